@@ -0,0 +1,836 @@
+// Package app assembles CentralAuth from its configuration: the client
+// registry, the providers, the stores and the server that serves them. The
+// centralauth binary runs what it builds, and pkg/centralauthserver hands it
+// to programs that embed CentralAuth.
+package app
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/BlackMission/centralauth/internal/audit"
+	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/ban"
+	"github.com/BlackMission/centralauth/internal/captcha"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/config"
+	"github.com/BlackMission/centralauth/internal/device"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/events"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/geoip"
+	"github.com/BlackMission/centralauth/internal/handler"
+	"github.com/BlackMission/centralauth/internal/hook"
+	"github.com/BlackMission/centralauth/internal/idempotency"
+	"github.com/BlackMission/centralauth/internal/identity"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/internal/lockout"
+	"github.com/BlackMission/centralauth/internal/maintenance"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/internal/mfa"
+	"github.com/BlackMission/centralauth/internal/outbound"
+	"github.com/BlackMission/centralauth/internal/pages"
+	"github.com/BlackMission/centralauth/internal/providers/discord"
+	"github.com/BlackMission/centralauth/internal/providers/facebook"
+	"github.com/BlackMission/centralauth/internal/providers/generic"
+	"github.com/BlackMission/centralauth/internal/providers/gitlab"
+	"github.com/BlackMission/centralauth/internal/providers/guest"
+	"github.com/BlackMission/centralauth/internal/providers/ldap"
+	"github.com/BlackMission/centralauth/internal/providers/local"
+	"github.com/BlackMission/centralauth/internal/providers/minecraft"
+	"github.com/BlackMission/centralauth/internal/providers/oidc"
+	"github.com/BlackMission/centralauth/internal/providers/phone"
+	"github.com/BlackMission/centralauth/internal/providers/reddit"
+	"github.com/BlackMission/centralauth/internal/providers/roblox"
+	"github.com/BlackMission/centralauth/internal/providers/steam"
+	"github.com/BlackMission/centralauth/internal/providers/twitter"
+	"github.com/BlackMission/centralauth/internal/ratelimit"
+	"github.com/BlackMission/centralauth/internal/refresh"
+	"github.com/BlackMission/centralauth/internal/roles"
+	"github.com/BlackMission/centralauth/internal/server"
+	"github.com/BlackMission/centralauth/internal/session"
+	"github.com/BlackMission/centralauth/internal/sqldb"
+	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/store"
+	"github.com/BlackMission/centralauth/internal/ticket"
+	"github.com/BlackMission/centralauth/internal/tracing"
+)
+
+// App is CentralAuth built from a configuration, ready to serve.
+type App struct {
+	Server *server.Server
+	Deps   server.Deps
+
+	clients   *client.Registry
+	providers *auth.Registry
+	store     client.Store
+	watcher   *client.Watcher
+	stopWatch context.CancelFunc
+}
+
+// New builds CentralAuth from cfg, and starts watching the clients file or
+// database if there is one. hooks run on each sign-in after the post-auth
+// hooks cfg configures. Close releases what New opened.
+func New(cfg *config.Config, hooks ...hook.Hook) (_ *App, err error) {
+	a := &App{}
+	defer func() {
+		if err != nil {
+			a.Close(context.Background())
+		}
+	}()
+
+	clientApps := ClientApps(cfg)
+	clients, err := client.NewRegistry(clientApps)
+	if err != nil {
+		return nil, fmt.Errorf("creating client registry: %w", err)
+	}
+	a.clients = clients
+	clients.SetRetention(cfg.ClientRetention)
+	clients.SetRateLimit(cfg.ClientRateLimit)
+
+	// Watch the clients file or database, if any, so edits apply without a restart
+	ctx, stopWatch := context.WithCancel(context.Background())
+	a.stopWatch = stopWatch
+	store, err := ClientStore(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("opening clients store: %w", err)
+	}
+	a.store = store
+	if store != nil {
+		static, err := seedClients(ctx, cfg, store, clientApps)
+		if err != nil {
+			return nil, fmt.Errorf("seeding clients: %w", err)
+		}
+		a.watcher = client.NewWatcher(clients, store, cfg.ClientsReloadInterval, static)
+		if _, err := a.watcher.Reload(ctx); err != nil {
+			return nil, fmt.Errorf("loading clients: %w", err)
+		}
+		go a.watcher.Run(ctx)
+		slog.Info("watching clients", "store", store.String())
+	}
+
+	// Build state service
+	stateOpts := []state.Option{
+		state.WithExpiry(cfg.Tokens.StateTTL),
+		state.WithClientExpiry(clients.StateTTL),
+	}
+	if cfg.Secrets.PreviousStateSigningKey != "" {
+		stateOpts = append(stateOpts, state.WithPreviousKeys([]byte(cfg.Secrets.PreviousStateSigningKey)))
+	}
+	stateSvc := state.NewService([]byte(cfg.Secrets.StateSigningKey), stateOpts...)
+	stateSvc.SetRegion(cfg.Server.Region)
+
+	// Build exchange codec
+	encKey, err := cfg.Secrets.ExchangeKey()
+	if err != nil {
+		return nil, fmt.Errorf("invalid exchange encryption key: %w", err)
+	}
+	prevEncKey, err := cfg.Secrets.PreviousExchangeKey()
+	if err != nil {
+		return nil, fmt.Errorf("invalid previous exchange encryption key: %w", err)
+	}
+	codecOpts := []exchange.Option{
+		exchange.WithExpiry(cfg.Tokens.ExchangeCodeTTL),
+		exchange.WithClientExpiry(clients.ExchangeCodeTTL),
+	}
+	if len(prevEncKey) > 0 {
+		codecOpts = append(codecOpts, exchange.WithPreviousKeys(prevEncKey))
+	}
+	codec, err := exchange.NewCodec(encKey, codecOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating exchange codec: %w", err)
+	}
+	codec.SetRegion(cfg.Server.Region)
+	if cfg.Secrets.PerClientExchangeKeys {
+		codec.EnablePerClientKeys(clients)
+	}
+
+	if cfg.Server.PagesDir != "" {
+		if err := pages.Override(cfg.Server.PagesDir); err != nil {
+			return nil, fmt.Errorf("loading pages: %w", err)
+		}
+		slog.Info("hosted pages overridden", "dir", cfg.Server.PagesDir)
+	}
+
+	// Build CAPTCHA verifier for hosted pages
+	var captchaVerifier *captcha.Verifier
+	var captchaPasses *ticket.Signer
+	if cfg.Captcha.Provider != "" {
+		captchaVerifier, err = captcha.New(cfg.Captcha.Provider, cfg.Captcha.SiteKey, cfg.Captcha.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("creating captcha verifier: %w", err)
+		}
+		// Passes from the provider chooser get their own key, derived from the state key
+		passKey, err := hkdf.Key(sha256.New, []byte(cfg.Secrets.StateSigningKey), nil, "centralauth captcha pass", 32)
+		if err != nil {
+			return nil, fmt.Errorf("deriving captcha pass key: %w", err)
+		}
+		captchaPasses = ticket.NewSigner(passKey, 0)
+		slog.Info("CAPTCHA enabled", "provider", cfg.Captcha.Provider)
+	}
+
+	// Build provider registry
+	providers := auth.NewRegistry()
+	a.providers = providers
+
+	// Provider callbacks and hosted pages live under BASE_PATH
+	publicURL := cfg.Server.PublicURL()
+
+	// Each provider calls its API with its own timeout, retry and proxy policy
+	httpClients := make(map[string]*http.Client, len(cfg.Providers))
+	for name, pc := range cfg.Providers {
+		c, err := outbound.NewClient(outbound.Policy{
+			Timeout: pc.HTTPTimeout,
+			Retries: pc.HTTPRetries,
+			Proxy:   pc.HTTPProxy,
+			NoProxy: pc.NoProxy,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating HTTP client for provider %s: %w", name, err)
+		}
+		httpClients[name] = c
+	}
+
+	if dc, ok := cfg.Providers["discord"]; ok {
+		callbackURL := publicURL + "/callback/discord"
+		p := discord.New(discord.Config{
+			ClientID:     dc.ClientID,
+			ClientSecret: dc.ClientSecret,
+			Scopes:       dc.Scopes,
+			CallbackURL:  callbackURL,
+			GuildID:      dc.GuildID,
+			GuildFilter:  dc.GuildFilter,
+			BotToken:     dc.BotToken,
+			HTTPClient:   httpClients["discord"],
+		})
+		if err := providers.Register(p); err != nil {
+			return nil, fmt.Errorf("registering provider discord: %w", err)
+		}
+		slog.Info("registered provider", "provider", "discord")
+	}
+
+	if sc, ok := cfg.Providers["steam"]; ok {
+		callbackURL := publicURL + "/callback/steam"
+		p := steam.New(steam.Config{
+			APIKey:      sc.APIKey,
+			Realm:       sc.Realm,
+			CallbackURL: callbackURL,
+			Extras:      sc.Extras,
+			FetchBans:   sc.FetchBans,
+			AppID:       sc.AppID,
+			HTTPClient:  httpClients["steam"],
+		})
+		if err := providers.Register(p); err != nil {
+			return nil, fmt.Errorf("registering provider steam: %w", err)
+		}
+		slog.Info("registered provider", "provider", "steam")
+	}
+
+	if gc, ok := cfg.Providers["gitlab"]; ok {
+		p := gitlab.New(gitlab.Config{
+			ClientID:     gc.ClientID,
+			ClientSecret: gc.ClientSecret,
+			Scopes:       gc.Scopes,
+			CallbackURL:  publicURL + "/callback/gitlab",
+			BaseURL:      gc.BaseURL,
+			HTTPClient:   httpClients["gitlab"],
+		})
+		if err := providers.Register(p); err != nil {
+			return nil, fmt.Errorf("registering provider gitlab: %w", err)
+		}
+		slog.Info("registered provider", "provider", "gitlab", "base_url", gc.BaseURL)
+	}
+
+	if rc, ok := cfg.Providers["reddit"]; ok {
+		p := reddit.New(reddit.Config{
+			ClientID:     rc.ClientID,
+			ClientSecret: rc.ClientSecret,
+			Scopes:       rc.Scopes,
+			CallbackURL:  publicURL + "/callback/reddit",
+			UserAgent:    rc.UserAgent,
+			HTTPClient:   httpClients["reddit"],
+		})
+		if err := providers.Register(p); err != nil {
+			return nil, fmt.Errorf("registering provider reddit: %w", err)
+		}
+		slog.Info("registered provider", "provider", "reddit")
+	}
+
+	if fc, ok := cfg.Providers["facebook"]; ok {
+		p := facebook.New(facebook.Config{
+			AppID:       fc.ClientID,
+			AppSecret:   fc.ClientSecret,
+			Scopes:      fc.Scopes,
+			CallbackURL: publicURL + "/callback/facebook",
+			HTTPClient:  httpClients["facebook"],
+		})
+		if err := providers.Register(p); err != nil {
+			return nil, fmt.Errorf("registering provider facebook: %w", err)
+		}
+		slog.Info("registered provider", "provider", "facebook")
+	}
+
+	if tc, ok := cfg.Providers["twitter"]; ok {
+		p := twitter.New(twitter.Config{
+			ClientID:     tc.ClientID,
+			ClientSecret: tc.ClientSecret,
+			Scopes:       tc.Scopes,
+			CallbackURL:  publicURL + "/callback/twitter",
+			HTTPClient:   httpClients["twitter"],
+		})
+		if err := providers.Register(p); err != nil {
+			return nil, fmt.Errorf("registering provider twitter: %w", err)
+		}
+		slog.Info("registered provider", "provider", "twitter")
+	}
+
+	if rc, ok := cfg.Providers["roblox"]; ok {
+		p := roblox.New(roblox.Config{
+			ClientID:     rc.ClientID,
+			ClientSecret: rc.ClientSecret,
+			Scopes:       rc.Scopes,
+			CallbackURL:  publicURL + "/callback/roblox",
+			HTTPClient:   httpClients["roblox"],
+		})
+		if err := providers.Register(p); err != nil {
+			return nil, fmt.Errorf("registering provider roblox: %w", err)
+		}
+		slog.Info("registered provider", "provider", "roblox")
+	}
+
+	if mc, ok := cfg.Providers["minecraft"]; ok {
+		p := minecraft.New(minecraft.Config{
+			ClientID:     mc.ClientID,
+			ClientSecret: mc.ClientSecret,
+			CallbackURL:  publicURL + "/callback/minecraft",
+			HTTPClient:   httpClients["minecraft"],
+		})
+		if err := providers.Register(p); err != nil {
+			return nil, fmt.Errorf("registering provider minecraft: %w", err)
+		}
+		slog.Info("registered provider", "provider", "minecraft")
+	}
+
+	if oc, ok := cfg.Providers["oidc"]; ok {
+		p := oidc.New(oidc.Config{
+			IssuerURL:    oc.IssuerURL,
+			ClientID:     oc.ClientID,
+			ClientSecret: oc.ClientSecret,
+			Scopes:       oc.Scopes,
+			CallbackURL:  publicURL + "/callback/oidc",
+			HTTPClient:   httpClients["oidc"],
+		})
+		if err := providers.Register(p); err != nil {
+			return nil, fmt.Errorf("registering provider oidc: %w", err)
+		}
+		slog.Info("registered provider", "provider", "oidc", "issuer", oc.IssuerURL)
+	}
+
+	for name, pc := range cfg.Providers {
+		if pc.OAuth2 == nil {
+			continue
+		}
+		p, err := generic.New(generic.Config{
+			Name:         name,
+			ClientID:     pc.ClientID,
+			ClientSecret: pc.ClientSecret,
+			Scopes:       pc.Scopes,
+			AuthURL:      pc.OAuth2.AuthURL,
+			TokenURL:     pc.OAuth2.TokenURL,
+			UserURL:      pc.OAuth2.UserURL,
+			CallbackURL:  publicURL + "/callback/" + name,
+			TokenAuth:    pc.OAuth2.TokenAuth,
+			Mapping:      pc.OAuth2.Mapping,
+			HTTPClient:   httpClients[name],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating provider %s: %w", name, err)
+		}
+		if err := providers.Register(p); err != nil {
+			return nil, fmt.Errorf("registering provider %s: %w", name, err)
+		}
+		slog.Info("registered provider", "provider", name, "type", "generic")
+	}
+
+	if lc, ok := cfg.Providers["local"]; ok {
+		store, err := local.OpenFileStore(lc.AccountsFile)
+		if err != nil {
+			return nil, fmt.Errorf("opening local accounts: %w", err)
+		}
+		// Tickets get their own key, derived from the state key
+		ticketKey, err := hkdf.Key(sha256.New, []byte(cfg.Secrets.StateSigningKey), nil, "centralauth local ticket", 32)
+		if err != nil {
+			return nil, fmt.Errorf("deriving ticket key: %w", err)
+		}
+		p := local.New(local.Config{
+			BaseURL:           publicURL,
+			CallbackURL:       publicURL + "/callback/local",
+			AllowRegistration: lc.AllowRegistration,
+			MinPasswordLength: lc.MinPasswordLength,
+		}, store, stateSvc, ticket.NewSigner(ticketKey, 0))
+		p.SetCaptcha(captchaVerifier, clients.RequiresCaptcha)
+		if err := providers.Register(p); err != nil {
+			return nil, fmt.Errorf("registering provider local: %w", err)
+		}
+		slog.Info("registered provider", "provider", "local")
+	}
+
+	if lc, ok := cfg.Providers["ldap"]; ok {
+		ticketKey, err := hkdf.Key(sha256.New, []byte(cfg.Secrets.StateSigningKey), nil, "centralauth ldap ticket", 32)
+		if err != nil {
+			return nil, fmt.Errorf("deriving ticket key: %w", err)
+		}
+		p, err := ldap.New(ldap.Config{
+			BaseURL:      publicURL,
+			CallbackURL:  publicURL + "/callback/ldap",
+			URL:          lc.LDAP.URL,
+			StartTLS:     lc.LDAP.StartTLS,
+			CAFile:       lc.LDAP.CAFile,
+			BindDN:       lc.LDAP.BindDN,
+			BindPassword: lc.LDAP.BindPassword,
+			BaseDN:       lc.LDAP.BaseDN,
+			UserAttr:     lc.LDAP.UserAttr,
+			IDAttr:       lc.LDAP.IDAttr,
+		}, stateSvc, ticket.NewSigner(ticketKey, 0))
+		if err != nil {
+			return nil, fmt.Errorf("creating provider ldap: %w", err)
+		}
+		p.SetCaptcha(captchaVerifier, clients.RequiresCaptcha)
+		if err := providers.Register(p); err != nil {
+			return nil, fmt.Errorf("registering provider ldap: %w", err)
+		}
+		slog.Info("registered provider", "provider", "ldap")
+	}
+
+	if pc, ok := cfg.Providers["phone"]; ok {
+		var gateway phone.Gateway
+		if pc.SMS.Gateway == "twilio" {
+			gateway = phone.NewTwilio(pc.SMS.TwilioAccountSID, pc.SMS.TwilioAuthToken, pc.SMS.TwilioFrom)
+		} else {
+			gateway = phone.NewWebhook(pc.SMS.WebhookURL, pc.SMS.WebhookToken)
+		}
+		ticketKey, err := hkdf.Key(sha256.New, []byte(cfg.Secrets.StateSigningKey), nil, "centralauth phone ticket", 32)
+		if err != nil {
+			return nil, fmt.Errorf("deriving ticket key: %w", err)
+		}
+		codeKey, err := hkdf.Key(sha256.New, []byte(cfg.Secrets.StateSigningKey), nil, "centralauth phone code", 32)
+		if err != nil {
+			return nil, fmt.Errorf("deriving phone code key: %w", err)
+		}
+		p := phone.New(phone.Config{
+			BaseURL:     publicURL,
+			CallbackURL: publicURL + "/callback/phone",
+			CodeTTL:     pc.CodeTTL,
+			AppName:     pc.AppName,
+		}, gateway, stateSvc, ticket.NewSigner(ticketKey, 0), codeKey)
+		p.SetCaptcha(captchaVerifier, clients.RequiresCaptcha)
+		if err := providers.Register(p); err != nil {
+			return nil, fmt.Errorf("registering provider phone: %w", err)
+		}
+		slog.Info("registered provider", "provider", "phone", "gateway", pc.SMS.Gateway)
+	}
+
+	if pc, ok := cfg.Providers["guest"]; ok {
+		p := guest.New(guest.Config{
+			CallbackURL: publicURL + "/callback/guest",
+			Lifetime:    pc.Lifetime,
+		}, stateSvc)
+		p.SetLifetimes(clients.GuestLifetime)
+		if err := providers.Register(p); err != nil {
+			return nil, fmt.Errorf("registering provider guest: %w", err)
+		}
+		slog.Info("registered provider", "provider", "guest")
+	}
+
+	// Build metrics backend
+	var metricsBackend metrics.Backend
+	promRegistry := metrics.NewRegistry()
+	if cfg.Metrics.Enabled && cfg.Metrics.Backend == "statsd" {
+		statsd, err := metrics.NewStatsD(cfg.Metrics.StatsDAddr, cfg.Metrics.StatsDPrefix, cfg.Metrics.StatsDTags)
+		if err != nil {
+			return nil, fmt.Errorf("creating statsd exporter: %w", err)
+		}
+		metricsBackend = statsd
+	} else {
+		metricsBackend = promRegistry
+	}
+
+	// Bound concurrent provider exchanges
+	limits := make(map[string]auth.Limit, len(cfg.Providers))
+	for name, pc := range cfg.Providers {
+		limits[name] = auth.Limit{MaxConcurrent: pc.MaxConcurrency, MaxWait: pc.MaxWait}
+	}
+	limiter := auth.NewLimiter(limits, metricsBackend)
+
+	// Keep shared state in memory, or in Redis to share it between replicas
+	sharedStore, err := sharedStore(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating store: %w", err)
+	}
+	slog.Info("shared state store", "store", sharedStore.String())
+	stateSvc.SetEpochStore(sharedStore)
+	if cfg.Tokens.SingleUseState {
+		stateSvc.SetConsumedStore(sharedStore)
+	}
+	rateLimitStore, err := rateLimitStore(cfg, sharedStore)
+	if err != nil {
+		return nil, fmt.Errorf("creating rate limit store: %w", err)
+	}
+	slog.Info("rate limit store", "store", rateLimitStore.String())
+
+	a.Deps = server.Deps{
+		Clients:   clients,
+		Providers: providers,
+		State:     stateSvc,
+		Exchange:  codec,
+
+		Idempotency: idempotency.NewCache(sharedStore, 0),
+		Redeemed:    sharedStore,
+		Refresh:     refresh.New(sharedStore),
+		Limiter:     limiter,
+		RateLimiter: ratelimit.New(rateLimitStore),
+		Funnel:      metrics.NewFunnel(metricsBackend),
+		Maintenance: maintenance.NewMode(),
+		Audit:       audit.NewLog(0),
+		Captcha:     captchaVerifier,
+
+		CaptchaPasses: captchaPasses,
+	}
+	deps := &a.Deps
+	if cfg.Metrics.Enabled && cfg.Metrics.Backend == "prometheus" {
+		deps.Metrics = promRegistry
+	}
+	if !cfg.RateLimit.LockoutDisabled {
+		deps.Lockouts = lockout.New(deps.RateLimiter, sharedStore, lockout.Policy{
+			Failures:   cfg.RateLimit.LockoutAfter,
+			Lockout:    cfg.RateLimit.LockoutDuration,
+			MaxLockout: cfg.RateLimit.LockoutMaxDuration,
+		})
+	}
+	if cfg.Tracing.Enabled {
+		deps.Tracer = tracing.New(tracing.Config{
+			Endpoint:    cfg.Tracing.Endpoint,
+			Headers:     cfg.Tracing.Headers,
+			ServiceName: cfg.Tracing.ServiceName,
+			SampleRatio: cfg.Tracing.SampleRatio,
+		})
+		slog.Info("tracing enabled", "endpoint", cfg.Tracing.Endpoint, "sample_ratio", cfg.Tracing.SampleRatio)
+	}
+	if cfg.Events.Backend != "" {
+		var pub events.Publisher
+		switch cfg.Events.Backend {
+		case "nats":
+			pub, err = events.NewNATS(cfg.Events.NATSURL)
+		case "kafka":
+			pub, err = events.NewKafka(cfg.Events.KafkaBrokers, cfg.Events.KafkaTLS)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("configuring events: %w", err)
+		}
+		deps.Events = events.NewBus(pub, cfg.Events.Prefix)
+		slog.Info("publishing events", "publisher", pub.String(), "prefix", cfg.Events.Prefix)
+	}
+	if cfg.GeoIP.CountryDB != "" || cfg.GeoIP.ASNDB != "" {
+		deps.GeoIP, err = geoip.Open(cfg.GeoIP.CountryDB, cfg.GeoIP.ASNDB)
+		if err != nil {
+			return nil, fmt.Errorf("opening GeoIP databases: %w", err)
+		}
+		deps.Audit.SetGeoIP(deps.GeoIP)
+		slog.Info("GeoIP enabled", "country_db", cfg.GeoIP.CountryDB, "asn_db", cfg.GeoIP.ASNDB, "blocked_countries", cfg.GeoIP.BlockedCountries)
+	}
+	if cfg.IdentityDB.Driver != "" {
+		db, err := sqldb.Open(cfg.IdentityDB.Driver, cfg.IdentityDB.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("opening identity database: %w", err)
+		}
+		if deps.Identities, err = identity.NewStore(ctx, db); err != nil {
+			return nil, fmt.Errorf("opening identity database: %w", err)
+		}
+		deps.Identities.SetLoginRetention(cfg.LoginRetention)
+		slog.Info("central user IDs enabled", "database", deps.Identities.String())
+		if deps.Bans, err = ban.NewList(ctx, db, deps.Identities); err != nil {
+			return nil, fmt.Errorf("opening identity database: %w", err)
+		}
+	}
+	if cfg.Hooks.WebhookURL != "" {
+		deps.Hooks = append(deps.Hooks, hook.NewWebhook(cfg.Hooks.WebhookURL, cfg.Hooks.WebhookToken, cfg.Hooks.Timeout))
+		slog.Info("post-auth webhook enabled", "url", cfg.Hooks.WebhookURL)
+	}
+	if len(cfg.Hooks.Script) > 0 {
+		deps.Hooks = append(deps.Hooks, hook.NewScript(cfg.Hooks.Script, cfg.Hooks.Timeout))
+		slog.Info("post-auth script enabled", "command", cfg.Hooks.Script[0])
+	}
+	if cfg.RoleRulesFile != "" {
+		if deps.Roles, err = roles.LoadFile(cfg.RoleRulesFile); err != nil {
+			return nil, fmt.Errorf("loading role rules: %w", err)
+		}
+		slog.Info("role rules loaded", "rules", len(deps.Roles))
+	}
+	deps.Hooks = append(deps.Hooks, hooks...)
+	if cfg.Tokens.DeviceFlow {
+		deps.Devices = device.New(sharedStore, cfg.Tokens.DeviceCodeTTL, 0)
+	}
+	if cfg.Tokens.SSO {
+		deps.Sessions = session.New(sharedStore, cfg.Tokens.SessionTTL, publicURL)
+	}
+
+	// Optional signed identity tokens from /exchange
+	if key := cfg.Secrets.IDTokenSigningKey; key != "" {
+		signer, err := idtoken.NewSigner([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("loading ID token signing key: %w", err)
+		}
+		var published []*idtoken.Signer
+		for _, key := range []string{cfg.Secrets.PreviousIDTokenSigningKey, cfg.Secrets.NextIDTokenSigningKey} {
+			if key == "" {
+				continue
+			}
+			s, err := idtoken.NewSigner([]byte(key))
+			if err != nil {
+				return nil, fmt.Errorf("loading ID token signing key: %w", err)
+			}
+			published = append(published, s)
+		}
+		deps.IDTokens = idtoken.NewIssuer(signer, cfg.Tokens.IDTokenIssuer, cfg.Tokens.IDTokenTTL, published...)
+		slog.Info("identity tokens enabled", "signer", deps.IDTokens.String())
+	}
+
+	// Optional TOTP second factor
+	if cfg.MFA.Enabled {
+		store, err := mfa.OpenFileStore(cfg.MFA.SecretsFile)
+		if err != nil {
+			return nil, fmt.Errorf("opening MFA secrets: %w", err)
+		}
+		mfaKey, err := hkdf.Key(sha256.New, encKey, nil, "centralauth mfa", 32)
+		if err != nil {
+			return nil, fmt.Errorf("deriving MFA key: %w", err)
+		}
+		if deps.MFA, err = mfa.NewService(mfaKey, store, cfg.MFA.Issuer); err != nil {
+			return nil, fmt.Errorf("creating MFA service: %w", err)
+		}
+		if len(prevEncKey) > 0 {
+			prevMFAKey, err := hkdf.Key(sha256.New, prevEncKey, nil, "centralauth mfa", 32)
+			if err != nil {
+				return nil, fmt.Errorf("deriving previous MFA key: %w", err)
+			}
+			if err := deps.MFA.SetPreviousKeys(prevMFAKey); err != nil {
+				return nil, fmt.Errorf("setting previous MFA key: %w", err)
+			}
+		}
+		slog.Info("second factor enabled", "method", "totp")
+	}
+
+	// Serve HTTPS directly when configured to
+	var autocert *server.AutocertConfig
+	if len(cfg.TLS.AutocertDomains) > 0 {
+		autocert = &server.AutocertConfig{
+			Domains:      cfg.TLS.AutocertDomains,
+			Email:        cfg.TLS.AutocertEmail,
+			CacheDir:     cfg.TLS.AutocertCacheDir,
+			DirectoryURL: cfg.TLS.AutocertDirectoryURL,
+		}
+	}
+
+	a.Server = server.New(server.Config{
+		Host:   cfg.Server.Host,
+		Port:   cfg.Server.Port,
+		Region: cfg.Server.Region,
+
+		BasePath: cfg.Server.BasePath,
+
+		IPRateLimits: handler.IPLimits{
+			PerIP:  cfg.RateLimit.PerIP,
+			Global: cfg.RateLimit.Global,
+		},
+		TrustedProxies:   cfg.RateLimit.TrustedProxies,
+		BlockedCountries: cfg.GeoIP.BlockedCountries,
+
+		CORS:        cfg.Server.CORS,
+		CORSOrigins: cfg.Server.CORSOrigins,
+
+		OIDC:      cfg.Tokens.OIDC,
+		PublicURL: cfg.Server.PublicURL(),
+
+		AdminAPIKey:           cfg.Admin.APIKey,
+		AdminCertFingerprints: cfg.Admin.CertFingerprints,
+		AdminHost:             cfg.Admin.Host,
+		AdminPort:             cfg.Admin.Port,
+		Debug:                 cfg.Admin.Debug,
+
+		ShutdownDelay:  cfg.Server.ShutdownDelay,
+		DrainTimeout:   cfg.Server.DrainTimeout,
+		MaxQueryBytes:  cfg.Server.MaxQueryBytes,
+		MaxHeaderBytes: cfg.Server.MaxHeaderBytes,
+
+		TLSCertFile: cfg.TLS.CertFile,
+		TLSKeyFile:  cfg.TLS.KeyFile,
+		Autocert:    autocert,
+		ClientCerts: cfg.TLS.ClientCerts,
+	}, *deps)
+	return a, nil
+}
+
+// Reload applies what can change while running from cfg, a freshly loaded
+// configuration: the clients, their rate limits and the scopes each provider
+// requests. Anything else, including new providers, needs a restart. Flows
+// in flight carry their state in signed tokens, so they finish undisturbed.
+func (a *App) Reload(ctx context.Context, cfg *config.Config) error {
+	clientApps := ClientApps(cfg)
+	var err error
+	if a.watcher != nil {
+		var static []domain.ClientApp
+		if static, err = seedClients(ctx, cfg, a.store, clientApps); err == nil {
+			err = a.watcher.SetStatic(ctx, static)
+		}
+	} else {
+		err = a.clients.Replace(clientApps)
+	}
+	if err != nil {
+		return fmt.Errorf("clients not updated: %w", err)
+	}
+	a.clients.SetRateLimit(cfg.ClientRateLimit)
+
+	for name, pc := range cfg.Providers {
+		p, err := a.providers.Get(name)
+		if err != nil {
+			slog.Warn("reload: provider needs a restart to be enabled", "provider", name)
+			continue
+		}
+		if sp, ok := p.(auth.ScopeProvider); ok {
+			sp.SetScopes(pc.Scopes)
+		}
+	}
+	slog.Info("reload: configuration applied", "clients", len(clientApps))
+	return nil
+}
+
+// Close stops watching the clients, flushes the spans and events still
+// buffered, and closes the databases. Call it once the server has stopped
+// serving requests.
+func (a *App) Close(ctx context.Context) error {
+	if a.stopWatch != nil {
+		a.stopWatch()
+	}
+	var errs []error
+	if a.Deps.Tracer != nil {
+		if err := a.Deps.Tracer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("tracing: spans not exported: %w", err))
+		}
+	}
+	if err := a.Deps.Events.Close(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("events: events not published: %w", err))
+	}
+	if a.Deps.Identities != nil {
+		if err := a.Deps.Identities.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing identity database: %w", err))
+		}
+	}
+	if s, ok := a.store.(*client.SQLStore); ok {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing clients database: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ClientApps returns the clients configured in cfg.
+func ClientApps(cfg *config.Config) []domain.ClientApp {
+	clientApps := make([]domain.ClientApp, len(cfg.Clients))
+	for i, c := range cfg.Clients {
+		clientApps[i] = domain.ClientApp{
+			ID:                    c.ID,
+			Name:                  c.Name,
+			APIKey:                c.APIKey,
+			SecondaryAPIKey:       c.SecondaryAPIKey,
+			AllowedCallbacks:      c.AllowedCallbacks,
+			AllowedProviders:      c.AllowedProviders,
+			KeyVersion:            c.KeyVersion,
+			RequireCaptcha:        c.RequireCaptcha,
+			AllowLookup:           c.AllowLookup,
+			IncludeRaw:            c.IncludeRaw,
+			Public:                c.Public,
+			AllowTokenPassthrough: c.AllowTokenPassthrough,
+			AllowServiceTokens:    c.AllowServiceTokens,
+			StripFields:           c.StripFields,
+			GuestLifetime:         c.GuestLifetime,
+			StateTTL:              c.StateTTL,
+			ExchangeCodeTTL:       c.ExchangeCodeTTL,
+			RefreshTokenTTL:       c.RefreshTokenTTL,
+			BackchannelLogoutURI:  c.BackchannelLogoutURI,
+			RateLimit:             c.RateLimit,
+			AllowedIPs:            c.AllowedIPs,
+			CertFingerprints:      c.CertFingerprints,
+			BlockedCountries:      c.BlockedCountries,
+			Disabled:              c.Disabled,
+		}
+	}
+	return clientApps
+}
+
+// ClientStore opens the store named in cfg that clients are loaded from at
+// runtime, or returns nil if clients only come from the configuration.
+func ClientStore(ctx context.Context, cfg *config.Config) (client.Store, error) {
+	switch {
+	case cfg.ClientsFile != "":
+		return client.FileStore{Path: cfg.ClientsFile}, nil
+	case cfg.ClientsDB.Driver != "":
+		db, err := sqldb.Open(cfg.ClientsDB.Driver, cfg.ClientsDB.DSN)
+		if err != nil {
+			return nil, err
+		}
+		s, err := client.NewSQLStore(ctx, db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, nil
+}
+
+// sharedStore returns the store configured by STORE.
+func sharedStore(cfg *config.Config) (store.Store, error) {
+	if cfg.Store.Backend == "redis" {
+		return store.NewRedis(cfg.Store.RedisURL)
+	}
+	return store.NewMemory(), nil
+}
+
+// rateLimitStore returns the store configured by RATE_LIMIT_STORE, sharing
+// shared's connections when both are in the same Redis.
+func rateLimitStore(cfg *config.Config, shared store.Store) (ratelimit.Store, error) {
+	if cfg.RateLimit.Store != "redis" {
+		return ratelimit.NewMemory(), nil
+	}
+	if conn, ok := shared.(*store.Redis); ok && cfg.RateLimit.RedisURL == cfg.Store.RedisURL {
+		return ratelimit.NewRedis(conn), nil
+	}
+	conn, err := store.NewRedis(cfg.RateLimit.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+	return ratelimit.NewRedis(conn), nil
+}
+
+// seedClients inserts the configured clients into a database store when
+// CLIENTS_DB_SEED is set. It returns the clients to serve alongside the
+// store's: none once they've been seeded, since the database then holds them.
+func seedClients(ctx context.Context, cfg *config.Config, store client.Store, clientApps []domain.ClientApp) ([]domain.ClientApp, error) {
+	s, ok := store.(*client.SQLStore)
+	if !ok || !cfg.ClientsDBSeed {
+		return clientApps, nil
+	}
+	n, err := s.Seed(ctx, clientApps)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		slog.Info("seeded clients", "count", n, "store", s.String())
+	}
+	return nil, nil
+}
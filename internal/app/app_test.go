@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/config"
+)
+
+func loadConfig(t *testing.T) *config.Config {
+	t.Helper()
+	cfg, err := config.LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv error: %v", err)
+	}
+	return cfg
+}
+
+func TestNew(t *testing.T) {
+	t.Setenv("STATE_SIGNING_KEY", "test-signing-key-1234567890123456")
+	t.Setenv("EXCHANGE_ENCRYPTION_KEY", "test-encrypt-key-1234567890123456")
+	t.Setenv("GUEST_ENABLED", "true")
+	t.Setenv("CLIENT_WEBSITE_API_KEY", "test-api-key")
+	t.Setenv("CLIENT_WEBSITE_ALLOWED_PROVIDERS", "guest")
+
+	a, err := New(loadConfig(t))
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	defer a.Close(context.Background())
+
+	if _, err := a.Deps.Providers.Get("guest"); err != nil {
+		t.Errorf("guest provider not registered: %v", err)
+	}
+	if _, err := a.Deps.Clients.Get("website"); err != nil {
+		t.Errorf("website client not registered: %v", err)
+	}
+
+	t.Setenv("CLIENT_MOBILE_API_KEY", "mobile-api-key")
+	if err := a.Reload(context.Background(), loadConfig(t)); err != nil {
+		t.Fatalf("Reload error: %v", err)
+	}
+	if _, err := a.Deps.Clients.Get("mobile"); err != nil {
+		t.Errorf("mobile client not added by Reload: %v", err)
+	}
+}
+
+func TestNew_Error(t *testing.T) {
+	t.Setenv("STATE_SIGNING_KEY", "test-signing-key-1234567890123456")
+	t.Setenv("EXCHANGE_ENCRYPTION_KEY", "test-encrypt-key-1234567890123456")
+	t.Setenv("CLIENT_WEBSITE_API_KEY", "test-api-key")
+	path := filepath.Join(t.TempDir(), "roles.json")
+	if err := os.WriteFile(path, []byte(`[{"role": "role:everyone"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ROLE_RULES_FILE", path)
+
+	if _, err := New(loadConfig(t)); err == nil {
+		t.Error("expected an error for invalid role rules")
+	}
+}
@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"log/slog"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/geoip"
+)
+
+const defaultCapacity = 1000
+
+// Event is one recorded admin action.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"`
+	Remote  string    `json:"remote,omitempty"`
+	Country string    `json:"country,omitempty"` // where Remote is, with a GeoIP database
+	ASN     uint32    `json:"asn,omitempty"`
+	Action  string    `json:"action"`
+	Target  string    `json:"target,omitempty"`
+}
+
+// Log keeps the most recent admin actions in memory and writes each one to
+// the process log, which is the durable copy.
+type Log struct {
+	mu       sync.Mutex
+	events   []Event
+	capacity int
+	now      func() time.Time
+	geo      *geoip.DB
+}
+
+// NewLog creates an audit log retaining up to capacity events (1000 if zero).
+func NewLog(capacity int) *Log {
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	return &Log{capacity: capacity, now: time.Now}
+}
+
+// SetNow overrides the time function (for testing).
+func (l *Log) SetNow(fn func() time.Time) {
+	l.now = fn
+}
+
+// SetGeoIP makes the log record the country and ASN of each event's remote
+// address, looked up in db.
+func (l *Log) SetGeoIP(db *geoip.DB) {
+	l.geo = db
+}
+
+// Record appends an event. It is a no-op on a nil Log.
+func (l *Log) Record(actor, remote, action, target string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := Event{Time: l.now().UTC(), Actor: actor, Remote: remote, Action: action, Target: target}
+	loc := l.geo.Lookup(remoteAddr(remote))
+	e.Country, e.ASN = loc.Country, loc.ASN
+	if len(l.events) == l.capacity {
+		l.events = append(l.events[:0], l.events[1:]...)
+	}
+	l.events = append(l.events, e)
+	slog.Info("audit", "actor", actor, "remote", remote, "country", e.Country, "asn", e.ASN, "action", action, "target", target)
+}
+
+// remoteAddr parses remote, an address with or without a port.
+func remoteAddr(remote string) netip.Addr {
+	if ap, err := netip.ParseAddrPort(remote); err == nil {
+		return ap.Addr()
+	}
+	ip, _ := netip.ParseAddr(remote)
+	return ip
+}
+
+// Events returns a copy of the retained events, oldest first.
+func (l *Log) Events() []Event {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := make([]Event, len(l.events))
+	copy(out, l.events)
+	return out
+}
@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecord(t *testing.T) {
+	l := NewLog(0)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	l.SetNow(func() time.Time { return now })
+
+	l.Record("alice", "10.0.0.1", "client.delete", "website")
+
+	events := l.Events()
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	e := events[0]
+	if e.Actor != "alice" || e.Action != "client.delete" || e.Target != "website" || !e.Time.Equal(now) {
+		t.Errorf("unexpected event: %+v", e)
+	}
+}
+
+func TestRemoteAddr(t *testing.T) {
+	for remote, want := range map[string]string{
+		"10.0.0.1:52000":    "10.0.0.1",
+		"[2001:db8::1]:443": "2001:db8::1",
+		"2001:db8::1":       "2001:db8::1",
+		"":                  "invalid IP",
+		"admin.example.com": "invalid IP",
+	} {
+		if got := remoteAddr(remote).String(); got != want {
+			t.Errorf("remoteAddr(%q) = %s, want %s", remote, got, want)
+		}
+	}
+}
+
+func TestRecord_DropsOldestAtCapacity(t *testing.T) {
+	l := NewLog(2)
+	l.Record("a", "", "one", "")
+	l.Record("a", "", "two", "")
+	l.Record("a", "", "three", "")
+
+	events := l.Events()
+	if len(events) != 2 || events[0].Action != "two" || events[1].Action != "three" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
+
+func TestNilLog(t *testing.T) {
+	var l *Log
+	l.Record("a", "", "noop", "")
+	if l.Events() != nil {
+		t.Error("expected nil events from nil log")
+	}
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProbeTimeout = 5 * time.Second
+	defaultProbeEvery   = 15 * time.Second
+)
+
+// Provider health states.
+const (
+	HealthUp        = "up"
+	HealthDown      = "down"
+	HealthUnchecked = "unchecked" // the provider has nothing remote to probe
+)
+
+// ProviderHealth is the outcome of the latest probe of one provider.
+type ProviderHealth struct {
+	Status    string    `json:"status"`
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at,omitzero"`
+
+	// LastError is the most recent probe failure, kept after the provider
+	// recovers so flapping shows up.
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitzero"`
+}
+
+// HealthChecker probes registered providers on demand. Results are reused
+// for a short while, so a load balancer polling every few seconds doesn't
+// turn into traffic against every provider.
+type HealthChecker struct {
+	providers *Registry
+	timeout   time.Duration
+	every     time.Duration
+	now       func() time.Time
+
+	mu      sync.Mutex
+	results map[string]ProviderHealth
+	checked time.Time
+}
+
+// NewHealthChecker creates a checker for the providers in reg.
+func NewHealthChecker(reg *Registry) *HealthChecker {
+	return &HealthChecker{
+		providers: reg,
+		timeout:   defaultProbeTimeout,
+		every:     defaultProbeEvery,
+		now:       time.Now,
+		results:   make(map[string]ProviderHealth),
+	}
+}
+
+// Check probes every provider concurrently, unless the last round is recent
+// enough to reuse, and returns each provider's health by name.
+func (h *HealthChecker) Check(ctx context.Context) map[string]ProviderHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.checked.IsZero() || h.now().Sub(h.checked) >= h.every {
+		h.probeAll(ctx)
+		h.checked = h.now()
+	}
+	out := make(map[string]ProviderHealth, len(h.results))
+	for name, ph := range h.results {
+		out[name] = ph
+	}
+	return out
+}
+
+// Healthy reports whether no provider in results is down.
+func Healthy(results map[string]ProviderHealth) bool {
+	for _, ph := range results {
+		if ph.Status == HealthDown {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *HealthChecker) probeAll(ctx context.Context) {
+	type outcome struct {
+		name    string
+		err     error
+		latency time.Duration
+	}
+	var (
+		wg       sync.WaitGroup
+		outcomes = make(chan outcome, len(h.providers.providers))
+	)
+	for name, p := range h.providers.providers {
+		pp, ok := p.(ProbeProvider)
+		if !ok {
+			h.results[name] = ProviderHealth{Status: HealthUnchecked}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// A caller hanging up must not record every provider as down
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), h.timeout)
+			defer cancel()
+			start := time.Now()
+			err := pp.Probe(ctx)
+			outcomes <- outcome{name: name, err: err, latency: time.Since(start)}
+		}()
+	}
+	wg.Wait()
+	close(outcomes)
+
+	checkedAt := h.now()
+	for o := range outcomes {
+		ph := h.results[o.name]
+		ph.Status = HealthUp
+		ph.LatencyMS = o.latency.Milliseconds()
+		ph.CheckedAt = checkedAt
+		if o.err != nil {
+			ph.Status = HealthDown
+			ph.LastError = o.err.Error()
+			ph.LastErrorAt = checkedAt
+		}
+		h.results[o.name] = ph
+	}
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type probeProvider struct {
+	mockProvider
+	err   error
+	calls int
+}
+
+func (p *probeProvider) Probe(ctx context.Context) error {
+	p.calls++
+	return p.err
+}
+
+func TestHealthChecker_Check(t *testing.T) {
+	r := NewRegistry()
+	discord := &probeProvider{mockProvider: mockProvider{name: "discord"}}
+	steam := &probeProvider{mockProvider: mockProvider{name: "steam"}, err: errors.New("connection refused")}
+	r.Register(discord)
+	r.Register(steam)
+	r.Register(&mockProvider{name: "local"})
+
+	results := NewHealthChecker(r).Check(context.Background())
+	if results["discord"].Status != HealthUp || results["discord"].LastError != "" {
+		t.Errorf("unexpected discord health: %+v", results["discord"])
+	}
+	if s := results["steam"]; s.Status != HealthDown || s.LastError != "connection refused" || s.LastErrorAt.IsZero() {
+		t.Errorf("unexpected steam health: %+v", s)
+	}
+	if results["local"].Status != HealthUnchecked {
+		t.Errorf("expected local to be unchecked, got %+v", results["local"])
+	}
+	if Healthy(results) {
+		t.Error("expected a down provider to make the result unhealthy")
+	}
+}
+
+func TestHealthChecker_ReusesRecentResults(t *testing.T) {
+	r := NewRegistry()
+	steam := &probeProvider{mockProvider: mockProvider{name: "steam"}, err: errors.New("timeout")}
+	r.Register(steam)
+
+	now := time.Unix(1700000000, 0)
+	h := NewHealthChecker(r)
+	h.now = func() time.Time { return now }
+
+	h.Check(context.Background())
+	h.Check(context.Background())
+	if steam.calls != 1 {
+		t.Fatalf("expected one probe within the reuse window, got %d", steam.calls)
+	}
+
+	// The provider recovers; its last error stays visible
+	steam.err = nil
+	now = now.Add(defaultProbeEvery)
+	results := h.Check(context.Background())
+	if steam.calls != 2 {
+		t.Fatalf("expected a new probe after the window, got %d", steam.calls)
+	}
+	if s := results["steam"]; s.Status != HealthUp || s.LastError != "timeout" {
+		t.Errorf("expected steam up with its last error kept, got %+v", s)
+	}
+	if !Healthy(results) {
+		t.Error("expected the result to be healthy")
+	}
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/metrics"
+)
+
+const defaultMaxWait = 5 * time.Second
+
+// Limit bounds concurrent Exchange calls for one provider.
+type Limit struct {
+	MaxConcurrent int           // 0 means unlimited
+	MaxWait       time.Duration // how long a call may queue for a slot; defaults to 5s
+}
+
+// Limiter enforces per-provider concurrency limits around Exchange calls, so a
+// slow provider can only tie up its own share of server connections.
+type Limiter struct {
+	slots    map[string]chan struct{}
+	maxWait  map[string]time.Duration
+	inFlight metrics.Gauge
+	waiting  metrics.Gauge
+	rejected metrics.Counter
+}
+
+// NewLimiter creates a limiter for the given provider limits and registers its
+// saturation metrics. Providers without a limit are never throttled.
+func NewLimiter(limits map[string]Limit, reg metrics.Backend) *Limiter {
+	if reg == nil {
+		reg = metrics.NewRegistry()
+	}
+	l := &Limiter{
+		slots:   make(map[string]chan struct{}),
+		maxWait: make(map[string]time.Duration),
+		inFlight: reg.NewGauge("centralauth_provider_exchanges_in_flight",
+			"Provider exchanges currently running.", "provider"),
+		waiting: reg.NewGauge("centralauth_provider_exchanges_waiting",
+			"Provider exchanges queued for a concurrency slot.", "provider"),
+		rejected: reg.NewCounter("centralauth_provider_exchanges_rejected_total",
+			"Provider exchanges rejected after waiting too long for a slot.", "provider"),
+	}
+	capacity := reg.NewGauge("centralauth_provider_exchange_capacity",
+		"Maximum concurrent provider exchanges.", "provider")
+
+	for name, lim := range limits {
+		if lim.MaxConcurrent <= 0 {
+			continue
+		}
+		wait := lim.MaxWait
+		if wait <= 0 {
+			wait = defaultMaxWait
+		}
+		l.slots[name] = make(chan struct{}, lim.MaxConcurrent)
+		l.maxWait[name] = wait
+		capacity.Set(float64(lim.MaxConcurrent), name)
+	}
+	return l
+}
+
+// Acquire waits for an Exchange slot for the provider. The returned release
+// function must be called once the exchange is done. It fails with
+// domain.ErrProviderBusy when no slot frees up within the provider's max wait.
+func (l *Limiter) Acquire(ctx context.Context, provider string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	slots, ok := l.slots[provider]
+	if !ok {
+		l.inFlight.Inc(provider)
+		return func() { l.inFlight.Dec(provider) }, nil
+	}
+
+	release = func() {
+		<-slots
+		l.inFlight.Dec(provider)
+	}
+
+	// Fast path: a slot is free
+	select {
+	case slots <- struct{}{}:
+		l.inFlight.Inc(provider)
+		return release, nil
+	default:
+	}
+
+	l.waiting.Inc(provider)
+	defer l.waiting.Dec(provider)
+
+	timer := time.NewTimer(l.maxWait[provider])
+	defer timer.Stop()
+
+	select {
+	case slots <- struct{}{}:
+		l.inFlight.Inc(provider)
+		return release, nil
+	case <-timer.C:
+		l.rejected.Inc(provider)
+		return nil, domain.ErrProviderBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// RetryAfter suggests how many seconds a rejected caller should wait.
+func (l *Limiter) RetryAfter(provider string) string {
+	if l == nil {
+		return "1"
+	}
+	secs := int(l.maxWait[provider].Round(time.Second) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.Itoa(secs)
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestLimiter_Unlimited(t *testing.T) {
+	l := NewLimiter(nil, nil)
+	for i := 0; i < 10; i++ {
+		if _, err := l.Acquire(context.Background(), "discord"); err != nil {
+			t.Fatalf("Acquire error: %v", err)
+		}
+	}
+}
+
+func TestLimiter_NilIsNoop(t *testing.T) {
+	var l *Limiter
+	release, err := l.Acquire(context.Background(), "discord")
+	if err != nil {
+		t.Fatalf("Acquire error: %v", err)
+	}
+	release()
+}
+
+func TestLimiter_RejectsAfterMaxWait(t *testing.T) {
+	l := NewLimiter(map[string]Limit{
+		"steam": {MaxConcurrent: 1, MaxWait: 20 * time.Millisecond},
+	}, nil)
+
+	release, err := l.Acquire(context.Background(), "steam")
+	if err != nil {
+		t.Fatalf("first Acquire error: %v", err)
+	}
+
+	_, err = l.Acquire(context.Background(), "steam")
+	if !errors.Is(err, domain.ErrProviderBusy) {
+		t.Fatalf("expected ErrProviderBusy, got %v", err)
+	}
+
+	// Other providers are unaffected by steam's saturation
+	if _, err := l.Acquire(context.Background(), "discord"); err != nil {
+		t.Errorf("expected discord to be unaffected, got %v", err)
+	}
+
+	release()
+	release2, err := l.Acquire(context.Background(), "steam")
+	if err != nil {
+		t.Fatalf("Acquire after release error: %v", err)
+	}
+	release2()
+}
+
+func TestLimiter_QueuedCallerGetsFreedSlot(t *testing.T) {
+	l := NewLimiter(map[string]Limit{
+		"steam": {MaxConcurrent: 1, MaxWait: time.Second},
+	}, nil)
+
+	release, _ := l.Acquire(context.Background(), "steam")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+
+	release2, err := l.Acquire(context.Background(), "steam")
+	if err != nil {
+		t.Fatalf("expected queued Acquire to succeed, got %v", err)
+	}
+	release2()
+}
+
+func TestLimiter_ContextCancelled(t *testing.T) {
+	l := NewLimiter(map[string]Limit{
+		"steam": {MaxConcurrent: 1, MaxWait: time.Second},
+	}, nil)
+	l.Acquire(context.Background(), "steam")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Acquire(ctx, "steam"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestLimiter_Metrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	l := NewLimiter(map[string]Limit{
+		"steam": {MaxConcurrent: 1, MaxWait: time.Millisecond},
+	}, reg)
+
+	l.Acquire(context.Background(), "steam")
+	l.Acquire(context.Background(), "steam")
+
+	rr := testutil.DoRequest(t, reg.Handler(), http.MethodGet, "/metrics", nil).Body.String()
+	for _, want := range []string{
+		`centralauth_provider_exchange_capacity{provider="steam"} 1`,
+		`centralauth_provider_exchanges_in_flight{provider="steam"} 1`,
+		`centralauth_provider_exchanges_rejected_total{provider="steam"} 1`,
+	} {
+		if !strings.Contains(rr, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, rr)
+		}
+	}
+}
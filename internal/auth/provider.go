@@ -2,6 +2,8 @@ package auth
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 
 	"github.com/BlackMission/centralauth/internal/domain"
 )
@@ -12,3 +14,63 @@ type Provider interface {
 	AuthURL(stateToken string) (string, error)
 	Exchange(ctx context.Context, params map[string]string) (*domain.AuthResult, error)
 }
+
+// RouteProvider is implemented by providers that serve their own pages
+// (e.g. a first-party login form) in addition to the standard flow routes.
+type RouteProvider interface {
+	Provider
+	RegisterRoutes(mux *http.ServeMux)
+}
+
+// TicketProvider is implemented by providers that can authenticate a
+// session ticket from a game client directly, without a browser.
+// identity is the optional string the ticket was requested for.
+type TicketProvider interface {
+	Provider
+	AuthenticateTicket(ctx context.Context, ticket, identity string) (*domain.AuthResult, error)
+}
+
+// LookupProvider is implemented by providers that can resolve a user ID to
+// a profile with their own credentials, for trusted clients that already
+// know who the user is. It returns ErrLookupNotConfigured when the
+// credentials it needs are not set.
+type LookupProvider interface {
+	Provider
+	LookupUser(ctx context.Context, userID string) (*domain.AuthResult, error)
+}
+
+// ProbeProvider is implemented by providers that depend on remote endpoints.
+// Probe reports whether they can be reached right now.
+type ProbeProvider interface {
+	Provider
+	Probe(ctx context.Context) error
+}
+
+// ScopeProvider is implemented by providers whose requested scopes can be
+// changed while running. New scopes apply to flows started afterwards.
+type ScopeProvider interface {
+	Provider
+	SetScopes(scopes []string)
+}
+
+// CallbackError returns the error a provider reported on its callback in
+// place of a sign-in: ErrConsentDenied when the user turned it down, and
+// ErrProviderExchange for anything else. It returns nil for a callback that
+// carries no error, which is left to the provider's Exchange.
+func CallbackError(params map[string]string) error {
+	// OAuth 2.0 (RFC 6749 section 4.1.2.1)
+	if e := params["error"]; e != "" {
+		if e == "access_denied" {
+			return domain.ErrConsentDenied
+		}
+		return fmt.Errorf("%w: %s: %s", domain.ErrProviderExchange, e, params["error_description"])
+	}
+	// OpenID 2.0 (section 10.2), used by Steam
+	switch params["openid.mode"] {
+	case "cancel":
+		return domain.ErrConsentDenied
+	case "error":
+		return fmt.Errorf("%w: %s", domain.ErrProviderExchange, params["openid.error"])
+	}
+	return nil
+}
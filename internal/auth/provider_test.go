@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func TestCallbackError(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]string
+		want   error
+	}{
+		{"code", map[string]string{"code": "abc", "state": "xyz"}, nil},
+		{"oauth denied", map[string]string{"error": "access_denied", "state": "xyz"}, domain.ErrConsentDenied},
+		{"oauth error", map[string]string{"error": "invalid_scope", "error_description": "bad scope"}, domain.ErrProviderExchange},
+		{"openid assertion", map[string]string{"openid.mode": "id_res", "openid.claimed_id": "https://steamcommunity.com/openid/id/1"}, nil},
+		{"openid cancel", map[string]string{"openid.mode": "cancel"}, domain.ErrConsentDenied},
+		{"openid error", map[string]string{"openid.mode": "error", "openid.error": "bad realm"}, domain.ErrProviderExchange},
+	}
+	for _, tt := range tests {
+		err := CallbackError(tt.params)
+		if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: CallbackError = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
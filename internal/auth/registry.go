@@ -37,6 +37,15 @@ func (r *Registry) Get(name string) (Provider, error) {
 	return p, nil
 }
 
+// All returns the registered providers.
+func (r *Registry) All() []Provider {
+	all := make([]Provider, 0, len(r.providers))
+	for _, p := range r.providers {
+		all = append(all, p)
+	}
+	return all
+}
+
 // Names returns the list of registered provider names.
 func (r *Registry) Names() []string {
 	names := make([]string, 0, len(r.providers))
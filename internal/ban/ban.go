@@ -0,0 +1,168 @@
+// Package ban keeps the list of users who may not sign in, by provider
+// account or by central ID, in the identity database.
+package ban
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/identity"
+	"github.com/BlackMission/centralauth/internal/sqldb"
+)
+
+// schema creates the bans table. A ban names either a central ID or a
+// provider account, leaving the other columns empty, and has no expiry when
+// it is permanent.
+var schema = []string{
+	`CREATE TABLE bans (
+		id          TEXT PRIMARY KEY,
+		central_id  TEXT NOT NULL,
+		provider    TEXT NOT NULL,
+		provider_id TEXT NOT NULL,
+		reason      TEXT NOT NULL,
+		expires_at  TIMESTAMP,
+		banned_by   TEXT NOT NULL,
+		banned_at   TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX bans_provider ON bans (provider, provider_id)`,
+	`CREATE INDEX bans_central_id ON bans (central_id)`,
+}
+
+const columns = `id, central_id, provider, provider_id, reason, expires_at, banned_by, banned_at`
+
+// Ban refuses sign-ins to a central ID, and so every account linked to it,
+// or to a single provider account.
+type Ban struct {
+	ID         string    `json:"id"`
+	CentralID  string    `json:"central_id,omitempty"`
+	Provider   string    `json:"provider,omitempty"`
+	ProviderID string    `json:"provider_id,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"` // zero for a permanent ban
+	BannedBy   string    `json:"banned_by"`
+	BannedAt   time.Time `json:"banned_at"`
+}
+
+// Target is what the ban names, for logs and the audit log:
+// "provider/provider_id" or the central ID.
+func (b Ban) Target() string {
+	if b.CentralID != "" {
+		return b.CentralID
+	}
+	return b.Provider + "/" + b.ProviderID
+}
+
+// List is the ban list. A nil *List bans no one.
+type List struct {
+	db         *sqldb.DB
+	identities *identity.Store
+	now        func() time.Time
+	rand       io.Reader
+}
+
+// NewList returns the ban list kept in db, creating the bans table if
+// needed. Bans by central ID are matched through identities.
+func NewList(ctx context.Context, db *sqldb.DB, identities *identity.Store) (*List, error) {
+	if err := db.Migrate(ctx, "bans", schema); err != nil {
+		return nil, err
+	}
+	return &List{db: db, identities: identities, now: time.Now, rand: rand.Reader}, nil
+}
+
+// SetNow overrides the time function (for testing).
+func (l *List) SetNow(fn func() time.Time) {
+	l.now = fn
+}
+
+// Add bans b.CentralID, or b.Provider's account b.ProviderID, and returns
+// the ban with its ID and BannedAt set.
+func (l *List) Add(ctx context.Context, b Ban) (Ban, error) {
+	id := make([]byte, 8)
+	if _, err := io.ReadFull(l.rand, id); err != nil {
+		return Ban{}, fmt.Errorf("generating ban ID: %w", err)
+	}
+	b.ID = hex.EncodeToString(id)
+	b.BannedAt = l.now().UTC()
+	var expires sql.NullTime
+	if !b.ExpiresAt.IsZero() {
+		b.ExpiresAt = b.ExpiresAt.UTC()
+		expires = sql.NullTime{Time: b.ExpiresAt, Valid: true}
+	}
+	if _, err := l.db.Exec(ctx, `INSERT INTO bans (`+columns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		b.ID, b.CentralID, b.Provider, b.ProviderID, b.Reason, expires, b.BannedBy, b.BannedAt); err != nil {
+		return Ban{}, fmt.Errorf("adding ban: %w", err)
+	}
+	return b, nil
+}
+
+// Remove lifts the ban id, or returns domain.ErrBanNotFound.
+func (l *List) Remove(ctx context.Context, id string) error {
+	res, err := l.db.Exec(ctx, `DELETE FROM bans WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("removing ban: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return domain.ErrBanNotFound
+	}
+	return nil
+}
+
+// Active returns the bans that haven't expired, oldest first.
+func (l *List) Active(ctx context.Context) ([]Ban, error) {
+	return l.query(ctx, `SELECT `+columns+` FROM bans
+		WHERE expires_at IS NULL OR expires_at > ? ORDER BY banned_at`, l.now().UTC())
+}
+
+// Check returns the ban in force on user, by their provider account or their
+// central ID, or nil if they may sign in.
+func (l *List) Check(ctx context.Context, user domain.UserInfo) (*Ban, error) {
+	if l == nil {
+		return nil, nil
+	}
+	centralID := user.CentralID
+	if centralID == "" && l.identities != nil {
+		id, err := l.identities.Find(ctx, user.ProviderName, user.ProviderID)
+		if err != nil && !errors.Is(err, domain.ErrAccountNotFound) {
+			return nil, err
+		}
+		centralID = id
+	}
+	bans, err := l.query(ctx, `SELECT `+columns+` FROM bans
+		WHERE ((provider = ? AND provider_id = ?) OR (central_id = ? AND central_id <> ''))
+		AND (expires_at IS NULL OR expires_at > ?) ORDER BY banned_at LIMIT 1`,
+		user.ProviderName, user.ProviderID, centralID, l.now().UTC())
+	if err != nil || len(bans) == 0 {
+		return nil, err
+	}
+	return &bans[0], nil
+}
+
+func (l *List) query(ctx context.Context, query string, args ...any) ([]Ban, error) {
+	rows, err := l.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("reading bans table: %w", err)
+	}
+	defer rows.Close()
+
+	var bans []Ban
+	for rows.Next() {
+		var b Ban
+		var expires sql.NullTime
+		if err := rows.Scan(&b.ID, &b.CentralID, &b.Provider, &b.ProviderID, &b.Reason, &expires, &b.BannedBy, &b.BannedAt); err != nil {
+			return nil, fmt.Errorf("reading bans table: %w", err)
+		}
+		b.ExpiresAt = expires.Time
+		bans = append(bans, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading bans table: %w", err)
+	}
+	return bans, nil
+}
@@ -0,0 +1,142 @@
+package ban
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/identity"
+	"github.com/BlackMission/centralauth/internal/sqldb"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+// bansTable fakes a database holding the bans table, and the identities
+// table with discord/123 and steam/7656 linked to "c0ffee".
+type bansTable struct {
+	rows [][]driver.Value // in column order
+	err  error
+}
+
+func newList(t *testing.T) (*bansTable, *List, func(time.Duration)) {
+	t.Helper()
+	tbl := &bansTable{}
+	db := sqldb.New(testutil.OpenSQL(t, tbl.handle), sqldb.SQLite)
+	identities, err := identity.NewStore(context.Background(), db)
+	if err != nil {
+		t.Fatalf("NewStore error: %v", err)
+	}
+	l, err := NewList(context.Background(), db, identities)
+	if err != nil {
+		t.Fatalf("NewList error: %v", err)
+	}
+	now := time.Now()
+	l.SetNow(func() time.Time { return now })
+	return tbl, l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func (tbl *bansTable) handle(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT version"):
+		return []string{"version"}, nil, nil
+	case tbl.err != nil:
+		return nil, nil, tbl.err
+	case strings.HasPrefix(query, "SELECT central_id"):
+		if args[0] == "discord" && args[1] == "123" || args[0] == "steam" && args[1] == "7656" {
+			return []string{"central_id"}, [][]driver.Value{{"c0ffee"}}, nil
+		}
+		return []string{"central_id"}, nil, nil
+	case strings.HasPrefix(query, "INSERT INTO bans"):
+		tbl.rows = append(tbl.rows, args)
+		return nil, [][]driver.Value{{}}, nil
+	case strings.HasPrefix(query, "DELETE FROM bans"):
+		n := len(tbl.rows)
+		tbl.rows = slices.DeleteFunc(tbl.rows, func(row []driver.Value) bool { return row[0] == args[0] })
+		return nil, make([][]driver.Value, n-len(tbl.rows)), nil
+	case strings.HasPrefix(query, "SELECT id"):
+		now := args[len(args)-1].(time.Time)
+		var matched [][]driver.Value
+		for _, row := range tbl.rows {
+			if expires, ok := row[5].(time.Time); ok && !expires.After(now) {
+				continue
+			}
+			// Check names the user; Active takes only the time
+			if len(args) > 1 && !(row[2] == args[0] && row[3] == args[1] || row[1] == args[2] && row[1] != "") {
+				continue
+			}
+			matched = append(matched, row)
+		}
+		return strings.Split(columns, ", "), matched, nil
+	}
+	return nil, nil, nil
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	_, l, advance := newList(t)
+	discord := domain.UserInfo{ProviderName: "discord", ProviderID: "123"}
+	steam := domain.UserInfo{ProviderName: "steam", ProviderID: "7656"}
+
+	if b, err := l.Check(ctx, discord); b != nil || err != nil {
+		t.Fatalf("Check before any bans = %+v, %v", b, err)
+	}
+
+	// A ban on an account leaves the user's other accounts alone
+	byAccount, err := l.Add(ctx, Ban{Provider: "discord", ProviderID: "123", Reason: "spam", BannedBy: "alice", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil || byAccount.ID == "" || byAccount.BannedAt.IsZero() {
+		t.Fatalf("Add = %+v, %v", byAccount, err)
+	}
+	if b, _ := l.Check(ctx, discord); b == nil || b.ID != byAccount.ID || b.Reason != "spam" {
+		t.Errorf("Check(discord) = %+v, want the ban", b)
+	}
+	if b, _ := l.Check(ctx, steam); b != nil {
+		t.Errorf("Check(steam) = %+v, want no ban", b)
+	}
+
+	// and lapses when it expires
+	advance(time.Hour + time.Second)
+	if b, _ := l.Check(ctx, discord); b != nil {
+		t.Errorf("Check after expiry = %+v", b)
+	}
+
+	// A ban on a central ID covers every account linked to it
+	byCentralID, _ := l.Add(ctx, Ban{CentralID: "c0ffee", BannedBy: "alice"})
+	for _, user := range []domain.UserInfo{discord, steam} {
+		if b, _ := l.Check(ctx, user); b == nil || b.ID != byCentralID.ID || !b.ExpiresAt.IsZero() {
+			t.Errorf("Check(%s) = %+v, want the permanent ban", user.ProviderName, b)
+		}
+	}
+	if b, _ := l.Check(ctx, domain.UserInfo{ProviderName: "discord", ProviderID: "456"}); b != nil {
+		t.Errorf("another user is banned: %+v", b)
+	}
+
+	if active, err := l.Active(ctx); err != nil || len(active) != 1 || active[0].Target() != "c0ffee" {
+		t.Errorf("Active = %+v, %v", active, err)
+	}
+	if err := l.Remove(ctx, byCentralID.ID); err != nil {
+		t.Fatalf("Remove error: %v", err)
+	}
+	if b, _ := l.Check(ctx, steam); b != nil {
+		t.Errorf("Check after Remove = %+v", b)
+	}
+	if err := l.Remove(ctx, byCentralID.ID); !errors.Is(err, domain.ErrBanNotFound) {
+		t.Errorf("second Remove: err = %v, want ErrBanNotFound", err)
+	}
+}
+
+func TestList_Errors(t *testing.T) {
+	tbl, l, _ := newList(t)
+	tbl.err = errors.New("connection refused")
+	if _, err := l.Check(context.Background(), domain.UserInfo{ProviderName: "discord", ProviderID: "123"}); err == nil {
+		t.Error("expected an error from a failing database")
+	}
+
+	var none *List
+	if b, err := none.Check(context.Background(), domain.UserInfo{ProviderName: "discord", ProviderID: "123"}); b != nil || err != nil {
+		t.Errorf("nil List Check = %+v, %v", b, err)
+	}
+}
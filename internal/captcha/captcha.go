@@ -0,0 +1,135 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// Supported CAPTCHA services.
+const (
+	HCaptcha  = "hcaptcha"
+	Turnstile = "turnstile"
+)
+
+type service struct {
+	scriptURL     string
+	widgetClass   string
+	responseField string
+	verifyURL     string
+}
+
+var services = map[string]service{
+	HCaptcha: {
+		scriptURL:     "https://js.hcaptcha.com/1/api.js",
+		widgetClass:   "h-captcha",
+		responseField: "h-captcha-response",
+		verifyURL:     "https://api.hcaptcha.com/siteverify",
+	},
+	Turnstile: {
+		scriptURL:     "https://challenges.cloudflare.com/turnstile/v0/api.js",
+		widgetClass:   "cf-turnstile",
+		responseField: "cf-turnstile-response",
+		verifyURL:     "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	},
+}
+
+// Verifier checks CAPTCHA responses submitted with hosted page forms.
+// A nil Verifier accepts everything, so callers need no special case when
+// CAPTCHA is not configured.
+type Verifier struct {
+	kind       string
+	siteKey    string
+	secret     string
+	svc        service
+	httpClient *http.Client
+}
+
+// New creates a verifier for kind (HCaptcha or Turnstile).
+func New(kind, siteKey, secret string) (*Verifier, error) {
+	svc, ok := services[kind]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown captcha provider %q", domain.ErrInvalidConfig, kind)
+	}
+	return &Verifier{
+		kind:       kind,
+		siteKey:    siteKey,
+		secret:     secret,
+		svc:        svc,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// SiteKey returns the public key rendered into the widget.
+func (v *Verifier) SiteKey() string { return v.siteKey }
+
+// ScriptURL returns the widget's JavaScript URL.
+func (v *Verifier) ScriptURL() string { return v.svc.scriptURL }
+
+// WidgetClass returns the CSS class of the widget container element.
+func (v *Verifier) WidgetClass() string { return v.svc.widgetClass }
+
+// SetVerifyURL overrides the verification endpoint (for testing).
+func (v *Verifier) SetVerifyURL(u string) {
+	v.svc.verifyURL = u
+}
+
+// Verify checks the CAPTCHA response in r's form with the CAPTCHA service.
+func (v *Verifier) Verify(ctx context.Context, r *http.Request) error {
+	if v == nil {
+		return nil
+	}
+	response := r.PostFormValue(v.svc.responseField)
+	if response == "" {
+		return domain.ErrCaptchaFailed
+	}
+
+	form := url.Values{
+		"secret":   {v.secret},
+		"response": {response},
+		"sitekey":  {v.siteKey},
+	}
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		form.Set("remoteip", ip)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.svc.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("creating captcha request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("verifying captcha: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return fmt.Errorf("verifying captcha: reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("verifying captcha: status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("verifying captcha: invalid JSON: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("%w: %s", domain.ErrCaptchaFailed, strings.Join(result.ErrorCodes, ","))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func newTestVerifier(t *testing.T, kind string, handler http.HandlerFunc) *Verifier {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	v, err := New(kind, "site-key", "secret-key")
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	v.SetVerifyURL(srv.URL)
+	return v
+}
+
+func formRequest(field, value string) *http.Request {
+	form := url.Values{field: {value}}
+	req := httptest.NewRequest(http.MethodPost, "/local/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestVerify_Success(t *testing.T) {
+	v := newTestVerifier(t, Turnstile, func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("secret") != "secret-key" || r.PostFormValue("response") != "token" {
+			t.Errorf("unexpected verify form: %v", r.PostForm)
+		}
+		w.Write([]byte(`{"success": true}`))
+	})
+
+	if err := v.Verify(context.Background(), formRequest("cf-turnstile-response", "token")); err != nil {
+		t.Errorf("Verify error: %v", err)
+	}
+}
+
+func TestVerify_Rejected(t *testing.T) {
+	v := newTestVerifier(t, HCaptcha, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+	})
+
+	err := v.Verify(context.Background(), formRequest("h-captcha-response", "token"))
+	if !errors.Is(err, domain.ErrCaptchaFailed) {
+		t.Errorf("expected ErrCaptchaFailed, got %v", err)
+	}
+}
+
+func TestVerify_MissingResponse(t *testing.T) {
+	v := newTestVerifier(t, HCaptcha, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("verify endpoint should not be called without a response")
+	})
+
+	err := v.Verify(context.Background(), formRequest("other", "x"))
+	if !errors.Is(err, domain.ErrCaptchaFailed) {
+		t.Errorf("expected ErrCaptchaFailed, got %v", err)
+	}
+}
+
+func TestVerify_NilVerifier(t *testing.T) {
+	var v *Verifier
+	if err := v.Verify(context.Background(), formRequest("x", "y")); err != nil {
+		t.Errorf("expected nil verifier to accept, got %v", err)
+	}
+}
+
+func TestNew_UnknownProvider(t *testing.T) {
+	if _, err := New("recaptcha", "a", "b"); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// hashPrefix marks a configured API key as a hash of the key rather than the
+// key itself.
+const hashPrefix = "hash:"
+
+// storedKey is an API key as configured: either the key itself, or a hash of
+// it in one of two forms, so that a leaked configuration doesn't leak usable
+// keys:
+//
+//	hash:sha256:<hex digest>
+//	hash:$argon2id$v=19$m=65536,t=3,p=4,keyid=<id>$<salt>$<hash>   (PHC string format)
+//
+// An argon2id hash is of a key of the form <id>.<secret>. The key ID is not
+// secret; it lets a lookup find the one hash a key could match.
+type storedKey struct {
+	plain  []byte
+	sha256 []byte
+	argon  *argon2Hash
+}
+
+type argon2Hash struct {
+	keyID        string
+	time, memory uint32
+	threads      uint8
+	salt, hash   []byte
+
+	// verified is the SHA-256 of the last key that matched, so that a client
+	// pays for argon2 once rather than on every request.
+	verified atomic.Pointer[[sha256.Size]byte]
+}
+
+func parseStoredKey(s string) (*storedKey, error) {
+	rest, hashed := strings.CutPrefix(s, hashPrefix)
+	switch {
+	case !hashed:
+		return &storedKey{plain: []byte(s)}, nil
+	case strings.HasPrefix(rest, "sha256:"):
+		sum, err := hex.DecodeString(strings.TrimPrefix(rest, "sha256:"))
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid sha256 API key hash")
+		}
+		return &storedKey{sha256: sum}, nil
+	case strings.HasPrefix(rest, "$argon2id$"):
+		h, err := parseArgon2(rest)
+		if err != nil {
+			return nil, err
+		}
+		return &storedKey{argon: h}, nil
+	}
+	return nil, fmt.Errorf("unsupported API key hash (use hash:sha256:... or hash:$argon2id$...)")
+}
+
+// parseArgon2 parses an argon2id hash in the PHC string format.
+func parseArgon2(s string) (*argon2Hash, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 || parts[2] != "v=19" {
+		return nil, fmt.Errorf("invalid argon2id API key hash")
+	}
+	h := &argon2Hash{}
+	params, keyID, _ := strings.Cut(parts[3], ",keyid=")
+	if _, err := fmt.Sscanf(params, "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil || h.time == 0 || h.threads == 0 {
+		return nil, fmt.Errorf("invalid argon2id API key hash parameters %q", parts[3])
+	}
+	if !validKeyID(keyID) {
+		return nil, fmt.Errorf("argon2id API key hash needs a keyid parameter of letters, digits and dashes")
+	}
+	h.keyID = keyID
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id API key hash salt")
+	}
+	if h.hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.hash) == 0 {
+		return nil, fmt.Errorf("invalid argon2id API key hash")
+	}
+	return h, nil
+}
+
+func validKeyID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// keyID returns the ID an argon2id hash's key starts with, or "" for other
+// stored keys.
+func (k *storedKey) keyID() string {
+	if k.argon == nil {
+		return ""
+	}
+	return k.argon.keyID
+}
+
+// digest returns the SHA-256 of the key, or nil for an argon2id hash, whose
+// key is only known once verified.
+func (k *storedKey) digest() []byte {
+	switch {
+	case k.sha256 != nil:
+		return k.sha256
+	case k.argon != nil:
+		return nil
+	}
+	sum := sha256.Sum256(k.plain)
+	return sum[:]
+}
+
+// matches reports in constant time whether key is the stored key, without
+// running argon2: an argon2id hash only matches the key it last verified.
+func (k *storedKey) matches(key string, sum *[sha256.Size]byte) bool {
+	switch {
+	case k.sha256 != nil:
+		return hmac.Equal(sum[:], k.sha256)
+	case k.argon != nil:
+		v := k.argon.verified.Load()
+		return v != nil && subtle.ConstantTimeCompare(v[:], sum[:]) == 1
+	}
+	return hmac.Equal([]byte(key), k.plain)
+}
+
+// verifySlow checks key against an argon2id hash, remembering it on success.
+func (k *storedKey) verifySlow(key string, sum *[sha256.Size]byte) bool {
+	if k.argon == nil {
+		return false
+	}
+	h := k.argon
+	got := argon2.IDKey([]byte(key), h.salt, h.time, h.memory, h.threads, uint32(len(h.hash)))
+	if subtle.ConstantTimeCompare(got, h.hash) != 1 {
+		return false
+	}
+	h.verified.Store(sum)
+	return true
+}
+
+// HashAPIKey returns the hash:sha256: form of key, which can be configured in
+// place of the key itself.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hashPrefix + "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// argon2Ref returns key hashed with cheap argon2id parameters, in the
+// configured form.
+func argon2Ref(key string) string {
+	id, _, _ := strings.Cut(key, ".")
+	salt := []byte("0123456789abcdef")
+	hash := argon2.IDKey([]byte(key), salt, 1, 64, 1, 32)
+	return fmt.Sprintf("hash:$argon2id$v=19$m=64,t=1,p=1,keyid=%s$%s$%s",
+		id, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash))
+}
+
+func TestGetByAPIKey_HashedKeys(t *testing.T) {
+	r, err := NewRegistry([]domain.ClientApp{
+		{ID: "website", APIKey: HashAPIKey("web-key")},
+		{ID: "game", APIKey: argon2Ref("game.key")},
+		{ID: "mod", APIKey: argon2Ref("mod.key")},
+	})
+	if err != nil {
+		t.Fatalf("NewRegistry error: %v", err)
+	}
+
+	for key, id := range map[string]string{"web-key": "website", "game.key": "game", "mod.key": "mod"} {
+		for range 2 { // the second argon2id lookup is served from the cache
+			c, err := r.GetByAPIKey(key)
+			if err != nil || c.ID != id {
+				t.Fatalf("GetByAPIKey(%q) = %v, %v; want %s", key, c, err, id)
+			}
+		}
+	}
+	for _, key := range []string{"wrong-key", "game.wrong-key", "other.key", HashAPIKey("web-key"), argon2Ref("game.key")} {
+		if _, err := r.GetByAPIKey(key); err == nil {
+			t.Errorf("GetByAPIKey(%q) succeeded", key)
+		}
+	}
+}
+
+func TestParseStoredKey_Invalid(t *testing.T) {
+	for _, s := range []string{
+		"hash:sha256:abc",
+		"hash:sha256:" + HashAPIKey("x")[len("hash:sha256:"):] + "00",
+		"hash:md5:0123",
+		"hash:$argon2id$v=16$m=64,t=1,p=1$c2FsdA$aGFzaA",
+		"hash:$argon2id$v=19$m=64,t=0,p=1$c2FsdA$aGFzaA",
+		"hash:$argon2id$v=19$m=64,t=1,p=1,keyid=game$c2FsdA",
+		"hash:$argon2id$v=19$m=64,t=1,p=1$c2FsdA$aGFzaA",
+		"hash:$argon2id$v=19$m=64,t=1,p=1,keyid=$c2FsdA$aGFzaA",
+		"hash:$argon2id$v=19$m=64,t=1,p=1,keyid=ga.me$c2FsdA$aGFzaA",
+	} {
+		if _, err := parseStoredKey(s); err == nil {
+			t.Errorf("parseStoredKey(%q) succeeded", s)
+		}
+	}
+	if _, err := NewRegistry([]domain.ClientApp{{ID: "website", APIKey: "hash:sha256:abc"}}); err == nil {
+		t.Error("expected NewRegistry to reject an invalid hash")
+	}
+}
+
+func TestNewRegistry_SharedKeyID(t *testing.T) {
+	_, err := NewRegistry([]domain.ClientApp{
+		{ID: "game", APIKey: argon2Ref("shared.one")},
+		{ID: "mod", APIKey: argon2Ref("shared.two")},
+	})
+	if !errors.Is(err, domain.ErrAPIKeyInUse) {
+		t.Errorf("NewRegistry error = %v, want ErrAPIKeyInUse", err)
+	}
+}
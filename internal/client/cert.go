@@ -0,0 +1,26 @@
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// CertFingerprint returns the SHA-256 fingerprint of cert's DER encoding, in
+// lowercase hex, which is how clients' certificates are configured.
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseFingerprint normalizes a SHA-256 certificate fingerprint written in
+// hex, with or without the colons openssl puts between bytes.
+func ParseFingerprint(s string) (string, error) {
+	fp := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(s), ":", ""))
+	if b, err := hex.DecodeString(fp); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("certificate fingerprint %q must be a SHA-256 digest in hex", s)
+	}
+	return fp, nil
+}
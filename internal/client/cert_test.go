@@ -0,0 +1,29 @@
+package client
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+func TestCertFingerprint(t *testing.T) {
+	// sha256("abc")
+	got := CertFingerprint(&x509.Certificate{Raw: []byte("abc")})
+	if want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"; got != want {
+		t.Errorf("CertFingerprint = %q, want %q", got, want)
+	}
+}
+
+func TestParseFingerprint(t *testing.T) {
+	want := "ab" + strings.Repeat("01", 31)
+	for _, in := range []string{want, strings.ToUpper(want), " AB:" + strings.TrimSuffix(strings.Repeat("01:", 31), ":") + " "} {
+		if got, err := ParseFingerprint(in); err != nil || got != want {
+			t.Errorf("ParseFingerprint(%q) = %q, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "abcd", strings.Repeat("zz", 32), strings.Repeat("ab", 20)} {
+		if _, err := ParseFingerprint(in); err == nil {
+			t.Errorf("ParseFingerprint(%q): expected an error", in)
+		}
+	}
+}
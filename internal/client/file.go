@@ -0,0 +1,220 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/geoip"
+	"github.com/BlackMission/centralauth/internal/scope"
+)
+
+// fileClient is the on-disk form of a client app. Unlike domain.ClientApp it
+// carries the API key, since the file is the source of truth for it.
+type fileClient struct {
+	ID                    string   `json:"id"`
+	Name                  string   `json:"name"`
+	APIKey                string   `json:"api_key"`
+	SecondaryAPIKey       string   `json:"secondary_api_key"` // also accepted while api_key is rotated
+	AllowedCallbacks      []string `json:"allowed_callbacks"`
+	AllowedProviders      []string `json:"allowed_providers"`
+	KeyVersion            string   `json:"key_version"`
+	RequireCaptcha        bool     `json:"require_captcha"`
+	AllowLookup           bool     `json:"allow_lookup"`
+	IncludeRaw            bool     `json:"include_raw"`
+	Public                bool     `json:"public"` // no api_key; redeems codes with PKCE
+	AllowTokenPassthrough bool     `json:"allow_token_passthrough"`
+	AllowServiceTokens    bool     `json:"allow_service_tokens"`
+	BackchannelLogoutURI  string   `json:"backchannel_logout_uri"`
+	StripFields           []string `json:"strip_fields"`
+	GuestLifetime         string   `json:"guest_lifetime"`    // e.g. "2h"; empty uses the provider default
+	StateTTL              string   `json:"state_ttl"`         // e.g. "10m"; empty uses STATE_TTL
+	ExchangeCodeTTL       string   `json:"exchange_code_ttl"` // e.g. "1m"; empty uses EXCHANGE_CODE_TTL
+	RefreshTokenTTL       string   `json:"refresh_token_ttl"` // e.g. "720h"; empty issues no refresh tokens
+	RateLimit             string   `json:"rate_limit"`        // e.g. "100/1m"; empty uses CLIENT_RATE_LIMIT
+	AllowedIPs            []string `json:"allowed_ips"`       // CIDR prefixes or addresses the api_key works from
+	CertFingerprints      []string `json:"cert_fingerprints"` // SHA-256 fingerprints of its TLS client certificates
+	BlockedCountries      []string `json:"blocked_countries"` // ISO 3166-1 codes of countries its users can't sign in from
+	Enabled               *bool    `json:"enabled"`           // false suspends the client; default true
+}
+
+// LoadFile reads a JSON array of client apps from path.
+func LoadFile(path string) ([]domain.ClientApp, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading clients file: %w", err)
+	}
+	return parseFile(data)
+}
+
+// FileStore is a Store backed by a JSON clients file (see LoadFile).
+type FileStore struct {
+	Path string
+}
+
+// Load reads the file.
+func (s FileStore) Load(context.Context) ([]domain.ClientApp, error) {
+	return LoadFile(s.Path)
+}
+
+func (s FileStore) String() string {
+	return s.Path
+}
+
+func parseFile(data []byte) ([]domain.ClientApp, error) {
+	var entries []fileClient
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parsing clients file: %w", err)
+	}
+
+	clients := make([]domain.ClientApp, 0, len(entries))
+	for _, e := range entries {
+		if e.ID == "" {
+			return nil, fmt.Errorf("parsing clients file: client without id")
+		}
+		if e.Public && (e.APIKey != "" || e.SecondaryAPIKey != "") {
+			return nil, fmt.Errorf("parsing clients file: public client %q has an api_key", e.ID)
+		}
+		if e.APIKey == "" && !e.Public {
+			return nil, fmt.Errorf("parsing clients file: client %q has no api_key", e.ID)
+		}
+		guestLifetime, err := parseDuration(e.ID, "guest_lifetime", e.GuestLifetime)
+		if err != nil {
+			return nil, fmt.Errorf("parsing clients file: %w", err)
+		}
+		stateTTL, err := parseDuration(e.ID, "state_ttl", e.StateTTL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing clients file: %w", err)
+		}
+		exchangeCodeTTL, err := parseDuration(e.ID, "exchange_code_ttl", e.ExchangeCodeTTL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing clients file: %w", err)
+		}
+		refreshTokenTTL, err := parseDuration(e.ID, "refresh_token_ttl", e.RefreshTokenTTL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing clients file: %w", err)
+		}
+		rateLimit, err := parseRateLimit(e.ID, e.RateLimit)
+		if err != nil {
+			return nil, fmt.Errorf("parsing clients file: %w", err)
+		}
+		if err := scope.CheckFields(e.StripFields); err != nil {
+			return nil, fmt.Errorf("parsing clients file: client %q has an invalid strip_fields: %w", e.ID, err)
+		}
+		allowedIPs, err := parsePrefixes(e.ID, e.AllowedIPs)
+		if err != nil {
+			return nil, fmt.Errorf("parsing clients file: %w", err)
+		}
+		certFingerprints, err := parseFingerprints(e.ID, e.CertFingerprints)
+		if err != nil {
+			return nil, fmt.Errorf("parsing clients file: %w", err)
+		}
+		blockedCountries, err := parseCountries(e.ID, e.BlockedCountries)
+		if err != nil {
+			return nil, fmt.Errorf("parsing clients file: %w", err)
+		}
+		name := e.Name
+		if name == "" {
+			name = e.ID
+		}
+		clients = append(clients, domain.ClientApp{
+			ID:                    e.ID,
+			Name:                  name,
+			APIKey:                e.APIKey,
+			SecondaryAPIKey:       e.SecondaryAPIKey,
+			AllowedCallbacks:      e.AllowedCallbacks,
+			AllowedProviders:      e.AllowedProviders,
+			KeyVersion:            e.KeyVersion,
+			RequireCaptcha:        e.RequireCaptcha,
+			AllowLookup:           e.AllowLookup,
+			IncludeRaw:            e.IncludeRaw,
+			Public:                e.Public,
+			AllowTokenPassthrough: e.AllowTokenPassthrough,
+			AllowServiceTokens:    e.AllowServiceTokens,
+			StripFields:           e.StripFields,
+			GuestLifetime:         guestLifetime,
+			StateTTL:              stateTTL,
+			ExchangeCodeTTL:       exchangeCodeTTL,
+			RefreshTokenTTL:       refreshTokenTTL,
+			BackchannelLogoutURI:  e.BackchannelLogoutURI,
+			RateLimit:             rateLimit,
+			AllowedIPs:            allowedIPs,
+			CertFingerprints:      certFingerprints,
+			BlockedCountries:      blockedCountries,
+			Disabled:              e.Enabled != nil && !*e.Enabled,
+		})
+	}
+	return clients, nil
+}
+
+// parseDuration parses the optional duration field of client id.
+func parseDuration(id, field, v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("client %q has an invalid %s: %q", id, field, v)
+	}
+	return d, nil
+}
+
+// parsePrefixes parses the allowed_ips of client id: CIDR prefixes, where a
+// bare IP address stands for itself alone.
+func parsePrefixes(id string, list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range list {
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			ip, ipErr := netip.ParseAddr(v)
+			if ipErr != nil {
+				return nil, fmt.Errorf("client %q has an invalid allowed_ips entry: %q", id, v)
+			}
+			p = netip.PrefixFrom(ip, ip.BitLen())
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// parseFingerprints normalizes the cert_fingerprints of client id.
+func parseFingerprints(id string, list []string) ([]string, error) {
+	var fingerprints []string
+	for _, v := range list {
+		fp, err := ParseFingerprint(v)
+		if err != nil {
+			return nil, fmt.Errorf("client %q has an invalid cert_fingerprints entry: %w", id, err)
+		}
+		fingerprints = append(fingerprints, fp)
+	}
+	return fingerprints, nil
+}
+
+// parseCountries normalizes the blocked_countries of client id.
+func parseCountries(id string, list []string) ([]string, error) {
+	var countries []string
+	for _, v := range list {
+		code, err := geoip.ParseCountry(v)
+		if err != nil {
+			return nil, fmt.Errorf("client %q has an invalid blocked_countries entry: %w", id, err)
+		}
+		countries = append(countries, code)
+	}
+	return countries, nil
+}
+
+// parseRateLimit parses the optional rate_limit field of client id.
+func parseRateLimit(id, v string) (domain.RateLimit, error) {
+	if v == "" {
+		return domain.RateLimit{}, nil
+	}
+	l, err := domain.ParseRateLimit(v)
+	if err != nil {
+		return domain.RateLimit{}, fmt.Errorf("client %q: %w", id, err)
+	}
+	return l, nil
+}
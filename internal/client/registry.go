@@ -2,51 +2,417 @@ package client
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/BlackMission/centralauth/internal/domain"
 )
 
+const (
+	defaultRetention = 30 * 24 * time.Hour
+
+	// DefaultGracePeriod is how long a rotated-out API key keeps working
+	// when RotateKey isn't given a grace period.
+	DefaultGracePeriod = 24 * time.Hour
+)
+
+// Tombstone records a soft-deleted client. Until PurgeAt the deletion can be
+// undone with Restore.
+type Tombstone struct {
+	ClientID  string    `json:"client_id"`
+	DeletedBy string    `json:"deleted_by"`
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+// Suspension records a client disabled through the admin API. It lasts until
+// the client is enabled again.
+type Suspension struct {
+	ClientID   string    `json:"client_id"`
+	DisabledBy string    `json:"disabled_by"`
+	DisabledAt time.Time `json:"disabled_at"`
+}
+
+// Rotation records an API key rotated through the admin API. The key it
+// replaced stays valid until GraceUntil, so the client's services can switch
+// over without downtime.
+type Rotation struct {
+	ClientID   string    `json:"client_id"`
+	APIKey     string    `json:"api_key"`
+	RotatedBy  string    `json:"rotated_by"`
+	RotatedAt  time.Time `json:"rotated_at"`
+	GraceUntil time.Time `json:"grace_until"`
+
+	key       string // hash of APIKey, which is only kept in the returned copy
+	previous  string
+	sourceKey string // the client's key in its source when it was rotated
+}
+
+// apiKey is an entry of the API key index. A secondary key being rotated out
+// expires; other keys don't.
+type apiKey struct {
+	client  *domain.ClientApp
+	stored  *storedKey
+	expires time.Time
+}
+
 // Registry holds registered client apps and provides lookup/validation.
+// Its contents can be swapped at runtime with Replace.
+//
+// API keys are indexed by an HMAC of their SHA-256 under a random per-process
+// key, so a lookup costs the same however many clients there are and the
+// index's timing reveals nothing an attacker could steer. Keys hashed with
+// argon2id can't be indexed that way, so they are indexed by the key ID their
+// keys start with, and a lookup runs argon2id at most once.
+//
+// Deleted clients stay in the registry behind a tombstone, so lookups fail
+// but the deletion is reversible for the retention period. Tombstones are
+// kept across Replace, so a reload does not bring a deleted client back.
+// Key rotations are kept too, until the reloaded client carries the new key,
+// and so are suspensions, so a reload does not re-enable a disabled client.
 type Registry struct {
+	mu       sync.RWMutex
+	source   []domain.ClientApp
 	byID     map[string]*domain.ClientApp
-	byAPIKey map[string]*domain.ClientApp
+	indexKey []byte
+	byKeyMAC map[[sha256.Size]byte]*apiKey
+	slowKeys map[string]*apiKey // argon2id hashes, by key ID
+	origins  map[string][]*domain.ClientApp
+	byCert   map[string]*domain.ClientApp // by certificate fingerprint
+
+	rateLimit domain.RateLimit
+
+	rotations   map[string]Rotation
+	suspensions map[string]Suspension
+	tombstones  map[string]Tombstone
+	purged      map[string]bool
+	retention   time.Duration
+	now         func() time.Time
 }
 
 // NewRegistry creates a client registry from the given client app list.
 func NewRegistry(clients []domain.ClientApp) (*Registry, error) {
+	indexKey := make([]byte, 32)
+	if _, err := rand.Read(indexKey); err != nil {
+		return nil, fmt.Errorf("generating API key index key: %w", err)
+	}
 	r := &Registry{
-		byID:     make(map[string]*domain.ClientApp, len(clients)),
-		byAPIKey: make(map[string]*domain.ClientApp, len(clients)),
+		indexKey:    indexKey,
+		rotations:   make(map[string]Rotation),
+		suspensions: make(map[string]Suspension),
+		tombstones:  make(map[string]Tombstone),
+		purged:      make(map[string]bool),
+		retention:   defaultRetention,
+		now:         time.Now,
+	}
+	if err := r.Replace(clients); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Replace atomically swaps the registry contents for the given client list.
+// On error the previous contents are kept.
+func (r *Registry) Replace(clients []domain.ClientApp) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range clients {
+		if rot, ok := r.rotations[c.ID]; ok && c.APIKey != rot.sourceKey {
+			// The client's key was changed in its source since the rotation.
+			delete(r.rotations, c.ID)
+		}
+	}
+	return r.indexLocked(clients)
+}
+
+// indexLocked rebuilds the lookup maps from clients with the rotations
+// applied. Callers must hold r.mu.
+func (r *Registry) indexLocked(clients []domain.ClientApp) error {
+	byID := make(map[string]*domain.ClientApp, len(clients))
+	byKeyMAC := make(map[[sha256.Size]byte]*apiKey, len(clients))
+	slowKeys := make(map[string]*apiKey)
+	origins := make(map[string][]*domain.ClientApp)
+	byCert := make(map[string]*domain.ClientApp)
+	add := func(e *apiKey) error {
+		d := e.stored.digest()
+		if d == nil {
+			id := e.stored.keyID()
+			if other, ok := slowKeys[id]; ok {
+				return fmt.Errorf("clients %q and %q: argon2id key ID %q: %w", other.client.ID, e.client.ID, id, domain.ErrAPIKeyInUse)
+			}
+			slowKeys[id] = e
+			return nil
+		}
+		mac := r.keyMAC(d)
+		if other, ok := byKeyMAC[mac]; ok && other.client.ID != e.client.ID {
+			return fmt.Errorf("clients %q and %q: %w", other.client.ID, e.client.ID, domain.ErrAPIKeyInUse)
+		}
+		if _, ok := byKeyMAC[mac]; !ok {
+			byKeyMAC[mac] = e
+		}
+		return nil
 	}
 	for i := range clients {
-		c := &clients[i]
-		if _, exists := r.byID[c.ID]; exists {
-			return nil, fmt.Errorf("%w: %s", domain.ErrDuplicateClientID, c.ID)
+		c := clients[i]
+		if _, exists := byID[c.ID]; exists {
+			return fmt.Errorf("%w: %s", domain.ErrDuplicateClientID, c.ID)
+		}
+		var secondaryExpires time.Time
+		if rot, ok := r.rotations[c.ID]; ok {
+			c.APIKey, c.SecondaryAPIKey, secondaryExpires = rot.key, rot.previous, rot.GraceUntil
+		}
+		if _, ok := r.suspensions[c.ID]; ok {
+			c.Disabled = true
+		}
+		byID[c.ID] = &c
+		for _, cb := range c.AllowedCallbacks {
+			if o := Origin(cb); o != "" && !slices.Contains(origins[o], &c) {
+				origins[o] = append(origins[o], &c)
+			}
+		}
+		for _, fp := range c.CertFingerprints {
+			if other, ok := byCert[fp]; ok && other.ID != c.ID {
+				return fmt.Errorf("clients %q and %q: %w", other.ID, c.ID, domain.ErrCertificateInUse)
+			}
+			byCert[fp] = &c
+		}
+		if c.Public {
+			// Public clients have no key to index; an empty one would
+			// match a request without a key
+			continue
+		}
+
+		primary, err := parseStoredKey(c.APIKey)
+		if err != nil {
+			return fmt.Errorf("client %q: %w", c.ID, err)
+		}
+		if err := add(&apiKey{client: &c, stored: primary}); err != nil {
+			return err
+		}
+		if c.SecondaryAPIKey != "" {
+			secondary, err := parseStoredKey(c.SecondaryAPIKey)
+			if err != nil {
+				return fmt.Errorf("client %q secondary key: %w", c.ID, err)
+			}
+			if err := add(&apiKey{client: &c, stored: secondary, expires: secondaryExpires}); err != nil {
+				return err
+			}
 		}
-		r.byID[c.ID] = c
-		r.byAPIKey[c.APIKey] = c
 	}
-	return r, nil
+
+	r.source = clients
+	r.byID = byID
+	r.byKeyMAC = byKeyMAC
+	r.slowKeys = slowKeys
+	r.origins = origins
+	r.byCert = byCert
+	return nil
+}
+
+// keyMAC returns the index entry for a key with the given SHA-256 digest.
+func (r *Registry) keyMAC(digest []byte) [sha256.Size]byte {
+	m := hmac.New(sha256.New, r.indexKey)
+	m.Write(digest)
+	var mac [sha256.Size]byte
+	m.Sum(mac[:0])
+	return mac
 }
 
-// Get returns a client app by its ID.
+// Get returns a client app by its ID. It returns ErrClientDisabled for a
+// disabled client.
 func (r *Registry) Get(clientID string) (*domain.ClientApp, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	c, ok := r.byID[clientID]
-	if !ok {
+	if !ok || r.deletedLocked(clientID) {
 		return nil, domain.ErrClientNotFound
 	}
+	if c.Disabled {
+		return nil, domain.ErrClientDisabled
+	}
+	return c, nil
+}
+
+// GetByAPIKey returns a client app by its primary or secondary API key using
+// constant-time comparison. It returns ErrClientDisabled for a disabled
+// client's key.
+func (r *Registry) GetByAPIKey(key string) (*domain.ClientApp, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	e := r.findKeyLocked(key)
+	if e == nil || r.deletedLocked(e.client.ID) {
+		return nil, domain.ErrInvalidAPIKey
+	}
+	if !e.expires.IsZero() && !r.now().Before(e.expires) {
+		return nil, domain.ErrInvalidAPIKey
+	}
+	if e.client.Disabled {
+		return nil, domain.ErrClientDisabled
+	}
+	return e.client, nil
+}
+
+// GetByCertificate returns the client app that presents cert, by its
+// fingerprint. It returns ErrClientDisabled for a disabled client.
+func (r *Registry) GetByCertificate(cert *x509.Certificate) (*domain.ClientApp, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.byCert[CertFingerprint(cert)]
+	if !ok || r.deletedLocked(c.ID) {
+		return nil, domain.ErrClientNotFound
+	}
+	if c.Disabled {
+		return nil, domain.ErrClientDisabled
+	}
 	return c, nil
 }
 
-// GetByAPIKey returns a client app by its API key using constant-time comparison.
-func (r *Registry) GetByAPIKey(apiKey string) (*domain.ClientApp, error) {
-	for key, c := range r.byAPIKey {
-		if hmac.Equal([]byte(key), []byte(apiKey)) {
-			return c, nil
+// findKeyLocked returns the index entry whose stored key matches key, or nil.
+// Only the argon2id hash for key's ID is computed, and only once the cheap
+// comparisons have failed. Callers must hold r.mu.
+func (r *Registry) findKeyLocked(key string) *apiKey {
+	sum := sha256.Sum256([]byte(key))
+	if e, ok := r.byKeyMAC[r.keyMAC(sum[:])]; ok && e.stored.matches(key, &sum) {
+		return e
+	}
+	id, _, ok := strings.Cut(key, ".")
+	if !ok {
+		return nil
+	}
+	e, ok := r.slowKeys[id]
+	if !ok || !(e.stored.matches(key, &sum) || e.stored.verifySlow(key, &sum)) {
+		return nil
+	}
+	return e
+}
+
+// RotateKey makes newKey the primary API key of a client on behalf of actor.
+// The current primary key becomes the secondary key and is accepted for grace
+// more (DefaultGracePeriod if zero); the previous secondary key stops working.
+func (r *Registry) RotateKey(clientID, newKey, actor string, grace time.Duration) (Rotation, error) {
+	if grace <= 0 {
+		grace = DefaultGracePeriod
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.byID[clientID]
+	if !ok || r.deletedLocked(clientID) {
+		return Rotation{}, domain.ErrClientNotFound
+	}
+	if c.Public {
+		return Rotation{}, domain.ErrPublicClient
+	}
+	if newKey == "" {
+		return Rotation{}, domain.ErrInvalidAPIKey
+	}
+	if r.findKeyLocked(newKey) != nil {
+		return Rotation{}, domain.ErrAPIKeyInUse
+	}
+	now := r.now()
+	rot := Rotation{
+		ClientID:   clientID,
+		APIKey:     newKey,
+		RotatedBy:  actor,
+		RotatedAt:  now,
+		GraceUntil: now.Add(grace),
+	}
+
+	// Only the hash of the new key is kept.
+	stored := rot
+	stored.APIKey = ""
+	stored.key = HashAPIKey(newKey)
+	stored.previous = c.APIKey
+	if prev, ok := r.rotations[clientID]; ok {
+		stored.sourceKey = prev.sourceKey
+	} else {
+		stored.sourceKey = c.APIKey
+	}
+	r.rotations[clientID] = stored
+	if err := r.indexLocked(r.source); err != nil {
+		return Rotation{}, err
+	}
+	return rot, nil
+}
+
+// Disable suspends a client on behalf of actor, effective immediately. The
+// client keeps its settings and keys and comes back with Enable. Disabling a
+// client again returns the existing suspension.
+func (r *Registry) Disable(clientID, actor string) (Suspension, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.byID[clientID]; !ok || r.deletedLocked(clientID) {
+		return Suspension{}, domain.ErrClientNotFound
+	}
+	if s, ok := r.suspensions[clientID]; ok {
+		return s, nil
+	}
+	s := Suspension{ClientID: clientID, DisabledBy: actor, DisabledAt: r.now()}
+	r.suspensions[clientID] = s
+	if err := r.indexLocked(r.source); err != nil {
+		delete(r.suspensions, clientID)
+		return Suspension{}, err
+	}
+	return s, nil
+}
+
+// Enable lifts a suspension made with Disable. It returns ErrClientDisabled
+// if the client stays disabled because its source disables it.
+func (r *Registry) Enable(clientID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.byID[clientID]; !ok || r.deletedLocked(clientID) {
+		return domain.ErrClientNotFound
+	}
+	if s, ok := r.suspensions[clientID]; ok {
+		delete(r.suspensions, clientID)
+		if err := r.indexLocked(r.source); err != nil {
+			r.suspensions[clientID] = s
+			return err
 		}
 	}
-	return nil, domain.ErrInvalidAPIKey
+	if r.byID[clientID].Disabled {
+		return domain.ErrClientDisabled
+	}
+	return nil
+}
+
+// Suspended returns the suspensions made with Disable, oldest first.
+func (r *Registry) Suspended() []Suspension {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]Suspension, 0, len(r.suspensions))
+	for _, s := range r.suspensions {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DisabledAt.Before(out[j].DisabledAt) })
+	return out
+}
+
+// GenerateAPIKey returns a random API key.
+func GenerateAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating API key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
 // ValidateCallback checks if the given callback URI is allowed for the client.
@@ -63,6 +429,40 @@ func (r *Registry) ValidateCallback(clientID, callbackURI string) error {
 	return domain.ErrCallbackNotAllowed
 }
 
+// AllowsOrigin reports whether origin, as sent in a browser's Origin
+// header, is the origin of one of an active client's allowed callbacks.
+func (r *Registry) AllowsOrigin(origin string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.origins[strings.ToLower(origin)] {
+		if !c.Disabled && !r.deletedLocked(c.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Origin returns the origin of an http(s) URL as browsers send it, e.g.
+// "https://app.example.com:8443", or "" for any other URL.
+func Origin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	scheme, host, port := strings.ToLower(u.Scheme), strings.ToLower(u.Hostname()), u.Port()
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		return scheme + "://" + net.JoinHostPort(host, port)
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return scheme + "://" + host
+}
+
 // ValidateProvider checks if the given provider is allowed for the client.
 func (r *Registry) ValidateProvider(clientID, provider string) error {
 	c, err := r.Get(clientID)
@@ -76,3 +476,148 @@ func (r *Registry) ValidateProvider(clientID, provider string) error {
 	}
 	return domain.ErrProviderNotAllowed
 }
+
+// KeyVersion returns the exchange key version of a client ("" if unknown).
+func (r *Registry) KeyVersion(clientID string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if c, ok := r.byID[clientID]; ok {
+		return c.KeyVersion
+	}
+	return ""
+}
+
+// RequiresCaptcha reports whether hosted pages must show a CAPTCHA in flows
+// started by clientID.
+func (r *Registry) RequiresCaptcha(clientID string) bool {
+	c, err := r.Get(clientID)
+	return err == nil && c.RequireCaptcha
+}
+
+// GuestLifetime returns how long guest identities issued to clientID last,
+// or 0 to use the guest provider's default.
+func (r *Registry) GuestLifetime(clientID string) time.Duration {
+	c, err := r.Get(clientID)
+	if err != nil {
+		return 0
+	}
+	return c.GuestLifetime
+}
+
+// StateTTL returns how long state tokens issued to clientID stay valid, or 0
+// to use the service default.
+func (r *Registry) StateTTL(clientID string) time.Duration {
+	c, err := r.Get(clientID)
+	if err != nil {
+		return 0
+	}
+	return c.StateTTL
+}
+
+// ExchangeCodeTTL returns how long exchange codes issued to clientID stay
+// valid, or 0 to use the service default.
+func (r *Registry) ExchangeCodeTTL(clientID string) time.Duration {
+	c, err := r.Get(clientID)
+	if err != nil {
+		return 0
+	}
+	return c.ExchangeCodeTTL
+}
+
+// RateLimit returns the limit on clientID's requests: its own, or the default
+// set with SetRateLimit.
+func (r *Registry) RateLimit(clientID string) domain.RateLimit {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if c, ok := r.byID[clientID]; ok && !c.RateLimit.IsZero() {
+		return c.RateLimit
+	}
+	return r.rateLimit
+}
+
+// SetRateLimit sets the limit for clients without one of their own.
+func (r *Registry) SetRateLimit(l domain.RateLimit) {
+	r.mu.Lock()
+	r.rateLimit = l
+	r.mu.Unlock()
+}
+
+// SetRetention sets how long deleted clients can be restored (30 days if zero).
+func (r *Registry) SetRetention(d time.Duration) {
+	if d <= 0 {
+		d = defaultRetention
+	}
+	r.mu.Lock()
+	r.retention = d
+	r.mu.Unlock()
+}
+
+// SetNow overrides the time function (for testing).
+func (r *Registry) SetNow(fn func() time.Time) {
+	r.now = fn
+}
+
+// Delete soft-deletes a client on behalf of actor. The client stops resolving
+// immediately but can be restored until the returned tombstone's PurgeAt.
+func (r *Registry) Delete(clientID, actor string) (Tombstone, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.byID[clientID]; !ok || r.deletedLocked(clientID) {
+		return Tombstone{}, domain.ErrClientNotFound
+	}
+	now := r.now()
+	t := Tombstone{
+		ClientID:  clientID,
+		DeletedBy: actor,
+		DeletedAt: now,
+		PurgeAt:   now.Add(r.retention),
+	}
+	r.tombstones[clientID] = t
+	return t, nil
+}
+
+// Restore undoes a soft delete that is still within its retention period.
+func (r *Registry) Restore(clientID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.tombstones[clientID]
+	if !ok || !r.now().Before(t.PurgeAt) {
+		return domain.ErrClientNotFound
+	}
+	delete(r.tombstones, clientID)
+	return nil
+}
+
+// Deleted returns the restorable tombstones, oldest first.
+func (r *Registry) Deleted() []Tombstone {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	out := make([]Tombstone, 0, len(r.tombstones))
+	for id, t := range r.tombstones {
+		if !now.Before(t.PurgeAt) {
+			// Past retention: the deletion becomes permanent.
+			delete(r.tombstones, id)
+			r.purged[id] = true
+			continue
+		}
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DeletedAt.Before(out[j].DeletedAt) })
+	return out
+}
+
+// deletedLocked reports whether clientID is soft-deleted or purged.
+// Callers must hold r.mu.
+func (r *Registry) deletedLocked(clientID string) bool {
+	if r.purged[clientID] {
+		return true
+	}
+	_, ok := r.tombstones[clientID]
+	return ok
+}
@@ -1,8 +1,11 @@
 package client
 
 import (
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/BlackMission/centralauth/internal/domain"
 )
@@ -103,6 +106,57 @@ func TestValidateCallback_Disallowed(t *testing.T) {
 	}
 }
 
+func TestAllowsOrigin(t *testing.T) {
+	r, err := NewRegistry(testClients())
+	if err != nil {
+		t.Fatalf("NewRegistry error: %v", err)
+	}
+
+	for origin, want := range map[string]bool{
+		"https://example.com":       true,
+		"https://EXAMPLE.com":       true,
+		"http://localhost:3000":     true,
+		"https://admin.example.com": true,
+		"http://example.com":        false,
+		"https://example.com:8443":  false,
+		"https://evil.com":          false,
+		"null":                      false,
+	} {
+		if got := r.AllowsOrigin(origin); got != want {
+			t.Errorf("AllowsOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+
+	if _, err := r.Disable("admin", "ops"); err != nil {
+		t.Fatalf("Disable error: %v", err)
+	}
+	if r.AllowsOrigin("https://admin.example.com") {
+		t.Error("expected a disabled client's origin to be refused")
+	}
+	if _, err := r.Delete("website", "ops"); err != nil {
+		t.Fatalf("Delete error: %v", err)
+	}
+	if r.AllowsOrigin("https://example.com") {
+		t.Error("expected a deleted client's origin to be refused")
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	for in, want := range map[string]string{
+		"https://Example.com/callback":     "https://example.com",
+		"https://example.com:443/cb":       "https://example.com",
+		"http://localhost:3000/callback":   "http://localhost:3000",
+		"http://[::1]:8080/cb":             "http://[::1]:8080",
+		"http://[::1]/cb":                  "http://[::1]",
+		"com.example.app://oauth/callback": "",
+		"not a url":                        "",
+	} {
+		if got := Origin(in); got != want {
+			t.Errorf("Origin(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
 func TestValidateProvider_Allowed(t *testing.T) {
 	r, err := NewRegistry(testClients())
 	if err != nil {
@@ -136,3 +190,380 @@ func TestDuplicateIDs(t *testing.T) {
 		t.Errorf("expected ErrDuplicateClientID, got %v", err)
 	}
 }
+
+func TestReplace(t *testing.T) {
+	r, err := NewRegistry(testClients())
+	if err != nil {
+		t.Fatalf("NewRegistry error: %v", err)
+	}
+
+	err = r.Replace([]domain.ClientApp{{ID: "game", Name: "Game", APIKey: "game-key"}})
+	if err != nil {
+		t.Fatalf("Replace error: %v", err)
+	}
+	if _, err := r.Get("website"); !errors.Is(err, domain.ErrClientNotFound) {
+		t.Errorf("expected website to be removed, got %v", err)
+	}
+	if c, err := r.GetByAPIKey("game-key"); err != nil || c.ID != "game" {
+		t.Errorf("expected game client, got %v, %v", c, err)
+	}
+}
+
+func TestReplace_KeepsContentsOnError(t *testing.T) {
+	r, err := NewRegistry(testClients())
+	if err != nil {
+		t.Fatalf("NewRegistry error: %v", err)
+	}
+
+	err = r.Replace([]domain.ClientApp{
+		{ID: "dup", APIKey: "key1"},
+		{ID: "dup", APIKey: "key2"},
+	})
+	if !errors.Is(err, domain.ErrDuplicateClientID) {
+		t.Fatalf("expected ErrDuplicateClientID, got %v", err)
+	}
+	if _, err := r.Get("website"); err != nil {
+		t.Errorf("expected previous contents to remain, got %v", err)
+	}
+}
+
+func TestDeleteAndRestore(t *testing.T) {
+	r, err := NewRegistry(testClients())
+	if err != nil {
+		t.Fatalf("NewRegistry error: %v", err)
+	}
+
+	tomb, err := r.Delete("website", "alice")
+	if err != nil {
+		t.Fatalf("Delete error: %v", err)
+	}
+	if tomb.DeletedBy != "alice" || tomb.PurgeAt.Sub(tomb.DeletedAt) != defaultRetention {
+		t.Errorf("unexpected tombstone: %+v", tomb)
+	}
+	if _, err := r.Get("website"); !errors.Is(err, domain.ErrClientNotFound) {
+		t.Errorf("expected deleted client to be hidden, got %v", err)
+	}
+	if _, err := r.GetByAPIKey("web-api-key-secret"); !errors.Is(err, domain.ErrInvalidAPIKey) {
+		t.Errorf("expected deleted client's key to be rejected, got %v", err)
+	}
+	if _, err := r.Delete("website", "alice"); !errors.Is(err, domain.ErrClientNotFound) {
+		t.Errorf("expected second delete to fail, got %v", err)
+	}
+
+	if err := r.Restore("website"); err != nil {
+		t.Fatalf("Restore error: %v", err)
+	}
+	if _, err := r.Get("website"); err != nil {
+		t.Errorf("expected restored client, got %v", err)
+	}
+}
+
+func TestDelete_SurvivesReplace(t *testing.T) {
+	r, _ := NewRegistry(testClients())
+	if _, err := r.Delete("website", "alice"); err != nil {
+		t.Fatalf("Delete error: %v", err)
+	}
+	if err := r.Replace(testClients()); err != nil {
+		t.Fatalf("Replace error: %v", err)
+	}
+	if _, err := r.Get("website"); !errors.Is(err, domain.ErrClientNotFound) {
+		t.Errorf("expected client to stay deleted after reload, got %v", err)
+	}
+}
+
+func TestDelete_PurgedAfterRetention(t *testing.T) {
+	r, _ := NewRegistry(testClients())
+	now := time.Now()
+	r.SetNow(func() time.Time { return now })
+	r.SetRetention(time.Hour)
+
+	if _, err := r.Delete("website", "alice"); err != nil {
+		t.Fatalf("Delete error: %v", err)
+	}
+	if got := r.Deleted(); len(got) != 1 {
+		t.Fatalf("expected 1 tombstone, got %d", len(got))
+	}
+
+	now = now.Add(2 * time.Hour)
+	if got := r.Deleted(); len(got) != 0 {
+		t.Errorf("expected tombstone to be purged, got %+v", got)
+	}
+	if err := r.Restore("website"); !errors.Is(err, domain.ErrClientNotFound) {
+		t.Errorf("expected restore to fail after retention, got %v", err)
+	}
+	if _, err := r.Get("website"); !errors.Is(err, domain.ErrClientNotFound) {
+		t.Errorf("expected purged client to stay deleted, got %v", err)
+	}
+}
+
+func TestRequiresCaptcha(t *testing.T) {
+	clients := testClients()
+	clients[0].RequireCaptcha = true
+	r, _ := NewRegistry(clients)
+
+	if !r.RequiresCaptcha("website") {
+		t.Error("expected website to require CAPTCHA")
+	}
+	if r.RequiresCaptcha("admin") || r.RequiresCaptcha("unknown") {
+		t.Error("expected CAPTCHA to be off for other clients")
+	}
+}
+
+func TestTokenTTLs(t *testing.T) {
+	clients := testClients()
+	clients[0].StateTTL = 10 * time.Minute
+	clients[0].ExchangeCodeTTL = time.Minute
+	r, _ := NewRegistry(clients)
+
+	if r.StateTTL("website") != 10*time.Minute || r.ExchangeCodeTTL("website") != time.Minute {
+		t.Errorf("got %v and %v, want 10m and 1m", r.StateTTL("website"), r.ExchangeCodeTTL("website"))
+	}
+	if r.StateTTL("unknown") != 0 || r.ExchangeCodeTTL("admin") != 0 {
+		t.Error("expected other clients to use the defaults")
+	}
+}
+
+func TestGuestLifetime(t *testing.T) {
+	clients := testClients()
+	clients[0].GuestLifetime = time.Hour
+	r, _ := NewRegistry(clients)
+
+	if got := r.GuestLifetime("website"); got != time.Hour {
+		t.Errorf("GuestLifetime(website) = %v, want 1h", got)
+	}
+	if r.GuestLifetime("admin") != 0 || r.GuestLifetime("unknown") != 0 {
+		t.Error("expected other clients to use the default")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	clients := testClients()
+	clients[0].RateLimit = domain.RateLimit{Requests: 10, Per: time.Second}
+	r, _ := NewRegistry(clients)
+	def := domain.RateLimit{Requests: 100, Per: time.Minute}
+	r.SetRateLimit(def)
+
+	if got := r.RateLimit("website"); got != clients[0].RateLimit {
+		t.Errorf("RateLimit(website) = %v, want 10/1s", got)
+	}
+	if r.RateLimit("admin") != def || r.RateLimit("unknown") != def {
+		t.Error("expected other clients to use the default")
+	}
+}
+
+func TestDisableAndEnable(t *testing.T) {
+	now := time.Now()
+	r, _ := NewRegistry(testClients())
+	r.SetNow(func() time.Time { return now })
+
+	s, err := r.Disable("website", "alice")
+	if err != nil || s.DisabledBy != "alice" || !s.DisabledAt.Equal(now) {
+		t.Fatalf("Disable = %+v, %v", s, err)
+	}
+	if _, err := r.Get("website"); !errors.Is(err, domain.ErrClientDisabled) {
+		t.Errorf("Get = %v, want ErrClientDisabled", err)
+	}
+	if _, err := r.GetByAPIKey("web-api-key-secret"); !errors.Is(err, domain.ErrClientDisabled) {
+		t.Errorf("GetByAPIKey = %v, want ErrClientDisabled", err)
+	}
+	if again, _ := r.Disable("website", "bob"); again.DisabledBy != "alice" {
+		t.Errorf("expected disabling again to keep the first suspension, got %+v", again)
+	}
+
+	// Suspensions survive a reload
+	if err := r.Replace(testClients()); err != nil {
+		t.Fatalf("Replace error: %v", err)
+	}
+	if _, err := r.Get("website"); !errors.Is(err, domain.ErrClientDisabled) {
+		t.Errorf("expected the client to stay disabled after Replace, got %v", err)
+	}
+	if got := r.Suspended(); len(got) != 1 || got[0].ClientID != "website" {
+		t.Errorf("Suspended = %+v", got)
+	}
+
+	if err := r.Enable("website"); err != nil {
+		t.Fatalf("Enable error: %v", err)
+	}
+	if _, err := r.GetByAPIKey("web-api-key-secret"); err != nil {
+		t.Errorf("expected the client to be enabled, got %v", err)
+	}
+	if _, err := r.Disable("unknown", "alice"); !errors.Is(err, domain.ErrClientNotFound) {
+		t.Errorf("Disable(unknown) = %v, want ErrClientNotFound", err)
+	}
+}
+
+func TestEnable_DisabledInSource(t *testing.T) {
+	clients := testClients()
+	clients[0].Disabled = true
+	r, _ := NewRegistry(clients)
+
+	if err := r.Enable("website"); !errors.Is(err, domain.ErrClientDisabled) {
+		t.Errorf("Enable = %v, want ErrClientDisabled", err)
+	}
+	if _, err := r.Get("admin"); err != nil {
+		t.Errorf("expected other clients to be unaffected, got %v", err)
+	}
+}
+
+func TestGetByAPIKey_SecondaryKey(t *testing.T) {
+	r, _ := NewRegistry([]domain.ClientApp{{ID: "website", APIKey: "new-key", SecondaryAPIKey: "old-key"}})
+
+	for _, key := range []string{"new-key", "old-key"} {
+		if c, err := r.GetByAPIKey(key); err != nil || c.ID != "website" {
+			t.Errorf("GetByAPIKey(%q) = %v, %v", key, c, err)
+		}
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	now := time.Now()
+	r, _ := NewRegistry(testClients())
+	r.SetNow(func() time.Time { return now })
+
+	rot, err := r.RotateKey("website", "web-api-key-2", "alice", time.Hour)
+	if err != nil {
+		t.Fatalf("RotateKey error: %v", err)
+	}
+	if rot.RotatedBy != "alice" || !rot.GraceUntil.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected rotation: %+v", rot)
+	}
+	if c, _ := r.Get("website"); c.APIKey != HashAPIKey("web-api-key-2") || c.SecondaryAPIKey != "web-api-key-secret" {
+		t.Errorf("unexpected keys after rotation: %q, %q", c.APIKey, c.SecondaryAPIKey)
+	}
+	if _, err := r.GetByAPIKey("web-api-key-secret"); err != nil {
+		t.Errorf("expected the old key to work during the grace period, got %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := r.GetByAPIKey("web-api-key-secret"); !errors.Is(err, domain.ErrInvalidAPIKey) {
+		t.Errorf("expected the old key to expire, got %v", err)
+	}
+	if _, err := r.GetByAPIKey("web-api-key-2"); err != nil {
+		t.Errorf("expected the new key to work, got %v", err)
+	}
+}
+
+func TestRotateKey_Errors(t *testing.T) {
+	r, _ := NewRegistry(testClients())
+
+	if _, err := r.RotateKey("nope", "key", "admin", 0); !errors.Is(err, domain.ErrClientNotFound) {
+		t.Errorf("expected ErrClientNotFound, got %v", err)
+	}
+	if _, err := r.RotateKey("website", "web-api-key-secret", "admin", 0); !errors.Is(err, domain.ErrAPIKeyInUse) {
+		t.Errorf("expected ErrAPIKeyInUse for the current key, got %v", err)
+	}
+	if _, err := r.RotateKey("website", "", "admin", 0); !errors.Is(err, domain.ErrInvalidAPIKey) {
+		t.Errorf("expected ErrInvalidAPIKey for an empty key, got %v", err)
+	}
+}
+
+func TestPublicClient(t *testing.T) {
+	r, err := NewRegistry(append(testClients(),
+		domain.ClientApp{ID: "spa", Name: "SPA", Public: true},
+		domain.ClientApp{ID: "mobile", Name: "Mobile", Public: true},
+	))
+	if err != nil {
+		t.Fatalf("NewRegistry error: %v", err)
+	}
+	if c, err := r.Get("spa"); err != nil || !c.Public {
+		t.Errorf("Get = %+v, %v", c, err)
+	}
+	if _, err := r.GetByAPIKey(""); !errors.Is(err, domain.ErrInvalidAPIKey) {
+		t.Errorf("expected no client for an empty key, got %v", err)
+	}
+	if _, err := r.RotateKey("spa", "spa-key", "admin", 0); !errors.Is(err, domain.ErrPublicClient) {
+		t.Errorf("expected ErrPublicClient, got %v", err)
+	}
+}
+
+func TestRotateKey_SurvivesReplace(t *testing.T) {
+	r, _ := NewRegistry(testClients())
+	if _, err := r.RotateKey("website", "web-api-key-2", "admin", 0); err != nil {
+		t.Fatalf("RotateKey error: %v", err)
+	}
+
+	r.Replace(testClients())
+	if _, err := r.GetByAPIKey("web-api-key-2"); err != nil {
+		t.Errorf("expected the rotation to survive a reload, got %v", err)
+	}
+
+	// Once the key is changed in the source, the source is authoritative again
+	updated := testClients()
+	updated[0].APIKey = HashAPIKey("web-api-key-2")
+	r.Replace(updated)
+	if c, _ := r.Get("website"); c.APIKey != updated[0].APIKey || c.SecondaryAPIKey != "" {
+		t.Errorf("expected the source's keys once it caught up, got %q, %q", c.APIKey, c.SecondaryAPIKey)
+	}
+}
+
+func TestGetByAPIKey_ManyClients(t *testing.T) {
+	clients := make([]domain.ClientApp, 1000)
+	for i := range clients {
+		clients[i] = domain.ClientApp{ID: fmt.Sprintf("client-%d", i), APIKey: fmt.Sprintf("key-%d", i)}
+	}
+	clients[500].APIKey = HashAPIKey("key-500")
+	r, err := NewRegistry(clients)
+	if err != nil {
+		t.Fatalf("NewRegistry error: %v", err)
+	}
+
+	for _, i := range []int{0, 500, 999} {
+		c, err := r.GetByAPIKey(fmt.Sprintf("key-%d", i))
+		if err != nil || c.ID != clients[i].ID {
+			t.Errorf("GetByAPIKey(key-%d) = %v, %v", i, c, err)
+		}
+	}
+	if _, err := r.GetByAPIKey("key-1000"); !errors.Is(err, domain.ErrInvalidAPIKey) {
+		t.Errorf("expected ErrInvalidAPIKey, got %v", err)
+	}
+}
+
+func TestReplace_SharedAPIKey(t *testing.T) {
+	r, _ := NewRegistry(testClients())
+
+	// A hash and the plain key are the same key
+	err := r.Replace([]domain.ClientApp{
+		{ID: "website", APIKey: "shared-key"},
+		{ID: "game", APIKey: "other-key", SecondaryAPIKey: HashAPIKey("shared-key")},
+	})
+	if !errors.Is(err, domain.ErrAPIKeyInUse) {
+		t.Errorf("expected ErrAPIKeyInUse, got %v", err)
+	}
+	if _, err := r.GetByAPIKey("web-api-key-secret"); err != nil {
+		t.Errorf("expected the previous clients to be kept, got %v", err)
+	}
+}
+
+func TestGetByCertificate(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("game-cert")}
+	clients := testClients()
+	clients[0].CertFingerprints = []string{CertFingerprint(cert)}
+	r, _ := NewRegistry(clients)
+
+	c, err := r.GetByCertificate(cert)
+	if err != nil || c.ID != "website" {
+		t.Fatalf("GetByCertificate = %+v, %v", c, err)
+	}
+	if _, err := r.GetByCertificate(&x509.Certificate{Raw: []byte("other")}); !errors.Is(err, domain.ErrClientNotFound) {
+		t.Errorf("unknown certificate: %v, want ErrClientNotFound", err)
+	}
+	if _, err := r.Disable("website", "alice"); err != nil {
+		t.Fatalf("Disable error: %v", err)
+	}
+	if _, err := r.GetByCertificate(cert); !errors.Is(err, domain.ErrClientDisabled) {
+		t.Errorf("disabled client: %v, want ErrClientDisabled", err)
+	}
+}
+
+func TestReplace_SharedCertificate(t *testing.T) {
+	r, _ := NewRegistry(testClients())
+
+	fp := CertFingerprint(&x509.Certificate{Raw: []byte("shared-cert")})
+	err := r.Replace([]domain.ClientApp{
+		{ID: "website", APIKey: "web-key", CertFingerprints: []string{fp}},
+		{ID: "game", APIKey: "game-key", CertFingerprints: []string{fp}},
+	})
+	if !errors.Is(err, domain.ErrCertificateInUse) {
+		t.Errorf("expected ErrCertificateInUse, got %v", err)
+	}
+}
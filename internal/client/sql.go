@@ -0,0 +1,258 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/scope"
+	"github.com/BlackMission/centralauth/internal/sqldb"
+)
+
+// clientsSchema creates the clients table. List columns hold JSON arrays and
+// durations are strings such as "10m"; empty means the service default.
+var clientsSchema = []string{
+	`CREATE TABLE clients (
+		id                      TEXT PRIMARY KEY,
+		name                    TEXT NOT NULL DEFAULT '',
+		api_key                 TEXT NOT NULL,
+		allowed_callbacks       TEXT NOT NULL DEFAULT '[]',
+		allowed_providers       TEXT NOT NULL DEFAULT '[]',
+		key_version             TEXT NOT NULL DEFAULT '',
+		require_captcha         BOOLEAN NOT NULL DEFAULT FALSE,
+		allow_lookup            BOOLEAN NOT NULL DEFAULT FALSE,
+		include_raw             BOOLEAN NOT NULL DEFAULT FALSE,
+		allow_token_passthrough BOOLEAN NOT NULL DEFAULT FALSE,
+		guest_lifetime          TEXT NOT NULL DEFAULT '',
+		state_ttl               TEXT NOT NULL DEFAULT '',
+		exchange_code_ttl       TEXT NOT NULL DEFAULT '',
+		disabled                BOOLEAN NOT NULL DEFAULT FALSE
+	)`,
+	`ALTER TABLE clients ADD COLUMN secondary_api_key TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE clients ADD COLUMN rate_limit TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE clients ADD COLUMN strip_fields TEXT NOT NULL DEFAULT '[]'`,
+	`ALTER TABLE clients ADD COLUMN refresh_token_ttl TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE clients ADD COLUMN allow_service_tokens BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE clients ADD COLUMN backchannel_logout_uri TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE clients ADD COLUMN public BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE clients ADD COLUMN allowed_ips TEXT NOT NULL DEFAULT '[]'`,
+	`ALTER TABLE clients ADD COLUMN cert_fingerprints TEXT NOT NULL DEFAULT '[]'`,
+	`ALTER TABLE clients ADD COLUMN blocked_countries TEXT NOT NULL DEFAULT '[]'`,
+}
+
+const clientColumns = `id, name, api_key, allowed_callbacks, allowed_providers, key_version,
+	require_captcha, allow_lookup, include_raw, allow_token_passthrough,
+	guest_lifetime, state_ttl, exchange_code_ttl, secondary_api_key, rate_limit, disabled,
+	strip_fields, refresh_token_ttl, allow_service_tokens, backchannel_logout_uri, public,
+	allowed_ips, cert_fingerprints, blocked_countries`
+
+// SQLStore is a Store backed by the clients table of a Postgres or SQLite
+// database. Rows can be inserted, updated, or disabled with plain SQL and
+// take effect at the next reload.
+type SQLStore struct {
+	db *sqldb.DB
+}
+
+// NewSQLStore returns a store for db, creating the clients table if needed.
+func NewSQLStore(ctx context.Context, db *sqldb.DB) (*SQLStore, error) {
+	if err := db.Migrate(ctx, "clients", clientsSchema); err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: db}, nil
+}
+
+// Load returns the clients, with the disabled ones marked Disabled.
+func (s *SQLStore) Load(ctx context.Context) ([]domain.ClientApp, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+clientColumns+` FROM clients ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("reading clients table: %w", err)
+	}
+	defer rows.Close()
+
+	var clients []domain.ClientApp
+	for rows.Next() {
+		var (
+			c                                        domain.ClientApp
+			callbacks, providers, stripFields        string
+			allowedIPs, certFingerprints, countries  string
+			guestLifetime, stateTTL, exchangeCodeTTL string
+			refreshTokenTTL, rateLimit               string
+		)
+		err := rows.Scan(&c.ID, &c.Name, &c.APIKey, &callbacks, &providers, &c.KeyVersion,
+			&c.RequireCaptcha, &c.AllowLookup, &c.IncludeRaw, &c.AllowTokenPassthrough,
+			&guestLifetime, &stateTTL, &exchangeCodeTTL, &c.SecondaryAPIKey, &rateLimit, &c.Disabled,
+			&stripFields, &refreshTokenTTL, &c.AllowServiceTokens, &c.BackchannelLogoutURI, &c.Public,
+			&allowedIPs, &certFingerprints, &countries)
+		if err != nil {
+			return nil, fmt.Errorf("reading clients table: %w", err)
+		}
+		if c.Public && (c.APIKey != "" || c.SecondaryAPIKey != "") {
+			return nil, fmt.Errorf("reading clients table: public client %q has an api_key", c.ID)
+		}
+		if c.APIKey == "" && !c.Public {
+			return nil, fmt.Errorf("reading clients table: client %q has no api_key", c.ID)
+		}
+		if c.Name == "" {
+			c.Name = c.ID
+		}
+		if c.AllowedCallbacks, err = decodeList(c.ID, "allowed_callbacks", callbacks); err != nil {
+			return nil, err
+		}
+		if c.AllowedProviders, err = decodeList(c.ID, "allowed_providers", providers); err != nil {
+			return nil, err
+		}
+		if c.StripFields, err = decodeList(c.ID, "strip_fields", stripFields); err != nil {
+			return nil, err
+		}
+		if err := scope.CheckFields(c.StripFields); err != nil {
+			return nil, fmt.Errorf("reading clients table: client %q has an invalid strip_fields: %w", c.ID, err)
+		}
+		ips, err := decodeList(c.ID, "allowed_ips", allowedIPs)
+		if err != nil {
+			return nil, err
+		}
+		if c.AllowedIPs, err = parsePrefixes(c.ID, ips); err != nil {
+			return nil, fmt.Errorf("reading clients table: %w", err)
+		}
+		fingerprints, err := decodeList(c.ID, "cert_fingerprints", certFingerprints)
+		if err != nil {
+			return nil, err
+		}
+		if c.CertFingerprints, err = parseFingerprints(c.ID, fingerprints); err != nil {
+			return nil, fmt.Errorf("reading clients table: %w", err)
+		}
+		blocked, err := decodeList(c.ID, "blocked_countries", countries)
+		if err != nil {
+			return nil, err
+		}
+		if c.BlockedCountries, err = parseCountries(c.ID, blocked); err != nil {
+			return nil, fmt.Errorf("reading clients table: %w", err)
+		}
+		for _, d := range []struct {
+			field string
+			v     string
+			dst   *time.Duration
+		}{
+			{"guest_lifetime", guestLifetime, &c.GuestLifetime},
+			{"state_ttl", stateTTL, &c.StateTTL},
+			{"exchange_code_ttl", exchangeCodeTTL, &c.ExchangeCodeTTL},
+			{"refresh_token_ttl", refreshTokenTTL, &c.RefreshTokenTTL},
+		} {
+			if *d.dst, err = parseDuration(c.ID, d.field, d.v); err != nil {
+				return nil, fmt.Errorf("reading clients table: %w", err)
+			}
+		}
+		if c.RateLimit, err = parseRateLimit(c.ID, rateLimit); err != nil {
+			return nil, fmt.Errorf("reading clients table: %w", err)
+		}
+		clients = append(clients, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading clients table: %w", err)
+	}
+	return clients, nil
+}
+
+// Seed inserts the clients whose IDs aren't in the table yet and returns how
+// many it inserted. Existing rows are left alone, so edits made in the
+// database win over the seed.
+func (s *SQLStore) Seed(ctx context.Context, clients []domain.ClientApp) (int, error) {
+	inserted := 0
+	for _, c := range clients {
+		callbacks, err := encodeList(c.AllowedCallbacks)
+		if err != nil {
+			return inserted, err
+		}
+		providers, err := encodeList(c.AllowedProviders)
+		if err != nil {
+			return inserted, err
+		}
+		stripFields, err := encodeList(c.StripFields)
+		if err != nil {
+			return inserted, err
+		}
+		allowedIPs, err := encodeList(formatPrefixes(c.AllowedIPs))
+		if err != nil {
+			return inserted, err
+		}
+		certFingerprints, err := encodeList(c.CertFingerprints)
+		if err != nil {
+			return inserted, err
+		}
+		blockedCountries, err := encodeList(c.BlockedCountries)
+		if err != nil {
+			return inserted, err
+		}
+		res, err := s.db.Exec(ctx, `INSERT INTO clients (`+clientColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO NOTHING`,
+			c.ID, c.Name, c.APIKey, callbacks, providers, c.KeyVersion,
+			c.RequireCaptcha, c.AllowLookup, c.IncludeRaw, c.AllowTokenPassthrough,
+			formatDuration(c.GuestLifetime), formatDuration(c.StateTTL), formatDuration(c.ExchangeCodeTTL), c.SecondaryAPIKey,
+			formatRateLimit(c.RateLimit), c.Disabled, stripFields, formatDuration(c.RefreshTokenTTL), c.AllowServiceTokens,
+			c.BackchannelLogoutURI, c.Public, allowedIPs, certFingerprints, blockedCountries)
+		if err != nil {
+			return inserted, fmt.Errorf("seeding client %q: %w", c.ID, err)
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			inserted += int(n)
+		}
+	}
+	return inserted, nil
+}
+
+// Close closes the database.
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLStore) String() string {
+	return s.db.Dialect() + " clients table"
+}
+
+func decodeList(id, field, v string) ([]string, error) {
+	if v == "" {
+		return nil, nil
+	}
+	var list []string
+	if err := json.Unmarshal([]byte(v), &list); err != nil {
+		return nil, fmt.Errorf("reading clients table: client %q has an invalid %s: %w", id, field, err)
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return list, nil
+}
+
+func encodeList(list []string) (string, error) {
+	if len(list) == 0 {
+		return "[]", nil
+	}
+	b, err := json.Marshal(list)
+	return string(b), err
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func formatPrefixes(prefixes []netip.Prefix) []string {
+	list := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		list[i] = p.String()
+	}
+	return list
+}
+
+func formatRateLimit(l domain.RateLimit) string {
+	if l.IsZero() {
+		return ""
+	}
+	return l.String()
+}
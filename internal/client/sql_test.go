@@ -0,0 +1,221 @@
+package client
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/sqldb"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+// clientsTable fakes a database holding the clients table. Rows hold the
+// values of clientColumns.
+type clientsTable struct {
+	rows map[string][]driver.Value
+}
+
+func newClientsTable(t *testing.T) (*clientsTable, *SQLStore) {
+	t.Helper()
+	tbl := &clientsTable{rows: map[string][]driver.Value{}}
+	db := sqldb.New(testutil.OpenSQL(t, tbl.handle), sqldb.SQLite)
+	s, err := NewSQLStore(context.Background(), db)
+	if err != nil {
+		t.Fatalf("NewSQLStore error: %v", err)
+	}
+	return tbl, s
+}
+
+// insert adds a row with the given id and api_key and no other settings.
+func (tbl *clientsTable) insert(id, apiKey string) []driver.Value {
+	row := []driver.Value{id, "", apiKey, "[]", "[]", "", false, false, false, false, "", "", "", "", "", false, "[]", "", false, "", false, "[]", "[]", "[]"}
+	tbl.rows[id] = row
+	return row
+}
+
+func (tbl *clientsTable) handle(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT version"):
+		return []string{"version"}, nil, nil
+	case strings.HasPrefix(query, "SELECT"):
+		ids := make([]string, 0, len(tbl.rows))
+		for id := range tbl.rows {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		rows := make([][]driver.Value, len(ids))
+		for i, id := range ids {
+			rows[i] = tbl.rows[id]
+		}
+		return strings.Split(strings.Join(strings.Fields(clientColumns), ""), ","), rows, nil
+	case strings.HasPrefix(query, "INSERT INTO clients"):
+		id := args[0].(string)
+		if _, exists := tbl.rows[id]; exists {
+			return nil, nil, nil
+		}
+		tbl.rows[id] = args
+		return nil, [][]driver.Value{{}}, nil
+	}
+	return nil, nil, nil
+}
+
+func TestSQLStore_Load(t *testing.T) {
+	tbl, s := newClientsTable(t)
+	row := tbl.insert("game", "game-key")
+	row[4] = `["steam","discord"]`
+	row[7] = true
+	row[11] = "10m"
+	row[16] = `["email"]`
+	row[17] = "720h"
+	row[18] = true
+	row[19] = "https://game.example.com/logout"
+	row[21] = `["203.0.113.0/24","2001:db8::1"]`
+	row[22] = `["AB:` + strings.Repeat("cd:", 30) + `EF"]`
+	row[23] = `["ru", "BY"]`
+	tbl.insert("old", "old-key")[15] = true
+	tbl.insert("spa", "")[20] = true
+
+	clients, err := s.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if len(clients) != 3 {
+		t.Fatalf("expected 3 clients, got %+v", clients)
+	}
+	if !clients[2].Public || clients[2].APIKey != "" {
+		t.Errorf("expected a public client without a key, got %+v", clients[2])
+	}
+	if !clients[1].Disabled {
+		t.Errorf("expected the disabled row to load as disabled, got %+v", clients[1])
+	}
+	c := clients[0]
+	if c.Disabled {
+		t.Error("expected the other client to be enabled")
+	}
+	if c.Name != "game" || c.APIKey != "game-key" {
+		t.Errorf("unexpected client: %+v", c)
+	}
+	if !slices.Equal(c.AllowedProviders, []string{"steam", "discord"}) || c.AllowedCallbacks != nil {
+		t.Errorf("unexpected lists: %v, %v", c.AllowedCallbacks, c.AllowedProviders)
+	}
+	if !c.AllowLookup || c.StateTTL != 10*time.Minute || !slices.Equal(c.StripFields, []string{"email"}) || c.RefreshTokenTTL != 720*time.Hour || !c.AllowServiceTokens ||
+		c.BackchannelLogoutURI != "https://game.example.com/logout" {
+		t.Errorf("unexpected settings: %+v", c)
+	}
+	if want := []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24"), netip.MustParsePrefix("2001:db8::1/128")}; !slices.Equal(c.AllowedIPs, want) {
+		t.Errorf("AllowedIPs = %v, want %v", c.AllowedIPs, want)
+	}
+	if want := "ab" + strings.Repeat("cd", 30) + "ef"; !slices.Equal(c.CertFingerprints, []string{want}) {
+		t.Errorf("CertFingerprints = %v, want [%s]", c.CertFingerprints, want)
+	}
+	if !slices.Equal(c.BlockedCountries, []string{"RU", "BY"}) {
+		t.Errorf("BlockedCountries = %v", c.BlockedCountries)
+	}
+}
+
+func TestSQLStore_LoadInvalidRow(t *testing.T) {
+	tbl, s := newClientsTable(t)
+	row := tbl.insert("game", "game-key")
+	row[3] = "not json"
+	if _, err := s.Load(context.Background()); err == nil {
+		t.Error("expected an error for invalid allowed_callbacks")
+	}
+
+	row[3] = "[]"
+	row[10] = "soon"
+	if _, err := s.Load(context.Background()); err == nil {
+		t.Error("expected an error for an invalid guest_lifetime")
+	}
+
+	row[10] = ""
+	row[16] = `["phone"]`
+	if _, err := s.Load(context.Background()); err == nil {
+		t.Error("expected an error for an unknown strip_fields entry")
+	}
+
+	row[16] = "[]"
+	row[21] = `["backend"]`
+	if _, err := s.Load(context.Background()); err == nil {
+		t.Error("expected an error for an invalid allowed_ips entry")
+	}
+
+	row[21] = "[]"
+	row[22] = `["abcd"]`
+	if _, err := s.Load(context.Background()); err == nil {
+		t.Error("expected an error for an invalid cert_fingerprints entry")
+	}
+
+	row[22] = "[]"
+	row[23] = `["Russia"]`
+	if _, err := s.Load(context.Background()); err == nil {
+		t.Error("expected an error for an invalid blocked_countries entry")
+	}
+
+	row[23] = "[]"
+	row[20] = true
+	if _, err := s.Load(context.Background()); err == nil {
+		t.Error("expected an error for a public client with an api_key")
+	}
+}
+
+func TestSQLStore_Seed(t *testing.T) {
+	tbl, s := newClientsTable(t)
+	tbl.insert("website", "edited-key")
+
+	n, err := s.Seed(context.Background(), []domain.ClientApp{
+		{ID: "website", Name: "website", APIKey: "env-key"},
+		{ID: "game", Name: "Game", APIKey: "game-key", AllowedProviders: []string{"steam"}, GuestLifetime: 2 * time.Hour, Disabled: true,
+			AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+	})
+	if err != nil {
+		t.Fatalf("Seed error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 client inserted, got %d", n)
+	}
+
+	clients, err := s.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if len(clients) != 2 {
+		t.Fatalf("expected 2 clients, got %+v", clients)
+	}
+	game, website := clients[0], clients[1]
+	if website.APIKey != "edited-key" {
+		t.Errorf("expected the existing row to win, got %q", website.APIKey)
+	}
+	if game.Name != "Game" || game.GuestLifetime != 2*time.Hour || !slices.Equal(game.AllowedProviders, []string{"steam"}) || !game.Disabled ||
+		!slices.Equal(game.AllowedIPs, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}) {
+		t.Errorf("seeded client didn't round-trip: %+v", game)
+	}
+}
+
+func TestWatcher_SQLStore(t *testing.T) {
+	ctx := context.Background()
+	tbl, s := newClientsTable(t)
+	tbl.insert("game", "game-key")
+
+	r, _ := NewRegistry(nil)
+	w := NewWatcher(r, s, 0, nil)
+	if _, err := w.Reload(ctx); err != nil {
+		t.Fatalf("Reload error: %v", err)
+	}
+	if changed, _ := w.Reload(ctx); changed {
+		t.Error("expected no swap when the table hasn't changed")
+	}
+
+	tbl.rows["game"][15] = true
+	if changed, err := w.Reload(ctx); err != nil || !changed {
+		t.Fatalf("Reload after disabling = %v, %v; want true, nil", changed, err)
+	}
+	if _, err := r.Get("game"); !errors.Is(err, domain.ErrClientDisabled) {
+		t.Errorf("expected the client to be disabled, got %v", err)
+	}
+}
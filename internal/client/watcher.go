@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+const defaultReloadInterval = 10 * time.Second
+
+// Store is a source of client apps that can change while the service runs,
+// such as a clients file or a database table.
+type Store interface {
+	// Load returns the clients currently in the store.
+	Load(ctx context.Context) ([]domain.ClientApp, error)
+
+	// String describes the store in log messages.
+	String() string
+}
+
+// Watcher polls a Store and reloads the registry when its clients change, so
+// edits reach every replica within one interval without a restart.
+type Watcher struct {
+	registry *Registry
+	store    Store
+	interval time.Duration
+
+	mu     sync.Mutex
+	static []domain.ClientApp
+	last   []domain.ClientApp
+	loaded bool
+}
+
+// NewWatcher creates a watcher for store. Clients in static (e.g. those
+// configured through the environment) are kept alongside the store's clients.
+// A zero interval defaults to 10 seconds.
+func NewWatcher(registry *Registry, store Store, interval time.Duration, static []domain.ClientApp) *Watcher {
+	if interval <= 0 {
+		interval = defaultReloadInterval
+	}
+	return &Watcher{
+		registry: registry,
+		store:    store,
+		interval: interval,
+		static:   static,
+	}
+}
+
+// Reload loads the store and replaces the registry contents if its clients
+// have changed since the last successful reload. It reports whether a swap
+// happened.
+func (w *Watcher) Reload(ctx context.Context) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reload(ctx)
+}
+
+// SetStatic replaces the clients kept alongside the store's clients and
+// reloads the registry with them, whether or not the store has changed.
+func (w *Watcher) SetStatic(ctx context.Context, static []domain.ClientApp) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.static = static
+	w.loaded = false
+	_, err := w.reload(ctx)
+	return err
+}
+
+func (w *Watcher) reload(ctx context.Context) (bool, error) {
+	fromStore, err := w.store.Load(ctx)
+	if err != nil {
+		return false, err
+	}
+	if w.loaded && reflect.DeepEqual(fromStore, w.last) {
+		return false, nil
+	}
+
+	clients := make([]domain.ClientApp, 0, len(w.static)+len(fromStore))
+	clients = append(clients, w.static...)
+	clients = append(clients, fromStore...)
+	if err := w.registry.Replace(clients); err != nil {
+		return false, err
+	}
+
+	w.last, w.loaded = fromStore, true
+	return true, nil
+}
+
+// Run polls until ctx is cancelled. Failed reloads are logged and the
+// previous registry contents stay in effect.
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := w.Reload(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "clients: reload failed", "store", w.store.String(), "error", err)
+				continue
+			}
+			if changed {
+				slog.InfoContext(ctx, "clients: reloaded", "store", w.store.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func writeClientsFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write clients file: %v", err)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key","allowed_providers":["steam"]}]`)
+
+	clients, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile error: %v", err)
+	}
+	if len(clients) != 1 || clients[0].APIKey != "game-key" {
+		t.Fatalf("unexpected clients: %+v", clients)
+	}
+	if clients[0].Name != "game" {
+		t.Errorf("expected name to default to ID, got %q", clients[0].Name)
+	}
+}
+
+func TestLoadFile_MissingAPIKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"game"}]`)
+
+	if _, err := LoadFile(path); err == nil {
+		t.Fatal("expected error for client without api_key")
+	}
+}
+
+func TestLoadFile_GuestLifetime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key","guest_lifetime":"2h"}]`)
+
+	clients, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile error: %v", err)
+	}
+	if clients[0].GuestLifetime != 2*time.Hour {
+		t.Errorf("GuestLifetime = %v, want 2h", clients[0].GuestLifetime)
+	}
+
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key","guest_lifetime":"soon"}]`)
+	if _, err := LoadFile(path); err == nil {
+		t.Error("expected error for an invalid guest_lifetime")
+	}
+}
+
+func TestLoadFile_TokenTTLs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key","state_ttl":"10m","exchange_code_ttl":"1m","refresh_token_ttl":"720h"}]`)
+
+	clients, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile error: %v", err)
+	}
+	if clients[0].StateTTL != 10*time.Minute || clients[0].ExchangeCodeTTL != time.Minute {
+		t.Errorf("got %v and %v, want 10m and 1m", clients[0].StateTTL, clients[0].ExchangeCodeTTL)
+	}
+	if clients[0].RefreshTokenTTL != 720*time.Hour {
+		t.Errorf("RefreshTokenTTL = %v, want 720h", clients[0].RefreshTokenTTL)
+	}
+
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key","exchange_code_ttl":"-1s"}]`)
+	if _, err := LoadFile(path); err == nil {
+		t.Error("expected error for a negative exchange_code_ttl")
+	}
+}
+
+func TestLoadFile_SecondaryAPIKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"game","api_key":"new-key","secondary_api_key":"old-key"}]`)
+
+	clients, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile error: %v", err)
+	}
+	if clients[0].SecondaryAPIKey != "old-key" {
+		t.Errorf("SecondaryAPIKey = %q, want old-key", clients[0].SecondaryAPIKey)
+	}
+}
+
+func TestLoadFile_RateLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key","rate_limit":"10/s"}]`)
+
+	clients, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile error: %v", err)
+	}
+	if want := (domain.RateLimit{Requests: 10, Per: time.Second}); clients[0].RateLimit != want {
+		t.Errorf("RateLimit = %v, want %v", clients[0].RateLimit, want)
+	}
+
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key","rate_limit":"0/s"}]`)
+	if _, err := LoadFile(path); err == nil {
+		t.Error("expected error for an invalid rate_limit")
+	}
+}
+
+func TestLoadFile_StripFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"kiosk","api_key":"kiosk-key","strip_fields":["email","avatar_url"]}]`)
+
+	clients, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile error: %v", err)
+	}
+	if want := []string{"email", "avatar_url"}; !slices.Equal(clients[0].StripFields, want) {
+		t.Errorf("StripFields = %v, want %v", clients[0].StripFields, want)
+	}
+
+	writeClientsFile(t, path, `[{"id":"kiosk","api_key":"kiosk-key","strip_fields":["provider_id"]}]`)
+	if _, err := LoadFile(path); err == nil {
+		t.Error("expected error for an unknown strip_fields entry")
+	}
+}
+
+func TestLoadFile_AllowedIPs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key","allowed_ips":["203.0.113.9/24","2001:db8::1"]}]`)
+
+	clients, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile error: %v", err)
+	}
+	if want := []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24"), netip.MustParsePrefix("2001:db8::1/128")}; !slices.Equal(clients[0].AllowedIPs, want) {
+		t.Errorf("AllowedIPs = %v, want %v", clients[0].AllowedIPs, want)
+	}
+
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key","allowed_ips":["game-server.internal"]}]`)
+	if _, err := LoadFile(path); err == nil {
+		t.Error("expected error for an allowed_ips entry that isn't an address")
+	}
+}
+
+func TestLoadFile_CertFingerprints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	fp := strings.Repeat("AB:", 31) + "CD"
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key","cert_fingerprints":["`+fp+`"]}]`)
+
+	clients, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile error: %v", err)
+	}
+	if want := strings.Repeat("ab", 31) + "cd"; !slices.Equal(clients[0].CertFingerprints, []string{want}) {
+		t.Errorf("CertFingerprints = %v, want [%s]", clients[0].CertFingerprints, want)
+	}
+
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key","cert_fingerprints":["md5:abcd"]}]`)
+	if _, err := LoadFile(path); err == nil {
+		t.Error("expected error for a cert_fingerprints entry that isn't a SHA-256 digest")
+	}
+}
+
+func TestLoadFile_BlockedCountries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key","blocked_countries":["ru"," by "]}]`)
+
+	clients, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile error: %v", err)
+	}
+	if !slices.Equal(clients[0].BlockedCountries, []string{"RU", "BY"}) {
+		t.Errorf("BlockedCountries = %v", clients[0].BlockedCountries)
+	}
+
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key","blocked_countries":["RUS"]}]`)
+	if _, err := LoadFile(path); err == nil {
+		t.Error("expected error for a blocked_countries entry that isn't a two-letter code")
+	}
+}
+
+func TestLoadFile_Enabled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key","enabled":false},{"id":"web","api_key":"web-key","enabled":true},{"id":"app","api_key":"app-key"}]`)
+
+	clients, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile error: %v", err)
+	}
+	if !clients[0].Disabled || clients[1].Disabled || clients[2].Disabled {
+		t.Errorf("got disabled = %v, %v, %v; want true, false, false", clients[0].Disabled, clients[1].Disabled, clients[2].Disabled)
+	}
+}
+
+func TestLoadFile_AllowLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"bot","api_key":"bot-key","allow_lookup":true},{"id":"game","api_key":"game-key"}]`)
+
+	clients, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile error: %v", err)
+	}
+	if !clients[0].AllowLookup || clients[1].AllowLookup {
+		t.Errorf("unexpected AllowLookup values: %v, %v", clients[0].AllowLookup, clients[1].AllowLookup)
+	}
+}
+
+func TestLoadFile_IncludeRaw(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"tools","api_key":"tools-key","include_raw":true},{"id":"game","api_key":"game-key"}]`)
+
+	clients, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile error: %v", err)
+	}
+	if !clients[0].IncludeRaw || clients[1].IncludeRaw {
+		t.Errorf("unexpected IncludeRaw values: %v, %v", clients[0].IncludeRaw, clients[1].IncludeRaw)
+	}
+}
+
+func TestLoadFile_AllowTokenPassthrough(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"bot","api_key":"bot-key","allow_token_passthrough":true},{"id":"game","api_key":"game-key"}]`)
+
+	clients, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile error: %v", err)
+	}
+	if !clients[0].AllowTokenPassthrough || clients[1].AllowTokenPassthrough {
+		t.Errorf("unexpected AllowTokenPassthrough values: %v, %v", clients[0].AllowTokenPassthrough, clients[1].AllowTokenPassthrough)
+	}
+}
+
+func TestWatcher_Reload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key"}]`)
+
+	static := []domain.ClientApp{{ID: "website", APIKey: "web-key"}}
+	r, _ := NewRegistry(static)
+	w := NewWatcher(r, FileStore{path}, 0, static)
+
+	changed, err := w.Reload(ctx)
+	if err != nil || !changed {
+		t.Fatalf("first Reload = %v, %v; want true, nil", changed, err)
+	}
+	if _, err := r.Get("game"); err != nil {
+		t.Errorf("expected game client after reload, got %v", err)
+	}
+	if _, err := r.Get("website"); err != nil {
+		t.Errorf("expected static client to be kept, got %v", err)
+	}
+
+	changed, err = w.Reload(ctx)
+	if err != nil || changed {
+		t.Errorf("unchanged Reload = %v, %v; want false, nil", changed, err)
+	}
+
+	writeClientsFile(t, path, `[{"id":"launcher","api_key":"launcher-key"}]`)
+	if changed, err := w.Reload(ctx); err != nil || !changed {
+		t.Fatalf("Reload after edit = %v, %v; want true, nil", changed, err)
+	}
+	if _, err := r.Get("game"); !errors.Is(err, domain.ErrClientNotFound) {
+		t.Errorf("expected game client to be removed, got %v", err)
+	}
+}
+
+func TestWatcher_InvalidFileKeepsRegistry(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key"}]`)
+
+	r, _ := NewRegistry(nil)
+	w := NewWatcher(r, FileStore{path}, 0, nil)
+	if _, err := w.Reload(ctx); err != nil {
+		t.Fatalf("Reload error: %v", err)
+	}
+
+	writeClientsFile(t, path, `not json`)
+	if _, err := w.Reload(ctx); err == nil {
+		t.Fatal("expected error for invalid file")
+	}
+	if _, err := r.Get("game"); err != nil {
+		t.Errorf("expected previous clients to remain, got %v", err)
+	}
+}
+
+func TestWatcher_SetStatic(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "clients.json")
+	writeClientsFile(t, path, `[{"id":"game","api_key":"game-key"}]`)
+	r, _ := NewRegistry(nil)
+	w := NewWatcher(r, FileStore{path}, 0, []domain.ClientApp{{ID: "website", APIKey: "old-key"}})
+	if _, err := w.Reload(ctx); err != nil {
+		t.Fatalf("Reload error: %v", err)
+	}
+
+	if err := w.SetStatic(ctx, []domain.ClientApp{{ID: "website", APIKey: "new-key"}}); err != nil {
+		t.Fatalf("SetStatic error: %v", err)
+	}
+	if _, err := r.GetByAPIKey("old-key"); err == nil {
+		t.Error("expected the old API key to be rejected")
+	}
+	if _, err := r.GetByAPIKey("new-key"); err != nil {
+		t.Errorf("expected the new API key to work, got %v", err)
+	}
+	if _, err := r.Get("game"); err != nil {
+		t.Errorf("expected the file's clients to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/geoip"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/internal/session"
+)
+
+const (
+	exchangeKeyBytes = 32
+	minSigningKey    = 32
+	minDerivedKey    = 32
+)
+
+// Check looks for mistakes that load accepts but that would break logins
+// later: keys of the wrong length, callback URLs that can't match or aren't
+// HTTPS, and clients allowed providers that aren't configured. clients are
+// all the clients the service will serve, from the environment and the
+// clients file. It returns one message per problem.
+func Check(cfg *Config, clients []domain.ClientApp) []string {
+	var problems []string
+	addf := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if _, err := cfg.Secrets.ExchangeKey(); err != nil {
+		addf("%v", err)
+	} else if n := len(cfg.Secrets.ExchangeEncryptionKey); n < minDerivedKey {
+		addf("EXCHANGE_ENCRYPTION_KEY should be at least %d bytes to derive a key from, got %d", minDerivedKey, n)
+	}
+	if _, err := cfg.Secrets.PreviousExchangeKey(); err != nil {
+		addf("%v", err)
+	}
+	if n := len(cfg.Secrets.StateSigningKey); n < minSigningKey {
+		addf("STATE_SIGNING_KEY should be at least %d bytes, got %d", minSigningKey, n)
+	}
+
+	// Deployed behind a public BASE_URL, everything must be HTTPS except
+	// callbacks to the developer's own machine
+	production := false
+	if cfg.Server.BaseURL == "" {
+		addf("BASE_URL is not set; provider callback URLs will be relative")
+	} else if u, err := url.Parse(cfg.Server.BaseURL); err != nil || u.Host == "" {
+		addf("BASE_URL %q is not an absolute URL", cfg.Server.BaseURL)
+	} else if !loopback(u.Hostname()) {
+		production = true
+		if u.Scheme != "https" {
+			addf("BASE_URL %q must use https", cfg.Server.BaseURL)
+		}
+	}
+
+	if cfg.TLS.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile); err != nil {
+			addf("TLS_CERT_FILE and TLS_KEY_FILE can't be loaded: %v", err)
+		}
+	}
+	if cfg.GeoIP.CountryDB != "" || cfg.GeoIP.ASNDB != "" {
+		if _, err := geoip.Open(cfg.GeoIP.CountryDB, cfg.GeoIP.ASNDB); err != nil {
+			addf("GEOIP_COUNTRY_DB and GEOIP_ASN_DB can't be loaded: %v", err)
+		}
+	}
+
+	if len(cfg.Providers) == 0 {
+		addf("no providers are configured")
+	}
+	for _, c := range clients {
+		for _, cb := range c.AllowedCallbacks {
+			u, err := url.Parse(cb)
+			switch {
+			case err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https"):
+				addf("client %s: callback %q is not an absolute http(s) URL", c.ID, cb)
+			case production && u.Scheme != "https" && !loopback(u.Hostname()):
+				addf("client %s: callback %q must use https", c.ID, cb)
+			}
+		}
+		if len(c.AllowedCallbacks) == 0 {
+			addf("client %s: no allowed callbacks", c.ID)
+		}
+		if len(c.AllowedProviders) == 0 {
+			addf("client %s: no allowed providers", c.ID)
+		}
+		for _, p := range c.AllowedProviders {
+			if _, ok := cfg.Providers[p]; !ok {
+				addf("client %s: allowed provider %q is not configured", c.ID, p)
+			}
+		}
+	}
+	return problems
+}
+
+// WriteSummary writes an overview of cfg and clients with every secret
+// left out.
+func WriteSummary(w io.Writer, cfg *Config, clients []domain.ClientApp) {
+	fmt.Fprintf(w, "Server:    %s:%d (base URL %s)\n", cfg.Server.Host, cfg.Server.Port, orNone(cfg.Server.PublicURL()))
+	switch {
+	case cfg.TLS.CertFile != "":
+		fmt.Fprintf(w, "TLS:       certificate %s\n", cfg.TLS.CertFile)
+	case len(cfg.TLS.AutocertDomains) > 0:
+		fmt.Fprintf(w, "TLS:       automatic certificate for %s (cache %s)\n", strings.Join(cfg.TLS.AutocertDomains, ", "), cfg.TLS.AutocertCacheDir)
+	}
+	if cfg.Server.Region != "" {
+		fmt.Fprintf(w, "Region:    %s\n", cfg.Server.Region)
+	}
+	if cfg.GeoIP.CountryDB != "" || cfg.GeoIP.ASNDB != "" {
+		fmt.Fprintf(w, "GeoIP:     country %s, ASN %s, blocked countries %s\n",
+			orNone(cfg.GeoIP.CountryDB), orNone(cfg.GeoIP.ASNDB), orNone(strings.Join(cfg.GeoIP.BlockedCountries, " ")))
+	}
+	fmt.Fprintf(w, "Secrets:   state signing key %s, exchange key %s, admin API key %s\n",
+		redact(cfg.Secrets.StateSigningKey), redact(cfg.Secrets.ExchangeEncryptionKey), redact(cfg.Admin.APIKey))
+	if cfg.Secrets.PreviousStateSigningKey != "" || cfg.Secrets.PreviousExchangeEncryptionKey != "" {
+		fmt.Fprintf(w, "Rotating:  previous state signing key %s, previous exchange key %s\n",
+			redact(cfg.Secrets.PreviousStateSigningKey), redact(cfg.Secrets.PreviousExchangeEncryptionKey))
+	}
+	if signer, err := idtoken.NewSigner([]byte(cfg.Secrets.IDTokenSigningKey)); err == nil {
+		ttl := cfg.Tokens.IDTokenTTL
+		if ttl == 0 {
+			ttl = idtoken.DefaultTTL
+		}
+		fmt.Fprintf(w, "ID tokens: %s key %s, issuer %s, valid %s\n", signer.Algorithm(), signer.KeyID(), orNone(cfg.Tokens.IDTokenIssuer), ttl)
+		for _, k := range []struct{ name, key string }{
+			{"previous", cfg.Secrets.PreviousIDTokenSigningKey},
+			{"next", cfg.Secrets.NextIDTokenSigningKey},
+		} {
+			if s, err := idtoken.NewSigner([]byte(k.key)); err == nil {
+				fmt.Fprintf(w, "           %s key %s, published only\n", k.name, s.KeyID())
+			}
+		}
+		if cfg.Tokens.OIDC {
+			fmt.Fprintf(w, "OIDC:      provider at %s/.well-known/openid-configuration\n", cfg.Server.PublicURL())
+		}
+		if cfg.Tokens.DeviceFlow {
+			fmt.Fprintf(w, "Devices:   sign in at %s/device\n", cfg.Server.PublicURL())
+		}
+	}
+	if cfg.Tokens.SSO {
+		ttl := cfg.Tokens.SessionTTL
+		if ttl == 0 {
+			ttl = session.DefaultTTL
+		}
+		fmt.Fprintf(w, "SSO:       sessions last %s\n", ttl)
+	}
+
+	names := make([]string, 0, len(cfg.Providers))
+	for name := range cfg.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "Providers: %d\n", len(names))
+	for _, name := range names {
+		pc := cfg.Providers[name]
+		var details []string
+		if pc.ClientID != "" {
+			details = append(details, "client ID "+pc.ClientID)
+		}
+		if pc.ClientSecret != "" {
+			details = append(details, "client secret "+redact(pc.ClientSecret))
+		}
+		if pc.APIKey != "" {
+			details = append(details, "API key "+redact(pc.APIKey))
+		}
+		if len(pc.Scopes) > 0 {
+			details = append(details, "scopes "+strings.Join(pc.Scopes, " "))
+		}
+		fmt.Fprintf(w, "  %-10s %s\n", name, strings.Join(details, ", "))
+	}
+
+	if cfg.Store.Backend == "redis" {
+		fmt.Fprintf(w, "Store:     redis, URL %s\n", redact(cfg.Store.RedisURL))
+	}
+	if rl := cfg.RateLimit; !rl.PerIP.IsZero() || !rl.Global.IsZero() || !cfg.ClientRateLimit.IsZero() {
+		fmt.Fprintf(w, "Limits:    per client %s, per IP %s, global %s (kept in %s)\n", cfg.ClientRateLimit, rl.PerIP, rl.Global, rl.Store)
+	}
+	if cfg.RateLimit.LockoutDisabled {
+		fmt.Fprintln(w, "Lockouts:  off")
+	}
+	if cfg.ClientsDB.Driver != "" {
+		fmt.Fprintf(w, "Client DB: %s, DSN %s\n", cfg.ClientsDB.Driver, redact(cfg.ClientsDB.DSN))
+	}
+	if cfg.IdentityDB.Driver != "" {
+		fmt.Fprintf(w, "Identity:  %s, DSN %s\n", cfg.IdentityDB.Driver, redact(cfg.IdentityDB.DSN))
+	}
+	if cfg.RoleRulesFile != "" {
+		fmt.Fprintf(w, "Roles:     %s\n", cfg.RoleRulesFile)
+	}
+	if cfg.Hooks.WebhookURL != "" {
+		fmt.Fprintf(w, "Hook:      webhook %s\n", cfg.Hooks.WebhookURL)
+	}
+	if len(cfg.Hooks.Script) > 0 {
+		fmt.Fprintf(w, "Hook:      script %s\n", strings.Join(cfg.Hooks.Script, " "))
+	}
+	fmt.Fprintf(w, "Clients:   %d\n", len(clients))
+	clients = slices.Clone(clients)
+	slices.SortFunc(clients, func(a, b domain.ClientApp) int { return strings.Compare(a.ID, b.ID) })
+	for _, c := range clients {
+		status := ""
+		if c.Disabled {
+			status = " [disabled]"
+		}
+		key := "API key " + redactAPIKey(c.APIKey)
+		if c.Public {
+			key = "public, no API key"
+		}
+		fmt.Fprintf(w, "  %s (%s)%s: %s, providers %s, callbacks %s\n",
+			c.ID, c.Name, status, key, orNone(strings.Join(c.AllowedProviders, " ")), orNone(strings.Join(c.AllowedCallbacks, " ")))
+	}
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return "unset"
+	}
+	return fmt.Sprintf("set (%d bytes)", len(secret))
+}
+
+// redactAPIKey is redact for client API keys, which may be configured hashed.
+func redactAPIKey(key string) string {
+	if strings.HasPrefix(key, "hash:") {
+		return "hashed"
+	}
+	return redact(key)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+func loopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
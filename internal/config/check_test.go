@@ -0,0 +1,148 @@
+package config
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func checkedConfig() *Config {
+	return &Config{
+		Server: ServerConfig{BaseURL: "https://auth.example.com"},
+		Secrets: SecretsConfig{
+			StateSigningKey:       "test-signing-key-1234567890123456",
+			ExchangeEncryptionKey: "0123456789abcdef0123456789abcdef",
+		},
+		Providers: map[string]ProviderConfig{
+			"discord": {ClientID: "discord-id", ClientSecret: "discord-secret", Scopes: []string{"identify"}},
+		},
+	}
+}
+
+func TestCheck_Valid(t *testing.T) {
+	clients := []domain.ClientApp{{
+		ID:               "website",
+		APIKey:           "web-key",
+		AllowedCallbacks: []string{"https://example.com/callback", "http://localhost:3000/callback"},
+		AllowedProviders: []string{"discord"},
+	}}
+	if problems := Check(checkedConfig(), clients); len(problems) != 0 {
+		t.Errorf("expected no problems, got %q", problems)
+	}
+}
+
+func TestCheck_Problems(t *testing.T) {
+	tests := map[string]struct {
+		modify func(*Config, *domain.ClientApp)
+		want   string
+	}{
+		"exchange key length": {
+			func(c *Config, _ *domain.ClientApp) { c.Secrets.ExchangeEncryptionKey = "short" },
+			"EXCHANGE_ENCRYPTION_KEY must be exactly 32 bytes, or at least 16 bytes to derive a key from, got 5",
+		},
+		"short passphrase": {
+			func(c *Config, _ *domain.ClientApp) { c.Secrets.ExchangeEncryptionKey = "a-twenty-byte-phrase" },
+			"EXCHANGE_ENCRYPTION_KEY should be at least 32 bytes to derive a key from, got 20",
+		},
+		"raw exchange key length": {
+			func(c *Config, _ *domain.ClientApp) {
+				c.Secrets.KeyMode = KeyModeRaw
+				c.Secrets.ExchangeEncryptionKey = "a-long-passphrase-that-is-not-a-raw-key"
+			},
+			"EXCHANGE_ENCRYPTION_KEY must be exactly 32 bytes with EXCHANGE_KEY_MODE=raw, got 39",
+		},
+		"previous exchange key length": {
+			func(c *Config, _ *domain.ClientApp) { c.Secrets.PreviousExchangeEncryptionKey = "short" },
+			"EXCHANGE_ENCRYPTION_KEY_PREVIOUS must be exactly 32 bytes, or at least 16 bytes to derive a key from, got 5",
+		},
+		"short signing key": {
+			func(c *Config, _ *domain.ClientApp) { c.Secrets.StateSigningKey = "short" },
+			"STATE_SIGNING_KEY should be at least 32 bytes, got 5",
+		},
+		"plain http base URL": {
+			func(c *Config, _ *domain.ClientApp) { c.Server.BaseURL = "http://auth.example.com" },
+			`BASE_URL "http://auth.example.com" must use https`,
+		},
+		"plain http callback": {
+			func(_ *Config, a *domain.ClientApp) { a.AllowedCallbacks = []string{"http://example.com/cb"} },
+			`client website: callback "http://example.com/cb" must use https`,
+		},
+		"relative callback": {
+			func(_ *Config, a *domain.ClientApp) { a.AllowedCallbacks = []string{"/callback"} },
+			`client website: callback "/callback" is not an absolute http(s) URL`,
+		},
+		"unconfigured provider": {
+			func(_ *Config, a *domain.ClientApp) { a.AllowedProviders = []string{"discord", "steam"} },
+			`client website: allowed provider "steam" is not configured`,
+		},
+		"missing certificate": {
+			func(c *Config, _ *domain.ClientApp) {
+				c.TLS.CertFile, c.TLS.KeyFile = "/nonexistent/cert.pem", "/nonexistent/key.pem"
+			},
+			"TLS_CERT_FILE and TLS_KEY_FILE can't be loaded: open /nonexistent/cert.pem: no such file or directory",
+		},
+		"missing GeoIP database": {
+			func(c *Config, _ *domain.ClientApp) { c.GeoIP.CountryDB = "/nonexistent/GeoLite2-Country.mmdb" },
+			"GEOIP_COUNTRY_DB and GEOIP_ASN_DB can't be loaded: reading GeoIP database: open /nonexistent/GeoLite2-Country.mmdb: no such file or directory",
+		},
+		"no providers": {
+			func(_ *Config, a *domain.ClientApp) { a.AllowedProviders = nil },
+			"client website: no allowed providers",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := checkedConfig()
+			app := domain.ClientApp{
+				ID:               "website",
+				AllowedCallbacks: []string{"https://example.com/callback"},
+				AllowedProviders: []string{"discord"},
+			}
+			tt.modify(cfg, &app)
+
+			problems := Check(cfg, []domain.ClientApp{app})
+			if !slices.Contains(problems, tt.want) {
+				t.Errorf("expected %q, got %q", tt.want, problems)
+			}
+		})
+	}
+}
+
+func TestCheck_LocalDevelopment(t *testing.T) {
+	cfg := checkedConfig()
+	cfg.Server.BaseURL = "http://localhost:8080"
+	clients := []domain.ClientApp{{
+		ID:               "website",
+		AllowedCallbacks: []string{"http://dev.internal/callback"},
+		AllowedProviders: []string{"discord"},
+	}}
+	if problems := Check(cfg, clients); len(problems) != 0 {
+		t.Errorf("expected plain http to be accepted locally, got %q", problems)
+	}
+}
+
+func TestWriteSummary_RedactsSecrets(t *testing.T) {
+	cfg := checkedConfig()
+	cfg.Admin.APIKey = "admin-key"
+	cfg.RateLimit = RateLimitConfig{Store: "redis", RedisURL: "redis://:redis-password@cache", PerIP: domain.RateLimit{Requests: 20, Per: time.Minute}}
+	clients := []domain.ClientApp{{ID: "website", Name: "Website", APIKey: "web-key"}}
+
+	var buf bytes.Buffer
+	WriteSummary(&buf, cfg, clients)
+	out := buf.String()
+	if !strings.Contains(out, "per IP 20/1m0s") {
+		t.Errorf("expected the rate limits in the summary:\n%s", out)
+	}
+	for _, secret := range []string{cfg.Secrets.StateSigningKey, cfg.Secrets.ExchangeEncryptionKey, "admin-key", "discord-secret", "web-key", "redis-password"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("summary leaks %q:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "discord") || !strings.Contains(out, "website (Website)") {
+		t.Errorf("expected providers and clients in the summary:\n%s", out)
+	}
+}
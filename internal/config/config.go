@@ -2,20 +2,71 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
+	"net/url"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/BlackMission/centralauth/internal/client"
 	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/geoip"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/internal/logging"
+	"github.com/BlackMission/centralauth/internal/scope"
 )
 
 // Config is the top-level application configuration.
 type Config struct {
 	Server    ServerConfig
+	TLS       TLSConfig
+	Admin     AdminConfig
+	MFA       MFAConfig
+	Captcha   CaptchaConfig
+	Metrics   MetricsConfig
+	Log       LogConfig
+	Tracing   TracingConfig
+	Events    EventsConfig
+	Hooks     HooksConfig
+	GeoIP     GeoIPConfig
 	Secrets   SecretsConfig
+	Tokens    TokensConfig
+	RateLimit RateLimitConfig
+	Store     StoreConfig
 	Providers map[string]ProviderConfig
 	Clients   []ClientConfig
+
+	// ClientsFile optionally names a JSON file of additional clients that is
+	// re-read every ClientsReloadInterval.
+	ClientsFile           string
+	ClientsReloadInterval time.Duration
+
+	// ClientsDB optionally loads clients from a database table instead,
+	// re-read every ClientsReloadInterval. With ClientsDBSeed the clients
+	// configured through the environment are inserted into it at startup
+	// rather than served alongside it.
+	ClientsDB     DatabaseConfig
+	ClientsDBSeed bool
+
+	// IdentityDB optionally keeps a stable central ID for each user, the
+	// same whichever client they sign in through.
+	IdentityDB DatabaseConfig
+	// LoginRetention is how long the identity database keeps each user's
+	// sign-ins for their login history.
+	LoginRetention time.Duration
+
+	// RoleRulesFile optionally names a JSON file of rules granting users
+	// application roles from their provider data.
+	RoleRulesFile string
+
+	// ClientRetention is how long a deleted client can still be restored.
+	ClientRetention time.Duration
+
+	// ClientRateLimit limits each client's requests unless the client sets
+	// its own limit. Zero means no limit.
+	ClientRateLimit domain.RateLimit
 }
 
 // ServerConfig holds HTTP server settings.
@@ -23,12 +74,244 @@ type ServerConfig struct {
 	Port    int
 	Host    string
 	BaseURL string
+	Region  string
+
+	// BasePath is the path prefix every route is served under when the
+	// service sits behind a reverse proxy, e.g. /authsvc. Empty serves from /.
+	BasePath string
+
+	// PagesDir holds *.html templates that override the built-in hosted
+	// pages, e.g. to brand the error page. Empty uses the built-in ones.
+	PagesDir string
+
+	// CORS lets browser apps call the API from the origins of the clients'
+	// allowed callbacks, and from CORSOrigins.
+	CORS        bool
+	CORSOrigins []string
+
+	// ShutdownDelay is how long to keep serving with readiness withdrawn
+	// and new sign-ins refused before closing the listener on shutdown.
+	ShutdownDelay time.Duration
+
+	// DrainTimeout is how long shutdown then waits for requests in flight.
+	DrainTimeout time.Duration
+
+	// MaxQueryBytes and MaxHeaderBytes bound a request's query string and
+	// its request line and headers.
+	MaxQueryBytes  int
+	MaxHeaderBytes int
+}
+
+// PublicURL returns the URL the service's routes hang off: BASE_URL with
+// BASE_PATH appended, unless BASE_URL already ends in it.
+func (s ServerConfig) PublicURL() string {
+	base := strings.TrimSuffix(s.BaseURL, "/")
+	if strings.HasSuffix(base, s.BasePath) {
+		return base
+	}
+	return base + s.BasePath
+}
+
+// cleanBasePath normalizes BASE_PATH to a leading slash and no trailing one.
+func cleanBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// TLSConfig holds settings for serving HTTPS without a reverse proxy.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+
+	// AutocertDomains obtains a certificate for these hosts from an ACME CA
+	// when set.
+	AutocertDomains      []string
+	AutocertEmail        string
+	AutocertCacheDir     string
+	AutocertDirectoryURL string
+
+	// ClientCerts asks clients for a certificate, so that API clients can
+	// authenticate with the ones named by their CertFingerprints.
+	ClientCerts bool
+}
+
+// DatabaseConfig names a SQL database.
+type DatabaseConfig struct {
+	Driver string // postgres or sqlite
+	DSN    string
+}
+
+// AdminConfig holds settings for the operational /admin endpoints.
+type AdminConfig struct {
+	APIKey string
+
+	// Port, when set, moves /metrics and /admin off the public listener to
+	// one of their own on Host.
+	Host string
+	Port int
+
+	// Debug serves pprof profiles and runtime statistics on Port.
+	Debug bool
+
+	// CertFingerprints, when set, are the only TLS client certificates
+	// admin requests are accepted with.
+	CertFingerprints []string
+}
+
+// MFAConfig holds second-factor settings.
+type MFAConfig struct {
+	Enabled     bool
+	SecretsFile string
+	Issuer      string
+}
+
+// CaptchaConfig holds the CAPTCHA service used on hosted pages.
+type CaptchaConfig struct {
+	Provider string // "hcaptcha" or "turnstile"; empty disables CAPTCHA
+	SiteKey  string
+	Secret   string
+}
+
+// MetricsConfig holds metrics exporter settings.
+type MetricsConfig struct {
+	Enabled bool
+	Backend string // "prometheus" or "statsd"
+
+	StatsDAddr   string
+	StatsDPrefix string
+	StatsDTags   []string
+}
+
+// LogConfig holds the log output settings.
+type LogConfig struct {
+	Format string // "text" or "json"
+	Level  string // "debug", "info", "warn" or "error"
+}
+
+// TracingConfig holds the OpenTelemetry trace exporter settings.
+type TracingConfig struct {
+	Enabled     bool
+	Endpoint    string            // OTLP/HTTP collector base URL
+	Headers     map[string]string // sent with every export
+	ServiceName string
+	SampleRatio float64 // share of requests traced, 0 to 1
+}
+
+// EventsConfig holds the message broker that login events are published
+// to.
+type EventsConfig struct {
+	Backend      string // "nats" or "kafka"; empty publishes nothing
+	NATSURL      string
+	KafkaBrokers []string
+	KafkaTLS     bool
+	Prefix       string // prepended to each event type to name its subject or topic
+}
+
+// HooksConfig holds the built-in post-auth hooks. Each sign-in is put to the
+// webhook, then the script, when they are set.
+type HooksConfig struct {
+	WebhookURL   string
+	WebhookToken string
+	Script       []string      // program and arguments
+	Timeout      time.Duration // for each hook to answer
+}
+
+// GeoIPConfig holds the MaxMind databases that addresses are looked up in,
+// and the countries no one may start signing in from.
+type GeoIPConfig struct {
+	CountryDB        string
+	ASNDB            string
+	BlockedCountries []string // ISO 3166-1 alpha-2 codes
+}
+
+// StoreConfig names where state shared between requests is kept: spent
+// exchange codes, /exchange idempotency entries, and, unless RateLimitConfig
+// says otherwise, rate limits.
+type StoreConfig struct {
+	Backend  string // "memory" (lost on restart, revoked state tokens included) or "redis"
+	RedisURL string
+}
+
+// RateLimitConfig holds the limits on browser-facing auth routes and where
+// rate limits are kept.
+type RateLimitConfig struct {
+	Store    string // "memory" or "redis"
+	RedisURL string
+
+	PerIP  domain.RateLimit
+	Global domain.RateLimit
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For header
+	// names the client IP.
+	TrustedProxies []netip.Prefix
+
+	// Callers of /exchange and /token that fail to authenticate more often
+	// than LockoutAfter are locked out for LockoutDuration, doubling each
+	// time up to LockoutMaxDuration, unless LockoutDisabled. Zero values
+	// take the lockout package's defaults.
+	LockoutDisabled    bool
+	LockoutAfter       domain.RateLimit
+	LockoutDuration    time.Duration
+	LockoutMaxDuration time.Duration
 }
 
 // SecretsConfig holds cryptographic key references.
 type SecretsConfig struct {
 	StateSigningKey       string
 	ExchangeEncryptionKey string
+
+	// Keys being rotated out; still accepted for tokens and codes issued
+	// before the rotation, never used for new ones.
+	PreviousStateSigningKey       string
+	PreviousExchangeEncryptionKey string
+
+	// KeyMode says how the exchange key is made from its secret: auto,
+	// raw, or derive.
+	KeyMode string
+
+	// PerClientExchangeKeys derives a separate exchange code key for each client.
+	PerClientExchangeKeys bool
+
+	// IDTokenSigningKey is the PEM-encoded RSA or Ed25519 private key that
+	// signs identity tokens. Empty disables them. The previous and next keys
+	// are published for verifiers without signing anything, around a
+	// rotation.
+	IDTokenSigningKey         string
+	PreviousIDTokenSigningKey string
+	NextIDTokenSigningKey     string
+}
+
+// TokensConfig holds how long issued tokens stay valid. Zero uses the
+// defaults: 5 minutes for state and identity tokens, 30 seconds for exchange
+// codes.
+type TokensConfig struct {
+	StateTTL        time.Duration
+	ExchangeCodeTTL time.Duration
+	IDTokenTTL      time.Duration
+
+	// SingleUseState lets each state token complete its flow only once,
+	// remembering used tokens in the shared store.
+	SingleUseState bool
+
+	// IDTokenIssuer is the "iss" of identity tokens; the public URL if empty.
+	IDTokenIssuer string
+
+	// OIDC serves CentralAuth as an OpenID Connect provider, signing with
+	// the identity token key.
+	OIDC bool
+
+	// DeviceFlow serves the device authorization grant, whose device codes
+	// last DeviceCodeTTL (zero uses the default, 10 minutes).
+	DeviceFlow    bool
+	DeviceCodeTTL time.Duration
+
+	// SSO keeps users signed in to CentralAuth with a session cookie, which
+	// lasts SessionTTL (zero uses the default, 8 hours).
+	SSO        bool
+	SessionTTL time.Duration
 }
 
 // ProviderConfig holds provider-specific settings.
@@ -38,21 +321,130 @@ type ProviderConfig struct {
 	Scopes       []string
 	APIKey       string
 	Realm        string
+	IssuerURL    string // OpenID Connect issuer
+	BaseURL      string // self-hosted instance (GitLab)
+	UserAgent    string // sent on API calls (Reddit)
+
+	// Provider extras: the Discord guild to report roles in, whether to
+	// report Steam presence in and ownership of AppID, and whether to look
+	// up Steam bans
+	GuildID     string
+	GuildFilter []string // Discord guilds to report with the guilds scope
+	Extras      bool
+	FetchBans   bool
+	AppID       string
+
+	BotToken string // Discord bot token for user lookups
+
+	// Local provider settings
+	AccountsFile      string
+	AllowRegistration bool
+	MinPasswordLength int
+
+	// Phone provider settings
+	SMS     SMSConfig
+	CodeTTL time.Duration
+	AppName string
+
+	// Guest provider settings
+	Lifetime time.Duration
+
+	// LDAP provider settings
+	LDAP LDAPConfig
+
+	// Generic OAuth2 provider settings (nil for built-in providers)
+	OAuth2 *OAuth2Config
+
+	// MaxConcurrency bounds simultaneous Exchange calls (0 = unlimited);
+	// MaxWait is how long a call may queue for a free slot.
+	MaxConcurrency int
+	MaxWait        time.Duration
+
+	// HTTPTimeout bounds each call to the provider's API (0 = the default);
+	// HTTPRetries is how many times a failed idempotent call is repeated.
+	HTTPTimeout time.Duration
+	HTTPRetries int
+
+	// HTTPProxy is the proxy URL for calls to the provider's API ("" follows
+	// HTTPS_PROXY and friends, "direct" uses none); NoProxy lists hosts
+	// that bypass it.
+	HTTPProxy string
+	NoProxy   []string
+}
+
+// LDAPConfig points the LDAP provider at a directory.
+type LDAPConfig struct {
+	URL      string
+	StartTLS bool
+	CAFile   string
+
+	BindDN       string
+	BindPassword string
+
+	BaseDN   string
+	UserAttr string
+	IDAttr   string
+}
+
+// SMSConfig selects and configures the phone provider's SMS gateway.
+type SMSConfig struct {
+	Gateway string // "twilio" or "webhook"
+
+	TwilioAccountSID string
+	TwilioAuthToken  string
+	TwilioFrom       string // sender number or messaging service SID
+
+	WebhookURL   string
+	WebhookToken string
+}
+
+// OAuth2Config describes an operator-defined OAuth2 API.
+type OAuth2Config struct {
+	AuthURL   string
+	TokenURL  string
+	UserURL   string
+	TokenAuth string            // "post" or "basic"
+	Mapping   map[string]string // UserInfo field -> JSON path or template
 }
 
 // ClientConfig holds a registered client app's settings.
 type ClientConfig struct {
-	ID               string
-	Name             string
-	APIKey           string
-	AllowedCallbacks []string
-	AllowedProviders []string
+	ID                    string
+	Name                  string
+	APIKey                string
+	SecondaryAPIKey       string // also accepted while the API key is rotated
+	AllowedCallbacks      []string
+	AllowedProviders      []string
+	KeyVersion            string
+	RequireCaptcha        bool
+	AllowLookup           bool             // may look users up by provider ID
+	IncludeRaw            bool             // receives raw provider profiles
+	Public                bool             // has no API key; redeems codes with PKCE
+	AllowTokenPassthrough bool             // receives provider access and refresh tokens
+	AllowServiceTokens    bool             // may get service tokens from POST /token
+	StripFields           []string         // user fields this client never receives
+	GuestLifetime         time.Duration    // overrides GUEST_LIFETIME for this client
+	StateTTL              time.Duration    // overrides STATE_TTL for this client
+	ExchangeCodeTTL       time.Duration    // overrides EXCHANGE_CODE_TTL for this client
+	RefreshTokenTTL       time.Duration    // lifetime of this client's refresh tokens; 0 issues none
+	BackchannelLogoutURI  string           // told when its users sign out at /logout
+	RateLimit             domain.RateLimit // overrides CLIENT_RATE_LIMIT for this client
+	AllowedIPs            []netip.Prefix   // the only addresses its API key works from, if set
+	CertFingerprints      []string         // TLS client certificates it authenticates with
+	BlockedCountries      []string         // countries its users can't sign in from
+	Disabled              bool             // suspended: can't start flows or use its API key
 }
 
 // LoadFromEnv reads configuration purely from environment variables.
 func LoadFromEnv() (*Config, error) {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	return load()
+}
+
+func load() (*Config, error) {
 	port := 8080
-	if v := os.Getenv("PORT"); v != "" {
+	if v := getenv("PORT"); v != "" {
 		p, err := strconv.Atoi(v)
 		if err != nil {
 			return nil, fmt.Errorf("%w: PORT must be a number: %v", domain.ErrInvalidConfig, err)
@@ -62,36 +454,475 @@ func LoadFromEnv() (*Config, error) {
 
 	cfg := &Config{
 		Server: ServerConfig{
-			Port:    port,
-			Host:    getenvDefault("HOST", "0.0.0.0"),
-			BaseURL: os.Getenv("BASE_URL"),
+			Port:     port,
+			Host:     getenvDefault("HOST", "0.0.0.0"),
+			BaseURL:  getenv("BASE_URL"),
+			Region:   getenv("REGION"),
+			BasePath: cleanBasePath(getenv("BASE_PATH")),
+			PagesDir: getenv("PAGES_DIR"),
+
+			CORS:        getenv("CORS_ENABLED") == "true",
+			CORSOrigins: splitComma(strings.ToLower(getenv("CORS_ALLOWED_ORIGINS"))),
 		},
-		Secrets: SecretsConfig{
-			StateSigningKey:       os.Getenv("STATE_SIGNING_KEY"),
-			ExchangeEncryptionKey: os.Getenv("EXCHANGE_ENCRYPTION_KEY"),
+		TLS: TLSConfig{
+			CertFile:             getenv("TLS_CERT_FILE"),
+			KeyFile:              getenv("TLS_KEY_FILE"),
+			AutocertDomains:      splitComma(strings.ToLower(getenv("TLS_AUTOCERT_DOMAINS"))),
+			AutocertEmail:        getenv("TLS_AUTOCERT_EMAIL"),
+			AutocertCacheDir:     getenvDefault("TLS_AUTOCERT_CACHE_DIR", "autocert"),
+			AutocertDirectoryURL: getenv("TLS_AUTOCERT_DIRECTORY_URL"),
+			ClientCerts:          getenv("TLS_CLIENT_CERTS") == "true",
+		},
+		Admin: AdminConfig{
+			APIKey: getenv("ADMIN_API_KEY"),
+			Host:   getenv("ADMIN_HOST"),
+			Debug:  getenv("DEBUG_ENDPOINTS_ENABLED") == "true",
+		},
+		MFA: MFAConfig{
+			Enabled:     getenv("MFA_ENABLED") == "true",
+			SecretsFile: getenvDefault("MFA_SECRETS_FILE", "mfa-secrets.json"),
+			Issuer:      getenvDefault("MFA_ISSUER", "CentralAuth"),
+		},
+		Metrics: MetricsConfig{
+			Enabled:      getenv("METRICS_ENABLED") == "true",
+			Backend:      getenvDefault("METRICS_BACKEND", "prometheus"),
+			StatsDAddr:   getenvDefault("STATSD_ADDR", "127.0.0.1:8125"),
+			StatsDPrefix: getenv("STATSD_PREFIX"),
+			StatsDTags:   splitComma(getenv("STATSD_TAGS")),
+		},
+		Log: LogConfig{
+			Format: strings.ToLower(getenvDefault("LOG_FORMAT", "text")),
+			Level:  strings.ToLower(getenvDefault("LOG_LEVEL", "info")),
+		},
+		Tracing: TracingConfig{
+			Enabled:     getenv("TRACING_ENABLED") == "true",
+			Endpoint:    getenvDefault("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"),
+			ServiceName: getenvDefault("OTEL_SERVICE_NAME", "centralauth"),
+			SampleRatio: 1,
+		},
+		Events: EventsConfig{
+			Backend:      strings.ToLower(getenv("EVENTS_BACKEND")),
+			KafkaBrokers: splitComma(getenv("EVENTS_KAFKA_BROKERS")),
+			KafkaTLS:     getenv("EVENTS_KAFKA_TLS") == "true",
+			Prefix:       getenvDefault("EVENTS_PREFIX", "centralauth."),
+		},
+		GeoIP: GeoIPConfig{
+			CountryDB: getenv("GEOIP_COUNTRY_DB"),
+			ASNDB:     getenv("GEOIP_ASN_DB"),
 		},
 		Providers: make(map[string]ProviderConfig),
 	}
 
+	// Secrets may come from a mounted file so that every region can share one
+	// key source (e.g. a replicated secret volume).
+	var err error
+	if cfg.Secrets.StateSigningKey, err = getenvSecret("STATE_SIGNING_KEY"); err != nil {
+		return nil, err
+	}
+	if cfg.Secrets.ExchangeEncryptionKey, err = getenvSecret("EXCHANGE_ENCRYPTION_KEY"); err != nil {
+		return nil, err
+	}
+	cfg.Secrets.KeyMode = getenvDefault("EXCHANGE_KEY_MODE", KeyModeAuto)
+	if cfg.Secrets.PreviousStateSigningKey, err = getenvSecret("STATE_SIGNING_KEY_PREVIOUS"); err != nil {
+		return nil, err
+	}
+	if cfg.Secrets.PreviousExchangeEncryptionKey, err = getenvSecret("EXCHANGE_ENCRYPTION_KEY_PREVIOUS"); err != nil {
+		return nil, err
+	}
+	cfg.Captcha = CaptchaConfig{
+		Provider: getenv("CAPTCHA_PROVIDER"),
+		SiteKey:  getenv("CAPTCHA_SITE_KEY"),
+	}
+	if cfg.Captcha.Secret, err = getenvSecret("CAPTCHA_SECRET"); err != nil {
+		return nil, err
+	}
+	cfg.Secrets.PerClientExchangeKeys = getenv("EXCHANGE_PER_CLIENT_KEYS") == "true"
+	if cfg.Tokens.StateTTL, err = getenvDuration("STATE_TTL"); err != nil {
+		return nil, err
+	}
+	cfg.Tokens.SingleUseState = getenv("STATE_SINGLE_USE") == "true"
+	if cfg.Tokens.ExchangeCodeTTL, err = getenvDuration("EXCHANGE_CODE_TTL"); err != nil {
+		return nil, err
+	}
+	if cfg.Secrets.IDTokenSigningKey, err = getenvSecret("ID_TOKEN_SIGNING_KEY"); err != nil {
+		return nil, err
+	}
+	if cfg.Secrets.PreviousIDTokenSigningKey, err = getenvSecret("ID_TOKEN_SIGNING_KEY_PREVIOUS"); err != nil {
+		return nil, err
+	}
+	if cfg.Secrets.NextIDTokenSigningKey, err = getenvSecret("ID_TOKEN_SIGNING_KEY_NEXT"); err != nil {
+		return nil, err
+	}
+	if cfg.Tokens.IDTokenTTL, err = getenvDuration("ID_TOKEN_TTL"); err != nil {
+		return nil, err
+	}
+	cfg.Tokens.IDTokenIssuer = getenvDefault("ID_TOKEN_ISSUER", cfg.Server.PublicURL())
+	cfg.Tokens.OIDC = getenv("OIDC_ENABLED") == "true"
+	cfg.Tokens.DeviceFlow = getenv("DEVICE_FLOW_ENABLED") == "true"
+	if cfg.Tokens.DeviceCodeTTL, err = getenvDuration("DEVICE_CODE_TTL"); err != nil {
+		return nil, err
+	}
+	cfg.Tokens.SSO = getenv("SSO_ENABLED") == "true"
+	if cfg.Tracing.Enabled {
+		if v := getenv("TRACING_SAMPLE_RATIO"); v != "" {
+			if cfg.Tracing.SampleRatio, err = strconv.ParseFloat(v, 64); err != nil {
+				return nil, fmt.Errorf("%w: TRACING_SAMPLE_RATIO must be a number: %v", domain.ErrInvalidConfig, err)
+			}
+		}
+		headers, err := getenvSecret("OTEL_EXPORTER_OTLP_HEADERS")
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Tracing.Headers, err = parseHeaders(headers); err != nil {
+			return nil, fmt.Errorf("%w: OTEL_EXPORTER_OTLP_HEADERS: %v", domain.ErrInvalidConfig, err)
+		}
+	}
+	if cfg.Tokens.SessionTTL, err = getenvDuration("SSO_SESSION_TTL"); err != nil {
+		return nil, err
+	}
+	if cfg.Server.ShutdownDelay, err = getenvDuration("SHUTDOWN_DELAY"); err != nil {
+		return nil, err
+	}
+	if cfg.Server.DrainTimeout, err = getenvDuration("DRAIN_TIMEOUT"); err != nil {
+		return nil, err
+	}
+	if cfg.Server.DrainTimeout == 0 {
+		cfg.Server.DrainTimeout = 10 * time.Second
+	}
+	if cfg.Server.MaxQueryBytes, err = getenvInt("MAX_QUERY_BYTES"); err != nil {
+		return nil, err
+	}
+	if cfg.Server.MaxQueryBytes == 0 {
+		cfg.Server.MaxQueryBytes = 4096
+	}
+	if cfg.Server.MaxHeaderBytes, err = getenvInt("MAX_HEADER_BYTES"); err != nil {
+		return nil, err
+	}
+	if cfg.Server.MaxHeaderBytes == 0 {
+		cfg.Server.MaxHeaderBytes = 16 << 10
+	}
+	if cfg.GeoIP.BlockedCountries, err = getenvCountries("GEOIP_BLOCKED_COUNTRIES"); err != nil {
+		return nil, err
+	}
+	if cfg.Admin.CertFingerprints, err = getenvFingerprints("ADMIN_CERT_FINGERPRINTS"); err != nil {
+		return nil, err
+	}
+	if cfg.Admin.Port, err = getenvInt("ADMIN_PORT"); err != nil {
+		return nil, err
+	}
+	if cfg.Admin.Host == "" {
+		cfg.Admin.Host = cfg.Server.Host
+	}
+	// The NATS URL may carry a user and password or a token
+	if cfg.Events.NATSURL, err = getenvSecret("EVENTS_NATS_URL"); err != nil {
+		return nil, err
+	}
+	if cfg.Events.NATSURL == "" {
+		cfg.Events.NATSURL = "nats://127.0.0.1:4222"
+	}
+	cfg.Hooks.WebhookURL = getenv("POST_AUTH_WEBHOOK_URL")
+	if cfg.Hooks.WebhookToken, err = getenvSecret("POST_AUTH_WEBHOOK_TOKEN"); err != nil {
+		return nil, err
+	}
+	cfg.Hooks.Script = strings.Fields(getenv("POST_AUTH_SCRIPT"))
+	if cfg.Hooks.Timeout, err = getenvDuration("POST_AUTH_HOOK_TIMEOUT"); err != nil {
+		return nil, err
+	}
+	if cfg.Hooks.Timeout == 0 {
+		cfg.Hooks.Timeout = 5 * time.Second
+	}
+
 	// Discord provider — enabled by presence of DISCORD_CLIENT_ID
-	if id := os.Getenv("DISCORD_CLIENT_ID"); id != "" {
-		cfg.Providers["discord"] = ProviderConfig{
-			ClientID:     id,
-			ClientSecret: os.Getenv("DISCORD_CLIENT_SECRET"),
-			Scopes:       splitComma(getenvDefault("DISCORD_SCOPES", "identify,email")),
+	if id := getenv("DISCORD_CLIENT_ID"); id != "" {
+		pc := ProviderConfig{
+			ClientID:    id,
+			Scopes:      splitComma(getenvDefault("DISCORD_SCOPES", "identify,email")),
+			GuildID:     getenv("DISCORD_GUILD_ID"),
+			GuildFilter: splitComma(getenv("DISCORD_GUILD_FILTER")),
+		}
+		if pc.ClientSecret, err = getenvSecret("DISCORD_CLIENT_SECRET"); err != nil {
+			return nil, err
+		}
+		if pc.BotToken, err = getenvSecret("DISCORD_BOT_TOKEN"); err != nil {
+			return nil, err
 		}
+		cfg.Providers["discord"] = pc
 	}
 
 	// Steam provider — enabled by presence of STEAM_API_KEY
-	if key := os.Getenv("STEAM_API_KEY"); key != "" {
+	steamKey, err := getenvSecret("STEAM_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if steamKey != "" {
 		cfg.Providers["steam"] = ProviderConfig{
-			APIKey: key,
-			Realm:  getenvDefault("STEAM_REALM", cfg.Server.BaseURL),
+			APIKey:    steamKey,
+			Realm:     getenvDefault("STEAM_REALM", cfg.Server.BaseURL),
+			Extras:    getenv("STEAM_EXTRAS") == "true",
+			FetchBans: getenv("STEAM_FETCH_BANS") == "true",
+			AppID:     getenv("STEAM_APP_ID"),
+		}
+	}
+
+	// GitLab provider — enabled by presence of GITLAB_CLIENT_ID
+	if id := getenv("GITLAB_CLIENT_ID"); id != "" {
+		pc := ProviderConfig{
+			ClientID: id,
+			Scopes:   splitComma(getenvDefault("GITLAB_SCOPES", "read_user")),
+			BaseURL:  getenvDefault("GITLAB_BASE_URL", "https://gitlab.com"),
+		}
+		if pc.ClientSecret, err = getenvSecret("GITLAB_CLIENT_SECRET"); err != nil {
+			return nil, err
+		}
+		cfg.Providers["gitlab"] = pc
+	}
+
+	// Reddit provider — enabled by presence of REDDIT_CLIENT_ID
+	if id := getenv("REDDIT_CLIENT_ID"); id != "" {
+		pc := ProviderConfig{
+			ClientID:  id,
+			Scopes:    splitComma(getenvDefault("REDDIT_SCOPES", "identity")),
+			UserAgent: getenv("REDDIT_USER_AGENT"),
+		}
+		if pc.ClientSecret, err = getenvSecret("REDDIT_CLIENT_SECRET"); err != nil {
+			return nil, err
+		}
+		cfg.Providers["reddit"] = pc
+	}
+
+	// Facebook provider — enabled by presence of FACEBOOK_APP_ID
+	if id := getenv("FACEBOOK_APP_ID"); id != "" {
+		pc := ProviderConfig{
+			ClientID: id,
+			Scopes:   splitComma(getenvDefault("FACEBOOK_SCOPES", "public_profile,email")),
+		}
+		if pc.ClientSecret, err = getenvSecret("FACEBOOK_APP_SECRET"); err != nil {
+			return nil, err
+		}
+		cfg.Providers["facebook"] = pc
+	}
+
+	// X (Twitter) provider — enabled by presence of TWITTER_CLIENT_ID
+	if id := getenv("TWITTER_CLIENT_ID"); id != "" {
+		pc := ProviderConfig{
+			ClientID: id,
+			Scopes:   splitComma(getenvDefault("TWITTER_SCOPES", "tweet.read,users.read")),
+		}
+		if pc.ClientSecret, err = getenvSecret("TWITTER_CLIENT_SECRET"); err != nil {
+			return nil, err
+		}
+		cfg.Providers["twitter"] = pc
+	}
+
+	// Roblox provider — enabled by presence of ROBLOX_CLIENT_ID
+	if id := getenv("ROBLOX_CLIENT_ID"); id != "" {
+		pc := ProviderConfig{
+			ClientID: id,
+			Scopes:   splitComma(getenvDefault("ROBLOX_SCOPES", "openid,profile")),
+		}
+		if pc.ClientSecret, err = getenvSecret("ROBLOX_CLIENT_SECRET"); err != nil {
+			return nil, err
+		}
+		cfg.Providers["roblox"] = pc
+	}
+
+	// Minecraft provider — enabled by presence of MINECRAFT_CLIENT_ID
+	if id := getenv("MINECRAFT_CLIENT_ID"); id != "" {
+		pc := ProviderConfig{ClientID: id}
+		if pc.ClientSecret, err = getenvSecret("MINECRAFT_CLIENT_SECRET"); err != nil {
+			return nil, err
+		}
+		cfg.Providers["minecraft"] = pc
+	}
+
+	// OpenID Connect provider — enabled by presence of OIDC_ISSUER_URL
+	if issuer := getenv("OIDC_ISSUER_URL"); issuer != "" {
+		pc := ProviderConfig{
+			IssuerURL: issuer,
+			ClientID:  getenv("OIDC_CLIENT_ID"),
+			Scopes:    splitComma(getenvDefault("OIDC_SCOPES", "openid,profile,email")),
+		}
+		if pc.ClientSecret, err = getenvSecret("OIDC_CLIENT_SECRET"); err != nil {
+			return nil, err
+		}
+		cfg.Providers["oidc"] = pc
+	}
+
+	// Local provider — enabled by LOCAL_ENABLED=true
+	if getenv("LOCAL_ENABLED") == "true" {
+		minLen, err := getenvInt("LOCAL_MIN_PASSWORD_LENGTH")
+		if err != nil {
+			return nil, err
+		}
+		cfg.Providers["local"] = ProviderConfig{
+			AccountsFile:      getenvDefault("LOCAL_ACCOUNTS_FILE", "local-accounts.json"),
+			AllowRegistration: getenv("LOCAL_ALLOW_REGISTRATION") != "false",
+			MinPasswordLength: minLen,
+		}
+	}
+
+	// Phone provider — enabled by PHONE_ENABLED=true
+	if getenv("PHONE_ENABLED") == "true" {
+		pc := ProviderConfig{
+			SMS: SMSConfig{
+				Gateway:          getenv("SMS_GATEWAY"),
+				TwilioAccountSID: getenv("TWILIO_ACCOUNT_SID"),
+				TwilioFrom:       getenv("TWILIO_FROM"),
+				WebhookURL:       getenv("SMS_WEBHOOK_URL"),
+			},
+			AppName: getenvDefault("PHONE_APP_NAME", "CentralAuth"),
+		}
+		if pc.SMS.TwilioAuthToken, err = getenvSecret("TWILIO_AUTH_TOKEN"); err != nil {
+			return nil, err
+		}
+		if pc.SMS.WebhookToken, err = getenvSecret("SMS_WEBHOOK_TOKEN"); err != nil {
+			return nil, err
+		}
+		if pc.CodeTTL, err = getenvDuration("PHONE_CODE_TTL"); err != nil {
+			return nil, err
+		}
+		cfg.Providers["phone"] = pc
+	}
+
+	// LDAP provider — enabled by presence of LDAP_URL
+	if u := getenv("LDAP_URL"); u != "" {
+		pc := ProviderConfig{
+			LDAP: LDAPConfig{
+				URL:      u,
+				StartTLS: getenv("LDAP_START_TLS") == "true",
+				CAFile:   getenv("LDAP_CA_FILE"),
+				BindDN:   getenv("LDAP_BIND_DN"),
+				BaseDN:   getenv("LDAP_BASE_DN"),
+				UserAttr: getenvDefault("LDAP_USER_ATTR", "uid"),
+				IDAttr:   getenvDefault("LDAP_ID_ATTR", "entryUUID"),
+			},
+		}
+		if pc.LDAP.BindPassword, err = getenvSecret("LDAP_BIND_PASSWORD"); err != nil {
+			return nil, err
 		}
+		cfg.Providers["ldap"] = pc
+	}
+
+	// Guest provider — enabled by GUEST_ENABLED=true
+	if getenv("GUEST_ENABLED") == "true" {
+		var pc ProviderConfig
+		if pc.Lifetime, err = getenvDuration("GUEST_LIFETIME"); err != nil {
+			return nil, err
+		}
+		cfg.Providers["guest"] = pc
+	}
+
+	// Generic OAuth2 providers — one per name in GENERIC_PROVIDERS, each
+	// configured by GENERIC_<NAME>_* variables
+	for _, name := range splitComma(getenv("GENERIC_PROVIDERS")) {
+		if _, taken := cfg.Providers[name]; taken {
+			return nil, fmt.Errorf("%w: generic provider %q clashes with a built-in provider", domain.ErrInvalidConfig, name)
+		}
+		prefix := "GENERIC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		pc := ProviderConfig{
+			ClientID: getenv(prefix + "CLIENT_ID"),
+			Scopes:   splitComma(getenv(prefix + "SCOPES")),
+			OAuth2: &OAuth2Config{
+				AuthURL:   getenv(prefix + "AUTH_URL"),
+				TokenURL:  getenv(prefix + "TOKEN_URL"),
+				UserURL:   getenv(prefix + "USER_URL"),
+				TokenAuth: getenvDefault(prefix+"TOKEN_AUTH", "post"),
+				Mapping:   make(map[string]string),
+			},
+		}
+		if pc.ClientSecret, err = getenvSecret(prefix + "CLIENT_SECRET"); err != nil {
+			return nil, err
+		}
+		// GENERIC_<NAME>_MAP="id -> $.data.id, username -> $.data.login"
+		for _, pair := range splitComma(getenv(prefix + "MAP")) {
+			field, path, ok := strings.Cut(pair, "->")
+			if !ok {
+				return nil, fmt.Errorf("%w: %sMAP entry %q must look like field -> $.path", domain.ErrInvalidConfig, prefix, pair)
+			}
+			pc.OAuth2.Mapping[strings.TrimSpace(field)] = strings.TrimSpace(path)
+		}
+		cfg.Providers[name] = pc
+	}
+
+	// Exchange concurrency limits and upstream HTTP policy — PROVIDER_* sets
+	// the default, <PROVIDER>_* overrides it
+	for name, pc := range cfg.Providers {
+		prefix := strings.ToUpper(name)
+		if pc.MaxConcurrency, err = getenvInt(prefix+"_MAX_CONCURRENCY", "PROVIDER_MAX_CONCURRENCY"); err != nil {
+			return nil, err
+		}
+		if pc.MaxWait, err = getenvDuration(prefix+"_MAX_WAIT", "PROVIDER_MAX_WAIT"); err != nil {
+			return nil, err
+		}
+		if pc.HTTPTimeout, err = getenvDuration(prefix+"_HTTP_TIMEOUT", "PROVIDER_HTTP_TIMEOUT"); err != nil {
+			return nil, err
+		}
+		retries := getenvDefault(prefix+"_HTTP_RETRIES", getenvDefault("PROVIDER_HTTP_RETRIES", "2"))
+		if pc.HTTPRetries, err = strconv.Atoi(retries); err != nil || pc.HTTPRetries < 0 {
+			return nil, fmt.Errorf("%w: %s_HTTP_RETRIES must be a non-negative number", domain.ErrInvalidConfig, prefix)
+		}
+		pc.HTTPProxy = getenvDefault(prefix+"_HTTP_PROXY", getenv("PROVIDER_HTTP_PROXY"))
+		pc.NoProxy = splitComma(getenvDefault(prefix+"_NO_PROXY", getenv("PROVIDER_NO_PROXY")))
+		cfg.Providers[name] = pc
 	}
 
 	// Client discovery — scan env for CLIENT_<ID>_API_KEY
-	cfg.Clients = discoverClients()
+	if cfg.Clients, err = discoverClients(); err != nil {
+		return nil, err
+	}
+	cfg.ClientsFile = getenv("CLIENTS_FILE")
+	if cfg.ClientsReloadInterval, err = getenvDuration("CLIENTS_RELOAD_INTERVAL"); err != nil {
+		return nil, err
+	}
+	cfg.ClientsDB.Driver = getenv("CLIENTS_DB_DRIVER")
+	if cfg.ClientsDB.DSN, err = getenvSecret("CLIENTS_DB_DSN"); err != nil {
+		return nil, err
+	}
+	cfg.ClientsDBSeed = getenv("CLIENTS_DB_SEED") == "true"
+	cfg.IdentityDB.Driver = getenv("IDENTITY_DB_DRIVER")
+	if cfg.IdentityDB.DSN, err = getenvSecret("IDENTITY_DB_DSN"); err != nil {
+		return nil, err
+	}
+	if cfg.LoginRetention, err = getenvDuration("LOGIN_HISTORY_RETENTION"); err != nil {
+		return nil, err
+	}
+	cfg.RoleRulesFile = getenv("ROLE_RULES_FILE")
+	if cfg.ClientRetention, err = getenvDuration("CLIENT_DELETE_RETENTION"); err != nil {
+		return nil, err
+	}
+	if cfg.ClientRateLimit, err = getenvRateLimit("CLIENT_RATE_LIMIT"); err != nil {
+		return nil, err
+	}
+	cfg.Store.Backend = getenvDefault("STORE", "memory")
+	if cfg.Store.RedisURL, err = getenvSecret("REDIS_URL"); err != nil {
+		return nil, err
+	}
+	cfg.RateLimit.Store = getenvDefault("RATE_LIMIT_STORE", cfg.Store.Backend)
+	if cfg.RateLimit.RedisURL, err = getenvSecret("RATE_LIMIT_REDIS_URL"); err != nil {
+		return nil, err
+	}
+	if cfg.RateLimit.RedisURL == "" {
+		cfg.RateLimit.RedisURL = cfg.Store.RedisURL
+	}
+	if cfg.RateLimit.PerIP, err = getenvRateLimit("RATE_LIMIT_PER_IP"); err != nil {
+		return nil, err
+	}
+	if cfg.RateLimit.Global, err = getenvRateLimit("RATE_LIMIT_GLOBAL"); err != nil {
+		return nil, err
+	}
+	if cfg.RateLimit.TrustedProxies, err = getenvPrefixes("TRUSTED_PROXIES"); err != nil {
+		return nil, err
+	}
+	cfg.RateLimit.LockoutDisabled = getenv("LOCKOUT_ENABLED") == "false"
+	if cfg.RateLimit.LockoutAfter, err = getenvRateLimit("LOCKOUT_AFTER"); err != nil {
+		return nil, err
+	}
+	if cfg.RateLimit.LockoutDuration, err = getenvDuration("LOCKOUT_DURATION"); err != nil {
+		return nil, err
+	}
+	if cfg.RateLimit.LockoutMaxDuration, err = getenvDuration("LOCKOUT_MAX_DURATION"); err != nil {
+		return nil, err
+	}
 
 	if err := validate(cfg); err != nil {
 		return nil, err
@@ -102,8 +933,9 @@ func LoadFromEnv() (*Config, error) {
 
 // discoverClients scans environment variables for CLIENT_<ID>_API_KEY patterns
 // and builds client configs from related env vars.
-func discoverClients() []ClientConfig {
-	// Collect client IDs from CLIENT_*_API_KEY vars
+func discoverClients() ([]ClientConfig, error) {
+	// Collect client IDs from CLIENT_*_API_KEY vars, and CLIENT_*_PUBLIC
+	// for public clients, which have no key
 	type clientEntry struct {
 		envPrefix string // e.g. "CLIENT_WEBSITE"
 		id        string // e.g. "website"
@@ -112,17 +944,19 @@ func discoverClients() []ClientConfig {
 	var entries []clientEntry
 	seen := make(map[string]bool)
 
-	for _, env := range os.Environ() {
-		key, _, ok := strings.Cut(env, "=")
-		if !ok {
-			continue
-		}
-		if !strings.HasPrefix(key, "CLIENT_") || !strings.HasSuffix(key, "_API_KEY") {
+	for _, env := range environ() {
+		key, value, ok := strings.Cut(env, "=")
+		if !ok || !strings.HasPrefix(key, "CLIENT_") {
 			continue
 		}
 
 		// Extract prefix: CLIENT_WEBSITE_API_KEY → CLIENT_WEBSITE
-		prefix := strings.TrimSuffix(key, "_API_KEY")
+		prefix, ok := strings.CutSuffix(key, "_API_KEY")
+		if !ok {
+			if prefix, ok = strings.CutSuffix(key, "_PUBLIC"); !ok || value != "true" {
+				continue
+			}
+		}
 		if prefix == "CLIENT" {
 			continue // no ID segment
 		}
@@ -144,36 +978,101 @@ func discoverClients() []ClientConfig {
 
 	clients := make([]ClientConfig, 0, len(entries))
 	for _, e := range entries {
-		apiKey := os.Getenv(e.envPrefix + "_API_KEY")
-		if apiKey == "" {
+		apiKey, err := getenvSecret(e.envPrefix + "_API_KEY")
+		if err != nil {
+			return nil, err
+		}
+		public := getenv(e.envPrefix+"_PUBLIC") == "true"
+		if apiKey == "" && !public {
 			continue
 		}
+		secondaryAPIKey, err := getenvSecret(e.envPrefix + "_API_KEY_SECONDARY")
+		if err != nil {
+			return nil, err
+		}
 
-		name := os.Getenv(e.envPrefix + "_NAME")
+		name := getenv(e.envPrefix + "_NAME")
 		if name == "" {
 			name = e.id
 		}
 
 		var callbacks []string
-		if v := os.Getenv(e.envPrefix + "_ALLOWED_CALLBACKS"); v != "" {
+		if v := getenv(e.envPrefix + "_ALLOWED_CALLBACKS"); v != "" {
 			callbacks = splitComma(v)
 		}
 
 		var providers []string
-		if v := os.Getenv(e.envPrefix + "_ALLOWED_PROVIDERS"); v != "" {
+		if v := getenv(e.envPrefix + "_ALLOWED_PROVIDERS"); v != "" {
 			providers = splitComma(v)
 		}
 
+		stripFields := splitComma(getenv(e.envPrefix + "_STRIP_FIELDS"))
+		if err := scope.CheckFields(stripFields); err != nil {
+			return nil, fmt.Errorf("%w: %s_STRIP_FIELDS: %v", domain.ErrInvalidConfig, e.envPrefix, err)
+		}
+
+		guestLifetime, err := getenvDuration(e.envPrefix + "_GUEST_LIFETIME")
+		if err != nil {
+			return nil, err
+		}
+		stateTTL, err := getenvDuration(e.envPrefix + "_STATE_TTL")
+		if err != nil {
+			return nil, err
+		}
+		exchangeCodeTTL, err := getenvDuration(e.envPrefix + "_EXCHANGE_CODE_TTL")
+		if err != nil {
+			return nil, err
+		}
+		refreshTokenTTL, err := getenvDuration(e.envPrefix + "_REFRESH_TOKEN_TTL")
+		if err != nil {
+			return nil, err
+		}
+		rateLimit, err := getenvRateLimit(e.envPrefix + "_RATE_LIMIT")
+		if err != nil {
+			return nil, err
+		}
+		allowedIPs, err := getenvPrefixes(e.envPrefix + "_ALLOWED_IPS")
+		if err != nil {
+			return nil, err
+		}
+		certFingerprints, err := getenvFingerprints(e.envPrefix + "_CERT_FINGERPRINTS")
+		if err != nil {
+			return nil, err
+		}
+		blockedCountries, err := getenvCountries(e.envPrefix + "_BLOCKED_COUNTRIES")
+		if err != nil {
+			return nil, err
+		}
+
 		clients = append(clients, ClientConfig{
-			ID:               e.id,
-			Name:             name,
-			APIKey:           apiKey,
-			AllowedCallbacks: callbacks,
-			AllowedProviders: providers,
+			ID:                    e.id,
+			Name:                  name,
+			APIKey:                apiKey,
+			SecondaryAPIKey:       secondaryAPIKey,
+			AllowedCallbacks:      callbacks,
+			AllowedProviders:      providers,
+			KeyVersion:            getenv(e.envPrefix + "_KEY_VERSION"),
+			RequireCaptcha:        getenv(e.envPrefix+"_REQUIRE_CAPTCHA") == "true",
+			AllowLookup:           getenv(e.envPrefix+"_ALLOW_LOOKUP") == "true",
+			IncludeRaw:            getenv(e.envPrefix+"_INCLUDE_RAW") == "true",
+			Public:                public,
+			AllowTokenPassthrough: getenv(e.envPrefix+"_ALLOW_TOKEN_PASSTHROUGH") == "true",
+			AllowServiceTokens:    getenv(e.envPrefix+"_ALLOW_SERVICE_TOKENS") == "true",
+			StripFields:           stripFields,
+			GuestLifetime:         guestLifetime,
+			StateTTL:              stateTTL,
+			ExchangeCodeTTL:       exchangeCodeTTL,
+			RefreshTokenTTL:       refreshTokenTTL,
+			BackchannelLogoutURI:  getenv(e.envPrefix + "_BACKCHANNEL_LOGOUT_URI"),
+			RateLimit:             rateLimit,
+			AllowedIPs:            allowedIPs,
+			CertFingerprints:      certFingerprints,
+			BlockedCountries:      blockedCountries,
+			Disabled:              getenv(e.envPrefix+"_ENABLED") == "false",
 		})
 	}
 
-	return clients
+	return clients, nil
 }
 
 func validate(cfg *Config) error {
@@ -183,11 +1082,223 @@ func validate(cfg *Config) error {
 	if cfg.Secrets.ExchangeEncryptionKey == "" {
 		return fmt.Errorf("%w: EXCHANGE_ENCRYPTION_KEY is required", domain.ErrMissingConfig)
 	}
-	if len(cfg.Clients) == 0 {
-		return fmt.Errorf("%w: at least one client must be configured (CLIENT_<ID>_API_KEY)", domain.ErrMissingConfig)
+	if m := cfg.Secrets.KeyMode; m != KeyModeAuto && m != KeyModeRaw && m != KeyModeDerive {
+		return fmt.Errorf("%w: EXCHANGE_KEY_MODE must be auto, raw, or derive, got %q", domain.ErrInvalidConfig, m)
+	}
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		return fmt.Errorf("%w: TLS_CERT_FILE and TLS_KEY_FILE must be set together", domain.ErrMissingConfig)
+	}
+	if cfg.TLS.CertFile != "" && len(cfg.TLS.AutocertDomains) > 0 {
+		return fmt.Errorf("%w: TLS_CERT_FILE and TLS_AUTOCERT_DOMAINS are mutually exclusive", domain.ErrInvalidConfig)
+	}
+	if cfg.TLS.ClientCerts && cfg.TLS.CertFile == "" && len(cfg.TLS.AutocertDomains) == 0 {
+		return fmt.Errorf("%w: TLS_CLIENT_CERTS needs TLS_CERT_FILE or TLS_AUTOCERT_DOMAINS", domain.ErrMissingConfig)
+	}
+	if len(cfg.Admin.CertFingerprints) > 0 && (!cfg.TLS.ClientCerts || cfg.Admin.Port != 0) {
+		return fmt.Errorf("%w: ADMIN_CERT_FINGERPRINTS needs TLS_CLIENT_CERTS, and the admin endpoints on the TLS listener rather than ADMIN_PORT", domain.ErrInvalidConfig)
 	}
 	for _, c := range cfg.Clients {
-		if c.APIKey == "" {
+		if len(c.CertFingerprints) > 0 && !cfg.TLS.ClientCerts {
+			return fmt.Errorf("%w: CLIENT_%s_CERT_FINGERPRINTS needs TLS_CLIENT_CERTS", domain.ErrMissingConfig, strings.ToUpper(c.ID))
+		}
+	}
+	if f := cfg.Log.Format; f != "text" && f != "json" {
+		return fmt.Errorf("%w: LOG_FORMAT must be text or json, got %q", domain.ErrInvalidConfig, f)
+	}
+	if _, err := logging.ParseLevel(cfg.Log.Level); err != nil {
+		return fmt.Errorf("%w: LOG_LEVEL must be debug, info, warn or error, got %q", domain.ErrInvalidConfig, cfg.Log.Level)
+	}
+	if cfg.Server.MaxQueryBytes < 0 {
+		return fmt.Errorf("%w: MAX_QUERY_BYTES must be positive, got %d", domain.ErrInvalidConfig, cfg.Server.MaxQueryBytes)
+	}
+	if cfg.Server.MaxHeaderBytes < 0 {
+		return fmt.Errorf("%w: MAX_HEADER_BYTES must be positive, got %d", domain.ErrInvalidConfig, cfg.Server.MaxHeaderBytes)
+	}
+	if p := cfg.Admin.Port; p < 0 || p > 65535 || (p != 0 && p == cfg.Server.Port) {
+		return fmt.Errorf("%w: ADMIN_PORT must be a port other than PORT, got %d", domain.ErrInvalidConfig, p)
+	}
+	if cfg.Admin.Debug && cfg.Admin.Port == 0 {
+		return fmt.Errorf("%w: DEBUG_ENDPOINTS_ENABLED needs ADMIN_PORT", domain.ErrMissingConfig)
+	}
+	for _, o := range cfg.Server.CORSOrigins {
+		if client.Origin(o) != o {
+			return fmt.Errorf("%w: CORS_ALLOWED_ORIGINS must list origins like https://app.example.com, got %q", domain.ErrInvalidConfig, o)
+		}
+	}
+	if strings.ContainsAny(cfg.Server.BasePath, "?# ") {
+		return fmt.Errorf("%w: BASE_PATH must be a plain path, got %q", domain.ErrInvalidConfig, cfg.Server.BasePath)
+	}
+	if cfg.Tokens.StateTTL < 0 || cfg.Tokens.ExchangeCodeTTL < 0 || cfg.Tokens.IDTokenTTL < 0 || cfg.Tokens.DeviceCodeTTL < 0 || cfg.Tokens.SessionTTL < 0 {
+		return fmt.Errorf("%w: STATE_TTL, EXCHANGE_CODE_TTL, ID_TOKEN_TTL, DEVICE_CODE_TTL and SSO_SESSION_TTL must not be negative", domain.ErrInvalidConfig)
+	}
+	for _, k := range []struct{ name, key string }{
+		{"ID_TOKEN_SIGNING_KEY", cfg.Secrets.IDTokenSigningKey},
+		{"ID_TOKEN_SIGNING_KEY_PREVIOUS", cfg.Secrets.PreviousIDTokenSigningKey},
+		{"ID_TOKEN_SIGNING_KEY_NEXT", cfg.Secrets.NextIDTokenSigningKey},
+	} {
+		if k.key == "" {
+			continue
+		}
+		if _, err := idtoken.NewSigner([]byte(k.key)); err != nil {
+			return fmt.Errorf("%w: %s: %v", domain.ErrInvalidConfig, k.name, err)
+		}
+	}
+	if cfg.Secrets.IDTokenSigningKey == "" && (cfg.Secrets.PreviousIDTokenSigningKey != "" || cfg.Secrets.NextIDTokenSigningKey != "") {
+		return fmt.Errorf("%w: ID_TOKEN_SIGNING_KEY_PREVIOUS and ID_TOKEN_SIGNING_KEY_NEXT need ID_TOKEN_SIGNING_KEY", domain.ErrMissingConfig)
+	}
+	if cfg.Tokens.OIDC && (cfg.Secrets.IDTokenSigningKey == "" || cfg.Server.BaseURL == "") {
+		return fmt.Errorf("%w: OIDC_ENABLED needs ID_TOKEN_SIGNING_KEY and BASE_URL", domain.ErrMissingConfig)
+	}
+	if cfg.Tokens.DeviceFlow && (cfg.Secrets.IDTokenSigningKey == "" || cfg.Server.BaseURL == "") {
+		return fmt.Errorf("%w: DEVICE_FLOW_ENABLED needs ID_TOKEN_SIGNING_KEY and BASE_URL", domain.ErrMissingConfig)
+	}
+	if cfg.Tokens.SSO && cfg.Server.BaseURL == "" {
+		return fmt.Errorf("%w: SSO_ENABLED needs BASE_URL", domain.ErrMissingConfig)
+	}
+	for _, c := range cfg.Clients {
+		if c.StateTTL < 0 || c.ExchangeCodeTTL < 0 || c.RefreshTokenTTL < 0 {
+			return fmt.Errorf("%w: client %s: token lifetimes must not be negative", domain.ErrInvalidConfig, c.ID)
+		}
+	}
+	if cfg.Tracing.Enabled {
+		if r := cfg.Tracing.SampleRatio; r < 0 || r > 1 {
+			return fmt.Errorf("%w: TRACING_SAMPLE_RATIO must be between 0 and 1, got %v", domain.ErrInvalidConfig, r)
+		}
+		if u, err := url.Parse(cfg.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: OTEL_EXPORTER_OTLP_ENDPOINT must be an http(s) URL, got %q", domain.ErrInvalidConfig, cfg.Tracing.Endpoint)
+		}
+	}
+	if u := cfg.Hooks.WebhookURL; u != "" {
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%w: POST_AUTH_WEBHOOK_URL must be an http(s) URL, got %q", domain.ErrInvalidConfig, u)
+		}
+	}
+	if len(cfg.GeoIP.BlockedCountries) > 0 && cfg.GeoIP.CountryDB == "" {
+		return fmt.Errorf("%w: GEOIP_BLOCKED_COUNTRIES needs GEOIP_COUNTRY_DB", domain.ErrMissingConfig)
+	}
+	for _, c := range cfg.Clients {
+		if len(c.BlockedCountries) > 0 && cfg.GeoIP.CountryDB == "" {
+			return fmt.Errorf("%w: CLIENT_%s_BLOCKED_COUNTRIES needs GEOIP_COUNTRY_DB", domain.ErrMissingConfig, strings.ToUpper(c.ID))
+		}
+	}
+	switch cfg.Events.Backend {
+	case "", "nats":
+	case "kafka":
+		if len(cfg.Events.KafkaBrokers) == 0 {
+			return fmt.Errorf("%w: EVENTS_KAFKA_BROKERS is required with EVENTS_BACKEND=kafka", domain.ErrMissingConfig)
+		}
+	default:
+		return fmt.Errorf("%w: EVENTS_BACKEND must be nats or kafka, got %q", domain.ErrInvalidConfig, cfg.Events.Backend)
+	}
+	if b := cfg.Metrics.Backend; b != "prometheus" && b != "statsd" {
+		return fmt.Errorf("%w: METRICS_BACKEND must be prometheus or statsd, got %q", domain.ErrInvalidConfig, b)
+	}
+	switch cfg.Captcha.Provider {
+	case "":
+	case "hcaptcha", "turnstile":
+		if cfg.Captcha.SiteKey == "" || cfg.Captcha.Secret == "" {
+			return fmt.Errorf("%w: CAPTCHA_SITE_KEY and CAPTCHA_SECRET are required with CAPTCHA_PROVIDER", domain.ErrMissingConfig)
+		}
+	default:
+		return fmt.Errorf("%w: CAPTCHA_PROVIDER must be hcaptcha or turnstile, got %q", domain.ErrInvalidConfig, cfg.Captcha.Provider)
+	}
+	if pc, ok := cfg.Providers["phone"]; ok {
+		switch pc.SMS.Gateway {
+		case "twilio":
+			if pc.SMS.TwilioAccountSID == "" || pc.SMS.TwilioAuthToken == "" || pc.SMS.TwilioFrom == "" {
+				return fmt.Errorf("%w: TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN, and TWILIO_FROM are required with SMS_GATEWAY=twilio", domain.ErrMissingConfig)
+			}
+		case "webhook":
+			if pc.SMS.WebhookURL == "" {
+				return fmt.Errorf("%w: SMS_WEBHOOK_URL is required with SMS_GATEWAY=webhook", domain.ErrMissingConfig)
+			}
+		default:
+			return fmt.Errorf("%w: SMS_GATEWAY must be twilio or webhook, got %q", domain.ErrInvalidConfig, pc.SMS.Gateway)
+		}
+	}
+	if pc, ok := cfg.Providers["ldap"]; ok {
+		if pc.LDAP.BaseDN == "" {
+			return fmt.Errorf("%w: LDAP_BASE_DN is required with LDAP_URL", domain.ErrMissingConfig)
+		}
+		if pc.LDAP.BindDN != "" && pc.LDAP.BindPassword == "" {
+			return fmt.Errorf("%w: LDAP_BIND_PASSWORD is required with LDAP_BIND_DN", domain.ErrMissingConfig)
+		}
+	}
+	if pc, ok := cfg.Providers["facebook"]; ok && pc.ClientSecret == "" {
+		return fmt.Errorf("%w: FACEBOOK_APP_SECRET is required with FACEBOOK_APP_ID", domain.ErrMissingConfig)
+	}
+	if pc, ok := cfg.Providers["twitter"]; ok && pc.ClientSecret == "" {
+		return fmt.Errorf("%w: TWITTER_CLIENT_SECRET is required with TWITTER_CLIENT_ID", domain.ErrMissingConfig)
+	}
+	if pc, ok := cfg.Providers["roblox"]; ok && pc.ClientSecret == "" {
+		return fmt.Errorf("%w: ROBLOX_CLIENT_SECRET is required with ROBLOX_CLIENT_ID", domain.ErrMissingConfig)
+	}
+	if pc, ok := cfg.Providers["minecraft"]; ok && pc.ClientSecret == "" {
+		return fmt.Errorf("%w: MINECRAFT_CLIENT_SECRET is required with MINECRAFT_CLIENT_ID", domain.ErrMissingConfig)
+	}
+	if pc, ok := cfg.Providers["oidc"]; ok && pc.ClientID == "" {
+		return fmt.Errorf("%w: OIDC_CLIENT_ID is required with OIDC_ISSUER_URL", domain.ErrMissingConfig)
+	}
+	for name, pc := range cfg.Providers {
+		if p := pc.HTTPProxy; p != "" && p != "direct" {
+			if u, err := url.Parse(p); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+				return fmt.Errorf("%w: %s_HTTP_PROXY must be an http, https, or socks5 URL, or direct", domain.ErrInvalidConfig, strings.ToUpper(name))
+			}
+		}
+	}
+	for name, pc := range cfg.Providers {
+		o := pc.OAuth2
+		if o == nil {
+			continue
+		}
+		prefix := "GENERIC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		if pc.ClientID == "" || o.AuthURL == "" || o.TokenURL == "" || o.UserURL == "" {
+			return fmt.Errorf("%w: %[2]sCLIENT_ID, %[2]sAUTH_URL, %[2]sTOKEN_URL, and %[2]sUSER_URL are required", domain.ErrMissingConfig, prefix)
+		}
+		if o.Mapping["id"] == "" {
+			return fmt.Errorf("%w: %sMAP must map id", domain.ErrMissingConfig, prefix)
+		}
+		if o.TokenAuth != "post" && o.TokenAuth != "basic" {
+			return fmt.Errorf("%w: %sTOKEN_AUTH must be post or basic, got %q", domain.ErrInvalidConfig, prefix, o.TokenAuth)
+		}
+	}
+	if db := cfg.ClientsDB; db.Driver != "" || db.DSN != "" {
+		if db.Driver != "postgres" && db.Driver != "sqlite" {
+			return fmt.Errorf("%w: CLIENTS_DB_DRIVER must be postgres or sqlite, got %q", domain.ErrInvalidConfig, db.Driver)
+		}
+		if db.DSN == "" {
+			return fmt.Errorf("%w: CLIENTS_DB_DSN is required with CLIENTS_DB_DRIVER", domain.ErrMissingConfig)
+		}
+		if cfg.ClientsFile != "" {
+			return fmt.Errorf("%w: CLIENTS_FILE and CLIENTS_DB_DRIVER are mutually exclusive", domain.ErrInvalidConfig)
+		}
+	}
+	if db := cfg.IdentityDB; db.Driver != "" || db.DSN != "" {
+		if db.Driver != "postgres" && db.Driver != "sqlite" {
+			return fmt.Errorf("%w: IDENTITY_DB_DRIVER must be postgres or sqlite, got %q", domain.ErrInvalidConfig, db.Driver)
+		}
+		if db.DSN == "" {
+			return fmt.Errorf("%w: IDENTITY_DB_DSN is required with IDENTITY_DB_DRIVER", domain.ErrMissingConfig)
+		}
+	}
+	if err := validateStore("STORE", "REDIS_URL", cfg.Store.Backend, cfg.Store.RedisURL); err != nil {
+		return err
+	}
+	if err := validateStore("RATE_LIMIT_STORE", "RATE_LIMIT_REDIS_URL or REDIS_URL", cfg.RateLimit.Store, cfg.RateLimit.RedisURL); err != nil {
+		return err
+	}
+	if rl := cfg.RateLimit; rl.LockoutDuration < 0 || rl.LockoutMaxDuration < 0 ||
+		(rl.LockoutMaxDuration != 0 && rl.LockoutDuration > rl.LockoutMaxDuration) {
+		return fmt.Errorf("%w: LOCKOUT_DURATION and LOCKOUT_MAX_DURATION must be positive, and the first no longer than the second", domain.ErrInvalidConfig)
+	}
+	if len(cfg.Clients) == 0 && cfg.ClientsFile == "" && cfg.ClientsDB.Driver == "" {
+		return fmt.Errorf("%w: at least one client must be configured (CLIENT_<ID>_API_KEY, CLIENTS_FILE, or CLIENTS_DB_DRIVER)", domain.ErrMissingConfig)
+	}
+	for _, c := range cfg.Clients {
+		if c.Public && (c.APIKey != "" || c.SecondaryAPIKey != "") {
+			return fmt.Errorf("%w: client %q is PUBLIC and can't have an API_KEY", domain.ErrInvalidConfig, c.ID)
+		}
+		if c.APIKey == "" && !c.Public {
 			return fmt.Errorf("%w: client %q API_KEY is required", domain.ErrMissingConfig, c.ID)
 		}
 	}
@@ -195,12 +1306,153 @@ func validate(cfg *Config) error {
 }
 
 func getenvDefault(key, fallback string) string {
-	if v := os.Getenv(key); v != "" {
+	if v := getenv(key); v != "" {
 		return v
 	}
 	return fallback
 }
 
+// getenvInt parses the first set variable among keys as an integer (0 if none is set).
+func getenvInt(keys ...string) (int, error) {
+	for _, key := range keys {
+		v := getenv(key)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s must be a number: %v", domain.ErrInvalidConfig, key, err)
+		}
+		return n, nil
+	}
+	return 0, nil
+}
+
+// getenvDuration parses the first set variable among keys as a duration (0 if none is set).
+func getenvDuration(keys ...string) (time.Duration, error) {
+	for _, key := range keys {
+		v := getenv(key)
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s must be a duration: %v", domain.ErrInvalidConfig, key, err)
+		}
+		return d, nil
+	}
+	return 0, nil
+}
+
+// getenvPrefixes parses key as comma-separated CIDR prefixes, where a bare
+// IP address stands for itself alone.
+func getenvPrefixes(key string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range splitComma(getenv(key)) {
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			ip, ipErr := netip.ParseAddr(v)
+			if ipErr != nil {
+				return nil, fmt.Errorf("%w: %s: %q is not an IP address or CIDR prefix", domain.ErrInvalidConfig, key, v)
+			}
+			p = netip.PrefixFrom(ip, ip.BitLen())
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// getenvFingerprints parses key as comma-separated SHA-256 certificate
+// fingerprints.
+func getenvFingerprints(key string) ([]string, error) {
+	var fingerprints []string
+	for _, v := range splitComma(getenv(key)) {
+		fp, err := client.ParseFingerprint(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", domain.ErrInvalidConfig, key, err)
+		}
+		fingerprints = append(fingerprints, fp)
+	}
+	return fingerprints, nil
+}
+
+// getenvCountries parses key as comma-separated ISO 3166-1 alpha-2 country
+// codes.
+func getenvCountries(key string) ([]string, error) {
+	var countries []string
+	for _, v := range splitComma(getenv(key)) {
+		code, err := geoip.ParseCountry(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", domain.ErrInvalidConfig, key, err)
+		}
+		countries = append(countries, code)
+	}
+	return countries, nil
+}
+
+// validateStore checks a store setting, named storeKey, and the Redis URL it
+// needs when it is redis.
+func validateStore(storeKey, urlKey, backend, redisURL string) error {
+	switch backend {
+	case "memory":
+	case "redis":
+		if u, err := url.Parse(redisURL); err != nil || u.Host == "" || (u.Scheme != "redis" && u.Scheme != "rediss") {
+			return fmt.Errorf("%w: %s must be a redis:// or rediss:// URL with %s=redis", domain.ErrInvalidConfig, urlKey, storeKey)
+		}
+	default:
+		return fmt.Errorf("%w: %s must be memory or redis, got %q", domain.ErrInvalidConfig, storeKey, backend)
+	}
+	return nil
+}
+
+// getenvRateLimit parses key as a rate limit such as 100/1m (zero if unset).
+func getenvRateLimit(key string) (domain.RateLimit, error) {
+	v := getenv(key)
+	if v == "" {
+		return domain.RateLimit{}, nil
+	}
+	l, err := domain.ParseRateLimit(v)
+	if err != nil {
+		return domain.RateLimit{}, fmt.Errorf("%w: %s: %v", domain.ErrInvalidConfig, key, err)
+	}
+	return l, nil
+}
+
+// getenvOrFile returns the value of key, or the trimmed contents of the file
+// named by key+"_FILE" when key itself is unset.
+func getenvOrFile(key string) (string, error) {
+	if v := getenv(key); v != "" {
+		return v, nil
+	}
+	path := getenv(key + "_FILE")
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: reading %s_FILE: %v", domain.ErrInvalidConfig, key, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// parseHeaders parses comma-separated key=value pairs, as in
+// OTEL_EXPORTER_OTLP_HEADERS. Values may be URL-encoded.
+func parseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range splitComma(s) {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("%q is not key=value", pair)
+		}
+		v, err := url.QueryUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %v", k, err)
+		}
+		headers[strings.TrimSpace(k)] = v
+	}
+	return headers, nil
+}
+
 func splitComma(s string) []string {
 	if s == "" {
 		return nil
@@ -2,9 +2,14 @@ package config
 
 import (
 	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/pkg/testutil"
 )
 
 // setRequiredEnv sets the minimum required env vars for a valid config.
@@ -354,3 +359,1456 @@ func TestSplitComma(t *testing.T) {
 	}
 }
 
+func TestLoadFromEnv_Region(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("REGION", "eu-west")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Server.Region != "eu-west" {
+		t.Errorf("expected region 'eu-west', got %q", cfg.Server.Region)
+	}
+}
+
+func TestLoadFromEnv_SecretsFromFile(t *testing.T) {
+	t.Setenv("CLIENT_WEBSITE_API_KEY", "test-api-key")
+
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "state_key")
+	encFile := filepath.Join(dir, "exchange_key")
+	os.WriteFile(stateFile, []byte("shared-state-key\n"), 0o600)
+	os.WriteFile(encFile, []byte("shared-exchange-key\n"), 0o600)
+	t.Setenv("STATE_SIGNING_KEY_FILE", stateFile)
+	t.Setenv("EXCHANGE_ENCRYPTION_KEY_FILE", encFile)
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Secrets.StateSigningKey != "shared-state-key" {
+		t.Errorf("expected key from file, got %q", cfg.Secrets.StateSigningKey)
+	}
+	if cfg.Secrets.ExchangeEncryptionKey != "shared-exchange-key" {
+		t.Errorf("expected key from file, got %q", cfg.Secrets.ExchangeEncryptionKey)
+	}
+}
+
+func TestLoadFromEnv_SecretFileMissing(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("STATE_SIGNING_KEY", "")
+	t.Setenv("STATE_SIGNING_KEY_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	_, err := LoadFromEnv()
+	if !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_PreviousKeys(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("STATE_SIGNING_KEY_PREVIOUS", "old-state-key")
+	t.Setenv("EXCHANGE_ENCRYPTION_KEY_PREVIOUS", "old-exchange-key-0123456789abcde")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Secrets.PreviousStateSigningKey != "old-state-key" {
+		t.Errorf("expected the previous state key, got %q", cfg.Secrets.PreviousStateSigningKey)
+	}
+	if cfg.Secrets.PreviousExchangeEncryptionKey != "old-exchange-key-0123456789abcde" {
+		t.Errorf("expected the previous exchange key, got %q", cfg.Secrets.PreviousExchangeEncryptionKey)
+	}
+}
+
+func TestLoadFromEnv_ProviderConcurrencyLimits(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("DISCORD_CLIENT_ID", "discord-id")
+	t.Setenv("STEAM_API_KEY", "steam-key")
+	t.Setenv("PROVIDER_MAX_CONCURRENCY", "20")
+	t.Setenv("PROVIDER_MAX_WAIT", "3s")
+	t.Setenv("STEAM_MAX_CONCURRENCY", "5")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := cfg.Providers["discord"].MaxConcurrency; got != 20 {
+		t.Errorf("expected discord to inherit global limit 20, got %d", got)
+	}
+	if got := cfg.Providers["steam"].MaxConcurrency; got != 5 {
+		t.Errorf("expected steam override 5, got %d", got)
+	}
+	if got := cfg.Providers["steam"].MaxWait; got != 3*time.Second {
+		t.Errorf("expected steam max wait 3s, got %v", got)
+	}
+}
+
+func TestLoadFromEnv_InvalidConcurrencyLimit(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("DISCORD_CLIENT_ID", "discord-id")
+	t.Setenv("DISCORD_MAX_WAIT", "soon")
+
+	_, err := LoadFromEnv()
+	if !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_ProviderHTTPPolicy(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("DISCORD_CLIENT_ID", "discord-id")
+	t.Setenv("STEAM_API_KEY", "steam-key")
+	t.Setenv("PROVIDER_HTTP_TIMEOUT", "4s")
+	t.Setenv("STEAM_HTTP_TIMEOUT", "15s")
+	t.Setenv("STEAM_HTTP_RETRIES", "0")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	discord, steam := cfg.Providers["discord"], cfg.Providers["steam"]
+	if discord.HTTPTimeout != 4*time.Second || discord.HTTPRetries != 2 {
+		t.Errorf("expected discord to get 4s and the default 2 retries, got %v and %d", discord.HTTPTimeout, discord.HTTPRetries)
+	}
+	if steam.HTTPTimeout != 15*time.Second || steam.HTTPRetries != 0 {
+		t.Errorf("expected steam overrides 15s and 0 retries, got %v and %d", steam.HTTPTimeout, steam.HTTPRetries)
+	}
+}
+
+func TestLoadFromEnv_InvalidHTTPRetries(t *testing.T) {
+	for _, v := range []string{"many", "-1"} {
+		setRequiredEnv(t)
+		t.Setenv("DISCORD_CLIENT_ID", "discord-id")
+		t.Setenv("PROVIDER_HTTP_RETRIES", v)
+
+		if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+			t.Errorf("%q: expected ErrInvalidConfig, got %v", v, err)
+		}
+	}
+}
+
+func TestLoadFromEnv_ProviderHTTPProxy(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("DISCORD_CLIENT_ID", "discord-id")
+	t.Setenv("STEAM_API_KEY", "steam-key")
+	t.Setenv("PROVIDER_HTTP_PROXY", "http://proxy.corp:3128")
+	t.Setenv("PROVIDER_NO_PROXY", ".corp,10.0.0.0/8")
+	t.Setenv("STEAM_HTTP_PROXY", "direct")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	discord := cfg.Providers["discord"]
+	if discord.HTTPProxy != "http://proxy.corp:3128" || len(discord.NoProxy) != 2 {
+		t.Errorf("expected discord to use the global proxy, got %q %v", discord.HTTPProxy, discord.NoProxy)
+	}
+	if got := cfg.Providers["steam"].HTTPProxy; got != "direct" {
+		t.Errorf("expected steam override direct, got %q", got)
+	}
+}
+
+func TestLoadFromEnv_InvalidHTTPProxy(t *testing.T) {
+	for _, v := range []string{"proxy.corp:3128", "ftp://proxy.corp"} {
+		setRequiredEnv(t)
+		t.Setenv("DISCORD_CLIENT_ID", "discord-id")
+		t.Setenv("DISCORD_HTTP_PROXY", v)
+
+		if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+			t.Errorf("%q: expected ErrInvalidConfig, got %v", v, err)
+		}
+	}
+}
+
+func TestLoadFromEnv_StatsDMetrics(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("METRICS_ENABLED", "true")
+	t.Setenv("METRICS_BACKEND", "statsd")
+	t.Setenv("STATSD_ADDR", "datadog-agent:8125")
+	t.Setenv("STATSD_TAGS", "env:prod, service:centralauth")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Metrics.Backend != "statsd" || cfg.Metrics.StatsDAddr != "datadog-agent:8125" {
+		t.Errorf("unexpected metrics config: %+v", cfg.Metrics)
+	}
+	if len(cfg.Metrics.StatsDTags) != 2 || cfg.Metrics.StatsDTags[1] != "service:centralauth" {
+		t.Errorf("unexpected statsd tags: %v", cfg.Metrics.StatsDTags)
+	}
+}
+
+func TestLoadFromEnv_InvalidMetricsBackend(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("METRICS_BACKEND", "graphite")
+
+	_, err := LoadFromEnv()
+	if !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_Log(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Log.Format != "text" || cfg.Log.Level != "info" {
+		t.Errorf("default log config = %+v", cfg.Log)
+	}
+
+	t.Setenv("LOG_FORMAT", "JSON")
+	t.Setenv("LOG_LEVEL", "debug")
+	if cfg, err = LoadFromEnv(); err != nil || cfg.Log.Format != "json" || cfg.Log.Level != "debug" {
+		t.Errorf("log config = %+v, %v", cfg.Log, err)
+	}
+
+	t.Setenv("LOG_LEVEL", "verbose")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for LOG_LEVEL, got %v", err)
+	}
+	t.Setenv("LOG_LEVEL", "info")
+	t.Setenv("LOG_FORMAT", "logfmt")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for LOG_FORMAT, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_Tracing(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Tracing.Enabled || cfg.Tracing.Endpoint != "http://localhost:4318" || cfg.Tracing.ServiceName != "centralauth" || cfg.Tracing.SampleRatio != 1 {
+		t.Errorf("default tracing config = %+v", cfg.Tracing)
+	}
+
+	t.Setenv("TRACING_ENABLED", "true")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "https://otlp.example.com")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-api-key=abc%3D, x-team=auth")
+	t.Setenv("TRACING_SAMPLE_RATIO", "0.25")
+	if cfg, err = LoadFromEnv(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Tracing.Endpoint != "https://otlp.example.com" || cfg.Tracing.SampleRatio != 0.25 ||
+		cfg.Tracing.Headers["x-api-key"] != "abc=" || cfg.Tracing.Headers["x-team"] != "auth" {
+		t.Errorf("tracing config = %+v", cfg.Tracing)
+	}
+
+	for key, value := range map[string]string{
+		"TRACING_SAMPLE_RATIO":        "2",
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "localhost:4318",
+		"OTEL_EXPORTER_OTLP_HEADERS":  "x-api-key",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+				t.Errorf("expected ErrInvalidConfig, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadFromEnv_Events(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Events.Backend != "" || cfg.Events.NATSURL != "nats://127.0.0.1:4222" || cfg.Events.Prefix != "centralauth." {
+		t.Errorf("default events config = %+v", cfg.Events)
+	}
+
+	t.Setenv("EVENTS_BACKEND", "kafka")
+	t.Setenv("EVENTS_KAFKA_BROKERS", "kafka-1:9092, kafka-2:9092")
+	t.Setenv("EVENTS_KAFKA_TLS", "true")
+	t.Setenv("EVENTS_PREFIX", "auth.")
+	if cfg, err = LoadFromEnv(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Events.KafkaBrokers) != 2 || cfg.Events.KafkaBrokers[1] != "kafka-2:9092" || !cfg.Events.KafkaTLS || cfg.Events.Prefix != "auth." {
+		t.Errorf("events config = %+v", cfg.Events)
+	}
+
+	t.Setenv("EVENTS_KAFKA_BROKERS", "")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrMissingConfig) {
+		t.Errorf("expected ErrMissingConfig without brokers, got %v", err)
+	}
+	t.Setenv("EVENTS_BACKEND", "rabbitmq")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for an unknown backend, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_Hooks(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Hooks.WebhookURL != "" || len(cfg.Hooks.Script) != 0 || cfg.Hooks.Timeout != 5*time.Second {
+		t.Errorf("default hooks config = %+v", cfg.Hooks)
+	}
+
+	t.Setenv("POST_AUTH_WEBHOOK_URL", "https://policy.internal/sign-in")
+	t.Setenv("POST_AUTH_WEBHOOK_TOKEN", "hook-token")
+	t.Setenv("POST_AUTH_SCRIPT", "/usr/local/bin/check-user --strict")
+	t.Setenv("POST_AUTH_HOOK_TIMEOUT", "2s")
+	if cfg, err = LoadFromEnv(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Hooks.WebhookToken != "hook-token" || len(cfg.Hooks.Script) != 2 || cfg.Hooks.Script[1] != "--strict" || cfg.Hooks.Timeout != 2*time.Second {
+		t.Errorf("hooks config = %+v", cfg.Hooks)
+	}
+
+	t.Setenv("POST_AUTH_WEBHOOK_URL", "policy.internal")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for a webhook URL without a scheme, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_ClientsFileOnly(t *testing.T) {
+	t.Setenv("STATE_SIGNING_KEY", "key1")
+	t.Setenv("EXCHANGE_ENCRYPTION_KEY", "key2")
+	t.Setenv("CLIENTS_FILE", "/etc/centralauth/clients.json")
+	t.Setenv("CLIENTS_RELOAD_INTERVAL", "30s")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ClientsFile != "/etc/centralauth/clients.json" {
+		t.Errorf("ClientsFile = %q", cfg.ClientsFile)
+	}
+	if cfg.ClientsReloadInterval != 30*time.Second {
+		t.Errorf("ClientsReloadInterval = %v, want 30s", cfg.ClientsReloadInterval)
+	}
+}
+
+func TestLoadFromEnv_SecondaryAPIKey(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CLIENT_WEBSITE_API_KEY_SECONDARY", "old-api-key")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Clients) != 1 {
+		t.Fatalf("expected the secondary key not to add a client, got %+v", cfg.Clients)
+	}
+	if cfg.Clients[0].SecondaryAPIKey != "old-api-key" {
+		t.Errorf("SecondaryAPIKey = %q", cfg.Clients[0].SecondaryAPIKey)
+	}
+}
+
+func TestLoadFromEnv_ClientEnabled(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CLIENT_GAME_API_KEY", "game-key")
+	t.Setenv("CLIENT_GAME_ENABLED", "false")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, c := range cfg.Clients {
+		if c.Disabled != (c.ID == "game") {
+			t.Errorf("client %s: Disabled = %v", c.ID, c.Disabled)
+		}
+	}
+}
+
+func TestLoadFromEnv_ClientStripFields(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CLIENT_WEBSITE_STRIP_FIELDS", "email, avatar_url")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cfg.Clients[0].StripFields; len(got) != 2 || got[0] != "email" || got[1] != "avatar_url" {
+		t.Errorf("StripFields = %v", got)
+	}
+
+	t.Setenv("CLIENT_WEBSITE_STRIP_FIELDS", "email,phone")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_ClientAllowedIPs(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CLIENT_WEBSITE_ALLOWED_IPS", "203.0.113.0/24, 198.51.100.7")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cfg.Clients[0].AllowedIPs; len(got) != 2 || got[0].String() != "203.0.113.0/24" || got[1].String() != "198.51.100.7/32" {
+		t.Errorf("AllowedIPs = %v", got)
+	}
+
+	t.Setenv("CLIENT_WEBSITE_ALLOWED_IPS", "backend.internal")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_GeoIP(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("GEOIP_BLOCKED_COUNTRIES", "ru, by")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrMissingConfig) {
+		t.Errorf("expected blocked countries without GEOIP_COUNTRY_DB to be rejected, got %v", err)
+	}
+
+	t.Setenv("GEOIP_COUNTRY_DB", "/var/lib/GeoIP/GeoLite2-Country.mmdb")
+	t.Setenv("CLIENT_WEBSITE_BLOCKED_COUNTRIES", "kp")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Join(cfg.GeoIP.BlockedCountries, ","); got != "RU,BY" {
+		t.Errorf("BlockedCountries = %q", got)
+	}
+	if got := cfg.Clients[0].BlockedCountries; len(got) != 1 || got[0] != "KP" {
+		t.Errorf("client BlockedCountries = %v", got)
+	}
+
+	t.Setenv("CLIENT_WEBSITE_BLOCKED_COUNTRIES", "North Korea")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_ClientRateLimit(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CLIENT_RATE_LIMIT", "100/1m")
+	t.Setenv("CLIENT_WEBSITE_RATE_LIMIT", "5/s")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (domain.RateLimit{Requests: 100, Per: time.Minute}); cfg.ClientRateLimit != want {
+		t.Errorf("ClientRateLimit = %v, want %v", cfg.ClientRateLimit, want)
+	}
+	if want := (domain.RateLimit{Requests: 5, Per: time.Second}); cfg.Clients[0].RateLimit != want {
+		t.Errorf("client RateLimit = %v, want %v", cfg.Clients[0].RateLimit, want)
+	}
+
+	t.Setenv("CLIENT_WEBSITE_RATE_LIMIT", "fast")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_IPRateLimits(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("RATE_LIMIT_PER_IP", "20/1m")
+	t.Setenv("RATE_LIMIT_GLOBAL", "500/s")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.7")
+	t.Setenv("RATE_LIMIT_STORE", "redis")
+	t.Setenv("RATE_LIMIT_REDIS_URL", "redis://:secret@cache:6379/1")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rl := cfg.RateLimit
+	if rl.PerIP != (domain.RateLimit{Requests: 20, Per: time.Minute}) || rl.Global != (domain.RateLimit{Requests: 500, Per: time.Second}) {
+		t.Errorf("PerIP = %v, Global = %v", rl.PerIP, rl.Global)
+	}
+	if len(rl.TrustedProxies) != 2 || rl.TrustedProxies[0].String() != "10.0.0.0/8" || rl.TrustedProxies[1].String() != "192.0.2.7/32" {
+		t.Errorf("TrustedProxies = %v", rl.TrustedProxies)
+	}
+	if rl.Store != "redis" || rl.RedisURL != "redis://:secret@cache:6379/1" {
+		t.Errorf("Store = %q, RedisURL = %q", rl.Store, rl.RedisURL)
+	}
+}
+
+func TestLoadFromEnv_Lockout(t *testing.T) {
+	setRequiredEnv(t)
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.RateLimit.LockoutDisabled {
+		t.Error("expected lockouts to be on by default")
+	}
+
+	t.Setenv("LOCKOUT_AFTER", "5/10m")
+	t.Setenv("LOCKOUT_DURATION", "30s")
+	t.Setenv("LOCKOUT_MAX_DURATION", "2h")
+	if cfg, err = LoadFromEnv(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rl := cfg.RateLimit
+	if rl.LockoutAfter != (domain.RateLimit{Requests: 5, Per: 10 * time.Minute}) || rl.LockoutDuration != 30*time.Second || rl.LockoutMaxDuration != 2*time.Hour {
+		t.Errorf("LockoutAfter = %v, LockoutDuration = %s, LockoutMaxDuration = %s", rl.LockoutAfter, rl.LockoutDuration, rl.LockoutMaxDuration)
+	}
+
+	t.Setenv("LOCKOUT_DURATION", "3h")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected a lockout longer than the max to be rejected, got %v", err)
+	}
+
+	t.Setenv("LOCKOUT_ENABLED", "false")
+	t.Setenv("LOCKOUT_DURATION", "")
+	if cfg, err = LoadFromEnv(); err != nil || !cfg.RateLimit.LockoutDisabled {
+		t.Errorf("LOCKOUT_ENABLED=false: disabled = %v, error %v", cfg != nil && cfg.RateLimit.LockoutDisabled, err)
+	}
+}
+
+func TestLoadFromEnv_Store(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("STORE", "redis")
+	t.Setenv("REDIS_URL", "redis://cache:6379")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Store.Backend != "redis" || cfg.Store.RedisURL != "redis://cache:6379" {
+		t.Errorf("Store = %+v", cfg.Store)
+	}
+	if cfg.RateLimit.Store != "redis" || cfg.RateLimit.RedisURL != "redis://cache:6379" {
+		t.Errorf("expected rate limits to follow STORE, got %q, %q", cfg.RateLimit.Store, cfg.RateLimit.RedisURL)
+	}
+
+	t.Setenv("RATE_LIMIT_STORE", "memory")
+	if cfg, err = LoadFromEnv(); err != nil || cfg.RateLimit.Store != "memory" {
+		t.Errorf("expected RATE_LIMIT_STORE to override STORE, got %v, %v", cfg, err)
+	}
+}
+
+func TestLoadFromEnv_IDTokens(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("ID_TOKEN_SIGNING_KEY", string(testutil.SigningKeyPEM(t)))
+	t.Setenv("BASE_URL", "https://auth.example.com")
+	t.Setenv("ID_TOKEN_TTL", "1m")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Tokens.IDTokenIssuer != "https://auth.example.com" || cfg.Tokens.IDTokenTTL != time.Minute {
+		t.Errorf("Tokens = %+v", cfg.Tokens)
+	}
+
+	t.Setenv("ID_TOKEN_SIGNING_KEY_PREVIOUS", string(testutil.SigningKeyPEM(t)))
+	t.Setenv("ID_TOKEN_SIGNING_KEY_NEXT", string(testutil.SigningKeyPEM(t)))
+	if cfg, err = LoadFromEnv(); err != nil || cfg.Secrets.PreviousIDTokenSigningKey == "" || cfg.Secrets.NextIDTokenSigningKey == "" {
+		t.Errorf("expected the previous and next keys, got %v", err)
+	}
+
+	t.Setenv("ID_TOKEN_SIGNING_KEY_NEXT", "not-a-key")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for a bad next key, got %v", err)
+	}
+	t.Setenv("ID_TOKEN_SIGNING_KEY", "not-a-key")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for a bad key, got %v", err)
+	}
+	t.Setenv("ID_TOKEN_SIGNING_KEY", "")
+	t.Setenv("ID_TOKEN_SIGNING_KEY_NEXT", "")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrMissingConfig) {
+		t.Errorf("expected ErrMissingConfig for a previous key alone, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_OIDC(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("OIDC_ENABLED", "true")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrMissingConfig) {
+		t.Errorf("expected ErrMissingConfig without a signing key, got %v", err)
+	}
+
+	t.Setenv("ID_TOKEN_SIGNING_KEY", string(testutil.SigningKeyPEM(t)))
+	t.Setenv("BASE_URL", "https://auth.example.com")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Tokens.OIDC {
+		t.Error("expected OIDC to be enabled")
+	}
+}
+
+func TestLoadFromEnv_DeviceFlow(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("DEVICE_FLOW_ENABLED", "true")
+	t.Setenv("DEVICE_CODE_TTL", "15m")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrMissingConfig) {
+		t.Errorf("expected ErrMissingConfig without a signing key, got %v", err)
+	}
+
+	t.Setenv("ID_TOKEN_SIGNING_KEY", string(testutil.SigningKeyPEM(t)))
+	t.Setenv("BASE_URL", "https://auth.example.com")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Tokens.DeviceFlow || cfg.Tokens.DeviceCodeTTL != 15*time.Minute {
+		t.Errorf("Tokens = %+v", cfg.Tokens)
+	}
+}
+
+func TestLoadFromEnv_SingleUseState(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("STATE_SINGLE_USE", "true")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Tokens.SingleUseState {
+		t.Error("expected single-use state tokens")
+	}
+}
+
+func TestLoadFromEnv_SSO(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("SSO_ENABLED", "true")
+	t.Setenv("SSO_SESSION_TTL", "24h")
+	t.Setenv("BASE_URL", "")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrMissingConfig) {
+		t.Errorf("expected ErrMissingConfig without BASE_URL, got %v", err)
+	}
+
+	t.Setenv("BASE_URL", "https://auth.example.com")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Tokens.SSO || cfg.Tokens.SessionTTL != 24*time.Hour {
+		t.Errorf("Tokens = %+v", cfg.Tokens)
+	}
+}
+
+func TestLoadFromEnv_InvalidIPRateLimits(t *testing.T) {
+	for name, env := range map[string]map[string]string{
+		"proxy":      {"TRUSTED_PROXIES": "proxy.internal"},
+		"store":      {"RATE_LIMIT_STORE": "memcached"},
+		"redis URL":  {"RATE_LIMIT_STORE": "redis"},
+		"shared":     {"STORE": "memcached"},
+		"shared URL": {"STORE": "redis", "REDIS_URL": "cache:6379"},
+		"limit":      {"RATE_LIMIT_PER_IP": "lots"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			setRequiredEnv(t)
+			for k, v := range env {
+				t.Setenv(k, v)
+			}
+			if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+				t.Errorf("expected ErrInvalidConfig, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadFromEnv_ClientsDB(t *testing.T) {
+	t.Setenv("STATE_SIGNING_KEY", "key1")
+	t.Setenv("EXCHANGE_ENCRYPTION_KEY", "key2")
+	t.Setenv("CLIENTS_DB_DRIVER", "postgres")
+	t.Setenv("CLIENTS_DB_DSN", "postgres://auth@db/centralauth")
+	t.Setenv("CLIENTS_DB_SEED", "true")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ClientsDB.Driver != "postgres" || cfg.ClientsDB.DSN != "postgres://auth@db/centralauth" || !cfg.ClientsDBSeed {
+		t.Errorf("unexpected clients database settings: %+v, seed %v", cfg.ClientsDB, cfg.ClientsDBSeed)
+	}
+
+	t.Setenv("CLIENTS_FILE", "/etc/centralauth/clients.json")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig with CLIENTS_FILE too, got %v", err)
+	}
+	t.Setenv("CLIENTS_FILE", "")
+
+	t.Setenv("CLIENTS_DB_DRIVER", "mysql")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for an unknown driver, got %v", err)
+	}
+
+	t.Setenv("CLIENTS_DB_DRIVER", "sqlite")
+	t.Setenv("CLIENTS_DB_DSN", "")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrMissingConfig) {
+		t.Errorf("expected ErrMissingConfig without a DSN, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_IdentityDB(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("IDENTITY_DB_DRIVER", "sqlite")
+	t.Setenv("IDENTITY_DB_DSN", "/var/lib/centralauth/identities.db")
+	t.Setenv("LOGIN_HISTORY_RETENTION", "720h")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IdentityDB.Driver != "sqlite" || cfg.IdentityDB.DSN != "/var/lib/centralauth/identities.db" {
+		t.Errorf("unexpected identity database settings: %+v", cfg.IdentityDB)
+	}
+	if cfg.LoginRetention != 720*time.Hour {
+		t.Errorf("LoginRetention = %v, want 720h", cfg.LoginRetention)
+	}
+
+	t.Setenv("LOGIN_HISTORY_RETENTION", "forever")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for an invalid retention, got %v", err)
+	}
+	t.Setenv("LOGIN_HISTORY_RETENTION", "")
+
+	t.Setenv("IDENTITY_DB_DRIVER", "mysql")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for an unknown driver, got %v", err)
+	}
+
+	t.Setenv("IDENTITY_DB_DRIVER", "postgres")
+	t.Setenv("IDENTITY_DB_DSN", "")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrMissingConfig) {
+		t.Errorf("expected ErrMissingConfig without a DSN, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_PerClientExchangeKeys(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("EXCHANGE_PER_CLIENT_KEYS", "true")
+	t.Setenv("CLIENT_WEBSITE_KEY_VERSION", "3")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Secrets.PerClientExchangeKeys {
+		t.Error("expected per-client exchange keys to be enabled")
+	}
+	if cfg.Clients[0].KeyVersion != "3" {
+		t.Errorf("KeyVersion = %q, want %q", cfg.Clients[0].KeyVersion, "3")
+	}
+}
+
+func TestLoadFromEnv_LocalProvider(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("LOCAL_ENABLED", "true")
+	t.Setenv("LOCAL_ALLOW_REGISTRATION", "false")
+	t.Setenv("LOCAL_MIN_PASSWORD_LENGTH", "12")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lc, ok := cfg.Providers["local"]
+	if !ok {
+		t.Fatal("expected local provider to be configured")
+	}
+	if lc.AccountsFile != "local-accounts.json" || lc.AllowRegistration || lc.MinPasswordLength != 12 {
+		t.Errorf("unexpected local config: %+v", lc)
+	}
+}
+
+func TestLoadFromEnv_MFA(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("MFA_ENABLED", "true")
+	t.Setenv("MFA_ISSUER", "BlackMission")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.MFA.Enabled || cfg.MFA.Issuer != "BlackMission" || cfg.MFA.SecretsFile != "mfa-secrets.json" {
+		t.Errorf("unexpected MFA config: %+v", cfg.MFA)
+	}
+}
+
+func TestLoadFromEnv_Captcha(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CAPTCHA_PROVIDER", "turnstile")
+	t.Setenv("CAPTCHA_SITE_KEY", "site-key")
+	t.Setenv("CAPTCHA_SECRET", "secret")
+	t.Setenv("CLIENT_WEBSITE_REQUIRE_CAPTCHA", "true")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Captcha.Provider != "turnstile" || cfg.Captcha.SiteKey != "site-key" || cfg.Captcha.Secret != "secret" {
+		t.Errorf("unexpected captcha config: %+v", cfg.Captcha)
+	}
+	if !cfg.Clients[0].RequireCaptcha {
+		t.Error("expected website to require CAPTCHA")
+	}
+}
+
+func TestLoadFromEnv_ClientAllowLookup(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CLIENT_WEBSITE_ALLOW_LOOKUP", "true")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Clients[0].AllowLookup {
+		t.Error("expected website to be allowed lookups")
+	}
+}
+
+func TestLoadFromEnv_ClientIncludeRaw(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CLIENT_WEBSITE_INCLUDE_RAW", "true")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Clients[0].IncludeRaw {
+		t.Error("expected website to receive raw profiles")
+	}
+}
+
+func TestLoadFromEnv_ClientAllowTokenPassthrough(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Clients[0].AllowTokenPassthrough {
+		t.Error("expected token passthrough to be off by default")
+	}
+
+	t.Setenv("CLIENT_WEBSITE_ALLOW_TOKEN_PASSTHROUGH", "true")
+	if cfg, err = LoadFromEnv(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Clients[0].AllowTokenPassthrough {
+		t.Error("expected website to receive provider tokens")
+	}
+}
+
+func TestLoadFromEnv_ClientAllowServiceTokens(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CLIENT_WEBSITE_ALLOW_SERVICE_TOKENS", "true")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Clients[0].AllowServiceTokens {
+		t.Error("expected website to get service tokens")
+	}
+}
+
+func TestLoadFromEnv_PublicClient(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CLIENT_SPA_PUBLIC", "true")
+	t.Setenv("CLIENT_SPA_ALLOWED_CALLBACKS", "https://app.example.com/callback")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Clients) != 2 || cfg.Clients[0].ID != "spa" || !cfg.Clients[0].Public || cfg.Clients[0].APIKey != "" {
+		t.Fatalf("expected a public spa client, got %+v", cfg.Clients)
+	}
+
+	t.Setenv("CLIENT_SPA_API_KEY", "spa-key")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for a public client with an API key, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_ClientBackchannelLogoutURI(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CLIENT_WEBSITE_BACKCHANNEL_LOGOUT_URI", "https://example.com/auth/logout")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Clients[0].BackchannelLogoutURI != "https://example.com/auth/logout" {
+		t.Errorf("BackchannelLogoutURI = %q", cfg.Clients[0].BackchannelLogoutURI)
+	}
+}
+
+func TestLoadFromEnv_InvalidCaptcha(t *testing.T) {
+	tests := map[string]string{
+		"unknown provider": "recaptcha",
+		"missing keys":     "hcaptcha",
+	}
+	for name, provider := range tests {
+		t.Run(name, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("CAPTCHA_PROVIDER", provider)
+			if _, err := LoadFromEnv(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadFromEnv_PhoneProvider(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("PHONE_ENABLED", "true")
+	t.Setenv("SMS_GATEWAY", "twilio")
+	t.Setenv("TWILIO_ACCOUNT_SID", "AC123")
+	t.Setenv("TWILIO_AUTH_TOKEN", "token")
+	t.Setenv("TWILIO_FROM", "+14155550199")
+	t.Setenv("PHONE_CODE_TTL", "3m")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pc, ok := cfg.Providers["phone"]
+	if !ok {
+		t.Fatal("expected phone provider to be configured")
+	}
+	if pc.SMS.Gateway != "twilio" || pc.SMS.TwilioAuthToken != "token" || pc.CodeTTL != 3*time.Minute || pc.AppName != "CentralAuth" {
+		t.Errorf("unexpected phone config: %+v", pc)
+	}
+}
+
+func TestLoadFromEnv_PhoneProviderInvalidGateway(t *testing.T) {
+	tests := map[string]map[string]string{
+		"no gateway":          {},
+		"unknown gateway":     {"SMS_GATEWAY": "carrier-pigeon"},
+		"twilio without keys": {"SMS_GATEWAY": "twilio"},
+		"webhook without url": {"SMS_GATEWAY": "webhook"},
+	}
+	for name, env := range tests {
+		t.Run(name, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("PHONE_ENABLED", "true")
+			for k, v := range env {
+				t.Setenv(k, v)
+			}
+			if _, err := LoadFromEnv(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadFromEnv_GitLabProvider(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("GITLAB_CLIENT_ID", "gitlab-id")
+	t.Setenv("GITLAB_CLIENT_SECRET", "gitlab-secret")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pc := cfg.Providers["gitlab"]
+	if pc.ClientID != "gitlab-id" || pc.ClientSecret != "gitlab-secret" || pc.BaseURL != "https://gitlab.com" {
+		t.Errorf("unexpected gitlab config: %+v", pc)
+	}
+	if len(pc.Scopes) != 1 || pc.Scopes[0] != "read_user" {
+		t.Errorf("expected default scope read_user, got %v", pc.Scopes)
+	}
+
+	t.Setenv("GITLAB_BASE_URL", "https://git.example.com")
+	if cfg, err = LoadFromEnv(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cfg.Providers["gitlab"].BaseURL; got != "https://git.example.com" {
+		t.Errorf("expected self-hosted base URL, got %q", got)
+	}
+}
+
+func TestLoadFromEnv_RedditProvider(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("REDDIT_CLIENT_ID", "reddit-id")
+	t.Setenv("REDDIT_CLIENT_SECRET", "reddit-secret")
+	t.Setenv("REDDIT_USER_AGENT", "web:blackmission:1.0 (by /u/blackmission)")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pc := cfg.Providers["reddit"]
+	if pc.ClientID != "reddit-id" || pc.ClientSecret != "reddit-secret" || pc.UserAgent != "web:blackmission:1.0 (by /u/blackmission)" {
+		t.Errorf("unexpected reddit config: %+v", pc)
+	}
+	if len(pc.Scopes) != 1 || pc.Scopes[0] != "identity" {
+		t.Errorf("expected default scope identity, got %v", pc.Scopes)
+	}
+}
+
+func TestLoadFromEnv_FacebookProvider(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("FACEBOOK_APP_ID", "fb-app")
+
+	if _, err := LoadFromEnv(); err == nil {
+		t.Error("expected an error without FACEBOOK_APP_SECRET")
+	}
+
+	t.Setenv("FACEBOOK_APP_SECRET", "fb-secret")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pc := cfg.Providers["facebook"]
+	if pc.ClientID != "fb-app" || pc.ClientSecret != "fb-secret" || len(pc.Scopes) != 2 {
+		t.Errorf("unexpected facebook config: %+v", pc)
+	}
+}
+
+func TestLoadFromEnv_TwitterProvider(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("TWITTER_CLIENT_ID", "tw-client")
+
+	if _, err := LoadFromEnv(); err == nil {
+		t.Error("expected an error without TWITTER_CLIENT_SECRET")
+	}
+
+	t.Setenv("TWITTER_CLIENT_SECRET", "tw-secret")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pc := cfg.Providers["twitter"]
+	if pc.ClientID != "tw-client" || pc.ClientSecret != "tw-secret" {
+		t.Errorf("unexpected twitter config: %+v", pc)
+	}
+	if len(pc.Scopes) != 2 || pc.Scopes[0] != "tweet.read" || pc.Scopes[1] != "users.read" {
+		t.Errorf("unexpected default scopes: %v", pc.Scopes)
+	}
+}
+
+func TestLoadFromEnv_RobloxProvider(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("ROBLOX_CLIENT_ID", "rbx-client")
+
+	if _, err := LoadFromEnv(); err == nil {
+		t.Error("expected an error without ROBLOX_CLIENT_SECRET")
+	}
+
+	t.Setenv("ROBLOX_CLIENT_SECRET", "rbx-secret")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pc := cfg.Providers["roblox"]
+	if pc.ClientID != "rbx-client" || pc.ClientSecret != "rbx-secret" || len(pc.Scopes) != 2 {
+		t.Errorf("unexpected roblox config: %+v", pc)
+	}
+}
+
+func TestLoadFromEnv_MinecraftProvider(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("MINECRAFT_CLIENT_ID", "azure-app")
+
+	if _, err := LoadFromEnv(); err == nil {
+		t.Error("expected an error without MINECRAFT_CLIENT_SECRET")
+	}
+
+	t.Setenv("MINECRAFT_CLIENT_SECRET", "azure-secret")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pc := cfg.Providers["minecraft"]
+	if pc.ClientID != "azure-app" || pc.ClientSecret != "azure-secret" {
+		t.Errorf("unexpected minecraft config: %+v", pc)
+	}
+}
+
+func TestLoadFromEnv_LDAPProvider(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("LDAP_URL", "ldaps://dc1.corp.example.com")
+
+	if _, err := LoadFromEnv(); err == nil {
+		t.Error("expected an error without LDAP_BASE_DN")
+	}
+
+	t.Setenv("LDAP_BASE_DN", "dc=corp,dc=example,dc=com")
+	t.Setenv("LDAP_BIND_DN", "cn=centralauth,dc=corp,dc=example,dc=com")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Error("expected an error without LDAP_BIND_PASSWORD")
+	}
+
+	t.Setenv("LDAP_BIND_PASSWORD", "service-secret")
+	t.Setenv("LDAP_USER_ATTR", "sAMAccountName")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lc := cfg.Providers["ldap"].LDAP
+	if lc.URL != "ldaps://dc1.corp.example.com" || lc.BindPassword != "service-secret" || lc.StartTLS {
+		t.Errorf("unexpected ldap config: %+v", lc)
+	}
+	if lc.UserAttr != "sAMAccountName" || lc.IDAttr != "entryUUID" {
+		t.Errorf("unexpected attributes: %+v", lc)
+	}
+}
+
+func TestLoadFromEnv_OIDCProvider(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("OIDC_ISSUER_URL", "https://id.example.com/realms/main")
+	t.Setenv("OIDC_CLIENT_ID", "centralauth")
+	t.Setenv("OIDC_CLIENT_SECRET", "secret")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pc, ok := cfg.Providers["oidc"]
+	if !ok {
+		t.Fatal("expected oidc provider to be configured")
+	}
+	if pc.IssuerURL != "https://id.example.com/realms/main" || pc.ClientID != "centralauth" || pc.ClientSecret != "secret" {
+		t.Errorf("unexpected oidc config: %+v", pc)
+	}
+	if len(pc.Scopes) != 3 || pc.Scopes[0] != "openid" {
+		t.Errorf("expected default scopes, got %v", pc.Scopes)
+	}
+
+	t.Setenv("OIDC_CLIENT_ID", "")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Error("expected an error without OIDC_CLIENT_ID")
+	}
+}
+
+func TestLoadFromEnv_GenericProviders(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("GENERIC_PROVIDERS", "gitea, my-idp")
+	for _, prefix := range []string{"GENERIC_GITEA_", "GENERIC_MY_IDP_"} {
+		t.Setenv(prefix+"CLIENT_ID", "id")
+		t.Setenv(prefix+"AUTH_URL", "https://example.com/authorize")
+		t.Setenv(prefix+"TOKEN_URL", "https://example.com/token")
+		t.Setenv(prefix+"USER_URL", "https://example.com/user")
+		t.Setenv(prefix+"MAP", "id -> $.data.id, avatar_url -> https://cdn.example.com/{$.data.id}.png?size=64")
+	}
+	t.Setenv("GENERIC_MY_IDP_TOKEN_AUTH", "basic")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gitea, ok := cfg.Providers["gitea"]
+	if !ok || gitea.OAuth2 == nil {
+		t.Fatal("expected gitea to be configured as a generic provider")
+	}
+	if gitea.OAuth2.TokenAuth != "post" || gitea.OAuth2.Mapping["id"] != "$.data.id" ||
+		gitea.OAuth2.Mapping["avatar_url"] != "https://cdn.example.com/{$.data.id}.png?size=64" {
+		t.Errorf("unexpected gitea config: %+v", gitea.OAuth2)
+	}
+	if cfg.Providers["my-idp"].OAuth2.TokenAuth != "basic" {
+		t.Errorf("expected my-idp to use basic auth")
+	}
+}
+
+func TestLoadFromEnv_GenericProviderInvalid(t *testing.T) {
+	tests := map[string]map[string]string{
+		"missing URL":      {"GENERIC_GITEA_TOKEN_URL": ""},
+		"missing id":       {"GENERIC_GITEA_MAP": "username -> $.login"},
+		"bad map entry":    {"GENERIC_GITEA_MAP": "id = $.id"},
+		"bad token auth":   {"GENERIC_GITEA_TOKEN_AUTH": "jwt"},
+		"built-in clashes": {"GENERIC_PROVIDERS": "discord"},
+	}
+	for name, env := range tests {
+		t.Run(name, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("GENERIC_PROVIDERS", "gitea")
+			t.Setenv("GENERIC_GITEA_CLIENT_ID", "id")
+			t.Setenv("GENERIC_GITEA_AUTH_URL", "https://example.com/authorize")
+			t.Setenv("GENERIC_GITEA_TOKEN_URL", "https://example.com/token")
+			t.Setenv("GENERIC_GITEA_USER_URL", "https://example.com/user")
+			t.Setenv("GENERIC_GITEA_MAP", "id -> $.id")
+			for k, v := range env {
+				t.Setenv(k, v)
+			}
+			if _, err := LoadFromEnv(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadFromEnv_GuestProvider(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("GUEST_ENABLED", "true")
+	t.Setenv("GUEST_LIFETIME", "6h")
+	t.Setenv("CLIENT_WEBSITE_GUEST_LIFETIME", "30m")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pc, ok := cfg.Providers["guest"]; !ok || pc.Lifetime != 6*time.Hour {
+		t.Errorf("unexpected guest config: %+v (ok=%v)", pc, ok)
+	}
+	if cfg.Clients[0].GuestLifetime != 30*time.Minute {
+		t.Errorf("GuestLifetime = %v, want 30m", cfg.Clients[0].GuestLifetime)
+	}
+
+	t.Setenv("CLIENT_WEBSITE_GUEST_LIFETIME", "forever")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Error("expected an error for an invalid client guest lifetime")
+	}
+}
+
+func TestLoadFromEnv_TokenTTLs(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("STATE_TTL", "10m")
+	t.Setenv("EXCHANGE_CODE_TTL", "1m")
+	t.Setenv("CLIENT_WEBSITE_STATE_TTL", "2m")
+	t.Setenv("CLIENT_WEBSITE_EXCHANGE_CODE_TTL", "15s")
+	t.Setenv("CLIENT_WEBSITE_REFRESH_TOKEN_TTL", "720h")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Tokens.StateTTL != 10*time.Minute || cfg.Tokens.ExchangeCodeTTL != time.Minute || cfg.Tokens.SingleUseState {
+		t.Errorf("unexpected token config: %+v", cfg.Tokens)
+	}
+	if c := cfg.Clients[0]; c.StateTTL != 2*time.Minute || c.ExchangeCodeTTL != 15*time.Second || c.RefreshTokenTTL != 720*time.Hour {
+		t.Errorf("unexpected client TTLs: %v, %v, %v", c.StateTTL, c.ExchangeCodeTTL, c.RefreshTokenTTL)
+	}
+
+	for key, v := range map[string]string{"STATE_TTL": "soon", "EXCHANGE_CODE_TTL": "-1s", "CLIENT_WEBSITE_STATE_TTL": "-1m", "CLIENT_WEBSITE_REFRESH_TOKEN_TTL": "-1h"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, v)
+			if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+				t.Errorf("expected ErrInvalidConfig, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadFromEnv_ProviderExtras(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("DISCORD_CLIENT_ID", "discord-id")
+	t.Setenv("DISCORD_GUILD_ID", "42")
+	t.Setenv("DISCORD_BOT_TOKEN", "bot-token")
+	t.Setenv("DISCORD_GUILD_FILTER", "42, 77")
+	t.Setenv("STEAM_API_KEY", "steam-key")
+	t.Setenv("STEAM_EXTRAS", "true")
+	t.Setenv("STEAM_APP_ID", "304930")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dc := cfg.Providers["discord"]; dc.GuildID != "42" || dc.BotToken != "bot-token" || len(dc.GuildFilter) != 2 {
+		t.Errorf("unexpected discord config: %+v", dc)
+	}
+	if sc := cfg.Providers["steam"]; !sc.Extras || sc.AppID != "304930" {
+		t.Errorf("unexpected steam config: %+v", sc)
+	}
+}
+
+func TestLoadFromEnv_SteamFetchBans(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("STEAM_API_KEY", "steam-key")
+	t.Setenv("STEAM_FETCH_BANS", "true")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sc := cfg.Providers["steam"]; !sc.FetchBans || sc.Extras {
+		t.Errorf("expected STEAM_FETCH_BANS to turn on only ban lookups, got %+v", sc)
+	}
+}
+
+func TestLoadFromEnv_BasePath(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("BASE_URL", "https://example.com")
+	t.Setenv("BASE_PATH", "authsvc/")
+	t.Setenv("PAGES_DIR", "/etc/centralauth/pages")
+	t.Setenv("SHUTDOWN_DELAY", "5s")
+	t.Setenv("DRAIN_TIMEOUT", "30s")
+	t.Setenv("MAX_QUERY_BYTES", "8192")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Server.BasePath != "/authsvc" {
+		t.Errorf("expected base path '/authsvc', got %q", cfg.Server.BasePath)
+	}
+	if cfg.Server.PagesDir != "/etc/centralauth/pages" {
+		t.Errorf("expected pages dir '/etc/centralauth/pages', got %q", cfg.Server.PagesDir)
+	}
+	if cfg.Server.ShutdownDelay != 5*time.Second {
+		t.Errorf("expected a 5s shutdown delay, got %v", cfg.Server.ShutdownDelay)
+	}
+	if cfg.Server.DrainTimeout != 30*time.Second {
+		t.Errorf("expected a 30s drain timeout, got %v", cfg.Server.DrainTimeout)
+	}
+	if cfg.Server.MaxQueryBytes != 8192 || cfg.Server.MaxHeaderBytes != 16<<10 {
+		t.Errorf("expected request limits 8192 and 16384, got %d and %d", cfg.Server.MaxQueryBytes, cfg.Server.MaxHeaderBytes)
+	}
+	if got := cfg.Server.PublicURL(); got != "https://example.com/authsvc" {
+		t.Errorf("expected the base path in the public URL, got %q", got)
+	}
+
+	t.Setenv("BASE_PATH", "/authsvc?x=1")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_CORS(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CORS_ENABLED", "true")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://Docs.example.com, http://localhost:5173")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Server.CORS || len(cfg.Server.CORSOrigins) != 2 || cfg.Server.CORSOrigins[0] != "https://docs.example.com" {
+		t.Errorf("CORS = %v, origins = %q", cfg.Server.CORS, cfg.Server.CORSOrigins)
+	}
+
+	for _, origin := range []string{"https://docs.example.com/", "*", "docs.example.com"} {
+		t.Setenv("CORS_ALLOWED_ORIGINS", origin)
+		if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+			t.Errorf("%q: expected ErrInvalidConfig, got %v", origin, err)
+		}
+	}
+}
+
+func TestLoadFromEnv_AdminPort(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("HOST", "10.0.0.5")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Admin.Port != 0 || cfg.Admin.Host != "10.0.0.5" {
+		t.Errorf("default admin listener = %s:%d", cfg.Admin.Host, cfg.Admin.Port)
+	}
+
+	t.Setenv("ADMIN_PORT", "9090")
+	t.Setenv("ADMIN_HOST", "127.0.0.1")
+	if cfg, err = LoadFromEnv(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Admin.Port != 9090 || cfg.Admin.Host != "127.0.0.1" {
+		t.Errorf("admin listener = %s:%d", cfg.Admin.Host, cfg.Admin.Port)
+	}
+
+	t.Setenv("DEBUG_ENDPOINTS_ENABLED", "true")
+	if cfg, err = LoadFromEnv(); err != nil || !cfg.Admin.Debug {
+		t.Errorf("expected debug endpoints, got %v", err)
+	}
+	t.Setenv("ADMIN_PORT", "")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrMissingConfig) {
+		t.Errorf("expected ErrMissingConfig for debug endpoints without ADMIN_PORT, got %v", err)
+	}
+	t.Setenv("DEBUG_ENDPOINTS_ENABLED", "")
+
+	for _, port := range []string{"8080", "70000", "admin"} {
+		t.Setenv("ADMIN_PORT", port)
+		if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+			t.Errorf("ADMIN_PORT=%s: expected ErrInvalidConfig, got %v", port, err)
+		}
+	}
+}
+
+func TestServerConfig_PublicURL(t *testing.T) {
+	tests := []struct {
+		baseURL, basePath, want string
+	}{
+		{"https://example.com", "", "https://example.com"},
+		{"https://example.com/", "/authsvc", "https://example.com/authsvc"},
+		{"https://example.com/authsvc", "/authsvc", "https://example.com/authsvc"},
+		{"", "/authsvc", "/authsvc"},
+	}
+	for _, tt := range tests {
+		s := ServerConfig{BaseURL: tt.baseURL, BasePath: tt.basePath}
+		if got := s.PublicURL(); got != tt.want {
+			t.Errorf("PublicURL(%q, %q) = %q, want %q", tt.baseURL, tt.basePath, got, tt.want)
+		}
+	}
+}
+
+func TestLoadFromEnv_TLS(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("TLS_AUTOCERT_DOMAINS", "Auth.Example.com, login.example.com")
+	t.Setenv("TLS_AUTOCERT_EMAIL", "ops@example.com")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Join(cfg.TLS.AutocertDomains, ","); got != "auth.example.com,login.example.com" {
+		t.Errorf("unexpected autocert domains %q", got)
+	}
+	if cfg.TLS.AutocertCacheDir != "autocert" {
+		t.Errorf("expected the default cache dir, got %q", cfg.TLS.AutocertCacheDir)
+	}
+
+	t.Setenv("TLS_CERT_FILE", "/etc/centralauth/cert.pem")
+	t.Setenv("TLS_KEY_FILE", "/etc/centralauth/key.pem")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected certificate files and autocert to conflict, got %v", err)
+	}
+
+	t.Setenv("TLS_AUTOCERT_DOMAINS", "")
+	t.Setenv("TLS_KEY_FILE", "")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrMissingConfig) {
+		t.Errorf("expected a certificate without a key to be rejected, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_ClientCerts(t *testing.T) {
+	setRequiredEnv(t)
+	fp := strings.Repeat("AB:", 31) + "CD"
+	t.Setenv("CLIENT_WEBSITE_CERT_FINGERPRINTS", fp)
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrMissingConfig) {
+		t.Errorf("expected fingerprints without TLS_CLIENT_CERTS to be rejected, got %v", err)
+	}
+
+	t.Setenv("TLS_CLIENT_CERTS", "true")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrMissingConfig) {
+		t.Errorf("expected client certificates without TLS to be rejected, got %v", err)
+	}
+
+	t.Setenv("TLS_CERT_FILE", "/etc/centralauth/cert.pem")
+	t.Setenv("TLS_KEY_FILE", "/etc/centralauth/key.pem")
+	t.Setenv("ADMIN_CERT_FINGERPRINTS", fp)
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := strings.Repeat("ab", 31) + "cd"
+	if !cfg.TLS.ClientCerts || len(cfg.Clients[0].CertFingerprints) != 1 || cfg.Clients[0].CertFingerprints[0] != want {
+		t.Errorf("ClientCerts = %v, client fingerprints = %v", cfg.TLS.ClientCerts, cfg.Clients[0].CertFingerprints)
+	}
+	if len(cfg.Admin.CertFingerprints) != 1 || cfg.Admin.CertFingerprints[0] != want {
+		t.Errorf("admin fingerprints = %v", cfg.Admin.CertFingerprints)
+	}
+
+	t.Setenv("ADMIN_PORT", "9090")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected admin fingerprints on the plain admin listener to be rejected, got %v", err)
+	}
+
+	t.Setenv("ADMIN_PORT", "")
+	t.Setenv("CLIENT_WEBSITE_CERT_FINGERPRINTS", "abcd")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for a short fingerprint, got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+var (
+	// loadMu serializes loads, since fileValues is only set during one.
+	loadMu sync.Mutex
+
+	// fileValues holds the settings of the config file being loaded, keyed
+	// by the environment variables they stand for.
+	fileValues map[string]string
+)
+
+// LoadFromFile reads configuration from a JSON file, with environment
+// variables taking precedence over it.
+//
+// The file uses the environment variables' names, nested and in lower case:
+// {"discord": {"client_id": "..."}} sets DISCORD_CLIENT_ID. Lists become
+// comma-separated values and a "map" object becomes GENERIC_<NAME>_MAP
+// pairs. Providers listed under "generic" are enabled without repeating
+// their names in GENERIC_PROVIDERS.
+func LoadFromFile(path string) (*Config, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return nil, fmt.Errorf("%w: CONFIG_FILE %s: only JSON config files are supported", domain.ErrInvalidConfig, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading CONFIG_FILE: %v", domain.ErrInvalidConfig, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: parsing CONFIG_FILE %s: %v", domain.ErrInvalidConfig, path, err)
+	}
+	values := make(map[string]string)
+	if err := flatten("", doc, values); err != nil {
+		return nil, fmt.Errorf("%w: CONFIG_FILE %s: %v", domain.ErrInvalidConfig, path, err)
+	}
+	if generic, ok := doc["generic"].(map[string]any); ok && values["GENERIC_PROVIDERS"] == "" {
+		names := make([]string, 0, len(generic))
+		for name := range generic {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		values["GENERIC_PROVIDERS"] = strings.Join(names, ",")
+	}
+
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	fileValues = values
+	defer func() { fileValues = nil }()
+	return load()
+}
+
+// flatten turns the nested document v into variables under prefix.
+func flatten(prefix string, v any, out map[string]string) error {
+	switch v := v.(type) {
+	case map[string]any:
+		if strings.HasPrefix(prefix, "GENERIC_") && strings.HasSuffix(prefix, "_MAP") {
+			return flattenMapping(prefix, v, out)
+		}
+		for key, child := range v {
+			name := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+			if prefix != "" {
+				name = prefix + "_" + name
+			}
+			if err := flatten(name, child, out); err != nil {
+				return err
+			}
+		}
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := scalar(prefix, item)
+			if err != nil {
+				return err
+			}
+			items[i] = s
+		}
+		out[prefix] = strings.Join(items, ",")
+	default:
+		s, err := scalar(prefix, v)
+		if err != nil {
+			return err
+		}
+		out[prefix] = s
+	}
+	return nil
+}
+
+// flattenMapping writes a generic provider's field mapping in the
+// "field -> expression" form GENERIC_<NAME>_MAP takes.
+func flattenMapping(name string, m map[string]any, out map[string]string) error {
+	fields := make([]string, 0, len(m))
+	for field := range m {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	pairs := make([]string, len(fields))
+	for i, field := range fields {
+		expr, ok := m[field].(string)
+		if !ok {
+			return fmt.Errorf("%s: %s must map to a string", name, field)
+		}
+		pairs[i] = field + " -> " + expr
+	}
+	out[name] = strings.Join(pairs, ", ")
+	return nil
+}
+
+func scalar(name string, v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("%s must be a string, number, boolean, or list of them", name)
+	}
+}
+
+// getenv returns the environment variable key, or the config file's value
+// for it when the variable is unset or empty.
+func getenv(key string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fileValues[key]
+}
+
+// environ is os.Environ with the config file's values added, so variables
+// found by scanning (such as clients) can come from the file too.
+func environ() []string {
+	env := os.Environ()
+	for key, v := range fileValues {
+		if os.Getenv(key) == "" {
+			env = append(env, key+"="+v)
+		}
+	}
+	return env
+}
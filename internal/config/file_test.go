@@ -0,0 +1,125 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFromFile(t *testing.T) {
+	path := writeConfigFile(t, "centralauth.json", `{
+		"port": 9090,
+		"state_signing_key": "test-signing-key-1234567890123456",
+		"exchange_encryption_key": "test-encrypt-key-1234567890123456",
+		"discord": {"client_id": "discord-id", "client_secret": "discord-secret", "scopes": ["identify", "email"]},
+		"client": {
+			"my-site": {
+				"api_key": "site-key",
+				"allowed_callbacks": ["https://a.example.com/cb", "https://b.example.com/cb"],
+				"include_raw": true
+			}
+		}
+	}`)
+
+	cfg, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Server.Port != 9090 {
+		t.Errorf("expected port 9090, got %d", cfg.Server.Port)
+	}
+	dc, ok := cfg.Providers["discord"]
+	if !ok || dc.ClientID != "discord-id" || len(dc.Scopes) != 2 {
+		t.Errorf("unexpected discord config: %+v", dc)
+	}
+	if len(cfg.Clients) != 1 {
+		t.Fatalf("expected 1 client, got %d", len(cfg.Clients))
+	}
+	c := cfg.Clients[0]
+	if c.ID != "my-site" || c.APIKey != "site-key" || len(c.AllowedCallbacks) != 2 || !c.IncludeRaw {
+		t.Errorf("unexpected client: %+v", c)
+	}
+}
+
+func TestLoadFromFile_EnvOverrides(t *testing.T) {
+	t.Setenv("PORT", "7070")
+	t.Setenv("CLIENT_WEBSITE_API_KEY", "env-key")
+	path := writeConfigFile(t, "centralauth.json", `{
+		"port": 9090,
+		"host": "127.0.0.1",
+		"state_signing_key": "test-signing-key-1234567890123456",
+		"exchange_encryption_key": "test-encrypt-key-1234567890123456",
+		"client": {"website": {"api_key": "file-key"}}
+	}`)
+
+	cfg, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Server.Port != 7070 {
+		t.Errorf("expected PORT from the environment, got %d", cfg.Server.Port)
+	}
+	if cfg.Server.Host != "127.0.0.1" {
+		t.Errorf("expected host from the file, got %q", cfg.Server.Host)
+	}
+	if len(cfg.Clients) != 1 || cfg.Clients[0].APIKey != "env-key" {
+		t.Errorf("expected the environment's API key, got %+v", cfg.Clients)
+	}
+}
+
+func TestLoadFromFile_GenericProviders(t *testing.T) {
+	setRequiredEnv(t)
+	path := writeConfigFile(t, "centralauth.json", `{
+		"generic": {
+			"forum": {
+				"client_id": "forum-id",
+				"client_secret": "forum-secret",
+				"auth_url": "https://forum.example.com/oauth/authorize",
+				"token_url": "https://forum.example.com/oauth/token",
+				"user_url": "https://forum.example.com/api/me",
+				"map": {"id": "$.user.id", "username": "$.user.name"}
+			}
+		}
+	}`)
+
+	cfg, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	forum, ok := cfg.Providers["forum"]
+	if !ok || forum.OAuth2 == nil || forum.OAuth2.UserURL != "https://forum.example.com/api/me" {
+		t.Fatalf("expected the forum provider, got %+v", forum)
+	}
+	if m := forum.OAuth2.Mapping; m["id"] != "$.user.id" || m["username"] != "$.user.name" {
+		t.Errorf("unexpected mapping: %v", m)
+	}
+}
+
+func TestLoadFromFile_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"yaml":           writeConfigFile(t, "centralauth.yaml", "port: 9090\n"),
+		"malformed":      writeConfigFile(t, "centralauth.json", `{"port": `),
+		"nested list":    writeConfigFile(t, "nested.json", `{"discord": {"scopes": [["identify"]]}}`),
+		"missing":        filepath.Join(t.TempDir(), "missing.json"),
+		"non-string map": writeConfigFile(t, "map.json", `{"generic": {"forum": {"map": {"id": 1}}}}`),
+	}
+	for name, path := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadFromFile(path); !errors.Is(err, domain.ErrInvalidConfig) {
+				t.Errorf("expected ErrInvalidConfig, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// Exchange key modes, set with EXCHANGE_KEY_MODE.
+const (
+	// KeyModeAuto uses a 32-byte secret as is and derives a key from any
+	// other secret of at least minPassphrase bytes.
+	KeyModeAuto = "auto"
+	// KeyModeRaw requires the secret to be the 32-byte key itself.
+	KeyModeRaw = "raw"
+	// KeyModeDerive always derives the key, even from a 32-byte secret.
+	KeyModeDerive = "derive"
+)
+
+const minPassphrase = 16
+
+// ExchangeKey returns the 32-byte AES key for EXCHANGE_ENCRYPTION_KEY.
+func (s SecretsConfig) ExchangeKey() ([]byte, error) {
+	return exchangeKey("EXCHANGE_ENCRYPTION_KEY", s.ExchangeEncryptionKey, s.KeyMode)
+}
+
+// PreviousExchangeKey returns the 32-byte AES key for
+// EXCHANGE_ENCRYPTION_KEY_PREVIOUS, or nil if it isn't set.
+func (s SecretsConfig) PreviousExchangeKey() ([]byte, error) {
+	if s.PreviousExchangeEncryptionKey == "" {
+		return nil, nil
+	}
+	return exchangeKey("EXCHANGE_ENCRYPTION_KEY_PREVIOUS", s.PreviousExchangeEncryptionKey, s.KeyMode)
+}
+
+// exchangeKey turns secret into an AES-256 key according to mode. Derived
+// keys are HKDF-SHA256(secret, info = "centralauth exchange key").
+func exchangeKey(name, secret, mode string) ([]byte, error) {
+	n := len(secret)
+	switch mode {
+	case KeyModeRaw:
+		if n != exchangeKeyBytes {
+			return nil, fmt.Errorf("%s must be exactly %d bytes with EXCHANGE_KEY_MODE=raw, got %d", name, exchangeKeyBytes, n)
+		}
+		return []byte(secret), nil
+	case KeyModeAuto, "":
+		if n == exchangeKeyBytes {
+			return []byte(secret), nil
+		}
+		if n < minPassphrase {
+			return nil, fmt.Errorf("%s must be exactly %d bytes, or at least %d bytes to derive a key from, got %d", name, exchangeKeyBytes, minPassphrase, n)
+		}
+	case KeyModeDerive:
+		if n < minPassphrase {
+			return nil, fmt.Errorf("%s must be at least %d bytes to derive a key from, got %d", name, minPassphrase, n)
+		}
+	default:
+		return nil, fmt.Errorf("EXCHANGE_KEY_MODE must be auto, raw, or derive, got %q", mode)
+	}
+	key, err := hkdf.Key(sha256.New, []byte(secret), nil, "centralauth exchange key", exchangeKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("deriving %s: %w", name, err)
+	}
+	return key, nil
+}
+
+// GenerateKeys returns a random state signing key and exchange encryption
+// key. Both are base64url text; the exchange key is exactly 32 bytes long so
+// it works in every key mode.
+func GenerateKeys() (state, exchange string, err error) {
+	stateRaw := make([]byte, 48)
+	exchangeRaw := make([]byte, exchangeKeyBytes*3/4) // 32 characters of base64
+	if _, err := rand.Read(stateRaw); err != nil {
+		return "", "", fmt.Errorf("generating state signing key: %w", err)
+	}
+	if _, err := rand.Read(exchangeRaw); err != nil {
+		return "", "", fmt.Errorf("generating exchange encryption key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(stateRaw), base64.RawURLEncoding.EncodeToString(exchangeRaw), nil
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func TestExchangeKey_Modes(t *testing.T) {
+	raw := "0123456789abcdef0123456789abcdef"
+	passphrase := "correct horse battery staple, twice over"
+
+	tests := []struct {
+		name    string
+		secret  string
+		mode    string
+		derived bool
+		wantErr bool
+	}{
+		{"auto uses a 32-byte key as is", raw, KeyModeAuto, false, false},
+		{"auto derives from a passphrase", passphrase, KeyModeAuto, true, false},
+		{"auto rejects a short secret", "short", KeyModeAuto, false, true},
+		{"raw uses a 32-byte key as is", raw, KeyModeRaw, false, false},
+		{"raw rejects a passphrase", passphrase, KeyModeRaw, false, true},
+		{"derive always derives", raw, KeyModeDerive, true, false},
+		{"derive rejects a short secret", "short", KeyModeDerive, false, true},
+		{"unknown mode", raw, "scrypt", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := SecretsConfig{ExchangeEncryptionKey: tt.secret, KeyMode: tt.mode}.ExchangeKey()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(key) != 32 {
+				t.Fatalf("expected a 32-byte key, got %d", len(key))
+			}
+			if derived := !bytes.Equal(key, []byte(tt.secret)); derived != tt.derived {
+				t.Errorf("derived = %v, want %v", derived, tt.derived)
+			}
+		})
+	}
+}
+
+func TestExchangeKey_Deterministic(t *testing.T) {
+	s := SecretsConfig{ExchangeEncryptionKey: "a passphrase shared by every region", KeyMode: KeyModeAuto}
+	a, _ := s.ExchangeKey()
+	b, _ := s.ExchangeKey()
+	if !bytes.Equal(a, b) {
+		t.Error("expected the same passphrase to derive the same key")
+	}
+	s.ExchangeEncryptionKey += "!"
+	if c, _ := s.ExchangeKey(); bytes.Equal(a, c) {
+		t.Error("expected a different passphrase to derive a different key")
+	}
+}
+
+func TestLoadFromEnv_ExchangeKeyMode(t *testing.T) {
+	setRequiredEnv(t)
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Secrets.KeyMode != KeyModeAuto {
+		t.Errorf("expected the auto mode by default, got %q", cfg.Secrets.KeyMode)
+	}
+
+	t.Setenv("EXCHANGE_KEY_MODE", "scrypt")
+	if _, err := LoadFromEnv(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestGenerateKeys(t *testing.T) {
+	stateKey, exchangeKey, err := GenerateKeys()
+	if err != nil {
+		t.Fatalf("GenerateKeys error: %v", err)
+	}
+	if len(stateKey) < minSigningKey {
+		t.Errorf("state signing key is %d bytes", len(stateKey))
+	}
+	for _, mode := range []string{KeyModeAuto, KeyModeRaw, KeyModeDerive} {
+		if _, err := (SecretsConfig{ExchangeEncryptionKey: exchangeKey, KeyMode: mode}).ExchangeKey(); err != nil {
+			t.Errorf("%s: %v", mode, err)
+		}
+	}
+	if again, _, _ := GenerateKeys(); again == stateKey {
+		t.Error("expected fresh keys on every call")
+	}
+}
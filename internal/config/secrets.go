@@ -0,0 +1,295 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+const (
+	secretTimeout   = 10 * time.Second
+	maxSecretBytes  = 1 << 20
+	awsSecretTarget = "secretsmanager.GetSecretValue"
+)
+
+// secretBackend fetches secrets referenced as <scheme>://<path>#<field>.
+type secretBackend interface {
+	Secret(ctx context.Context, path, field string) (string, error)
+}
+
+// secretBackends maps reference schemes to constructors for their backends,
+// which configure themselves from the environment.
+var secretBackends = map[string]func() (secretBackend, error){
+	"vault":  newVaultBackend,
+	"aws-sm": newAWSBackend,
+}
+
+// getenvSecret is getenvOrFile for values that may also be a reference to a
+// secrets backend, such as vault://secret/centralauth#state_key, in which
+// case the referenced secret is fetched.
+func getenvSecret(key string) (string, error) {
+	v, err := getenvOrFile(key)
+	if err != nil || v == "" {
+		return v, err
+	}
+	scheme, ref, ok := strings.Cut(v, "://")
+	newBackend, known := secretBackends[scheme]
+	if !ok || !known {
+		return v, nil
+	}
+
+	backend, err := newBackend()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %s backend: %v", domain.ErrInvalidConfig, key, scheme, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+	defer cancel()
+	path, field, _ := strings.Cut(ref, "#")
+	secret, err := backend.Secret(ctx, path, field)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: fetching %s://%s: %v", domain.ErrInvalidConfig, key, scheme, path, err)
+	}
+	return secret, nil
+}
+
+// vaultBackend reads secrets from a HashiCorp Vault KV secrets engine.
+type vaultBackend struct {
+	addr      string
+	token     string
+	namespace string
+	kvVersion string
+	client    *http.Client
+}
+
+func newVaultBackend() (secretBackend, error) {
+	addr := getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, errors.New("VAULT_ADDR is not set")
+	}
+	token, err := getenvOrFile("VAULT_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, errors.New("VAULT_TOKEN is not set")
+	}
+	b := &vaultBackend{
+		addr:      strings.TrimSuffix(addr, "/"),
+		token:     token,
+		namespace: getenv("VAULT_NAMESPACE"),
+		kvVersion: getenvDefault("VAULT_KV_VERSION", "2"),
+		client:    &http.Client{},
+	}
+	if b.kvVersion != "1" && b.kvVersion != "2" {
+		return nil, errors.New("VAULT_KV_VERSION must be 1 or 2")
+	}
+	return b, nil
+}
+
+// Secret reads field from the secret at path. For version 2 of the KV
+// engine, path is written as for the vault CLI, without the "data/" segment.
+func (b *vaultBackend) Secret(ctx context.Context, path, field string) (string, error) {
+	if field == "" {
+		return "", errors.New("reference needs a #field")
+	}
+	path = strings.Trim(path, "/")
+	if b.kvVersion == "2" {
+		mount, rest, _ := strings.Cut(path, "/")
+		path = mount + "/data/" + rest
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.addr+"/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", b.token)
+	if b.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", b.namespace)
+	}
+	body, err := doSecretRequest(b.client, req)
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("decoding response: %v", err)
+	}
+	data := resp.Data
+	if b.kvVersion == "2" {
+		var inner struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(data, &inner); err != nil {
+			return "", fmt.Errorf("decoding response: %v", err)
+		}
+		data = inner.Data
+	}
+	return secretField(data, field)
+}
+
+// awsBackend reads secrets from AWS Secrets Manager with the credentials in
+// the standard AWS environment variables.
+type awsBackend struct {
+	endpoint     string
+	region       string
+	accessKeyID  string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+	now          func() time.Time
+}
+
+func newAWSBackend() (secretBackend, error) {
+	region := getenvDefault("AWS_REGION", getenv("AWS_DEFAULT_REGION"))
+	if region == "" {
+		return nil, errors.New("AWS_REGION is not set")
+	}
+	b := &awsBackend{
+		endpoint:     getenvDefault("AWS_ENDPOINT_URL_SECRETS_MANAGER", "https://secretsmanager."+region+".amazonaws.com"),
+		region:       region,
+		accessKeyID:  getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{},
+		now:          time.Now,
+	}
+	if b.accessKeyID == "" || b.secretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return b, nil
+}
+
+// Secret returns the secret named path (a name or ARN). With a field, the
+// secret must be a JSON object and the field's value is returned.
+func (b *awsBackend) Secret(ctx context.Context, path, field string) (string, error) {
+	payload, _ := json.Marshal(map[string]string{"SecretId": path})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.endpoint+"/", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", awsSecretTarget)
+	b.sign(req, payload)
+
+	body, err := doSecretRequest(b.client, req)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		SecretString *string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("decoding response: %v", err)
+	}
+	if resp.SecretString == nil {
+		return "", errors.New("secret has no string value")
+	}
+	if field == "" {
+		return *resp.SecretString, nil
+	}
+	return secretField(json.RawMessage(*resp.SecretString), field)
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (b *awsBackend) sign(req *http.Request, payload []byte) {
+	now := b.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if b.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.sessionToken)
+	}
+
+	headers := []string{"content-type", "host", "x-amz-date"}
+	if b.sessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	headers = append(headers, "x-amz-target")
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		"/",
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(payload),
+	}, "\n")
+	scope := date + "/" + b.region + "/secretsmanager/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + b.secretKey)
+	for _, part := range []string{date, b.region, "secretsmanager", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+b.accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func doSecretRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSecretBytes))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// The body is left out: error responses can echo request details
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return body, nil
+}
+
+// secretField returns field from the JSON object data, as a string.
+func secretField(data json.RawMessage, field string) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", errors.New("secret is not a JSON object")
+	}
+	raw, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", field)
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	return string(raw), nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func newVaultServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/centralauth":
+			w.Write([]byte(`{"data":{"data":{"state_key":"vault-state-key","exchange_key":"vault-exchange-key-0123456789abcd"}}}`))
+		case "/v1/kv/centralauth":
+			w.Write([]byte(`{"data":{"state_key":"v1-state-key"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestLoadFromEnv_VaultSecrets(t *testing.T) {
+	srv := newVaultServer(t)
+	setRequiredEnv(t)
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "vault-token")
+	t.Setenv("STATE_SIGNING_KEY", "vault://secret/centralauth#state_key")
+	t.Setenv("EXCHANGE_ENCRYPTION_KEY", "vault://secret/centralauth#exchange_key")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Secrets.StateSigningKey != "vault-state-key" {
+		t.Errorf("expected the key from Vault, got %q", cfg.Secrets.StateSigningKey)
+	}
+	if cfg.Secrets.ExchangeEncryptionKey != "vault-exchange-key-0123456789abcd" {
+		t.Errorf("expected the key from Vault, got %q", cfg.Secrets.ExchangeEncryptionKey)
+	}
+}
+
+func TestGetenvSecret_VaultKVv1(t *testing.T) {
+	srv := newVaultServer(t)
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "vault-token")
+	t.Setenv("VAULT_KV_VERSION", "1")
+	t.Setenv("STATE_SIGNING_KEY", "vault://kv/centralauth#state_key")
+
+	if got, err := getenvSecret("STATE_SIGNING_KEY"); err != nil || got != "v1-state-key" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestGetenvSecret_VaultErrors(t *testing.T) {
+	srv := newVaultServer(t)
+	tests := map[string]struct {
+		ref   string
+		token string
+	}{
+		"missing field":  {"vault://secret/centralauth#nope", "vault-token"},
+		"no field":       {"vault://secret/centralauth", "vault-token"},
+		"missing secret": {"vault://secret/other#state_key", "vault-token"},
+		"forbidden":      {"vault://secret/centralauth#state_key", "wrong-token"},
+		"no vault token": {"vault://secret/centralauth#state_key", ""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("VAULT_ADDR", srv.URL)
+			t.Setenv("VAULT_TOKEN", tt.token)
+			t.Setenv("STATE_SIGNING_KEY", tt.ref)
+
+			_, err := getenvSecret("STATE_SIGNING_KEY")
+			if !errors.Is(err, domain.ErrInvalidConfig) {
+				t.Errorf("expected ErrInvalidConfig, got %v", err)
+			}
+		})
+	}
+}
+
+func TestGetenvSecret_PlainValues(t *testing.T) {
+	for _, v := range []string{"plain-secret", "https://example.com/not-a-reference"} {
+		t.Setenv("STATE_SIGNING_KEY", v)
+		if got, err := getenvSecret("STATE_SIGNING_KEY"); err != nil || got != v {
+			t.Errorf("%q: got %q, %v", v, got, err)
+		}
+	}
+}
+
+func TestGetenvSecret_AWSSecretsManager(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" {
+			t.Errorf("unexpected target %q", r.Header.Get("X-Amz-Target"))
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") ||
+			!strings.Contains(auth, "/eu-west-1/secretsmanager/aws4_request") ||
+			!strings.Contains(auth, "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target") {
+			t.Errorf("unexpected Authorization header %q", auth)
+		}
+		if r.Header.Get("X-Amz-Security-Token") != "session-token" {
+			t.Errorf("expected the session token to be sent")
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req struct{ SecretId string }
+		json.Unmarshal(body, &req)
+		switch req.SecretId {
+		case "centralauth/discord":
+			w.Write([]byte(`{"SecretString":"discord-secret"}`))
+		case "centralauth/keys":
+			w.Write([]byte(`{"SecretString":"{\"state_key\":\"aws-state-key\"}"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"ResourceNotFoundException"}`))
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ENDPOINT_URL_SECRETS_MANAGER", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret-access-key")
+	t.Setenv("AWS_SESSION_TOKEN", "session-token")
+
+	t.Setenv("DISCORD_CLIENT_SECRET", "aws-sm://centralauth/discord")
+	if got, err := getenvSecret("DISCORD_CLIENT_SECRET"); err != nil || got != "discord-secret" {
+		t.Errorf("whole secret: got %q, %v", got, err)
+	}
+	t.Setenv("STATE_SIGNING_KEY", "aws-sm://centralauth/keys#state_key")
+	if got, err := getenvSecret("STATE_SIGNING_KEY"); err != nil || got != "aws-state-key" {
+		t.Errorf("field: got %q, %v", got, err)
+	}
+	t.Setenv("STATE_SIGNING_KEY", "aws-sm://centralauth/missing")
+	if _, err := getenvSecret("STATE_SIGNING_KEY"); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("missing secret: expected ErrInvalidConfig, got %v", err)
+	}
+}
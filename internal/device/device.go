@@ -0,0 +1,285 @@
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/store"
+)
+
+// Defaults for how long a device has for the user to approve it, and how
+// often it may poll meanwhile.
+const (
+	DefaultTTL      = 10 * time.Minute
+	DefaultInterval = 5 * time.Second
+)
+
+// deviceCodeBytes is the number of random bytes in a device code.
+const deviceCodeBytes = 32
+
+// User codes are typed by people, often on a phone: eight letters without
+// vowels, so they can't spell words, or look-alikes of digits (RFC 8628
+// section 6.1). That's about 34 bits, plenty for codes that expire in
+// minutes behind rate-limited pages.
+const (
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// Store key prefixes. Device codes are stored under their hash, so the
+// store never holds one that could be presented.
+const (
+	grantPrefix    = "device/grant/"
+	userCodePrefix = "device/user/"
+	resultPrefix   = "device/result/"
+	pollPrefix     = "device/poll/"
+	redeemedPrefix = "device/redeemed/"
+)
+
+// Grant is a device's pending request to sign a user in, as the user sees
+// it when they enter its user code.
+type Grant struct {
+	// ID identifies the grant without being its device code.
+	ID        string    `json:"-"`
+	ClientID  string    `json:"client_id"`
+	Scope     string    `json:"scope"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Authorization is what a device is given to start a grant: a device code
+// to poll with, and a user code for the user to enter in a browser.
+type Authorization struct {
+	DeviceCode string
+	UserCode   string
+	ExpiresIn  time.Duration
+	Interval   time.Duration
+}
+
+// outcome is what the user decided about a grant.
+type outcome struct {
+	Denied bool              `json:"denied,omitempty"`
+	Result domain.AuthResult `json:"result"`
+}
+
+// Service runs device authorization grants (RFC 8628), keeping them in a
+// store so that any replica can serve the device and the browser.
+type Service struct {
+	store    store.Store
+	ttl      time.Duration
+	interval time.Duration
+	now      func() time.Time
+	rand     io.Reader
+}
+
+// New creates a service that keeps its grants in s. Grants last ttl, and
+// devices may poll every interval; zero uses the defaults.
+func New(s store.Store, ttl, interval time.Duration) *Service {
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+	return &Service{store: s, ttl: ttl, interval: interval, now: time.Now, rand: rand.Reader}
+}
+
+// SetNow overrides the time function (for testing).
+func (s *Service) SetNow(fn func() time.Time) {
+	s.now = fn
+}
+
+// Start begins a grant for clientID's device, asking for scope.
+func (s *Service) Start(ctx context.Context, clientID, scope string) (Authorization, error) {
+	b := make([]byte, deviceCodeBytes)
+	if _, err := io.ReadFull(s.rand, b); err != nil {
+		return Authorization{}, fmt.Errorf("device: generating device code: %w", err)
+	}
+	deviceCode := base64.RawURLEncoding.EncodeToString(b)
+	id := hash(deviceCode)
+
+	data, err := json.Marshal(Grant{ClientID: clientID, Scope: scope, ExpiresAt: s.now().Add(s.ttl)})
+	if err != nil {
+		return Authorization{}, fmt.Errorf("device: encoding grant: %w", err)
+	}
+	if err := s.store.Set(ctx, grantPrefix+id, data, s.ttl); err != nil {
+		return Authorization{}, fmt.Errorf("device: %w", err)
+	}
+	// A user code that collides with a live one is drawn again
+	for range 5 {
+		userCode, err := s.userCode()
+		if err != nil {
+			return Authorization{}, err
+		}
+		ok, err := s.store.Add(ctx, userCodePrefix+userCode, []byte(id), s.ttl)
+		if err != nil {
+			return Authorization{}, fmt.Errorf("device: %w", err)
+		}
+		if ok {
+			return Authorization{
+				DeviceCode: deviceCode,
+				UserCode:   userCode[:4] + "-" + userCode[4:],
+				ExpiresIn:  s.ttl,
+				Interval:   s.interval,
+			}, nil
+		}
+	}
+	return Authorization{}, fmt.Errorf("device: no free user code")
+}
+
+// Lookup returns the pending grant for a user code as the user typed it,
+// in any case and with or without its dash.
+func (s *Service) Lookup(ctx context.Context, userCode string) (Grant, error) {
+	id, ok, err := s.store.Get(ctx, userCodePrefix+NormalizeUserCode(userCode))
+	if err != nil {
+		return Grant{}, fmt.Errorf("device: %w", err)
+	}
+	if !ok {
+		return Grant{}, domain.ErrInvalidUserCode
+	}
+	grant, err := s.grant(ctx, string(id))
+	if err != nil {
+		return Grant{}, domain.ErrInvalidUserCode
+	}
+	_, decided, err := s.store.Get(ctx, resultPrefix+grant.ID)
+	if err != nil {
+		return Grant{}, fmt.Errorf("device: %w", err)
+	}
+	if decided {
+		return Grant{}, domain.ErrInvalidUserCode
+	}
+	return grant, nil
+}
+
+// Approve completes the grant with id with the result of the user's
+// sign-in. Each grant can be approved or denied once.
+func (s *Service) Approve(ctx context.Context, id string, result domain.AuthResult) error {
+	result.Tokens, result.IDToken, result.RefreshToken = nil, "", ""
+	return s.decide(ctx, id, outcome{Result: result})
+}
+
+// Deny completes the grant with id without signing anyone in.
+func (s *Service) Deny(ctx context.Context, id string) error {
+	return s.decide(ctx, id, outcome{Denied: true})
+}
+
+// Poll is a device asking whether its grant is complete. It returns the
+// result once, after the user has approved it; until then it fails with
+// ErrAuthorizationPending, or ErrSlowDown for a device polling more often
+// than the interval.
+func (s *Service) Poll(ctx context.Context, clientID, deviceCode string) (domain.AuthResult, error) {
+	id := hash(deviceCode)
+	grant, err := s.grant(ctx, id)
+	if err != nil {
+		return domain.AuthResult{}, err
+	}
+	if grant.ClientID != clientID {
+		return domain.AuthResult{}, domain.ErrInvalidDeviceCode
+	}
+	first, err := s.store.Add(ctx, pollPrefix+id, nil, s.interval)
+	if err != nil {
+		return domain.AuthResult{}, fmt.Errorf("device: %w", err)
+	}
+	if !first {
+		return domain.AuthResult{}, domain.ErrSlowDown
+	}
+
+	data, ok, err := s.store.Get(ctx, resultPrefix+id)
+	if err != nil {
+		return domain.AuthResult{}, fmt.Errorf("device: %w", err)
+	}
+	if !ok {
+		return domain.AuthResult{}, domain.ErrAuthorizationPending
+	}
+	var out outcome
+	if err := json.Unmarshal(data, &out); err != nil {
+		return domain.AuthResult{}, fmt.Errorf("device: decoding outcome: %w", err)
+	}
+	if out.Denied {
+		return domain.AuthResult{}, domain.ErrDeviceAccessDenied
+	}
+	first, err = s.store.Add(ctx, redeemedPrefix+id, nil, s.remaining(grant))
+	if err != nil {
+		return domain.AuthResult{}, fmt.Errorf("device: %w", err)
+	}
+	if !first {
+		return domain.AuthResult{}, domain.ErrDeviceCodeUsed
+	}
+	return out.Result, nil
+}
+
+func (s *Service) decide(ctx context.Context, id string, out outcome) error {
+	grant, err := s.grant(ctx, id)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return fmt.Errorf("device: encoding outcome: %w", err)
+	}
+	first, err := s.store.Add(ctx, resultPrefix+id, data, s.remaining(grant))
+	if err != nil {
+		return fmt.Errorf("device: %w", err)
+	}
+	if !first {
+		return domain.ErrDeviceCodeUsed
+	}
+	return nil
+}
+
+// grant returns the live grant with id.
+func (s *Service) grant(ctx context.Context, id string) (Grant, error) {
+	data, ok, err := s.store.Get(ctx, grantPrefix+id)
+	if err != nil {
+		return Grant{}, fmt.Errorf("device: %w", err)
+	}
+	var grant Grant
+	if !ok || json.Unmarshal(data, &grant) != nil {
+		return Grant{}, domain.ErrInvalidDeviceCode
+	}
+	if !s.now().Before(grant.ExpiresAt) {
+		return Grant{}, domain.ErrExpiredDeviceCode
+	}
+	grant.ID = id
+	return grant, nil
+}
+
+func (s *Service) remaining(grant Grant) time.Duration {
+	return max(grant.ExpiresAt.Sub(s.now()), time.Second)
+}
+
+func (s *Service) userCode() (string, error) {
+	b := make([]byte, userCodeLength)
+	if _, err := io.ReadFull(s.rand, b); err != nil {
+		return "", fmt.Errorf("device: generating user code: %w", err)
+	}
+	for i := range b {
+		// 256 is not a multiple of 20, so some letters are slightly more
+		// likely than others; the codes are short-lived enough not to matter
+		b[i] = userCodeAlphabet[int(b[i])%len(userCodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+// NormalizeUserCode returns a user code as typed in its stored form:
+// upper case, without dashes or spaces.
+func NormalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
+
+func hash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
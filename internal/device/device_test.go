@@ -0,0 +1,112 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/store"
+)
+
+func newTestService() (*Service, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	mem := store.NewMemory()
+	mem.SetNow(func() time.Time { return now })
+	s := New(mem, 0, 0)
+	s.SetNow(func() time.Time { return now })
+	return s, &now
+}
+
+var signIn = domain.AuthResult{
+	User:   domain.UserInfo{ProviderName: "discord", ProviderID: "123", Username: "alice"},
+	Scope:  "profile",
+	Tokens: &domain.ProviderTokens{AccessToken: "provider-secret"},
+}
+
+func TestService_Approve(t *testing.T) {
+	s, now := newTestService()
+	ctx := context.Background()
+
+	auth, err := s.Start(ctx, "gameserver", "profile")
+	if err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	if !regexp.MustCompile(`^[B-Z]{4}-[B-Z]{4}$`).MatchString(auth.UserCode) || auth.ExpiresIn != DefaultTTL || auth.Interval != DefaultInterval {
+		t.Errorf("authorization = %+v", auth)
+	}
+	if _, err := s.Poll(ctx, "gameserver", auth.DeviceCode); !errors.Is(err, domain.ErrAuthorizationPending) {
+		t.Errorf("Poll before approval = %v, want ErrAuthorizationPending", err)
+	}
+	if _, err := s.Poll(ctx, "gameserver", auth.DeviceCode); !errors.Is(err, domain.ErrSlowDown) {
+		t.Errorf("Poll within the interval = %v, want ErrSlowDown", err)
+	}
+
+	// Typed by hand, in lower case and without the dash
+	grant, err := s.Lookup(ctx, strings.ToLower(strings.ReplaceAll(auth.UserCode, "-", "")))
+	if err != nil || grant.ClientID != "gameserver" || grant.Scope != "profile" {
+		t.Fatalf("Lookup = %+v, %v", grant, err)
+	}
+	if err := s.Approve(ctx, grant.ID, signIn); err != nil {
+		t.Fatalf("Approve error: %v", err)
+	}
+	if err := s.Deny(ctx, grant.ID); !errors.Is(err, domain.ErrDeviceCodeUsed) {
+		t.Errorf("Deny after approval = %v, want ErrDeviceCodeUsed", err)
+	}
+	if _, err := s.Lookup(ctx, auth.UserCode); !errors.Is(err, domain.ErrInvalidUserCode) {
+		t.Errorf("Lookup after approval = %v, want ErrInvalidUserCode", err)
+	}
+
+	*now = now.Add(DefaultInterval)
+	result, err := s.Poll(ctx, "gameserver", auth.DeviceCode)
+	if err != nil || result.User.Username != "alice" || result.Tokens != nil {
+		t.Fatalf("Poll = %+v, %v; want the sign-in without provider tokens", result, err)
+	}
+	*now = now.Add(DefaultInterval)
+	if _, err := s.Poll(ctx, "gameserver", auth.DeviceCode); !errors.Is(err, domain.ErrDeviceCodeUsed) {
+		t.Errorf("second redemption = %v, want ErrDeviceCodeUsed", err)
+	}
+}
+
+func TestService_Deny(t *testing.T) {
+	s, _ := newTestService()
+	ctx := context.Background()
+	auth, _ := s.Start(ctx, "gameserver", "profile")
+	grant, _ := s.Lookup(ctx, auth.UserCode)
+
+	if err := s.Deny(ctx, grant.ID); err != nil {
+		t.Fatalf("Deny error: %v", err)
+	}
+	if _, err := s.Poll(ctx, "gameserver", auth.DeviceCode); !errors.Is(err, domain.ErrDeviceAccessDenied) {
+		t.Errorf("Poll = %v, want ErrDeviceAccessDenied", err)
+	}
+}
+
+func TestService_Invalid(t *testing.T) {
+	s, now := newTestService()
+	ctx := context.Background()
+	auth, _ := s.Start(ctx, "gameserver", "profile")
+
+	if _, err := s.Poll(ctx, "otherclient", auth.DeviceCode); !errors.Is(err, domain.ErrInvalidDeviceCode) {
+		t.Errorf("another client's code = %v, want ErrInvalidDeviceCode", err)
+	}
+	if _, err := s.Poll(ctx, "gameserver", "made-up"); !errors.Is(err, domain.ErrInvalidDeviceCode) {
+		t.Errorf("unknown code = %v, want ErrInvalidDeviceCode", err)
+	}
+	if _, err := s.Lookup(ctx, "BCDF-GHJK"); !errors.Is(err, domain.ErrInvalidUserCode) {
+		t.Errorf("unknown user code = %v, want ErrInvalidUserCode", err)
+	}
+
+	*now = now.Add(DefaultTTL - time.Second)
+	grant, err := s.Lookup(ctx, auth.UserCode)
+	if err != nil {
+		t.Fatalf("Lookup error: %v", err)
+	}
+	*now = now.Add(time.Second)
+	if err := s.Approve(ctx, grant.ID, signIn); !errors.Is(err, domain.ErrExpiredDeviceCode) && !errors.Is(err, domain.ErrInvalidDeviceCode) {
+		t.Errorf("Approve after expiry = %v", err)
+	}
+}
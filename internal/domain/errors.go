@@ -9,6 +9,10 @@ var (
 	ErrCallbackNotAllowed = errors.New("callback URI not allowed")
 	ErrProviderNotAllowed = errors.New("provider not allowed for this client")
 	ErrDuplicateClientID  = errors.New("duplicate client ID")
+	ErrAPIKeyInUse        = errors.New("API key is already in use")
+	ErrClientDisabled     = errors.New("client is disabled")
+	ErrPublicClient       = errors.New("public clients have no API key")
+	ErrCertificateInUse   = errors.New("client certificate is already in use")
 
 	// Provider errors
 	ErrProviderNotFound      = errors.New("provider not found")
@@ -16,17 +20,75 @@ var (
 	ErrProviderExchange      = errors.New("provider exchange failed")
 	ErrProviderUserFetch     = errors.New("failed to fetch user from provider")
 	ErrMissingProviderParams = errors.New("missing required provider parameters")
+	ErrProviderBusy          = errors.New("provider is at its concurrency limit")
+	ErrInvalidIDToken        = errors.New("invalid ID token")
+	ErrNoGameAccount         = errors.New("account has no game profile")
+	ErrConsentDenied         = errors.New("user denied consent at the provider")
+	ErrInvalidSessionTicket  = errors.New("invalid session ticket")
+	ErrLookupNotConfigured   = errors.New("provider is not configured for user lookups")
 
 	// State token errors
 	ErrInvalidState  = errors.New("invalid state token")
 	ErrExpiredState  = errors.New("expired state token")
 	ErrMalformedState = errors.New("malformed state token")
+	ErrRevokedState   = errors.New("revoked state token")
+	ErrUsedState      = errors.New("state token already used")
 
 	// Exchange code errors
 	ErrInvalidExchangeCode = errors.New("invalid exchange code")
 	ErrExpiredExchangeCode = errors.New("expired exchange code")
 	ErrClientMismatch      = errors.New("API key does not match client in exchange code")
 
+	// Refresh token errors
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrExpiredRefreshToken = errors.New("expired refresh token")
+	ErrRevokedRefreshToken = errors.New("revoked refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token already used")
+
+	// Device authorization errors
+	ErrInvalidDeviceCode    = errors.New("invalid device code")
+	ErrExpiredDeviceCode    = errors.New("expired device code")
+	ErrInvalidUserCode      = errors.New("invalid or expired user code")
+	ErrDeviceCodeUsed       = errors.New("device code already used")
+	ErrAuthorizationPending = errors.New("authorization pending")
+	ErrSlowDown             = errors.New("polling too often")
+	ErrDeviceAccessDenied   = errors.New("user denied the device")
+
+	// SSO session errors
+	ErrInvalidSession = errors.New("invalid or expired session")
+
+	// Ticket errors
+	ErrInvalidTicket = errors.New("invalid ticket")
+	ErrExpiredTicket = errors.New("expired ticket")
+
+	// Local account errors
+	ErrAccountNotFound    = errors.New("account not found")
+	ErrAccountExists      = errors.New("account already exists")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+
+	// Identity errors
+	ErrLastIdentity = errors.New("account is the user's only linked account")
+	ErrBanNotFound  = errors.New("ban not found")
+
+	// Second factor errors
+	ErrInvalidMFAToken = errors.New("invalid second-factor token")
+	ErrExpiredMFAToken = errors.New("expired second-factor token")
+	ErrMFANotEnrolled  = errors.New("no second factor enrolled")
+	ErrInvalidTOTPCode = errors.New("invalid TOTP code")
+	ErrMFALocked       = errors.New("too many failed second-factor attempts")
+
+	// Phone provider errors
+	ErrInvalidPhoneNumber = errors.New("invalid phone number")
+	ErrInvalidOTP         = errors.New("invalid verification code")
+	ErrOTPAttempts        = errors.New("too many verification attempts")
+	ErrSMSDelivery        = errors.New("sms delivery failed")
+
+	// Scope errors
+	ErrInvalidScope = errors.New("unsupported scope")
+
+	// CAPTCHA errors
+	ErrCaptchaFailed = errors.New("captcha verification failed")
+
 	// Config errors
 	ErrMissingConfig = errors.New("missing required configuration")
 	ErrInvalidConfig = errors.New("invalid configuration")
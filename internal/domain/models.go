@@ -1,6 +1,10 @@
 package domain
 
-import "time"
+import (
+	"encoding/json"
+	"net/netip"
+	"time"
+)
 
 // UserInfo represents the normalized user profile returned by any provider.
 type UserInfo struct {
@@ -10,11 +14,80 @@ type UserInfo struct {
 	DisplayName  string `json:"display_name"`
 	AvatarURL    string `json:"avatar_url"`
 	Email        string `json:"email,omitempty"`
+
+	// CentralID is the user's stable CentralAuth ID, the same whichever
+	// client they sign in through, when an identity database is configured.
+	CentralID string `json:"central_id,omitempty"`
+
+	// Roles are the application roles the role rules grant the user at
+	// sign-in, e.g. "role:moderator".
+	Roles []string `json:"roles,omitempty"`
+
+	// EmailVerified is true when the provider says it checked the user owns
+	// Email. Don't link accounts by an email that isn't verified.
+	EmailVerified bool `json:"email_verified,omitempty"`
+
+	// Locale is the user's language (e.g. "en-GB") and Country their ISO
+	// 3166-1 alpha-2 country code (e.g. "US"), for providers that share
+	// them. Both are released with the "profile" scope.
+	Locale  string `json:"locale,omitempty"`
+	Country string `json:"country,omitempty"`
+
+	// Connections are third-party accounts linked at the provider, released
+	// with the "connections" scope.
+	Connections []Connection `json:"connections,omitempty"`
+
+	// ProviderData holds typed provider-specific extras, released with the
+	// "profile" scope.
+	ProviderData *ProviderData `json:"provider_data,omitempty"`
+
+	// Raw is the provider's profile response, untouched. It is only kept
+	// for clients with include_raw, and only when both the "profile" and
+	// "email" scopes are granted, since it may hold anything.
+	Raw json.RawMessage `json:"raw,omitempty"`
+}
+
+// Connection is an account the user linked at their provider, e.g. a Steam
+// or Twitch account linked to Discord.
+type Connection struct {
+	Type     string `json:"type"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Verified bool   `json:"verified"`
 }
 
 // AuthResult is the result of a successful provider authentication.
 type AuthResult struct {
 	User UserInfo `json:"user"`
+
+	// Factors lists the authentication factors the user satisfied, starting
+	// with the provider name, e.g. ["discord", "totp"].
+	Factors []string `json:"factors,omitempty"`
+
+	// Scope is the space-separated set of scopes the user data was released
+	// under, e.g. "profile email".
+	Scope string `json:"scope,omitempty"`
+
+	// Tokens are the provider's OAuth tokens for the user. They are only
+	// handed to clients with allow_token_passthrough.
+	Tokens *ProviderTokens `json:"provider_tokens,omitempty"`
+
+	// IDToken is the result as a signed JWT, when the client asked for both.
+	IDToken string `json:"id_token,omitempty"`
+
+	// RefreshToken gets the client a fresh result from POST /token/refresh
+	// later, for clients with refresh tokens.
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// ProviderTokens are the tokens a provider issued for the user, for clients
+// that call the provider's API themselves.
+type ProviderTokens struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	TokenType    string    `json:"token_type,omitempty"`
+	Scope        string    `json:"scope,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
 }
 
 // StatePayload is the data embedded in the HMAC-signed OAuth state token.
@@ -24,13 +97,69 @@ type StatePayload struct {
 	RedirectURI string    `json:"rdr"`
 	Nonce       string    `json:"nce"`
 	ExpiresAt   time.Time `json:"exp"`
+	Region      string    `json:"rgn,omitempty"` // region that issued the token
+	FlowID      string    `json:"fid,omitempty"` // correlates log lines across one login
+	Epoch       uint64    `json:"epc,omitempty"` // must match the service's current epoch
+	ACR         string    `json:"acr,omitempty"` // requested assurance level, e.g. "2fa"
+	Scope       string    `json:"scp,omitempty"` // granted scopes, canonical form
+	Raw         bool      `json:"raw,omitempty"` // the client receives raw provider profiles
+	Tokens      bool      `json:"tok,omitempty"` // the client receives provider tokens
+
+	// CodeChallenge is the PKCE S256 challenge a flow started at
+	// /auth/{provider} binds its exchange code to. Public clients must send one.
+	CodeChallenge string `json:"cch,omitempty"`
+
+	// AppState is the client's own opaque value from /auth/{provider},
+	// handed back untouched on the redirect that ends the flow.
+	AppState string `json:"aps,omitempty"`
+
+	OIDC   *OIDCRequest `json:"oidc,omitempty"` // set for flows started at /authorize
+	Device string       `json:"dev,omitempty"`  // the device grant a flow started at /device approves
+}
+
+// OIDCRequest is what an OpenID Connect authorization request asks of the
+// flow it starts, carried through to the code the flow ends with.
+type OIDCRequest struct {
+	State         string `json:"st,omitempty"` // the client's, returned with the code
+	Nonce         string `json:"nc,omitempty"` // echoed in the ID token
+	CodeChallenge string `json:"cc,omitempty"` // PKCE S256 challenge the code verifier must match
 }
 
 // ExchangePayload is the data encrypted inside an exchange code (AES-GCM).
 type ExchangePayload struct {
 	ClientID  string    `json:"cid"`
 	ExpiresAt time.Time `json:"exp"`
+	Region    string    `json:"rgn,omitempty"` // region that issued the code
+	FlowID    string    `json:"fid,omitempty"` // carried over from the state token
+	Factors   []string  `json:"fct,omitempty"`
+	Scope     string    `json:"scp,omitempty"`
 	User      UserInfo  `json:"user"`
+
+	Tokens *ProviderTokens `json:"tok,omitempty"`
+
+	// RedirectURI is the callback the code was delivered to, and IPHash a
+	// hash of the browser's IP address at the time, which IP holds for the
+	// user's login history. Codes issued without a browser, such as for
+	// session tickets, carry none of them.
+	RedirectURI string `json:"rdu,omitempty"`
+	IPHash      string `json:"iph,omitempty"`
+	IP          string `json:"ip,omitempty"`
+
+	// CodeChallenge is carried over from the state token. When set, the
+	// code is only redeemed with the code_verifier that answers it.
+	CodeChallenge string `json:"cch,omitempty"`
+
+	// AppState is returned with the code on the redirect. It isn't sealed
+	// in the code, which the client has no need to get it back from.
+	AppState string `json:"-"`
+
+	// OIDC is set on codes for flows started at /authorize, which can only
+	// be redeemed at /token.
+	OIDC *OIDCRequest `json:"oidc,omitempty"`
+
+	// Device is set on codes for flows started at /device, which only
+	// approve that device grant.
+	Device string `json:"dev,omitempty"`
 }
 
 // ClientApp represents a registered client application.
@@ -38,6 +167,86 @@ type ClientApp struct {
 	ID               string   `json:"id"`
 	Name             string   `json:"name"`
 	APIKey           string   `json:"-"`
+	SecondaryAPIKey  string   `json:"-"` // also accepted, so the key can be rotated without downtime
 	AllowedCallbacks []string `json:"allowed_callbacks"`
 	AllowedProviders []string `json:"allowed_providers"`
+	KeyVersion       string   `json:"-"` // mixed into the client's exchange key; change it to revoke outstanding codes
+	RequireCaptcha   bool     `json:"require_captcha"`
+	AllowLookup      bool     `json:"allow_lookup"` // may call POST /auth/{provider}/lookup and GET /users/...
+	IncludeRaw       bool     `json:"include_raw"`  // receives UserInfo.Raw
+
+	// Public marks a client that can't keep a secret, such as a browser or
+	// mobile app. It has no API key: its flows must use PKCE, and it redeems
+	// codes with the code_verifier and the exact redirect_uri instead.
+	Public bool `json:"public"`
+
+	// AllowServiceTokens lets the client trade its API key for service
+	// tokens at POST /token.
+	AllowServiceTokens bool `json:"allow_service_tokens"`
+
+	// AllowTokenPassthrough hands the provider's access and refresh tokens
+	// to the client on exchange.
+	AllowTokenPassthrough bool `json:"allow_token_passthrough"`
+
+	// StripFields names user fields, by their JSON keys, that are removed
+	// from everything this client receives, whatever scope it asks for.
+	StripFields []string `json:"strip_fields,omitempty"`
+
+	// GuestLifetime overrides how long guest identities issued to this
+	// client last (0 uses the provider default).
+	GuestLifetime time.Duration `json:"-"`
+
+	// StateTTL and ExchangeCodeTTL override how long state tokens and
+	// exchange codes issued to this client stay valid (0 uses the default).
+	StateTTL        time.Duration `json:"-"`
+	ExchangeCodeTTL time.Duration `json:"-"`
+
+	// RefreshTokenTTL opts the client into refresh tokens: how long each
+	// refresh token it gets stays valid (0 issues none).
+	RefreshTokenTTL time.Duration `json:"-"`
+
+	// BackchannelLogoutURI is sent a logout token when a user signed in to
+	// the client signs out at /logout (empty sends none).
+	BackchannelLogoutURI string `json:"backchannel_logout_uri,omitempty"`
+
+	// RateLimit overrides the default limit on this client's requests
+	// (zero uses the default).
+	RateLimit RateLimit `json:"-"`
+
+	// AllowedIPs, when set, are the only addresses the client's API key is
+	// accepted from, so a leaked key is of no use elsewhere.
+	AllowedIPs []netip.Prefix `json:"allowed_ips,omitempty"`
+
+	// CertFingerprints, when set, are the SHA-256 fingerprints of the TLS
+	// client certificates the client calls the API with. A request with one
+	// of them needs no API key; a request with the API key must present one.
+	CertFingerprints []string `json:"cert_fingerprints,omitempty"`
+
+	// BlockedCountries are the ISO 3166-1 alpha-2 codes of the countries
+	// users can't start signing in to the client from, on top of the ones
+	// blocked for every client.
+	BlockedCountries []string `json:"blocked_countries,omitempty"`
+
+	// Disabled suspends the client: it can't start flows or redeem codes,
+	// but keeps its settings so it can be enabled again.
+	Disabled bool `json:"disabled"`
+}
+
+// Enabled reports whether the client may start flows and call the API.
+func (c *ClientApp) Enabled() bool {
+	return !c.Disabled
+}
+
+// AllowsIP reports whether the client's API key may be used from ip: any
+// address when the client has no AllowedIPs.
+func (c *ClientApp) AllowsIP(ip netip.Addr) bool {
+	if len(c.AllowedIPs) == 0 {
+		return true
+	}
+	for _, p := range c.AllowedIPs {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,156 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Provider data kinds.
+const (
+	KindDiscord = "discord"
+	KindSteam   = "steam"
+	KindGuest   = "guest"
+)
+
+// ProviderData carries typed, provider-specific extras about a user. On the
+// wire it is a flat JSON object whose "kind" field says which extras type
+// the rest of the object holds:
+//
+//	{"kind": "discord", "locale": "en-GB", "mfa_enabled": true, "guild_roles": ["123"]}
+//
+// Versioning rules, so clients written against an older release keep working:
+//
+//   - Fields may be added to an extras type, but never renamed, removed, or
+//     given a different type or meaning. Clients must ignore fields they
+//     don't know.
+//   - New kinds may appear at any time. Clients must ignore provider data of
+//     a kind they don't know, and ProviderData keeps such data intact.
+//   - A change that can't follow these rules gets a new kind (for example
+//     "discord.v2") and the old kind is kept for at least one release.
+type ProviderData struct {
+	Kind    string
+	Discord *DiscordExtras
+	Steam   *SteamExtras
+	Guest   *GuestExtras
+
+	raw json.RawMessage // data of an unknown kind, passed through unchanged
+}
+
+// DiscordExtras are the Discord-specific extras (kind "discord").
+type DiscordExtras struct {
+	// GuildRoles lists the user's role IDs in the configured guild. It is
+	// null when no guild is configured or the user isn't a member, and empty
+	// for a member without roles.
+	GuildRoles []string `json:"guild_roles"`
+	Locale     string   `json:"locale,omitempty"`
+	MFAEnabled bool     `json:"mfa_enabled"`
+
+	// Guilds lists the guilds the user is a member of, limited to the
+	// configured filter if there is one. It is null unless the guilds scope
+	// is configured.
+	Guilds []DiscordGuild `json:"guilds"`
+}
+
+// DiscordGuild is a Discord server the user is a member of.
+type DiscordGuild struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// SteamExtras are the Steam-specific extras (kind "steam").
+type SteamExtras struct {
+	Bans SteamBans `json:"bans"`
+
+	// OwnsGame and Playtime refer to the configured app, AppID. Both read
+	// as zero when the user's game details are private.
+	AppID    string `json:"app_id,omitempty"`
+	OwnsGame bool   `json:"owns_game"`
+	Playtime int    `json:"playtime"` // total minutes played
+
+	// InGame reports whether the user was playing the configured app when
+	// they signed in, and GameServer the ip:port of the server they were on,
+	// if any. Both read as zero when the user's profile is private.
+	InGame     bool   `json:"in_game"`
+	GameServer string `json:"game_server,omitempty"`
+}
+
+// SteamBans summarizes a Steam account's ban record.
+type SteamBans struct {
+	VACBanned        bool   `json:"vac_banned"`
+	VACBans          int    `json:"vac_bans"`
+	GameBans         int    `json:"game_bans"`
+	CommunityBanned  bool   `json:"community_banned"`
+	EconomyBan       string `json:"economy_ban"` // "none", "probation", or "banned"
+	DaysSinceLastBan int    `json:"days_since_last_ban"`
+}
+
+// GuestExtras are the guest provider's extras (kind "guest").
+type GuestExtras struct {
+	// ExpiresAt is when the site should stop honouring the guest identity.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewDiscordData wraps Discord extras as provider data.
+func NewDiscordData(e DiscordExtras) *ProviderData {
+	return &ProviderData{Kind: KindDiscord, Discord: &e}
+}
+
+// NewSteamData wraps Steam extras as provider data.
+func NewSteamData(e SteamExtras) *ProviderData {
+	return &ProviderData{Kind: KindSteam, Steam: &e}
+}
+
+// NewGuestData wraps guest extras as provider data.
+func NewGuestData(e GuestExtras) *ProviderData {
+	return &ProviderData{Kind: KindGuest, Guest: &e}
+}
+
+func (d ProviderData) MarshalJSON() ([]byte, error) {
+	switch {
+	case d.Kind == KindDiscord && d.Discord != nil:
+		return json.Marshal(struct {
+			Kind string `json:"kind"`
+			*DiscordExtras
+		}{d.Kind, d.Discord})
+	case d.Kind == KindSteam && d.Steam != nil:
+		return json.Marshal(struct {
+			Kind string `json:"kind"`
+			*SteamExtras
+		}{d.Kind, d.Steam})
+	case d.Kind == KindGuest && d.Guest != nil:
+		return json.Marshal(struct {
+			Kind string `json:"kind"`
+			*GuestExtras
+		}{d.Kind, d.Guest})
+	case d.raw != nil:
+		return d.raw, nil
+	default:
+		return nil, fmt.Errorf("provider data of kind %q has no extras", d.Kind)
+	}
+}
+
+func (d *ProviderData) UnmarshalJSON(data []byte) error {
+	var head struct {
+		Kind string `json:"kind"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return err
+	}
+
+	*d = ProviderData{Kind: head.Kind}
+	switch head.Kind {
+	case KindDiscord:
+		d.Discord = new(DiscordExtras)
+		return json.Unmarshal(data, d.Discord)
+	case KindSteam:
+		d.Steam = new(SteamExtras)
+		return json.Unmarshal(data, d.Steam)
+	case KindGuest:
+		d.Guest = new(GuestExtras)
+		return json.Unmarshal(data, d.Guest)
+	default:
+		d.raw = append(json.RawMessage(nil), data...)
+		return nil
+	}
+}
@@ -0,0 +1,52 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestProviderData_RoundTrip(t *testing.T) {
+	tests := []*ProviderData{
+		NewDiscordData(DiscordExtras{GuildRoles: []string{"1"}, Locale: "de", MFAEnabled: true, Guilds: []DiscordGuild{{ID: "42", Name: "BlackMission"}}}),
+		NewSteamData(SteamExtras{Bans: SteamBans{VACBans: 2, EconomyBan: "none"}, OwnsGame: true, Playtime: 90}),
+		NewGuestData(GuestExtras{ExpiresAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}),
+	}
+	for _, pd := range tests {
+		data, err := json.Marshal(pd)
+		if err != nil {
+			t.Fatalf("Marshal error: %v", err)
+		}
+		var got ProviderData
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("Unmarshal error: %v", err)
+		}
+		again, _ := json.Marshal(got)
+		if string(again) != string(data) {
+			t.Errorf("round trip changed %s to %s", data, again)
+		}
+	}
+}
+
+func TestProviderData_Flat(t *testing.T) {
+	data, _ := json.Marshal(NewDiscordData(DiscordExtras{Locale: "en-US"}))
+	want := `{"kind":"discord","guild_roles":null,"locale":"en-US","mfa_enabled":false,"guilds":null}`
+	if string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+}
+
+func TestProviderData_UnknownKind(t *testing.T) {
+	in := `{"kind":"twitch","follower":true}`
+	var pd ProviderData
+	if err := json.Unmarshal([]byte(in), &pd); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if pd.Kind != "twitch" || pd.Discord != nil || pd.Steam != nil {
+		t.Errorf("unexpected provider data: %+v", pd)
+	}
+	out, err := json.Marshal(pd)
+	if err != nil || string(out) != in {
+		t.Errorf("Marshal = %s, %v; want %s", out, err, in)
+	}
+}
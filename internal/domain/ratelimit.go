@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit allows Requests per Per on average, in bursts of up to Requests.
+// The zero value sets no limit.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// IsZero reports whether l sets no limit.
+func (l RateLimit) IsZero() bool {
+	return l.Requests <= 0 || l.Per <= 0
+}
+
+func (l RateLimit) String() string {
+	if l.IsZero() {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Per)
+}
+
+// ParseRateLimit parses a limit written as requests/period, such as "100/1m"
+// or "5/s". A bare unit stands for one of it.
+func ParseRateLimit(s string) (RateLimit, error) {
+	n, per, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit %q must be requests/period, e.g. 100/1m", s)
+	}
+	requests, err := strconv.Atoi(n)
+	if err != nil || requests <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q must allow a positive number of requests", s)
+	}
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q has an invalid period", s)
+	}
+	return RateLimit{Requests: requests, Per: d}, nil
+}
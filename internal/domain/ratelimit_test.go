@@ -0,0 +1,29 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    RateLimit
+		wantErr bool
+	}{
+		{"100/1m", RateLimit{100, time.Minute}, false},
+		{"5/s", RateLimit{5, time.Second}, false},
+		{" 10/30s ", RateLimit{10, 30 * time.Second}, false},
+		{"100", RateLimit{}, true},
+		{"0/1m", RateLimit{}, true},
+		{"ten/1m", RateLimit{}, true},
+		{"10/fortnight", RateLimit{}, true},
+		{"10/-1m", RateLimit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRateLimit(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseRateLimit(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package drain
+
+import (
+	"sync"
+	"time"
+)
+
+// Switch toggles drain mode, in which no new auth flows are started while
+// flows already in progress are allowed to complete.
+type Switch struct {
+	mu    sync.RWMutex
+	on    bool
+	since time.Time
+	now   func() time.Time
+}
+
+// Status describes the current drain state.
+type Status struct {
+	Draining bool       `json:"draining"`
+	Since    *time.Time `json:"since,omitempty"`
+}
+
+// NewSwitch creates a switch in the off position.
+func NewSwitch() *Switch {
+	return &Switch{now: time.Now}
+}
+
+// Enable starts draining. Enabling an already draining switch keeps the original start time.
+func (s *Switch) Enable() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.on {
+		s.on = true
+		s.since = s.now()
+	}
+}
+
+// Disable stops draining.
+func (s *Switch) Disable() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.on = false
+	s.since = time.Time{}
+}
+
+// Draining reports whether drain mode is on.
+func (s *Switch) Draining() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.on
+}
+
+// Status returns a snapshot of the switch state.
+func (s *Switch) Status() Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.on {
+		return Status{}
+	}
+	since := s.since
+	return Status{Draining: true, Since: &since}
+}
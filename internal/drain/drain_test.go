@@ -0,0 +1,35 @@
+package drain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSwitch(t *testing.T) {
+	s := NewSwitch()
+	if s.Draining() {
+		t.Fatal("expected new switch to be off")
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return start }
+	s.Enable()
+	if !s.Draining() {
+		t.Fatal("expected switch to be on")
+	}
+
+	// Enabling again keeps the original start time
+	s.now = func() time.Time { return start.Add(time.Minute) }
+	s.Enable()
+	if st := s.Status(); st.Since == nil || !st.Since.Equal(start) {
+		t.Errorf("expected since %v, got %v", start, st.Since)
+	}
+
+	s.Disable()
+	if s.Draining() {
+		t.Error("expected switch to be off")
+	}
+	if st := s.Status(); st.Since != nil {
+		t.Errorf("expected no since when off, got %v", st.Since)
+	}
+}
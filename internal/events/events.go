@@ -0,0 +1,156 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/geoip"
+	"github.com/BlackMission/centralauth/internal/logging"
+)
+
+// Event types, which with the bus's prefix name the subject or topic each
+// is published to.
+const (
+	// AuthSucceeded is published when a sign-in completes and its exchange
+	// code is issued to the client.
+	AuthSucceeded = "auth.succeeded"
+	// AuthFailed is published when a sign-in fails before its code is
+	// issued, such as when the user denies consent or the provider errors.
+	AuthFailed = "auth.failed"
+	// ClientExchange is published when a client redeems a sign-in's code.
+	ClientExchange = "client.exchange"
+	// SecurityLockout is published when a caller that keeps failing to
+	// authenticate, such as by presenting invalid exchange codes or API
+	// keys, is locked out.
+	SecurityLockout = "security.lockout"
+)
+
+// Event is one piece of login activity, published as JSON.
+type Event struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	FlowID    string    `json:"flow_id,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Provider  string    `json:"provider,omitempty"`
+	// UserID is the user's ID at the provider.
+	UserID  string   `json:"user_id,omitempty"`
+	Factors []string `json:"factors,omitempty"`
+	// Reason says why an auth.failed sign-in failed, as in the funnel
+	// metrics, e.g. "consent_denied", or what a security.lockout locked
+	// out: "ip".
+	Reason string `json:"reason,omitempty"`
+	// IP is the address the failures behind a security.lockout came
+	// from, and LockedUntil when the lockout ends.
+	IP          string    `json:"ip,omitempty"`
+	LockedUntil time.Time `json:"locked_until,omitzero"`
+	// Country and ASN locate the address the request publishing the event
+	// came from: the user's browser for auth.* events, the client's server
+	// for client.exchange. They are set when GeoIP databases are configured.
+	Country string `json:"country,omitempty"`
+	ASN     uint32 `json:"asn,omitempty"`
+}
+
+// Publisher sends messages to a message broker.
+type Publisher interface {
+	// Publish sends msg to topic. key, when set, keeps a client's messages
+	// in order on brokers that partition topics.
+	Publish(ctx context.Context, topic, key string, msg []byte) error
+
+	// Close closes the connections to the broker.
+	Close() error
+
+	// String describes the publisher for logs.
+	String() string
+}
+
+const (
+	// queueSize bounds the events waiting to be published. Once it is full,
+	// new events are dropped rather than holding up sign-ins.
+	queueSize = 1024
+
+	publishTimeout = 5 * time.Second
+)
+
+// Bus publishes events in the background, so that a slow or unreachable
+// broker never holds up a sign-in: events it can't take are logged and
+// dropped. A nil *Bus publishes nothing.
+type Bus struct {
+	pub    Publisher
+	prefix string
+	now    func() time.Time
+
+	mu     sync.RWMutex // guards closing queue
+	closed bool
+	queue  chan Event
+	done   chan struct{}
+}
+
+// NewBus returns a bus publishing each event to prefix followed by its type,
+// e.g. "centralauth.auth.succeeded", until Close.
+func NewBus(pub Publisher, prefix string) *Bus {
+	b := &Bus{pub: pub, prefix: prefix, now: time.Now, queue: make(chan Event, queueSize), done: make(chan struct{})}
+	go b.run()
+	return b
+}
+
+// Publish queues e, stamped with the time, and the ID and location of the
+// request ctx belongs to. Events published after Close are dropped.
+func (b *Bus) Publish(ctx context.Context, e Event) {
+	if b == nil {
+		return
+	}
+	e.Time = b.now().UTC()
+	e.RequestID = logging.RequestID(ctx)
+	loc := geoip.FromContext(ctx)
+	e.Country, e.ASN = loc.Country, loc.ASN
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return
+	}
+	select {
+	case b.queue <- e:
+	default:
+		slog.WarnContext(ctx, "events: queue full; event dropped", "type", e.Type)
+	}
+}
+
+// Close publishes the events still queued and closes the publisher, giving
+// up on the queue when ctx ends.
+func (b *Bus) Close(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+	}
+	return b.pub.Close()
+}
+
+func (b *Bus) run() {
+	defer close(b.done)
+	for e := range b.queue {
+		msg, err := json.Marshal(e)
+		if err != nil {
+			slog.Error("events: encoding event failed", "type", e.Type, "error", err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		err = b.pub.Publish(ctx, b.prefix+e.Type, e.ClientID, msg)
+		cancel()
+		if err != nil {
+			slog.Warn("events: publish failed; event dropped", "publisher", b.pub.String(), "type", e.Type, "flow_id", e.FlowID, "error", err)
+		}
+	}
+}
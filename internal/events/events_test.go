@@ -0,0 +1,95 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/geoip"
+	"github.com/BlackMission/centralauth/internal/logging"
+)
+
+type message struct {
+	topic, key string
+	event      Event
+}
+
+type fakePublisher struct {
+	mu     sync.Mutex
+	sent   []message
+	fail   bool
+	closed bool
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, topic, key string, msg []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fail {
+		return errors.New("broker down")
+	}
+	var e Event
+	if err := json.Unmarshal(msg, &e); err != nil {
+		return err
+	}
+	p.sent = append(p.sent, message{topic, key, e})
+	return nil
+}
+
+func (p *fakePublisher) Close() error {
+	p.closed = true
+	return nil
+}
+
+func (p *fakePublisher) String() string { return "fake" }
+
+func TestBus_Publish(t *testing.T) {
+	pub := &fakePublisher{}
+	bus := NewBus(pub, "centralauth.")
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	bus.now = func() time.Time { return now }
+
+	ctx := logging.WithRequestID(context.Background(), "r1")
+	ctx = geoip.NewContext(ctx, geoip.Location{Country: "DE", ASN: 64500})
+	bus.Publish(ctx, Event{Type: AuthSucceeded, FlowID: "f1", ClientID: "website", Provider: "discord", UserID: "123", Factors: []string{"discord"}})
+	bus.Publish(ctx, Event{Type: AuthFailed, FlowID: "f2", ClientID: "website", Provider: "discord", Reason: "consent_denied"})
+	if err := bus.Close(context.Background()); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	bus.Publish(ctx, Event{Type: ClientExchange})
+
+	if len(pub.sent) != 2 || !pub.closed {
+		t.Fatalf("sent %d events, closed %v", len(pub.sent), pub.closed)
+	}
+	got := pub.sent[0]
+	if got.topic != "centralauth.auth.succeeded" || got.key != "website" || got.event.FlowID != "f1" ||
+		got.event.RequestID != "r1" || !got.event.Time.Equal(now) || got.event.UserID != "123" ||
+		got.event.Country != "DE" || got.event.ASN != 64500 {
+		t.Errorf("first message = %+v", got)
+	}
+	if got := pub.sent[1]; got.topic != "centralauth.auth.failed" || got.event.Reason != "consent_denied" {
+		t.Errorf("second message = %+v", got)
+	}
+}
+
+func TestBus_PublishFailureDropsEvent(t *testing.T) {
+	pub := &fakePublisher{fail: true}
+	bus := NewBus(pub, "")
+	bus.Publish(context.Background(), Event{Type: AuthSucceeded})
+	if err := bus.Close(context.Background()); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	if len(pub.sent) != 0 {
+		t.Errorf("sent = %+v", pub.sent)
+	}
+}
+
+func TestBus_Nil(t *testing.T) {
+	var bus *Bus
+	bus.Publish(context.Background(), Event{Type: AuthSucceeded})
+	if err := bus.Close(context.Background()); err != nil {
+		t.Errorf("Close error: %v", err)
+	}
+}
@@ -0,0 +1,444 @@
+package events
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// kafkaTimeout bounds each round trip when the caller's context has no
+	// sooner deadline.
+	kafkaTimeout = 5 * time.Second
+
+	kafkaClientID = "centralauth"
+
+	apiProduce  = 0
+	apiMetadata = 3
+
+	// Metadata v4 and Produce v3, the first with record batches, are
+	// understood by Kafka 1.0 and later, 4.x included.
+	metadataVersion = 4
+	produceVersion  = 3
+
+	// maxKafkaResponse bounds the responses read, which for the requests
+	// made here are small.
+	maxKafkaResponse = 1 << 20
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// Kafka publishes to Kafka topics over the Kafka protocol, as a plain
+// producer: each message is acknowledged by its partition's leader
+// (acks=1), without idempotence or transactions. Messages with the same key
+// go to the same partition, so each client's events stay in order.
+type Kafka struct {
+	brokers   []string
+	tlsConfig *tls.Config
+
+	mu          sync.Mutex
+	conns       map[string]net.Conn // by broker address
+	leaders     map[string][]string // each topic's partition leaders, by partition
+	correlation int32
+	next        uint32 // round-robin partition for unkeyed messages
+}
+
+// NewKafka creates a publisher for the Kafka cluster that brokers, as
+// host:port, belong to. It connects on first use, over TLS when useTLS is
+// set. Topics that don't exist are created if the cluster allows it.
+func NewKafka(brokers []string, useTLS bool) (*Kafka, error) {
+	if len(brokers) == 0 {
+		return nil, errors.New("kafka: no brokers")
+	}
+	for _, b := range brokers {
+		if _, _, err := net.SplitHostPort(b); err != nil {
+			return nil, fmt.Errorf("kafka: invalid broker %q: must be host:port", b)
+		}
+	}
+	k := &Kafka{brokers: brokers, conns: make(map[string]net.Conn), leaders: make(map[string][]string)}
+	if useTLS {
+		k.tlsConfig = &tls.Config{}
+	}
+	return k, nil
+}
+
+// Publish sends msg, keyed by key, to topic. A failure drops what is known
+// about the cluster, since leaders move, and the message is tried once more.
+func (k *Kafka) Publish(ctx context.Context, topic, key string, msg []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	err := k.publish(ctx, topic, key, msg)
+	if err != nil && ctx.Err() == nil {
+		k.reset()
+		err = k.publish(ctx, topic, key, msg)
+	}
+	if err != nil {
+		k.reset()
+	}
+	return err
+}
+
+// Close closes the connections to the brokers.
+func (k *Kafka) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.reset()
+	return nil
+}
+
+func (k *Kafka) String() string {
+	return "kafka " + k.brokers[0]
+}
+
+func (k *Kafka) reset() {
+	for _, c := range k.conns {
+		c.Close()
+	}
+	clear(k.conns)
+	clear(k.leaders)
+}
+
+func (k *Kafka) publish(ctx context.Context, topic, key string, msg []byte) error {
+	leaders, err := k.partitions(ctx, topic)
+	if err != nil {
+		return err
+	}
+	partition := int32(k.next % uint32(len(leaders)))
+	if key != "" {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		partition = int32(h.Sum32() % uint32(len(leaders)))
+	} else {
+		k.next++
+	}
+	if leaders[partition] == "" {
+		return fmt.Errorf("kafka: partition %d of %s has no leader", partition, topic)
+	}
+	c, err := k.conn(ctx, leaders[partition])
+	if err != nil {
+		return err
+	}
+
+	var e kafkaEncoder
+	e.int16(-1) // no transactional ID
+	e.int16(1)  // acks from the leader
+	e.int32(int32(kafkaTimeout / time.Millisecond))
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(partition)
+	e.bytes(recordBatch(key, msg, time.Now()))
+	resp, err := k.roundTrip(ctx, c, apiProduce, produceVersion, e.buf)
+	if err != nil {
+		return err
+	}
+
+	d := kafkaDecoder{buf: resp}
+	for range d.count() {
+		d.string()
+		for range d.count() {
+			d.int32()
+			code := d.int16()
+			d.int64()
+			d.int64()
+			if d.err == nil && code != 0 {
+				return fmt.Errorf("kafka: producing to %s/%d failed with error code %d", topic, partition, code)
+			}
+		}
+	}
+	return d.err
+}
+
+// partitions returns the address of the leader of each of topic's
+// partitions, asking the cluster when it isn't known yet.
+func (k *Kafka) partitions(ctx context.Context, topic string) ([]string, error) {
+	if leaders, ok := k.leaders[topic]; ok {
+		return leaders, nil
+	}
+	var e kafkaEncoder
+	e.int32(1)
+	e.string(topic)
+	e.int8(1) // allow auto topic creation
+
+	var errs []error
+	for _, broker := range k.brokers {
+		c, err := k.conn(ctx, broker)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resp, err := k.roundTrip(ctx, c, apiMetadata, metadataVersion, e.buf)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		leaders, err := parseMetadata(resp, topic)
+		if err != nil {
+			return nil, err
+		}
+		k.leaders[topic] = leaders
+		return leaders, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// parseMetadata reads the addresses of topic's partition leaders from a
+// Metadata v4 response.
+func parseMetadata(resp []byte, topic string) ([]string, error) {
+	d := kafkaDecoder{buf: resp}
+	d.int32() // throttle time
+	brokers := make(map[int32]string)
+	for range d.count() {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.nullableString() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.nullableString() // cluster ID
+	d.int32()          // controller ID
+
+	var leaders []string
+	for range d.count() {
+		code := d.int16()
+		name := d.string()
+		d.int8() // internal
+		var partitions []string
+		for range d.count() {
+			d.int16() // partition error, e.g. no leader; handled as one below
+			index := d.int32()
+			leader := d.int32()
+			for range d.count() {
+				d.int32() // replicas
+			}
+			for range d.count() {
+				d.int32() // in-sync replicas
+			}
+			if index < 0 || index > 1<<16 {
+				return nil, fmt.Errorf("kafka: invalid partition %d of %s", index, name)
+			}
+			for int(index) >= len(partitions) {
+				partitions = append(partitions, "")
+			}
+			partitions[index] = brokers[leader]
+		}
+		if d.err != nil {
+			break
+		}
+		if name != topic {
+			continue
+		}
+		if code != 0 {
+			return nil, fmt.Errorf("kafka: topic %s is unavailable, error code %d", topic, code)
+		}
+		leaders = partitions
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(leaders) == 0 {
+		return nil, fmt.Errorf("kafka: topic %s has no partitions", topic)
+	}
+	return leaders, nil
+}
+
+func (k *Kafka) conn(ctx context.Context, addr string) (net.Conn, error) {
+	if c, ok := k.conns[addr]; ok {
+		return c, nil
+	}
+	d := net.Dialer{Timeout: kafkaTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("kafka: %w", err)
+	}
+	if k.tlsConfig != nil {
+		cfg := k.tlsConfig.Clone()
+		cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		tc := tls.Client(conn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("kafka: %w", err)
+		}
+		conn = tc
+	}
+	k.conns[addr] = conn
+	return conn, nil
+}
+
+// roundTrip sends one request on c and returns the body of its response.
+func (k *Kafka) roundTrip(ctx context.Context, c net.Conn, apiKey, version int16, body []byte) ([]byte, error) {
+	k.correlation++
+	var e kafkaEncoder
+	e.int32(0) // size, filled in below
+	e.int16(apiKey)
+	e.int16(version)
+	e.int32(k.correlation)
+	e.string(kafkaClientID)
+	e.buf = append(e.buf, body...)
+	binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4))
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(kafkaTimeout)
+	}
+	c.SetDeadline(deadline)
+	if _, err := c.Write(e.buf); err != nil {
+		return nil, fmt.Errorf("kafka: %w", err)
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(c, size[:]); err != nil {
+		return nil, fmt.Errorf("kafka: %w", err)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 4 || n > maxKafkaResponse {
+		return nil, fmt.Errorf("kafka: invalid response size %d", n)
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(c, resp); err != nil {
+		return nil, fmt.Errorf("kafka: %w", err)
+	}
+	if id := int32(binary.BigEndian.Uint32(resp)); id != k.correlation {
+		return nil, fmt.Errorf("kafka: response %d to request %d", id, k.correlation)
+	}
+	return resp[4:], nil
+}
+
+// recordBatch encodes one record as a v2 record batch.
+func recordBatch(key string, value []byte, now time.Time) []byte {
+	var rec []byte
+	rec = append(rec, 0)              // attributes
+	rec = binary.AppendVarint(rec, 0) // timestamp delta
+	rec = binary.AppendVarint(rec, 0) // offset delta
+	if key == "" {
+		rec = binary.AppendVarint(rec, -1)
+	} else {
+		rec = binary.AppendVarint(rec, int64(len(key)))
+		rec = append(rec, key...)
+	}
+	rec = binary.AppendVarint(rec, int64(len(value)))
+	rec = append(rec, value...)
+	rec = binary.AppendVarint(rec, 0) // headers
+
+	// Everything from the attributes on is covered by the CRC
+	var tail kafkaEncoder
+	tail.int16(0) // attributes: no compression
+	tail.int32(0) // last offset delta
+	ts := now.UnixMilli()
+	tail.int64(ts) // base timestamp
+	tail.int64(ts) // max timestamp
+	tail.int64(-1) // producer ID
+	tail.int16(-1) // producer epoch
+	tail.int32(-1) // base sequence
+	tail.int32(1)  // records
+	tail.buf = binary.AppendVarint(tail.buf, int64(len(rec)))
+	tail.buf = append(tail.buf, rec...)
+
+	var e kafkaEncoder
+	e.int64(0)                                // base offset
+	e.int32(int32(4 + 1 + 4 + len(tail.buf))) // batch length, from the leader epoch on
+	e.int32(-1)                               // partition leader epoch
+	e.int8(2)                                 // magic
+	e.int32(int32(crc32.Checksum(tail.buf, crc32c)))
+	e.buf = append(e.buf, tail.buf...)
+	return e.buf
+}
+
+// kafkaEncoder appends big-endian Kafka protocol primitives.
+type kafkaEncoder struct {
+	buf []byte
+}
+
+func (e *kafkaEncoder) int8(v int8)   { e.buf = append(e.buf, byte(v)) }
+func (e *kafkaEncoder) int16(v int16) { e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v)) }
+func (e *kafkaEncoder) int32(v int32) { e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v)) }
+func (e *kafkaEncoder) int64(v int64) { e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v)) }
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// kafkaDecoder reads Kafka protocol primitives. Reading past the end sets
+// err and returns zeros from then on.
+type kafkaDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf) {
+		d.err = errors.New("kafka: truncated response")
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.take(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// count reads an array's length. Every element takes at least a byte, so
+// longer arrays than what is left are truncated responses.
+func (d *kafkaDecoder) count() int32 {
+	n := d.int32()
+	if d.err == nil && int(n) > len(d.buf) {
+		d.err = errors.New("kafka: truncated response")
+		return 0
+	}
+	return n
+}
+
+func (d *kafkaDecoder) string() string {
+	return string(d.take(int(d.int16())))
+}
+
+func (d *kafkaDecoder) nullableString() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
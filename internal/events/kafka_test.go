@@ -0,0 +1,230 @@
+package events
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// kafkaBroker is a one-node cluster on a local port that answers Metadata
+// and Produce requests, sending each record it is given on records.
+type kafkaBroker struct {
+	addr       string
+	partitions int
+	produceErr int16
+	records    chan kafkaRecord
+}
+
+type kafkaRecord struct {
+	topic     string
+	partition int32
+	key       string // "<nil>" for no key
+	value     string
+}
+
+func startKafka(t *testing.T, partitions int, produceErr int16) *kafkaBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	b := &kafkaBroker{addr: ln.Addr().String(), partitions: partitions, produceErr: produceErr, records: make(chan kafkaRecord, 16)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(t, conn)
+		}
+	}()
+	return b
+}
+
+func (b *kafkaBroker) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		d := kafkaDecoder{buf: req}
+		apiKey, version, correlation, clientID := d.int16(), d.int16(), d.int32(), d.string()
+		if clientID != kafkaClientID {
+			t.Errorf("client ID = %q", clientID)
+		}
+
+		var e kafkaEncoder
+		e.int32(0)
+		e.int32(correlation)
+		switch {
+		case apiKey == apiMetadata && version == metadataVersion:
+			d.int32()
+			topic := d.string()
+			host, port, _ := net.SplitHostPort(b.addr)
+			p, _ := strconv.Atoi(port)
+			e.int32(0) // throttle
+			e.int32(1)
+			e.int32(7)
+			e.string(host)
+			e.int32(int32(p))
+			e.int16(-1) // rack
+			e.int16(-1) // cluster ID
+			e.int32(7)  // controller
+			e.int32(1)
+			e.int16(0)
+			e.string(topic)
+			e.int8(0)
+			e.int32(int32(b.partitions))
+			for i := range b.partitions {
+				e.int16(0)
+				e.int32(int32(i))
+				e.int32(7)
+				e.int32(1)
+				e.int32(7)
+				e.int32(1)
+				e.int32(7)
+			}
+		case apiKey == apiProduce && version == produceVersion:
+			d.nullableString()
+			if acks := d.int16(); acks != 1 {
+				t.Errorf("acks = %d", acks)
+			}
+			d.int32()
+			d.int32()
+			topic := d.string()
+			d.int32()
+			partition := d.int32()
+			batch := d.take(int(d.int32()))
+			if d.err != nil {
+				t.Errorf("produce request: %v", d.err)
+				return
+			}
+			rec := parseBatch(t, batch)
+			rec.topic, rec.partition = topic, partition
+			b.records <- rec
+			e.int32(1)
+			e.string(topic)
+			e.int32(1)
+			e.int32(partition)
+			e.int16(b.produceErr)
+			e.int64(0)
+			e.int64(-1)
+			e.int32(0) // throttle
+		default:
+			t.Errorf("unexpected request %d v%d", apiKey, version)
+			return
+		}
+		binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4))
+		conn.Write(e.buf)
+	}
+}
+
+// parseBatch checks a v2 record batch of one record and returns the record.
+func parseBatch(t *testing.T, batch []byte) kafkaRecord {
+	t.Helper()
+	d := kafkaDecoder{buf: batch}
+	d.int64()
+	if n := d.int32(); int(n) != len(batch)-12 {
+		t.Errorf("batch length %d, want %d", n, len(batch)-12)
+	}
+	d.int32()
+	if magic := d.int8(); magic != 2 {
+		t.Errorf("magic = %d", magic)
+	}
+	if crc := uint32(d.int32()); crc != crc32.Checksum(d.buf, crc32.MakeTable(crc32.Castagnoli)) {
+		t.Error("batch CRC does not match")
+	}
+	d.take(2 + 4 + 8 + 8 + 8 + 2 + 4)
+	if n := d.int32(); n != 1 {
+		t.Errorf("records = %d", n)
+	}
+	length, n := binary.Varint(d.buf)
+	rec := d.buf[n : n+int(length)]
+	rec = rec[1:] // attributes
+	for range 2 { // timestamp and offset deltas
+		_, n = binary.Varint(rec)
+		rec = rec[n:]
+	}
+	var r kafkaRecord
+	keyLen, n := binary.Varint(rec)
+	rec = rec[n:]
+	if keyLen < 0 {
+		r.key = "<nil>"
+	} else {
+		r.key, rec = string(rec[:keyLen]), rec[keyLen:]
+	}
+	valueLen, n := binary.Varint(rec)
+	rec = rec[n:]
+	r.value = string(rec[:valueLen])
+	return r
+}
+
+func receiveRecord(t *testing.T, b *kafkaBroker) kafkaRecord {
+	t.Helper()
+	select {
+	case r := <-b.records:
+		return r
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out")
+		return kafkaRecord{}
+	}
+}
+
+func TestKafka_Publish(t *testing.T) {
+	b := startKafka(t, 3, 0)
+	k, err := NewKafka([]string{"127.0.0.1:1", b.addr}, false)
+	if err != nil {
+		t.Fatalf("NewKafka error: %v", err)
+	}
+	defer k.Close()
+
+	// The first broker is down; the second answers for the cluster
+	for range 2 {
+		if err := k.Publish(context.Background(), "centralauth.auth.succeeded", "website", []byte(`{"type":"auth.succeeded"}`)); err != nil {
+			t.Fatalf("Publish error: %v", err)
+		}
+	}
+	first, second := receiveRecord(t, b), receiveRecord(t, b)
+	if first.topic != "centralauth.auth.succeeded" || first.key != "website" || first.value != `{"type":"auth.succeeded"}` {
+		t.Errorf("record = %+v", first)
+	}
+	if second.partition != first.partition {
+		t.Errorf("keyed records went to partitions %d and %d", first.partition, second.partition)
+	}
+
+	if err := k.Publish(context.Background(), "centralauth.client.exchange", "", []byte("{}")); err != nil {
+		t.Fatalf("Publish error: %v", err)
+	}
+	if r := receiveRecord(t, b); r.key != "<nil>" || r.value != "{}" {
+		t.Errorf("unkeyed record = %+v", r)
+	}
+}
+
+func TestKafka_ProduceError(t *testing.T) {
+	b := startKafka(t, 1, 10) // MESSAGE_TOO_LARGE
+	k, _ := NewKafka([]string{b.addr}, false)
+	defer k.Close()
+	if err := k.Publish(context.Background(), "centralauth.auth.failed", "website", []byte("{}")); err == nil {
+		t.Error("expected the broker's error")
+	}
+}
+
+func TestNewKafka_Invalid(t *testing.T) {
+	if _, err := NewKafka(nil, false); err == nil {
+		t.Error("expected an error without brokers")
+	}
+	if _, err := NewKafka([]string{"kafka.internal"}, false); err == nil {
+		t.Error("expected an error for a broker without a port")
+	}
+}
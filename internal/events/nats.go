@@ -0,0 +1,223 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsTimeout bounds connecting and each publish when the caller's context
+// has no sooner deadline.
+const natsTimeout = 5 * time.Second
+
+// NATS publishes to a NATS server over its client protocol. As with any
+// core NATS publish, messages are not acknowledged: those sent while no one
+// subscribes to the subject are lost.
+type NATS struct {
+	addr      string
+	tlsConfig *tls.Config
+	user      string
+	password  string
+	token     string
+
+	mu   sync.Mutex
+	conn *natsConn
+}
+
+// NewNATS creates a publisher for the NATS server at rawURL, of the form
+// nats://[user:password@|token@]host[:port], or tls:// for TLS. It connects
+// on first use, and again after the connection fails.
+func NewNATS(rawURL string) (*NATS, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "nats" && u.Scheme != "tls") {
+		return nil, fmt.Errorf("invalid NATS URL: must be nats://host:port or tls://host:port")
+	}
+	n := &NATS{addr: u.Host}
+	if u.Port() == "" {
+		n.addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.Scheme == "tls" {
+		n.tlsConfig = &tls.Config{ServerName: u.Hostname()}
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			n.user, n.password = u.User.Username(), password
+		} else {
+			n.token = u.User.Username()
+		}
+	}
+	return n, nil
+}
+
+// Publish sends msg to the subject topic. NATS has no keys, so key is
+// ignored.
+func (n *NATS) Publish(ctx context.Context, topic, key string, msg []byte) error {
+	if topic == "" || strings.ContainsAny(topic, " \t\r\n") {
+		return fmt.Errorf("nats: invalid subject %q", topic)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil || n.conn.broken() {
+		c, err := n.dial(ctx)
+		if err != nil {
+			return err
+		}
+		n.conn = c
+	}
+	err := n.conn.write(ctx, "PUB "+topic+" "+strconv.Itoa(len(msg))+"\r\n"+string(msg)+"\r\n")
+	if err != nil {
+		n.conn.close()
+		n.conn = nil
+	}
+	return err
+}
+
+// Close closes the connection.
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn != nil {
+		n.conn.close()
+		n.conn = nil
+	}
+	return nil
+}
+
+func (n *NATS) String() string {
+	return "nats " + n.addr
+}
+
+// natsConnect is the CONNECT message that starts a session.
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+	Protocol int    `json:"protocol"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+}
+
+// dial connects and completes the handshake: the server's INFO, our
+// CONNECT, and a PING answered with PONG once the server has accepted it.
+func (n *NATS) dial(ctx context.Context) (*natsConn, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(natsTimeout)
+	}
+	d := net.Dialer{Deadline: deadline}
+	conn, err := d.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return nil, fmt.Errorf("nats: %w", err)
+	}
+	conn.SetDeadline(deadline)
+	r := bufio.NewReader(conn)
+	if line, err := r.ReadString('\n'); err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, fmt.Errorf("nats: %s did not greet with INFO", n.addr)
+	}
+	if n.tlsConfig != nil {
+		tc := tls.Client(conn, n.tlsConfig)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("nats: %w", err)
+		}
+		conn, r = tc, bufio.NewReader(tc)
+	}
+
+	connect, _ := json.Marshal(natsConnect{
+		Name: "centralauth", Lang: "go", Version: "1", Protocol: 1,
+		User: n.user, Password: n.password, Token: n.token,
+	})
+	if _, err := conn.Write([]byte("CONNECT " + string(connect) + "\r\nPING\r\n")); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("nats: %w", err)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("nats: %w", err)
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			conn.SetDeadline(time.Time{})
+			c := &natsConn{conn: conn, done: make(chan struct{})}
+			go c.read(r, n.addr)
+			return c, nil
+		case strings.HasPrefix(line, "-ERR"):
+			conn.Close()
+			return nil, fmt.Errorf("nats: %s refused the connection: %s", n.addr, strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// natsConn is one session with the server. Its reader answers the server's
+// keepalive PINGs and notices when the server drops the connection.
+type natsConn struct {
+	conn net.Conn
+	wmu  sync.Mutex // serializes writes from Publish and the reader
+	done chan struct{}
+}
+
+func (c *natsConn) write(ctx context.Context, s string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(natsTimeout)
+	}
+	c.conn.SetWriteDeadline(deadline)
+	if _, err := c.conn.Write([]byte(s)); err != nil {
+		return fmt.Errorf("nats: %w", err)
+	}
+	return nil
+}
+
+func (c *natsConn) read(r *bufio.Reader, addr string) {
+	defer close(c.done)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Warn("events: NATS connection lost", "addr", addr, "error", err)
+			}
+			c.conn.Close()
+			return
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PING":
+			c.write(context.Background(), "PONG\r\n")
+		case strings.HasPrefix(line, "-ERR"):
+			// Such as a permissions violation for a subject; the server
+			// closes the connection after most errors
+			slog.Warn("events: NATS error", "addr", addr, "error", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// broken reports whether the reader has seen the connection end.
+func (c *natsConn) broken() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *natsConn) close() {
+	c.conn.Close()
+}
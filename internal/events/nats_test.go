@@ -0,0 +1,143 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// natsServer accepts one connection at a time on a local port, speaking
+// enough of the NATS protocol for a publisher. It sends the messages it
+// receives, and the CONNECT payloads, on its channels.
+type natsServer struct {
+	addr     string
+	connects chan string
+	pubs     chan string
+	conns    chan net.Conn
+}
+
+func startNATS(t *testing.T, refuse bool) *natsServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &natsServer{addr: ln.Addr().String(), connects: make(chan string, 4), pubs: make(chan string, 16), conns: make(chan net.Conn, 4)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.conns <- conn
+			go s.serve(conn, refuse)
+		}
+	}()
+	return s
+}
+
+func (s *natsServer) serve(conn net.Conn, refuse bool) {
+	defer conn.Close()
+	io.WriteString(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "CONNECT":
+			s.connects <- strings.TrimSpace(strings.TrimPrefix(line, "CONNECT"))
+			if refuse {
+				io.WriteString(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case "PUB":
+			n, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			s.pubs <- fields[1] + " " + string(payload[:n])
+		}
+	}
+}
+
+func receive(t *testing.T, ch chan string) string {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out")
+		return ""
+	}
+}
+
+func TestNATS_Publish(t *testing.T) {
+	srv := startNATS(t, false)
+	n, err := NewNATS("nats://svc:s3cret@" + srv.addr)
+	if err != nil {
+		t.Fatalf("NewNATS error: %v", err)
+	}
+	defer n.Close()
+
+	if err := n.Publish(context.Background(), "centralauth.auth.succeeded", "website", []byte(`{"type":"auth.succeeded"}`)); err != nil {
+		t.Fatalf("Publish error: %v", err)
+	}
+	if connect := receive(t, srv.connects); !strings.Contains(connect, `"user":"svc"`) || !strings.Contains(connect, `"pass":"s3cret"`) {
+		t.Errorf("CONNECT = %s", connect)
+	}
+	if got := receive(t, srv.pubs); got != `centralauth.auth.succeeded {"type":"auth.succeeded"}` {
+		t.Errorf("published %q", got)
+	}
+
+	// A dropped connection is replaced on the next publish
+	(<-srv.conns).Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for !n.conn.broken() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := n.Publish(context.Background(), "centralauth.auth.failed", "", []byte("{}")); err != nil {
+		t.Fatalf("Publish after reconnect error: %v", err)
+	}
+	if got := receive(t, srv.pubs); got != "centralauth.auth.failed {}" {
+		t.Errorf("published %q", got)
+	}
+}
+
+func TestNATS_Refused(t *testing.T) {
+	srv := startNATS(t, true)
+	n, _ := NewNATS("nats://wrong-token@" + srv.addr)
+	err := n.Publish(context.Background(), "centralauth.auth.succeeded", "", []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("expected the server's refusal, got %v", err)
+	}
+	if connect := receive(t, srv.connects); !strings.Contains(connect, `"auth_token":"wrong-token"`) {
+		t.Errorf("CONNECT = %s", connect)
+	}
+	if err := n.Publish(context.Background(), "bad subject", "", nil); err == nil {
+		t.Error("expected an error for a subject with a space")
+	}
+}
+
+func TestNewNATS_InvalidURL(t *testing.T) {
+	for _, u := range []string{"", "localhost:4222", "http://localhost:4222", "nats://"} {
+		if _, err := NewNATS(u); err == nil {
+			t.Errorf("%q: expected an error", u)
+		}
+	}
+	n, err := NewNATS("tls://nats.internal")
+	if err != nil || n.addr != "nats.internal:4222" || n.tlsConfig == nil {
+		t.Errorf("NewNATS(tls://nats.internal) = %+v, %v", n, err)
+	}
+}
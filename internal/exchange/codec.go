@@ -3,10 +3,15 @@ package exchange
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hkdf"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/BlackMission/centralauth/internal/domain"
@@ -14,15 +19,96 @@ import (
 
 const defaultCodeExpiry = 30 * time.Second
 
+// KeyVersions reports the current exchange key version of a client. Bumping a
+// client's version invalidates every outstanding code issued to it.
+type KeyVersions interface {
+	KeyVersion(clientID string) string
+}
+
 // Codec encrypts and decrypts exchange codes using AES-256-GCM.
 type Codec struct {
-	aead   cipher.AEAD
-	expiry time.Duration
-	now    func() time.Time
+	keys     []codecKey // the current key first, then previous ones
+	previous [][]byte
+	expiry   time.Duration
+	region   string
+	now      func() time.Time
+	rand     io.Reader
+
+	versions KeyVersions // non-nil when per-client keys are enabled
+	derived  sync.Map    // key ID + HKDF info -> cipher.AEAD
+
+	clientExpiry func(clientID string) time.Duration
+}
+
+// codecKey is a master key and the ID that codes sealed with it carry.
+type codecKey struct {
+	id   string
+	key  []byte
+	aead cipher.AEAD
+}
+
+func newCodecKey(key []byte) (codecKey, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return codecKey{}, err
+	}
+	sum := sha256.Sum256(append([]byte("centralauth key id\x00"), key...))
+	return codecKey{id: base64.RawURLEncoding.EncodeToString(sum[:6]), key: key, aead: aead}, nil
+}
+
+// Option configures a Codec.
+type Option func(*Codec)
+
+// WithExpiry sets how long codes stay valid (30 seconds if zero).
+func WithExpiry(d time.Duration) Option {
+	return func(c *Codec) {
+		if d > 0 {
+			c.expiry = d
+		}
+	}
+}
+
+// WithPreviousKeys makes the codec open codes sealed with keys it has been
+// rotated away from, so codes issued before a rotation can still be
+// redeemed. New codes are always sealed with the current key.
+func WithPreviousKeys(keys ...[]byte) Option {
+	return func(c *Codec) {
+		c.previous = append(c.previous, keys...)
+	}
+}
+
+// WithClientExpiry lets clients override the expiry of their codes. fn
+// returns 0 for clients that use the codec's expiry.
+func WithClientExpiry(fn func(clientID string) time.Duration) Option {
+	return func(c *Codec) {
+		c.clientExpiry = fn
+	}
 }
 
 // NewCodec creates an exchange codec with the given 32-byte AES key.
-func NewCodec(key []byte) (*Codec, error) {
+func NewCodec(key []byte, opts ...Option) (*Codec, error) {
+	c := &Codec{
+		expiry: defaultCodeExpiry,
+		now:    time.Now,
+		rand:   rand.Reader,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	for i, k := range append([][]byte{key}, c.previous...) {
+		ck, err := newCodecKey(k)
+		if err != nil {
+			if i > 0 {
+				return nil, fmt.Errorf("previous key %d: %w", i, err)
+			}
+			return nil, err
+		}
+		c.keys = append(c.keys, ck)
+	}
+	return c, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("creating AES cipher: %w", err)
@@ -31,11 +117,45 @@ func NewCodec(key []byte) (*Codec, error) {
 	if err != nil {
 		return nil, fmt.Errorf("creating GCM: %w", err)
 	}
-	return &Codec{
-		aead:   aead,
-		expiry: defaultCodeExpiry,
-		now:    time.Now,
-	}, nil
+	return aead, nil
+}
+
+// EnablePerClientKeys makes the codec encrypt each code under a key derived
+// (HKDF-SHA256) from the master key, the client ID, and the client's key
+// version, so codes issued to one client can only be opened for that client.
+func (c *Codec) EnablePerClientKeys(versions KeyVersions) {
+	c.versions = versions
+}
+
+// aeadFor returns the cipher used for clientID's codes under master key k.
+func (c *Codec) aeadFor(k codecKey, clientID string) (cipher.AEAD, error) {
+	if c.versions == nil {
+		return k.aead, nil
+	}
+	info := "centralauth exchange " + clientID + "\x00" + c.versions.KeyVersion(clientID)
+	if aead, ok := c.derived.Load(k.id + info); ok {
+		return aead.(cipher.AEAD), nil
+	}
+	key, err := hkdf.Key(sha256.New, k.key, nil, info, 32)
+	if err != nil {
+		return nil, fmt.Errorf("deriving client key: %w", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	c.derived.Store(k.id+info, aead)
+	return aead, nil
+}
+
+// expiryFor returns how long codes issued to clientID stay valid.
+func (c *Codec) expiryFor(clientID string) time.Duration {
+	if c.clientExpiry != nil {
+		if d := c.clientExpiry(clientID); d > 0 {
+			return d
+		}
+	}
+	return c.expiry
 }
 
 // SetNow overrides the time function (for testing).
@@ -43,42 +163,94 @@ func (c *Codec) SetNow(fn func() time.Time) {
 	c.now = fn
 }
 
+// SetRand overrides the nonce source (for testing).
+func (c *Codec) SetRand(r io.Reader) {
+	c.rand = r
+}
+
+// SetRegion sets the region label stamped into encoded exchange codes.
+func (c *Codec) SetRegion(region string) {
+	c.region = region
+}
+
+// Region returns the region label of this codec.
+func (c *Codec) Region() string {
+	return c.region
+}
+
 // Encode encrypts an ExchangePayload into a base64url-encoded exchange code.
 func (c *Codec) Encode(payload domain.ExchangePayload) (string, error) {
-	payload.ExpiresAt = c.now().Add(c.expiry)
+	payload.ExpiresAt = c.now().Add(c.expiryFor(payload.ClientID))
+	payload.Region = c.region
 
 	plaintext, err := json.Marshal(payload)
 	if err != nil {
 		return "", fmt.Errorf("marshaling exchange payload: %w", err)
 	}
 
-	nonce := make([]byte, c.aead.NonceSize())
-	if _, err := rand.Read(nonce); err != nil {
+	current := c.keys[0]
+	aead, err := c.aeadFor(current, payload.ClientID)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(c.rand, nonce); err != nil {
 		return "", fmt.Errorf("generating nonce: %w", err)
 	}
 
-	// nonce || ciphertext+tag
-	ciphertext := c.aead.Seal(nonce, nonce, plaintext, nil)
+	// key ID . base64url(nonce || ciphertext+tag)
+	ciphertext := aead.Seal(nonce, nonce, plaintext, nil)
 
-	return base64.RawURLEncoding.EncodeToString(ciphertext), nil
+	return current.id + "." + base64.RawURLEncoding.EncodeToString(ciphertext), nil
 }
 
-// Decode decrypts a base64url-encoded exchange code back into an ExchangePayload.
+// Decode decrypts an exchange code back into an ExchangePayload.
+// With per-client keys enabled, use DecodeFor instead.
 func (c *Codec) Decode(code string) (*domain.ExchangePayload, error) {
+	return c.open(code, func(k codecKey) (cipher.AEAD, error) { return k.aead, nil })
+}
+
+// DecodeFor decrypts an exchange code presented by clientID. With per-client
+// keys enabled, a code issued to any other client fails as invalid.
+func (c *Codec) DecodeFor(code, clientID string) (*domain.ExchangePayload, error) {
+	return c.open(code, func(k codecKey) (cipher.AEAD, error) { return c.aeadFor(k, clientID) })
+}
+
+// open decrypts code with the cipher aeadOf returns for the master key the
+// code names, or for each key in turn if it predates key IDs.
+func (c *Codec) open(code string, aeadOf func(codecKey) (cipher.AEAD, error)) (*domain.ExchangePayload, error) {
+	candidates := c.keys
+	if id, rest, ok := strings.Cut(code, "."); ok {
+		code = rest
+		candidates = nil
+		for _, k := range c.keys {
+			if k.id == id {
+				candidates = []codecKey{k}
+				break
+			}
+		}
+	}
+
 	raw, err := base64.RawURLEncoding.DecodeString(code)
 	if err != nil {
 		return nil, domain.ErrInvalidExchangeCode
 	}
 
-	if len(raw) < c.aead.NonceSize() {
-		return nil, domain.ErrInvalidExchangeCode
+	var plaintext []byte
+	for _, k := range candidates {
+		aead, err := aeadOf(k)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) < aead.NonceSize() {
+			return nil, domain.ErrInvalidExchangeCode
+		}
+		if plaintext, err = aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil); err == nil {
+			break
+		}
 	}
-
-	nonce := raw[:c.aead.NonceSize()]
-	ciphertext := raw[c.aead.NonceSize():]
-
-	plaintext, err := c.aead.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
+	if plaintext == nil {
 		return nil, domain.ErrInvalidExchangeCode
 	}
 
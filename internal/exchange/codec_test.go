@@ -3,6 +3,7 @@ package exchange
 import (
 	"encoding/base64"
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -84,6 +85,34 @@ func TestExpiredCode(t *testing.T) {
 	}
 }
 
+func TestExpiryOptions(t *testing.T) {
+	c, err := NewCodec(testKey,
+		WithExpiry(2*time.Minute),
+		WithClientExpiry(func(clientID string) time.Duration {
+			if clientID == "launcher" {
+				return 10 * time.Second
+			}
+			return 0
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewCodec error: %v", err)
+	}
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	website, _ := c.Encode(domain.ExchangePayload{ClientID: "website"})
+	launcher, _ := c.Encode(domain.ExchangePayload{ClientID: "launcher"})
+
+	c.now = func() time.Time { return now.Add(time.Minute) }
+	if _, err := c.Decode(website); err != nil {
+		t.Errorf("expected the configured expiry to apply, got %v", err)
+	}
+	if _, err := c.Decode(launcher); !errors.Is(err, domain.ErrExpiredExchangeCode) {
+		t.Errorf("expected the client's expiry to apply, got %v", err)
+	}
+}
+
 func TestWrongKey(t *testing.T) {
 	c1 := newTestCodec(t)
 	c2, err := NewCodec([]byte("different-key-567890123456789012"))
@@ -116,9 +145,10 @@ func TestTamperedCiphertext(t *testing.T) {
 		t.Fatalf("Encode error: %v", err)
 	}
 
-	raw, _ := base64.RawURLEncoding.DecodeString(code)
+	kid, sealed, _ := strings.Cut(code, ".")
+	raw, _ := base64.RawURLEncoding.DecodeString(sealed)
 	raw[len(raw)-1] ^= 0xFF // flip last byte
-	tampered := base64.RawURLEncoding.EncodeToString(raw)
+	tampered := kid + "." + base64.RawURLEncoding.EncodeToString(raw)
 
 	_, err = c.Decode(tampered)
 	if !errors.Is(err, domain.ErrInvalidExchangeCode) {
@@ -174,3 +204,125 @@ func TestInvalidKeySize(t *testing.T) {
 		t.Error("expected error for invalid key size")
 	}
 }
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := []byte("previous-key-5678901234567890123")
+	old, err := NewCodec(oldKey)
+	if err != nil {
+		t.Fatalf("NewCodec error: %v", err)
+	}
+	issued, err := old.Encode(domain.ExchangePayload{ClientID: "website"})
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+
+	rotated, err := NewCodec(testKey, WithPreviousKeys(oldKey))
+	if err != nil {
+		t.Fatalf("NewCodec error: %v", err)
+	}
+	if _, err := rotated.Decode(issued); err != nil {
+		t.Errorf("expected a code sealed with the previous key to decode, got %v", err)
+	}
+
+	fresh, err := rotated.Encode(domain.ExchangePayload{ClientID: "website"})
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+	if _, err := newTestCodec(t).Decode(fresh); err != nil {
+		t.Errorf("expected new codes to be sealed with the current key, got %v", err)
+	}
+	if _, err := old.Decode(fresh); !errors.Is(err, domain.ErrInvalidExchangeCode) {
+		t.Errorf("expected the previous key alone to reject new codes, got %v", err)
+	}
+
+	// Once the previous key is dropped, its codes stop working
+	if _, err := newTestCodec(t).Decode(issued); !errors.Is(err, domain.ErrInvalidExchangeCode) {
+		t.Errorf("expected ErrInvalidExchangeCode, got %v", err)
+	}
+}
+
+func TestKeyRotation_InvalidPreviousKey(t *testing.T) {
+	if _, err := NewCodec(testKey, WithPreviousKeys([]byte("too-short"))); err == nil {
+		t.Error("expected error for invalid previous key size")
+	}
+}
+
+func TestCodeWithoutKeyID(t *testing.T) {
+	oldKey := []byte("previous-key-5678901234567890123")
+	c, err := NewCodec(testKey, WithPreviousKeys(oldKey))
+	if err != nil {
+		t.Fatalf("NewCodec error: %v", err)
+	}
+	old, _ := NewCodec(oldKey)
+	code, err := old.Encode(domain.ExchangePayload{ClientID: "website"})
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+
+	// Codes issued before key IDs were added are tried against every key
+	_, sealed, _ := strings.Cut(code, ".")
+	if _, err := c.Decode(sealed); err != nil {
+		t.Errorf("expected a code without a key ID to decode, got %v", err)
+	}
+}
+
+func TestRegionStamped(t *testing.T) {
+	c := newTestCodec(t)
+	c.SetRegion("eu-west")
+
+	code, err := c.Encode(domain.ExchangePayload{ClientID: "website"})
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+
+	other := newTestCodec(t)
+	other.SetRegion("us-east")
+	got, err := other.Decode(code)
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if got.Region != "eu-west" {
+		t.Errorf("expected region 'eu-west', got %q", got.Region)
+	}
+}
+
+type staticVersions map[string]string
+
+func (v staticVersions) KeyVersion(clientID string) string { return v[clientID] }
+
+func TestPerClientKeys(t *testing.T) {
+	c := newTestCodec(t)
+	versions := staticVersions{}
+	c.EnablePerClientKeys(versions)
+
+	code, err := c.Encode(domain.ExchangePayload{ClientID: "website"})
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+
+	if _, err := c.DecodeFor(code, "website"); err != nil {
+		t.Fatalf("DecodeFor(website) error: %v", err)
+	}
+	if _, err := c.DecodeFor(code, "admin"); !errors.Is(err, domain.ErrInvalidExchangeCode) {
+		t.Errorf("expected ErrInvalidExchangeCode for another client, got %v", err)
+	}
+	if _, err := c.Decode(code); !errors.Is(err, domain.ErrInvalidExchangeCode) {
+		t.Errorf("expected master key not to open a per-client code, got %v", err)
+	}
+}
+
+func TestPerClientKeys_VersionChangeRevokesCodes(t *testing.T) {
+	c := newTestCodec(t)
+	versions := staticVersions{"website": "1"}
+	c.EnablePerClientKeys(versions)
+
+	code, err := c.Encode(domain.ExchangePayload{ClientID: "website"})
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+
+	versions["website"] = "2"
+	if _, err := c.DecodeFor(code, "website"); !errors.Is(err, domain.ErrInvalidExchangeCode) {
+		t.Errorf("expected code to be revoked by version change, got %v", err)
+	}
+}
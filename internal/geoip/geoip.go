@@ -0,0 +1,146 @@
+// Package geoip looks up the country and network (autonomous system) of an
+// address in MaxMind databases, such as the free GeoLite2-Country and
+// GeoLite2-ASN, for annotating logs and blocking sign-ins by country.
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Location is what the databases know about an address. Fields are empty
+// when the address isn't in a database or no database was given.
+type Location struct {
+	// Country is the ISO 3166-1 alpha-2 code of the country, e.g. "DE".
+	Country string `json:"country,omitempty"`
+	// ASN is the number of the autonomous system announcing the address.
+	ASN uint32 `json:"asn,omitempty"`
+	// ASOrg is the organization the autonomous system belongs to.
+	ASOrg string `json:"as_org,omitempty"`
+}
+
+// DB looks addresses up in a country database, an ASN database, or both.
+// The files are read into memory, and read again on Reload. A nil *DB knows
+// nothing about any address.
+type DB struct {
+	countryPath, asnPath string
+
+	mu      sync.RWMutex
+	country *reader
+	asn     *reader
+}
+
+// Open reads the country database at countryPath (GeoLite2-Country,
+// GeoLite2-City or their GeoIP2 editions) and the ASN database at asnPath
+// (GeoLite2-ASN or GeoIP2-ISP). Either path may be empty.
+func Open(countryPath, asnPath string) (*DB, error) {
+	db := &DB{countryPath: countryPath, asnPath: asnPath}
+	if err := db.Reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Reload reads the database files again, after they have been updated. On
+// error, the databases already loaded are kept.
+func (db *DB) Reload() error {
+	country, err := openReader(db.countryPath)
+	if err != nil {
+		return err
+	}
+	asn, err := openReader(db.asnPath)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	db.country, db.asn = country, asn
+	db.mu.Unlock()
+	return nil
+}
+
+func openReader(path string) (*reader, error) {
+	if path == "" {
+		return nil, nil
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading GeoIP database: %w", err)
+	}
+	r, err := newReader(buf)
+	if err != nil {
+		return nil, fmt.Errorf("reading GeoIP database %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Lookup returns what the databases know about ip.
+func (db *DB) Lookup(ip netip.Addr) Location {
+	if db == nil || !ip.IsValid() {
+		return Location{}
+	}
+	db.mu.RLock()
+	country, asn := db.country, db.asn
+	db.mu.RUnlock()
+
+	var loc Location
+	if rec := lookupMap(country, ip); rec != nil {
+		// The registered country stands in for addresses, such as
+		// anycast ones, that aren't placed in a country
+		for _, field := range []string{"country", "registered_country"} {
+			if c, ok := rec[field].(map[string]any); ok {
+				if loc.Country, _ = c["iso_code"].(string); loc.Country != "" {
+					break
+				}
+			}
+		}
+	}
+	if rec := lookupMap(asn, ip); rec != nil {
+		loc.ASN = uint32(asUint(rec["autonomous_system_number"]))
+		loc.ASOrg, _ = rec["autonomous_system_organization"].(string)
+	}
+	return loc
+}
+
+// lookupMap returns the record r has for ip, or nil.
+func lookupMap(r *reader, ip netip.Addr) map[string]any {
+	if r == nil {
+		return nil
+	}
+	v, err := r.lookup(ip)
+	if err != nil {
+		slog.Warn("geoip: lookup failed", "database", r.databaseType, "error", err)
+		return nil
+	}
+	m, _ := v.(map[string]any)
+	return m
+}
+
+// ParseCountry normalizes an ISO 3166-1 alpha-2 country code, e.g. "de" to
+// "DE", the form the databases use.
+func ParseCountry(s string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(s))
+	if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+		return "", fmt.Errorf("country %q must be a two-letter ISO 3166-1 code", s)
+	}
+	return code, nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying loc, the location of the address
+// the request being served came from.
+func NewContext(ctx context.Context, loc Location) context.Context {
+	return context.WithValue(ctx, contextKey{}, loc)
+}
+
+// FromContext returns the location stored in ctx by NewContext, or an empty
+// one.
+func FromContext(ctx context.Context) Location {
+	loc, _ := ctx.Value(contextKey{}).(Location)
+	return loc
+}
@@ -0,0 +1,95 @@
+package geoip
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeDB(t *testing.T, path string, db []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, db, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_Lookup(t *testing.T) {
+	dir := t.TempDir()
+	countryPath, asnPath := filepath.Join(dir, "country.mmdb"), filepath.Join(dir, "asn.mmdb")
+	writeDB(t, countryPath, buildDB(t, "GeoLite2-Country", 24, []testNetwork{
+		{"203.0.113.0/24", map[string]any{"country": map[string]any{"iso_code": "DE", "names": map[string]any{"en": "Germany"}}}},
+		{"198.51.100.0/24", map[string]any{"registered_country": map[string]any{"iso_code": "US"}}},
+	}))
+	writeDB(t, asnPath, buildDB(t, "GeoLite2-ASN", 24, []testNetwork{
+		{"203.0.113.0/24", map[string]any{"autonomous_system_number": uint32(64500), "autonomous_system_organization": "Example Net"}},
+	}))
+
+	db, err := Open(countryPath, asnPath)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	if got, want := db.Lookup(netip.MustParseAddr("203.0.113.9")), (Location{Country: "DE", ASN: 64500, ASOrg: "Example Net"}); got != want {
+		t.Errorf("Lookup = %+v, want %+v", got, want)
+	}
+	if got := db.Lookup(netip.MustParseAddr("198.51.100.1")); got != (Location{Country: "US"}) {
+		t.Errorf("expected the registered country, got %+v", got)
+	}
+	if got := db.Lookup(netip.MustParseAddr("192.0.2.1")); got != (Location{}) {
+		t.Errorf("expected nothing for an unknown address, got %+v", got)
+	}
+
+	// Reload picks up a replaced file
+	writeDB(t, countryPath, buildDB(t, "GeoLite2-Country", 24, []testNetwork{
+		{"203.0.113.0/24", map[string]any{"country": map[string]any{"iso_code": "FR"}}},
+	}))
+	if err := db.Reload(); err != nil {
+		t.Fatalf("Reload error: %v", err)
+	}
+	if got := db.Lookup(netip.MustParseAddr("203.0.113.9")); got.Country != "FR" {
+		t.Errorf("after Reload, Country = %q, want FR", got.Country)
+	}
+
+	// A broken file leaves the loaded databases in place
+	writeDB(t, countryPath, []byte("garbage"))
+	if err := db.Reload(); err == nil {
+		t.Error("expected Reload to fail on a broken file")
+	}
+	if got := db.Lookup(netip.MustParseAddr("203.0.113.9")); got.Country != "FR" {
+		t.Errorf("after a failed Reload, Country = %q, want FR", got.Country)
+	}
+}
+
+func TestDB_Nil(t *testing.T) {
+	var db *DB
+	if got := db.Lookup(netip.MustParseAddr("203.0.113.9")); got != (Location{}) {
+		t.Errorf("nil DB Lookup = %+v", got)
+	}
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb"), ""); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestParseCountry(t *testing.T) {
+	for in, want := range map[string]string{"de": "DE", " US ": "US"} {
+		if got, err := ParseCountry(in); err != nil || got != want {
+			t.Errorf("ParseCountry(%q) = %q, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "DEU", "Germany", "1A"} {
+		if _, err := ParseCountry(in); err == nil {
+			t.Errorf("ParseCountry(%q): expected an error", in)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	loc := Location{Country: "DE", ASN: 64500}
+	if got := FromContext(NewContext(context.Background(), loc)); got != loc {
+		t.Errorf("FromContext = %+v, want %+v", got, loc)
+	}
+	if got := FromContext(context.Background()); got != (Location{}) {
+		t.Errorf("FromContext without a location = %+v", got)
+	}
+}
@@ -0,0 +1,290 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+)
+
+// metadataMarker precedes the metadata map at the end of a MaxMind DB file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+var errCorrupt = errors.New("corrupt MaxMind DB data")
+
+// Data section types, from the MaxMind DB format specification.
+const (
+	typeExtended = 0
+	typePointer  = 1
+	typeString   = 2
+	typeDouble   = 3
+	typeBytes    = 4
+	typeUint16   = 5
+	typeUint32   = 6
+	typeMap      = 7
+	typeInt32    = 8
+	typeUint64   = 9
+	typeUint128  = 10
+	typeArray    = 11
+	typeBoolean  = 14
+	typeFloat    = 15
+)
+
+// maxDepth bounds how deeply maps, arrays and pointers may nest, so that a
+// corrupt file can't recurse forever.
+const maxDepth = 32
+
+// reader reads a MaxMind DB file, the format of the GeoLite2 and GeoIP2
+// databases: a binary search tree over the bits of an address whose leaves
+// point into a section of typed data.
+type reader struct {
+	buf          []byte
+	data         decoder
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	ipv4Start    uint
+	databaseType string
+}
+
+func newReader(buf []byte) (*reader, error) {
+	end := bytes.LastIndex(buf, metadataMarker)
+	if end < 0 {
+		return nil, errors.New("not a MaxMind DB file")
+	}
+	v, _, err := decoder{buf: buf[end+len(metadataMarker):]}.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+	meta, _ := v.(map[string]any)
+	r := &reader{
+		buf:        buf,
+		nodeCount:  uint(asUint(meta["node_count"])),
+		recordSize: uint(asUint(meta["record_size"])),
+		ipVersion:  uint(asUint(meta["ip_version"])),
+	}
+	r.databaseType, _ = meta["database_type"].(string)
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", r.ipVersion)
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+16 > uint(end) {
+		return nil, errCorrupt
+	}
+	r.data = decoder{buf: buf[treeSize+16 : end]}
+
+	// IPv4 addresses live under ::/96 in IPv6 databases
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (r *reader) record(node, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		b := r.buf[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.buf[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(r.buf[node*8+bit*4:]))
+	}
+}
+
+// lookup returns the data recorded for the network containing ip, or nil if
+// the database has none.
+func (r *reader) lookup(ip netip.Addr) (any, error) {
+	ip = ip.Unmap()
+	var addr []byte
+	var node uint
+	switch {
+	case ip.Is4():
+		a := ip.As4()
+		addr, node = a[:], r.ipv4Start
+	case r.ipVersion == 6:
+		a := ip.As16()
+		addr = a[:]
+	default:
+		return nil, nil
+	}
+	for i := 0; i < len(addr)*8 && node < r.nodeCount; i++ {
+		node = r.record(node, uint(addr[i/8]>>(7-i%8))&1)
+	}
+	switch {
+	case node == r.nodeCount:
+		return nil, nil
+	case node < r.nodeCount:
+		return nil, errCorrupt
+	}
+	v, _, err := r.data.decode(node-r.nodeCount-16, 0)
+	return v, err
+}
+
+// decoder decodes values from a MaxMind DB data section, into strings,
+// numbers, []byte, map[string]any and []any.
+type decoder struct {
+	buf []byte
+}
+
+// decode returns the value at off and the offset following it.
+func (d decoder) decode(off uint, depth int) (any, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, errCorrupt
+	}
+	ctrl, off, err := d.bytes(off, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	typ := uint(ctrl[0] >> 5)
+	if typ == typePointer {
+		ptr, next, err := d.pointer(ctrl[0], off)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := d.decode(ptr, depth+1)
+		return v, next, err
+	}
+	if typ == typeExtended {
+		ext, next, err := d.bytes(off, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		typ, off = 7+uint(ext[0]), next
+	}
+
+	size := uint(ctrl[0] & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		b, next, err := d.bytes(off, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		size, off = []uint{29, 285, 65821}[n-1]+uint(beUint(b)), next
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, min(size, 64))
+		for range size {
+			k, next, err := d.decode(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errCorrupt
+			}
+			if m[key], off, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, off, nil
+	case typeArray:
+		a := make([]any, 0, min(size, 64))
+		for range size {
+			v, next, err := d.decode(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a, off = append(a, v), next
+		}
+		return a, off, nil
+	case typeBoolean:
+		return size != 0, off, nil
+	}
+
+	b, off, err := d.bytes(off, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch typ {
+	case typeString:
+		return string(b), off, nil
+	case typeBytes:
+		return bytes.Clone(b), off, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errCorrupt
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), off, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errCorrupt
+		}
+		return beUint(b), off, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errCorrupt
+		}
+		return int32(beUint(b)), off, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, errCorrupt
+		}
+		return new(big.Int).SetBytes(b), off, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unknown type %d", errCorrupt, typ)
+}
+
+// pointer returns the data section offset a pointer with control byte ctrl
+// refers to, and the offset following it.
+func (d decoder) pointer(ctrl byte, off uint) (uint, uint, error) {
+	n := uint(ctrl>>3)&3 + 1
+	b, next, err := d.bytes(off, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	v := uint(ctrl & 7)
+	switch n {
+	case 1:
+		return v<<8 | uint(b[0]), next, nil
+	case 2:
+		return (v<<16 | uint(beUint(b))) + 2048, next, nil
+	case 3:
+		return (v<<24 | uint(beUint(b))) + 526336, next, nil
+	default:
+		return uint(beUint(b)), next, nil
+	}
+}
+
+// bytes returns the n bytes at off and the offset following them.
+func (d decoder) bytes(off, n uint) ([]byte, uint, error) {
+	if off > uint(len(d.buf)) || n > uint(len(d.buf))-off {
+		return nil, 0, errCorrupt
+	}
+	return d.buf[off : off+n], off + n, nil
+}
+
+// beUint decodes up to 8 big-endian bytes.
+func beUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// asUint returns v as a uint64 if it is an unsigned number, else 0.
+func asUint(v any) uint64 {
+	n, _ := v.(uint64)
+	return n
+}
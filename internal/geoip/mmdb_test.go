@@ -0,0 +1,221 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+// testNetwork is one network of a test database and its record.
+type testNetwork struct {
+	prefix string
+	data   map[string]any
+}
+
+// buildDB returns an IPv6 MaxMind DB file holding networks, with records of
+// recordSize bits. IPv4 networks are placed under ::/96, as in MaxMind's
+// databases.
+func buildDB(t *testing.T, dbType string, recordSize int, networks []testNetwork) []byte {
+	t.Helper()
+	const empty = -1
+	nodes := [][2]int{{empty, empty}}
+	var data bytes.Buffer
+	leaves := map[int]int{} // leaf ID to data offset
+	for i, n := range networks {
+		p := netip.MustParsePrefix(n.prefix)
+		addr, bits := p.Addr().As16(), p.Bits()
+		if p.Addr().Is4() {
+			a4 := p.Addr().As4()
+			addr = [16]byte{}
+			copy(addr[12:], a4[:])
+			bits += 96
+		}
+		leaf := -2 - i
+		leaves[leaf] = data.Len()
+		encode(&data, n.data)
+
+		node := 0
+		for b := range bits {
+			bit := int(addr[b/8]>>(7-b%8)) & 1
+			if b == bits-1 {
+				nodes[node][bit] = leaf
+				break
+			}
+			if nodes[node][bit] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	nodeCount := len(nodes)
+	value := func(rec int) uint32 {
+		switch {
+		case rec == empty:
+			return uint32(nodeCount)
+		case rec < 0:
+			return uint32(nodeCount + 16 + leaves[rec])
+		default:
+			return uint32(rec)
+		}
+	}
+	var file bytes.Buffer
+	for _, n := range nodes {
+		l, r := value(n[0]), value(n[1])
+		switch recordSize {
+		case 24:
+			file.Write([]byte{byte(l >> 16), byte(l >> 8), byte(l), byte(r >> 16), byte(r >> 8), byte(r)})
+		case 28:
+			file.Write([]byte{byte(l >> 16), byte(l >> 8), byte(l), byte(l>>20)&0xf0 | byte(r>>24)&0x0f, byte(r >> 16), byte(r >> 8), byte(r)})
+		default:
+			file.Write(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, l), r))
+		}
+	}
+	file.Write(make([]byte, 16))
+	file.Write(data.Bytes())
+	file.Write(metadataMarker)
+	encode(&file, map[string]any{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint32(recordSize),
+		"ip_version":                  uint32(6),
+		"database_type":               dbType,
+		"binary_format_major_version": uint32(2),
+		"languages":                   []any{"en"},
+	})
+	return file.Bytes()
+}
+
+// encode writes v in the data section format.
+func encode(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case string:
+		control(buf, typeString, len(v))
+		buf.WriteString(v)
+	case uint32:
+		b := bytes.TrimLeft(binary.BigEndian.AppendUint32(nil, v), "\x00")
+		control(buf, typeUint32, len(b))
+		buf.Write(b)
+	case uint64:
+		b := bytes.TrimLeft(binary.BigEndian.AppendUint64(nil, v), "\x00")
+		control(buf, typeUint64, len(b))
+		buf.Write(b)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		control(buf, typeBoolean, size)
+	case []any:
+		control(buf, typeArray, len(v))
+		for _, e := range v {
+			encode(buf, e)
+		}
+	case map[string]any:
+		control(buf, typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			encode(buf, k)
+			encode(buf, v[k])
+		}
+	default:
+		panic("encode: unsupported type")
+	}
+}
+
+func control(buf *bytes.Buffer, typ, size int) {
+	t := typ
+	if typ > 7 {
+		t = typeExtended
+	}
+	var sizeBytes []byte
+	switch {
+	case size < 29:
+	case size < 285:
+		sizeBytes, size = []byte{byte(size - 29)}, 29
+	default:
+		sizeBytes, size = []byte{byte((size - 285) >> 8), byte(size - 285)}, 30
+	}
+	buf.WriteByte(byte(t<<5 | size))
+	if typ > 7 {
+		buf.WriteByte(byte(typ - 7))
+	}
+	buf.Write(sizeBytes)
+}
+
+func TestReader_Lookup(t *testing.T) {
+	for _, size := range []int{24, 28, 32} {
+		r, err := newReader(buildDB(t, "Test", size, []testNetwork{
+			{"203.0.113.0/24", map[string]any{"name": "documentation", "big": uint64(1 << 40), "ok": true}},
+			{"2001:db8::/32", map[string]any{"name": "v6", "tags": []any{"a", "b"}}},
+		}))
+		if err != nil {
+			t.Fatalf("record size %d: newReader error: %v", size, err)
+		}
+		v, err := r.lookup(netip.MustParseAddr("203.0.113.77"))
+		m, _ := v.(map[string]any)
+		if err != nil || m["name"] != "documentation" || m["big"] != uint64(1<<40) || m["ok"] != true {
+			t.Errorf("record size %d: lookup(203.0.113.77) = %v, %v", size, v, err)
+		}
+		if v, err := r.lookup(netip.MustParseAddr("::ffff:203.0.113.1")); err != nil || v == nil {
+			t.Errorf("record size %d: IPv4-mapped lookup = %v, %v", size, v, err)
+		}
+		v, err = r.lookup(netip.MustParseAddr("2001:db8::1"))
+		m, _ = v.(map[string]any)
+		if tags, _ := m["tags"].([]any); err != nil || len(tags) != 2 || tags[1] != "b" {
+			t.Errorf("record size %d: lookup(2001:db8::1) = %v, %v", size, v, err)
+		}
+		for _, ip := range []string{"198.51.100.1", "2001:db9::1"} {
+			if v, err := r.lookup(netip.MustParseAddr(ip)); v != nil || err != nil {
+				t.Errorf("record size %d: lookup(%s) = %v, %v; want nothing", size, ip, v, err)
+			}
+		}
+	}
+}
+
+func TestReader_Invalid(t *testing.T) {
+	if _, err := newReader([]byte("not a database")); err == nil {
+		t.Error("expected an error without metadata")
+	}
+	db := buildDB(t, "Test", 24, []testNetwork{{"203.0.113.0/24", map[string]any{"name": "x"}}})
+	if _, err := newReader(db[bytes.LastIndex(db, metadataMarker)-4:]); err == nil {
+		t.Error("expected an error for a truncated search tree")
+	}
+}
+
+func TestDecoder_Pointer(t *testing.T) {
+	var buf bytes.Buffer
+	encode(&buf, "iso_code")
+	mapOff := uint(buf.Len())
+	control(&buf, typeMap, 1)
+	buf.Write([]byte{typePointer << 5, 0}) // the key, at offset 0
+	encode(&buf, "DE")
+
+	v, next, err := decoder{buf: buf.Bytes()}.decode(mapOff, 0)
+	if m, _ := v.(map[string]any); err != nil || m["iso_code"] != "DE" {
+		t.Fatalf("decode = %v, %v", v, err)
+	}
+	if next != uint(buf.Len()) {
+		t.Errorf("next = %d, want %d", next, buf.Len())
+	}
+}
+
+func TestDecoder_Corrupt(t *testing.T) {
+	for _, b := range [][]byte{
+		{},
+		{typeString<<5 | 5, 'a'},          // shorter than its size
+		{typePointer << 5, 0},             // points at itself
+		{typeMap<<5 | 1, typeUint16 << 5}, // a key that isn't a string
+		{typeExtended << 5, 200},          // unknown type
+	} {
+		if v, _, err := (decoder{buf: b}).decode(0, 0); err == nil {
+			t.Errorf("decode(%x) = %v, want an error", b, v)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/BlackMission/centralauth/internal/audit"
+)
+
+// AdminActorHeader names the operator performing an admin action. All admins
+// share one API key, so this is what makes audit entries attributable.
+const AdminActorHeader = "X-Admin-Actor"
+
+// AdminAuth rejects requests that don't carry the admin API key as a bearer
+// token and, when certFingerprints are given, requests not made with one of
+// those TLS client certificates.
+func AdminAuth(apiKey string, certFingerprints []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(authHeader, "Bearer ")
+		if token == authHeader || !hmac.Equal([]byte(token), []byte(apiKey)) {
+			writeError(w, http.StatusUnauthorized, "invalid admin API key")
+			return
+		}
+		if len(certFingerprints) > 0 && !hasCert(certFingerprints, r) {
+			slog.WarnContext(r.Context(), "admin: API key used without an admin certificate", "actor", adminActor(r))
+			writeError(w, http.StatusForbidden, "client certificate required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AuditEvents handles GET /admin/audit.
+func AuditEvents(auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		events := auditLog.Events()
+		if events == nil {
+			events = []audit.Event{}
+		}
+		writeJSON(w, http.StatusOK, map[string][]audit.Event{"events": events})
+	}
+}
+
+// adminActor returns the operator named by the request, or "admin".
+func adminActor(r *http.Request) string {
+	if actor := r.Header.Get(AdminActorHeader); actor != "" {
+		return actor
+	}
+	return "admin"
+}
+
+// recordAdmin writes an audit entry for the admin request r.
+func recordAdmin(auditLog *audit.Log, r *http.Request, action, target string) {
+	auditLog.Record(adminActor(r), r.RemoteAddr, action, target)
+}
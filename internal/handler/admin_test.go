@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/audit"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestAdminAuth(t *testing.T) {
+	h := AdminAuth("admin-secret", nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"valid key", "Bearer admin-secret", http.StatusNoContent},
+		{"wrong key", "Bearer nope", http.StatusUnauthorized},
+		{"missing bearer prefix", "admin-secret", http.StatusUnauthorized},
+		{"no header", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers map[string]string
+			if tt.header != "" {
+				headers = map[string]string{"Authorization": tt.header}
+			}
+			rr := testutil.DoRequest(t, h, http.MethodGet, "/admin/drain", headers)
+			testutil.AssertStatus(t, rr, tt.want)
+		})
+	}
+}
+
+func TestAdminAuth_ClientCertificate(t *testing.T) {
+	adminCert := &x509.Certificate{Raw: []byte("admin-cert")}
+	h := AdminAuth("admin-secret", []string{client.CertFingerprint(adminCert)}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for _, tt := range []struct {
+		name string
+		cert *x509.Certificate
+		want int
+	}{
+		{"admin certificate", adminCert, http.StatusNoContent},
+		{"other certificate", &x509.Certificate{Raw: []byte("other")}, http.StatusForbidden},
+		{"no certificate", nil, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/drain", nil)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		if tt.cert != nil {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rr.Code, tt.want)
+		}
+	}
+}
+
+func TestAuditEvents(t *testing.T) {
+	auditLog := audit.NewLog(0)
+	auditLog.Record("alice", "10.0.0.1", "drain.start", "")
+
+	rr := testutil.DoRequest(t, AuditEvents(auditLog), http.MethodGet, "/admin/audit", nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+
+	var body map[string][]audit.Event
+	testutil.ParseJSON(t, rr, &body)
+	if len(body["events"]) != 1 || body["events"][0].Actor != "alice" {
+		t.Errorf("unexpected events: %+v", body)
+	}
+}
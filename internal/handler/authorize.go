@@ -2,83 +2,319 @@ package handler
 
 import (
 	"errors"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
 
 	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/ban"
+	"github.com/BlackMission/centralauth/internal/captcha"
 	"github.com/BlackMission/centralauth/internal/client"
 	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/events"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/hook"
+	"github.com/BlackMission/centralauth/internal/hosted"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/internal/mfa"
+	"github.com/BlackMission/centralauth/internal/pages"
+	"github.com/BlackMission/centralauth/internal/scope"
+	"github.com/BlackMission/centralauth/internal/session"
 	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/ticket"
 )
 
+// maxAppStateBytes caps the app_state a client can pass through a flow.
+const maxAppStateBytes = 512
+
 // Authorize handles GET /auth/{provider}.
 // It validates the client, redirect_uri, and provider, then redirects to the provider's auth URL.
-func Authorize(clients *client.Registry, providers *auth.Registry, stateService *state.Service) http.HandlerFunc {
+// A browser with an SSO session from the same provider is sent straight back
+// with an exchange code instead, unless the request has prompt=login.
+// A code_challenge binds the code to a PKCE verifier; public clients must
+// send one, as they have no API key to redeem it with. An app_state is
+// returned as it was on the final redirect. Errors go to browsers as a page.
+// For a client that requires a CAPTCHA, the request must carry the pass the
+// chooser at /auth gives once it is solved; without one, the browser is sent
+// there to solve it.
+func Authorize(clients *client.Registry, providers *auth.Registry, stateService *state.Service, funnel *metrics.Funnel, bus *events.Bus, mfaSvc *mfa.Service,
+	codec *exchange.Codec, sessions *session.Service, bans *ban.List, hooks hook.Chain, verifier *captcha.Verifier, passes *ticket.Signer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		clientID := r.URL.Query().Get("client_id")
 		if clientID == "" {
-			writeError(w, http.StatusBadRequest, "missing client_id parameter")
+			writePageError(w, r, http.StatusBadRequest, "missing client_id parameter", "", clientLink{})
 			return
 		}
 
 		redirectURI := r.URL.Query().Get("redirect_uri")
 		if redirectURI == "" {
-			writeError(w, http.StatusBadRequest, "missing redirect_uri parameter")
+			writePageError(w, r, http.StatusBadRequest, "missing redirect_uri parameter", "", clientLink{})
 			return
 		}
 
 		providerName := r.PathValue("provider")
 		if providerName == "" {
-			writeError(w, http.StatusBadRequest, "missing provider")
+			writePageError(w, r, http.StatusBadRequest, "missing provider", "", clientLink{})
 			return
 		}
 
-		// Validate client exists
-		if _, err := clients.Get(clientID); err != nil {
-			writeError(w, http.StatusBadRequest, "unknown client")
+		// Validate client exists and isn't suspended
+		clientApp, err := clients.Get(clientID)
+		if errors.Is(err, domain.ErrClientDisabled) {
+			slog.InfoContext(r.Context(), "authorize: refusing a flow for a disabled client", "client_id", clientID)
+			writePageError(w, r, http.StatusForbidden, "client is disabled", "", clientLink{})
+			return
+		}
+		if err != nil {
+			writePageError(w, r, http.StatusBadRequest, "unknown client", "", clientLink{})
 			return
 		}
 
 		// Validate redirect_uri is allowed
 		if err := clients.ValidateCallback(clientID, redirectURI); err != nil {
-			writeError(w, http.StatusBadRequest, "redirect_uri not allowed")
+			writePageError(w, r, http.StatusBadRequest, "redirect_uri not allowed", "", clientLink{})
 			return
 		}
+		back := linkBack(clientApp.Name, redirectURI)
+		r = logWith(r, "client_id", clientID, "provider", providerName)
 
 		// Validate provider exists
 		provider, err := providers.Get(providerName)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, "unknown provider")
+			writePageError(w, r, http.StatusBadRequest, "unknown provider", "", back)
 			return
 		}
 
 		// Validate provider is allowed for this client
 		if err := clients.ValidateProvider(clientID, providerName); err != nil {
 			if errors.Is(err, domain.ErrProviderNotAllowed) {
-				writeError(w, http.StatusForbidden, "provider not allowed for this client")
+				writePageError(w, r, http.StatusForbidden, "provider not allowed for this client", "", back)
 				return
 			}
-			writeError(w, http.StatusBadRequest, "unknown client")
+			writePageError(w, r, http.StatusBadRequest, "unknown client", "", back)
+			return
+		}
+
+		// Bots can't skip the chooser's CAPTCHA by coming here directly
+		if hosted.Widget(verifier, clientApp.RequireCaptcha) != nil && !validCaptchaPass(r, passes, clientID, redirectURI) {
+			q := r.URL.Query()
+			q.Del(captchaPassParam)
+			w.Header().Set("Location", "../auth?"+q.Encode())
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+
+		// Validate the requested assurance level
+		acr := r.URL.Query().Get("acr")
+		switch {
+		case acr == "":
+		case acr == mfa.ACR2FA && mfaSvc != nil:
+		case acr == mfa.ACR2FA:
+			writePageError(w, r, http.StatusBadRequest, "acr=2fa is not enabled on this server", "", back)
+			return
+		default:
+			writePageError(w, r, http.StatusBadRequest, "unsupported acr value", "", back)
+			return
+		}
+
+		// Resolve the requested data-release scopes
+		granted, err := scope.Parse(r.URL.Query().Get("scope"))
+		if err != nil {
+			writePageError(w, r, http.StatusBadRequest, "unsupported scope value", "", back)
+			return
+		}
+
+		// Carried in the state token, which goes to the provider in a URL
+		appState := r.URL.Query().Get("app_state")
+		if len(appState) > maxAppStateBytes {
+			writePageError(w, r, http.StatusBadRequest, "app_state is too long", "", back)
 			return
 		}
 
-		// Generate state token
-		stateToken, err := stateService.Generate(domain.StatePayload{
+		challenge := r.URL.Query().Get("code_challenge")
+		if challenge != "" && r.URL.Query().Get("code_challenge_method") != "S256" {
+			writePageError(w, r, http.StatusBadRequest, "code_challenge_method must be S256", "", back)
+			return
+		}
+		if challenge == "" && clientApp.Public {
+			writePageError(w, r, http.StatusBadRequest, "public clients must send a code_challenge", "", back)
+			return
+		}
+
+		payload := domain.StatePayload{
 			ClientID:    clientID,
 			Provider:    providerName,
 			RedirectURI: redirectURI,
-		})
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, "failed to generate state token")
+			ACR:         acr,
+			Scope:       granted,
+			Raw:         clientApp.IncludeRaw,
+			Tokens:      clientApp.AllowTokenPassthrough,
+
+			CodeChallenge: challenge,
+			AppState:      appState,
+		}
+		if sess, ok := browserSession(r, sessions); ok && sessionSignsIn(sess, payload) {
+			resumeSession(w, r, sessions, codec, funnel, bus, bans, hooks, sess, payload)
 			return
 		}
+		startFlow(w, r, stateService, provider, funnel, payload)
+	}
+}
 
-		// Get provider auth URL
-		authURL, err := provider.AuthURL(stateToken)
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, "failed to generate auth URL")
+// ChooseProvider handles GET /auth, which takes the same parameters as
+// /auth/{provider} and shows the user a page to pick one of the client's
+// providers, each continuing to /auth/{provider}. A client with a single
+// provider goes straight to it, as does a browser with an SSO session from
+// one of them, unless the request has prompt=login. For a client that
+// requires a CAPTCHA, the page is always shown, as a form posted to /auth
+// with the answer.
+func ChooseProvider(clients *client.Registry, providers *auth.Registry, sessions *session.Service, verifier *captcha.Verifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientApp, names, ok := chooserClient(w, r, clients, providers)
+		if !ok {
+			return
+		}
+		// The CAPTCHA is on the page, so it can't be skipped
+		chosen := ""
+		if hosted.Widget(verifier, clientApp.RequireCaptcha) == nil {
+			if len(names) == 1 {
+				chosen = names[0]
+			} else if sess, ok := browserSession(r, sessions); ok && slices.Contains(names, sess.User.ProviderName) {
+				chosen = sess.User.ProviderName
+			}
+		}
+		if chosen != "" {
+			w.Header().Set("Location", providerLink(chosen, r.URL.RawQuery))
+			w.WriteHeader(http.StatusFound)
 			return
 		}
+		renderChooser(w, r, http.StatusOK, clientApp, names, verifier, "")
+	}
+}
+
+// ProviderChosen handles POST /auth, the chooser page of a client that
+// requires a CAPTCHA. It checks the answer and continues to the chosen
+// provider's /auth/{provider} with a pass from passes, which lets the browser
+// start a flow there for a short while.
+func ProviderChosen(clients *client.Registry, providers *auth.Registry, verifier *captcha.Verifier, passes *ticket.Signer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientApp, names, ok := chooserClient(w, r, clients, providers)
+		if !ok {
+			return
+		}
+		if err := hosted.VerifyCaptcha(r, verifier, clientApp.RequireCaptcha, clientApp.ID); err != nil {
+			renderChooser(w, r, http.StatusBadRequest, clientApp, names, verifier, "Please complete the CAPTCHA.")
+			return
+		}
+		q := r.URL.Query()
+		chosen := r.PostFormValue("provider")
+		if !slices.Contains(names, chosen) {
+			writePageError(w, r, http.StatusBadRequest, "provider not allowed for this client", "", linkBack(clientApp.Name, q.Get("redirect_uri")))
+			return
+		}
+		if hosted.Widget(verifier, clientApp.RequireCaptcha) != nil {
+			pass, err := issueCaptchaPass(r, passes, clientApp.ID, q.Get("redirect_uri"))
+			if err != nil {
+				slog.ErrorContext(r.Context(), "authorize: signing CAPTCHA pass failed", "client_id", clientApp.ID, "error", err)
+				writePageError(w, r, http.StatusInternalServerError, "failed to issue CAPTCHA pass", "", linkBack(clientApp.Name, q.Get("redirect_uri")))
+				return
+			}
+			q.Set(captchaPassParam, pass)
+		}
+		w.Header().Set("Location", providerLink(chosen, q.Encode()))
+		w.WriteHeader(http.StatusFound)
+	}
+}
+
+// chooserClient validates the client and redirect_uri of a request to
+// /auth and lists the providers it can offer, writing an error page if it
+// can't.
+func chooserClient(w http.ResponseWriter, r *http.Request, clients *client.Registry, providers *auth.Registry) (*domain.ClientApp, []string, bool) {
+	q := r.URL.Query()
+	clientID := q.Get("client_id")
+	if clientID == "" {
+		writePageError(w, r, http.StatusBadRequest, "missing client_id parameter", "", clientLink{})
+		return nil, nil, false
+	}
+	if q.Get("redirect_uri") == "" {
+		writePageError(w, r, http.StatusBadRequest, "missing redirect_uri parameter", "", clientLink{})
+		return nil, nil, false
+	}
+	clientApp, err := clients.Get(clientID)
+	if errors.Is(err, domain.ErrClientDisabled) {
+		writePageError(w, r, http.StatusForbidden, "client is disabled", "", clientLink{})
+		return nil, nil, false
+	}
+	if err != nil {
+		writePageError(w, r, http.StatusBadRequest, "unknown client", "", clientLink{})
+		return nil, nil, false
+	}
+	if err := clients.ValidateCallback(clientID, q.Get("redirect_uri")); err != nil {
+		writePageError(w, r, http.StatusBadRequest, "redirect_uri not allowed", "", clientLink{})
+		return nil, nil, false
+	}
 
-		http.Redirect(w, r, authURL, http.StatusFound)
+	names := clientProviders(clientApp, providers)
+	if len(names) == 0 {
+		writePageError(w, r, http.StatusBadRequest, "no provider is available to this client", "", linkBack(clientApp.Name, q.Get("redirect_uri")))
+		return nil, nil, false
+	}
+	return clientApp, names, true
+}
+
+// providerLink returns where the chooser continues to for provider, with
+// rawQuery. It is relative to /auth, so that it stays under any BASE_PATH.
+func providerLink(provider, rawQuery string) string {
+	return "auth/" + url.PathEscape(provider) + "?" + rawQuery
+}
+
+// renderChooser renders the chooser page for clientApp, offering names.
+func renderChooser(w http.ResponseWriter, r *http.Request, status int, clientApp *domain.ClientApp, names []string, verifier *captcha.Verifier, msg string) {
+	choices := make([]pages.ProviderLink, len(names))
+	for i, name := range names {
+		choices[i] = pages.ProviderLink{Name: name, URL: providerLink(name, r.URL.RawQuery)}
+	}
+	name := clientApp.Name
+	if name == "" {
+		name = clientApp.ID
 	}
+	pages.Render(w, status, "choose_provider.html", pages.ProviderChoice{
+		Client:    name,
+		Providers: choices,
+		Action:    "auth?" + r.URL.RawQuery,
+		Error:     msg,
+		Captcha:   hosted.Widget(verifier, clientApp.RequireCaptcha),
+	})
+}
+
+// startFlow gives payload a flow ID, signs it into a state token, and sends
+// the browser to the provider's sign-in page with it.
+func startFlow(w http.ResponseWriter, r *http.Request, stateService *state.Service, provider auth.Provider, funnel *metrics.Funnel, payload domain.StatePayload) {
+	flowID, err := newFlowID()
+	if err != nil {
+		writePageError(w, r, http.StatusInternalServerError, "failed to generate flow ID", "", flowLink(payload.RedirectURI, payload.Device))
+		return
+	}
+	payload.FlowID = flowID
+	r = logWith(r, "flow_id", flowID)
+
+	// Generate state token
+	stateToken, err := stateService.Generate(payload)
+	if err != nil {
+		writePageError(w, r, http.StatusInternalServerError, "failed to generate state token", flowID, flowLink(payload.RedirectURI, payload.Device))
+		return
+	}
+
+	// Get provider auth URL
+	authURL, err := provider.AuthURL(stateToken)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "authorize: generating provider auth URL failed", "error", err)
+		writePageError(w, r, http.StatusInternalServerError, "failed to generate auth URL", flowID, flowLink(payload.RedirectURI, payload.Device))
+		return
+	}
+
+	slog.InfoContext(r.Context(), "authorize: flow started")
+	funnel.Reached(metrics.StageAuthorizeIssued, payload.ClientID, payload.Provider)
+	http.Redirect(w, r, authURL, http.StatusFound)
 }
@@ -4,15 +4,40 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/captcha"
 	"github.com/BlackMission/centralauth/internal/client"
 	"github.com/BlackMission/centralauth/internal/domain"
 	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/ticket"
 	"github.com/BlackMission/centralauth/pkg/testutil"
 )
 
+// newTestCaptcha returns a Turnstile verifier that accepts only the answer
+// "good-token".
+func newTestCaptcha(t *testing.T) *captcha.Verifier {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("response") == "good-token" {
+			w.Write([]byte(`{"success":true}`))
+			return
+		}
+		w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	v, err := captcha.New(captcha.Turnstile, "site-key", "secret")
+	if err != nil {
+		t.Fatalf("captcha.New error: %v", err)
+	}
+	v.SetVerifyURL(srv.URL)
+	return v
+}
+
 type stubProvider struct {
 	name    string
 	authURL string
@@ -42,7 +67,7 @@ func setupAuthorize() (http.Handler, *client.Registry, *auth.Registry, *state.Se
 	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /auth/{provider}", Authorize(clients, providers, stateSvc))
+	mux.HandleFunc("GET /auth/{provider}", Authorize(clients, providers, stateSvc, nil, nil, nil, nil, nil, nil, nil, nil, nil))
 	return mux, clients, providers, stateSvc
 }
 
@@ -58,6 +83,263 @@ func TestAuthorize_ValidRequest(t *testing.T) {
 	}
 }
 
+func TestAuthorize_AssignsFlowID(t *testing.T) {
+	handler, _, _, stateSvc := setupAuthorize()
+	rr := testutil.DoRequest(t, handler, http.MethodGet,
+		"/auth/discord?client_id=website&redirect_uri=https://example.com/callback", nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse Location: %v", err)
+	}
+	payload, err := stateSvc.Validate(loc.Query().Get("state"))
+	if err != nil {
+		t.Fatalf("Validate state error: %v", err)
+	}
+	if len(payload.FlowID) != 16 {
+		t.Errorf("FlowID = %q, want 16 hex characters", payload.FlowID)
+	}
+}
+
+func TestAuthorize_IncludeRaw(t *testing.T) {
+	handler, clients, _, stateSvc := setupAuthorize()
+	clients.Replace([]domain.ClientApp{{
+		ID:                    "website",
+		APIKey:                "web-key",
+		AllowedCallbacks:      []string{"https://example.com/callback"},
+		AllowedProviders:      []string{"discord"},
+		IncludeRaw:            true,
+		AllowTokenPassthrough: true,
+	}})
+
+	rr := testutil.DoRequest(t, handler, http.MethodGet,
+		"/auth/discord?client_id=website&redirect_uri=https://example.com/callback", nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	payload, err := stateSvc.Validate(loc.Query().Get("state"))
+	if err != nil {
+		t.Fatalf("Validate state error: %v", err)
+	}
+	if !payload.Raw {
+		t.Error("expected the state to ask for raw profiles")
+	}
+	if !payload.Tokens {
+		t.Error("expected the state to ask for provider tokens")
+	}
+}
+
+func TestAuthorize_ACRWithoutMFA(t *testing.T) {
+	handler, _, _, _ := setupAuthorize()
+	for _, acr := range []string{"2fa", "bogus"} {
+		rr := testutil.DoRequest(t, handler, http.MethodGet,
+			"/auth/discord?client_id=website&redirect_uri=https://example.com/callback&acr="+acr, nil)
+		testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	}
+}
+
+func TestAuthorize_Scope(t *testing.T) {
+	handler, _, _, stateSvc := setupAuthorize()
+	tests := map[string]string{
+		"":                    "profile email",
+		"email":               "email",
+		"connections+profile": "profile connections",
+	}
+	for requested, want := range tests {
+		rr := testutil.DoRequest(t, handler, http.MethodGet,
+			"/auth/discord?client_id=website&redirect_uri=https://example.com/callback&scope="+requested, nil)
+		testutil.AssertStatus(t, rr, http.StatusFound)
+
+		loc, _ := url.Parse(rr.Header().Get("Location"))
+		payload, err := stateSvc.Validate(loc.Query().Get("state"))
+		if err != nil {
+			t.Fatalf("Validate state error: %v", err)
+		}
+		if payload.Scope != want {
+			t.Errorf("scope=%q: granted %q, want %q", requested, payload.Scope, want)
+		}
+	}
+}
+
+func TestAuthorize_PKCE(t *testing.T) {
+	handler, clients, _, stateSvc := setupAuthorize()
+	clients.Replace([]domain.ClientApp{{
+		ID:               "spa",
+		Public:           true,
+		AllowedCallbacks: []string{"https://app.example.com/callback"},
+		AllowedProviders: []string{"discord"},
+	}})
+	base := "/auth/discord?client_id=spa&redirect_uri=" + url.QueryEscape("https://app.example.com/callback")
+
+	rr := testutil.DoRequest(t, handler, http.MethodGet, base, nil)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	rr = testutil.DoRequest(t, handler, http.MethodGet, base+"&code_challenge=abc&code_challenge_method=plain", nil)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+
+	rr = testutil.DoRequest(t, handler, http.MethodGet, base+"&code_challenge=abc&code_challenge_method=S256", nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	payload, err := stateSvc.Validate(loc.Query().Get("state"))
+	if err != nil || payload.CodeChallenge != "abc" {
+		t.Errorf("state = %+v, %v", payload, err)
+	}
+}
+
+func TestAuthorize_AppState(t *testing.T) {
+	handler, _, _, stateSvc := setupAuthorize()
+	base := "/auth/discord?client_id=website&redirect_uri=https://example.com/callback&app_state="
+
+	rr := testutil.DoRequest(t, handler, http.MethodGet, base+url.QueryEscape("/servers/42?tab=bans"), nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	payload, err := stateSvc.Validate(loc.Query().Get("state"))
+	if err != nil || payload.AppState != "/servers/42?tab=bans" {
+		t.Errorf("state = %+v, %v", payload, err)
+	}
+
+	rr = testutil.DoRequest(t, handler, http.MethodGet, base+strings.Repeat("a", maxAppStateBytes+1), nil)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestChooseProvider(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "website", Name: "Website", APIKey: "web-key", AllowedCallbacks: []string{"https://example.com/callback"}, AllowedProviders: []string{"discord", "steam", "github"}},
+		{ID: "game", Name: "Game", APIKey: "game-key", AllowedCallbacks: []string{"https://game.example.com/callback"}, AllowedProviders: []string{"steam"}},
+	})
+	providers := auth.NewRegistry()
+	providers.Register(&stubProvider{name: "discord", authURL: "https://discord.com/oauth2/authorize"})
+	providers.Register(&stubProvider{name: "steam", authURL: "https://steamcommunity.com/openid/login"})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /auth", ChooseProvider(clients, providers, nil, nil))
+
+	query := "client_id=website&redirect_uri=" + url.QueryEscape("https://example.com/callback") + "&app_state=home"
+	rr := testutil.DoRequest(t, mux, http.MethodGet, "/auth?"+query, nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	body := rr.Body.String()
+	if !strings.Contains(body, "Sign in to Website") || !strings.Contains(body, `href="auth/discord?`+strings.ReplaceAll(query, "&", "&amp;")+`"`) ||
+		!strings.Contains(body, `href="auth/steam?`) || strings.Contains(body, "github") {
+		t.Errorf("expected a button for each registered provider, got %s", body)
+	}
+
+	rr = testutil.DoRequest(t, mux, http.MethodGet, "/auth?client_id=game&redirect_uri="+url.QueryEscape("https://game.example.com/callback"), nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	if loc := rr.Header().Get("Location"); !strings.HasPrefix(loc, "auth/steam?") {
+		t.Errorf("expected the only provider to be chosen, got %s", loc)
+	}
+
+	for _, q := range []string{"redirect_uri=https://example.com/callback", "client_id=website", "client_id=website&redirect_uri=https://evil.example/callback", "client_id=nope&redirect_uri=https://example.com/callback"} {
+		rr = testutil.DoRequest(t, mux, http.MethodGet, "/auth?"+q, nil)
+		testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	}
+}
+
+func TestChooseProvider_Captcha(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "website", Name: "Website", APIKey: "web-key", RequireCaptcha: true, AllowedCallbacks: []string{"https://example.com/callback", "https://example.com/other"}, AllowedProviders: []string{"discord", "steam"}},
+		{ID: "game", Name: "Game", APIKey: "game-key", RequireCaptcha: true, AllowedCallbacks: []string{"https://game.example.com/callback"}, AllowedProviders: []string{"steam"}},
+	})
+	providers := auth.NewRegistry()
+	providers.Register(&stubProvider{name: "discord", authURL: "https://discord.com/oauth2/authorize"})
+	providers.Register(&stubProvider{name: "steam", authURL: "https://steamcommunity.com/openid/login"})
+	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
+	verifier := newTestCaptcha(t)
+	passes := ticket.NewSigner([]byte("pass-key"), 0)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /auth", ChooseProvider(clients, providers, nil, verifier))
+	mux.HandleFunc("POST /auth", ProviderChosen(clients, providers, verifier, passes))
+	mux.HandleFunc("GET /auth/{provider}", Authorize(clients, providers, stateSvc, nil, nil, nil, nil, nil, nil, nil, verifier, passes))
+
+	query := "client_id=website&redirect_uri=" + url.QueryEscape("https://example.com/callback")
+	rr := testutil.DoRequest(t, mux, http.MethodGet, "/auth?"+query, nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	if body := rr.Body.String(); !strings.Contains(body, `data-sitekey="site-key"`) || !strings.Contains(body, `<form method="post"`) ||
+		!strings.Contains(body, `value="discord"`) || strings.Contains(body, `href="auth/discord?`) {
+		t.Errorf("expected a form with the CAPTCHA widget, got %s", body)
+	}
+
+	rr = postForm(t, mux, "/auth?"+query, url.Values{"provider": {"discord"}}, "", "")
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	if !strings.Contains(rr.Body.String(), "Please complete the CAPTCHA.") {
+		t.Errorf("expected the page again with an error, got %s", rr.Body)
+	}
+	rr = postForm(t, mux, "/auth?"+query, url.Values{"provider": {"discord"}, "cf-turnstile-response": {"bad-token"}}, "", "")
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+
+	rr = postForm(t, mux, "/auth?"+query, url.Values{"provider": {"github"}, "cf-turnstile-response": {"good-token"}}, "", "")
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	rr = postForm(t, mux, "/auth?"+query, url.Values{"provider": {"steam"}, "cf-turnstile-response": {"good-token"}}, "", "")
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	loc := rr.Header().Get("Location")
+	if !strings.HasPrefix(loc, "auth/steam?") || !strings.Contains(loc, "captcha_pass=") {
+		t.Fatalf("expected the provider with a CAPTCHA pass, got %s", loc)
+	}
+	rr = testutil.DoRequest(t, mux, http.MethodGet, "/"+loc, nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	if loc := rr.Header().Get("Location"); !strings.HasPrefix(loc, "https://steamcommunity.com/openid/login?state=") {
+		t.Errorf("expected the flow to start with the pass, got %s", loc)
+	}
+
+	// The pass is the only way past the chooser
+	u, _ := url.Parse(loc)
+	pass := u.Query().Get("captcha_pass")
+	for _, q := range []string{
+		query,
+		query + "&captcha_pass=forged",
+		"client_id=website&redirect_uri=" + url.QueryEscape("https://example.com/other") + "&captcha_pass=" + pass,
+	} {
+		rr = testutil.DoRequest(t, mux, http.MethodGet, "/auth/steam?"+q, nil)
+		testutil.AssertStatus(t, rr, http.StatusFound)
+		if loc := rr.Header().Get("Location"); !strings.HasPrefix(loc, "../auth?") || strings.Contains(loc, "captcha_pass") {
+			t.Errorf("%s: expected the chooser, got %s", q, loc)
+		}
+	}
+
+	// Even with a single provider
+	rr = testutil.DoRequest(t, mux, http.MethodGet, "/auth?client_id=game&redirect_uri="+url.QueryEscape("https://game.example.com/callback"), nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	if !strings.Contains(rr.Body.String(), `data-sitekey="site-key"`) {
+		t.Errorf("expected the CAPTCHA for a single-provider client, got %s", rr.Body)
+	}
+}
+
+func TestAuthorize_ErrorPage(t *testing.T) {
+	handler, _, _, _ := setupAuthorize()
+	browser := map[string]string{"Accept": "text/html"}
+
+	rr := testutil.DoRequest(t, handler, http.MethodGet,
+		"/auth/discord?client_id=website&redirect_uri=https://example.com/callback&scope=everything", browser)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	if body := rr.Body.String(); !strings.Contains(body, "unsupported scope value") ||
+		!strings.Contains(body, `<a href="https://example.com/">Return to Website</a>`) {
+		t.Errorf("error page = %s", body)
+	}
+
+	// An unvalidated redirect_uri is never linked to
+	rr = testutil.DoRequest(t, handler, http.MethodGet,
+		"/auth/discord?client_id=website&redirect_uri=https://evil.com/callback", browser)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	if body := rr.Body.String(); !strings.Contains(body, "redirect_uri not allowed") || strings.Contains(body, "evil.com") {
+		t.Errorf("error page = %s", body)
+	}
+
+	// API clients keep getting JSON
+	rr = testutil.DoRequest(t, handler, http.MethodGet,
+		"/auth/discord?client_id=website&redirect_uri=https://example.com/callback&scope=everything", nil)
+	var resp errorResponse
+	testutil.ParseJSON(t, rr, &resp)
+	if resp.Error != "unsupported scope value" {
+		t.Errorf("error = %q", resp.Error)
+	}
+}
+
+func TestAuthorize_UnknownScope(t *testing.T) {
+	handler, _, _, _ := setupAuthorize()
+	rr := testutil.DoRequest(t, handler, http.MethodGet,
+		"/auth/discord?client_id=website&redirect_uri=https://example.com/callback&scope=guilds", nil)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+}
+
 func TestAuthorize_MissingClientID(t *testing.T) {
 	handler, _, _, _ := setupAuthorize()
 	rr := testutil.DoRequest(t, handler, http.MethodGet,
@@ -72,6 +354,20 @@ func TestAuthorize_UnknownClient(t *testing.T) {
 	testutil.AssertStatus(t, rr, http.StatusBadRequest)
 }
 
+func TestAuthorize_DisabledClient(t *testing.T) {
+	handler, clients, _, _ := setupAuthorize()
+	clients.Disable("website", "alice")
+
+	rr := testutil.DoRequest(t, handler, http.MethodGet,
+		"/auth/discord?client_id=website&redirect_uri=https://example.com/callback", nil)
+	testutil.AssertStatus(t, rr, http.StatusForbidden)
+
+	clients.Enable("website")
+	rr = testutil.DoRequest(t, handler, http.MethodGet,
+		"/auth/discord?client_id=website&redirect_uri=https://example.com/callback", nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+}
+
 func TestAuthorize_DisallowedRedirectURI(t *testing.T) {
 	handler, _, _, _ := setupAuthorize()
 	rr := testutil.DoRequest(t, handler, http.MethodGet,
@@ -102,7 +398,7 @@ func TestAuthorize_ProviderNotAllowedForClient(t *testing.T) {
 	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /auth/{provider}", Authorize(clients, providers, stateSvc))
+	mux.HandleFunc("GET /auth/{provider}", Authorize(clients, providers, stateSvc, nil, nil, nil, nil, nil, nil, nil, nil, nil))
 
 	rr := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodGet,
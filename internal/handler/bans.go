@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/audit"
+	"github.com/BlackMission/centralauth/internal/ban"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/events"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/internal/refresh"
+)
+
+// refuseBanned sends the browser back to the client of the flow in payload
+// with an access_denied error if user is banned, and reports whether it did.
+// A ban list that can't be read refuses the sign-in too, as the exchange
+// code would be refused without the identity database anyway.
+func refuseBanned(w http.ResponseWriter, r *http.Request, bans *ban.List, funnel *metrics.Funnel, bus *events.Bus,
+	payload *domain.StatePayload, user domain.UserInfo, flowID string) bool {
+	b, err := bans.Check(r.Context(), user)
+	switch {
+	case err != nil:
+		signInFailed(r, funnel, bus, "ban_check_failed", flowID, payload.ClientID, user.ProviderName)
+		slog.ErrorContext(r.Context(), "ban: checking the ban list failed", "error", err)
+		redirectError(w, r, payload, http.StatusServiceUnavailable, oauthTemporarilyUnavailable, "sign-in is unavailable, please try again")
+		return true
+	case b != nil:
+		signInFailed(r, funnel, bus, "banned", flowID, payload.ClientID, user.ProviderName)
+		slog.WarnContext(r.Context(), "ban: refused a banned user", "provider_id", user.ProviderID, "ban_id", b.ID)
+		redirectError(w, r, payload, http.StatusForbidden, oauthAccessDenied, "account is banned")
+		return true
+	}
+	return false
+}
+
+// refreshBanned checks the user of a sign-in being refreshed for clientApp
+// against the ban list. If they have been banned since, or the list can't be
+// read, it revokes the sign-in's refresh tokens, of which next is the newest,
+// and returns the ban or the error for the caller to refuse the refresh
+// with. The token the client sent is spent by then, so the sign-in can't be
+// kept for a retry either way.
+func refreshBanned(ctx context.Context, bans *ban.List, refresher *refresh.Service, clientApp *domain.ClientApp, user domain.UserInfo, next string) (*ban.Ban, error) {
+	b, err := bans.Check(ctx, user)
+	switch {
+	case err != nil:
+		slog.ErrorContext(ctx, "ban: checking the ban list failed", "error", err)
+	case b != nil:
+		slog.WarnContext(ctx, "ban: refused to refresh a banned user's sign-in", "provider_id", user.ProviderID, "ban_id", b.ID)
+	default:
+		return nil, nil
+	}
+	if err := refresher.Revoke(ctx, clientApp.ID, next, clientApp.RefreshTokenTTL); err != nil {
+		slog.ErrorContext(ctx, "token: revoking refresh tokens failed", "client_id", clientApp.ID, "error", err)
+	}
+	return b, err
+}
+
+// Bans handles GET /admin/bans, listing the bans in force.
+func Bans(bans *ban.List) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		active, err := bans.Active(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "ban: listing bans failed", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to list bans")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string][]ban.Ban{"bans": active})
+	}
+}
+
+const maxBanRequestBytes = 4 << 10
+
+type banRequest struct {
+	CentralID  string `json:"central_id,omitempty"`
+	Provider   string `json:"provider,omitempty"`
+	ProviderID string `json:"provider_id,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Duration   string `json:"duration,omitempty"`
+}
+
+// AddBan handles POST /admin/bans. The body names either a central_id or a
+// provider and provider_id, and may give a reason and how long the ban
+// lasts; without a duration it is permanent.
+func AddBan(bans *ban.List, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req banRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBanRequestBytes)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		byAccount := req.Provider != "" || req.ProviderID != ""
+		if (req.CentralID != "") == byAccount || (byAccount && (req.Provider == "" || req.ProviderID == "")) {
+			writeError(w, http.StatusBadRequest, "give either central_id, or provider and provider_id")
+			return
+		}
+		b := ban.Ban{CentralID: req.CentralID, Provider: req.Provider, ProviderID: req.ProviderID, Reason: req.Reason, BannedBy: adminActor(r)}
+		if req.Duration != "" {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil || d <= 0 {
+				writeError(w, http.StatusBadRequest, "invalid duration")
+				return
+			}
+			b.ExpiresAt = time.Now().Add(d)
+		}
+
+		b, err := bans.Add(r.Context(), b)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "ban: adding ban failed", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to add ban")
+			return
+		}
+
+		recordAdmin(auditLog, r, "ban.add", b.Target())
+		writeJSON(w, http.StatusCreated, b)
+	}
+}
+
+// RemoveBan handles DELETE /admin/bans/{id}, lifting the ban.
+func RemoveBan(bans *ban.List, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		err := bans.Remove(r.Context(), id)
+		switch {
+		case errors.Is(err, domain.ErrBanNotFound):
+			writeError(w, http.StatusNotFound, "unknown ban")
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "ban: removing ban failed", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to remove ban")
+			return
+		}
+
+		recordAdmin(auditLog, r, "ban.remove", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
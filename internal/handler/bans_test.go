@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/audit"
+	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/ban"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/internal/refresh"
+	"github.com/BlackMission/centralauth/internal/sqldb"
+	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/store"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+// newBans returns a ban list over a fake bans table, which bans by provider
+// account only. Setting *down fails every query.
+func newBans(t *testing.T) (*ban.List, *bool) {
+	t.Helper()
+	down := new(bool)
+	var rows [][]driver.Value
+	db := sqldb.New(testutil.OpenSQL(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		switch {
+		case strings.HasPrefix(query, "SELECT version"):
+			return []string{"version"}, nil, nil
+		case *down:
+			return nil, nil, errors.New("connection refused")
+		case strings.HasPrefix(query, "INSERT INTO bans"):
+			rows = append(rows, args)
+			return nil, [][]driver.Value{{}}, nil
+		case strings.HasPrefix(query, "DELETE FROM bans"):
+			n := len(rows)
+			rows = slices.DeleteFunc(rows, func(row []driver.Value) bool { return row[0] == args[0] })
+			return nil, make([][]driver.Value, n-len(rows)), nil
+		case strings.HasPrefix(query, "SELECT id"):
+			var matched [][]driver.Value
+			for _, row := range rows {
+				if len(args) == 1 || row[2] == args[0] && row[3] == args[1] {
+					matched = append(matched, row)
+				}
+			}
+			return []string{"id", "central_id", "provider", "provider_id", "reason", "expires_at", "banned_by", "banned_at"}, matched, nil
+		}
+		return nil, nil, nil
+	}), sqldb.SQLite)
+	bans, err := ban.NewList(context.Background(), db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bans, down
+}
+
+func TestCallback_Banned(t *testing.T) {
+	bans, down := newBans(t)
+	if _, err := bans.Add(context.Background(), ban.Ban{Provider: "discord", ProviderID: "666", BannedBy: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	providers := auth.NewRegistry()
+	provider := &callbackStubProvider{name: "discord"}
+	providers.Register(provider)
+	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /callback/{provider}", Callback(providers, stateSvc, codec, nil, nil, nil, nil, nil, bans, nil, nil))
+	callback := func(userID string) url.Values {
+		t.Helper()
+		provider.result = &domain.AuthResult{User: domain.UserInfo{ProviderName: "discord", ProviderID: userID}}
+		token, _ := stateSvc.Generate(domain.StatePayload{ClientID: "website", Provider: "discord", RedirectURI: "https://example.com/callback"})
+		rr := testutil.DoRequest(t, mux, http.MethodGet, "/callback/discord?code=x&state="+url.QueryEscape(token), nil)
+		testutil.AssertStatus(t, rr, http.StatusFound)
+		loc, _ := url.Parse(rr.Header().Get("Location"))
+		return loc.Query()
+	}
+
+	if q := callback("123"); q.Get("code") == "" {
+		t.Errorf("a user who isn't banned got %v", q)
+	}
+	if q := callback("666"); q.Get("code") != "" || q.Get("error") != oauthAccessDenied {
+		t.Errorf("a banned user got %v", q)
+	}
+	*down = true
+	if q := callback("123"); q.Get("code") != "" || q.Get("error") != oauthTemporarilyUnavailable {
+		t.Errorf("with the ban list down, got %v", q)
+	}
+}
+
+func TestRefreshToken_Banned(t *testing.T) {
+	bans, down := newBans(t)
+	clients, _ := client.NewRegistry([]domain.ClientApp{{ID: "app", APIKey: "app-api-key-secret", RefreshTokenTTL: time.Hour}})
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	refresher := refresh.New(store.NewMemory())
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /exchange", Exchange(clients, codec, nil, nil, nil, refresher, nil, nil, nil))
+	mux.HandleFunc("POST /token/refresh", RefreshToken(clients, refresher, nil, bans))
+	refreshWith := func(token string) *httptest.ResponseRecorder {
+		return postToken(t, mux, "/token/refresh", "app-api-key-secret", `{"refresh_token":"`+token+`"}`)
+	}
+
+	token := signInFor(t, mux, codec, "app", "app-api-key-secret", FormatJSON).RefreshToken
+	rr := refreshWith(token)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	var result domain.AuthResult
+	testutil.ParseJSON(t, rr, &result)
+
+	// A user banned since signing in is refused, and the sign-in revoked, so
+	// lifting the ban doesn't bring it back
+	b, err := bans.Add(context.Background(), ban.Ban{Provider: "discord", ProviderID: "123", BannedBy: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AssertStatus(t, refreshWith(result.RefreshToken), http.StatusForbidden)
+	if err := bans.Remove(context.Background(), b.ID); err != nil {
+		t.Fatal(err)
+	}
+	rr = refreshWith(result.RefreshToken)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	if !strings.Contains(rr.Body.String(), "invalid refresh token") {
+		t.Errorf("expected the sign-in to be revoked, got %s", rr.Body)
+	}
+
+	*down = true
+	token = signInFor(t, mux, codec, "app", "app-api-key-secret", FormatJSON).RefreshToken
+	testutil.AssertStatus(t, refreshWith(token), http.StatusServiceUnavailable)
+}
+
+func TestOIDCToken_RefreshBanned(t *testing.T) {
+	bans, _ := newBans(t)
+	clients, _ := client.NewRegistry([]domain.ClientApp{{
+		ID:               "grafana",
+		APIKey:           "grafana-secret",
+		AllowedCallbacks: []string{"https://grafana.example.com/login/generic_oauth"},
+		RefreshTokenTTL:  time.Hour,
+	}})
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	signer, _ := idtoken.NewSigner(testutil.SigningKeyPEM(t))
+	ids := idtoken.NewIssuer(signer, "https://auth.example.com", 0)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", Token(clients, codec, store.NewMemory(), ids, refresh.New(store.NewMemory()), nil, nil, nil, nil, bans))
+
+	rr := postForm(t, mux, "/token", codeGrant(oidcCode(t, codec, ""), ""), "grafana", "grafana-secret")
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	var tokens tokenResponse
+	testutil.ParseJSON(t, rr, &tokens)
+
+	if _, err := bans.Add(context.Background(), ban.Ban{Provider: "discord", ProviderID: "123", BannedBy: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	rr = postForm(t, mux, "/token", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}, "grafana", "grafana-secret")
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	if got := oauthErrorOf(t, rr); got != oauthInvalidGrant {
+		t.Errorf("error = %q, want %q", got, oauthInvalidGrant)
+	}
+}
+
+func postBan(h http.Handler, body, actor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/admin/bans", strings.NewReader(body))
+	req.Header.Set(AdminActorHeader, actor)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestBans_Admin(t *testing.T) {
+	bans, _ := newBans(t)
+	auditLog := audit.NewLog(0)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/bans", Bans(bans))
+	mux.HandleFunc("POST /admin/bans", AddBan(bans, auditLog))
+	mux.HandleFunc("DELETE /admin/bans/{id}", RemoveBan(bans, auditLog))
+
+	for _, body := range []string{
+		`{}`,
+		`{"central_id":"c0ffee","provider":"discord","provider_id":"123"}`,
+		`{"provider":"discord"}`,
+		`{"provider_id":"123"}`,
+		`{"provider":"discord","provider_id":"123","duration":"soon"}`,
+		`{`,
+	} {
+		rr := postBan(mux, body, "")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("POST %s: status %d, want 400", body, rr.Code)
+		}
+	}
+
+	rr := postBan(mux, `{"provider":"discord","provider_id":"123","reason":"spam","duration":"24h"}`, "alice")
+	testutil.AssertStatus(t, rr, http.StatusCreated)
+	var added ban.Ban
+	testutil.ParseJSON(t, rr, &added)
+	if added.ID == "" || added.BannedBy != "alice" || time.Until(added.ExpiresAt) < 23*time.Hour {
+		t.Errorf("added ban = %+v", added)
+	}
+
+	var listed struct{ Bans []ban.Ban }
+	testutil.ParseJSON(t, testutil.DoRequest(t, mux, http.MethodGet, "/admin/bans", nil), &listed)
+	if len(listed.Bans) != 1 || listed.Bans[0].ID != added.ID || listed.Bans[0].Reason != "spam" {
+		t.Errorf("listed bans = %+v", listed.Bans)
+	}
+
+	testutil.AssertStatus(t, testutil.DoRequest(t, mux, http.MethodDelete, "/admin/bans/"+added.ID, nil), http.StatusNoContent)
+	testutil.AssertStatus(t, testutil.DoRequest(t, mux, http.MethodDelete, "/admin/bans/"+added.ID, nil), http.StatusNotFound)
+
+	events := auditLog.Events()
+	if len(events) != 2 || events[0].Action != "ban.add" || events[0].Target != "discord/123" || events[1].Action != "ban.remove" || events[1].Target != added.ID {
+		t.Errorf("audit events = %+v", events)
+	}
+}
@@ -2,26 +2,53 @@ package handler
 
 import (
 	"errors"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
 
 	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/ban"
 	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/events"
 	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/hook"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/internal/mfa"
+	"github.com/BlackMission/centralauth/internal/roles"
+	"github.com/BlackMission/centralauth/internal/scope"
+	"github.com/BlackMission/centralauth/internal/session"
 	"github.com/BlackMission/centralauth/internal/state"
 )
 
 // Callback handles GET /callback/{provider}.
 // It validates the state token, exchanges the code with the provider, encrypts the result,
 // and redirects back to the client with an exchange code.
-func Callback(providers *auth.Registry, stateService *state.Service, codec *exchange.Codec) http.HandlerFunc {
+// Provider exchanges are bounded by limiter (nil means unlimited). Users on
+// the bans list are sent back to the client with an access_denied error
+// before a second factor or an exchange code; other users are given the
+// roles rules grant them, and then put to the post-auth hooks. When sessions
+// is set, a completed sign-in also starts an SSO session, which keeps the
+// user as they were before the hooks, since the hooks run again for each
+// client it signs in to. A user who denies the sign-in, or a provider
+// exchange that fails, sends the browser back to the client with an OAuth
+// error; other errors go to browsers as a page linking back to the client.
+func Callback(providers *auth.Registry, stateService *state.Service, codec *exchange.Codec, limiter *auth.Limiter, funnel *metrics.Funnel, bus *events.Bus, mfaSvc *mfa.Service,
+	sessions *session.Service, bans *ban.List, rules roles.Rules, hooks hook.Chain) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		providerName := r.PathValue("provider")
+		provider, providerErr := providers.Get(providerName)
+		// Only registered providers label the funnel, so made-up paths
+		// can't add series
+		metricProvider := ""
+		if providerErr == nil {
+			metricProvider = providerName
+		}
 
 		// Get state token - could be in query param (Discord) or embedded in return_to (Steam)
 		stateToken := r.URL.Query().Get("state")
 		if stateToken == "" {
-			writeError(w, http.StatusBadRequest, "missing state parameter")
+			writePageError(w, r, http.StatusBadRequest, "missing state parameter", "", clientLink{})
 			return
 		}
 
@@ -29,17 +56,30 @@ func Callback(providers *auth.Registry, stateService *state.Service, codec *exch
 		statePayload, err := stateService.Validate(stateToken)
 		if err != nil {
 			if errors.Is(err, domain.ErrExpiredState) {
-				writeError(w, http.StatusBadRequest, "state token expired")
+				funnel.Dropped(metrics.StageCallbackReceived, "state_expired", "", metricProvider)
+				writePageError(w, r, http.StatusBadRequest, "state token expired", "", clientLink{})
+				return
+			}
+			if errors.Is(err, domain.ErrRevokedState) {
+				funnel.Dropped(metrics.StageCallbackReceived, "state_revoked", "", metricProvider)
+				writePageError(w, r, http.StatusBadRequest, "auth flow was cancelled, please sign in again", "", clientLink{})
 				return
 			}
-			writeError(w, http.StatusBadRequest, "invalid state token")
+			funnel.Dropped(metrics.StageCallbackReceived, "state_invalid", "", metricProvider)
+			writePageError(w, r, http.StatusBadRequest, "invalid state token", "", clientLink{})
 			return
 		}
+		funnel.Reached(metrics.StageCallbackReceived, statePayload.ClientID, statePayload.Provider)
+		flowID := statePayload.FlowID
+		back := flowLink(statePayload.RedirectURI, statePayload.Device)
+		r = logWith(r, "flow_id", flowID, "client_id", statePayload.ClientID, "provider", providerName)
+		if statePayload.Region != "" && statePayload.Region != stateService.Region() {
+			slog.InfoContext(r.Context(), "callback: completing a flow from another region",
+				"started_in", statePayload.Region, "region", stateService.Region())
+		}
 
-		// Get provider
-		provider, err := providers.Get(providerName)
-		if err != nil {
-			writeError(w, http.StatusBadRequest, "unknown provider")
+		if providerErr != nil {
+			writePageError(w, r, http.StatusBadRequest, "unknown provider", flowID, back)
 			return
 		}
 
@@ -51,37 +91,203 @@ func Callback(providers *auth.Registry, stateService *state.Service, codec *exch
 			}
 		}
 
+		// A provider that reports an error, such as the user saying no, has
+		// nothing to exchange
+		if err := auth.CallbackError(params); errors.Is(err, domain.ErrConsentDenied) {
+			signInFailed(r, funnel, bus, "consent_denied", flowID, statePayload.ClientID, providerName)
+			slog.InfoContext(r.Context(), "callback: user denied consent at the provider")
+			redirectError(w, r, statePayload, http.StatusForbidden, oauthAccessDenied, "sign-in was denied at the provider")
+			return
+		} else if err != nil {
+			signInFailed(r, funnel, bus, "provider_failed", flowID, statePayload.ClientID, providerName)
+			slog.WarnContext(r.Context(), "callback: provider reported an error", "error", err)
+			redirectError(w, r, statePayload, http.StatusBadGateway, oauthServerError, "provider exchange failed")
+			return
+		}
+
+		// Wait for a concurrency slot so a slow provider can't starve the others
+		ctx, span := traceProvider(r, "provider slot wait", providerName)
+		release, err := limiter.Acquire(ctx, providerName)
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			signInFailed(r, funnel, bus, "provider_busy", flowID, statePayload.ClientID, providerName)
+			slog.WarnContext(r.Context(), "callback: provider exchange not started", "error", err)
+			w.Header().Set("Retry-After", limiter.RetryAfter(providerName))
+			writePageError(w, r, http.StatusServiceUnavailable, "provider is busy, please try again", flowID, back)
+			return
+		}
+
+		// Spend the state token before the provider sees the callback, so a
+		// replayed callback can't complete the flow twice. A store that fails
+		// lets it through, as it would without replay protection.
+		if err := stateService.Consume(r.Context(), statePayload); errors.Is(err, domain.ErrUsedState) {
+			release()
+			signInFailed(r, funnel, bus, "state_reused", flowID, statePayload.ClientID, providerName)
+			slog.WarnContext(r.Context(), "callback: state token used again")
+			writePageError(w, r, http.StatusBadRequest, "sign-in was already completed, please sign in again", flowID, back)
+			return
+		} else if err != nil {
+			slog.WarnContext(r.Context(), "callback: store failed; not checking for state reuse", "error", err)
+		}
+
 		// Exchange with provider
-		result, err := provider.Exchange(r.Context(), params)
+		ctx, span = traceProvider(r, "provider exchange", providerName)
+		result, err := provider.Exchange(ctx, params)
+		release()
+		span.SetError(err)
+		span.End()
 		if err != nil {
+			signInFailed(r, funnel, bus, "provider_failed", flowID, statePayload.ClientID, providerName)
+			slog.WarnContext(r.Context(), "callback: provider exchange failed", "error", err)
 			if errors.Is(err, domain.ErrMissingProviderParams) {
-				writeError(w, http.StatusBadRequest, "missing provider parameters")
+				redirectError(w, r, statePayload, http.StatusBadRequest, oauthServerError, "missing provider parameters")
 				return
 			}
-			writeError(w, http.StatusBadGateway, "provider exchange failed")
+			if errors.Is(err, domain.ErrNoGameAccount) {
+				redirectError(w, r, statePayload, http.StatusForbidden, oauthAccessDenied, "account has no game profile")
+				return
+			}
+			redirectError(w, r, statePayload, http.StatusBadGateway, oauthServerError, "provider exchange failed")
 			return
 		}
 
-		// Encrypt auth result as exchange code
-		code, err := codec.Encode(domain.ExchangePayload{
-			ClientID: statePayload.ClientID,
-			User:     result.User,
-		})
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, "failed to create exchange code")
+		if !statePayload.Raw {
+			result.User.Raw = nil
+		}
+		if !statePayload.Tokens {
+			result.Tokens = nil
+		}
+		factors := []string{providerName}
+		if refuseBanned(w, r, bans, funnel, bus, statePayload, result.User, flowID) {
+			return
+		}
+		result.User.Roles = rules.Apply(result.User)
+		sessionUser := result.User
+		sessionUser.Roles, sessionUser.Raw = slices.Clone(sessionUser.Roles), nil
+		if refuseByHooks(w, r, hooks, funnel, bus, statePayload, result, flowID) {
 			return
 		}
 
-		// Redirect back to client with exchange code
-		redirectURL, err := url.Parse(statePayload.RedirectURI)
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, "invalid redirect URI")
+		// Pause the flow for a second factor when the client asked for one
+		if statePayload.ACR == mfa.ACR2FA {
+			if mfaSvc == nil {
+				writePageError(w, r, http.StatusBadRequest, "second factor is not available", flowID, back)
+				return
+			}
+			token, err := mfaSvc.Seal(mfa.Pending{
+				ClientID:    statePayload.ClientID,
+				RedirectURI: statePayload.RedirectURI,
+				FlowID:      flowID,
+				User:        result.User,
+				SessionUser: &sessionUser,
+				Factors:     factors,
+				Scope:       statePayload.Scope,
+				Tokens:      result.Tokens,
+				OIDC:        statePayload.OIDC,
+				Device:      statePayload.Device,
+
+				CodeChallenge: statePayload.CodeChallenge,
+				AppState:      statePayload.AppState,
+			})
+			if err != nil {
+				writePageError(w, r, http.StatusInternalServerError, "failed to start second factor", flowID, back)
+				return
+			}
+			slog.InfoContext(r.Context(), "callback: waiting for second factor")
+			// Relative to /callback/{provider}, so that it stays under any
+			// BASE_PATH; http.Redirect would resolve it against the stripped path
+			w.Header().Set("Location", "../mfa/totp?"+url.Values{"t": {token}}.Encode())
+			w.WriteHeader(http.StatusFound)
 			return
 		}
-		q := redirectURL.Query()
-		q.Set("code", code)
-		redirectURL.RawQuery = q.Encode()
 
-		http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+		startSession(w, r, sessions, statePayload.ClientID, sessionUser, factors, flowID)
+		issueCode(w, r, codec, funnel, bus, domain.ExchangePayload{
+			ClientID: statePayload.ClientID,
+			FlowID:   flowID,
+			Factors:  factors,
+			Scope:    statePayload.Scope,
+			User:     result.User,
+			Tokens:   result.Tokens,
+			OIDC:     statePayload.OIDC,
+			Device:   statePayload.Device,
+
+			CodeChallenge: statePayload.CodeChallenge,
+			AppState:      statePayload.AppState,
+		}, statePayload.RedirectURI, providerName)
+	}
+}
+
+// redirectError sends the browser back to the client of the flow in payload
+// with an OAuth error code and description in place of an exchange code,
+// so that the app can tell the user and offer another try rather than
+// leaving them on our page. Flows for a device, which have no app page to
+// return to, get the error page with status instead.
+func redirectError(w http.ResponseWriter, r *http.Request, payload *domain.StatePayload, status int, code, description string) {
+	redirectURL, err := url.Parse(payload.RedirectURI)
+	if err != nil || payload.Device != "" {
+		writePageError(w, r, status, description, payload.FlowID, clientLink{})
+		return
+	}
+	q := redirectURL.Query()
+	q.Set("error", code)
+	q.Set("error_description", description)
+	if payload.OIDC != nil && payload.OIDC.State != "" {
+		q.Set("state", payload.OIDC.State)
+	}
+	if payload.AppState != "" {
+		q.Set("app_state", payload.AppState)
+	}
+	redirectURL.RawQuery = q.Encode()
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+// issueCode encrypts payload as an exchange code and redirects the browser
+// back to the client with it, along with the client's app_state. The code is
+// bound to redirectURI and the browser's IP address.
+func issueCode(w http.ResponseWriter, r *http.Request, codec *exchange.Codec, funnel *metrics.Funnel, bus *events.Bus,
+	payload domain.ExchangePayload, redirectURI, providerName string) {
+	payload.RedirectURI = redirectURI
+	payload.IPHash = hashIP(requestIP(r))
+	if ip := requestIP(r); ip.IsValid() {
+		payload.IP = ip.String()
+	}
+	code, err := sealCode(codec, payload)
+	if err != nil {
+		writePageError(w, r, http.StatusInternalServerError, "failed to create exchange code", payload.FlowID, flowLink(redirectURI, payload.Device))
+		return
+	}
+
+	// Redirect back to client with exchange code
+	redirectURL, err := url.Parse(redirectURI)
+	if err != nil {
+		writePageError(w, r, http.StatusInternalServerError, "invalid redirect URI", payload.FlowID, flowLink(redirectURI, payload.Device))
+		return
+	}
+	q := redirectURL.Query()
+	q.Set("code", code)
+	if payload.OIDC != nil && payload.OIDC.State != "" {
+		q.Set("state", payload.OIDC.State)
+	}
+	if payload.AppState != "" {
+		q.Set("app_state", payload.AppState)
+	}
+	redirectURL.RawQuery = q.Encode()
+
+	slog.InfoContext(r.Context(), "callback: exchange code issued")
+	funnel.Reached(metrics.StageCodeIssued, payload.ClientID, providerName)
+	bus.Publish(r.Context(), events.Event{Type: events.AuthSucceeded, FlowID: payload.FlowID, ClientID: payload.ClientID,
+		Provider: providerName, UserID: payload.User.ProviderID, Factors: payload.Factors})
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+// sealCode encrypts payload as an exchange code. User data outside the
+// granted scope is dropped here, so it never leaves the server.
+func sealCode(codec *exchange.Codec, payload domain.ExchangePayload) (string, error) {
+	if payload.Scope == "" {
+		payload.Scope = scope.Default
 	}
+	payload.User = scope.Filter(payload.User, payload.Scope)
+	return codec.Encode(payload)
 }
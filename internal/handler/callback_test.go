@@ -2,17 +2,26 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/url"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/BlackMission/centralauth/internal/auth"
 	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/events"
 	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/internal/roles"
 	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/store"
 	"github.com/BlackMission/centralauth/pkg/testutil"
 )
 
@@ -38,7 +47,7 @@ func setupCallback(provider *callbackStubProvider) (http.Handler, *state.Service
 	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /callback/{provider}", Callback(providers, stateSvc, codec))
+	mux.HandleFunc("GET /callback/{provider}", Callback(providers, stateSvc, codec, nil, nil, nil, nil, nil, nil, nil, nil))
 	return mux, stateSvc, codec
 }
 
@@ -97,6 +106,12 @@ func TestCallback_ValidFlow(t *testing.T) {
 	if payload.User.Username != "testuser" {
 		t.Errorf("expected username 'testuser', got %q", payload.User.Username)
 	}
+	if payload.RedirectURI != "https://example.com/callback" {
+		t.Errorf("expected the code bound to the redirect URI, got %q", payload.RedirectURI)
+	}
+	if payload.IPHash != hashIP(netip.MustParseAddr("192.0.2.1")) {
+		t.Errorf("expected the code bound to the browser's IP, got %q", payload.IPHash)
+	}
 }
 
 func TestCallback_InvalidState(t *testing.T) {
@@ -108,6 +123,36 @@ func TestCallback_InvalidState(t *testing.T) {
 	testutil.AssertStatus(t, rr, http.StatusBadRequest)
 }
 
+func TestCallback_FunnelLabels(t *testing.T) {
+	providers := auth.NewRegistry()
+	providers.Register(&callbackStubProvider{name: "discord"})
+	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	reg := metrics.NewRegistry()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /callback/{provider}", Callback(providers, stateSvc, codec, nil, metrics.NewFunnel(reg), nil, nil, nil, nil, nil, nil))
+
+	testutil.DoRequest(t, mux, http.MethodGet, "/callback/discord?state=invalid-state", nil)
+	testutil.DoRequest(t, mux, http.MethodGet, "/callback/made-up?state=invalid-state", nil)
+	stateToken, _ := stateSvc.Generate(domain.StatePayload{ClientID: "website", Provider: "discord"})
+	testutil.DoRequest(t, mux, http.MethodGet, "/callback/made-up?state="+url.QueryEscape(stateToken), nil)
+
+	out := testutil.DoRequest(t, reg.Handler(), http.MethodGet, "/metrics", nil).Body.String()
+	for _, want := range []string{
+		`centralauth_funnel_dropped_total{stage="callback_received",reason="state_invalid",client="unknown",provider="discord"} 1`,
+		`centralauth_funnel_dropped_total{stage="callback_received",reason="state_invalid",client="unknown",provider="unknown"} 1`,
+		`centralauth_funnel_total{stage="callback_received",client="website",provider="discord"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "made-up") {
+		t.Errorf("unregistered provider labels a series:\n%s", out)
+	}
+}
+
 func TestCallback_ExpiredState(t *testing.T) {
 	provider := &callbackStubProvider{
 		name:   "discord",
@@ -132,7 +177,7 @@ func TestCallback_ExpiredState(t *testing.T) {
 	stateSvc.SetNow(func() time.Time { return now.Add(6 * time.Minute) })
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /callback/{provider}", Callback(providers, stateSvc, codec))
+	mux.HandleFunc("GET /callback/{provider}", Callback(providers, stateSvc, codec, nil, nil, nil, nil, nil, nil, nil, nil))
 
 	rr := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodGet,
@@ -142,6 +187,22 @@ func TestCallback_ExpiredState(t *testing.T) {
 	testutil.AssertStatus(t, rr, http.StatusBadRequest)
 }
 
+func TestCallback_RevokedState(t *testing.T) {
+	provider := &callbackStubProvider{name: "discord"}
+	handler, stateSvc, _ := setupCallback(provider)
+
+	stateToken, _ := stateSvc.Generate(domain.StatePayload{
+		ClientID:    "website",
+		Provider:    "discord",
+		RedirectURI: "https://example.com/callback",
+	})
+	stateSvc.RevokeAll(context.Background())
+
+	rr := testutil.DoRequest(t, handler, http.MethodGet,
+		fmt.Sprintf("/callback/discord?code=auth-code&state=%s", url.QueryEscape(stateToken)), nil)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+}
+
 func TestCallback_ProviderExchangeFailure(t *testing.T) {
 	provider := &callbackStubProvider{
 		name: "discord",
@@ -157,7 +218,352 @@ func TestCallback_ProviderExchangeFailure(t *testing.T) {
 
 	rr := testutil.DoRequest(t, handler, http.MethodGet,
 		fmt.Sprintf("/callback/discord?code=auth-code&state=%s", url.QueryEscape(stateToken)), nil)
-	testutil.AssertStatus(t, rr, http.StatusBadGateway)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+
+	locURL, _ := url.Parse(rr.Header().Get("Location"))
+	if q := locURL.Query(); locURL.Host != "example.com" || q.Get("error") != "server_error" ||
+		q.Get("error_description") != "provider exchange failed" || q.Has("code") {
+		t.Errorf("Location = %s", locURL)
+	}
+}
+
+func TestCallback_AccessDenied(t *testing.T) {
+	provider := &callbackStubProvider{name: "discord", err: domain.ErrMissingProviderParams}
+	handler, stateSvc, _ := setupCallback(provider)
+
+	stateToken, _ := stateSvc.Generate(domain.StatePayload{
+		ClientID:    "website",
+		Provider:    "discord",
+		RedirectURI: "https://example.com/callback?lang=en",
+		OIDC:        &domain.OIDCRequest{State: "client-state"},
+		AppState:    "/servers/42",
+	})
+
+	rr := testutil.DoRequest(t, handler, http.MethodGet,
+		fmt.Sprintf("/callback/discord?error=access_denied&state=%s", url.QueryEscape(stateToken)), nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+
+	locURL, _ := url.Parse(rr.Header().Get("Location"))
+	q := locURL.Query()
+	if q.Get("error") != "access_denied" || q.Get("state") != "client-state" || q.Get("app_state") != "/servers/42" || q.Get("lang") != "en" {
+		t.Errorf("Location = %s", locURL)
+	}
+
+	// Any other error the provider reports is its failure, not the user's
+	rr = testutil.DoRequest(t, handler, http.MethodGet,
+		fmt.Sprintf("/callback/discord?error=invalid_scope&state=%s", url.QueryEscape(stateToken)), nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	locURL, _ = url.Parse(rr.Header().Get("Location"))
+	if got := locURL.Query().Get("error"); got != "server_error" {
+		t.Errorf("error = %q, want server_error", got)
+	}
+
+	// Without a valid state there is no client to send the user back to
+	rr = testutil.DoRequest(t, handler, http.MethodGet, "/callback/discord?error=access_denied&state=forged", nil)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestCallback_NoGameAccount(t *testing.T) {
+	provider := &callbackStubProvider{
+		name: "minecraft",
+		err:  fmt.Errorf("%w: account does not own Minecraft", domain.ErrNoGameAccount),
+	}
+	handler, stateSvc, _ := setupCallback(provider)
+
+	stateToken, _ := stateSvc.Generate(domain.StatePayload{
+		ClientID:    "website",
+		Provider:    "minecraft",
+		RedirectURI: "https://example.com/callback",
+	})
+
+	rr := testutil.DoRequest(t, handler, http.MethodGet,
+		fmt.Sprintf("/callback/minecraft?code=auth-code&state=%s", url.QueryEscape(stateToken)), nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+
+	locURL, _ := url.Parse(rr.Header().Get("Location"))
+	if q := locURL.Query(); q.Get("error") != "access_denied" || q.Get("error_description") != "account has no game profile" {
+		t.Errorf("Location = %s", locURL)
+	}
+}
+
+func TestCallback_PropagatesFlowID(t *testing.T) {
+	provider := &callbackStubProvider{
+		name:   "discord",
+		result: &domain.AuthResult{User: domain.UserInfo{ProviderName: "discord", ProviderID: "123"}},
+	}
+	handler, stateSvc, codec := setupCallback(provider)
+
+	stateToken, _ := stateSvc.Generate(domain.StatePayload{
+		ClientID:    "website",
+		Provider:    "discord",
+		RedirectURI: "https://example.com/callback",
+		FlowID:      "0123456789abcdef",
+	})
+
+	rr := testutil.DoRequest(t, handler, http.MethodGet,
+		fmt.Sprintf("/callback/discord?code=auth-code&state=%s", url.QueryEscape(stateToken)), nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+
+	locURL, _ := url.Parse(rr.Header().Get("Location"))
+	payload, err := codec.Decode(locURL.Query().Get("code"))
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if payload.FlowID != "0123456789abcdef" {
+		t.Errorf("FlowID = %q, want %q", payload.FlowID, "0123456789abcdef")
+	}
+}
+
+func TestCallback_StateUsedOnce(t *testing.T) {
+	provider := &callbackStubProvider{
+		name:   "discord",
+		result: &domain.AuthResult{User: domain.UserInfo{ProviderName: "discord", ProviderID: "123"}},
+	}
+	handler, stateSvc, _ := setupCallback(provider)
+	stateSvc.SetConsumedStore(store.NewMemory())
+
+	stateToken, _ := stateSvc.Generate(domain.StatePayload{
+		ClientID:    "website",
+		Provider:    "discord",
+		RedirectURI: "https://example.com/callback",
+	})
+	target := fmt.Sprintf("/callback/discord?code=auth-code&state=%s", url.QueryEscape(stateToken))
+
+	rr := testutil.DoRequest(t, handler, http.MethodGet, target, nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	rr = testutil.DoRequest(t, handler, http.MethodGet, target, nil)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	if !strings.Contains(rr.Body.String(), "already completed") {
+		t.Errorf("expected a replay error, got %s", rr.Body)
+	}
+}
+
+func TestCallback_ReturnsAppState(t *testing.T) {
+	provider := &callbackStubProvider{
+		name:   "discord",
+		result: &domain.AuthResult{User: domain.UserInfo{ProviderName: "discord", ProviderID: "123"}},
+	}
+	handler, stateSvc, codec := setupCallback(provider)
+
+	stateToken, _ := stateSvc.Generate(domain.StatePayload{
+		ClientID:    "website",
+		Provider:    "discord",
+		RedirectURI: "https://example.com/callback",
+		AppState:    "/servers/42?tab=bans",
+	})
+
+	rr := testutil.DoRequest(t, handler, http.MethodGet,
+		fmt.Sprintf("/callback/discord?code=auth-code&state=%s", url.QueryEscape(stateToken)), nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+
+	locURL, _ := url.Parse(rr.Header().Get("Location"))
+	if got := locURL.Query().Get("app_state"); got != "/servers/42?tab=bans" {
+		t.Errorf("app_state = %q", got)
+	}
+	if _, err := codec.Decode(locURL.Query().Get("code")); err != nil {
+		t.Errorf("Decode error: %v", err)
+	}
+}
+
+func TestCallback_WithholdsDataOutsideScope(t *testing.T) {
+	provider := &callbackStubProvider{
+		name: "discord",
+		result: &domain.AuthResult{User: domain.UserInfo{
+			ProviderName: "discord",
+			ProviderID:   "123",
+			Username:     "testuser",
+			Email:        "test@example.com",
+		}},
+	}
+	handler, stateSvc, codec := setupCallback(provider)
+
+	stateToken, _ := stateSvc.Generate(domain.StatePayload{
+		ClientID:    "website",
+		Provider:    "discord",
+		RedirectURI: "https://example.com/callback",
+		Scope:       "email",
+	})
+
+	rr := testutil.DoRequest(t, handler, http.MethodGet,
+		fmt.Sprintf("/callback/discord?code=auth-code&state=%s", url.QueryEscape(stateToken)), nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+
+	locURL, _ := url.Parse(rr.Header().Get("Location"))
+	payload, err := codec.Decode(locURL.Query().Get("code"))
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if payload.Scope != "email" {
+		t.Errorf("Scope = %q, want %q", payload.Scope, "email")
+	}
+	if payload.User.Username != "" || payload.User.Email != "test@example.com" {
+		t.Errorf("expected only the email scope in the code, got %+v", payload.User)
+	}
+}
+
+func TestCallback_RawOnlyForFlaggedClients(t *testing.T) {
+	for _, raw := range []bool{false, true} {
+		provider := &callbackStubProvider{
+			name: "discord",
+			result: &domain.AuthResult{User: domain.UserInfo{
+				ProviderName: "discord",
+				ProviderID:   "123",
+				Raw:          []byte(`{"id":"123","discriminator":"0"}`),
+			}},
+		}
+		handler, stateSvc, codec := setupCallback(provider)
+
+		stateToken, _ := stateSvc.Generate(domain.StatePayload{
+			ClientID:    "website",
+			Provider:    "discord",
+			RedirectURI: "https://example.com/callback",
+			Scope:       "profile email",
+			Raw:         raw,
+		})
+		rr := testutil.DoRequest(t, handler, http.MethodGet,
+			fmt.Sprintf("/callback/discord?code=auth-code&state=%s", url.QueryEscape(stateToken)), nil)
+		testutil.AssertStatus(t, rr, http.StatusFound)
+
+		locURL, _ := url.Parse(rr.Header().Get("Location"))
+		payload, err := codec.Decode(locURL.Query().Get("code"))
+		if err != nil {
+			t.Fatalf("Decode error: %v", err)
+		}
+		if got := payload.User.Raw != nil; got != raw {
+			t.Errorf("raw=%v: raw profile in code = %v", raw, got)
+		}
+	}
+}
+
+func TestCallback_TokensOnlyForFlaggedClients(t *testing.T) {
+	for _, tokens := range []bool{false, true} {
+		provider := &callbackStubProvider{
+			name: "discord",
+			result: &domain.AuthResult{
+				User:   domain.UserInfo{ProviderName: "discord", ProviderID: "123"},
+				Tokens: &domain.ProviderTokens{AccessToken: "access", RefreshToken: "refresh"},
+			},
+		}
+		handler, stateSvc, codec := setupCallback(provider)
+
+		stateToken, _ := stateSvc.Generate(domain.StatePayload{
+			ClientID:    "website",
+			Provider:    "discord",
+			RedirectURI: "https://example.com/callback",
+			Tokens:      tokens,
+		})
+		rr := testutil.DoRequest(t, handler, http.MethodGet,
+			fmt.Sprintf("/callback/discord?code=auth-code&state=%s", url.QueryEscape(stateToken)), nil)
+		testutil.AssertStatus(t, rr, http.StatusFound)
+
+		locURL, _ := url.Parse(rr.Header().Get("Location"))
+		payload, err := codec.Decode(locURL.Query().Get("code"))
+		if err != nil {
+			t.Fatalf("Decode error: %v", err)
+		}
+		if got := payload.Tokens != nil; got != tokens {
+			t.Errorf("tokens=%v: tokens in code = %v", tokens, got)
+		}
+	}
+}
+
+func TestCallback_GrantsRoles(t *testing.T) {
+	providers := auth.NewRegistry()
+	providers.Register(&callbackStubProvider{
+		name: "discord",
+		result: &domain.AuthResult{User: domain.UserInfo{
+			ProviderName: "discord",
+			ProviderID:   "123",
+			ProviderData: domain.NewDiscordData(domain.DiscordExtras{GuildRoles: []string{"555"}}),
+		}},
+	})
+	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	rules := roles.Rules{
+		{Role: "role:moderator", DiscordRole: "555"},
+		{Role: "role:admin", DiscordRole: "777"},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /callback/{provider}", Callback(providers, stateSvc, codec, nil, nil, nil, nil, nil, nil, rules, nil))
+
+	stateToken, _ := stateSvc.Generate(domain.StatePayload{
+		ClientID:    "website",
+		Provider:    "discord",
+		RedirectURI: "https://example.com/callback",
+	})
+	rr := testutil.DoRequest(t, mux, http.MethodGet,
+		fmt.Sprintf("/callback/discord?code=auth-code&state=%s", url.QueryEscape(stateToken)), nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+
+	locURL, _ := url.Parse(rr.Header().Get("Location"))
+	payload, err := codec.Decode(locURL.Query().Get("code"))
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if !slices.Equal(payload.User.Roles, []string{"role:moderator"}) {
+		t.Errorf("roles = %v, want [role:moderator]", payload.User.Roles)
+	}
+}
+
+func TestCallback_ErrorIncludesFlowID(t *testing.T) {
+	provider := &callbackStubProvider{
+		name:   "discord",
+		result: &domain.AuthResult{User: domain.UserInfo{ProviderName: "discord", ProviderID: "123"}},
+	}
+	handler, stateSvc, _ := setupCallback(provider)
+
+	// acr=2fa on a server without MFA fails on our side, after the provider
+	stateToken, _ := stateSvc.Generate(domain.StatePayload{
+		ClientID:    "website",
+		Provider:    "discord",
+		RedirectURI: "https://example.com/callback",
+		FlowID:      "0123456789abcdef",
+		ACR:         "2fa",
+	})
+
+	rr := testutil.DoRequest(t, handler, http.MethodGet,
+		fmt.Sprintf("/callback/discord?code=auth-code&state=%s", url.QueryEscape(stateToken)), nil)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+
+	var body map[string]string
+	testutil.ParseJSON(t, rr, &body)
+	if body["flow_id"] != "0123456789abcdef" {
+		t.Errorf("flow_id = %q, want %q", body["flow_id"], "0123456789abcdef")
+	}
+}
+
+func TestCallback_ErrorPage(t *testing.T) {
+	provider := &callbackStubProvider{
+		name:   "discord",
+		result: &domain.AuthResult{User: domain.UserInfo{ProviderName: "discord", ProviderID: "123"}},
+	}
+	handler, stateSvc, _ := setupCallback(provider)
+
+	stateToken, _ := stateSvc.Generate(domain.StatePayload{
+		ClientID:    "website",
+		Provider:    "discord",
+		RedirectURI: "https://example.com/auth/callback?from=login",
+		FlowID:      "0123456789abcdef",
+		ACR:         "2fa",
+	})
+	browser := map[string]string{"Accept": "text/html,application/xhtml+xml,*/*;q=0.8"}
+
+	rr := testutil.DoRequest(t, handler, http.MethodGet,
+		fmt.Sprintf("/callback/discord?code=auth-code&state=%s", url.QueryEscape(stateToken)), browser)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	body := rr.Body.String()
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(body, "second factor is not available") || !strings.Contains(body, "0123456789abcdef") ||
+		!strings.Contains(body, `href="https://example.com/"`) {
+		t.Errorf("error page = %s", body)
+	}
+
+	// Without a valid state there is no client to link back to
+	rr = testutil.DoRequest(t, handler, http.MethodGet, "/callback/discord?code=auth-code&state=forged", browser)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	if body := rr.Body.String(); !strings.Contains(body, "invalid state token") || strings.Contains(body, "href=") {
+		t.Errorf("error page = %s", body)
+	}
 }
 
 func TestCallback_MissingState(t *testing.T) {
@@ -168,3 +574,97 @@ func TestCallback_MissingState(t *testing.T) {
 		"/callback/discord?code=auth-code", nil)
 	testutil.AssertStatus(t, rr, http.StatusBadRequest)
 }
+
+func TestCallback_ProviderBusy(t *testing.T) {
+	provider := &callbackStubProvider{
+		name:   "discord",
+		result: &domain.AuthResult{User: domain.UserInfo{ProviderName: "discord", ProviderID: "123"}},
+	}
+	providers := auth.NewRegistry()
+	providers.Register(provider)
+
+	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	limiter := auth.NewLimiter(map[string]auth.Limit{
+		"discord": {MaxConcurrent: 1, MaxWait: time.Millisecond},
+	}, nil)
+
+	// Occupy the only slot
+	release, _ := limiter.Acquire(context.Background(), "discord")
+	defer release()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /callback/{provider}", Callback(providers, stateSvc, codec, limiter, nil, nil, nil, nil, nil, nil, nil))
+
+	stateToken, _ := stateSvc.Generate(domain.StatePayload{
+		ClientID:    "website",
+		Provider:    "discord",
+		RedirectURI: "https://example.com/callback",
+	})
+	rr := testutil.DoRequest(t, mux, http.MethodGet,
+		fmt.Sprintf("/callback/discord?code=auth-code&state=%s", url.QueryEscape(stateToken)), nil)
+
+	testutil.AssertStatus(t, rr, http.StatusServiceUnavailable)
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+}
+
+// eventRecorder is an events.Publisher that keeps what it is sent.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (p *eventRecorder) Publish(ctx context.Context, topic, key string, msg []byte) error {
+	var e events.Event
+	if err := json.Unmarshal(msg, &e); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, e)
+	return nil
+}
+
+func (p *eventRecorder) Close() error   { return nil }
+func (p *eventRecorder) String() string { return "recorder" }
+
+func TestCallback_PublishesEvents(t *testing.T) {
+	provider := &callbackStubProvider{
+		name:   "discord",
+		result: &domain.AuthResult{User: domain.UserInfo{ProviderName: "discord", ProviderID: "123"}},
+	}
+	providers := auth.NewRegistry()
+	providers.Register(provider)
+	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	rec := &eventRecorder{}
+	bus := events.NewBus(rec, "")
+	handler := http.NewServeMux()
+	handler.HandleFunc("GET /callback/{provider}", Callback(providers, stateSvc, codec, nil, nil, bus, nil, nil, nil, nil, nil))
+
+	for _, query := range []string{"code=auth-code", "error=access_denied"} {
+		stateToken, _ := stateSvc.Generate(domain.StatePayload{
+			ClientID:    "website",
+			Provider:    "discord",
+			RedirectURI: "https://example.com/callback",
+			FlowID:      "flow-1",
+		})
+		testutil.DoRequest(t, handler, http.MethodGet, "/callback/discord?"+query+"&state="+url.QueryEscape(stateToken), nil)
+	}
+	if err := bus.Close(context.Background()); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	if len(rec.events) != 2 {
+		t.Fatalf("expected 2 events, got %+v", rec.events)
+	}
+	if e := rec.events[0]; e.Type != events.AuthSucceeded || e.FlowID != "flow-1" || e.ClientID != "website" ||
+		e.Provider != "discord" || e.UserID != "123" || len(e.Factors) != 1 {
+		t.Errorf("first event = %+v", e)
+	}
+	if e := rec.events[1]; e.Type != events.AuthFailed || e.Reason != "consent_denied" || e.ClientID != "website" {
+		t.Errorf("second event = %+v", e)
+	}
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/BlackMission/centralauth/internal/ticket"
+)
+
+// captchaPassParam carries the pass the provider chooser gives a browser
+// that solved the client's CAPTCHA on to /auth/{provider}.
+const captchaPassParam = "captcha_pass"
+
+// issueCaptchaPass signs a pass that lets the browser r came from start a
+// flow for clientID back to redirectURI.
+func issueCaptchaPass(r *http.Request, passes *ticket.Signer, clientID, redirectURI string) (string, error) {
+	return passes.Sign(clientID, captchaPassAudience(r, clientID, redirectURI))
+}
+
+// validCaptchaPass reports whether r carries a live pass for clientID and
+// redirectURI, issued to the browser it came from.
+func validCaptchaPass(r *http.Request, passes *ticket.Signer, clientID, redirectURI string) bool {
+	pass := r.URL.Query().Get(captchaPassParam)
+	if passes == nil || pass == "" {
+		return false
+	}
+	_, err := passes.Verify(pass, captchaPassAudience(r, clientID, redirectURI))
+	return err == nil
+}
+
+func captchaPassAudience(r *http.Request, clientID, redirectURI string) string {
+	sum := sha256.Sum256([]byte(clientID + "\x00" + redirectURI + "\x00" + requestIP(r).String()))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/ticket"
+)
+
+func TestCaptchaPass(t *testing.T) {
+	passes := ticket.NewSigner([]byte("pass-key"), 0)
+	issued := httptest.NewRequest(http.MethodPost, "/auth", nil)
+	pass, err := issueCaptchaPass(issued, passes, "website", "https://example.com/callback")
+	if err != nil {
+		t.Fatalf("issueCaptchaPass error: %v", err)
+	}
+
+	request := func(remoteAddr, pass string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/auth/steam?"+url.Values{captchaPassParam: {pass}}.Encode(), nil)
+		r.RemoteAddr = remoteAddr
+		return r
+	}
+	if !validCaptchaPass(request(issued.RemoteAddr, pass), passes, "website", "https://example.com/callback") {
+		t.Error("expected the pass to be accepted")
+	}
+	if validCaptchaPass(request(issued.RemoteAddr, pass), passes, "forum", "https://example.com/callback") {
+		t.Error("expected a pass for another client to be refused")
+	}
+	if validCaptchaPass(request(issued.RemoteAddr, pass), passes, "website", "https://example.com/other") {
+		t.Error("expected a pass for another redirect_uri to be refused")
+	}
+	if validCaptchaPass(request("198.51.100.7:1234", pass), passes, "website", "https://example.com/callback") {
+		t.Error("expected a pass from another address to be refused")
+	}
+	if validCaptchaPass(request(issued.RemoteAddr, ""), passes, "website", "https://example.com/callback") {
+		t.Error("expected a missing pass to be refused")
+	}
+	if validCaptchaPass(request(issued.RemoteAddr, pass), nil, "website", "https://example.com/callback") {
+		t.Error("expected passes to be refused without a signer")
+	}
+}
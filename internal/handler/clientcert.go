@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"crypto/x509"
+	"net/http"
+	"slices"
+
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// peerCertificate returns the TLS client certificate r was made with, or nil
+// when it came without one or not over TLS.
+func peerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// presentsCert reports whether r was made with one of the client's
+// certificates, or the client has none configured.
+func presentsCert(clientApp *domain.ClientApp, r *http.Request) bool {
+	return len(clientApp.CertFingerprints) == 0 || hasCert(clientApp.CertFingerprints, r)
+}
+
+// hasCert reports whether r was made with a certificate whose fingerprint is
+// among fingerprints.
+func hasCert(fingerprints []string, r *http.Request) bool {
+	cert := peerCertificate(r)
+	return cert != nil && slices.Contains(fingerprints, client.CertFingerprint(cert))
+}
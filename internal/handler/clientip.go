@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// ClientIP wraps next so that handlers see the address each request came
+// from, trusting X-Forwarded-For only from the trusted proxies.
+func ClientIP(trusted []netip.Prefix, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey{}, clientIP(r, trusted))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestIP returns the address found by ClientIP, or the peer's address
+// when r didn't pass through it.
+func requestIP(r *http.Request) netip.Addr {
+	if ip, ok := r.Context().Value(clientIPKey{}).(netip.Addr); ok {
+		return ip
+	}
+	return clientIP(r, nil)
+}
+
+// clientIP returns the address r came from: the peer, or, when the peer is a
+// trusted proxy, the last address in X-Forwarded-For that isn't one.
+func clientIP(r *http.Request, trusted []netip.Prefix) netip.Addr {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	ip := peer.Addr().Unmap()
+	if !isTrusted(ip, trusted) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap()
+		if !isTrusted(ip, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+func isTrusted(ip netip.Addr, trusted []netip.Prefix) bool {
+	for _, p := range trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hashIP hashes ip for binding an exchange code to it, so that codes don't
+// carry addresses. IPv6 addresses are hashed by their /64, since a browser
+// may switch between temporary addresses in it.
+func hashIP(ip netip.Addr) string {
+	if !ip.IsValid() {
+		return ""
+	}
+	sum := sha256.Sum256([]byte("centralauth ip " + ipKey(ip)))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		remoteAddr, forwardedFor, want string
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		{"192.0.2.1:1234", "198.51.100.1", "192.0.2.1"},
+		{"10.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		{"10.0.0.1:1234", "203.0.113.9, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+		{"[::ffff:192.0.2.1]:1234", "", "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		}
+		if got := clientIP(req, trusted).String(); got != tt.want {
+			t.Errorf("clientIP(%s, %q) = %s, want %s", tt.remoteAddr, tt.forwardedFor, got, tt.want)
+		}
+	}
+}
+
+func TestClientIPMiddleware(t *testing.T) {
+	var got netip.Addr
+	h := ClientIP([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = requestIP(r)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got.String() != "198.51.100.1" {
+		t.Errorf("requestIP = %s, want the forwarded address", got)
+	}
+
+	// Without the middleware X-Forwarded-For isn't believed
+	if got := requestIP(req); got.String() != "10.0.0.1" {
+		t.Errorf("requestIP = %s, want the peer", got)
+	}
+}
+
+func TestHashIP(t *testing.T) {
+	a := hashIP(netip.MustParseAddr("192.0.2.1"))
+	if a == "" || a != hashIP(netip.MustParseAddr("192.0.2.1")) {
+		t.Fatalf("hashIP is not stable: %q", a)
+	}
+	if a == hashIP(netip.MustParseAddr("192.0.2.2")) {
+		t.Error("expected different addresses to hash differently")
+	}
+	if hashIP(netip.MustParseAddr("2001:db8::1")) != hashIP(netip.MustParseAddr("2001:db8::ffff")) {
+		t.Error("expected addresses in one IPv6 /64 to hash the same")
+	}
+	if hashIP(netip.Addr{}) != "" {
+		t.Error("expected no hash for an unknown address")
+	}
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/audit"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// DeleteClient handles DELETE /admin/clients/{id}. The client is soft-deleted
+// and can be brought back with RestoreClient until its tombstone is purged.
+func DeleteClient(clients *client.Registry, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientID := r.PathValue("id")
+		tombstone, err := clients.Delete(clientID, adminActor(r))
+		if err != nil {
+			if errors.Is(err, domain.ErrClientNotFound) {
+				writeError(w, http.StatusNotFound, "unknown client")
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "failed to delete client")
+			return
+		}
+
+		recordAdmin(auditLog, r, "client.delete", clientID)
+		writeJSON(w, http.StatusOK, tombstone)
+	}
+}
+
+// RestoreClient handles POST /admin/clients/{id}/restore.
+func RestoreClient(clients *client.Registry, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientID := r.PathValue("id")
+		if err := clients.Restore(clientID); err != nil {
+			writeError(w, http.StatusNotFound, "no restorable deletion for client")
+			return
+		}
+
+		recordAdmin(auditLog, r, "client.restore", clientID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DeletedClients handles GET /admin/clients/deleted.
+func DeletedClients(clients *client.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string][]client.Tombstone{"deleted": clients.Deleted()})
+	}
+}
+
+// DisableClient handles POST /admin/clients/{id}/disable. The client can't
+// start flows or use its API key until EnableClient, but keeps its settings.
+func DisableClient(clients *client.Registry, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientID := r.PathValue("id")
+		suspension, err := clients.Disable(clientID, adminActor(r))
+		if err != nil {
+			if errors.Is(err, domain.ErrClientNotFound) {
+				writeError(w, http.StatusNotFound, "unknown client")
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "failed to disable client")
+			return
+		}
+
+		recordAdmin(auditLog, r, "client.disable", clientID)
+		writeJSON(w, http.StatusOK, suspension)
+	}
+}
+
+// EnableClient handles POST /admin/clients/{id}/enable. It answers 409 when
+// the client's configuration itself disables it.
+func EnableClient(clients *client.Registry, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientID := r.PathValue("id")
+		err := clients.Enable(clientID)
+		switch {
+		case errors.Is(err, domain.ErrClientNotFound):
+			writeError(w, http.StatusNotFound, "unknown client")
+			return
+		case errors.Is(err, domain.ErrClientDisabled):
+			writeError(w, http.StatusConflict, "client is disabled in its configuration")
+			return
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, "failed to enable client")
+			return
+		}
+
+		recordAdmin(auditLog, r, "client.enable", clientID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DisabledClients handles GET /admin/clients/disabled, listing the clients
+// disabled through the admin API.
+func DisabledClients(clients *client.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string][]client.Suspension{"disabled": clients.Suspended()})
+	}
+}
+
+const maxRotateRequestBytes = 4 << 10
+
+type rotateKeyRequest struct {
+	APIKey      string `json:"api_key,omitempty"`
+	GracePeriod string `json:"grace_period,omitempty"`
+}
+
+// RotateClientKey handles POST /admin/clients/{id}/rotate-key. The body may
+// carry the new key, so every replica can be given the same one, and how long
+// the old key keeps working; both are optional.
+func RotateClientKey(clients *client.Registry, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req rotateKeyRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRotateRequestBytes)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		var grace time.Duration
+		if req.GracePeriod != "" {
+			d, err := time.ParseDuration(req.GracePeriod)
+			if err != nil || d <= 0 {
+				writeError(w, http.StatusBadRequest, "invalid grace_period")
+				return
+			}
+			grace = d
+		}
+		if req.APIKey == "" {
+			key, err := client.GenerateAPIKey()
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "failed to generate API key")
+				return
+			}
+			req.APIKey = key
+		}
+
+		clientID := r.PathValue("id")
+		rotation, err := clients.RotateKey(clientID, req.APIKey, adminActor(r), grace)
+		switch {
+		case errors.Is(err, domain.ErrClientNotFound):
+			writeError(w, http.StatusNotFound, "unknown client")
+			return
+		case errors.Is(err, domain.ErrAPIKeyInUse):
+			writeError(w, http.StatusConflict, "API key is already in use")
+			return
+		case errors.Is(err, domain.ErrPublicClient):
+			writeError(w, http.StatusConflict, "public clients have no API key")
+			return
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, "failed to rotate API key")
+			return
+		}
+
+		recordAdmin(auditLog, r, "client.rotate_key", clientID)
+		writeJSON(w, http.StatusOK, rotation)
+	}
+}
@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/audit"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func setupClientAdmin(t *testing.T) (http.Handler, *client.Registry, *audit.Log) {
+	t.Helper()
+	clients, err := client.NewRegistry([]domain.ClientApp{
+		{ID: "website", Name: "Website", APIKey: "web-key"},
+	})
+	if err != nil {
+		t.Fatalf("NewRegistry error: %v", err)
+	}
+	auditLog := audit.NewLog(0)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/clients/deleted", DeletedClients(clients))
+	mux.HandleFunc("DELETE /admin/clients/{id}", DeleteClient(clients, auditLog))
+	mux.HandleFunc("POST /admin/clients/{id}/restore", RestoreClient(clients, auditLog))
+	mux.HandleFunc("POST /admin/clients/{id}/rotate-key", RotateClientKey(clients, auditLog))
+	mux.HandleFunc("GET /admin/clients/disabled", DisabledClients(clients))
+	mux.HandleFunc("POST /admin/clients/{id}/disable", DisableClient(clients, auditLog))
+	mux.HandleFunc("POST /admin/clients/{id}/enable", EnableClient(clients, auditLog))
+	return mux, clients, auditLog
+}
+
+func TestDeleteClient(t *testing.T) {
+	mux, clients, auditLog := setupClientAdmin(t)
+
+	rr := testutil.DoRequest(t, mux, http.MethodDelete, "/admin/clients/website",
+		map[string]string{AdminActorHeader: "alice"})
+	testutil.AssertStatus(t, rr, http.StatusOK)
+
+	var tomb client.Tombstone
+	testutil.ParseJSON(t, rr, &tomb)
+	if tomb.ClientID != "website" || tomb.DeletedBy != "alice" {
+		t.Errorf("unexpected tombstone: %+v", tomb)
+	}
+	if _, err := clients.Get("website"); err == nil {
+		t.Error("expected client to be deleted")
+	}
+
+	events := auditLog.Events()
+	if len(events) != 1 || events[0].Action != "client.delete" || events[0].Actor != "alice" {
+		t.Errorf("unexpected audit events: %+v", events)
+	}
+}
+
+func TestDeleteClient_Unknown(t *testing.T) {
+	mux, _, auditLog := setupClientAdmin(t)
+
+	rr := testutil.DoRequest(t, mux, http.MethodDelete, "/admin/clients/nope", nil)
+	testutil.AssertStatus(t, rr, http.StatusNotFound)
+	if len(auditLog.Events()) != 0 {
+		t.Error("expected no audit entry for a failed delete")
+	}
+}
+
+func TestRestoreClient(t *testing.T) {
+	mux, clients, auditLog := setupClientAdmin(t)
+
+	testutil.DoRequest(t, mux, http.MethodDelete, "/admin/clients/website", nil)
+
+	rr := testutil.DoRequest(t, mux, http.MethodGet, "/admin/clients/deleted", nil)
+	var listed map[string][]client.Tombstone
+	testutil.ParseJSON(t, rr, &listed)
+	if len(listed["deleted"]) != 1 {
+		t.Fatalf("expected 1 deleted client, got %+v", listed)
+	}
+
+	rr = testutil.DoRequest(t, mux, http.MethodPost, "/admin/clients/website/restore", nil)
+	testutil.AssertStatus(t, rr, http.StatusNoContent)
+	if _, err := clients.Get("website"); err != nil {
+		t.Errorf("expected client to be restored, got %v", err)
+	}
+
+	events := auditLog.Events()
+	if len(events) != 2 || events[1].Action != "client.restore" || events[1].Actor != "admin" {
+		t.Errorf("unexpected audit events: %+v", events)
+	}
+
+	rr = testutil.DoRequest(t, mux, http.MethodPost, "/admin/clients/website/restore", nil)
+	testutil.AssertStatus(t, rr, http.StatusNotFound)
+}
+
+func TestDisableAndEnableClient(t *testing.T) {
+	mux, clients, auditLog := setupClientAdmin(t)
+
+	rr := testutil.DoRequest(t, mux, http.MethodPost, "/admin/clients/website/disable",
+		map[string]string{AdminActorHeader: "alice"})
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	var suspension client.Suspension
+	testutil.ParseJSON(t, rr, &suspension)
+	if suspension.ClientID != "website" || suspension.DisabledBy != "alice" {
+		t.Errorf("unexpected suspension: %+v", suspension)
+	}
+	if _, err := clients.GetByAPIKey("web-key"); !errors.Is(err, domain.ErrClientDisabled) {
+		t.Errorf("expected the client to be disabled, got %v", err)
+	}
+
+	rr = testutil.DoRequest(t, mux, http.MethodGet, "/admin/clients/disabled", nil)
+	var listed map[string][]client.Suspension
+	testutil.ParseJSON(t, rr, &listed)
+	if len(listed["disabled"]) != 1 {
+		t.Fatalf("expected 1 disabled client, got %+v", listed)
+	}
+
+	rr = testutil.DoRequest(t, mux, http.MethodPost, "/admin/clients/website/enable", nil)
+	testutil.AssertStatus(t, rr, http.StatusNoContent)
+	if _, err := clients.GetByAPIKey("web-key"); err != nil {
+		t.Errorf("expected the client to be enabled, got %v", err)
+	}
+
+	events := auditLog.Events()
+	if len(events) != 2 || events[0].Action != "client.disable" || events[1].Action != "client.enable" {
+		t.Errorf("unexpected audit events: %+v", events)
+	}
+}
+
+func TestDisableAndEnableClient_Errors(t *testing.T) {
+	mux, clients, _ := setupClientAdmin(t)
+
+	testutil.AssertStatus(t, testutil.DoRequest(t, mux, http.MethodPost, "/admin/clients/nope/disable", nil), http.StatusNotFound)
+	testutil.AssertStatus(t, testutil.DoRequest(t, mux, http.MethodPost, "/admin/clients/nope/enable", nil), http.StatusNotFound)
+
+	clients.Replace([]domain.ClientApp{{ID: "website", APIKey: "web-key", Disabled: true}})
+	testutil.AssertStatus(t, testutil.DoRequest(t, mux, http.MethodPost, "/admin/clients/website/enable", nil), http.StatusConflict)
+}
+
+func TestRotateClientKey(t *testing.T) {
+	mux, clients, auditLog := setupClientAdmin(t)
+
+	rr := testutil.DoRequest(t, mux, http.MethodPost, "/admin/clients/website/rotate-key", nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+
+	var rot client.Rotation
+	testutil.ParseJSON(t, rr, &rot)
+	if rot.ClientID != "website" || rot.APIKey == "" {
+		t.Fatalf("unexpected rotation: %+v", rot)
+	}
+	if d := rot.GraceUntil.Sub(rot.RotatedAt); d != client.DefaultGracePeriod {
+		t.Errorf("expected the default grace period, got %v", d)
+	}
+	if _, err := clients.GetByAPIKey(rot.APIKey); err != nil {
+		t.Errorf("expected the new key to work, got %v", err)
+	}
+	if _, err := clients.GetByAPIKey("web-key"); err != nil {
+		t.Errorf("expected the old key to work during the grace period, got %v", err)
+	}
+
+	events := auditLog.Events()
+	if len(events) != 1 || events[0].Action != "client.rotate_key" {
+		t.Errorf("unexpected audit events: %+v", events)
+	}
+}
+
+func TestRotateClientKey_GivenKey(t *testing.T) {
+	mux, clients, _ := setupClientAdmin(t)
+
+	rotate := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/clients/website/rotate-key", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := rotate(`{"api_key":"web-key-2","grace_period":"1h"}`)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	if c, err := clients.GetByAPIKey("web-key-2"); err != nil || c.ID != "website" {
+		t.Errorf("expected the given key to work, got %v", err)
+	}
+
+	testutil.AssertStatus(t, rotate(`{"api_key":"web-key"}`), http.StatusConflict)
+	testutil.AssertStatus(t, rotate(`{"grace_period":"forever"}`), http.StatusBadRequest)
+	testutil.AssertStatus(t, rotate(`not json`), http.StatusBadRequest)
+
+	rr = testutil.DoRequest(t, mux, http.MethodPost, "/admin/clients/nope/rotate-key", nil)
+	testutil.AssertStatus(t, rr, http.StatusNotFound)
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+type runtimeResponse struct {
+	Goroutines    int          `json:"goroutines"`
+	GOMAXPROCS    int          `json:"gomaxprocs"`
+	UptimeSeconds float64      `json:"uptime_seconds"`
+	Memory        memoryStats  `json:"memory"`
+	GC            gcStats      `json:"gc"`
+	Build         buildSummary `json:"build"`
+}
+
+type memoryStats struct {
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	HeapInuseBytes uint64 `json:"heap_inuse_bytes"`
+	HeapObjects    uint64 `json:"heap_objects"`
+	SysBytes       uint64 `json:"sys_bytes"`
+}
+
+type gcStats struct {
+	Cycles            uint32     `json:"cycles"`
+	LastRun           *time.Time `json:"last_run,omitempty"`
+	LastPauseSeconds  float64    `json:"last_pause_seconds"`
+	TotalPauseSeconds float64    `json:"total_pause_seconds"`
+	CPUFraction       float64    `json:"cpu_fraction"`
+	NextGCBytes       uint64     `json:"next_gc_bytes"`
+}
+
+type buildSummary struct {
+	GoVersion string            `json:"go_version"`
+	Module    string            `json:"module,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	Settings  map[string]string `json:"settings,omitempty"` // VCS revision, build flags
+}
+
+// RuntimeStats handles GET /debug/runtime. It reports the goroutine count,
+// heap and GC statistics, and how the binary was built, for looking into a
+// latency spike without attaching a profiler.
+func RuntimeStats(started time.Time) http.HandlerFunc {
+	build := buildSummary{GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		build.Module, build.Version = info.Main.Path, info.Main.Version
+		build.Settings = make(map[string]string, len(info.Settings))
+		for _, s := range info.Settings {
+			build.Settings[s.Key] = s.Value
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		gc := gcStats{
+			Cycles:            m.NumGC,
+			TotalPauseSeconds: time.Duration(m.PauseTotalNs).Seconds(),
+			CPUFraction:       m.GCCPUFraction,
+			NextGCBytes:       m.NextGC,
+		}
+		if m.NumGC > 0 {
+			last := time.Unix(0, int64(m.LastGC)).UTC()
+			gc.LastRun = &last
+			gc.LastPauseSeconds = time.Duration(m.PauseNs[(m.NumGC+255)%256]).Seconds()
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, runtimeResponse{
+			Goroutines:    runtime.NumGoroutine(),
+			GOMAXPROCS:    runtime.GOMAXPROCS(0),
+			UptimeSeconds: time.Since(started).Seconds(),
+			Memory: memoryStats{
+				HeapAllocBytes: m.HeapAlloc,
+				HeapInuseBytes: m.HeapInuse,
+				HeapObjects:    m.HeapObjects,
+				SysBytes:       m.Sys,
+			},
+			GC:    gc,
+			Build: build,
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestRuntimeStats(t *testing.T) {
+	runtime.GC()
+	rr := testutil.DoRequest(t, RuntimeStats(time.Now().Add(-time.Minute)), http.MethodGet, "/debug/runtime", nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+
+	var got runtimeResponse
+	testutil.ParseJSON(t, rr, &got)
+	if got.Goroutines < 1 || got.GOMAXPROCS < 1 || got.UptimeSeconds < 60 {
+		t.Errorf("runtime = %+v", got)
+	}
+	if got.Memory.HeapAllocBytes == 0 || got.GC.Cycles == 0 || got.GC.LastRun == nil {
+		t.Errorf("memory = %+v, gc = %+v", got.Memory, got.GC)
+	}
+	if got.Build.GoVersion != runtime.Version() {
+		t.Errorf("go version = %q", got.Build.GoVersion)
+	}
+}
@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/captcha"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/device"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/events"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/hosted"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/internal/pages"
+	"github.com/BlackMission/centralauth/internal/scope"
+	"github.com/BlackMission/centralauth/internal/state"
+)
+
+// DeviceCodeGrantType is the grant_type a device polls /token with.
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// DeviceCode handles POST /device/code, the device authorization endpoint
+// (RFC 8628). A client authenticated as at /token starts a grant for one of
+// its devices, which shows the user code and verification URI to the user
+// and polls /token until the user has signed in at /device.
+func DeviceCode(clients *client.Registry, devices *device.Service, publicURL string) http.HandlerFunc {
+	verificationURI := strings.TrimSuffix(publicURL, "/") + "/device"
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		r.Body = http.MaxBytesReader(w, r.Body, maxTokenRequestBytes)
+		if err := r.ParseForm(); err != nil {
+			writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "invalid request body")
+			return
+		}
+		clientApp, ok := authenticateOAuthClient(w, r, clients)
+		if !ok {
+			return
+		}
+
+		grant, err := devices.Start(r.Context(), clientApp.ID, knownScopes(strings.Fields(r.PostForm.Get("scope"))))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "device: starting grant failed", "client_id", clientApp.ID, "error", err)
+			writeOAuthError(w, http.StatusServiceUnavailable, oauthTemporarilyUnavailable, "device sign-in is unavailable, please try again")
+			return
+		}
+		writeJSON(w, http.StatusOK, deviceAuthorizationResponse{
+			DeviceCode:              grant.DeviceCode,
+			UserCode:                grant.UserCode,
+			VerificationURI:         verificationURI,
+			VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {grant.UserCode}}.Encode(),
+			ExpiresIn:               int64(grant.ExpiresIn / time.Second),
+			Interval:                int64(grant.Interval / time.Second),
+		})
+	}
+}
+
+// DevicePage handles GET /device, where the user enters the code their
+// device shows and confirms which app is asking before signing in, answering
+// a CAPTCHA if the app requires one.
+func DevicePage(clients *client.Registry, providers *auth.Registry, devices *device.Service, verifier *captcha.Verifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userCode := r.URL.Query().Get("user_code")
+		if userCode == "" {
+			pages.Render(w, http.StatusOK, "device.html", pages.Device{})
+			return
+		}
+		_, clientApp, ok := lookupDevice(w, r, clients, devices, userCode)
+		if !ok {
+			return
+		}
+		renderDeviceConfirm(w, http.StatusOK, clientApp, providers, verifier, userCode, "")
+	}
+}
+
+// renderDeviceConfirm renders the page asking the user to confirm the
+// device of clientApp's showing userCode.
+func renderDeviceConfirm(w http.ResponseWriter, status int, clientApp *domain.ClientApp, providers *auth.Registry, verifier *captcha.Verifier, userCode, msg string) {
+	name := clientApp.Name
+	if name == "" {
+		name = clientApp.ID
+	}
+	pages.Render(w, status, "device.html", pages.Device{
+		UserCode:  userCode,
+		Error:     msg,
+		Client:    name,
+		Providers: clientProviders(clientApp, providers),
+		Captcha:   hosted.Widget(verifier, clientApp.RequireCaptcha),
+	})
+}
+
+// DeviceStart handles POST /device, the user's answer on the confirmation
+// page: a provider to sign in with, or deny to refuse the device. Signing
+// in runs the usual flow, which ends at /device/complete, once any CAPTCHA
+// the client requires is answered.
+func DeviceStart(clients *client.Registry, providers *auth.Registry, stateService *state.Service, devices *device.Service, funnel *metrics.Funnel, publicURL string,
+	verifier *captcha.Verifier) http.HandlerFunc {
+	completeURL := strings.TrimSuffix(publicURL, "/") + "/device/complete"
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The confirmation is what stops another site from approving its
+		// own device with the user's sign-in, so it must come from our page
+		if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" {
+			writeError(w, http.StatusForbidden, "cross-site request")
+			return
+		}
+		grant, clientApp, ok := lookupDevice(w, r, clients, devices, r.PostFormValue("user_code"))
+		if !ok {
+			return
+		}
+
+		if r.PostFormValue("deny") != "" {
+			if err := devices.Deny(r.Context(), grant.ID); err != nil {
+				slog.ErrorContext(r.Context(), "device: denying grant failed", "client_id", clientApp.ID, "error", err)
+			}
+			pages.Render(w, http.StatusOK, "device.html", pages.Device{Message: "The device was not signed in. You can close this page."})
+			return
+		}
+		if err := hosted.VerifyCaptcha(r, verifier, clientApp.RequireCaptcha, clientApp.ID); err != nil {
+			renderDeviceConfirm(w, http.StatusBadRequest, clientApp, providers, verifier, r.PostFormValue("user_code"), "Please complete the CAPTCHA.")
+			return
+		}
+		providerName := r.PostFormValue("provider")
+		if !slices.Contains(clientProviders(clientApp, providers), providerName) {
+			writeError(w, http.StatusBadRequest, "provider not allowed for this client")
+			return
+		}
+		provider, _ := providers.Get(providerName)
+		r = logWith(r, "client_id", clientApp.ID, "provider", providerName)
+		startFlow(w, r, stateService, provider, funnel, domain.StatePayload{
+			ClientID:    clientApp.ID,
+			Provider:    providerName,
+			RedirectURI: completeURL + "?" + url.Values{"client_id": {clientApp.ID}}.Encode(),
+			Scope:       grant.Scope,
+			Device:      grant.ID,
+		})
+	}
+}
+
+// DeviceComplete handles GET /device/complete, where a flow started at
+// /device delivers its code. The code approves the device's grant, so that
+// its next poll of /token gets the sign-in.
+func DeviceComplete(codec *exchange.Codec, devices *device.Service, funnel *metrics.Funnel, bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		payload, err := codec.DecodeFor(q.Get("code"), q.Get("client_id"))
+		if err != nil || payload.Device == "" {
+			writeError(w, http.StatusBadRequest, "invalid exchange code")
+			return
+		}
+		granted := payload.Scope
+		if granted == "" {
+			granted = scope.Default
+		}
+		err = devices.Approve(r.Context(), payload.Device, domain.AuthResult{
+			User:    scope.Filter(payload.User, granted),
+			Factors: payload.Factors,
+			Scope:   granted,
+		})
+		switch {
+		case errors.Is(err, domain.ErrDeviceCodeUsed):
+			pages.Render(w, http.StatusOK, "device.html", pages.Device{Message: "This device has already been signed in. You can close this page."})
+			return
+		case errors.Is(err, domain.ErrInvalidDeviceCode), errors.Is(err, domain.ErrExpiredDeviceCode):
+			pages.Render(w, http.StatusBadRequest, "device.html", pages.Device{Error: "The code has expired. Start again on your device."})
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "device: approving grant failed", "flow_id", payload.FlowID, "error", err)
+			pages.Render(w, http.StatusServiceUnavailable, "unavailable.html", pages.Unavailable{
+				Title:   "Sign-in unavailable",
+				Message: "Device sign-in is unavailable right now. Please try again in a minute.",
+			})
+			return
+		}
+		slog.InfoContext(r.Context(), "device: device approved",
+			"flow_id", payload.FlowID, "client_id", payload.ClientID, "provider", payload.User.ProviderName)
+		funnel.Reached(metrics.StageCodeRedeemed, payload.ClientID, payload.User.ProviderName)
+		bus.Publish(r.Context(), events.Event{Type: events.ClientExchange, FlowID: payload.FlowID, ClientID: payload.ClientID,
+			Provider: payload.User.ProviderName, UserID: payload.User.ProviderID})
+		pages.Render(w, http.StatusOK, "device.html", pages.Device{Message: "You're signed in. You can close this page and return to your device."})
+	}
+}
+
+// lookupDevice resolves a user code to its grant and client, rendering the
+// code entry page again with an error if it can't.
+func lookupDevice(w http.ResponseWriter, r *http.Request, clients *client.Registry, devices *device.Service, userCode string) (device.Grant, *domain.ClientApp, bool) {
+	grant, err := devices.Lookup(r.Context(), userCode)
+	if err != nil && !errors.Is(err, domain.ErrInvalidUserCode) {
+		slog.ErrorContext(r.Context(), "device: looking up user code failed", "error", err)
+		pages.Render(w, http.StatusServiceUnavailable, "unavailable.html", pages.Unavailable{
+			Title:   "Sign-in unavailable",
+			Message: "Device sign-in is unavailable right now. Please try again in a minute.",
+		})
+		return device.Grant{}, nil, false
+	}
+	var clientApp *domain.ClientApp
+	if err == nil {
+		clientApp, err = clients.Get(grant.ClientID)
+	}
+	if err != nil {
+		pages.Render(w, http.StatusBadRequest, "device.html", pages.Device{UserCode: userCode, Error: "That code is not valid or has expired."})
+		return device.Grant{}, nil, false
+	}
+	return grant, clientApp, true
+}
+
+// clientProviders lists the registered providers the user can sign in to
+// clientApp with.
+func clientProviders(clientApp *domain.ClientApp, providers *auth.Registry) []string {
+	var names []string
+	for _, name := range clientApp.AllowedProviders {
+		if _, err := providers.Get(name); err == nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
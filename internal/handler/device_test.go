@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/device"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/store"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func setupDevice(t *testing.T) (http.Handler, *exchange.Codec, *state.Service, *time.Time) {
+	t.Helper()
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "gameserver", Name: "Game Server", APIKey: "server-secret", AllowedProviders: []string{"discord", "steam"}},
+	})
+	providers := auth.NewRegistry()
+	providers.Register(&stubProvider{name: "discord", authURL: "https://discord.com/oauth2/authorize"})
+	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	signer, _ := idtoken.NewSigner(testutil.SigningKeyPEM(t))
+	ids := idtoken.NewIssuer(signer, "https://auth.example.com", 0)
+
+	now := time.Now()
+	mem := store.NewMemory()
+	mem.SetNow(func() time.Time { return now })
+	devices := device.New(mem, 0, 0)
+	devices.SetNow(func() time.Time { return now })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /device/code", DeviceCode(clients, devices, "https://auth.example.com"))
+	mux.HandleFunc("GET /device", DevicePage(clients, providers, devices, nil))
+	mux.HandleFunc("POST /device", DeviceStart(clients, providers, stateSvc, devices, nil, "https://auth.example.com", nil))
+	mux.HandleFunc("GET /device/complete", DeviceComplete(codec, devices, nil, nil))
+	mux.HandleFunc("POST /token", Token(clients, codec, nil, ids, nil, devices, nil, nil, nil, nil))
+	mux.HandleFunc("GET /exchange", Exchange(clients, codec, nil, nil, nil, nil, nil, nil, nil))
+	return mux, codec, stateSvc, &now
+}
+
+func startDevice(t *testing.T, h http.Handler) deviceAuthorizationResponse {
+	t.Helper()
+	rr := postForm(t, h, "/device/code", url.Values{"scope": {"profile"}}, "gameserver", "server-secret")
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	var resp deviceAuthorizationResponse
+	testutil.ParseJSON(t, rr, &resp)
+	return resp
+}
+
+func pollDevice(t *testing.T, h http.Handler, deviceCode string) *httptest.ResponseRecorder {
+	t.Helper()
+	return postForm(t, h, "/token", url.Values{"grant_type": {DeviceCodeGrantType}, "device_code": {deviceCode}}, "gameserver", "server-secret")
+}
+
+func confirmDevice(t *testing.T, h http.Handler, form url.Values, site string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/device", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Sec-Fetch-Site", site)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func oauthErrorOf(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp oauthError
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return resp.Error
+}
+
+func TestDevice_Flow(t *testing.T) {
+	h, codec, stateSvc, now := setupDevice(t)
+	grant := startDevice(t, h)
+	if grant.VerificationURI != "https://auth.example.com/device" || grant.Interval != 5 || grant.ExpiresIn != 600 ||
+		!strings.HasSuffix(grant.VerificationURIComplete, "?user_code="+grant.UserCode) {
+		t.Errorf("grant = %+v", grant)
+	}
+	if rr := pollDevice(t, h, grant.DeviceCode); oauthErrorOf(t, rr) != "authorization_pending" {
+		t.Errorf("first poll = %s", rr.Body)
+	}
+	if rr := pollDevice(t, h, grant.DeviceCode); oauthErrorOf(t, rr) != "slow_down" {
+		t.Errorf("early poll = %s", rr.Body)
+	}
+
+	// The user enters the code and confirms; only registered providers are offered
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/device?user_code="+grant.UserCode, nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	if body := rr.Body.String(); !strings.Contains(body, "Game Server") || !strings.Contains(body, `value="discord"`) || strings.Contains(body, `value="steam"`) {
+		t.Errorf("confirmation page = %s", body)
+	}
+	rr = confirmDevice(t, h, url.Values{"user_code": {grant.UserCode}, "provider": {"discord"}}, "same-origin")
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	payload, err := stateSvc.Validate(loc.Query().Get("state"))
+	if err != nil || payload.Device == "" || payload.RedirectURI != "https://auth.example.com/device/complete?client_id=gameserver" {
+		t.Fatalf("state = %+v, %v", payload, err)
+	}
+
+	// The callback's code, delivered to /device/complete, approves the device
+	code, _ := codec.Encode(domain.ExchangePayload{
+		ClientID: payload.ClientID,
+		Scope:    payload.Scope,
+		User:     domain.UserInfo{ProviderName: "discord", ProviderID: "123", Username: "alice", Email: "alice@example.com"},
+		Device:   payload.Device,
+	})
+	rr = testutil.DoRequest(t, h, http.MethodGet, "/exchange?code="+url.QueryEscape(code), map[string]string{"Authorization": "Bearer server-secret"})
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	rr = testutil.DoRequest(t, h, http.MethodGet, "/device/complete?client_id=gameserver&code="+url.QueryEscape(code), nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+
+	*now = now.Add(5 * time.Second)
+	rr = pollDevice(t, h, grant.DeviceCode)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	var tokens tokenResponse
+	testutil.ParseJSON(t, rr, &tokens)
+	if tokens.IDToken == "" || tokens.AccessToken == "" || tokens.Scope != "openid profile" {
+		t.Errorf("tokens = %+v", tokens)
+	}
+
+	*now = now.Add(5 * time.Second)
+	if rr := pollDevice(t, h, grant.DeviceCode); oauthErrorOf(t, rr) != "invalid_grant" {
+		t.Errorf("poll after redemption = %s", rr.Body)
+	}
+}
+
+func TestDevice_Deny(t *testing.T) {
+	h, _, _, _ := setupDevice(t)
+	grant := startDevice(t, h)
+
+	rr := confirmDevice(t, h, url.Values{"user_code": {grant.UserCode}, "deny": {"1"}}, "same-origin")
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	if rr := pollDevice(t, h, grant.DeviceCode); oauthErrorOf(t, rr) != "access_denied" {
+		t.Errorf("poll = %s", rr.Body)
+	}
+}
+
+func TestDevice_Rejected(t *testing.T) {
+	h, _, _, _ := setupDevice(t)
+	grant := startDevice(t, h)
+
+	rr := confirmDevice(t, h, url.Values{"user_code": {grant.UserCode}, "provider": {"discord"}}, "cross-site")
+	testutil.AssertStatus(t, rr, http.StatusForbidden)
+	rr = confirmDevice(t, h, url.Values{"user_code": {grant.UserCode}, "provider": {"steam"}}, "same-origin")
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	rr = testutil.DoRequest(t, h, http.MethodGet, "/device?user_code=BCDF-GHJK", nil)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	if !strings.Contains(rr.Body.String(), "not valid") {
+		t.Errorf("expected the code entry page with an error, got %s", rr.Body)
+	}
+	rr = postForm(t, h, "/device/code", url.Values{}, "gameserver", "wrong-secret")
+	testutil.AssertStatus(t, rr, http.StatusUnauthorized)
+}
+
+func TestDevice_Captcha(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "gameserver", Name: "Game Server", APIKey: "server-secret", RequireCaptcha: true, AllowedProviders: []string{"discord"}},
+	})
+	providers := auth.NewRegistry()
+	providers.Register(&stubProvider{name: "discord", authURL: "https://discord.com/oauth2/authorize"})
+	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
+	devices := device.New(store.NewMemory(), 0, 0)
+	verifier := newTestCaptcha(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /device/code", DeviceCode(clients, devices, "https://auth.example.com"))
+	mux.HandleFunc("GET /device", DevicePage(clients, providers, devices, verifier))
+	mux.HandleFunc("POST /device", DeviceStart(clients, providers, stateSvc, devices, nil, "https://auth.example.com", verifier))
+	grant := startDevice(t, mux)
+
+	rr := testutil.DoRequest(t, mux, http.MethodGet, "/device?user_code="+grant.UserCode, nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	if !strings.Contains(rr.Body.String(), `data-sitekey="site-key"`) {
+		t.Errorf("expected the CAPTCHA widget, got %s", rr.Body)
+	}
+
+	rr = confirmDevice(t, mux, url.Values{"user_code": {grant.UserCode}, "provider": {"discord"}}, "same-origin")
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	if body := rr.Body.String(); !strings.Contains(body, "Please complete the CAPTCHA.") || !strings.Contains(body, grant.UserCode) {
+		t.Errorf("expected the confirmation page again with an error, got %s", body)
+	}
+
+	rr = confirmDevice(t, mux, url.Values{"user_code": {grant.UserCode}, "provider": {"discord"}, "cf-turnstile-response": {"good-token"}}, "same-origin")
+	testutil.AssertStatus(t, rr, http.StatusFound)
+
+	// Cancelling needs no CAPTCHA
+	rr = confirmDevice(t, mux, url.Values{"user_code": {startDevice(t, mux).UserCode}, "deny": {"1"}}, "same-origin")
+	testutil.AssertStatus(t, rr, http.StatusOK)
+}
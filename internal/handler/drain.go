@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/BlackMission/centralauth/internal/audit"
+	"github.com/BlackMission/centralauth/internal/drain"
+	"github.com/BlackMission/centralauth/internal/pages"
+)
+
+// drainRetryAfter is the Retry-After hint, in seconds, sent while draining.
+const drainRetryAfter = 30
+
+// Drainable wraps an auth-initiating handler so that it serves a retry-later
+// page instead of starting a new flow while the switch is draining.
+func Drainable(sw *drain.Switch, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if sw.Draining() {
+			w.Header().Set("Retry-After", strconv.Itoa(drainRetryAfter))
+			pages.Render(w, http.StatusServiceUnavailable, "unavailable.html", pages.Unavailable{
+				Title:   "Sign-in temporarily unavailable",
+				Message: "We're performing maintenance. Please try again in a minute.",
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// DrainStatus handles GET /admin/drain.
+func DrainStatus(sw *drain.Switch) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, sw.Status())
+	}
+}
+
+// StartDrain handles POST /admin/drain.
+func StartDrain(sw *drain.Switch, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw.Enable()
+		recordAdmin(auditLog, r, "drain.start", "")
+		writeJSON(w, http.StatusOK, sw.Status())
+	}
+}
+
+// StopDrain handles DELETE /admin/drain.
+func StopDrain(sw *drain.Switch, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw.Disable()
+		recordAdmin(auditLog, r, "drain.stop", "")
+		writeJSON(w, http.StatusOK, sw.Status())
+	}
+}
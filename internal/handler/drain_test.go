@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/drain"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestDrainable_PassesThroughWhenOff(t *testing.T) {
+	sw := drain.NewSwitch()
+	h := Drainable(sw, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusFound)
+	})
+
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/auth/discord", nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+}
+
+func TestDrainable_RetryLaterWhenDraining(t *testing.T) {
+	sw := drain.NewSwitch()
+	sw.Enable()
+	called := false
+	h := Drainable(sw, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/auth/discord", nil)
+	testutil.AssertStatus(t, rr, http.StatusServiceUnavailable)
+	if called {
+		t.Error("expected wrapped handler not to be called")
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("expected HTML page, got %q", rr.Header().Get("Content-Type"))
+	}
+}
+
+func TestDrainAdminEndpoints(t *testing.T) {
+	sw := drain.NewSwitch()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/drain", DrainStatus(sw))
+	mux.HandleFunc("POST /admin/drain", StartDrain(sw, nil))
+	mux.HandleFunc("DELETE /admin/drain", StopDrain(sw, nil))
+
+	rr := testutil.DoRequest(t, mux, http.MethodPost, "/admin/drain", nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	if !sw.Draining() {
+		t.Fatal("expected drain mode on")
+	}
+
+	rr = testutil.DoRequest(t, mux, http.MethodGet, "/admin/drain", nil)
+	var status drain.Status
+	testutil.ParseJSON(t, rr, &status)
+	if !status.Draining {
+		t.Error("expected status to report draining")
+	}
+
+	rr = testutil.DoRequest(t, mux, http.MethodDelete, "/admin/drain", nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	if sw.Draining() {
+		t.Error("expected drain mode off")
+	}
+}
@@ -1,18 +1,60 @@
 package handler
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"strings"
+	"time"
 
 	"github.com/BlackMission/centralauth/internal/client"
 	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/events"
 	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/idempotency"
+	"github.com/BlackMission/centralauth/internal/identity"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/internal/refresh"
+	"github.com/BlackMission/centralauth/internal/scope"
+	"github.com/BlackMission/centralauth/internal/store"
 )
 
+// IdempotencyKeyHeader lets clients safely retry an exchange request.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// Result formats for GET /exchange: the plain JSON result, a signed identity
+// token, or the JSON result with the token in its id_token field.
+const (
+	FormatJSON = "json"
+	FormatJWT  = "jwt"
+	FormatBoth = "both"
+)
+
+// redeemedPrefix namespaces the redeemed exchange codes in the store.
+const redeemedPrefix = "exchange/redeemed/"
+
 // Exchange handles GET /exchange.
 // It decrypts the exchange code, validates the API key, and returns the user info.
-func Exchange(clients *client.Registry, codec *exchange.Codec) http.HandlerFunc {
+// When the request carries an Idempotency-Key header and idem is non-nil, the first
+// successful result is cached and replayed for retries with the same key.
+// When redeemed is non-nil, each code is recorded there until it expires and
+// can only be redeemed once. The optional redirect_uri and client_ip
+// parameters must match the callback and browser the code was issued to.
+// With ids set, the format parameter can ask for the result as a signed
+// identity token instead of, or as well as, plain JSON. With refresher set,
+// clients with refresh tokens get one with JSON results. With identities set,
+// the user comes with their central ID.
+//
+// Public clients have no API key. They name themselves with client_id and
+// must send the code_verifier for the code's PKCE challenge and the exact
+// redirect_uri it was delivered to; they get no refresh tokens, which can
+// only be used with an API key.
+func Exchange(clients *client.Registry, codec *exchange.Codec, idem *idempotency.Cache, redeemed store.Store, ids *idtoken.Issuer, refresher *refresh.Service, funnel *metrics.Funnel, bus *events.Bus, identities *identity.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		code := r.URL.Query().Get("code")
 		if code == "" {
@@ -20,38 +62,281 @@ func Exchange(clients *client.Registry, codec *exchange.Codec) http.HandlerFunc
 			return
 		}
 
-		// Extract API key from Authorization header
-		authHeader := r.Header.Get("Authorization")
-		apiKey := strings.TrimPrefix(authHeader, "Bearer ")
-		if apiKey == "" || apiKey == authHeader {
-			writeError(w, http.StatusUnauthorized, "missing or invalid Authorization header")
+		clientApp, ok := exchangeClient(w, r, clients)
+		if !ok {
 			return
 		}
 
-		// Decrypt the exchange code
-		payload, err := codec.Decode(code)
+		// Checked before the code is spent, so that a client asking for a
+		// format it can't have may try again with the same code.
+		q := r.URL.Query()
+		if clientApp.Public && (q.Get("redirect_uri") == "" || q.Get("code_verifier") == "") {
+			writeError(w, http.StatusBadRequest, "public clients must send redirect_uri and code_verifier")
+			return
+		}
+		format := q.Get("format")
+		switch format {
+		case "", FormatJSON:
+			format = FormatJSON
+		case FormatJWT, FormatBoth:
+			if ids == nil {
+				writeError(w, http.StatusBadRequest, "signed results are not enabled")
+				return
+			}
+		default:
+			writeError(w, http.StatusBadRequest, "invalid format")
+			return
+		}
+
+		// Replay a previous result for the same idempotency key. Keys are scoped
+		// to the client so one client can never observe another's results.
+		// The fingerprint covers the binding parameters and format as well as
+		// the code, so a replay can't be used to skip their checks.
+		fingerprint := fingerprintOf(code, q.Get("redirect_uri"), q.Get("client_ip"), q.Get("code_verifier"), format)
+		var idemKey string
+		if key := r.Header.Get(IdempotencyKeyHeader); key != "" && idem != nil {
+			idemKey = clientApp.ID + ":" + key
+			if entry, ok := idem.Get(r.Context(), idemKey); ok {
+				if entry.Fingerprint != fingerprint {
+					writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+					return
+				}
+				contentType := entry.ContentType
+				if contentType == "" {
+					contentType = "application/json"
+				}
+				w.Header().Set("Content-Type", contentType)
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(entry.Status)
+				w.Write(entry.Body)
+				return
+			}
+		}
+
+		// Decrypt the exchange code with the calling client's key
+		payload, err := codec.DecodeFor(code, clientApp.ID)
 		if err != nil {
 			if errors.Is(err, domain.ErrExpiredExchangeCode) {
+				funnel.Dropped(metrics.StageCodeRedeemed, "code_expired", clientApp.ID, "")
 				writeError(w, http.StatusBadRequest, "exchange code expired")
 				return
 			}
+			funnel.Dropped(metrics.StageCodeRedeemed, "code_invalid", clientApp.ID, "")
+			noteFailure(r)
 			writeError(w, http.StatusBadRequest, "invalid exchange code")
 			return
 		}
-
-		// Validate API key
-		clientApp, err := clients.GetByAPIKey(apiKey)
-		if err != nil {
-			writeError(w, http.StatusUnauthorized, "invalid API key")
+		// Codes for OpenID Connect flows are bound to a PKCE verifier that
+		// only /token checks, and codes for device flows approve a device
+		if payload.OIDC != nil || payload.Device != "" {
+			funnel.Dropped(metrics.StageCodeRedeemed, "code_invalid", clientApp.ID, "")
+			noteFailure(r)
+			writeFlowError(w, http.StatusBadRequest, "invalid exchange code", payload.FlowID)
 			return
 		}
+		r = logWith(r, "flow_id", payload.FlowID, "client_id", clientApp.ID, "provider", payload.User.ProviderName)
+		if payload.Region != "" && payload.Region != codec.Region() {
+			slog.InfoContext(r.Context(), "exchange: redeeming a code from another region",
+				"issued_in", payload.Region, "region", codec.Region())
+		}
 
 		// Verify the API key belongs to the client that initiated the flow
 		if clientApp.ID != payload.ClientID {
-			writeError(w, http.StatusForbidden, "API key does not match the client that initiated the auth flow")
+			funnel.Dropped(metrics.StageCodeRedeemed, "client_mismatch", clientApp.ID, payload.User.ProviderName)
+			slog.WarnContext(r.Context(), "exchange: code presented by another client", "code_client_id", payload.ClientID)
+			noteFailure(r)
+			writeFlowError(w, http.StatusForbidden, "API key does not match the client that initiated the auth flow", payload.FlowID)
+			return
+		}
+
+		// Check the code against where the client says it came from. Each
+		// check is made only when the client asks for it.
+		if uri := r.URL.Query().Get("redirect_uri"); uri != "" && payload.RedirectURI != "" && uri != payload.RedirectURI {
+			funnel.Dropped(metrics.StageCodeRedeemed, "redirect_mismatch", clientApp.ID, payload.User.ProviderName)
+			slog.WarnContext(r.Context(), "exchange: code presented with another redirect_uri", "redirect_uri", payload.RedirectURI, "presented_as", uri)
+			writeFlowError(w, http.StatusBadRequest, "redirect_uri does not match the one the code was issued for", payload.FlowID)
 			return
 		}
+		if v := r.URL.Query().Get("client_ip"); v != "" {
+			ip, err := netip.ParseAddr(v)
+			if err != nil {
+				writeFlowError(w, http.StatusBadRequest, "invalid client_ip", payload.FlowID)
+				return
+			}
+			if payload.IPHash != "" && hashIP(ip.Unmap()) != payload.IPHash {
+				funnel.Dropped(metrics.StageCodeRedeemed, "ip_mismatch", clientApp.ID, payload.User.ProviderName)
+				slog.WarnContext(r.Context(), "exchange: code presented from another IP address")
+				writeFlowError(w, http.StatusBadRequest, "client_ip does not match the address the code was issued to", payload.FlowID)
+				return
+			}
+		}
+		if !verifyPKCE(payload.CodeChallenge, q.Get("code_verifier")) {
+			funnel.Dropped(metrics.StageCodeRedeemed, "pkce_mismatch", clientApp.ID, payload.User.ProviderName)
+			slog.WarnContext(r.Context(), "exchange: code presented with the wrong code_verifier")
+			writeFlowError(w, http.StatusBadRequest, "code_verifier does not match the code_challenge", payload.FlowID)
+			return
+		}
+		// Looked up before the code is spent, so that the client can try
+		// again with the same code while the identity database is down
+		if payload.User.CentralID, err = identities.CentralID(r.Context(), payload.User.ProviderName, payload.User.ProviderID); err != nil {
+			slog.ErrorContext(r.Context(), "exchange: looking up the central ID failed", "error", err)
+			writeFlowError(w, http.StatusServiceUnavailable, "user identities are unavailable, please try again", payload.FlowID)
+			return
+		}
+
+		// Spend the code. A store that fails lets it through, as it would
+		// without replay protection, rather than failing every sign-in.
+		if redeemed != nil {
+			first, err := redeemed.Add(r.Context(), redeemedPrefix+fingerprintOf(code), []byte(clientApp.ID), max(time.Until(payload.ExpiresAt), time.Second))
+			if err != nil {
+				slog.WarnContext(r.Context(), "exchange: store failed; not checking for reuse", "store", redeemed.String(), "error", err)
+			} else if !first {
+				funnel.Dropped(metrics.StageCodeRedeemed, "code_reused", clientApp.ID, payload.User.ProviderName)
+				slog.WarnContext(r.Context(), "exchange: code redeemed again")
+				writeFlowError(w, http.StatusBadRequest, "exchange code already used", payload.FlowID)
+				return
+			}
+		}
 
-		writeJSON(w, http.StatusOK, domain.AuthResult{User: payload.User})
+		// Codes minted before scopes existed carry no scope and the default data
+		granted := payload.Scope
+		if granted == "" {
+			granted = scope.Default
+		}
+		result := domain.AuthResult{
+			User:    scope.Filter(payload.User, granted),
+			Factors: payload.Factors,
+			Scope:   granted,
+		}
+		// Stripped here rather than when the code was issued, so that a
+		// client's current strip_fields applies to codes already out.
+		result.User = scope.Strip(result.User, clientApp.StripFields)
+		// Checked again here in case passthrough was revoked mid-flow
+		if clientApp.AllowTokenPassthrough {
+			result.Tokens = payload.Tokens
+		}
+		// A refresh token only fits in a JSON result. The code is spent by
+		// now, so a store that fails costs the client its refresh token
+		// rather than the sign-in.
+		if refresher != nil && clientApp.RefreshTokenTTL > 0 && !clientApp.Public && format != FormatJWT {
+			result.RefreshToken, err = refresher.Issue(r.Context(), clientApp.ID, result, clientApp.RefreshTokenTTL)
+			if err != nil {
+				slog.WarnContext(r.Context(), "exchange: no refresh token issued", "error", err)
+			}
+		}
+
+		var body []byte
+		contentType := "application/json"
+		if format != FormatJSON {
+			// Issued from the filtered result, so the token never holds more
+			// than the client may see.
+			token, err := ids.Issue(clientApp.ID, result)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "exchange: signing result failed", "error", err)
+				writeFlowError(w, http.StatusInternalServerError, "failed to sign result", payload.FlowID)
+				return
+			}
+			if format == FormatJWT {
+				body, contentType = []byte(token), "application/jwt"
+			}
+			result.IDToken = token
+		}
+		if body == nil {
+			body, err = json.Marshal(result)
+			if err != nil {
+				writeFlowError(w, http.StatusInternalServerError, "failed to encode result", payload.FlowID)
+				return
+			}
+		}
+
+		if idemKey != "" {
+			idem.Put(r.Context(), idemKey, idempotency.Entry{
+				Fingerprint: fingerprint,
+				Status:      http.StatusOK,
+				ContentType: contentType,
+				Body:        body,
+			})
+		}
+
+		recordLogin(r, identities, clientApp.ID, payload.User, payload.IP)
+		slog.InfoContext(r.Context(), "exchange: code redeemed")
+		funnel.Reached(metrics.StageCodeRedeemed, clientApp.ID, payload.User.ProviderName)
+		bus.Publish(r.Context(), events.Event{Type: events.ClientExchange, FlowID: payload.FlowID, ClientID: clientApp.ID,
+			Provider: payload.User.ProviderName, UserID: payload.User.ProviderID})
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
+
+// fingerprintOf hashes the fields of an exchange request.
+func fingerprintOf(fields ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// exchangeClient resolves the client redeeming a code: by its API key or
+// certificate, as authenticateClient does, or by the client_id parameter for
+// a public client, which has neither.
+func exchangeClient(w http.ResponseWriter, r *http.Request, clients *client.Registry) (*domain.ClientApp, bool) {
+	clientID := r.URL.Query().Get("client_id")
+	if r.Header.Get("Authorization") != "" || clientID == "" || peerCertificate(r) != nil {
+		return authenticateClient(w, r, clients)
+	}
+	clientApp, err := clients.Get(clientID)
+	if errors.Is(err, domain.ErrClientDisabled) {
+		writeError(w, http.StatusForbidden, "client is disabled")
+		return nil, false
+	}
+	if err != nil || !clientApp.Public {
+		writeError(w, http.StatusUnauthorized, "missing or invalid Authorization header")
+		return nil, false
+	}
+	return clientApp, true
+}
+
+// authenticateClient resolves the client from the request's bearer API key,
+// or, without one, from the TLS client certificate it was made with. It
+// writes a 401, or a 403 for a disabled client, an API key without the
+// client's certificate, or an address outside its AllowedIPs, and returns
+// false if it can't.
+func authenticateClient(w http.ResponseWriter, r *http.Request, clients *client.Registry) (*domain.ClientApp, bool) {
+	authHeader := r.Header.Get("Authorization")
+	cert := peerCertificate(r)
+
+	var clientApp *domain.ClientApp
+	var err error
+	if authHeader == "" && cert != nil {
+		if clientApp, err = clients.GetByCertificate(cert); err != nil && !errors.Is(err, domain.ErrClientDisabled) {
+			noteFailure(r)
+			writeError(w, http.StatusUnauthorized, "unknown client certificate")
+			return nil, false
+		}
+	} else {
+		apiKey := strings.TrimPrefix(authHeader, "Bearer ")
+		if apiKey == "" || apiKey == authHeader {
+			writeError(w, http.StatusUnauthorized, "missing or invalid Authorization header")
+			return nil, false
+		}
+		if clientApp, err = clients.GetByAPIKey(apiKey); err != nil && !errors.Is(err, domain.ErrClientDisabled) {
+			noteFailure(r)
+			writeError(w, http.StatusUnauthorized, "invalid API key")
+			return nil, false
+		}
+	}
+	if errors.Is(err, domain.ErrClientDisabled) {
+		writeError(w, http.StatusForbidden, "client is disabled")
+		return nil, false
+	}
+	if !presentsCert(clientApp, r) {
+		slog.WarnContext(r.Context(), "auth: API key used without the client's certificate", "client_id", clientApp.ID)
+		writeError(w, http.StatusForbidden, "client certificate required")
+		return nil, false
+	}
+	if ip := requestIP(r); !clientApp.AllowsIP(ip) {
+		slog.WarnContext(r.Context(), "auth: API key used from an address the client doesn't allow", "client_id", clientApp.ID, "ip", ip)
+		writeError(w, http.StatusForbidden, "API key not allowed from this address")
+		return nil, false
 	}
+	return clientApp, true
 }
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/BlackMission/centralauth/internal/events"
+	"github.com/BlackMission/centralauth/internal/logging"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/internal/tracing"
+)
+
+const flowIDBytes = 8
+
+// newFlowID returns a random identifier for one login attempt.
+func newFlowID() (string, error) {
+	b := make([]byte, flowIDBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// logWith returns r with args added to the fields of everything logged for
+// it, such as the flow, client and provider once they are known.
+func logWith(r *http.Request, args ...any) *http.Request {
+	return r.WithContext(logging.With(r.Context(), args...))
+}
+
+// traceProvider starts a span for a step that waits on provider, so a
+// slow sign-in's trace tells the provider's time apart from ours. It does
+// nothing when r isn't traced.
+func traceProvider(r *http.Request, name, provider string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(r.Context(), name, tracing.KindInternal)
+	span.SetAttributes("provider", provider)
+	return ctx, span
+}
+
+// signInFailed records a sign-in that ended before its code was issued, in
+// the funnel and as an auth.failed event.
+func signInFailed(r *http.Request, funnel *metrics.Funnel, bus *events.Bus, reason, flowID, clientID, provider string) {
+	funnel.Dropped(metrics.StageCodeIssued, reason, clientID, provider)
+	bus.Publish(r.Context(), events.Event{Type: events.AuthFailed, FlowID: flowID, ClientID: clientID, Provider: provider, Reason: reason})
+}
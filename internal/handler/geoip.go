@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/geoip"
+	"github.com/BlackMission/centralauth/internal/pages"
+)
+
+// Located wraps next so that handlers, and the events and audit entries they
+// record, see the country and ASN of the address each request came from. A
+// nil db looks nothing up.
+func Located(db *geoip.DB, next http.Handler) http.Handler {
+	if db == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := geoip.NewContext(r.Context(), db.Lookup(requestIP(r)))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GeoBlocked wraps a handler that starts signing a user in so that requests
+// from the blocked countries, or the ones blocked by the client named by the
+// client_id parameter, are refused: browsers get a page saying signing in
+// isn't available in their region, other callers a 403 error. Requests from
+// addresses whose country isn't known are let through.
+func GeoBlocked(blocked []string, clients *client.Registry, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		country := geoip.FromContext(r.Context()).Country
+		if country == "" {
+			next(w, r)
+			return
+		}
+		clientID := r.URL.Query().Get("client_id")
+		if !slices.Contains(blocked, country) {
+			if c, err := clients.Get(clientID); err != nil || !slices.Contains(c.BlockedCountries, country) {
+				next(w, r)
+				return
+			}
+		}
+
+		slog.WarnContext(r.Context(), "geoip: sign-in from a blocked country refused", "country", country, "client_id", clientID)
+		if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			writeError(w, http.StatusForbidden, "signing in is not available in your region")
+			return
+		}
+		pages.Render(w, http.StatusForbidden, "unavailable.html", pages.Unavailable{
+			Title:   "Not available in your region",
+			Message: "Signing in to this app isn't available in your region.",
+		})
+	}
+}
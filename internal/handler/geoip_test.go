@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/geoip"
+)
+
+func TestGeoBlocked(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "website", APIKey: "web-key"},
+		{ID: "community", APIKey: "community-key", BlockedCountries: []string{"DE"}},
+	})
+	h := GeoBlocked([]string{"KP"}, clients, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusFound)
+	})
+
+	for _, tt := range []struct {
+		country, clientID string
+		want              int
+	}{
+		{"", "community", http.StatusFound},
+		{"FR", "community", http.StatusFound},
+		{"DE", "website", http.StatusFound},
+		{"DE", "community", http.StatusForbidden},
+		{"KP", "website", http.StatusForbidden},
+		{"KP", "unknown", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/auth/discord?client_id="+tt.clientID, nil)
+		req = req.WithContext(geoip.NewContext(req.Context(), geoip.Location{Country: tt.country}))
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%s from %q: status %d, want %d", tt.clientID, tt.country, rr.Code, tt.want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/discord?client_id=community", nil)
+	req.Header.Set("Accept", "text/html")
+	req = req.WithContext(geoip.NewContext(req.Context(), geoip.Location{Country: "DE"}))
+	rr := httptest.NewRecorder()
+	h(rr, req)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "Not available in your region") {
+		t.Errorf("browser got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestLocated_NilDB(t *testing.T) {
+	var got geoip.Location
+	h := Located(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = geoip.FromContext(r.Context())
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/auth", nil))
+	if got != (geoip.Location{}) {
+		t.Errorf("location = %+v without a database", got)
+	}
+}
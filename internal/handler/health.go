@@ -1,14 +1,92 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/auth"
 )
 
+type providerHealthResponse struct {
+	Status    string                         `json:"status"`
+	Providers map[string]auth.ProviderHealth `json:"providers"`
+}
+
 // Health handles GET /health.
-func Health() http.HandlerFunc {
+// With ?deep=true and a checker, it also probes the providers, as
+// HealthProviders does.
+func Health(checker *auth.HealthChecker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if checker != nil && r.URL.Query().Get("deep") == "true" {
+			writeProviderHealth(w, r, checker)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 	}
 }
+
+// HealthProviders handles GET /health/providers.
+// It reports each provider's reachability, answering 503 while any is down,
+// so a load balancer can act on a provider outage.
+func HealthProviders(checker *auth.HealthChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeProviderHealth(w, r, checker)
+	}
+}
+
+func writeProviderHealth(w http.ResponseWriter, r *http.Request, checker *auth.HealthChecker) {
+	results := checker.Check(r.Context())
+	if !auth.Healthy(results) {
+		writeJSON(w, http.StatusServiceUnavailable, providerHealthResponse{Status: "degraded", Providers: results})
+		return
+	}
+	writeJSON(w, http.StatusOK, providerHealthResponse{Status: "ok", Providers: results})
+}
+
+// readyTimeout bounds each readiness check, so a hung store fails the probe
+// rather than outlasting it.
+const readyTimeout = 2 * time.Second
+
+// ReadyCheck reports whether a dependency, such as a shared store, can be
+// used.
+type ReadyCheck func(ctx context.Context) error
+
+type readyResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Ready handles GET /readyz. The instance is ready for traffic while it is
+// not shutting down, has a provider to sign in with, and passes every check;
+// otherwise it answers 503, so a load balancer or Kubernetes stops sending
+// it requests.
+func Ready(providers *auth.Registry, stopping func() bool, checks map[string]ReadyCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if stopping() {
+			writeJSON(w, http.StatusServiceUnavailable, readyResponse{Status: "shutting down"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		resp := readyResponse{Status: "ready", Checks: map[string]string{"providers": "ok"}}
+		if len(providers.Names()) == 0 {
+			resp.Status, resp.Checks["providers"] = "not ready", "no providers configured"
+		}
+		for name, check := range checks {
+			resp.Checks[name] = "ok"
+			if err := check(ctx); err != nil {
+				resp.Status, resp.Checks[name] = "not ready", err.Error()
+			}
+		}
+		if resp.Status != "ready" {
+			writeJSON(w, http.StatusServiceUnavailable, resp)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -1,14 +1,30 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"testing"
 
+	"github.com/BlackMission/centralauth/internal/auth"
 	"github.com/BlackMission/centralauth/pkg/testutil"
 )
 
+type probeStubProvider struct {
+	callbackStubProvider
+	probeErr error
+}
+
+func (s *probeStubProvider) Probe(ctx context.Context) error { return s.probeErr }
+
+func healthChecker(probeErr error) *auth.HealthChecker {
+	providers := auth.NewRegistry()
+	providers.Register(&probeStubProvider{callbackStubProvider: callbackStubProvider{name: "steam"}, probeErr: probeErr})
+	return auth.NewHealthChecker(providers)
+}
+
 func TestHealth(t *testing.T) {
-	rr := testutil.DoRequest(t, Health(), http.MethodGet, "/health", nil)
+	rr := testutil.DoRequest(t, Health(nil), http.MethodGet, "/health", nil)
 	testutil.AssertStatus(t, rr, http.StatusOK)
 
 	var body map[string]string
@@ -18,3 +34,68 @@ func TestHealth(t *testing.T) {
 		t.Errorf("expected status 'ok', got %q", body["status"])
 	}
 }
+
+func TestHealth_ShallowByDefault(t *testing.T) {
+	rr := testutil.DoRequest(t, Health(healthChecker(errors.New("down"))), http.MethodGet, "/health", nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+}
+
+func TestHealth_Deep(t *testing.T) {
+	rr := testutil.DoRequest(t, Health(healthChecker(nil)), http.MethodGet, "/health?deep=true", nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+
+	var body providerHealthResponse
+	testutil.ParseJSON(t, rr, &body)
+	if body.Status != "ok" || body.Providers["steam"].Status != auth.HealthUp {
+		t.Errorf("unexpected body: %+v", body)
+	}
+}
+
+func TestHealthProviders_Down(t *testing.T) {
+	rr := testutil.DoRequest(t, HealthProviders(healthChecker(errors.New("steamcommunity.com: status 502"))),
+		http.MethodGet, "/health/providers", nil)
+	testutil.AssertStatus(t, rr, http.StatusServiceUnavailable)
+
+	var body providerHealthResponse
+	testutil.ParseJSON(t, rr, &body)
+	steam := body.Providers["steam"]
+	if body.Status != "degraded" || steam.Status != auth.HealthDown || steam.LastError != "steamcommunity.com: status 502" {
+		t.Errorf("unexpected body: %+v", body)
+	}
+}
+
+func TestReady(t *testing.T) {
+	providers := auth.NewRegistry()
+	providers.Register(&callbackStubProvider{name: "discord"})
+	stopping := false
+	var storeErr error
+	h := Ready(providers, func() bool { return stopping }, map[string]ReadyCheck{
+		"store": func(ctx context.Context) error { return storeErr },
+	})
+
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/readyz", nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	var body readyResponse
+	testutil.ParseJSON(t, rr, &body)
+	if body.Status != "ready" || body.Checks["store"] != "ok" || body.Checks["providers"] != "ok" {
+		t.Errorf("body = %+v", body)
+	}
+
+	storeErr = errors.New("dial tcp 10.0.0.9:6379: connection refused")
+	rr = testutil.DoRequest(t, h, http.MethodGet, "/readyz", nil)
+	testutil.AssertStatus(t, rr, http.StatusServiceUnavailable)
+	body = readyResponse{}
+	testutil.ParseJSON(t, rr, &body)
+	if body.Status != "not ready" || body.Checks["store"] != storeErr.Error() {
+		t.Errorf("body = %+v", body)
+	}
+
+	storeErr, stopping = nil, true
+	rr = testutil.DoRequest(t, h, http.MethodGet, "/readyz", nil)
+	testutil.AssertStatus(t, rr, http.StatusServiceUnavailable)
+}
+
+func TestReady_NoProviders(t *testing.T) {
+	rr := testutil.DoRequest(t, Ready(auth.NewRegistry(), func() bool { return false }, nil), http.MethodGet, "/readyz", nil)
+	testutil.AssertStatus(t, rr, http.StatusServiceUnavailable)
+}
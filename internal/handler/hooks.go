@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/events"
+	"github.com/BlackMission/centralauth/internal/hook"
+	"github.com/BlackMission/centralauth/internal/metrics"
+)
+
+// refuseByHooks runs the post-auth hooks on result, and sends the browser
+// back to the client of the flow in payload with an error if one of them
+// refuses the sign-in or fails, reporting whether it did.
+func refuseByHooks(w http.ResponseWriter, r *http.Request, hooks hook.Chain, funnel *metrics.Funnel, bus *events.Bus,
+	payload *domain.StatePayload, result *domain.AuthResult, flowID string) bool {
+	err := hooks.Run(r.Context(), payload.ClientID, result)
+	if err == nil {
+		return false
+	}
+	var rejection *hook.Rejection
+	if errors.As(err, &rejection) {
+		signInFailed(r, funnel, bus, "hook_rejected", flowID, payload.ClientID, result.User.ProviderName)
+		slog.WarnContext(r.Context(), "hook: sign-in rejected", "provider_id", result.User.ProviderID, "reason", rejection.Reason)
+		redirectError(w, r, payload, http.StatusForbidden, oauthAccessDenied, rejection.Reason)
+		return true
+	}
+	signInFailed(r, funnel, bus, "hook_failed", flowID, payload.ClientID, result.User.ProviderName)
+	slog.ErrorContext(r.Context(), "hook: post-auth hook failed", "error", err)
+	redirectError(w, r, payload, http.StatusServiceUnavailable, oauthTemporarilyUnavailable, "sign-in is unavailable, please try again")
+	return true
+}
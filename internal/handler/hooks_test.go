@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/hook"
+	"github.com/BlackMission/centralauth/internal/session"
+	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/store"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestCallback_Hooks(t *testing.T) {
+	providers := auth.NewRegistry()
+	provider := &callbackStubProvider{name: "discord"}
+	providers.Register(provider)
+	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	hooks := hook.Chain{hook.Func(func(ctx context.Context, clientID string, result *domain.AuthResult) error {
+		switch result.User.ProviderID {
+		case "666":
+			return hook.Reject("account is under review")
+		case "500":
+			return errors.New("policy service unreachable")
+		}
+		result.User.Roles = append(result.User.Roles, "role:"+clientID)
+		return nil
+	})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /callback/{provider}", Callback(providers, stateSvc, codec, nil, nil, nil, nil, nil, nil, nil, hooks))
+	callback := func(userID string) url.Values {
+		t.Helper()
+		provider.result = &domain.AuthResult{User: domain.UserInfo{ProviderName: "discord", ProviderID: userID}}
+		token, _ := stateSvc.Generate(domain.StatePayload{ClientID: "website", Provider: "discord", RedirectURI: "https://example.com/callback"})
+		rr := testutil.DoRequest(t, mux, http.MethodGet, "/callback/discord?code=x&state="+url.QueryEscape(token), nil)
+		testutil.AssertStatus(t, rr, http.StatusFound)
+		loc, _ := url.Parse(rr.Header().Get("Location"))
+		return loc.Query()
+	}
+
+	q := callback("123")
+	payload, err := codec.Decode(q.Get("code"))
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if !slices.Equal(payload.User.Roles, []string{"role:website"}) {
+		t.Errorf("roles = %v, want the hook's", payload.User.Roles)
+	}
+	if q := callback("666"); q.Get("code") != "" || q.Get("error") != oauthAccessDenied || q.Get("error_description") != "account is under review" {
+		t.Errorf("a rejected user got %v", q)
+	}
+	if q := callback("500"); q.Get("code") != "" || q.Get("error") != oauthTemporarilyUnavailable {
+		t.Errorf("with the hook failing, got %v", q)
+	}
+}
+
+func TestSSO_Hooks(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "website", APIKey: "web-key", AllowedCallbacks: []string{"https://example.com/callback"}, AllowedProviders: []string{"discord"}},
+		{ID: "forum", APIKey: "forum-key", AllowedCallbacks: []string{"https://forum.example.com/callback"}, AllowedProviders: []string{"discord"}},
+		{ID: "wiki", APIKey: "wiki-key", AllowedCallbacks: []string{"https://wiki.example.com/callback"}, AllowedProviders: []string{"discord"}},
+	})
+	providers := auth.NewRegistry()
+	providers.Register(&signInProvider{
+		stubProvider: stubProvider{name: "discord", authURL: "https://discord.com/oauth2/authorize"},
+		user:         domain.UserInfo{ProviderName: "discord", ProviderID: "123", Username: "alice"},
+	})
+	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	sessions := session.New(store.NewMemory(), 0, "https://auth.example.com/sso")
+	hooks := hook.Chain{hook.Func(func(ctx context.Context, clientID string, result *domain.AuthResult) error {
+		if clientID == "wiki" {
+			return hook.Reject("the wiki is closed")
+		}
+		result.User.Roles = append(result.User.Roles, "role:"+clientID)
+		return nil
+	})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /auth/{provider}", Authorize(clients, providers, stateSvc, nil, nil, nil, codec, sessions, nil, hooks, nil, nil))
+	mux.HandleFunc("GET /callback/{provider}", Callback(providers, stateSvc, codec, nil, nil, nil, nil, sessions, nil, nil, hooks))
+	cookie := signIn(t, mux, stateSvc)
+
+	// The session signs in to forum with forum's hooks, not website's roles
+	rr := getWithCookie(mux, "/auth/discord?client_id=forum&redirect_uri="+url.QueryEscape("https://forum.example.com/callback"), cookie)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	payload, err := codec.DecodeFor(loc.Query().Get("code"), "forum")
+	if err != nil {
+		t.Fatalf("DecodeFor error: %v", err)
+	}
+	if !slices.Equal(payload.User.Roles, []string{"role:forum"}) {
+		t.Errorf("roles = %v, want only forum's", payload.User.Roles)
+	}
+
+	rr = getWithCookie(mux, "/auth/discord?client_id=wiki&redirect_uri="+url.QueryEscape("https://wiki.example.com/callback"), cookie)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	loc, _ = url.Parse(rr.Header().Get("Location"))
+	if q := loc.Query(); loc.Host != "wiki.example.com" || q.Get("code") != "" || q.Get("error") != oauthAccessDenied || q.Get("error_description") != "the wiki is closed" {
+		t.Errorf("a sign-in the hooks reject got %s", loc)
+	}
+}
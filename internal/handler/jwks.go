@@ -0,0 +1,20 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/BlackMission/centralauth/internal/idtoken"
+)
+
+// jwksMaxAge is how long verifiers may cache the key set. A next key must
+// be published for at least this long before it starts signing.
+const jwksMaxAge = "max-age=300"
+
+// JWKS handles GET /.well-known/jwks.json, publishing the public keys that
+// identity tokens are signed with.
+func JWKS(ids *idtoken.Issuer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, "+jwksMaxAge)
+		writeJSON(w, http.StatusOK, ids.JWKS())
+	}
+}
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestJWKS(t *testing.T) {
+	current, _ := idtoken.NewSigner(testutil.SigningKeyPEM(t))
+	previous, _ := idtoken.NewSigner(testutil.SigningKeyPEM(t))
+
+	handler := JWKS(idtoken.NewIssuer(current, "https://auth.example.com", 0, previous))
+	rr := testutil.DoRequest(t, handler, http.MethodGet, "/.well-known/jwks.json", nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	if cc := rr.Header().Get("Cache-Control"); cc != "public, max-age=300" {
+		t.Errorf("Cache-Control = %q", cc)
+	}
+
+	var set idtoken.JWKSet
+	testutil.ParseJSON(t, rr, &set)
+	if len(set.Keys) != 2 || set.Keys[0].Kid != current.KeyID() || set.Keys[1].Kid != previous.KeyID() {
+		t.Fatalf("keys = %+v", set.Keys)
+	}
+	if k := set.Keys[0]; k.Kty != "OKP" || k.Crv != "Ed25519" || k.X == "" || k.Alg != "EdDSA" {
+		t.Errorf("key = %+v", k)
+	}
+}
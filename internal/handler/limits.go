@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+)
+
+// QueryLimited refuses requests whose query string is longer than maxBytes
+// with 414, before any route parses it. Exchange codes, state tokens and the
+// OpenID Connect parameters all arrive in the query, and each is decoded or
+// decrypted before it can be rejected. A zero maxBytes allows any length.
+func QueryLimited(maxBytes int, next http.Handler) http.Handler {
+	if maxBytes <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.RawQuery) > maxBytes {
+			writeError(w, http.StatusRequestURITooLong, "query string too long")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeMethods are the methods tried when looking for the ones a path is
+// served with.
+var routeMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// MethodChecked answers requests that mux routes by path but not by method
+// with 405, listing the methods the path is served with in the Allow header,
+// in the same JSON as the other errors. Other requests go to next.
+func MethodChecked(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var allowed []string
+		for _, method := range routeMethods {
+			probe := *r
+			probe.Method = method
+			if _, pattern := mux.Handler(&probe); pattern != "" {
+				allowed = append(allowed, method)
+			}
+		}
+		if len(allowed) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestQueryLimited(t *testing.T) {
+	h := QueryLimited(32, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/exchange?code="+strings.Repeat("a", 27), nil)
+	testutil.AssertStatus(t, rr, http.StatusNoContent)
+
+	rr = testutil.DoRequest(t, h, http.MethodGet, "/exchange?code="+strings.Repeat("a", 28), nil)
+	testutil.AssertStatus(t, rr, http.StatusRequestURITooLong)
+	var body errorResponse
+	testutil.ParseJSON(t, rr, &body)
+	if body.Error != "query string too long" {
+		t.Errorf("error = %q", body.Error)
+	}
+}
+
+func TestMethodChecked(t *testing.T) {
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	mux.HandleFunc("GET /admin/drain", ok)
+	mux.HandleFunc("POST /admin/drain", ok)
+	mux.HandleFunc("DELETE /admin/drain", ok)
+	h := MethodChecked(mux, mux)
+
+	rr := testutil.DoRequest(t, h, http.MethodDelete, "/admin/drain", nil)
+	testutil.AssertStatus(t, rr, http.StatusNoContent)
+
+	rr = testutil.DoRequest(t, h, http.MethodPut, "/admin/drain", nil)
+	testutil.AssertStatus(t, rr, http.StatusMethodNotAllowed)
+	if got := rr.Header().Get("Allow"); got != "GET, HEAD, POST, DELETE" {
+		t.Errorf("Allow = %q", got)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	rr = testutil.DoRequest(t, h, http.MethodGet, "/missing", nil)
+	testutil.AssertStatus(t, rr, http.StatusNotFound)
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/events"
+	"github.com/BlackMission/centralauth/internal/lockout"
+)
+
+// failedKey is the context key under which Throttled leaves a *bool for the
+// handler it wraps to set, through noteFailure.
+type failedKey struct{}
+
+// noteFailure marks r as a failed attempt to authenticate, which Throttled
+// counts towards locking the caller out.
+func noteFailure(r *http.Request) {
+	if failed, ok := r.Context().Value(failedKey{}).(*bool); ok {
+		*failed = true
+	}
+}
+
+// Throttled wraps an API handler so that callers that keep failing to
+// authenticate, by presenting unknown API keys or client certificates or
+// exchange codes that aren't valid, are locked out for a while, for longer
+// each time. Failures are counted per IP, as found by ClientIP, and never
+// per client: anyone can present a bad code under a client's name, so
+// counting them against the client would let a stranger lock it out.
+// Locked out callers get a 429 error, and each lockout is logged and
+// published as a security.lockout event. A nil tracker disables lockouts.
+func Throttled(tracker *lockout.Tracker, bus *events.Bus, next http.HandlerFunc) http.HandlerFunc {
+	if tracker == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := ipKey(requestIP(r))
+		if retry := tracker.Locked(r.Context(), "ip/"+ip); retry > 0 {
+			slog.WarnContext(r.Context(), "lockout: refused a locked out caller", "ip", ip)
+			setRetryAfter(w, retry)
+			writeError(w, http.StatusTooManyRequests, "too many failed attempts")
+			return
+		}
+
+		failed := new(bool)
+		next(w, r.WithContext(context.WithValue(r.Context(), failedKey{}, failed)))
+		if !*failed {
+			return
+		}
+		if d := tracker.Fail(r.Context(), "ip/"+ip); d > 0 {
+			lockedOut(r, bus, ip, d)
+		}
+	}
+}
+
+// lockedOut logs and publishes the lockout of ip for d.
+func lockedOut(r *http.Request, bus *events.Bus, ip string, d time.Duration) {
+	slog.WarnContext(r.Context(), "lockout: locked out after repeated failures", "locked_out", "ip", "ip", ip, "duration", d)
+	bus.Publish(r.Context(), events.Event{Type: events.SecurityLockout, Reason: "ip", IP: ip,
+		LockedUntil: time.Now().Add(d).UTC()})
+}
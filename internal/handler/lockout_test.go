@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/lockout"
+	"github.com/BlackMission/centralauth/internal/ratelimit"
+	"github.com/BlackMission/centralauth/internal/store"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+// exchangeFrom serves a GET request for path with apiKey from remoteAddr.
+func exchangeFrom(h http.HandlerFunc, path, apiKey, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.RemoteAddr = remoteAddr
+	rr := httptest.NewRecorder()
+	h(rr, req)
+	return rr
+}
+
+func TestThrottled(t *testing.T) {
+	clients, _ := setupRateLimit(t)
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	tracker := lockout.New(ratelimit.New(ratelimit.NewMemory()), store.NewMemory(), lockout.Policy{
+		Failures: domain.RateLimit{Requests: 2, Per: time.Hour},
+		Lockout:  time.Minute,
+	})
+	h := Throttled(tracker, nil, Exchange(clients, codec, nil, nil, nil, nil, nil, nil, nil))
+	valid, _ := codec.Encode(domain.ExchangePayload{ClientID: "website", User: domain.UserInfo{ProviderName: "discord", ProviderID: "1"}})
+
+	// Bad API keys are counted against the address they come from
+	bad := map[string]string{"Authorization": "Bearer wrong-key"}
+	for range 3 {
+		testutil.AssertStatus(t, testutil.DoRequest(t, h, http.MethodGet, "/exchange?code=x", bad), http.StatusUnauthorized)
+	}
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/exchange?code="+url.QueryEscape(valid), map[string]string{"Authorization": "Bearer web-key"})
+	testutil.AssertStatus(t, rr, http.StatusTooManyRequests)
+	if rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("Retry-After = %q, want 60", rr.Header().Get("Retry-After"))
+	}
+}
+
+func TestThrottled_Codes(t *testing.T) {
+	clients, _ := setupRateLimit(t)
+	now := time.Now()
+	codec, _ := testutil.NewCodec([]byte("01234567890123456789012345678901"), func() time.Time { return now }, nil)
+	tracker := lockout.New(ratelimit.New(ratelimit.NewMemory()), store.NewMemory(), lockout.Policy{
+		Failures: domain.RateLimit{Requests: 1, Per: time.Hour},
+	})
+	h := Throttled(tracker, nil, Exchange(clients, codec, nil, nil, nil, nil, nil, nil, nil))
+	code := func(clientID string) string {
+		c, _ := codec.Encode(domain.ExchangePayload{ClientID: clientID, User: domain.UserInfo{ProviderName: "discord", ProviderID: "1"}})
+		return "/exchange?code=" + url.QueryEscape(c)
+	}
+
+	// Expired codes are a user's delay rather than an attack
+	expired := code("website")
+	now = now.Add(time.Hour)
+	for range 3 {
+		testutil.AssertStatus(t, exchangeFrom(h, expired, "web-key", "192.0.2.1:1234"), http.StatusBadRequest)
+	}
+	testutil.AssertStatus(t, exchangeFrom(h, code("website"), "web-key", "192.0.2.1:1234"), http.StatusOK)
+
+	// Codes that can't be decrypted, or are another client's, lock out the
+	// address they come from
+	testutil.AssertStatus(t, exchangeFrom(h, "/exchange?code=forged", "web-key", "192.0.2.1:1234"), http.StatusBadRequest)
+	testutil.AssertStatus(t, exchangeFrom(h, code("game"), "web-key", "192.0.2.1:1234"), http.StatusForbidden)
+	testutil.AssertStatus(t, exchangeFrom(h, code("website"), "web-key", "192.0.2.1:1234"), http.StatusTooManyRequests)
+
+	// but not the client, whose key carries on from elsewhere
+	testutil.AssertStatus(t, exchangeFrom(h, code("website"), "web-key", "198.51.100.7:4000"), http.StatusOK)
+}
+
+func TestThrottled_Token(t *testing.T) {
+	clients, _ := setupRateLimit(t)
+	tracker := lockout.New(ratelimit.New(ratelimit.NewMemory()), store.NewMemory(), lockout.Policy{
+		Failures: domain.RateLimit{Requests: 1, Per: time.Hour},
+	})
+	h := Throttled(tracker, nil, Token(clients, nil, nil, nil, nil, nil, nil, nil, nil, nil))
+	post := func(secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader("grant_type=authorization_code&code=x"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("website", secret)
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	testutil.AssertStatus(t, post("wrong-key"), http.StatusUnauthorized)
+	testutil.AssertStatus(t, post("wrong-key"), http.StatusUnauthorized)
+	testutil.AssertStatus(t, post("web-key"), http.StatusTooManyRequests)
+}
+
+func TestThrottled_TokenCodes(t *testing.T) {
+	clients, _ := setupRateLimit(t)
+	codec, _ := testutil.NewCodec([]byte("01234567890123456789012345678901"), time.Now, nil)
+	tracker := lockout.New(ratelimit.New(ratelimit.NewMemory()), store.NewMemory(), lockout.Policy{
+		Failures: domain.RateLimit{Requests: 1, Per: time.Hour},
+	})
+	h := Throttled(tracker, nil, Token(clients, codec, nil, nil, nil, nil, nil, nil, nil, nil))
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader("grant_type=authorization_code&code=forged"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("website", "web-key")
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	// Codes that can't be decrypted count against the address
+	testutil.AssertStatus(t, post(), http.StatusBadRequest)
+	testutil.AssertStatus(t, post(), http.StatusBadRequest)
+	testutil.AssertStatus(t, post(), http.StatusTooManyRequests)
+}
+
+func TestThrottled_NilTracker(t *testing.T) {
+	h := Throttled(nil, nil, ok)
+	testutil.AssertStatus(t, testutil.DoRequest(t, h, http.MethodGet, "/exchange", nil), http.StatusOK)
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/scope"
+)
+
+const maxLookupRequestBytes = 4 << 10
+
+type lookupRequest struct {
+	UserID string `json:"user_id"`
+	Scope  string `json:"scope,omitempty"`
+}
+
+// Lookup handles POST /auth/{provider}/lookup.
+// A client with allow_lookup posts a provider user ID with its API key, and
+// the provider resolves it to a profile with its own credentials. This is for
+// clients that already know who the user is, such as a Discord bot, and can't
+// send them through a redirect. The user has not signed in, so the result
+// lists no factors.
+func Lookup(clients *client.Registry, providers *auth.Registry, limiter *auth.Limiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientApp, ok := authenticateClient(w, r, clients)
+		if !ok {
+			return
+		}
+		if !clientApp.AllowLookup {
+			writeError(w, http.StatusForbidden, "client is not allowed to look up users")
+			return
+		}
+
+		providerName := r.PathValue("provider")
+		provider, err := providers.Get(providerName)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "unknown provider")
+			return
+		}
+		lp, ok := provider.(auth.LookupProvider)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "provider does not support user lookups")
+			return
+		}
+		if err := clients.ValidateProvider(clientApp.ID, providerName); err != nil {
+			writeError(w, http.StatusForbidden, "provider not allowed for this client")
+			return
+		}
+
+		var req lookupRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLookupRequestBytes)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if req.UserID == "" {
+			writeError(w, http.StatusBadRequest, "missing user_id")
+			return
+		}
+		granted, err := scope.Parse(req.Scope)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "unsupported scope value")
+			return
+		}
+		r = logWith(r, "client_id", clientApp.ID, "provider", providerName)
+
+		release, err := limiter.Acquire(r.Context(), providerName)
+		if err != nil {
+			slog.WarnContext(r.Context(), "lookup: lookup not started", "error", err)
+			w.Header().Set("Retry-After", limiter.RetryAfter(providerName))
+			writeError(w, http.StatusServiceUnavailable, "provider is busy, please try again")
+			return
+		}
+
+		ctx, span := traceProvider(r, "provider lookup", providerName)
+		result, err := lp.LookupUser(ctx, req.UserID)
+		release()
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			slog.WarnContext(r.Context(), "lookup: lookup failed", "error", err)
+			switch {
+			case errors.Is(err, domain.ErrLookupNotConfigured):
+				writeError(w, http.StatusBadRequest, "provider does not support user lookups")
+			case errors.Is(err, domain.ErrMissingProviderParams):
+				writeError(w, http.StatusBadRequest, "invalid user_id")
+			case errors.Is(err, domain.ErrAccountNotFound):
+				writeError(w, http.StatusNotFound, "user not found")
+			default:
+				writeError(w, http.StatusBadGateway, "provider lookup failed")
+			}
+			return
+		}
+
+		if !clientApp.IncludeRaw {
+			result.User.Raw = nil
+		}
+		slog.InfoContext(r.Context(), "lookup: user looked up", "provider_id", result.User.ProviderID)
+		writeJSON(w, http.StatusOK, domain.AuthResult{
+			User:  scope.Strip(scope.Filter(result.User, granted), clientApp.StripFields),
+			Scope: granted,
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+type lookupStubProvider struct {
+	callbackStubProvider
+	gotUserID string
+}
+
+func (s *lookupStubProvider) LookupUser(ctx context.Context, userID string) (*domain.AuthResult, error) {
+	s.gotUserID = userID
+	return s.result, s.err
+}
+
+func setupLookup(provider auth.Provider) http.Handler {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{
+			ID:               "bot",
+			APIKey:           "bot-api-key-secret",
+			AllowedProviders: []string{"discord", "steam"},
+			AllowLookup:      true,
+		},
+		{
+			ID:               "website",
+			APIKey:           "web-api-key-secret",
+			AllowedProviders: []string{"discord"},
+		},
+		{
+			ID:               "steam-only",
+			APIKey:           "steam-api-key-secret",
+			AllowedProviders: []string{"steam"},
+			AllowLookup:      true,
+		},
+	})
+	providers := auth.NewRegistry()
+	providers.Register(provider)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /auth/{provider}/lookup", Lookup(clients, providers, nil))
+	return mux
+}
+
+func postLookup(t *testing.T, h http.Handler, provider, apiKey, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/auth/"+provider+"/lookup", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func discordLookupStub() *lookupStubProvider {
+	return &lookupStubProvider{callbackStubProvider: callbackStubProvider{
+		name: "discord",
+		result: &domain.AuthResult{User: domain.UserInfo{
+			ProviderName: "discord",
+			ProviderID:   "123456789",
+			Username:     "testuser",
+			Email:        "test@example.com",
+		}},
+	}}
+}
+
+func TestLookup_ReturnsProfile(t *testing.T) {
+	provider := discordLookupStub()
+	h := setupLookup(provider)
+
+	rr := postLookup(t, h, "discord", "bot-api-key-secret", `{"user_id":"123456789","scope":"profile"}`)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+
+	var result domain.AuthResult
+	testutil.ParseJSON(t, rr, &result)
+	if provider.gotUserID != "123456789" {
+		t.Errorf("provider got user ID %q", provider.gotUserID)
+	}
+	if result.User.ProviderID != "123456789" || result.User.Username != "testuser" {
+		t.Errorf("unexpected user: %+v", result.User)
+	}
+	if result.User.Email != "" {
+		t.Error("expected the email to be withheld outside the granted scope")
+	}
+	if result.Scope != "profile" || len(result.Factors) != 0 {
+		t.Errorf("expected scope profile and no factors, got %q %v", result.Scope, result.Factors)
+	}
+}
+
+func TestLookup_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		apiKey   string
+		body     string
+		err      error
+		status   int
+	}{
+		{"bad API key", "discord", "wrong", `{"user_id":"1"}`, nil, http.StatusUnauthorized},
+		{"client without lookup", "discord", "web-api-key-secret", `{"user_id":"1"}`, nil, http.StatusForbidden},
+		{"unknown provider", "twitch", "bot-api-key-secret", `{"user_id":"1"}`, nil, http.StatusBadRequest},
+		{"provider not allowed", "discord", "steam-api-key-secret", `{"user_id":"1"}`, nil, http.StatusForbidden},
+		{"bad body", "discord", "bot-api-key-secret", `user_id=1`, nil, http.StatusBadRequest},
+		{"missing user ID", "discord", "bot-api-key-secret", `{}`, nil, http.StatusBadRequest},
+		{"bad scope", "discord", "bot-api-key-secret", `{"user_id":"1","scope":"everything"}`, nil, http.StatusBadRequest},
+		{"invalid user ID", "discord", "bot-api-key-secret", `{"user_id":"x"}`, domain.ErrMissingProviderParams, http.StatusBadRequest},
+		{"no bot token", "discord", "bot-api-key-secret", `{"user_id":"1"}`, domain.ErrLookupNotConfigured, http.StatusBadRequest},
+		{"unknown user", "discord", "bot-api-key-secret", `{"user_id":"1"}`, domain.ErrAccountNotFound, http.StatusNotFound},
+		{"provider failure", "discord", "bot-api-key-secret", `{"user_id":"1"}`, domain.ErrProviderUserFetch, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := discordLookupStub()
+			if tt.err != nil {
+				provider.err = fmt.Errorf("%w: test", tt.err)
+			}
+			h := setupLookup(provider)
+
+			rr := postLookup(t, h, tt.provider, tt.apiKey, tt.body)
+			testutil.AssertStatus(t, rr, tt.status)
+		})
+	}
+}
+
+func TestLookup_ProviderWithoutLookups(t *testing.T) {
+	h := setupLookup(&callbackStubProvider{name: "steam"})
+
+	rr := postLookup(t, h, "steam", "bot-api-key-secret", `{"user_id":"1"}`)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/BlackMission/centralauth/internal/audit"
+	"github.com/BlackMission/centralauth/internal/maintenance"
+	"github.com/BlackMission/centralauth/internal/pages"
+)
+
+// Maintainable wraps a handler that signs users in so that, while
+// maintenance mode is on, browsers get the "logins temporarily disabled"
+// page and other callers a 503 error instead. Callbacks and exchanges are
+// not wrapped, so codes already issued can still be redeemed.
+func Maintainable(mode *maintenance.Mode, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		on, message := mode.Enabled()
+		if !on {
+			next(w, r)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			writeError(w, http.StatusServiceUnavailable, "logins are temporarily disabled")
+			return
+		}
+		pages.Render(w, http.StatusServiceUnavailable, "maintenance.html", pages.Maintenance{Message: message})
+	}
+}
+
+// MaintenanceStatus handles GET /admin/maintenance.
+func MaintenanceStatus(mode *maintenance.Mode) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, mode.Status())
+	}
+}
+
+const maxMaintenanceRequestBytes = 4 << 10
+
+type maintenanceRequest struct {
+	Message string `json:"message,omitempty"`
+}
+
+// StartMaintenance handles POST /admin/maintenance. The body may carry the
+// message to show users instead of the default one.
+func StartMaintenance(mode *maintenance.Mode, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req maintenanceRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMaintenanceRequestBytes)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		mode.Enable(strings.TrimSpace(req.Message))
+		recordAdmin(auditLog, r, "maintenance.start", "")
+		writeJSON(w, http.StatusOK, mode.Status())
+	}
+}
+
+// StopMaintenance handles DELETE /admin/maintenance.
+func StopMaintenance(mode *maintenance.Mode, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mode.Disable()
+		recordAdmin(auditLog, r, "maintenance.stop", "")
+		writeJSON(w, http.StatusOK, mode.Status())
+	}
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/audit"
+	"github.com/BlackMission/centralauth/internal/maintenance"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestMaintainable(t *testing.T) {
+	mode := maintenance.NewMode()
+	called := false
+	h := Maintainable(mode, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusFound)
+	})
+	browser := map[string]string{"Accept": "text/html"}
+
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/auth/discord", browser)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+
+	mode.Enable("Back at <b>noon</b>")
+	called = false
+	rr = testutil.DoRequest(t, h, http.MethodGet, "/auth/discord", browser)
+	testutil.AssertStatus(t, rr, http.StatusServiceUnavailable)
+	if called {
+		t.Error("expected wrapped handler not to be called")
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "Logins temporarily disabled") || !strings.Contains(body, "Back at &lt;b&gt;noon&lt;/b&gt;") {
+		t.Errorf("unexpected page: %s", body)
+	}
+
+	rr = testutil.DoRequest(t, h, http.MethodPost, "/auth/steam/ticket", nil)
+	testutil.AssertStatus(t, rr, http.StatusServiceUnavailable)
+	var resp errorResponse
+	testutil.ParseJSON(t, rr, &resp)
+	if resp.Error != "logins are temporarily disabled" {
+		t.Errorf("error = %q", resp.Error)
+	}
+}
+
+func TestMaintenanceAdminEndpoints(t *testing.T) {
+	mode := maintenance.NewMode()
+	auditLog := audit.NewLog(0)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/maintenance", MaintenanceStatus(mode))
+	mux.HandleFunc("POST /admin/maintenance", StartMaintenance(mode, auditLog))
+	mux.HandleFunc("DELETE /admin/maintenance", StopMaintenance(mode, auditLog))
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/maintenance", strings.NewReader(`{"message":"Investigating an outage"}`))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	if on, msg := mode.Enabled(); !on || msg != "Investigating an outage" {
+		t.Fatalf("expected maintenance mode on with the message, got %v %q", on, msg)
+	}
+
+	rr = testutil.DoRequest(t, mux, http.MethodGet, "/admin/maintenance", nil)
+	var status maintenance.Status
+	testutil.ParseJSON(t, rr, &status)
+	if !status.Enabled || status.Message != "Investigating an outage" {
+		t.Errorf("status = %+v", status)
+	}
+
+	rr = testutil.DoRequest(t, mux, http.MethodDelete, "/admin/maintenance", nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	if on, _ := mode.Enabled(); on {
+		t.Error("expected maintenance mode off")
+	}
+
+	// Without a body the default message is shown
+	rr = testutil.DoRequest(t, mux, http.MethodPost, "/admin/maintenance", nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+
+	events := auditLog.Events()
+	if len(events) != 3 || events[0].Action != "maintenance.start" || events[1].Action != "maintenance.stop" {
+		t.Errorf("audit events = %+v", events)
+	}
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/events"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/internal/mfa"
+	"github.com/BlackMission/centralauth/internal/pages"
+	"github.com/BlackMission/centralauth/internal/session"
+	"github.com/BlackMission/centralauth/internal/totp"
+)
+
+// TOTPPrompt handles GET /mfa/totp. It asks for a code, first walking users
+// without an enrolled authenticator through enrollment.
+func TOTPPrompt(mfaSvc *mfa.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pending, ok := openPending(w, mfaSvc, r.URL.Query().Get("t"))
+		if !ok {
+			return
+		}
+		token := r.URL.Query().Get("t")
+		identity := mfa.Identity(pending.User)
+		r = logWith(r, "flow_id", pending.FlowID, "client_id", pending.ClientID, "provider", pending.User.ProviderName)
+
+		if pending.EnrollSecret == "" {
+			enrolled, err := mfaSvc.Enrolled(identity)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "mfa: checking enrollment failed", "error", err)
+				writeFlowError(w, http.StatusInternalServerError, "failed to load second factor", pending.FlowID)
+				return
+			}
+			if !enrolled {
+				secret, err := totp.GenerateSecret()
+				if err != nil {
+					writeFlowError(w, http.StatusInternalServerError, "failed to start enrollment", pending.FlowID)
+					return
+				}
+				pending.EnrollSecret = secret
+				if token, err = mfaSvc.Seal(*pending); err != nil {
+					writeFlowError(w, http.StatusInternalServerError, "failed to start enrollment", pending.FlowID)
+					return
+				}
+			}
+		}
+
+		pages.Render(w, http.StatusOK, "totp.html", totpPage(mfaSvc, token, pending, ""))
+	}
+}
+
+// TOTPVerify handles POST /mfa/totp. A valid code resumes the paused flow and
+// redirects back to the client with an exchange code, starting an SSO
+// session when sessions is set.
+func TOTPVerify(mfaSvc *mfa.Service, codec *exchange.Codec, funnel *metrics.Funnel, bus *events.Bus, sessions *session.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.PostFormValue("t")
+		pending, ok := openPending(w, mfaSvc, token)
+		if !ok {
+			return
+		}
+		identity := mfa.Identity(pending.User)
+		code := r.PostFormValue("code")
+		r = logWith(r, "flow_id", pending.FlowID, "client_id", pending.ClientID, "provider", pending.User.ProviderName)
+
+		var err error
+		if pending.EnrollSecret != "" {
+			err = mfaSvc.Enroll(identity, pending.EnrollSecret, code)
+		} else {
+			err = mfaSvc.Verify(identity, code)
+		}
+		switch {
+		case errors.Is(err, domain.ErrInvalidTOTPCode):
+			pages.Render(w, http.StatusUnauthorized, "totp.html", totpPage(mfaSvc, token, pending, "That code didn't match. Try again."))
+			return
+		case errors.Is(err, domain.ErrMFALocked):
+			signInFailed(r, funnel, bus, "mfa_locked", pending.FlowID, pending.ClientID, pending.User.ProviderName)
+			pages.Render(w, http.StatusTooManyRequests, "unavailable.html", pages.Unavailable{
+				Title:   "Too many attempts",
+				Message: "Too many incorrect codes. Wait a few minutes, then sign in again.",
+			})
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "mfa: verifying code failed", "error", err)
+			writeFlowError(w, http.StatusInternalServerError, "failed to verify second factor", pending.FlowID)
+			return
+		}
+
+		if pending.EnrollSecret != "" {
+			slog.InfoContext(r.Context(), "mfa: enrolled TOTP", "user", identity)
+		}
+		factors := append(pending.Factors, mfa.FactorTOTP)
+		// Flows paused before SessionUser was kept start the session with
+		// the user as the hooks left them
+		sessionUser := pending.User
+		if pending.SessionUser != nil {
+			sessionUser = *pending.SessionUser
+		}
+		startSession(w, r, sessions, pending.ClientID, sessionUser, factors, pending.FlowID)
+		issueCode(w, r, codec, funnel, bus, domain.ExchangePayload{
+			ClientID: pending.ClientID,
+			FlowID:   pending.FlowID,
+			Factors:  factors,
+			Scope:    pending.Scope,
+			User:     pending.User,
+			Tokens:   pending.Tokens,
+			OIDC:     pending.OIDC,
+			Device:   pending.Device,
+
+			CodeChallenge: pending.CodeChallenge,
+			AppState:      pending.AppState,
+		}, pending.RedirectURI, pending.User.ProviderName)
+	}
+}
+
+func openPending(w http.ResponseWriter, mfaSvc *mfa.Service, token string) (*mfa.Pending, bool) {
+	pending, err := mfaSvc.Open(token)
+	if err != nil {
+		pages.Render(w, http.StatusBadRequest, "unavailable.html", pages.Unavailable{
+			Title:   "Sign-in link expired",
+			Message: "This sign-in link is no longer valid. Go back to the app and start again.",
+		})
+		return nil, false
+	}
+	return pending, true
+}
+
+func totpPage(mfaSvc *mfa.Service, token string, pending *mfa.Pending, msg string) pages.TOTP {
+	page := pages.TOTP{Token: token, Error: msg}
+	if pending.EnrollSecret != "" {
+		page.Enroll = true
+		page.Secret = pending.EnrollSecret
+		page.URI = template.URL(mfaSvc.URI(mfa.Identity(pending.User), pending.EnrollSecret))
+	}
+	return page
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/mfa"
+	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/totp"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+var tokenField = regexp.MustCompile(`name="t" value="([^"]+)"`)
+var secretField = regexp.MustCompile(`<code>([A-Z2-7]+)</code>`)
+
+func setupMFA(t *testing.T) (http.Handler, *state.Service, *exchange.Codec, *time.Time) {
+	t.Helper()
+	providers := auth.NewRegistry()
+	providers.Register(&callbackStubProvider{
+		name:   "discord",
+		result: &domain.AuthResult{User: domain.UserInfo{ProviderName: "discord", ProviderID: "123"}},
+	})
+	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+
+	store, _ := mfa.OpenFileStore(filepath.Join(t.TempDir(), "mfa.json"))
+	mfaSvc, err := mfa.NewService([]byte("abcdefghijklmnopqrstuvwxyz012345"), store, "CentralAuth")
+	if err != nil {
+		t.Fatalf("NewService error: %v", err)
+	}
+	now := time.Now()
+	mfaSvc.SetNow(func() time.Time { return now })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /callback/{provider}", Callback(providers, stateSvc, codec, nil, nil, nil, mfaSvc, nil, nil, nil, nil))
+	mux.HandleFunc("GET /mfa/totp", TOTPPrompt(mfaSvc))
+	mux.HandleFunc("POST /mfa/totp", TOTPVerify(mfaSvc, codec, nil, nil, nil))
+	return mux, stateSvc, codec, &now
+}
+
+func TestCallback_2FAEnrollThenVerify(t *testing.T) {
+	h, stateSvc, codec, now := setupMFA(t)
+
+	stateToken, _ := stateSvc.Generate(domain.StatePayload{
+		ClientID:    "website",
+		Provider:    "discord",
+		RedirectURI: "https://example.com/callback",
+		ACR:         mfa.ACR2FA,
+	})
+	callback := fmt.Sprintf("/callback/discord?code=auth-code&state=%s", url.QueryEscape(stateToken))
+	rr := testutil.DoRequest(t, h, http.MethodGet, callback, nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	prompt := resolve(t, callback, rr.Header().Get("Location"))
+	if !strings.HasPrefix(prompt, "/mfa/totp?") {
+		t.Fatalf("expected redirect to TOTP prompt, got %s", prompt)
+	}
+
+	// First sign-in: the page enrolls a new secret
+	rr = testutil.DoRequest(t, h, http.MethodGet, prompt, nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	page := rr.Body.String()
+	secret := secretField.FindStringSubmatch(page)
+	token := tokenField.FindStringSubmatch(page)
+	if secret == nil || token == nil {
+		t.Fatalf("expected enrollment page, got %s", page)
+	}
+
+	code, _ := totp.Code(secret[1], *now)
+	rr = postTOTP(h, token[1], "000000")
+	testutil.AssertStatus(t, rr, http.StatusUnauthorized)
+
+	rr = postTOTP(h, token[1], code)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	payload, err := codec.Decode(loc.Query().Get("code"))
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if strings.Join(payload.Factors, ",") != "discord,totp" {
+		t.Errorf("Factors = %v, want [discord totp]", payload.Factors)
+	}
+}
+
+func TestCallback_WithoutACRSkipsSecondFactor(t *testing.T) {
+	h, stateSvc, codec, _ := setupMFA(t)
+
+	stateToken, _ := stateSvc.Generate(domain.StatePayload{
+		ClientID:    "website",
+		Provider:    "discord",
+		RedirectURI: "https://example.com/callback",
+	})
+	rr := testutil.DoRequest(t, h, http.MethodGet,
+		fmt.Sprintf("/callback/discord?code=auth-code&state=%s", url.QueryEscape(stateToken)), nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	payload, err := codec.Decode(loc.Query().Get("code"))
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if strings.Join(payload.Factors, ",") != "discord" {
+		t.Errorf("Factors = %v, want [discord]", payload.Factors)
+	}
+}
+
+func TestTOTPPrompt_InvalidToken(t *testing.T) {
+	h, _, _, _ := setupMFA(t)
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/mfa/totp?t=bogus", nil)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+}
+
+func postTOTP(h http.Handler, token, code string) *httptest.ResponseRecorder {
+	form := url.Values{"t": {token}, "code": {code}}
+	req := httptest.NewRequest(http.MethodPost, "/mfa/totp", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+// resolve returns the path and query that the browser follows location to
+// from base.
+func resolve(t *testing.T, base, location string) string {
+	t.Helper()
+	b, _ := url.Parse(base)
+	loc, err := url.Parse(location)
+	if err != nil {
+		t.Fatalf("bad Location %q: %v", location, err)
+	}
+	return b.ResolveReference(loc).RequestURI()
+}
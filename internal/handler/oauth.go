@@ -0,0 +1,313 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/ban"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/device"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/events"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/identity"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/internal/refresh"
+	"github.com/BlackMission/centralauth/internal/scope"
+	"github.com/BlackMission/centralauth/internal/store"
+)
+
+// OAuth error codes (RFC 6749 sections 4.1.2.1 and 5.2, OpenID Connect
+// Core section 3.1.2.6, RFC 8628 section 3.5, RFC 8707 section 2).
+const (
+	oauthInvalidRequest          = "invalid_request"
+	oauthInvalidClient           = "invalid_client"
+	oauthInvalidGrant            = "invalid_grant"
+	oauthInvalidScope            = "invalid_scope"
+	oauthUnauthorizedClient      = "unauthorized_client"
+	oauthUnsupportedGrantType    = "unsupported_grant_type"
+	oauthUnsupportedResponseType = "unsupported_response_type"
+	oauthLoginRequired           = "login_required"
+	oauthInteractionRequired     = "interaction_required"
+	oauthServerError             = "server_error"
+	oauthTemporarilyUnavailable  = "temporarily_unavailable"
+	oauthAuthorizationPending    = "authorization_pending"
+	oauthSlowDown                = "slow_down"
+	oauthAccessDenied            = "access_denied"
+	oauthExpiredToken            = "expired_token"
+	oauthInvalidTarget           = "invalid_target"
+)
+
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	RequestID   string `json:"request_id,omitempty"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	IDToken      string `json:"id_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// Token handles POST /token, the OAuth token endpoint. Clients authenticate
+// with their ID and API key as client_secret_basic or client_secret_post, or
+// with their ID and TLS client certificate as tls_client_auth, and get an ID
+// token, and an access token for /userinfo, for one of:
+//
+//   - a code from /authorize (authorization_code); codes from
+//     /auth/{provider} aren't accepted, those are redeemed at /exchange
+//   - a refresh token (refresh_token)
+//   - a device code from /device/code, when devices is set
+//
+// Clients with service tokens can also ask for one for themselves
+// (client_credentials), to authenticate to other services. With identities
+// set, users come with their central ID. A refresh token of a user banned
+// since they signed in is refused, and its sign-in's tokens revoked.
+func Token(clients *client.Registry, codec *exchange.Codec, redeemed store.Store, ids *idtoken.Issuer, refresher *refresh.Service, devices *device.Service, funnel *metrics.Funnel, bus *events.Bus, identities *identity.Store,
+	bans *ban.List) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		r.Body = http.MaxBytesReader(w, r.Body, maxTokenRequestBytes)
+		if err := r.ParseForm(); err != nil {
+			writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "invalid request body")
+			return
+		}
+		clientApp, ok := authenticateOAuthClient(w, r, clients)
+		if !ok {
+			return
+		}
+		r = logWith(r, "client_id", clientApp.ID)
+
+		var (
+			result domain.AuthResult
+			nonce  string
+			flowID string
+			// loginIP is the user's address, for their login history
+			loginIP string
+			// signIn is set for grants that complete a sign-in, rather than
+			// continue one, and so start a refresh token family
+			signIn bool
+			err    error
+		)
+		switch grantType := r.PostForm.Get("grant_type"); grantType {
+		case "authorization_code":
+			payload, ok := redeemOIDCCode(w, r, clientApp, codec, redeemed, funnel, identities)
+			if !ok {
+				return
+			}
+			flowID, nonce, loginIP, signIn = payload.FlowID, payload.OIDC.Nonce, payload.IP, true
+			granted := payload.Scope
+			if granted == "" {
+				granted = scope.Default
+			}
+			result = domain.AuthResult{
+				User:    scope.Filter(payload.User, granted),
+				Factors: payload.Factors,
+				Scope:   granted,
+			}
+		case "refresh_token":
+			if refresher == nil || clientApp.RefreshTokenTTL == 0 {
+				writeOAuthError(w, http.StatusBadRequest, oauthUnauthorizedClient, "client does not use refresh tokens")
+				return
+			}
+			var next string
+			result, next, err = refresher.Refresh(r.Context(), clientApp.ID, r.PostForm.Get("refresh_token"), clientApp.RefreshTokenTTL)
+			switch {
+			case errors.Is(err, domain.ErrRefreshTokenReused):
+				slog.WarnContext(r.Context(), "token: refresh token used twice; revoked its sign-in")
+				writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "refresh token already used")
+				return
+			case errors.Is(err, domain.ErrExpiredRefreshToken):
+				writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "refresh token expired")
+				return
+			case errors.Is(err, domain.ErrInvalidRefreshToken), errors.Is(err, domain.ErrRevokedRefreshToken):
+				writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "invalid refresh token")
+				return
+			case err != nil:
+				slog.ErrorContext(r.Context(), "token: refresh failed", "error", err)
+				writeOAuthError(w, http.StatusServiceUnavailable, oauthTemporarilyUnavailable, "refresh tokens are unavailable, please try again")
+				return
+			}
+			if b, err := refreshBanned(r.Context(), bans, refresher, clientApp, result.User, next); err != nil {
+				writeOAuthError(w, http.StatusServiceUnavailable, oauthTemporarilyUnavailable, "refresh tokens are unavailable, please try again")
+				return
+			} else if b != nil {
+				writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "account is banned")
+				return
+			}
+			result.RefreshToken = next
+		case DeviceCodeGrantType:
+			if devices == nil {
+				writeOAuthError(w, http.StatusBadRequest, oauthUnsupportedGrantType, "")
+				return
+			}
+			result, err = devices.Poll(r.Context(), clientApp.ID, r.PostForm.Get("device_code"))
+			switch {
+			case errors.Is(err, domain.ErrAuthorizationPending):
+				writeOAuthError(w, http.StatusBadRequest, oauthAuthorizationPending, "")
+				return
+			case errors.Is(err, domain.ErrSlowDown):
+				writeOAuthError(w, http.StatusBadRequest, oauthSlowDown, "")
+				return
+			case errors.Is(err, domain.ErrDeviceAccessDenied):
+				writeOAuthError(w, http.StatusBadRequest, oauthAccessDenied, "the user refused the device")
+				return
+			case errors.Is(err, domain.ErrExpiredDeviceCode):
+				writeOAuthError(w, http.StatusBadRequest, oauthExpiredToken, "")
+				return
+			case errors.Is(err, domain.ErrInvalidDeviceCode), errors.Is(err, domain.ErrDeviceCodeUsed):
+				writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "invalid device code")
+				return
+			case err != nil:
+				slog.ErrorContext(r.Context(), "token: device poll failed", "error", err)
+				writeOAuthError(w, http.StatusServiceUnavailable, oauthTemporarilyUnavailable, "device sign-in is unavailable, please try again")
+				return
+			}
+			if result.User.CentralID, err = identities.CentralID(r.Context(), result.User.ProviderName, result.User.ProviderID); err != nil {
+				slog.ErrorContext(r.Context(), "token: looking up the central ID failed", "error", err)
+				writeOAuthError(w, http.StatusServiceUnavailable, oauthTemporarilyUnavailable, "user identities are unavailable, please try again")
+				return
+			}
+			if ip := requestIP(r); ip.IsValid() {
+				loginIP = ip.String()
+			}
+			signIn = true
+		case "client_credentials":
+			serviceToken(w, r, clients, clientApp, ids)
+			return
+		case "":
+			writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "missing grant_type")
+			return
+		default:
+			writeOAuthError(w, http.StatusBadRequest, oauthUnsupportedGrantType, "")
+			return
+		}
+
+		if signIn && refresher != nil && clientApp.RefreshTokenTTL > 0 {
+			result.RefreshToken, err = refresher.Issue(r.Context(), clientApp.ID, result, clientApp.RefreshTokenTTL)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "token: no refresh token issued", "error", err)
+			}
+		}
+		// Stripped here, as at /exchange, so the client's current
+		// strip_fields applies
+		result.User = scope.Strip(result.User, clientApp.StripFields)
+		accessToken, err := ids.Issue(clientApp.ID, result)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "token: signing failed", "error", err)
+			writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "failed to sign tokens")
+			return
+		}
+		idToken, err := ids.IssueOIDC(clientApp.ID, nonce, result)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "token: signing failed", "error", err)
+			writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "failed to sign tokens")
+			return
+		}
+		if signIn {
+			recordLogin(r, identities, clientApp.ID, result.User, loginIP)
+		}
+		if flowID != "" {
+			slog.InfoContext(r.Context(), "token: code redeemed", "flow_id", flowID, "provider", result.User.ProviderName)
+			funnel.Reached(metrics.StageCodeRedeemed, clientApp.ID, result.User.ProviderName)
+			bus.Publish(r.Context(), events.Event{Type: events.ClientExchange, FlowID: flowID, ClientID: clientApp.ID,
+				Provider: result.User.ProviderName, UserID: result.User.ProviderID})
+		}
+		w.Header().Set("Pragma", "no-cache")
+		writeJSON(w, http.StatusOK, tokenResponse{
+			AccessToken:  accessToken,
+			TokenType:    "Bearer",
+			ExpiresIn:    int64(ids.TTL() / time.Second),
+			IDToken:      idToken,
+			RefreshToken: result.RefreshToken,
+			Scope:        oidcScope + " " + result.Scope,
+		})
+	}
+}
+
+// serviceToken answers a client_credentials grant with a service token for
+// clientApp. The optional audience names the client the token is for.
+func serviceToken(w http.ResponseWriter, r *http.Request, clients *client.Registry, clientApp *domain.ClientApp, ids *idtoken.Issuer) {
+	if !clientApp.AllowServiceTokens {
+		writeOAuthError(w, http.StatusBadRequest, oauthUnauthorizedClient, "client does not use service tokens")
+		return
+	}
+	audience := r.PostForm.Get("audience")
+	if audience != "" {
+		if _, err := clients.Get(audience); err != nil {
+			writeOAuthError(w, http.StatusBadRequest, oauthInvalidTarget, "unknown audience")
+			return
+		}
+	}
+	token, err := ids.IssueService(clientApp, audience)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "token: signing failed", "error", err)
+		writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "failed to sign tokens")
+		return
+	}
+	slog.InfoContext(r.Context(), "token: service token issued", "audience", audience)
+	w.Header().Set("Pragma", "no-cache")
+	writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(ids.TTL() / time.Second),
+	})
+}
+
+// authenticateOAuthClient resolves the client from the client ID and API key
+// in the request's Basic credentials or form, or from the client ID and the
+// TLS client certificate the request was made with, writing an invalid_client
+// error and returning false if they don't match an enabled client or the
+// request comes from outside the client's AllowedIPs.
+func authenticateOAuthClient(w http.ResponseWriter, r *http.Request, clients *client.Registry) (*domain.ClientApp, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 form-encodes both before Basic encoding
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	var clientApp *domain.ClientApp
+	var err error
+	cert := peerCertificate(r)
+	if secret == "" && cert != nil {
+		// tls_client_auth (RFC 8705): the certificate stands in for the secret
+		clientApp, err = clients.GetByCertificate(cert)
+	} else {
+		clientApp, err = clients.GetByAPIKey(secret)
+	}
+	if (secret == "" && cert == nil) || err != nil || clientApp.ID != clientID {
+		if (secret != "" || cert != nil) && !errors.Is(err, domain.ErrClientDisabled) {
+			noteFailure(r)
+		}
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="centralauth"`)
+		}
+		writeOAuthError(w, http.StatusUnauthorized, oauthInvalidClient, "")
+		return nil, false
+	}
+	if !presentsCert(clientApp, r) {
+		slog.WarnContext(r.Context(), "token: API key used without the client's certificate", "client_id", clientApp.ID)
+		writeOAuthError(w, http.StatusUnauthorized, oauthInvalidClient, "client certificate required")
+		return nil, false
+	}
+	if ip := requestIP(r); !clientApp.AllowsIP(ip) {
+		slog.WarnContext(r.Context(), "token: API key used from an address the client doesn't allow", "client_id", clientApp.ID, "ip", ip)
+		writeOAuthError(w, http.StatusUnauthorized, oauthInvalidClient, "client not allowed from this address")
+		return nil, false
+	}
+	return clientApp, true
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, oauthError{Error: code, Description: description, RequestID: requestID(w)})
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestToken_ClientCredentials(t *testing.T) {
+	h, _, _, ids := setupOIDC(t)
+
+	rr := postForm(t, h, "/token", url.Values{"grant_type": {"client_credentials"}, "audience": {"forum"}}, "grafana", "grafana-secret")
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	var tokens tokenResponse
+	testutil.ParseJSON(t, rr, &tokens)
+	if tokens.TokenType != "Bearer" || tokens.ExpiresIn != 300 || tokens.IDToken != "" || tokens.RefreshToken != "" {
+		t.Errorf("tokens = %+v", tokens)
+	}
+	var claims idtoken.ServiceClaims
+	if err := ids.Verify(tokens.AccessToken, &claims); err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+	if claims.Subject != "client:grafana" || claims.ClientID != "grafana" || claims.ClientName != "Grafana" || claims.Audience != "forum" {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	// Service tokens identify a client, not a user
+	rr = testutil.DoRequest(t, h, http.MethodGet, "/userinfo", map[string]string{"Authorization": "Bearer " + tokens.AccessToken})
+	testutil.AssertStatus(t, rr, http.StatusUnauthorized)
+}
+
+func TestToken_ClientCertificate(t *testing.T) {
+	h, _, _, _ := setupOIDC(t)
+
+	for _, tt := range []struct {
+		name   string
+		form   url.Values
+		cert   *x509.Certificate
+		status int
+	}{
+		{"tls_client_auth", url.Values{"client_id": {"partner"}}, partnerCert, http.StatusOK},
+		{"secret and certificate", url.Values{"client_id": {"partner"}, "client_secret": {"partner-secret"}}, partnerCert, http.StatusOK},
+		{"secret without certificate", url.Values{"client_id": {"partner"}, "client_secret": {"partner-secret"}}, nil, http.StatusUnauthorized},
+		{"another client's certificate", url.Values{"client_id": {"grafana"}}, partnerCert, http.StatusUnauthorized},
+		{"unknown certificate", url.Values{"client_id": {"partner"}}, &x509.Certificate{Raw: []byte("other")}, http.StatusUnauthorized},
+	} {
+		tt.form.Set("grant_type", "client_credentials")
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(tt.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.cert != nil {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != tt.status {
+			t.Errorf("%s: status %d, want %d (%s)", tt.name, rr.Code, tt.status, rr.Body.String())
+		}
+	}
+}
+
+func TestToken_ClientCredentialsRejected(t *testing.T) {
+	h, _, _, _ := setupOIDC(t)
+
+	tests := []struct {
+		name     string
+		form     url.Values
+		user     string
+		password string
+		status   int
+		err      string
+	}{
+		{"not allowed", url.Values{"grant_type": {"client_credentials"}}, "forum", "forum-secret", http.StatusBadRequest, "unauthorized_client"},
+		{"unknown audience", url.Values{"grant_type": {"client_credentials"}, "audience": {"nope"}}, "grafana", "grafana-secret", http.StatusBadRequest, "invalid_target"},
+		{"wrong secret", url.Values{"grant_type": {"client_credentials"}}, "grafana", "wrong", http.StatusUnauthorized, "invalid_client"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postForm(t, h, "/token", tt.form, tt.user, tt.password)
+			testutil.AssertStatus(t, rr, tt.status)
+			if got := oauthErrorOf(t, rr); got != tt.err {
+				t.Errorf("error = %q, want %q", got, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,380 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/ban"
+	"github.com/BlackMission/centralauth/internal/captcha"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/device"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/events"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/hook"
+	"github.com/BlackMission/centralauth/internal/hosted"
+	"github.com/BlackMission/centralauth/internal/identity"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/internal/pages"
+	"github.com/BlackMission/centralauth/internal/scope"
+	"github.com/BlackMission/centralauth/internal/session"
+	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/store"
+)
+
+// oidcScope is the scope every OpenID Connect request must include.
+const oidcScope = "openid"
+
+type oidcConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint,omitempty"`
+}
+
+// OIDCDiscovery handles GET /.well-known/openid-configuration.
+// publicURL is where the service's routes are reachable. The device
+// authorization endpoint is listed when devices is set.
+func OIDCDiscovery(ids *idtoken.Issuer, devices *device.Service, publicURL string) http.HandlerFunc {
+	publicURL = strings.TrimSuffix(publicURL, "/")
+	doc := oidcConfiguration{
+		Issuer:                            ids.Name(),
+		AuthorizationEndpoint:             publicURL + "/authorize",
+		TokenEndpoint:                     publicURL + "/token",
+		UserInfoEndpoint:                  publicURL + "/userinfo",
+		JWKSURI:                           publicURL + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{ids.Signer().Algorithm()},
+		ScopesSupported:                   []string{oidcScope, scope.Profile, scope.Email, scope.Connections},
+		ClaimsSupported:                   []string{"sub", "provider", "preferred_username", "name", "picture", "email", "email_verified", "locale"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+	}
+	if devices != nil {
+		doc.DeviceAuthorizationEndpoint = publicURL + "/device/code"
+		doc.GrantTypesSupported = append(doc.GrantTypesSupported, DeviceCodeGrantType)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		writeJSON(w, http.StatusOK, doc)
+	}
+}
+
+// OIDCAuthorize handles GET and POST /authorize, the OpenID Connect authorization
+// endpoint. It takes a standard authorization code request, with PKCE if the
+// client uses it, and runs the same flow as /auth/{provider}, ending with a
+// code for /token. The provider is chosen with the provider parameter, or by
+// the user when the client allows more than one. A browser with an SSO
+// session is signed in with it, without the provider, unless the request has
+// prompt=login; prompt=none fails without one.
+//
+// For a client that requires a CAPTCHA, the user always picks the provider
+// on a form with the CAPTCHA, which is posted back to /authorize with the
+// request parameters still in the URL. prompt=none fails for such a client.
+func OIDCAuthorize(clients *client.Registry, providers *auth.Registry, stateService *state.Service, funnel *metrics.Funnel, bus *events.Bus,
+	codec *exchange.Codec, sessions *session.Service, bans *ban.List, hooks hook.Chain, verifier *captcha.Verifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		clientID := q.Get("client_id")
+		if clientID == "" {
+			writeError(w, http.StatusBadRequest, "missing client_id parameter")
+			return
+		}
+		redirectURI := q.Get("redirect_uri")
+		if redirectURI == "" {
+			writeError(w, http.StatusBadRequest, "missing redirect_uri parameter")
+			return
+		}
+		clientApp, err := clients.Get(clientID)
+		if errors.Is(err, domain.ErrClientDisabled) {
+			slog.WarnContext(r.Context(), "authorize: refusing a flow for a disabled client", "client_id", clientID)
+			writeError(w, http.StatusForbidden, "client is disabled")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "unknown client")
+			return
+		}
+		if err := clients.ValidateCallback(clientID, redirectURI); err != nil {
+			writeError(w, http.StatusBadRequest, "redirect_uri not allowed")
+			return
+		}
+
+		// From here on errors go back to the client, which can show them
+		fail := func(code, description string) {
+			redirectOAuthError(w, r, redirectURI, q.Get("state"), code, description)
+		}
+		// Codes from here are redeemed at /token, which public clients
+		// have no credentials for
+		if clientApp.Public {
+			fail(oauthUnauthorizedClient, "public clients sign in at /auth/{provider}")
+			return
+		}
+		if q.Get("response_type") != "code" {
+			fail(oauthUnsupportedResponseType, "only response_type=code is supported")
+			return
+		}
+		requested := strings.Fields(q.Get("scope"))
+		if !slices.Contains(requested, oidcScope) {
+			fail(oauthInvalidScope, "scope must include openid")
+			return
+		}
+		granted := knownScopes(requested)
+
+		challenge := q.Get("code_challenge")
+		if challenge != "" && q.Get("code_challenge_method") != "S256" {
+			fail(oauthInvalidRequest, "code_challenge_method must be S256")
+			return
+		}
+		sess, hasSession := browserSession(r, sessions)
+		silent := slices.Contains(strings.Fields(q.Get("prompt")), "none")
+		if silent && !hasSession {
+			fail(oauthLoginRequired, "the user must sign in with a provider")
+			return
+		}
+
+		names := clientProviders(clientApp, providers)
+		providerName := q.Get("provider")
+		if widget := hosted.Widget(verifier, clientApp.RequireCaptcha); widget != nil {
+			// The chooser's CAPTCHA can't be skipped with the provider
+			// parameter or a session
+			if silent {
+				fail(oauthInteractionRequired, "the user must solve a CAPTCHA")
+				return
+			}
+			if slices.Contains(names, providerName) {
+				names = []string{providerName}
+			}
+			if len(names) == 0 {
+				fail(oauthUnauthorizedClient, "no provider is available to this client")
+				return
+			}
+			if r.Method != http.MethodPost {
+				renderOIDCChooser(w, http.StatusOK, clientApp, q, names, widget, "")
+				return
+			}
+			if err := hosted.VerifyCaptcha(r, verifier, true, clientID); err != nil {
+				renderOIDCChooser(w, http.StatusBadRequest, clientApp, q, names, widget, "Please complete the CAPTCHA.")
+				return
+			}
+			providerName = r.PostFormValue("provider")
+		} else if providerName == "" && hasSession && slices.Contains(names, sess.User.ProviderName) {
+			providerName = sess.User.ProviderName
+		}
+		if providerName == "" {
+			switch len(names) {
+			case 0:
+				fail(oauthUnauthorizedClient, "no provider is available to this client")
+				return
+			case 1:
+				providerName = names[0]
+			default:
+				renderOIDCChooser(w, http.StatusOK, clientApp, q, names, nil, "")
+				return
+			}
+		}
+		provider, err := providers.Get(providerName)
+		if err != nil {
+			fail(oauthInvalidRequest, "unknown provider")
+			return
+		}
+		if err := clients.ValidateProvider(clientID, providerName); err != nil {
+			fail(oauthUnauthorizedClient, "provider not allowed for this client")
+			return
+		}
+
+		payload := domain.StatePayload{
+			ClientID:    clientID,
+			Provider:    providerName,
+			RedirectURI: redirectURI,
+			Scope:       granted,
+			OIDC: &domain.OIDCRequest{
+				State:         q.Get("state"),
+				Nonce:         q.Get("nonce"),
+				CodeChallenge: challenge,
+			},
+		}
+		if hasSession && sessionSignsIn(sess, payload) {
+			resumeSession(w, r, sessions, codec, funnel, bus, bans, hooks, sess, payload)
+			return
+		}
+		if silent {
+			fail(oauthLoginRequired, "the user must sign in with "+providerName)
+			return
+		}
+		startFlow(w, r, stateService, provider, funnel, payload)
+	}
+}
+
+// renderOIDCChooser renders the chooser page for an authorization request
+// with parameters q, offering names. With a CAPTCHA, the page is a form
+// posted back to /authorize.
+func renderOIDCChooser(w http.ResponseWriter, status int, clientApp *domain.ClientApp, q url.Values, names []string, widget *pages.Captcha, msg string) {
+	choices := make([]pages.ProviderLink, len(names))
+	for i, name := range names {
+		pq := maps.Clone(q)
+		pq.Set("provider", name)
+		choices[i] = pages.ProviderLink{Name: name, URL: "?" + pq.Encode()}
+	}
+	name := clientApp.Name
+	if name == "" {
+		name = clientApp.ID
+	}
+	pages.Render(w, status, "choose_provider.html", pages.ProviderChoice{
+		Client:    name,
+		Providers: choices,
+		Action:    "?" + q.Encode(),
+		Error:     msg,
+		Captcha:   widget,
+	})
+}
+
+// knownScopes grants the CentralAuth scopes among requested. Others, such as
+// openid and offline_access, are ignored as OAuth allows; none grants the
+// default.
+func knownScopes(requested []string) string {
+	var known []string
+	for _, s := range requested {
+		if _, err := scope.Parse(s); err == nil {
+			known = append(known, s)
+		}
+	}
+	granted, _ := scope.Parse(strings.Join(known, " "))
+	return granted
+}
+
+// redeemOIDCCode checks an authorization_code grant's code against the
+// authorization request it was issued for, looks up the user's central ID in
+// identities and spends it, writing an OAuth error and returning false if it
+// can't be redeemed.
+func redeemOIDCCode(w http.ResponseWriter, r *http.Request, clientApp *domain.ClientApp, codec *exchange.Codec, redeemed store.Store, funnel *metrics.Funnel, identities *identity.Store) (*domain.ExchangePayload, bool) {
+	code := r.PostForm.Get("code")
+	if code == "" {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "missing code")
+		return nil, false
+	}
+	payload, err := codec.DecodeFor(code, clientApp.ID)
+	if err != nil {
+		reason := "code_invalid"
+		if errors.Is(err, domain.ErrExpiredExchangeCode) {
+			reason = "code_expired"
+		} else {
+			noteFailure(r)
+		}
+		funnel.Dropped(metrics.StageCodeRedeemed, reason, clientApp.ID, "")
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "invalid or expired code")
+		return nil, false
+	}
+	if payload.OIDC == nil || payload.ClientID != clientApp.ID {
+		funnel.Dropped(metrics.StageCodeRedeemed, "code_invalid", clientApp.ID, payload.User.ProviderName)
+		noteFailure(r)
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "invalid or expired code")
+		return nil, false
+	}
+	if r.PostForm.Get("redirect_uri") != payload.RedirectURI {
+		funnel.Dropped(metrics.StageCodeRedeemed, "redirect_mismatch", clientApp.ID, payload.User.ProviderName)
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "redirect_uri does not match the authorization request")
+		return nil, false
+	}
+	if !verifyPKCE(payload.OIDC.CodeChallenge, r.PostForm.Get("code_verifier")) {
+		funnel.Dropped(metrics.StageCodeRedeemed, "pkce_mismatch", clientApp.ID, payload.User.ProviderName)
+		slog.WarnContext(r.Context(), "token: code presented with the wrong code_verifier", "flow_id", payload.FlowID)
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "code_verifier does not match the code_challenge")
+		return nil, false
+	}
+	// Looked up before the code is spent, as at /exchange
+	if payload.User.CentralID, err = identities.CentralID(r.Context(), payload.User.ProviderName, payload.User.ProviderID); err != nil {
+		slog.ErrorContext(r.Context(), "token: looking up the central ID failed", "error", err)
+		writeOAuthError(w, http.StatusServiceUnavailable, oauthTemporarilyUnavailable, "user identities are unavailable, please try again")
+		return nil, false
+	}
+
+	// Spent as at /exchange: a store that fails lets the code through
+	if redeemed != nil {
+		first, err := redeemed.Add(r.Context(), redeemedPrefix+fingerprintOf(code), []byte(clientApp.ID), max(time.Until(payload.ExpiresAt), time.Second))
+		if err != nil {
+			slog.WarnContext(r.Context(), "token: store failed; not checking for reuse", "flow_id", payload.FlowID, "store", redeemed.String(), "error", err)
+		} else if !first {
+			funnel.Dropped(metrics.StageCodeRedeemed, "code_reused", clientApp.ID, payload.User.ProviderName)
+			slog.WarnContext(r.Context(), "token: code redeemed again", "flow_id", payload.FlowID)
+			writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "code already used")
+			return nil, false
+		}
+	}
+	return payload, true
+}
+
+// verifyPKCE reports whether verifier answers an S256 challenge. A request
+// made without a challenge must be redeemed without a verifier.
+func verifyPKCE(challenge, verifier string) bool {
+	if challenge == "" {
+		return verifier == ""
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
+// OIDCUserInfo handles GET and POST /userinfo. It returns the claims about
+// the user an access token from /token was issued for.
+func OIDCUserInfo(ids *idtoken.Issuer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(authHeader, "Bearer ")
+		if token == "" || token == authHeader {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="centralauth"`)
+			writeOAuthError(w, http.StatusUnauthorized, oauthInvalidRequest, "missing access token")
+			return
+		}
+		claims, err := ids.Check(token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="centralauth", error="invalid_token"`)
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, idtoken.UserClaims(claims.User))
+	}
+}
+
+// redirectOAuthError sends an authorization error back to the client's
+// redirect URI.
+func redirectOAuthError(w http.ResponseWriter, r *http.Request, redirectURI, state, code, description string) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "redirect_uri not allowed")
+		return
+	}
+	q := u.Query()
+	q.Set("error", code)
+	if description != "" {
+		q.Set("error_description", description)
+	}
+	if state != "" {
+		q.Set("state", state)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
@@ -0,0 +1,343 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/internal/refresh"
+	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/store"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+const oidcVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func oidcChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func setupOIDC(t *testing.T) (http.Handler, *exchange.Codec, *state.Service, *idtoken.Issuer) {
+	t.Helper()
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{
+			ID:               "grafana",
+			Name:             "Grafana",
+			APIKey:           "grafana-secret",
+			AllowedCallbacks: []string{"https://grafana.example.com/login/generic_oauth"},
+			AllowedProviders: []string{"discord"},
+			RefreshTokenTTL:  time.Hour,
+
+			AllowServiceTokens: true,
+		},
+		{
+			ID:               "forum",
+			APIKey:           "forum-secret",
+			AllowedCallbacks: []string{"https://forum.example.com/auth/oidc/callback"},
+			AllowedProviders: []string{"discord", "github"},
+			StripFields:      []string{"email"},
+		},
+		{
+			ID:                 "partner",
+			APIKey:             "partner-secret",
+			CertFingerprints:   []string{client.CertFingerprint(partnerCert)},
+			AllowServiceTokens: true,
+		},
+	})
+	providers := auth.NewRegistry()
+	providers.Register(&stubProvider{name: "discord", authURL: "https://discord.com/oauth2/authorize"})
+	providers.Register(&stubProvider{name: "github", authURL: "https://github.com/login/oauth/authorize"})
+	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	signer, _ := idtoken.NewSigner(testutil.SigningKeyPEM(t))
+	ids := idtoken.NewIssuer(signer, "https://auth.example.com", 0)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", OIDCDiscovery(ids, nil, "https://auth.example.com/"))
+	mux.HandleFunc("GET /authorize", OIDCAuthorize(clients, providers, stateSvc, nil, nil, codec, nil, nil, nil, nil))
+	mux.HandleFunc("POST /token", Token(clients, codec, store.NewMemory(), ids, refresh.New(store.NewMemory()), nil, nil, nil, nil, nil))
+	mux.HandleFunc("GET /userinfo", OIDCUserInfo(ids))
+	mux.HandleFunc("GET /exchange", Exchange(clients, codec, nil, nil, nil, nil, nil, nil, nil))
+	return mux, codec, stateSvc, ids
+}
+
+// oidcCode returns a code as the callback would issue it at the end of an
+// /authorize flow for grafana.
+func oidcCode(t *testing.T, codec *exchange.Codec, challenge string) string {
+	t.Helper()
+	code, err := codec.Encode(domain.ExchangePayload{
+		ClientID:    "grafana",
+		RedirectURI: "https://grafana.example.com/login/generic_oauth",
+		Scope:       "profile email",
+		User:        domain.UserInfo{ProviderName: "discord", ProviderID: "123", Username: "testuser", Email: "test@example.com"},
+		OIDC:        &domain.OIDCRequest{State: "xyz", Nonce: "n-0S6", CodeChallenge: challenge},
+	})
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+	return code
+}
+
+func postForm(t *testing.T, h http.Handler, path string, form url.Values, basicUser, basicPass string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basicUser != "" {
+		req.SetBasicAuth(basicUser, basicPass)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func codeGrant(code, verifier string) url.Values {
+	return url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {"https://grafana.example.com/login/generic_oauth"},
+		"code_verifier": {verifier},
+	}
+}
+
+func TestOIDCDiscovery(t *testing.T) {
+	h, _, _, _ := setupOIDC(t)
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/.well-known/openid-configuration", nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+
+	var doc map[string]any
+	testutil.ParseJSON(t, rr, &doc)
+	if doc["issuer"] != "https://auth.example.com" || doc["token_endpoint"] != "https://auth.example.com/token" ||
+		doc["jwks_uri"] != "https://auth.example.com/.well-known/jwks.json" {
+		t.Errorf("discovery = %v", doc)
+	}
+}
+
+func TestOIDCAuthorize_StartsFlow(t *testing.T) {
+	h, _, stateSvc, _ := setupOIDC(t)
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/authorize?response_type=code&client_id=grafana"+
+		"&redirect_uri=https://grafana.example.com/login/generic_oauth&scope=openid+email+offline_access"+
+		"&state=xyz&nonce=n-0S6&code_challenge="+oidcChallenge(oidcVerifier)+"&code_challenge_method=S256", nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	if loc.Host != "discord.com" {
+		t.Fatalf("redirected to %s, want the only allowed provider", loc)
+	}
+	payload, err := stateSvc.Validate(loc.Query().Get("state"))
+	if err != nil {
+		t.Fatalf("Validate error: %v", err)
+	}
+	want := domain.OIDCRequest{State: "xyz", Nonce: "n-0S6", CodeChallenge: oidcChallenge(oidcVerifier)}
+	if payload.OIDC == nil || *payload.OIDC != want || payload.Scope != "email" {
+		t.Errorf("payload = %+v, OIDC %+v", payload, payload.OIDC)
+	}
+}
+
+func TestOIDCAuthorize_ChoosesProvider(t *testing.T) {
+	h, _, _, _ := setupOIDC(t)
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/authorize?response_type=code&client_id=forum"+
+		"&redirect_uri=https://forum.example.com/auth/oidc/callback&scope=openid", nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	if body := rr.Body.String(); !strings.Contains(body, "provider=github") || !strings.Contains(body, "provider=discord") {
+		t.Errorf("expected a link for each provider, got %s", body)
+	}
+
+	rr = testutil.DoRequest(t, h, http.MethodGet, "/authorize?response_type=code&client_id=forum"+
+		"&redirect_uri=https://forum.example.com/auth/oidc/callback&scope=openid&provider=github", nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	if loc, _ := url.Parse(rr.Header().Get("Location")); loc.Host != "github.com" {
+		t.Errorf("redirected to %s, want github", loc)
+	}
+}
+
+func TestOIDCAuthorize_Captcha(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "wiki", Name: "Wiki", APIKey: "wiki-secret", RequireCaptcha: true, AllowedCallbacks: []string{"https://wiki.example.com/callback"}, AllowedProviders: []string{"discord"}},
+	})
+	providers := auth.NewRegistry()
+	providers.Register(&stubProvider{name: "discord", authURL: "https://discord.com/oauth2/authorize"})
+	authorize := OIDCAuthorize(clients, providers, state.NewService([]byte("test-key-1234567890abcdef")), nil, nil, nil, nil, nil, nil, newTestCaptcha(t))
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /authorize", authorize)
+	mux.HandleFunc("POST /authorize", authorize)
+
+	// Shown even for a single provider named in the request
+	path := "/authorize?response_type=code&client_id=wiki&redirect_uri=https://wiki.example.com/callback&scope=openid&state=xyz&provider=discord"
+	rr := testutil.DoRequest(t, mux, http.MethodGet, path, nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	if body := rr.Body.String(); !strings.Contains(body, `data-sitekey="site-key"`) || !strings.Contains(body, `value="discord"`) {
+		t.Errorf("expected a form with the CAPTCHA widget, got %s", body)
+	}
+
+	rr = postForm(t, mux, path, url.Values{"provider": {"discord"}, "cf-turnstile-response": {"bad-token"}}, "", "")
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	if !strings.Contains(rr.Body.String(), "Please complete the CAPTCHA.") {
+		t.Errorf("expected the page again with an error, got %s", rr.Body)
+	}
+	rr = postForm(t, mux, path, url.Values{"provider": {"discord"}, "cf-turnstile-response": {"good-token"}}, "", "")
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	if loc, _ := url.Parse(rr.Header().Get("Location")); loc.Host != "discord.com" {
+		t.Errorf("redirected to %s, want discord", loc)
+	}
+}
+
+func TestOIDCAuthorize_Errors(t *testing.T) {
+	h, _, _, _ := setupOIDC(t)
+	base := "/authorize?client_id=grafana&redirect_uri=https://grafana.example.com/login/generic_oauth&state=xyz"
+
+	tests := []struct {
+		name, query, want string
+	}{
+		{"response type", "&response_type=token&scope=openid", "unsupported_response_type"},
+		{"no openid", "&response_type=code&scope=profile", "invalid_scope"},
+		{"plain pkce", "&response_type=code&scope=openid&code_challenge=abc&code_challenge_method=plain", "invalid_request"},
+		{"prompt none", "&response_type=code&scope=openid&prompt=none", "login_required"},
+		{"provider", "&response_type=code&scope=openid&provider=github", "unauthorized_client"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := testutil.DoRequest(t, h, http.MethodGet, base+tt.query, nil)
+			testutil.AssertStatus(t, rr, http.StatusFound)
+			loc, _ := url.Parse(rr.Header().Get("Location"))
+			if loc.Host != "grafana.example.com" || loc.Query().Get("error") != tt.want || loc.Query().Get("state") != "xyz" {
+				t.Errorf("Location = %s, want error %s", loc, tt.want)
+			}
+		})
+	}
+
+	// Without a valid redirect_uri there is nowhere safe to send the error
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/authorize?client_id=grafana&redirect_uri=https://evil.example.com/&response_type=code&scope=openid", nil)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestOIDCToken_AuthorizationCode(t *testing.T) {
+	h, codec, _, ids := setupOIDC(t)
+	rr := postForm(t, h, "/token", codeGrant(oidcCode(t, codec, oidcChallenge(oidcVerifier)), oidcVerifier), "grafana", "grafana-secret")
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Error("expected Cache-Control: no-store")
+	}
+
+	var resp map[string]any
+	testutil.ParseJSON(t, rr, &resp)
+	if resp["token_type"] != "Bearer" || resp["scope"] != "openid profile email" || resp["refresh_token"] == nil {
+		t.Errorf("response = %v", resp)
+	}
+	var claims map[string]any
+	if err := ids.Verify(resp["id_token"].(string), &claims); err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+	if claims["sub"] != "discord:123" || claims["aud"] != "grafana" || claims["nonce"] != "n-0S6" || claims["email"] != "test@example.com" {
+		t.Errorf("ID token claims = %v", claims)
+	}
+
+	// The access token is good for /userinfo
+	rr = testutil.DoRequest(t, h, http.MethodGet, "/userinfo", map[string]string{"Authorization": "Bearer " + resp["access_token"].(string)})
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	var info map[string]any
+	testutil.ParseJSON(t, rr, &info)
+	if info["sub"] != "discord:123" || info["preferred_username"] != "testuser" {
+		t.Errorf("userinfo = %v", info)
+	}
+}
+
+func TestOIDCToken_ClientSecretPost(t *testing.T) {
+	h, codec, _, _ := setupOIDC(t)
+	form := codeGrant(oidcCode(t, codec, ""), "")
+	form.Set("client_id", "grafana")
+	form.Set("client_secret", "grafana-secret")
+	testutil.AssertStatus(t, postForm(t, h, "/token", form, "", ""), http.StatusOK)
+}
+
+func TestOIDCToken_Rejected(t *testing.T) {
+	h, codec, _, _ := setupOIDC(t)
+	challenge := oidcChallenge(oidcVerifier)
+
+	tests := []struct {
+		name       string
+		form       url.Values
+		user, pass string
+		status     int
+		want       string
+	}{
+		{"wrong secret", codeGrant(oidcCode(t, codec, challenge), oidcVerifier), "grafana", "forum-secret", http.StatusUnauthorized, "invalid_client"},
+		{"wrong verifier", codeGrant(oidcCode(t, codec, challenge), "not-the-verifier"), "grafana", "grafana-secret", http.StatusBadRequest, "invalid_grant"},
+		{"missing verifier", codeGrant(oidcCode(t, codec, challenge), ""), "grafana", "grafana-secret", http.StatusBadRequest, "invalid_grant"},
+		{"grant type", url.Values{"grant_type": {"password"}}, "grafana", "grafana-secret", http.StatusBadRequest, "unsupported_grant_type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postForm(t, h, "/token", tt.form, tt.user, tt.pass)
+			testutil.AssertStatus(t, rr, tt.status)
+			var resp oauthError
+			testutil.ParseJSON(t, rr, &resp)
+			if resp.Error != tt.want {
+				t.Errorf("error = %q, want %q", resp.Error, tt.want)
+			}
+		})
+	}
+
+	t.Run("redirect uri", func(t *testing.T) {
+		form := codeGrant(oidcCode(t, codec, challenge), oidcVerifier)
+		form.Set("redirect_uri", "https://grafana.example.com/other")
+		testutil.AssertStatus(t, postForm(t, h, "/token", form, "grafana", "grafana-secret"), http.StatusBadRequest)
+	})
+	t.Run("reused", func(t *testing.T) {
+		form := codeGrant(oidcCode(t, codec, challenge), oidcVerifier)
+		testutil.AssertStatus(t, postForm(t, h, "/token", form, "grafana", "grafana-secret"), http.StatusOK)
+		testutil.AssertStatus(t, postForm(t, h, "/token", form, "grafana", "grafana-secret"), http.StatusBadRequest)
+	})
+	t.Run("exchange code", func(t *testing.T) {
+		code, _ := codec.Encode(domain.ExchangePayload{
+			ClientID:    "grafana",
+			RedirectURI: "https://grafana.example.com/login/generic_oauth",
+			User:        domain.UserInfo{ProviderName: "discord", ProviderID: "123"},
+		})
+		testutil.AssertStatus(t, postForm(t, h, "/token", codeGrant(code, ""), "grafana", "grafana-secret"), http.StatusBadRequest)
+	})
+}
+
+func TestOIDCToken_Refresh(t *testing.T) {
+	h, codec, _, _ := setupOIDC(t)
+	rr := postForm(t, h, "/token", codeGrant(oidcCode(t, codec, ""), ""), "grafana", "grafana-secret")
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	var first tokenResponse
+	testutil.ParseJSON(t, rr, &first)
+
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {first.RefreshToken}}
+	rr = postForm(t, h, "/token", form, "grafana", "grafana-secret")
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	var second tokenResponse
+	testutil.ParseJSON(t, rr, &second)
+	if second.IDToken == "" || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Errorf("refresh response = %+v", second)
+	}
+	testutil.AssertStatus(t, postForm(t, h, "/token", form, "grafana", "grafana-secret"), http.StatusBadRequest)
+}
+
+func TestExchange_RefusesOIDCCode(t *testing.T) {
+	h, codec, _, _ := setupOIDC(t)
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/exchange?code="+url.QueryEscape(oidcCode(t, codec, "")),
+		map[string]string{"Authorization": "Bearer grafana-secret"})
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestOIDCUserInfo_InvalidToken(t *testing.T) {
+	h, _, _, _ := setupOIDC(t)
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/userinfo", map[string]string{"Authorization": "Bearer not-a-token"})
+	testutil.AssertStatus(t, rr, http.StatusUnauthorized)
+	if !strings.Contains(rr.Header().Get("WWW-Authenticate"), "invalid_token") {
+		t.Errorf("WWW-Authenticate = %q", rr.Header().Get("WWW-Authenticate"))
+	}
+}
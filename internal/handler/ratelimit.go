@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/pages"
+	"github.com/BlackMission/centralauth/internal/ratelimit"
+)
+
+// ClientRateLimited wraps an auth-initiating handler so that each client's
+// flows, identified by the client_id parameter, are limited to the client's
+// rate limit. Requests for unknown clients pass through to be rejected by
+// next. A nil limiter disables limiting.
+func ClientRateLimited(clients *client.Registry, limiter *ratelimit.Limiter, next http.HandlerFunc) http.HandlerFunc {
+	if limiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientID := r.URL.Query().Get("client_id")
+		if _, err := clients.Get(clientID); err == nil {
+			if ok, retry := limiter.Allow(r.Context(), "auth/"+clientID, clients.RateLimit(clientID)); !ok {
+				slog.WarnContext(r.Context(), "ratelimit: client is over its limit for new flows", "client_id", clientID)
+				setRetryAfter(w, retry)
+				pages.Render(w, http.StatusTooManyRequests, "unavailable.html", pages.Unavailable{
+					Title:   "Too many sign-in attempts",
+					Message: "This app is starting more sign-ins than allowed. Please try again in a minute.",
+				})
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// APIKeyRateLimited wraps an API handler so that each client's requests,
+// identified by the API key or client certificate they carry, are limited to
+// the client's rate limit. The primary and secondary keys share one budget,
+// and public clients, which have no key, are identified by their client_id
+// parameter.
+// Requests without a valid key pass through to be rejected by next. A nil
+// limiter disables limiting.
+func APIKeyRateLimited(clients *client.Registry, limiter *ratelimit.Limiter, next http.HandlerFunc) http.HandlerFunc {
+	if limiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := keyedClient(clients, r)
+		if c == nil {
+			if p, err := clients.Get(r.URL.Query().Get("client_id")); err == nil && p.Public {
+				c = p
+			}
+		}
+		if c != nil {
+			if ok, retry := limiter.Allow(r.Context(), "api/"+c.ID, clients.RateLimit(c.ID)); !ok {
+				slog.WarnContext(r.Context(), "ratelimit: client is over its API limit", "client_id", c.ID)
+				setRetryAfter(w, retry)
+				writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// keyedClient returns the client whose API key, as a bearer token or Basic
+// credentials, or, without one, client certificate r carries, or nil.
+func keyedClient(clients *client.Registry, r *http.Request) *domain.ClientApp {
+	var c *domain.ClientApp
+	if apiKey, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && apiKey != "" {
+		c, _ = clients.GetByAPIKey(apiKey)
+	} else if _, secret, ok := r.BasicAuth(); ok && secret != "" {
+		secret, _ = url.QueryUnescape(secret)
+		c, _ = clients.GetByAPIKey(secret)
+	} else if cert := peerCertificate(r); cert != nil {
+		c, _ = clients.GetByCertificate(cert)
+	}
+	return c
+}
+
+// IPLimits limits requests by the client IP they come from and across all
+// IPs.
+type IPLimits struct {
+	PerIP  domain.RateLimit
+	Global domain.RateLimit
+}
+
+// IPRateLimited wraps a browser-facing handler so that requests are limited
+// per client IP, as found by ClientIP, and overall. IPv6 clients are limited
+// per /64, which a single host can usually draw addresses from. A nil
+// limiter disables limiting.
+func IPRateLimited(limits IPLimits, limiter *ratelimit.Limiter, next http.HandlerFunc) http.HandlerFunc {
+	if limiter == nil || (limits.PerIP.IsZero() && limits.Global.IsZero()) {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := ipKey(requestIP(r))
+		ok, retry := limiter.Allow(r.Context(), "ip/"+ip, limits.PerIP)
+		if !ok {
+			slog.WarnContext(r.Context(), "ratelimit: IP is over its limit", "ip", ip, "path", r.URL.Path)
+		} else if ok, retry = limiter.Allow(r.Context(), "global", limits.Global); !ok {
+			slog.WarnContext(r.Context(), "ratelimit: over the global limit", "path", r.URL.Path)
+		}
+		if !ok {
+			setRetryAfter(w, retry)
+			pages.Render(w, http.StatusTooManyRequests, "unavailable.html", pages.Unavailable{
+				Title:   "Too many requests",
+				Message: "Too many sign-in requests are coming in right now. Please try again in a minute.",
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ipKey is the rate limit key for ip: the address itself, or its /64 for
+// IPv6.
+func ipKey(ip netip.Addr) string {
+	if ip.Is6() {
+		p, _ := ip.Prefix(64)
+		return p.String()
+	}
+	return ip.String()
+}
+
+// setRetryAfter sets the Retry-After header to retry, in whole seconds.
+func setRetryAfter(w http.ResponseWriter, retry time.Duration) {
+	secs := max(1, int(math.Ceil(retry.Seconds())))
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+}
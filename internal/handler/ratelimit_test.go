@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/ratelimit"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func setupRateLimit(t *testing.T) (*client.Registry, *ratelimit.Limiter) {
+	t.Helper()
+	clients, err := client.NewRegistry([]domain.ClientApp{
+		{ID: "website", APIKey: "web-key"},
+		{ID: "game", APIKey: "game-key", RateLimit: domain.RateLimit{Requests: 1, Per: time.Minute}},
+	})
+	if err != nil {
+		t.Fatalf("NewRegistry error: %v", err)
+	}
+	clients.SetRateLimit(domain.RateLimit{Requests: 2, Per: time.Minute})
+	return clients, ratelimit.New(ratelimit.NewMemory())
+}
+
+func ok(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestClientRateLimited(t *testing.T) {
+	clients, limiter := setupRateLimit(t)
+	h := ClientRateLimited(clients, limiter, ok)
+
+	for range 2 {
+		rr := testutil.DoRequest(t, h, http.MethodGet, "/auth/discord?client_id=website", nil)
+		testutil.AssertStatus(t, rr, http.StatusOK)
+	}
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/auth/discord?client_id=website", nil)
+	testutil.AssertStatus(t, rr, http.StatusTooManyRequests)
+	if rr.Header().Get("Retry-After") != "30" {
+		t.Errorf("Retry-After = %q, want 30", rr.Header().Get("Retry-After"))
+	}
+
+	// The client's own limit applies instead of the default
+	testutil.AssertStatus(t, testutil.DoRequest(t, h, http.MethodGet, "/auth/discord?client_id=game", nil), http.StatusOK)
+	testutil.AssertStatus(t, testutil.DoRequest(t, h, http.MethodGet, "/auth/discord?client_id=game", nil), http.StatusTooManyRequests)
+
+	// Unknown clients are left to the handler
+	for range 3 {
+		testutil.AssertStatus(t, testutil.DoRequest(t, h, http.MethodGet, "/auth/discord?client_id=nope", nil), http.StatusOK)
+	}
+}
+
+func TestAPIKeyRateLimited(t *testing.T) {
+	clients, limiter := setupRateLimit(t)
+	h := APIKeyRateLimited(clients, limiter, ok)
+	auth := map[string]string{"Authorization": "Bearer game-key"}
+
+	testutil.AssertStatus(t, testutil.DoRequest(t, h, http.MethodGet, "/exchange?code=x", auth), http.StatusOK)
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/exchange?code=x", auth)
+	testutil.AssertStatus(t, rr, http.StatusTooManyRequests)
+	if rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("Retry-After = %q, want 60", rr.Header().Get("Retry-After"))
+	}
+
+	// API requests have their own budget, apart from new flows
+	testutil.AssertStatus(t, testutil.DoRequest(t, ClientRateLimited(clients, limiter, ok), http.MethodGet, "/auth/discord?client_id=game", nil), http.StatusOK)
+
+	// Invalid keys are left to the handler
+	bad := map[string]string{"Authorization": "Bearer wrong-key"}
+	for range 3 {
+		testutil.AssertStatus(t, testutil.DoRequest(t, h, http.MethodGet, "/exchange?code=x", bad), http.StatusOK)
+	}
+}
+
+// fromIP serves a GET /auth/discord request from remoteAddr with the given
+// X-Forwarded-For header.
+func fromIP(h http.HandlerFunc, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/auth/discord", nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	rr := httptest.NewRecorder()
+	h(rr, req)
+	return rr
+}
+
+func TestIPRateLimited(t *testing.T) {
+	limits := IPLimits{PerIP: domain.RateLimit{Requests: 1, Per: time.Minute}}
+	h := IPRateLimited(limits, ratelimit.New(ratelimit.NewMemory()), ok)
+
+	testutil.AssertStatus(t, fromIP(h, "192.0.2.1:1234", ""), http.StatusOK)
+	rr := fromIP(h, "192.0.2.1:5678", "")
+	testutil.AssertStatus(t, rr, http.StatusTooManyRequests)
+	if rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("Retry-After = %q, want 60", rr.Header().Get("Retry-After"))
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want an HTML page", ct)
+	}
+	testutil.AssertStatus(t, fromIP(h, "192.0.2.2:1234", ""), http.StatusOK)
+
+	// An untrusted peer can't pick its address
+	testutil.AssertStatus(t, fromIP(h, "192.0.2.1:1234", "198.51.100.1"), http.StatusTooManyRequests)
+
+	// IPv6 clients share a bucket per /64
+	testutil.AssertStatus(t, fromIP(h, "[2001:db8::1]:1234", ""), http.StatusOK)
+	testutil.AssertStatus(t, fromIP(h, "[2001:db8::2]:1234", ""), http.StatusTooManyRequests)
+}
+
+func TestIPRateLimited_Global(t *testing.T) {
+	limits := IPLimits{Global: domain.RateLimit{Requests: 2, Per: time.Minute}}
+	h := IPRateLimited(limits, ratelimit.New(ratelimit.NewMemory()), ok)
+
+	testutil.AssertStatus(t, fromIP(h, "192.0.2.1:1234", ""), http.StatusOK)
+	testutil.AssertStatus(t, fromIP(h, "192.0.2.2:1234", ""), http.StatusOK)
+	testutil.AssertStatus(t, fromIP(h, "192.0.2.3:1234", ""), http.StatusTooManyRequests)
+}
+
+func TestRateLimited_NilLimiter(t *testing.T) {
+	clients, _ := setupRateLimit(t)
+	h := APIKeyRateLimited(clients, nil, ok)
+	for range 3 {
+		rr := testutil.DoRequest(t, h, http.MethodGet, "/exchange", map[string]string{"Authorization": "Bearer game-key"})
+		testutil.AssertStatus(t, rr, http.StatusOK)
+	}
+}
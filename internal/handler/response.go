@@ -3,10 +3,17 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/BlackMission/centralauth/internal/logging"
+	"github.com/BlackMission/centralauth/internal/pages"
 )
 
 type errorResponse struct {
-	Error string `json:"error"`
+	Error     string `json:"error"`
+	FlowID    string `json:"flow_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -16,5 +23,63 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 }
 
 func writeError(w http.ResponseWriter, status int, msg string) {
-	writeJSON(w, status, errorResponse{Error: msg})
+	writeJSON(w, status, errorResponse{Error: msg, RequestID: requestID(w)})
+}
+
+// writeFlowError writes an error that includes the flow ID, so a user-reported
+// failure can be matched to the server's log lines for that login.
+func writeFlowError(w http.ResponseWriter, status int, msg, flowID string) {
+	writeJSON(w, status, errorResponse{Error: msg, FlowID: flowID, RequestID: requestID(w)})
+}
+
+// requestID returns the ID the server gave the request w answers, which it
+// sets on the response before any handler runs.
+func requestID(w http.ResponseWriter) string {
+	return w.Header().Get(logging.RequestIDHeader)
+}
+
+// writePageError writes an error on a route the user's browser visits: the
+// error page when the request accepts HTML, and the usual JSON otherwise.
+// The page links back to the client at returnTo, which must come from a
+// validated redirect_uri; a zero clientLink leaves the link out.
+func writePageError(w http.ResponseWriter, r *http.Request, status int, msg, flowID string, returnTo clientLink) {
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		writeFlowError(w, status, msg, flowID)
+		return
+	}
+	pages.Render(w, status, "error.html", pages.Error{
+		Message:   msg,
+		FlowID:    flowID,
+		RequestID: requestID(w),
+		Client:    returnTo.Client,
+		ReturnURL: returnTo.URL,
+	})
+}
+
+// clientLink is the "return to the app" link on an error page.
+type clientLink struct {
+	Client string
+	URL    string
+}
+
+// linkBack returns the link back to the client named client, to the root of
+// the site its validated redirectURI is on. Redirect URIs that aren't web
+// pages, such as an app's custom scheme, get no link, as following one
+// without a code would leave the app waiting.
+func linkBack(client, redirectURI string) clientLink {
+	u, err := url.Parse(redirectURI)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return clientLink{}
+	}
+	return clientLink{Client: client, URL: u.Scheme + "://" + u.Host + "/"}
+}
+
+// flowLink is linkBack for a flow already under way, whose redirect_uri was
+// validated when it started. Flows for a device end on our own /device
+// pages, so they get no link.
+func flowLink(redirectURI, device string) clientLink {
+	if device != "" {
+		return clientLink{}
+	}
+	return linkBack("", redirectURI)
 }
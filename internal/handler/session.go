@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/BlackMission/centralauth/internal/ban"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/events"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/hook"
+	"github.com/BlackMission/centralauth/internal/logout"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/internal/mfa"
+	"github.com/BlackMission/centralauth/internal/session"
+)
+
+// startSession starts an SSO session for a user who has just signed in to
+// clientID, so that the browser can sign in to other clients without the
+// provider. A session is a convenience: failing to start one doesn't fail
+// the sign-in.
+func startSession(w http.ResponseWriter, r *http.Request, sessions *session.Service, clientID string, user domain.UserInfo, factors []string, flowID string) {
+	if sessions == nil {
+		return
+	}
+	sess, err := sessions.Create(r.Context(), clientID, user, factors)
+	if err != nil {
+		slog.WarnContext(r.Context(), "session: starting SSO session failed", "error", err)
+		return
+	}
+	http.SetCookie(w, sessions.Cookie(sess))
+}
+
+// browserSession returns the SSO session of the browser that sent r, unless
+// it asked to sign in again with prompt=login.
+func browserSession(r *http.Request, sessions *session.Service) (session.Session, bool) {
+	if sessions == nil || slices.Contains(strings.Fields(r.URL.Query().Get("prompt")), "login") {
+		return session.Session{}, false
+	}
+	sess, err := sessions.FromRequest(r)
+	if err != nil {
+		if !errors.Is(err, domain.ErrInvalidSession) {
+			slog.WarnContext(r.Context(), "session: reading SSO session failed", "error", err)
+		}
+		return session.Session{}, false
+	}
+	return sess, true
+}
+
+// sessionSignsIn reports whether sess can sign in the flow in payload: it
+// must be with the same provider, and have a second factor if the flow asks
+// for one. Flows that want the provider's raw profile or tokens, which
+// sessions don't keep, always go to the provider.
+func sessionSignsIn(sess session.Session, payload domain.StatePayload) bool {
+	if payload.Raw || payload.Tokens || sess.User.ProviderName != payload.Provider {
+		return false
+	}
+	return payload.ACR != mfa.ACR2FA || slices.Contains(sess.Factors, mfa.FactorTOTP)
+}
+
+// resumeSession completes the flow in payload with sess instead of the
+// provider, redirecting straight back to the client with an exchange code,
+// unless the user has been banned since the session started. The post-auth
+// hooks run for the flow's client as they would at the callback, on a copy
+// of the session's user.
+func resumeSession(w http.ResponseWriter, r *http.Request, sessions *session.Service, codec *exchange.Codec, funnel *metrics.Funnel, bus *events.Bus,
+	bans *ban.List, hooks hook.Chain, sess session.Session, payload domain.StatePayload) {
+	flowID, err := newFlowID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to generate flow ID")
+		return
+	}
+	r = logWith(r, "flow_id", flowID)
+	if refuseBanned(w, r, bans, funnel, bus, &payload, sess.User, flowID) {
+		return
+	}
+	result := &domain.AuthResult{User: sess.User, Factors: sess.Factors}
+	result.User.Roles = slices.Clone(result.User.Roles)
+	if refuseByHooks(w, r, hooks, funnel, bus, &payload, result, flowID) {
+		return
+	}
+	slog.InfoContext(r.Context(), "authorize: signed in with an existing session")
+	// Remembered so that the client is told when the user signs out
+	if err := sessions.AddClient(r.Context(), sess, payload.ClientID); err != nil {
+		slog.WarnContext(r.Context(), "session: recording client failed", "error", err)
+	}
+	funnel.Reached(metrics.StageAuthorizeIssued, payload.ClientID, payload.Provider)
+	issueCode(w, r, codec, funnel, bus, domain.ExchangePayload{
+		ClientID: payload.ClientID,
+		FlowID:   flowID,
+		Factors:  sess.Factors,
+		Scope:    payload.Scope,
+		User:     result.User,
+		OIDC:     payload.OIDC,
+		Device:   payload.Device,
+
+		CodeChallenge: payload.CodeChallenge,
+		AppState:      payload.AppState,
+	}, payload.RedirectURI, payload.Provider)
+}
+
+// Logout handles GET /logout. It signs the browser out of its SSO session
+// and redirects to redirect_uri, which must be one of client_id's callbacks,
+// passing state back. The other clients the session signed the user in to
+// are sent logout tokens when notifier is set.
+func Logout(clients *client.Registry, sessions *session.Service, notifier *logout.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		clientID := q.Get("client_id")
+		if clientID == "" {
+			writeError(w, http.StatusBadRequest, "missing client_id parameter")
+			return
+		}
+		redirectURI := q.Get("redirect_uri")
+		if redirectURI == "" {
+			writeError(w, http.StatusBadRequest, "missing redirect_uri parameter")
+			return
+		}
+		err := clients.ValidateCallback(clientID, redirectURI)
+		switch {
+		case errors.Is(err, domain.ErrClientDisabled):
+			writeError(w, http.StatusForbidden, "client is disabled")
+			return
+		case errors.Is(err, domain.ErrCallbackNotAllowed):
+			writeError(w, http.StatusBadRequest, "redirect_uri not allowed")
+			return
+		case err != nil:
+			writeError(w, http.StatusBadRequest, "unknown client")
+			return
+		}
+		target, err := url.Parse(redirectURI)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid redirect URI")
+			return
+		}
+
+		http.SetCookie(w, sessions.ClearCookie())
+		if c, err := r.Cookie(session.CookieName); err == nil {
+			sess, err := sessions.End(r.Context(), c.Value)
+			switch {
+			case errors.Is(err, domain.ErrInvalidSession):
+			case err != nil:
+				slog.WarnContext(r.Context(), "logout: ending SSO session failed", "error", err)
+			default:
+				slog.InfoContext(r.Context(), "logout: signed out", "user", mfa.Identity(sess.User), "client_id", clientID)
+				notifyLogout(r, clients, notifier, sess, clientID)
+			}
+		}
+
+		if state := q.Get("state"); state != "" {
+			tq := target.Query()
+			tq.Set("state", state)
+			target.RawQuery = tq.Encode()
+		}
+		http.Redirect(w, r, target.String(), http.StatusFound)
+	}
+}
+
+// notifyLogout tells the clients sess signed the user in to, other than the
+// one that asked for the sign-out, that the user has signed out. It waits
+// for them, so that by the time the browser is back they have all heard.
+func notifyLogout(r *http.Request, clients *client.Registry, notifier *logout.Notifier, sess session.Session, initiator string) {
+	if notifier == nil {
+		return
+	}
+	var notify []*domain.ClientApp
+	for _, id := range sess.Clients {
+		if c, err := clients.Get(id); err == nil && id != initiator {
+			notify = append(notify, c)
+		}
+	}
+	// The sign-out stands even if the browser goes away meanwhile
+	if err := notifier.Notify(context.WithoutCancel(r.Context()), sess.User, notify); err != nil {
+		slog.WarnContext(r.Context(), "logout: back-channel logout failed", "error", err)
+	}
+}
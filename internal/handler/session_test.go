@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/internal/logout"
+	"github.com/BlackMission/centralauth/internal/mfa"
+	"github.com/BlackMission/centralauth/internal/session"
+	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/store"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+// signInProvider redirects to authURL and signs everyone in as user.
+type signInProvider struct {
+	stubProvider
+	user domain.UserInfo
+}
+
+func (s *signInProvider) Exchange(ctx context.Context, params map[string]string) (*domain.AuthResult, error) {
+	return &domain.AuthResult{User: s.user}, nil
+}
+
+// setupSSO serves the flows of three clients with sessions. forum is told
+// about sign-outs at logoutURI.
+func setupSSO(t *testing.T, logoutURI string) (http.Handler, *state.Service, *exchange.Codec) {
+	t.Helper()
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "website", APIKey: "web-key", AllowedCallbacks: []string{"https://example.com/callback"}, AllowedProviders: []string{"discord", "steam"}},
+		{ID: "forum", APIKey: "forum-key", AllowedCallbacks: []string{"https://forum.example.com/callback"}, AllowedProviders: []string{"discord", "steam"}, BackchannelLogoutURI: logoutURI},
+		{ID: "shop", APIKey: "shop-key", AllowedCallbacks: []string{"https://shop.example.com/callback"}, AllowedProviders: []string{"discord"}, AllowTokenPassthrough: true},
+	})
+	providers := auth.NewRegistry()
+	providers.Register(&signInProvider{
+		stubProvider: stubProvider{name: "discord", authURL: "https://discord.com/oauth2/authorize"},
+		user:         domain.UserInfo{ProviderName: "discord", ProviderID: "123", Username: "alice", Raw: json.RawMessage(`{"id":"123"}`)},
+	})
+	providers.Register(&stubProvider{name: "steam", authURL: "https://steamcommunity.com/openid/login"})
+	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	sessions := session.New(store.NewMemory(), 0, "https://auth.example.com/sso")
+	signer, _ := idtoken.NewSigner(testutil.SigningKeyPEM(t))
+	ids := idtoken.NewIssuer(signer, "https://auth.example.com", 0)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /auth/{provider}", Authorize(clients, providers, stateSvc, nil, nil, nil, codec, sessions, nil, nil, nil, nil))
+	mux.HandleFunc("GET /callback/{provider}", Callback(providers, stateSvc, codec, nil, nil, nil, nil, sessions, nil, nil, nil))
+	mux.HandleFunc("GET /authorize", OIDCAuthorize(clients, providers, stateSvc, nil, nil, codec, sessions, nil, nil, nil))
+	mux.HandleFunc("GET /logout", Logout(clients, sessions, logout.New(ids)))
+	return mux, stateSvc, codec
+}
+
+// signIn runs a flow for website through the provider and returns the
+// session cookie it leaves.
+func signIn(t *testing.T, h http.Handler, stateSvc *state.Service) *http.Cookie {
+	t.Helper()
+	stateToken, _ := stateSvc.Generate(domain.StatePayload{ClientID: "website", Provider: "discord", RedirectURI: "https://example.com/callback"})
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/callback/discord?code=c&state="+url.QueryEscape(stateToken), nil)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == session.CookieName {
+			return c
+		}
+	}
+	t.Fatal("expected a session cookie")
+	return nil
+}
+
+func getWithCookie(h http.Handler, target string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestSSO_SignsInOtherClients(t *testing.T) {
+	h, stateSvc, codec := setupSSO(t, "")
+	cookie := signIn(t, h, stateSvc)
+	if !cookie.HttpOnly || !cookie.Secure || cookie.Path != "/sso" || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie = %+v", cookie)
+	}
+
+	rr := getWithCookie(h, "/auth/discord?client_id=forum&redirect_uri="+url.QueryEscape("https://forum.example.com/callback"), cookie)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	if loc.Host != "forum.example.com" {
+		t.Fatalf("expected to go straight back to forum, got %s", loc)
+	}
+	payload, err := codec.DecodeFor(loc.Query().Get("code"), "forum")
+	if err != nil {
+		t.Fatalf("DecodeFor error: %v", err)
+	}
+	if payload.User.Username != "alice" || payload.User.Raw != nil || !slices.Equal(payload.Factors, []string{"discord"}) {
+		t.Errorf("payload = %+v", payload)
+	}
+}
+
+func TestSSO_GoesToProvider(t *testing.T) {
+	h, stateSvc, _ := setupSSO(t, "")
+	cookie := signIn(t, h, stateSvc)
+
+	tests := map[string]struct {
+		target string
+		cookie *http.Cookie
+	}{
+		"no session":       {"/auth/discord?client_id=forum&redirect_uri=" + url.QueryEscape("https://forum.example.com/callback"), nil},
+		"prompt=login":     {"/auth/discord?prompt=login&client_id=forum&redirect_uri=" + url.QueryEscape("https://forum.example.com/callback"), cookie},
+		"another provider": {"/auth/steam?client_id=forum&redirect_uri=" + url.QueryEscape("https://forum.example.com/callback"), cookie},
+		"provider tokens":  {"/auth/discord?client_id=shop&redirect_uri=" + url.QueryEscape("https://shop.example.com/callback"), cookie},
+		"unknown session":  {"/auth/discord?client_id=forum&redirect_uri=" + url.QueryEscape("https://forum.example.com/callback"), &http.Cookie{Name: session.CookieName, Value: "made-up"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rr := getWithCookie(h, tt.target, tt.cookie)
+			testutil.AssertStatus(t, rr, http.StatusFound)
+			if loc := rr.Header().Get("Location"); strings.Contains(loc, "example.com") {
+				t.Errorf("expected a redirect to the provider, got %s", loc)
+			}
+		})
+	}
+}
+
+func TestSSO_SecondFactor(t *testing.T) {
+	payload := domain.StatePayload{Provider: "discord", ACR: mfa.ACR2FA}
+	if sessionSignsIn(session.Session{User: domain.UserInfo{ProviderName: "discord"}, Factors: []string{"discord"}}, payload) {
+		t.Error("expected a session without a second factor not to satisfy acr=2fa")
+	}
+	if !sessionSignsIn(session.Session{User: domain.UserInfo{ProviderName: "discord"}, Factors: []string{"discord", mfa.FactorTOTP}}, payload) {
+		t.Error("expected a session with a second factor to satisfy acr=2fa")
+	}
+}
+
+func TestSSO_OIDCPromptNone(t *testing.T) {
+	h, stateSvc, _ := setupSSO(t, "")
+	target := "/authorize?" + url.Values{
+		"response_type": {"code"},
+		"client_id":     {"forum"},
+		"redirect_uri":  {"https://forum.example.com/callback"},
+		"scope":         {"openid profile"},
+		"state":         {"xyz"},
+		"prompt":        {"none"},
+	}.Encode()
+
+	rr := getWithCookie(h, target, nil)
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	if loc.Query().Get("error") != "login_required" || loc.Query().Get("state") != "xyz" {
+		t.Errorf("without a session: %s", loc)
+	}
+
+	// With a session, forum's choice of providers is skipped too
+	rr = getWithCookie(h, target, signIn(t, h, stateSvc))
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	loc, _ = url.Parse(rr.Header().Get("Location"))
+	if loc.Host != "forum.example.com" || loc.Query().Get("code") == "" || loc.Query().Get("state") != "xyz" {
+		t.Errorf("with a session: %s", loc)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	var told []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		told = append(told, r.PostFormValue("logout_token"))
+	}))
+	defer srv.Close()
+	h, stateSvc, _ := setupSSO(t, srv.URL)
+	cookie := signIn(t, h, stateSvc)
+	forum := "/auth/discord?client_id=forum&redirect_uri=" + url.QueryEscape("https://forum.example.com/callback")
+	testutil.AssertStatus(t, getWithCookie(h, forum, cookie), http.StatusFound)
+
+	rr := getWithCookie(h, "/logout?client_id=website&state=s1&redirect_uri="+url.QueryEscape("https://example.com/callback"), cookie)
+	testutil.AssertStatus(t, rr, http.StatusFound)
+	if loc := rr.Header().Get("Location"); loc != "https://example.com/callback?state=s1" {
+		t.Errorf("Location = %s", loc)
+	}
+	cleared := rr.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].Name != session.CookieName || cleared[0].MaxAge >= 0 {
+		t.Errorf("expected the session cookie to be cleared, got %v", cleared)
+	}
+	if len(told) != 1 {
+		t.Fatalf("expected forum to be told once, got %d", len(told))
+	}
+
+	// The session is over even for a browser that kept the cookie
+	rr = getWithCookie(h, forum, cookie)
+	if loc := rr.Header().Get("Location"); strings.Contains(loc, "forum.example.com") {
+		t.Errorf("expected a redirect to the provider after logout, got %s", loc)
+	}
+}
+
+func TestLogout_Rejected(t *testing.T) {
+	h, _, _ := setupSSO(t, "")
+	tests := map[string]struct {
+		target string
+		status int
+	}{
+		"missing client":     {"/logout?redirect_uri=" + url.QueryEscape("https://example.com/callback"), http.StatusBadRequest},
+		"unknown client":     {"/logout?client_id=nope&redirect_uri=" + url.QueryEscape("https://example.com/callback"), http.StatusBadRequest},
+		"another's callback": {"/logout?client_id=website&redirect_uri=" + url.QueryEscape("https://forum.example.com/callback"), http.StatusBadRequest},
+		"missing redirect":   {"/logout?client_id=website", http.StatusBadRequest},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			testutil.AssertStatus(t, getWithCookie(h, tt.target, nil), tt.status)
+		})
+	}
+}
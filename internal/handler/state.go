@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/BlackMission/centralauth/internal/audit"
+	"github.com/BlackMission/centralauth/internal/state"
+)
+
+// RevokeState handles POST /admin/state/revoke. It bumps the state epoch,
+// invalidating every auth flow that is currently in progress, and responds
+// 503 if the store the epoch is kept in can't be reached.
+func RevokeState(stateService *state.Service, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		epoch, err := stateService.RevokeAll(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "state: revoking state tokens failed", "error", err)
+			writeError(w, http.StatusServiceUnavailable, "state epoch store is unavailable, please try again")
+			return
+		}
+		recordAdmin(auditLog, r, "state.revoke", "epoch="+strconv.FormatUint(epoch, 10))
+		writeJSON(w, http.StatusOK, map[string]uint64{"epoch": epoch})
+	}
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/audit"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestRevokeState(t *testing.T) {
+	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
+	auditLog := audit.NewLog(0)
+
+	token, _ := stateSvc.Generate(domain.StatePayload{ClientID: "website", Provider: "discord"})
+
+	rr := testutil.DoRequest(t, RevokeState(stateSvc, auditLog), http.MethodPost, "/admin/state/revoke", nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+
+	var body map[string]uint64
+	testutil.ParseJSON(t, rr, &body)
+	if body["epoch"] != 1 {
+		t.Errorf("epoch = %d, want 1", body["epoch"])
+	}
+	if _, err := stateSvc.Validate(token); !errors.Is(err, domain.ErrRevokedState) {
+		t.Errorf("expected ErrRevokedState, got %v", err)
+	}
+	if events := auditLog.Events(); len(events) != 1 || events[0].Action != "state.revoke" {
+		t.Errorf("unexpected audit events: %+v", events)
+	}
+}
+
+type failingEpochStore struct{}
+
+func (failingEpochStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+func (failingEpochStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return errors.New("connection refused")
+}
+func (failingEpochStore) String() string { return "redis" }
+
+func TestRevokeState_StoreUnavailable(t *testing.T) {
+	stateSvc := state.NewService([]byte("test-key-1234567890abcdef"))
+	stateSvc.SetEpochStore(failingEpochStore{})
+	auditLog := audit.NewLog(0)
+
+	rr := testutil.DoRequest(t, RevokeState(stateSvc, auditLog), http.MethodPost, "/admin/state/revoke", nil)
+	testutil.AssertStatus(t, rr, http.StatusServiceUnavailable)
+	if events := auditLog.Events(); len(events) != 0 {
+		t.Errorf("expected no audit events, got %+v", events)
+	}
+}
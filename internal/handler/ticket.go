@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/ban"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/events"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/hook"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/internal/roles"
+	"github.com/BlackMission/centralauth/internal/scope"
+)
+
+const maxTicketRequestBytes = 16 << 10
+
+type ticketRequest struct {
+	Ticket   string `json:"ticket"`
+	Identity string `json:"identity,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+type ticketResponse struct {
+	Code   string `json:"code"`
+	FlowID string `json:"flow_id"`
+}
+
+// Ticket handles POST /auth/{provider}/ticket.
+// A trusted client (a game server, say) posts a session ticket from a game
+// client with its API key. The provider validates the ticket, and the
+// response carries an exchange code for GET /exchange, so the player is
+// authenticated without a browser. The player is given the roles rules
+// grant them and put to the post-auth hooks, as at the callback.
+func Ticket(clients *client.Registry, providers *auth.Registry, codec *exchange.Codec, limiter *auth.Limiter, funnel *metrics.Funnel, bus *events.Bus,
+	bans *ban.List, rules roles.Rules, hooks hook.Chain) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientApp, ok := authenticateClient(w, r, clients)
+		if !ok {
+			return
+		}
+
+		providerName := r.PathValue("provider")
+		provider, err := providers.Get(providerName)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "unknown provider")
+			return
+		}
+		tp, ok := provider.(auth.TicketProvider)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "provider does not accept session tickets")
+			return
+		}
+		if err := clients.ValidateProvider(clientApp.ID, providerName); err != nil {
+			writeError(w, http.StatusForbidden, "provider not allowed for this client")
+			return
+		}
+
+		var req ticketRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTicketRequestBytes)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if req.Ticket == "" {
+			writeError(w, http.StatusBadRequest, "missing ticket")
+			return
+		}
+		granted, err := scope.Parse(req.Scope)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "unsupported scope value")
+			return
+		}
+
+		flowID, err := newFlowID()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to generate flow ID")
+			return
+		}
+		r = logWith(r, "flow_id", flowID, "client_id", clientApp.ID, "provider", providerName)
+
+		release, err := limiter.Acquire(r.Context(), providerName)
+		if err != nil {
+			signInFailed(r, funnel, bus, "provider_busy", flowID, clientApp.ID, providerName)
+			slog.WarnContext(r.Context(), "ticket: ticket not checked", "error", err)
+			w.Header().Set("Retry-After", limiter.RetryAfter(providerName))
+			writeFlowError(w, http.StatusServiceUnavailable, "provider is busy, please try again", flowID)
+			return
+		}
+
+		ctx, span := traceProvider(r, "provider ticket check", providerName)
+		result, err := tp.AuthenticateTicket(ctx, req.Ticket, req.Identity)
+		release()
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			signInFailed(r, funnel, bus, "provider_failed", flowID, clientApp.ID, providerName)
+			slog.WarnContext(r.Context(), "ticket: ticket rejected", "error", err)
+			switch {
+			case errors.Is(err, domain.ErrMissingProviderParams):
+				writeFlowError(w, http.StatusBadRequest, "missing provider parameters", flowID)
+			case errors.Is(err, domain.ErrInvalidSessionTicket):
+				writeFlowError(w, http.StatusUnauthorized, "invalid session ticket", flowID)
+			case errors.Is(err, domain.ErrNoGameAccount):
+				writeFlowError(w, http.StatusForbidden, "account has no game profile", flowID)
+			default:
+				writeFlowError(w, http.StatusBadGateway, "provider exchange failed", flowID)
+			}
+			return
+		}
+
+		if b, err := bans.Check(r.Context(), result.User); err != nil {
+			signInFailed(r, funnel, bus, "ban_check_failed", flowID, clientApp.ID, providerName)
+			slog.ErrorContext(r.Context(), "ban: checking the ban list failed", "error", err)
+			writeFlowError(w, http.StatusServiceUnavailable, "sign-in is unavailable, please try again", flowID)
+			return
+		} else if b != nil {
+			signInFailed(r, funnel, bus, "banned", flowID, clientApp.ID, providerName)
+			slog.WarnContext(r.Context(), "ban: refused a banned user", "provider_id", result.User.ProviderID, "ban_id", b.ID)
+			writeFlowError(w, http.StatusForbidden, "account is banned", flowID)
+			return
+		}
+		result.User.Roles = rules.Apply(result.User)
+		if err := hooks.Run(r.Context(), clientApp.ID, result); err != nil {
+			var rejection *hook.Rejection
+			if errors.As(err, &rejection) {
+				signInFailed(r, funnel, bus, "hook_rejected", flowID, clientApp.ID, providerName)
+				slog.WarnContext(r.Context(), "hook: sign-in rejected", "provider_id", result.User.ProviderID, "reason", rejection.Reason)
+				writeFlowError(w, http.StatusForbidden, rejection.Reason, flowID)
+				return
+			}
+			signInFailed(r, funnel, bus, "hook_failed", flowID, clientApp.ID, providerName)
+			slog.ErrorContext(r.Context(), "hook: post-auth hook failed", "error", err)
+			writeFlowError(w, http.StatusServiceUnavailable, "sign-in is unavailable, please try again", flowID)
+			return
+		}
+
+		if !clientApp.IncludeRaw {
+			result.User.Raw = nil
+		}
+		code, err := sealCode(codec, domain.ExchangePayload{
+			ClientID: clientApp.ID,
+			FlowID:   flowID,
+			Factors:  []string{providerName},
+			Scope:    granted,
+			User:     result.User,
+		})
+		if err != nil {
+			writeFlowError(w, http.StatusInternalServerError, "failed to create exchange code", flowID)
+			return
+		}
+
+		slog.InfoContext(r.Context(), "ticket: exchange code issued")
+		funnel.Reached(metrics.StageCodeIssued, clientApp.ID, providerName)
+		bus.Publish(r.Context(), events.Event{Type: events.AuthSucceeded, FlowID: flowID, ClientID: clientApp.ID,
+			Provider: providerName, UserID: result.User.ProviderID, Factors: []string{providerName}})
+		writeJSON(w, http.StatusOK, ticketResponse{Code: code, FlowID: flowID})
+	}
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+type ticketStubProvider struct {
+	callbackStubProvider
+	gotTicket, gotIdentity string
+}
+
+func (s *ticketStubProvider) AuthenticateTicket(ctx context.Context, ticket, identity string) (*domain.AuthResult, error) {
+	s.gotTicket, s.gotIdentity = ticket, identity
+	return s.result, s.err
+}
+
+func setupTicket(provider auth.Provider) (http.Handler, *exchange.Codec) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{
+			ID:               "gameserver",
+			APIKey:           "server-api-key-secret",
+			AllowedProviders: []string{"steam", "discord"},
+		},
+		{
+			ID:               "website",
+			APIKey:           "web-api-key-secret",
+			AllowedProviders: []string{"discord"},
+		},
+	})
+	providers := auth.NewRegistry()
+	providers.Register(provider)
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /auth/{provider}/ticket", Ticket(clients, providers, codec, nil, nil, nil, nil, nil, nil))
+	return mux, codec
+}
+
+func postTicket(t *testing.T, h http.Handler, provider, apiKey, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/auth/"+provider+"/ticket", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func steamTicketStub() *ticketStubProvider {
+	return &ticketStubProvider{callbackStubProvider: callbackStubProvider{
+		name: "steam",
+		result: &domain.AuthResult{User: domain.UserInfo{
+			ProviderName: "steam",
+			ProviderID:   "76561198012345678",
+			Username:     "GamerTag",
+			Email:        "gamer@example.com",
+		}},
+	}}
+}
+
+func TestTicket_IssuesExchangeCode(t *testing.T) {
+	provider := steamTicketStub()
+	h, codec := setupTicket(provider)
+
+	rr := postTicket(t, h, "steam", "server-api-key-secret", `{"ticket":"14000000abcdef","identity":"unturned","scope":"profile"}`)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+
+	var resp ticketResponse
+	testutil.ParseJSON(t, rr, &resp)
+	if resp.Code == "" || resp.FlowID == "" {
+		t.Fatalf("expected a code and flow ID, got %+v", resp)
+	}
+	if provider.gotTicket != "14000000abcdef" || provider.gotIdentity != "unturned" {
+		t.Errorf("provider got ticket %q identity %q", provider.gotTicket, provider.gotIdentity)
+	}
+
+	payload, err := codec.DecodeFor(resp.Code, "gameserver")
+	if err != nil {
+		t.Fatalf("decoding code: %v", err)
+	}
+	if payload.User.ProviderID != "76561198012345678" || payload.FlowID != resp.FlowID {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+	if len(payload.Factors) != 1 || payload.Factors[0] != "steam" {
+		t.Errorf("expected factors [steam], got %v", payload.Factors)
+	}
+	if payload.User.Email != "" {
+		t.Error("expected the email to be withheld outside the granted scope")
+	}
+}
+
+func TestTicket_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		apiKey   string
+		body     string
+		err      error
+		status   int
+	}{
+		{"bad API key", "steam", "wrong", `{"ticket":"ab"}`, nil, http.StatusUnauthorized},
+		{"unknown provider", "twitch", "server-api-key-secret", `{"ticket":"ab"}`, nil, http.StatusBadRequest},
+		{"provider not allowed", "steam", "web-api-key-secret", `{"ticket":"ab"}`, nil, http.StatusForbidden},
+		{"bad body", "steam", "server-api-key-secret", `ticket=ab`, nil, http.StatusBadRequest},
+		{"missing ticket", "steam", "server-api-key-secret", `{}`, nil, http.StatusBadRequest},
+		{"bad scope", "steam", "server-api-key-secret", `{"ticket":"ab","scope":"everything"}`, nil, http.StatusBadRequest},
+		{"rejected ticket", "steam", "server-api-key-secret", `{"ticket":"ab"}`, domain.ErrInvalidSessionTicket, http.StatusUnauthorized},
+		{"provider failure", "steam", "server-api-key-secret", `{"ticket":"ab"}`, domain.ErrProviderExchange, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := steamTicketStub()
+			if tt.err != nil {
+				provider.err = fmt.Errorf("%w: test", tt.err)
+			}
+			h, _ := setupTicket(provider)
+
+			rr := postTicket(t, h, tt.provider, tt.apiKey, tt.body)
+			testutil.AssertStatus(t, rr, tt.status)
+		})
+	}
+}
+
+func TestTicket_ProviderWithoutTickets(t *testing.T) {
+	h, _ := setupTicket(&callbackStubProvider{name: "discord"})
+
+	rr := postTicket(t, h, "discord", "server-api-key-secret", `{"ticket":"ab"}`)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+}
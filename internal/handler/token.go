@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/BlackMission/centralauth/internal/ban"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/internal/refresh"
+	"github.com/BlackMission/centralauth/internal/scope"
+)
+
+const maxTokenRequestBytes = 4 << 10
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshToken handles POST /token/refresh.
+// A client with refresh tokens posts one with its API key and gets the
+// sign-in's result again, with a new refresh token in place of the one it
+// sent and, when identity tokens are enabled, a new identity token. The user
+// data is what the provider returned at sign-in; it isn't fetched again, but
+// a user banned since is refused and the sign-in's tokens revoked.
+func RefreshToken(clients *client.Registry, refresher *refresh.Service, ids *idtoken.Issuer, bans *ban.List) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientApp, ok := authenticateClient(w, r, clients)
+		if !ok {
+			return
+		}
+		if clientApp.RefreshTokenTTL == 0 {
+			writeError(w, http.StatusForbidden, "client does not use refresh tokens")
+			return
+		}
+		token, ok := decodeRefreshRequest(w, r)
+		if !ok {
+			return
+		}
+
+		result, next, err := refresher.Refresh(r.Context(), clientApp.ID, token, clientApp.RefreshTokenTTL)
+		switch {
+		case errors.Is(err, domain.ErrRefreshTokenReused):
+			slog.WarnContext(r.Context(), "token: refresh token used twice; revoked its sign-in", "client_id", clientApp.ID)
+			writeError(w, http.StatusBadRequest, "refresh token already used")
+			return
+		case errors.Is(err, domain.ErrExpiredRefreshToken):
+			writeError(w, http.StatusBadRequest, "refresh token expired")
+			return
+		case errors.Is(err, domain.ErrInvalidRefreshToken), errors.Is(err, domain.ErrRevokedRefreshToken):
+			writeError(w, http.StatusBadRequest, "invalid refresh token")
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "token: refresh failed", "client_id", clientApp.ID, "error", err)
+			writeError(w, http.StatusServiceUnavailable, "refresh tokens are unavailable, please try again")
+			return
+		}
+		if b, err := refreshBanned(r.Context(), bans, refresher, clientApp, result.User, next); err != nil {
+			writeError(w, http.StatusServiceUnavailable, "refresh tokens are unavailable, please try again")
+			return
+		} else if b != nil {
+			writeError(w, http.StatusForbidden, "account is banned")
+			return
+		}
+
+		// Stripped again in case the client's strip_fields changed since
+		result.User = scope.Strip(result.User, clientApp.StripFields)
+		if ids != nil {
+			if result.IDToken, err = ids.Issue(clientApp.ID, result); err != nil {
+				slog.ErrorContext(r.Context(), "token: refresh failed", "client_id", clientApp.ID, "error", err)
+				writeError(w, http.StatusInternalServerError, "failed to sign result")
+				return
+			}
+		}
+		result.RefreshToken = next
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// RevokeToken handles POST /token/revoke.
+// It revokes a refresh token and every token rotated from the same sign-in,
+// for example when the user signs out. Like RFC 7009, it succeeds for tokens
+// that are unknown or already revoked.
+func RevokeToken(clients *client.Registry, refresher *refresh.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientApp, ok := authenticateClient(w, r, clients)
+		if !ok {
+			return
+		}
+		token, ok := decodeRefreshRequest(w, r)
+		if !ok {
+			return
+		}
+		if err := refresher.Revoke(r.Context(), clientApp.ID, token, clientApp.RefreshTokenTTL); err != nil {
+			slog.ErrorContext(r.Context(), "token: revoke failed", "client_id", clientApp.ID, "error", err)
+			writeError(w, http.StatusServiceUnavailable, "refresh tokens are unavailable, please try again")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func decodeRefreshRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req refreshRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTokenRequestBytes)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return "", false
+	}
+	if req.RefreshToken == "" {
+		writeError(w, http.StatusBadRequest, "missing refresh_token")
+		return "", false
+	}
+	return req.RefreshToken, true
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/internal/refresh"
+	"github.com/BlackMission/centralauth/internal/store"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func setupToken(t *testing.T) (http.Handler, *exchange.Codec, *client.Registry, *idtoken.Signer) {
+	t.Helper()
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "app", APIKey: "app-api-key-secret", RefreshTokenTTL: time.Hour},
+		{ID: "website", APIKey: "web-api-key-secret"},
+	})
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	signer, _ := idtoken.NewSigner(testutil.SigningKeyPEM(t))
+	ids := idtoken.NewIssuer(signer, "https://auth.example.com", 0)
+	refresher := refresh.New(store.NewMemory())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /exchange", Exchange(clients, codec, nil, nil, ids, refresher, nil, nil, nil))
+	mux.HandleFunc("POST /token/refresh", RefreshToken(clients, refresher, ids, nil))
+	mux.HandleFunc("POST /token/revoke", RevokeToken(clients, refresher))
+	return mux, codec, clients, signer
+}
+
+func postToken(t *testing.T, h http.Handler, path, apiKey, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+// signInFor redeems a new exchange code for clientID with format and returns
+// the result.
+func signInFor(t *testing.T, h http.Handler, codec *exchange.Codec, clientID, apiKey, format string) domain.AuthResult {
+	t.Helper()
+	code, _ := codec.Encode(domain.ExchangePayload{
+		ClientID: clientID,
+		User:     domain.UserInfo{ProviderName: "discord", ProviderID: "123", Username: "testuser", Email: "test@example.com"},
+		Scope:    "profile email",
+	})
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/exchange?format="+format+"&code="+url.QueryEscape(code),
+		map[string]string{"Authorization": "Bearer " + apiKey})
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	var result domain.AuthResult
+	if format != FormatJWT {
+		testutil.ParseJSON(t, rr, &result)
+	}
+	return result
+}
+
+func TestExchange_RefreshToken(t *testing.T) {
+	h, codec, _, _ := setupToken(t)
+
+	if got := signInFor(t, h, codec, "app", "app-api-key-secret", FormatJSON); got.RefreshToken == "" {
+		t.Error("expected a refresh token for a client with refresh tokens")
+	}
+	if got := signInFor(t, h, codec, "website", "web-api-key-secret", FormatJSON); got.RefreshToken != "" {
+		t.Error("expected no refresh token for a client without them")
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	h, codec, clients, signer := setupToken(t)
+	first := signInFor(t, h, codec, "app", "app-api-key-secret", FormatJSON).RefreshToken
+
+	// Fields stripped since sign-in are stripped from the refreshed result
+	app, _ := clients.Get("app")
+	app.StripFields = []string{"email"}
+	clients.Replace([]domain.ClientApp{*app, {ID: "website", APIKey: "web-api-key-secret"}})
+
+	rr := postToken(t, h, "/token/refresh", "app-api-key-secret", `{"refresh_token":"`+first+`"}`)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	var result domain.AuthResult
+	testutil.ParseJSON(t, rr, &result)
+	if result.User.Username != "testuser" || result.User.Email != "" {
+		t.Errorf("user = %+v", result.User)
+	}
+	if result.RefreshToken == "" || result.RefreshToken == first {
+		t.Errorf("expected a new refresh token, got %q", result.RefreshToken)
+	}
+	var claims idtoken.Claims
+	if err := signer.Verify(result.IDToken, &claims); err != nil || claims.Audience != "app" {
+		t.Errorf("id_token = %+v, %v", claims, err)
+	}
+
+	// The old token is spent, and using it again revokes the new one
+	rr = postToken(t, h, "/token/refresh", "app-api-key-secret", `{"refresh_token":"`+first+`"}`)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+	rr = postToken(t, h, "/token/refresh", "app-api-key-secret", `{"refresh_token":"`+result.RefreshToken+`"}`)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestRefreshToken_Rejected(t *testing.T) {
+	h, codec, _, _ := setupToken(t)
+	token := signInFor(t, h, codec, "app", "app-api-key-secret", FormatJSON).RefreshToken
+
+	for _, tt := range []struct {
+		name, apiKey, body string
+		want               int
+	}{
+		{"no API key", "", `{"refresh_token":"` + token + `"}`, http.StatusUnauthorized},
+		{"client without refresh tokens", "web-api-key-secret", `{"refresh_token":"` + token + `"}`, http.StatusForbidden},
+		{"bad body", "app-api-key-secret", `refresh_token=` + token, http.StatusBadRequest},
+		{"missing token", "app-api-key-secret", `{}`, http.StatusBadRequest},
+		{"unknown token", "app-api-key-secret", `{"refresh_token":"made-up"}`, http.StatusBadRequest},
+	} {
+		rr := postToken(t, h, "/token/refresh", tt.apiKey, tt.body)
+		if rr.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rr.Code, tt.want)
+		}
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	h, codec, _, _ := setupToken(t)
+	token := signInFor(t, h, codec, "app", "app-api-key-secret", FormatJSON).RefreshToken
+
+	rr := postToken(t, h, "/token/revoke", "app-api-key-secret", `{"refresh_token":"`+token+`"}`)
+	testutil.AssertStatus(t, rr, http.StatusNoContent)
+	rr = postToken(t, h, "/token/refresh", "app-api-key-secret", `{"refresh_token":"`+token+`"}`)
+	testutil.AssertStatus(t, rr, http.StatusBadRequest)
+
+	// Unknown tokens revoke quietly
+	rr = postToken(t, h, "/token/revoke", "app-api-key-secret", `{"refresh_token":"made-up"}`)
+	testutil.AssertStatus(t, rr, http.StatusNoContent)
+}
@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/BlackMission/centralauth/internal/audit"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/identity"
+)
+
+// linkedUser is the response of the user lookups: a central ID and the
+// provider accounts linked to it.
+type linkedUser struct {
+	CentralID  string              `json:"central_id"`
+	Identities []identity.Identity `json:"identities"`
+}
+
+// User handles GET /users/{central_id}.
+// A client with allow_lookup sends a central ID with its API key and gets
+// the provider accounts linked to it, such as the Discord account of a
+// player it knows by their Steam account. Only accounts at the client's
+// allowed providers are listed.
+func User(clients *client.Registry, identities *identity.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientApp, ok := authenticateLookupClient(w, r, clients)
+		if !ok {
+			return
+		}
+		writeLinkedUser(w, r, clients, identities, clientApp, r.PathValue("central_id"))
+	}
+}
+
+// UserByProvider handles GET /users/by-provider/{provider}/{provider_id}.
+// It is User for the central ID of a provider account, which must be at one
+// of the client's allowed providers.
+func UserByProvider(clients *client.Registry, identities *identity.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientApp, ok := authenticateLookupClient(w, r, clients)
+		if !ok {
+			return
+		}
+		provider := r.PathValue("provider")
+		if err := clients.ValidateProvider(clientApp.ID, provider); err != nil {
+			writeError(w, http.StatusForbidden, "provider not allowed for this client")
+			return
+		}
+
+		centralID, err := identities.Find(r.Context(), provider, r.PathValue("provider_id"))
+		if errors.Is(err, domain.ErrAccountNotFound) {
+			writeError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "users: lookup failed", "client_id", clientApp.ID, "error", err)
+			writeError(w, http.StatusServiceUnavailable, "user identities are unavailable, please try again")
+			return
+		}
+		writeLinkedUser(w, r, clients, identities, clientApp, centralID)
+	}
+}
+
+// authenticateLookupClient is authenticateClient for clients that may look
+// users up.
+func authenticateLookupClient(w http.ResponseWriter, r *http.Request, clients *client.Registry) (*domain.ClientApp, bool) {
+	clientApp, ok := authenticateClient(w, r, clients)
+	if !ok {
+		return nil, false
+	}
+	if !clientApp.AllowLookup {
+		writeError(w, http.StatusForbidden, "client is not allowed to look up users")
+		return nil, false
+	}
+	return clientApp, true
+}
+
+// writeLinkedUser writes the accounts linked to centralID that clientApp may
+// see. A user with none is not found, so clients can't learn who else signs
+// in to CentralAuth.
+func writeLinkedUser(w http.ResponseWriter, r *http.Request, clients *client.Registry, identities *identity.Store, clientApp *domain.ClientApp, centralID string) {
+	linked, err := identities.Identities(r.Context(), centralID)
+	if err != nil && !errors.Is(err, domain.ErrAccountNotFound) {
+		slog.ErrorContext(r.Context(), "users: lookup failed", "client_id", clientApp.ID, "error", err)
+		writeError(w, http.StatusServiceUnavailable, "user identities are unavailable, please try again")
+		return
+	}
+
+	visible := make([]identity.Identity, 0, len(linked))
+	for _, id := range linked {
+		if clients.ValidateProvider(clientApp.ID, id.Provider) == nil {
+			visible = append(visible, id)
+		}
+	}
+	if len(visible) == 0 {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, linkedUser{CentralID: centralID, Identities: visible})
+}
+
+const (
+	defaultLoginsLimit = 20
+	maxLoginsLimit     = 100
+)
+
+// loginHistory is the response of the login history endpoints.
+type loginHistory struct {
+	CentralID string           `json:"central_id"`
+	Logins    []identity.Login `json:"logins"`
+}
+
+// UserLogins handles GET /users/{central_id}/logins.
+// A client with allow_lookup gets the user's most recent sign-ins, newest
+// first, to show them their account activity. As with User, only sign-ins
+// with accounts at the client's allowed providers are listed, and a user
+// with no such account is not found.
+func UserLogins(clients *client.Registry, identities *identity.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientApp, ok := authenticateLookupClient(w, r, clients)
+		if !ok {
+			return
+		}
+		limit, ok := loginsLimit(w, r)
+		if !ok {
+			return
+		}
+		centralID := r.PathValue("central_id")
+		linked, err := identities.Identities(r.Context(), centralID)
+		if err != nil && !errors.Is(err, domain.ErrAccountNotFound) {
+			slog.ErrorContext(r.Context(), "users: lookup failed", "client_id", clientApp.ID, "error", err)
+			writeError(w, http.StatusServiceUnavailable, "user identities are unavailable, please try again")
+			return
+		}
+		if !slices.ContainsFunc(linked, func(id identity.Identity) bool {
+			return clients.ValidateProvider(clientApp.ID, id.Provider) == nil
+		}) {
+			writeError(w, http.StatusNotFound, "user not found")
+			return
+		}
+
+		logins, err := identities.Logins(r.Context(), centralID, limit)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "users: reading login history failed", "client_id", clientApp.ID, "error", err)
+			writeError(w, http.StatusServiceUnavailable, "user identities are unavailable, please try again")
+			return
+		}
+		logins = slices.DeleteFunc(logins, func(l identity.Login) bool {
+			return clients.ValidateProvider(clientApp.ID, l.Provider) != nil
+		})
+		writeJSON(w, http.StatusOK, loginHistory{CentralID: centralID, Logins: logins})
+	}
+}
+
+// AdminUserLogins handles GET /admin/users/{central_id}/logins, for staff
+// investigating a compromised account. Every sign-in is listed, whichever
+// client it was to.
+func AdminUserLogins(identities *identity.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := loginsLimit(w, r)
+		if !ok {
+			return
+		}
+		centralID := r.PathValue("central_id")
+		logins, err := identities.Logins(r.Context(), centralID, limit)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "users: reading login history failed", "central_id", centralID, "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to read login history")
+			return
+		}
+		writeJSON(w, http.StatusOK, loginHistory{CentralID: centralID, Logins: logins})
+	}
+}
+
+// loginsLimit parses the limit query parameter of the login history
+// endpoints, writing a 400 and returning false if it is invalid.
+func loginsLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultLoginsLimit, true
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > maxLoginsLimit {
+		writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxLoginsLimit))
+		return 0, false
+	}
+	return limit, true
+}
+
+// recordLogin adds a sign-in to the user's login history. The history is
+// informational, so a sign-in isn't failed for want of recording it.
+func recordLogin(r *http.Request, identities *identity.Store, clientID string, user domain.UserInfo, ip string) {
+	if user.CentralID == "" {
+		return
+	}
+	err := identities.RecordLogin(r.Context(), user.CentralID, identity.Login{
+		ClientID: clientID, Provider: user.ProviderName, ProviderID: user.ProviderID, IP: ip,
+	})
+	if err != nil {
+		slog.WarnContext(r.Context(), "users: recording login failed", "error", err)
+	}
+}
+
+// UnlinkIdentity handles
+// DELETE /admin/users/{central_id}/identities/{provider}/{provider_id}, for a
+// user who has lost access to an account. The account gets a new central ID
+// the next time it signs in. A user's only account can't be unlinked.
+func UnlinkIdentity(identities *identity.Store, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		centralID, provider, providerID := r.PathValue("central_id"), r.PathValue("provider"), r.PathValue("provider_id")
+		err := identities.Unlink(r.Context(), centralID, provider, providerID)
+		switch {
+		case errors.Is(err, domain.ErrAccountNotFound):
+			writeError(w, http.StatusNotFound, "account is not linked to the user")
+			return
+		case errors.Is(err, domain.ErrLastIdentity):
+			writeError(w, http.StatusConflict, "account is the user's only linked account")
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "users: unlink failed", "central_id", centralID, "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to unlink account")
+			return
+		}
+
+		recordAdmin(auditLog, r, "identity.unlink", provider+"/"+providerID+" from "+centralID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+const maxMergeRequestBytes = 4 << 10
+
+type mergeRequest struct {
+	From string `json:"from"`
+}
+
+// MergeUsers handles POST /admin/users/{central_id}/merge, for a user who
+// signed in with two accounts before linking them. The accounts of the
+// central ID in the body's "from" are linked to central_id, and from is
+// left with none.
+func MergeUsers(identities *identity.Store, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req mergeRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMergeRequestBytes)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		into := r.PathValue("central_id")
+		if req.From == "" || req.From == into {
+			writeError(w, http.StatusBadRequest, "invalid from")
+			return
+		}
+
+		linked, err := identities.Merge(r.Context(), req.From, into)
+		switch {
+		case errors.Is(err, domain.ErrAccountNotFound):
+			writeError(w, http.StatusNotFound, "unknown user")
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "users: merge failed", "central_id", into, "from", req.From, "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to merge users")
+			return
+		}
+
+		recordAdmin(auditLog, r, "identity.merge", req.From+" into "+into)
+		writeJSON(w, http.StatusOK, linkedUser{CentralID: into, Identities: linked})
+	}
+}
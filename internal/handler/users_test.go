@@ -0,0 +1,276 @@
+package handler
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/audit"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/identity"
+	"github.com/BlackMission/centralauth/internal/sqldb"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+// identityRow is a row of a fake identities table.
+type identityRow struct {
+	identity.Identity
+	centralID string
+}
+
+// newIdentities returns a store over a fake identities table holding rows,
+// and an empty logins table. Setting *down fails every query.
+func newIdentities(t *testing.T, rows ...identityRow) (*identity.Store, *bool) {
+	t.Helper()
+	down := new(bool)
+	var logins [][]driver.Value
+	db := sqldb.New(testutil.OpenSQL(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		var matched [][]driver.Value
+		switch {
+		case strings.HasPrefix(query, "SELECT version"):
+			return []string{"version"}, nil, nil
+		case *down:
+			return nil, nil, errors.New("connection refused")
+		case strings.HasPrefix(query, "SELECT central_id"):
+			for _, row := range rows {
+				if row.Provider == args[0] && row.ProviderID == args[1] {
+					matched = append(matched, []driver.Value{row.centralID})
+				}
+			}
+			return []string{"central_id"}, matched, nil
+		case strings.HasPrefix(query, "SELECT provider"):
+			for _, row := range rows {
+				if row.centralID == args[0] {
+					matched = append(matched, []driver.Value{row.Provider, row.ProviderID, row.CreatedAt})
+				}
+			}
+			return []string{"provider", "provider_id", "created_at"}, matched, nil
+		case strings.HasPrefix(query, "DELETE FROM identities"):
+			rows = slices.DeleteFunc(rows, func(row identityRow) bool {
+				return row.centralID == args[0] && row.Provider == args[1] && row.ProviderID == args[2]
+			})
+		case strings.HasPrefix(query, "INSERT INTO logins"):
+			logins = append(logins, args)
+			return nil, [][]driver.Value{{}}, nil
+		case strings.HasPrefix(query, "SELECT client_id"):
+			for _, row := range slices.Backward(logins) {
+				if row[0] == args[0] && len(matched) < int(args[1].(int64)) {
+					matched = append(matched, row[1:])
+				}
+			}
+			return []string{"client_id", "provider", "provider_id", "ip", "logged_in_at"}, matched, nil
+		case strings.HasPrefix(query, "UPDATE identities"):
+			for i := range rows {
+				if rows[i].centralID == args[1] {
+					rows[i].centralID = args[0].(string)
+					matched = append(matched, []driver.Value{})
+				}
+			}
+			return nil, matched, nil
+		}
+		return nil, nil, nil
+	}), sqldb.SQLite)
+	identities, err := identity.NewStore(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identities, down
+}
+
+// setupUsers serves the user lookups from an identities table holding a
+// player's linked Steam and Discord accounts.
+func setupUsers(t *testing.T) (http.Handler, *bool) {
+	t.Helper()
+	identities, down := newIdentities(t,
+		identityRow{identity.Identity{Provider: "steam", ProviderID: "76561197960287930", CreatedAt: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)}, "c0ffee"},
+		identityRow{identity.Identity{Provider: "discord", ProviderID: "123456789", CreatedAt: time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC)}, "c0ffee"},
+	)
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "plugin", APIKey: "plugin-api-key", AllowedProviders: []string{"steam", "discord"}, AllowLookup: true},
+		{ID: "steam-only", APIKey: "steam-api-key", AllowedProviders: []string{"steam"}, AllowLookup: true},
+		{ID: "website", APIKey: "web-api-key", AllowedProviders: []string{"steam", "discord"}},
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{central_id}", User(clients, identities))
+	mux.HandleFunc("GET /users/by-provider/{provider}/{provider_id}", UserByProvider(clients, identities))
+	return mux, down
+}
+
+func getUser(t *testing.T, h http.Handler, path, apiKey string) *linkedUser {
+	t.Helper()
+	rr := testutil.DoRequest(t, h, http.MethodGet, path, map[string]string{"Authorization": "Bearer " + apiKey})
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	var u linkedUser
+	testutil.ParseJSON(t, rr, &u)
+	return &u
+}
+
+func TestUserByProvider(t *testing.T) {
+	h, _ := setupUsers(t)
+
+	// A game server resolves a player's Steam account to their Discord one
+	u := getUser(t, h, "/users/by-provider/steam/76561197960287930", "plugin-api-key")
+	if u.CentralID != "c0ffee" || len(u.Identities) != 2 || u.Identities[1].Provider != "discord" || u.Identities[1].ProviderID != "123456789" {
+		t.Errorf("user = %+v", u)
+	}
+
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/users/by-provider/steam/1", map[string]string{"Authorization": "Bearer plugin-api-key"})
+	testutil.AssertStatus(t, rr, http.StatusNotFound)
+	rr = testutil.DoRequest(t, h, http.MethodGet, "/users/by-provider/discord/123456789", map[string]string{"Authorization": "Bearer steam-api-key"})
+	testutil.AssertStatus(t, rr, http.StatusForbidden)
+}
+
+func TestUser(t *testing.T) {
+	h, _ := setupUsers(t)
+
+	if u := getUser(t, h, "/users/c0ffee", "plugin-api-key"); len(u.Identities) != 2 {
+		t.Errorf("identities = %+v", u.Identities)
+	}
+	// Clients only see accounts at their own providers
+	if u := getUser(t, h, "/users/c0ffee", "steam-api-key"); len(u.Identities) != 1 || u.Identities[0].Provider != "steam" {
+		t.Errorf("steam-only client got %+v", u.Identities)
+	}
+	rr := testutil.DoRequest(t, h, http.MethodGet, "/users/unknown", map[string]string{"Authorization": "Bearer plugin-api-key"})
+	testutil.AssertStatus(t, rr, http.StatusNotFound)
+}
+
+func TestUser_Errors(t *testing.T) {
+	h, down := setupUsers(t)
+
+	for _, tt := range []struct {
+		name   string
+		apiKey string
+		want   int
+	}{
+		{"no API key", "", http.StatusUnauthorized},
+		{"wrong API key", "wrong", http.StatusUnauthorized},
+		{"client without allow_lookup", "web-api-key", http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := testutil.DoRequest(t, h, http.MethodGet, "/users/c0ffee", map[string]string{"Authorization": "Bearer " + tt.apiKey})
+			testutil.AssertStatus(t, rr, tt.want)
+		})
+	}
+
+	*down = true
+	for _, path := range []string{"/users/c0ffee", "/users/by-provider/steam/76561197960287930"} {
+		rr := testutil.DoRequest(t, h, http.MethodGet, path, map[string]string{"Authorization": "Bearer plugin-api-key"})
+		testutil.AssertStatus(t, rr, http.StatusServiceUnavailable)
+	}
+}
+
+func TestMergeUsers(t *testing.T) {
+	identities, _ := newIdentities(t,
+		identityRow{identity.Identity{Provider: "steam", ProviderID: "76561197960287930"}, "c0ffee"},
+		identityRow{identity.Identity{Provider: "discord", ProviderID: "123456789"}, "decade"},
+	)
+	auditLog := audit.NewLog(0)
+	h := MergeUsers(identities, auditLog)
+	merge := func(into, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/users/"+into+"/merge", strings.NewReader(body))
+		req.SetPathValue("central_id", into)
+		req.Header.Set(AdminActorHeader, "alice")
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	rr := merge("c0ffee", `{"from":"decade"}`)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	var u linkedUser
+	testutil.ParseJSON(t, rr, &u)
+	if u.CentralID != "c0ffee" || len(u.Identities) != 2 {
+		t.Errorf("merged user = %+v", u)
+	}
+	events := auditLog.Events()
+	if len(events) != 1 || events[0].Action != "identity.merge" || events[0].Target != "decade into c0ffee" || events[0].Actor != "alice" {
+		t.Errorf("audit events = %+v", events)
+	}
+
+	testutil.AssertStatus(t, merge("c0ffee", `{"from":"decade"}`), http.StatusNotFound)
+	testutil.AssertStatus(t, merge("c0ffee", `{"from":"c0ffee"}`), http.StatusBadRequest)
+	testutil.AssertStatus(t, merge("c0ffee", `{`), http.StatusBadRequest)
+	if len(auditLog.Events()) != 1 {
+		t.Error("failed merges were audited")
+	}
+}
+
+func TestUnlinkIdentity(t *testing.T) {
+	identities, down := newIdentities(t,
+		identityRow{identity.Identity{Provider: "steam", ProviderID: "76561197960287930"}, "c0ffee"},
+		identityRow{identity.Identity{Provider: "discord", ProviderID: "123456789"}, "c0ffee"},
+	)
+	auditLog := audit.NewLog(0)
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /admin/users/{central_id}/identities/{provider}/{provider_id}", UnlinkIdentity(identities, auditLog))
+	unlink := func(path string) *httptest.ResponseRecorder {
+		return testutil.DoRequest(t, mux, http.MethodDelete, "/admin/users/c0ffee/identities/"+path, nil)
+	}
+
+	testutil.AssertStatus(t, unlink("discord/123456789"), http.StatusNoContent)
+	if events := auditLog.Events(); len(events) != 1 || events[0].Action != "identity.unlink" || events[0].Target != "discord/123456789 from c0ffee" {
+		t.Errorf("audit events = %+v", events)
+	}
+	testutil.AssertStatus(t, unlink("discord/123456789"), http.StatusNotFound)
+	testutil.AssertStatus(t, unlink("steam/76561197960287930"), http.StatusConflict)
+
+	*down = true
+	testutil.AssertStatus(t, unlink("steam/76561197960287930"), http.StatusInternalServerError)
+}
+
+func TestUserLogins(t *testing.T) {
+	identities, down := newIdentities(t,
+		identityRow{identity.Identity{Provider: "steam", ProviderID: "76561197960287930"}, "c0ffee"},
+		identityRow{identity.Identity{Provider: "discord", ProviderID: "123456789"}, "c0ffee"},
+	)
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "plugin", APIKey: "plugin-api-key", AllowedProviders: []string{"steam", "discord"}, AllowLookup: true},
+		{ID: "steam-only", APIKey: "steam-api-key", AllowedProviders: []string{"steam"}, AllowLookup: true},
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{central_id}/logins", UserLogins(clients, identities))
+	mux.HandleFunc("GET /admin/users/{central_id}/logins", AdminUserLogins(identities))
+
+	r := httptest.NewRequest(http.MethodGet, "/exchange", nil)
+	recordLogin(r, identities, "plugin", domain.UserInfo{ProviderName: "steam", ProviderID: "76561197960287930", CentralID: "c0ffee"}, "203.0.113.7")
+	recordLogin(r, identities, "website", domain.UserInfo{ProviderName: "discord", ProviderID: "123456789", CentralID: "c0ffee"}, "")
+	recordLogin(r, identities, "website", domain.UserInfo{ProviderName: "discord", ProviderID: "123456789"}, "")
+
+	getLogins := func(path, apiKey string, want int) *loginHistory {
+		t.Helper()
+		rr := testutil.DoRequest(t, mux, http.MethodGet, path, map[string]string{"Authorization": "Bearer " + apiKey})
+		testutil.AssertStatus(t, rr, want)
+		var h loginHistory
+		if want == http.StatusOK {
+			testutil.ParseJSON(t, rr, &h)
+		}
+		return &h
+	}
+
+	// Newest first; a user without a central ID has no history
+	if h := getLogins("/users/c0ffee/logins", "plugin-api-key", http.StatusOK); len(h.Logins) != 2 || h.Logins[0].ClientID != "website" || h.Logins[1].IP != "203.0.113.7" {
+		t.Errorf("logins = %+v", h.Logins)
+	}
+	// Clients only see sign-ins with accounts at their own providers
+	if h := getLogins("/users/c0ffee/logins", "steam-api-key", http.StatusOK); len(h.Logins) != 1 || h.Logins[0].Provider != "steam" {
+		t.Errorf("steam-only client got %+v", h.Logins)
+	}
+	// Staff see them all
+	if h := getLogins("/admin/users/c0ffee/logins?limit=1", "", http.StatusOK); h.CentralID != "c0ffee" || len(h.Logins) != 1 {
+		t.Errorf("admin got %+v", h)
+	}
+
+	getLogins("/users/unknown/logins", "plugin-api-key", http.StatusNotFound)
+	getLogins("/users/c0ffee/logins?limit=0", "plugin-api-key", http.StatusBadRequest)
+	getLogins("/users/c0ffee/logins?limit=101", "plugin-api-key", http.StatusBadRequest)
+	*down = true
+	getLogins("/users/c0ffee/logins", "plugin-api-key", http.StatusServiceUnavailable)
+	getLogins("/admin/users/c0ffee/logins", "", http.StatusInternalServerError)
+}
@@ -0,0 +1,100 @@
+// Package hook runs a deployment's own policy on each sign-in, after the
+// provider has authenticated the user and before the client is given an
+// exchange code. A hook can add to the result or refuse the sign-in.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// Hook is a post-auth hook.
+type Hook interface {
+	// Run is given the client the user is signing in to and the provider's
+	// result, which it may change. It refuses the sign-in by returning an
+	// error from Reject; any other error refuses it as unavailable.
+	Run(ctx context.Context, clientID string, result *domain.AuthResult) error
+}
+
+// Func adapts a function to a Hook.
+type Func func(ctx context.Context, clientID string, result *domain.AuthResult) error
+
+func (f Func) Run(ctx context.Context, clientID string, result *domain.AuthResult) error {
+	return f(ctx, clientID, result)
+}
+
+// Rejection is the error of a hook that refuses a sign-in. Reason is shown
+// to the user.
+type Rejection struct {
+	Reason string
+}
+
+// Reject returns a Rejection for reason.
+func Reject(reason string) error {
+	return &Rejection{Reason: reason}
+}
+
+func (r *Rejection) Error() string {
+	return "sign-in rejected: " + r.Reason
+}
+
+// Chain runs hooks in order. A nil Chain runs none.
+type Chain []Hook
+
+// Run runs each hook on result in turn, stopping at the first that fails.
+func (c Chain) Run(ctx context.Context, clientID string, result *domain.AuthResult) error {
+	for _, h := range c {
+		if err := h.Run(ctx, clientID, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// request is what the built-in hooks send about a sign-in.
+type request struct {
+	ClientID string          `json:"client_id"`
+	User     domain.UserInfo `json:"user"`
+	Factors  []string        `json:"factors"`
+}
+
+// response is what the built-in hooks are answered with. Empty allows the
+// sign-in unchanged.
+type response struct {
+	Reject string   `json:"reject,omitempty"` // refuses the sign-in, with the reason shown to the user
+	Roles  []string `json:"roles,omitempty"`  // added to the user's roles
+}
+
+func newRequest(clientID string, result *domain.AuthResult) ([]byte, error) {
+	user := result.User
+	user.Raw = nil // may hold anything; the hook gets the normalized profile
+	return json.Marshal(request{ClientID: clientID, User: user, Factors: result.Factors})
+}
+
+// decodeResponse decodes a built-in hook's answer, which may be empty.
+func decodeResponse(body []byte) (response, error) {
+	var resp response
+	if len(bytes.TrimSpace(body)) == 0 {
+		return resp, nil
+	}
+	err := json.Unmarshal(body, &resp)
+	return resp, err
+}
+
+// apply makes the changes resp asks for to result, or rejects it.
+func (resp response) apply(result *domain.AuthResult) error {
+	if resp.Reject != "" {
+		return Reject(resp.Reject)
+	}
+	for _, role := range resp.Roles {
+		if !slices.Contains(result.User.Roles, role) {
+			result.User.Roles = append(result.User.Roles, role)
+		}
+	}
+	slices.Sort(result.User.Roles)
+	return nil
+}
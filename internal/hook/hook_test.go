@@ -0,0 +1,69 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func TestChain(t *testing.T) {
+	var ran []string
+	record := func(name string, err error) Hook {
+		return Func(func(ctx context.Context, clientID string, result *domain.AuthResult) error {
+			ran = append(ran, name)
+			result.User.Roles = append(result.User.Roles, "role:"+name)
+			return err
+		})
+	}
+
+	result := &domain.AuthResult{User: domain.UserInfo{ProviderName: "discord", ProviderID: "123"}}
+	if err := (Chain{record("first", nil), record("second", nil)}).Run(context.Background(), "website", result); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	if !slices.Equal(result.User.Roles, []string{"role:first", "role:second"}) {
+		t.Errorf("roles = %v", result.User.Roles)
+	}
+
+	ran = nil
+	err := Chain{record("first", Reject("under review")), record("second", nil)}.Run(context.Background(), "website", result)
+	var rejection *Rejection
+	if !errors.As(err, &rejection) || rejection.Reason != "under review" {
+		t.Errorf("err = %v, want the rejection", err)
+	}
+	if !slices.Equal(ran, []string{"first"}) {
+		t.Errorf("ran %v, want only the first hook", ran)
+	}
+
+	var none Chain
+	if err := none.Run(context.Background(), "website", result); err != nil {
+		t.Errorf("nil Chain error: %v", err)
+	}
+}
+
+func TestResponse(t *testing.T) {
+	result := &domain.AuthResult{User: domain.UserInfo{Roles: []string{"role:player"}}}
+	for _, body := range []string{"", "  \n", "{}"} {
+		resp, err := decodeResponse([]byte(body))
+		if err != nil || resp.apply(result) != nil {
+			t.Errorf("%q: err = %v, want the sign-in allowed", body, err)
+		}
+	}
+
+	resp, _ := decodeResponse([]byte(`{"roles": ["role:beta", "role:player"]}`))
+	if err := resp.apply(result); err != nil || !slices.Equal(result.User.Roles, []string{"role:beta", "role:player"}) {
+		t.Errorf("roles = %v, %v", result.User.Roles, err)
+	}
+
+	resp, _ = decodeResponse([]byte(`{"reject": "under review", "roles": ["role:beta"]}`))
+	var rejection *Rejection
+	if err := resp.apply(result); !errors.As(err, &rejection) || rejection.Reason != "under review" {
+		t.Errorf("apply = %v, want the rejection", err)
+	}
+
+	if _, err := decodeResponse([]byte(`allow`)); err == nil {
+		t.Error("expected an error for a response that isn't JSON")
+	}
+}
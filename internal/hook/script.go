@@ -0,0 +1,58 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// Script is a hook that runs a local command for each sign-in. The command
+// is given the JSON a Webhook posts on its standard input, and answers as
+// a Webhook's service does on its standard output. A command that exits
+// with a non-zero status, or runs past the timeout, fails the sign-in.
+type Script struct {
+	command []string
+	timeout time.Duration
+}
+
+// NewScript creates a script hook running command, a program and its
+// arguments. It is not run through a shell.
+func NewScript(command []string, timeout time.Duration) *Script {
+	return &Script{command: command, timeout: timeout}
+}
+
+func (s *Script) Run(ctx context.Context, clientID string, result *domain.AuthResult) error {
+	input, err := newRequest(clientID, result)
+	if err != nil {
+		return fmt.Errorf("script hook: %w", err)
+	}
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.command[0], s.command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = errors.Join(err, ctxErr)
+		}
+		msg := strings.TrimSpace(stderr.String())
+		return fmt.Errorf("script hook: %w: %s", err, msg[:min(len(msg), 1024)])
+	}
+	changes, err := decodeResponse(stdout.Bytes())
+	if err != nil {
+		return fmt.Errorf("script hook: decoding output: %w", err)
+	}
+	return changes.apply(result)
+}
@@ -0,0 +1,42 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func TestScript(t *testing.T) {
+	sh := func(script string) *Script {
+		return NewScript([]string{"sh", "-c", script}, time.Second)
+	}
+	newResult := func() *domain.AuthResult {
+		return &domain.AuthResult{User: domain.UserInfo{ProviderName: "steam", ProviderID: "7656"}}
+	}
+
+	// The script reads the sign-in from stdin
+	result := newResult()
+	err := sh(`grep -q '"client_id":"game"' && echo '{"roles": ["role:player"]}'`).Run(context.Background(), "game", result)
+	if err != nil || !slices.Equal(result.User.Roles, []string{"role:player"}) {
+		t.Errorf("Run = %v, roles %v", err, result.User.Roles)
+	}
+
+	if err := sh(`cat >/dev/null`).Run(context.Background(), "game", newResult()); err != nil {
+		t.Errorf("silent script: err = %v", err)
+	}
+	var rejection *Rejection
+	if err := sh(`echo '{"reject": "not whitelisted"}'`).Run(context.Background(), "game", newResult()); !errors.As(err, &rejection) || rejection.Reason != "not whitelisted" {
+		t.Errorf("rejecting script: err = %v", err)
+	}
+	if err := sh(`echo oops >&2; exit 3`).Run(context.Background(), "game", newResult()); err == nil || errors.As(err, &rejection) {
+		t.Errorf("failing script: err = %v, want a failure that isn't a rejection", err)
+	}
+	slow := NewScript([]string{"sleep", "5"}, 50*time.Millisecond)
+	if err := slow.Run(context.Background(), "game", newResult()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("slow script: err = %v, want a timeout", err)
+	}
+}
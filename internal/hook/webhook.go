@@ -0,0 +1,75 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+const maxResponseBytes = 64 << 10
+
+// Webhook is a hook that posts each sign-in as JSON to an operator-run
+// service:
+//
+//	{"client_id": "website", "user": {...}, "factors": ["discord"]}
+//
+// and is answered with an empty body, or with changes to the result:
+//
+//	{"reject": "account is under review"}
+//	{"roles": ["role:beta"]}
+//
+// A response other than 2xx fails the sign-in.
+type Webhook struct {
+	url        string
+	token      string
+	httpClient *http.Client
+}
+
+// NewWebhook creates a webhook hook that gives the service timeout to answer.
+// If token is set it is sent as a bearer token.
+func NewWebhook(url, token string, timeout time.Duration) *Webhook {
+	return &Webhook{
+		url:        url,
+		token:      token,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (wh *Webhook) Run(ctx context.Context, clientID string, result *domain.AuthResult) error {
+	body, err := newRequest(clientID, result)
+	if err != nil {
+		return fmt.Errorf("webhook hook: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook hook: creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wh.token != "" {
+		req.Header.Set("Authorization", "Bearer "+wh.token)
+	}
+
+	resp, err := wh.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook hook: %w", err)
+	}
+	defer resp.Body.Close()
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return fmt.Errorf("webhook hook: reading response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook hook: status %d: %s", resp.StatusCode, strings.TrimSpace(string(answer[:min(len(answer), 1024)])))
+	}
+	changes, err := decodeResponse(answer)
+	if err != nil {
+		return fmt.Errorf("webhook hook: decoding response: %w", err)
+	}
+	return changes.apply(result)
+}
@@ -0,0 +1,64 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func TestWebhook(t *testing.T) {
+	var got request
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+		switch got.User.ProviderID {
+		case "banned":
+			w.Write([]byte(`{"reject": "account is under review"}`))
+		case "broken":
+			http.Error(w, "database down", http.StatusInternalServerError)
+		case "silent":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Write([]byte(`{"roles": ["role:beta"]}`))
+		}
+	}))
+	defer srv.Close()
+	wh := NewWebhook(srv.URL, "hook-token", time.Second)
+	run := func(userID string) (*domain.AuthResult, error) {
+		result := &domain.AuthResult{
+			User:    domain.UserInfo{ProviderName: "discord", ProviderID: userID, Raw: json.RawMessage(`{"secret":1}`)},
+			Factors: []string{"discord"},
+		}
+		return result, wh.Run(context.Background(), "website", result)
+	}
+
+	result, err := run("123")
+	if err != nil || !slices.Equal(result.User.Roles, []string{"role:beta"}) {
+		t.Errorf("Run = %v, roles %v", err, result.User.Roles)
+	}
+	if got.ClientID != "website" || got.User.ProviderID != "123" || got.User.Raw != nil || !slices.Equal(got.Factors, []string{"discord"}) {
+		t.Errorf("hook was sent %+v", got)
+	}
+	if auth != "Bearer hook-token" {
+		t.Errorf("Authorization = %q", auth)
+	}
+
+	if result, err := run("silent"); err != nil || result.User.Roles != nil {
+		t.Errorf("empty answer: Run = %v, roles %v", err, result.User.Roles)
+	}
+	var rejection *Rejection
+	if _, err := run("banned"); !errors.As(err, &rejection) || rejection.Reason != "account is under review" {
+		t.Errorf("rejecting answer: err = %v", err)
+	}
+	if _, err := run("broken"); err == nil || errors.As(err, &rejection) {
+		t.Errorf("failing service: err = %v, want a failure that isn't a rejection", err)
+	}
+}
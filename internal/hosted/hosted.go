@@ -0,0 +1,100 @@
+// Package hosted holds what the sign-in pages CentralAuth serves itself
+// share: the local, LDAP and phone providers' forms and the provider chooser
+// and device pages. They check the flow's state token, show a CAPTCHA to
+// the clients that require one, and bind tickets to the flow.
+package hosted
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+
+	"github.com/BlackMission/centralauth/internal/captcha"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/pages"
+	"github.com/BlackMission/centralauth/internal/state"
+)
+
+// ValidState returns the flow stateToken belongs to. It writes an error
+// page and returns false for page requests that don't belong to a live
+// auth flow with provider.
+func ValidState(w http.ResponseWriter, states *state.Service, provider, stateToken string) (*domain.StatePayload, bool) {
+	if stateToken == "" {
+		http.Error(w, "missing state parameter", http.StatusBadRequest)
+		return nil, false
+	}
+	payload, err := states.Validate(stateToken)
+	if err != nil || payload.Provider != provider {
+		pages.Render(w, http.StatusBadRequest, "unavailable.html", pages.Unavailable{
+			Title:   "Sign-in link expired",
+			Message: "This sign-in link is no longer valid. Go back to the app and start again.",
+		})
+		return nil, false
+	}
+	return payload, true
+}
+
+// Audience binds a ticket to the flow's state token.
+func Audience(stateToken string) string {
+	sum := sha256.Sum256([]byte(stateToken))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Widget returns the CAPTCHA widget for a hosted page, or nil if the page's
+// client doesn't require one or none is configured.
+func Widget(verifier *captcha.Verifier, required bool) *pages.Captcha {
+	if verifier == nil || !required {
+		return nil
+	}
+	return &pages.Captcha{
+		ScriptURL:   verifier.ScriptURL(),
+		WidgetClass: verifier.WidgetClass(),
+		SiteKey:     verifier.SiteKey(),
+	}
+}
+
+// VerifyCaptcha checks the CAPTCHA answered with a form posted from a
+// hosted page for clientID, if Widget put one on it.
+func VerifyCaptcha(r *http.Request, verifier *captcha.Verifier, required bool, clientID string) error {
+	return verifyCaptcha(r, verifier, required, "client_id", clientID)
+}
+
+func verifyCaptcha(r *http.Request, verifier *captcha.Verifier, required bool, logArgs ...any) error {
+	if Widget(verifier, required) == nil {
+		return nil
+	}
+	err := verifier.Verify(r.Context(), r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "captcha: check failed", append(logArgs, "error", err)...)
+	}
+	return err
+}
+
+// Captcha is the CAPTCHA a provider's hosted pages show to the clients that
+// require one. The zero value shows none.
+type Captcha struct {
+	verifier *captcha.Verifier
+	required func(clientID string) bool
+}
+
+// Set makes the pages show v's widget to the clients required reports.
+func (c *Captcha) Set(v *captcha.Verifier, required func(clientID string) bool) {
+	c.verifier = v
+	c.required = required
+}
+
+// For returns the widget to show in flow, or nil if none is required.
+func (c *Captcha) For(flow *domain.StatePayload) *pages.Captcha {
+	return Widget(c.verifier, c.requiredIn(flow))
+}
+
+// Verify checks the CAPTCHA answered with a form posted in flow, if For
+// put one on the page.
+func (c *Captcha) Verify(r *http.Request, flow *domain.StatePayload) error {
+	return verifyCaptcha(r, c.verifier, c.requiredIn(flow), "client_id", flow.ClientID, "flow_id", flow.FlowID)
+}
+
+func (c *Captcha) requiredIn(flow *domain.StatePayload) bool {
+	return c.required != nil && c.required(flow.ClientID)
+}
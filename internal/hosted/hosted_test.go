@@ -0,0 +1,142 @@
+package hosted
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/captcha"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/state"
+)
+
+// newTestCaptcha returns a Turnstile verifier that accepts only the answer
+// "good-token".
+func newTestCaptcha(t *testing.T) *captcha.Verifier {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("response") == "good-token" {
+			w.Write([]byte(`{"success":true}`))
+			return
+		}
+		w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	v, err := captcha.New(captcha.Turnstile, "site-key", "secret")
+	if err != nil {
+		t.Fatalf("captcha.New error: %v", err)
+	}
+	v.SetVerifyURL(srv.URL)
+	return v
+}
+
+func formRequest(answer string) *http.Request {
+	form := url.Values{}
+	if answer != "" {
+		form.Set("cf-turnstile-response", answer)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestValidState(t *testing.T) {
+	states := state.NewService([]byte("test-key-1234567890abcdef"))
+	tok, err := states.Generate(domain.StatePayload{ClientID: "website", Provider: "local"})
+	if err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	flow, ok := ValidState(rr, states, "local", tok)
+	if !ok || flow.ClientID != "website" {
+		t.Fatalf("ValidState = %+v, %v, want the flow", flow, ok)
+	}
+
+	for name, tc := range map[string]struct {
+		provider, token string
+	}{
+		"missing":        {"local", ""},
+		"bogus":          {"local", "bogus"},
+		"other provider": {"ldap", tok},
+	} {
+		rr := httptest.NewRecorder()
+		if _, ok := ValidState(rr, states, tc.provider, tc.token); ok {
+			t.Errorf("%s: ValidState accepted the token", name)
+		}
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, rr.Code)
+		}
+	}
+}
+
+func TestAudience(t *testing.T) {
+	if Audience("a") == Audience("b") {
+		t.Error("expected different state tokens to give different audiences")
+	}
+	if got := len(Audience("a")); got != 32 {
+		t.Errorf("audience length = %d, want 32", got)
+	}
+}
+
+func TestWidget(t *testing.T) {
+	v := newTestCaptcha(t)
+	if c := Widget(v, true); c == nil || c.SiteKey != "site-key" {
+		t.Errorf("Widget = %+v, want the widget", c)
+	}
+	if c := Widget(v, false); c != nil {
+		t.Errorf("Widget = %+v for a client that doesn't require one", c)
+	}
+	if c := Widget(nil, true); c != nil {
+		t.Errorf("Widget = %+v without a verifier", c)
+	}
+}
+
+func TestVerifyCaptcha(t *testing.T) {
+	v := newTestCaptcha(t)
+	if err := VerifyCaptcha(formRequest(""), v, false, "website"); err != nil {
+		t.Errorf("expected no check for a client that doesn't require one, got %v", err)
+	}
+	if err := VerifyCaptcha(formRequest(""), v, true, "website"); err == nil {
+		t.Error("expected an error without a CAPTCHA response")
+	}
+	if err := VerifyCaptcha(formRequest("bad-token"), v, true, "website"); err == nil {
+		t.Error("expected an error for a rejected CAPTCHA")
+	}
+	if err := VerifyCaptcha(formRequest("good-token"), v, true, "website"); err != nil {
+		t.Errorf("VerifyCaptcha error: %v", err)
+	}
+}
+
+func TestCaptcha(t *testing.T) {
+	website := &domain.StatePayload{ClientID: "website"}
+	game := &domain.StatePayload{ClientID: "game"}
+
+	var c Captcha
+	if w := c.For(website); w != nil {
+		t.Errorf("zero Captcha For = %+v, want nil", w)
+	}
+	if err := c.Verify(formRequest(""), website); err != nil {
+		t.Errorf("zero Captcha Verify error: %v", err)
+	}
+
+	c.Set(newTestCaptcha(t), func(clientID string) bool { return clientID == "website" })
+	if w := c.For(website); w == nil {
+		t.Error("expected the widget for a client that requires one")
+	}
+	if w := c.For(game); w != nil {
+		t.Errorf("For = %+v for a client that doesn't require one", w)
+	}
+	if err := c.Verify(formRequest(""), game); err != nil {
+		t.Errorf("expected no check for a client that doesn't require one, got %v", err)
+	}
+	if err := c.Verify(formRequest("bad-token"), website); err == nil {
+		t.Error("expected an error for a rejected CAPTCHA")
+	}
+	if err := c.Verify(formRequest("good-token"), website); err != nil {
+		t.Errorf("Verify error: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/store"
+)
+
+const defaultTTL = 5 * time.Minute
+
+// keyPrefix namespaces the cached responses in the store.
+const keyPrefix = "idempotency/"
+
+// Entry is a cached response replayed for retries carrying the same key.
+type Entry struct {
+	// Fingerprint identifies the request the key was first used with, so a key
+	// reused for a different request is not answered with the wrong result.
+	Fingerprint string `json:"fingerprint"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"` // application/json if empty
+	Body        []byte `json:"body"`
+}
+
+// Cache holds idempotent responses for a short period. With a shared store,
+// a retry is answered by whichever replica it reaches.
+type Cache struct {
+	store store.Store
+	ttl   time.Duration
+}
+
+// NewCache creates a cache that keeps its entries in s for ttl (5 minutes if
+// zero).
+func NewCache(s store.Store, ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	return &Cache{store: s, ttl: ttl}
+}
+
+// Get returns the live entry stored under key. A store that fails is treated
+// as empty, so the request goes ahead rather than failing.
+func (c *Cache) Get(ctx context.Context, key string) (Entry, bool) {
+	data, ok, err := c.store.Get(ctx, keyPrefix+key)
+	if err != nil {
+		slog.WarnContext(ctx, "idempotency: store failed", "store", c.store.String(), "error", err)
+		return Entry{}, false
+	}
+	if !ok {
+		return Entry{}, false
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		slog.WarnContext(ctx, "idempotency: unreadable entry", "key", key, "error", err)
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// Put stores an entry under key, replacing any previous one. Failures are
+// logged; retries then fail like they would without a key.
+func (c *Cache) Put(ctx context.Context, key string, e Entry) {
+	data, err := json.Marshal(e)
+	if err == nil {
+		err = c.store.Set(ctx, keyPrefix+key, data, c.ttl)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "idempotency: store failed", "store", c.store.String(), "error", err)
+	}
+}
@@ -0,0 +1,50 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/store"
+)
+
+func TestPutAndGet(t *testing.T) {
+	c := NewCache(store.NewMemory(), time.Minute)
+	ctx := context.Background()
+	c.Put(ctx, "website:abc", Entry{Fingerprint: "fp", Status: 200, Body: []byte(`{}`)})
+
+	e, ok := c.Get(ctx, "website:abc")
+	if !ok {
+		t.Fatal("expected entry")
+	}
+	if e.Fingerprint != "fp" || e.Status != 200 || string(e.Body) != `{}` {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+}
+
+func TestGetMissing(t *testing.T) {
+	c := NewCache(store.NewMemory(), time.Minute)
+	if _, ok := c.Get(context.Background(), "nope"); ok {
+		t.Error("expected no entry")
+	}
+}
+
+func TestEntryExpires(t *testing.T) {
+	s := store.NewMemory()
+	c := NewCache(s, time.Minute)
+	now := time.Now()
+	s.SetNow(func() time.Time { return now })
+	c.Put(context.Background(), "k", Entry{Status: 200})
+
+	s.SetNow(func() time.Time { return now.Add(61 * time.Second) })
+	if _, ok := c.Get(context.Background(), "k"); ok {
+		t.Error("expected entry to expire")
+	}
+}
+
+func TestDefaultTTL(t *testing.T) {
+	c := NewCache(store.NewMemory(), 0)
+	if c.ttl != defaultTTL {
+		t.Errorf("expected default TTL %v, got %v", defaultTTL, c.ttl)
+	}
+}
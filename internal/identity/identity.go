@@ -0,0 +1,271 @@
+// Package identity gives each user a stable CentralAuth ID, kept in a
+// Postgres or SQLite database, so that client apps can key users the same
+// way whichever client they sign in through.
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/sqldb"
+)
+
+// schema creates the identities table. Each provider account is given a
+// central ID the first time it signs in, and keeps it unless an admin merges
+// it into another or unlinks it.
+var schema = []string{
+	`CREATE TABLE identities (
+		provider    TEXT NOT NULL,
+		provider_id TEXT NOT NULL,
+		central_id  TEXT NOT NULL UNIQUE,
+		created_at  TIMESTAMP NOT NULL,
+		PRIMARY KEY (provider, provider_id)
+	)`,
+	// Merging links several accounts to one central ID, so central_id is no
+	// longer unique. SQLite can't drop a constraint, so the table is rebuilt.
+	`CREATE TABLE identities_linked (
+		provider    TEXT NOT NULL,
+		provider_id TEXT NOT NULL,
+		central_id  TEXT NOT NULL,
+		created_at  TIMESTAMP NOT NULL,
+		PRIMARY KEY (provider, provider_id)
+	)`,
+	`INSERT INTO identities_linked (provider, provider_id, central_id, created_at)
+		SELECT provider, provider_id, central_id, created_at FROM identities`,
+	`DROP TABLE identities`,
+	`ALTER TABLE identities_linked RENAME TO identities`,
+	`CREATE INDEX identities_central_id ON identities (central_id)`,
+	// Each sign-in a client redeems, for the user's login history
+	`CREATE TABLE logins (
+		central_id   TEXT NOT NULL,
+		client_id    TEXT NOT NULL,
+		provider     TEXT NOT NULL,
+		provider_id  TEXT NOT NULL,
+		ip           TEXT NOT NULL,
+		logged_in_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX logins_central_id ON logins (central_id, logged_in_at)`,
+}
+
+const defaultLoginRetention = 90 * 24 * time.Hour
+
+// Identity is a provider account linked to a central ID.
+type Identity struct {
+	Provider   string    `json:"provider"`
+	ProviderID string    `json:"provider_id"`
+	CreatedAt  time.Time `json:"created_at"` // when the account first signed in
+}
+
+// Login is a sign-in to a client, as redeemed by the client.
+type Login struct {
+	ClientID   string    `json:"client_id"`
+	Provider   string    `json:"provider"`
+	ProviderID string    `json:"provider_id"`
+	IP         string    `json:"ip,omitempty"` // the user's, where known
+	Time       time.Time `json:"time"`
+}
+
+// Store assigns central IDs to provider accounts and looks them up, and
+// keeps each user's login history. A nil *Store assigns none.
+type Store struct {
+	db             *sqldb.DB
+	now            func() time.Time
+	rand           io.Reader
+	loginRetention time.Duration
+}
+
+// NewStore returns a store for db, creating the identities table if needed.
+func NewStore(ctx context.Context, db *sqldb.DB) (*Store, error) {
+	if err := db.Migrate(ctx, "identities", schema); err != nil {
+		return nil, err
+	}
+	return &Store{db: db, now: time.Now, rand: rand.Reader, loginRetention: defaultLoginRetention}, nil
+}
+
+// SetLoginRetention sets how long logins are kept (90 days if zero).
+func (s *Store) SetLoginRetention(d time.Duration) {
+	if d <= 0 {
+		d = defaultLoginRetention
+	}
+	s.loginRetention = d
+}
+
+// CentralID returns the central ID of the account providerID at provider,
+// assigning it one the first time it is seen.
+func (s *Store) CentralID(ctx context.Context, provider, providerID string) (string, error) {
+	if s == nil {
+		return "", nil
+	}
+	id, err := s.lookup(ctx, provider, providerID)
+	if err == nil || !errors.Is(err, sql.ErrNoRows) {
+		return id, err
+	}
+
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(s.rand, b); err != nil {
+		return "", fmt.Errorf("generating central ID: %w", err)
+	}
+	// Two replicas seeing the account at once both insert; the first wins
+	// and the other reads its ID back.
+	if _, err := s.db.Exec(ctx, `INSERT INTO identities (provider, provider_id, central_id, created_at)
+		VALUES (?, ?, ?, ?) ON CONFLICT (provider, provider_id) DO NOTHING`,
+		provider, providerID, hex.EncodeToString(b), s.now().UTC()); err != nil {
+		return "", fmt.Errorf("assigning central ID: %w", err)
+	}
+	return s.lookup(ctx, provider, providerID)
+}
+
+// Find returns the central ID of the account providerID at provider, or
+// domain.ErrAccountNotFound if it has never signed in.
+func (s *Store) Find(ctx context.Context, provider, providerID string) (string, error) {
+	id, err := s.lookup(ctx, provider, providerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", domain.ErrAccountNotFound
+	}
+	return id, err
+}
+
+// Identities returns the provider accounts linked to centralID, oldest
+// first, or domain.ErrAccountNotFound if there are none.
+func (s *Store) Identities(ctx context.Context, centralID string) ([]Identity, error) {
+	rows, err := s.db.Query(ctx, `SELECT provider, provider_id, created_at FROM identities
+		WHERE central_id = ? ORDER BY created_at, provider`, centralID)
+	if err != nil {
+		return nil, fmt.Errorf("reading identities table: %w", err)
+	}
+	defer rows.Close()
+
+	var linked []Identity
+	for rows.Next() {
+		var id Identity
+		if err := rows.Scan(&id.Provider, &id.ProviderID, &id.CreatedAt); err != nil {
+			return nil, fmt.Errorf("reading identities table: %w", err)
+		}
+		linked = append(linked, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading identities table: %w", err)
+	}
+	if len(linked) == 0 {
+		return nil, domain.ErrAccountNotFound
+	}
+	return linked, nil
+}
+
+// Unlink removes the account providerID at provider from centralID. It is
+// given a new central ID the next time it signs in. Unlink returns
+// domain.ErrAccountNotFound if the account isn't linked to centralID, and
+// domain.ErrLastIdentity if it is the only account linked to it.
+func (s *Store) Unlink(ctx context.Context, centralID, provider, providerID string) error {
+	linked, err := s.Identities(ctx, centralID)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(linked, func(id Identity) bool { return id.Provider == provider && id.ProviderID == providerID }) {
+		return domain.ErrAccountNotFound
+	}
+	if len(linked) == 1 {
+		return domain.ErrLastIdentity
+	}
+	if _, err := s.db.Exec(ctx, `DELETE FROM identities WHERE central_id = ? AND provider = ? AND provider_id = ?`,
+		centralID, provider, providerID); err != nil {
+		return fmt.Errorf("unlinking identity: %w", err)
+	}
+	return nil
+}
+
+// Merge links the accounts of the central ID from to into, for a user who
+// signed in with two accounts before they were linked. from is left with no
+// accounts. Merge returns domain.ErrAccountNotFound if either ID has none.
+func (s *Store) Merge(ctx context.Context, from, into string) ([]Identity, error) {
+	if _, err := s.Identities(ctx, into); err != nil {
+		return nil, err
+	}
+	res, err := s.db.Exec(ctx, `UPDATE identities SET central_id = ? WHERE central_id = ?`, into, from)
+	if err != nil {
+		return nil, fmt.Errorf("merging identities: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, domain.ErrAccountNotFound
+	}
+	if _, err := s.db.Exec(ctx, `UPDATE logins SET central_id = ? WHERE central_id = ?`, into, from); err != nil {
+		return nil, fmt.Errorf("merging login histories: %w", err)
+	}
+	return s.Identities(ctx, into)
+}
+
+// RecordLogin adds login to centralID's login history, dropping the logins
+// older than the retention period.
+func (s *Store) RecordLogin(ctx context.Context, centralID string, login Login) error {
+	if s == nil {
+		return nil
+	}
+	now := s.now().UTC()
+	if login.Time.IsZero() {
+		login.Time = now
+	}
+	if _, err := s.db.Exec(ctx, `INSERT INTO logins (central_id, client_id, provider, provider_id, ip, logged_in_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, centralID, login.ClientID, login.Provider, login.ProviderID, login.IP, login.Time); err != nil {
+		return fmt.Errorf("recording login: %w", err)
+	}
+	if _, err := s.db.Exec(ctx, `DELETE FROM logins WHERE central_id = ? AND logged_in_at < ?`,
+		centralID, now.Add(-s.loginRetention)); err != nil {
+		return fmt.Errorf("pruning logins: %w", err)
+	}
+	return nil
+}
+
+// Logins returns up to limit of centralID's most recent logins, newest
+// first.
+func (s *Store) Logins(ctx context.Context, centralID string, limit int) ([]Login, error) {
+	rows, err := s.db.Query(ctx, `SELECT client_id, provider, provider_id, ip, logged_in_at FROM logins
+		WHERE central_id = ? ORDER BY logged_in_at DESC LIMIT ?`, centralID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("reading logins table: %w", err)
+	}
+	defer rows.Close()
+
+	logins := []Login{}
+	for rows.Next() {
+		var l Login
+		if err := rows.Scan(&l.ClientID, &l.Provider, &l.ProviderID, &l.IP, &l.Time); err != nil {
+			return nil, fmt.Errorf("reading logins table: %w", err)
+		}
+		logins = append(logins, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading logins table: %w", err)
+	}
+	return logins, nil
+}
+
+func (s *Store) lookup(ctx context.Context, provider, providerID string) (string, error) {
+	var id string
+	err := s.db.QueryRow(ctx, `SELECT central_id FROM identities WHERE provider = ? AND provider_id = ?`, provider, providerID).Scan(&id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("reading identities table: %w", err)
+	}
+	return id, err
+}
+
+// Ping checks that the database is reachable.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx)
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) String() string {
+	return s.db.Dialect()
+}
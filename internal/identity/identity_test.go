@@ -0,0 +1,270 @@
+package identity
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/sqldb"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+// identitiesTable fakes a database holding the identities table, keyed by
+// provider and provider ID, and the logins table.
+type identitiesTable struct {
+	rows    map[[2]string]string
+	logins  [][]driver.Value // in column order
+	inserts int
+	err     error
+}
+
+func newIdentitiesTable(t *testing.T) (*identitiesTable, *Store) {
+	t.Helper()
+	tbl := &identitiesTable{rows: map[[2]string]string{}}
+	s, err := NewStore(context.Background(), sqldb.New(testutil.OpenSQL(t, tbl.handle), sqldb.SQLite))
+	if err != nil {
+		t.Fatalf("NewStore error: %v", err)
+	}
+	return tbl, s
+}
+
+func (tbl *identitiesTable) handle(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT version"):
+		return []string{"version"}, nil, nil
+	case tbl.err != nil:
+		return nil, nil, tbl.err
+	case strings.HasPrefix(query, "SELECT central_id"):
+		id, ok := tbl.rows[[2]string{args[0].(string), args[1].(string)}]
+		if !ok {
+			return []string{"central_id"}, nil, nil
+		}
+		return []string{"central_id"}, [][]driver.Value{{id}}, nil
+	case strings.HasPrefix(query, "SELECT provider"):
+		var rows [][]driver.Value
+		for key, id := range tbl.rows {
+			if id == args[0] {
+				rows = append(rows, []driver.Value{key[0], key[1], time.Time{}})
+			}
+		}
+		slices.SortFunc(rows, func(a, b []driver.Value) int { return strings.Compare(a[0].(string), b[0].(string)) })
+		return []string{"provider", "provider_id", "created_at"}, rows, nil
+	case strings.HasPrefix(query, "DELETE FROM identities"):
+		key := [2]string{args[1].(string), args[2].(string)}
+		if tbl.rows[key] != args[0] {
+			return nil, nil, nil
+		}
+		delete(tbl.rows, key)
+		return nil, [][]driver.Value{{}}, nil
+	case strings.HasPrefix(query, "UPDATE identities"):
+		var moved [][]driver.Value
+		for key, id := range tbl.rows {
+			if id == args[1] {
+				tbl.rows[key] = args[0].(string)
+				moved = append(moved, []driver.Value{})
+			}
+		}
+		return nil, moved, nil
+	case strings.HasPrefix(query, "INSERT INTO logins"):
+		tbl.logins = append(tbl.logins, args)
+		return nil, [][]driver.Value{{}}, nil
+	case strings.HasPrefix(query, "DELETE FROM logins"):
+		tbl.logins = slices.DeleteFunc(tbl.logins, func(row []driver.Value) bool {
+			return row[0] == args[0] && row[5].(time.Time).Before(args[1].(time.Time))
+		})
+		return nil, nil, nil
+	case strings.HasPrefix(query, "UPDATE logins"):
+		for _, row := range tbl.logins {
+			if row[0] == args[1] {
+				row[0] = args[0]
+			}
+		}
+		return nil, nil, nil
+	case strings.HasPrefix(query, "SELECT client_id"):
+		var rows [][]driver.Value
+		for _, row := range slices.Backward(tbl.logins) {
+			if row[0] == args[0] && len(rows) < int(args[1].(int64)) {
+				rows = append(rows, row[1:])
+			}
+		}
+		return []string{"client_id", "provider", "provider_id", "ip", "logged_in_at"}, rows, nil
+	case strings.HasPrefix(query, "INSERT INTO identities ("):
+		tbl.inserts++
+		key := [2]string{args[0].(string), args[1].(string)}
+		if _, exists := tbl.rows[key]; exists {
+			return nil, nil, nil
+		}
+		tbl.rows[key] = args[2].(string)
+		return nil, [][]driver.Value{{}}, nil
+	}
+	return nil, nil, nil
+}
+
+func TestStore_CentralID(t *testing.T) {
+	ctx := context.Background()
+	tbl, s := newIdentitiesTable(t)
+
+	id, err := s.CentralID(ctx, "discord", "123")
+	if err != nil || len(id) != 32 {
+		t.Fatalf("CentralID = %q, %v", id, err)
+	}
+	if again, err := s.CentralID(ctx, "discord", "123"); err != nil || again != id {
+		t.Errorf("second CentralID = %q, %v; want %q", again, err, id)
+	}
+	if tbl.inserts != 1 {
+		t.Errorf("%d inserts, want 1", tbl.inserts)
+	}
+	if other, _ := s.CentralID(ctx, "steam", "123"); other == "" || other == id {
+		t.Errorf("another provider's account got %q", other)
+	}
+}
+
+func TestStore_CentralIDRace(t *testing.T) {
+	tbl, s := newIdentitiesTable(t)
+	// Another replica assigns the account an ID between the lookup and the
+	// insert; its ID is the one returned
+	s.rand = racingReader{tbl}
+	if id, err := s.CentralID(context.Background(), "discord", "123"); err != nil || id != "theirs" {
+		t.Errorf("CentralID = %q, %v; want the other replica's", id, err)
+	}
+}
+
+type racingReader struct{ tbl *identitiesTable }
+
+func (r racingReader) Read(p []byte) (int, error) {
+	r.tbl.rows[[2]string{"discord", "123"}] = "theirs"
+	return len(p), nil
+}
+
+func TestStore_Find(t *testing.T) {
+	ctx := context.Background()
+	tbl, s := newIdentitiesTable(t)
+	if _, err := s.Find(ctx, "steam", "7656"); !errors.Is(err, domain.ErrAccountNotFound) {
+		t.Errorf("Find before sign-in: err = %v, want ErrAccountNotFound", err)
+	}
+	if tbl.inserts != 0 {
+		t.Error("Find assigned a central ID")
+	}
+
+	id, _ := s.CentralID(ctx, "steam", "7656")
+	if found, err := s.Find(ctx, "steam", "7656"); err != nil || found != id {
+		t.Errorf("Find = %q, %v; want %q", found, err, id)
+	}
+}
+
+func TestStore_Identities(t *testing.T) {
+	ctx := context.Background()
+	tbl, s := newIdentitiesTable(t)
+	tbl.rows[[2]string{"steam", "7656"}] = "c0ffee"
+	tbl.rows[[2]string{"discord", "123"}] = "c0ffee"
+	tbl.rows[[2]string{"discord", "456"}] = "someone-else"
+
+	linked, err := s.Identities(ctx, "c0ffee")
+	if err != nil || len(linked) != 2 || linked[0].Provider != "discord" || linked[1].ProviderID != "7656" {
+		t.Errorf("Identities = %+v, %v", linked, err)
+	}
+	if _, err := s.Identities(ctx, "unknown"); !errors.Is(err, domain.ErrAccountNotFound) {
+		t.Errorf("Identities of an unknown ID: err = %v, want ErrAccountNotFound", err)
+	}
+}
+
+func TestStore_Merge(t *testing.T) {
+	ctx := context.Background()
+	tbl, s := newIdentitiesTable(t)
+	tbl.rows[[2]string{"steam", "7656"}] = "c0ffee"
+	tbl.rows[[2]string{"discord", "123"}] = "decade"
+
+	linked, err := s.Merge(ctx, "decade", "c0ffee")
+	if err != nil || len(linked) != 2 {
+		t.Fatalf("Merge = %+v, %v", linked, err)
+	}
+	if id, _ := s.Find(ctx, "discord", "123"); id != "c0ffee" {
+		t.Errorf("merged account has central ID %q", id)
+	}
+	if _, err := s.Merge(ctx, "decade", "c0ffee"); !errors.Is(err, domain.ErrAccountNotFound) {
+		t.Errorf("merging an empty ID: err = %v, want ErrAccountNotFound", err)
+	}
+	if _, err := s.Merge(ctx, "c0ffee", "unknown"); !errors.Is(err, domain.ErrAccountNotFound) {
+		t.Errorf("merging into an unknown ID: err = %v, want ErrAccountNotFound", err)
+	}
+}
+
+func TestStore_Unlink(t *testing.T) {
+	ctx := context.Background()
+	tbl, s := newIdentitiesTable(t)
+	tbl.rows[[2]string{"steam", "7656"}] = "c0ffee"
+	tbl.rows[[2]string{"discord", "123"}] = "c0ffee"
+
+	if err := s.Unlink(ctx, "decade", "discord", "123"); !errors.Is(err, domain.ErrAccountNotFound) {
+		t.Errorf("unlinking from another user: err = %v, want ErrAccountNotFound", err)
+	}
+	if err := s.Unlink(ctx, "c0ffee", "discord", "123"); err != nil {
+		t.Fatalf("Unlink error: %v", err)
+	}
+	// The account is given a new central ID the next time it signs in
+	if id, _ := s.CentralID(ctx, "discord", "123"); id == "c0ffee" {
+		t.Error("unlinked account kept its central ID")
+	}
+	if err := s.Unlink(ctx, "c0ffee", "steam", "7656"); !errors.Is(err, domain.ErrLastIdentity) {
+		t.Errorf("unlinking the last account: err = %v, want ErrLastIdentity", err)
+	}
+}
+
+func TestStore_Logins(t *testing.T) {
+	ctx := context.Background()
+	tbl, s := newIdentitiesTable(t)
+	tbl.rows[[2]string{"discord", "123"}] = "c0ffee"
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	s.SetLoginRetention(48 * time.Hour)
+
+	for _, login := range []Login{
+		{ClientID: "website", Provider: "discord", ProviderID: "123", IP: "203.0.113.7", Time: now.Add(-72 * time.Hour)},
+		{ClientID: "website", Provider: "discord", ProviderID: "123", IP: "203.0.113.7", Time: now.Add(-time.Hour)},
+		{ClientID: "game", Provider: "steam", ProviderID: "7656"},
+	} {
+		if err := s.RecordLogin(ctx, "c0ffee", login); err != nil {
+			t.Fatalf("RecordLogin error: %v", err)
+		}
+	}
+	s.RecordLogin(ctx, "decade", Login{ClientID: "website", Provider: "discord", ProviderID: "456"})
+
+	// Newest first, without the one past the retention period
+	logins, err := s.Logins(ctx, "c0ffee", 10)
+	if err != nil || len(logins) != 2 || logins[0].ClientID != "game" || !logins[0].Time.Equal(now) || logins[1].IP != "203.0.113.7" {
+		t.Fatalf("Logins = %+v, %v", logins, err)
+	}
+	if logins, _ := s.Logins(ctx, "c0ffee", 1); len(logins) != 1 {
+		t.Errorf("Logins with limit 1 returned %d", len(logins))
+	}
+
+	// Merging users merges their histories
+	tbl.rows[[2]string{"discord", "456"}] = "decade"
+	s.Merge(ctx, "decade", "c0ffee")
+	if logins, _ := s.Logins(ctx, "c0ffee", 10); len(logins) != 3 {
+		t.Errorf("after Merge, Logins returned %d, want 3", len(logins))
+	}
+
+	var none *Store
+	if err := none.RecordLogin(ctx, "c0ffee", Login{}); err != nil {
+		t.Errorf("nil Store RecordLogin error: %v", err)
+	}
+}
+
+func TestStore_Errors(t *testing.T) {
+	tbl, s := newIdentitiesTable(t)
+	tbl.err = errors.New("connection refused")
+	if _, err := s.CentralID(context.Background(), "discord", "123"); err == nil {
+		t.Error("expected an error from a failing database")
+	}
+
+	var none *Store
+	if id, err := none.CentralID(context.Background(), "discord", "123"); id != "" || err != nil {
+		t.Errorf("nil Store CentralID = %q, %v", id, err)
+	}
+}
@@ -0,0 +1,142 @@
+package idtoken
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// DefaultTTL is how long identity tokens are valid when no TTL is configured.
+const DefaultTTL = 5 * time.Minute
+
+// Claims are the claims of an identity token. The subject is the user's
+// "provider:provider_id" and the audience the client the token was issued to.
+type Claims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  string          `json:"aud"`
+	IssuedAt  int64           `json:"iat"`
+	ExpiresAt int64           `json:"exp"`
+	Scope     string          `json:"scope,omitempty"`
+	Factors   []string        `json:"amr,omitempty"`
+	User      domain.UserInfo `json:"user"`
+}
+
+// Issuer issues identity tokens: signed JWTs carrying an exchange result's
+// user, which services can pass around and verify offline.
+type Issuer struct {
+	signer    *Signer
+	published []*Signer
+	issuer    string
+	ttl       time.Duration
+	now       func() time.Time
+}
+
+// JWKSet is a JSON Web Key Set, as served on /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewIssuer creates an issuer that signs with signer and names itself issuer.
+// A zero ttl means DefaultTTL. The published signers sign nothing but have
+// their keys published alongside signer's: the key rotated away from, so
+// that tokens issued before a rotation verify until they expire, and the
+// one to rotate to, so that verifiers have it before it signs anything.
+func NewIssuer(signer *Signer, issuer string, ttl time.Duration, published ...*Signer) *Issuer {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Issuer{signer: signer, published: published, issuer: issuer, ttl: ttl, now: time.Now}
+}
+
+// SetNow overrides the time function (for testing).
+func (i *Issuer) SetNow(fn func() time.Time) {
+	i.now = fn
+}
+
+// Signer returns the signer the issuer signs with.
+func (i *Issuer) Signer() *Signer {
+	return i.signer
+}
+
+// Issue returns an identity token for result, issued to clientID. Provider
+// tokens are never put in it.
+func (i *Issuer) Issue(clientID string, result domain.AuthResult) (string, error) {
+	now := i.now()
+	token, err := i.signer.Sign(Claims{
+		Issuer:    i.issuer,
+		Subject:   subject(result.User),
+		Audience:  clientID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(i.ttl).Unix(),
+		Scope:     result.Scope,
+		Factors:   result.Factors,
+		User:      result.User,
+	})
+	if err != nil {
+		return "", fmt.Errorf("issuing identity token: %w", err)
+	}
+	return token, nil
+}
+
+// Check verifies an identity token this issuer issued and returns its
+// claims, failing for tokens that have expired or aren't identity tokens.
+func (i *Issuer) Check(token string) (Claims, error) {
+	var claims Claims
+	if err := i.Verify(token, &claims); err != nil {
+		return Claims{}, err
+	}
+	if claims.Issuer != i.issuer || claims.User.ProviderID == "" {
+		return Claims{}, errors.New("not an identity token")
+	}
+	if i.now().Unix() >= claims.ExpiresAt {
+		return Claims{}, errors.New("token expired")
+	}
+	return claims, nil
+}
+
+// Name returns the issuer's "iss".
+func (i *Issuer) Name() string {
+	return i.issuer
+}
+
+// TTL returns how long the tokens it issues are valid.
+func (i *Issuer) TTL() time.Duration {
+	return i.ttl
+}
+
+// JWKS returns the public keys of the signer and the published signers, the
+// signer's first.
+func (i *Issuer) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{i.signer.PublicJWK()}}
+	for _, s := range i.published {
+		set.Keys = append(set.Keys, s.PublicJWK())
+	}
+	return set
+}
+
+// Verify checks token's signature with the published key its kid names and
+// decodes its claims into v. It doesn't check the claims.
+func (i *Issuer) Verify(token string, v any) error {
+	kid := keyID(token)
+	for _, s := range append([]*Signer{i.signer}, i.published...) {
+		if s.KeyID() == kid {
+			return s.Verify(token, v)
+		}
+	}
+	return fmt.Errorf("unknown signing key %q", kid)
+}
+
+// subject is the "sub" of user's tokens.
+func subject(user domain.UserInfo) string {
+	return user.ProviderName + ":" + user.ProviderID
+}
+
+func (i *Issuer) String() string {
+	if len(i.published) > 0 {
+		return fmt.Sprintf("%s key %s (%d more published)", i.signer.Algorithm(), i.signer.KeyID(), len(i.published))
+	}
+	return fmt.Sprintf("%s key %s", i.signer.Algorithm(), i.signer.KeyID())
+}
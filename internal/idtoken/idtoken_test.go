@@ -0,0 +1,113 @@
+package idtoken
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestIssuer_Issue(t *testing.T) {
+	signer, err := NewSigner(testutil.SigningKeyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := NewIssuer(signer, "https://auth.example.com", 0)
+	now := time.Unix(1_700_000_000, 0)
+	iss.SetNow(func() time.Time { return now })
+
+	token, err := iss.Issue("webapp", domain.AuthResult{
+		User:    domain.UserInfo{ProviderName: "discord", ProviderID: "123", Username: "alice"},
+		Factors: []string{"discord", "totp"},
+		Scope:   "profile",
+		Tokens:  &domain.ProviderTokens{AccessToken: "provider-secret"},
+	})
+	if err != nil {
+		t.Fatalf("Issue error: %v", err)
+	}
+
+	var claims Claims
+	if err := signer.Verify(token, &claims); err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+	if claims.Issuer != "https://auth.example.com" || claims.Subject != "discord:123" || claims.Audience != "webapp" {
+		t.Errorf("iss, sub, aud = %q, %q, %q", claims.Issuer, claims.Subject, claims.Audience)
+	}
+	if claims.IssuedAt != now.Unix() || claims.ExpiresAt != now.Add(DefaultTTL).Unix() {
+		t.Errorf("iat, exp = %d, %d", claims.IssuedAt, claims.ExpiresAt)
+	}
+	if claims.Scope != "profile" || !slices.Equal(claims.Factors, []string{"discord", "totp"}) || claims.User.Username != "alice" {
+		t.Errorf("claims = %+v", claims)
+	}
+	var raw map[string]any
+	signer.Verify(token, &raw)
+	for _, k := range []string{"provider_tokens", "tok"} {
+		if _, ok := raw[k]; ok {
+			t.Errorf("token carries %s", k)
+		}
+	}
+}
+
+func TestIssuer_Rotation(t *testing.T) {
+	oldKey, _ := NewSigner(testutil.SigningKeyPEM(t))
+	current, _ := NewSigner(testutil.SigningKeyPEM(t))
+	next, _ := NewSigner(testutil.SigningKeyPEM(t))
+	user := domain.AuthResult{User: domain.UserInfo{ProviderName: "discord", ProviderID: "123"}}
+
+	before, _ := NewIssuer(oldKey, "https://auth.example.com", 0).Issue("webapp", user)
+	iss := NewIssuer(current, "https://auth.example.com", 0, oldKey, next)
+	after, _ := iss.Issue("webapp", user)
+
+	var kids []string
+	for _, k := range iss.JWKS().Keys {
+		kids = append(kids, k.Kid)
+	}
+	if !slices.Equal(kids, []string{current.KeyID(), oldKey.KeyID(), next.KeyID()}) {
+		t.Errorf("JWKS kids = %v", kids)
+	}
+	if keyID(after) != current.KeyID() {
+		t.Errorf("token signed with %q, want the current key", keyID(after))
+	}
+
+	var claims Claims
+	for name, token := range map[string]string{"before": before, "after": after} {
+		if err := iss.Verify(token, &claims); err != nil {
+			t.Errorf("%s rotation: Verify error: %v", name, err)
+		}
+	}
+	stranger, _ := NewSigner(testutil.SigningKeyPEM(t))
+	forged, _ := NewIssuer(stranger, "https://auth.example.com", 0).Issue("webapp", user)
+	if err := iss.Verify(forged, &claims); err == nil {
+		t.Error("expected a token from an unpublished key to fail")
+	}
+}
+
+func TestIssuer_Check(t *testing.T) {
+	signer, _ := NewSigner(testutil.SigningKeyPEM(t))
+	iss := NewIssuer(signer, "https://auth.example.com", time.Minute)
+	now := time.Unix(1_700_000_000, 0)
+	iss.SetNow(func() time.Time { return now })
+	result := domain.AuthResult{User: domain.UserInfo{ProviderName: "discord", ProviderID: "123", Username: "alice"}}
+
+	token, _ := iss.Issue("webapp", result)
+	claims, err := iss.Check(token)
+	if err != nil || claims.User.Username != "alice" {
+		t.Fatalf("Check = %+v, %v", claims, err)
+	}
+
+	// ID tokens carry the user as standard claims, not as an identity token
+	oidc, _ := iss.IssueOIDC("webapp", "n", result)
+	if _, err := iss.Check(oidc); err == nil {
+		t.Error("expected an ID token to be refused")
+	}
+	other, _ := NewIssuer(signer, "https://other.example.com", 0).Issue("webapp", result)
+	if _, err := iss.Check(other); err == nil {
+		t.Error("expected another issuer's token to be refused")
+	}
+	now = now.Add(time.Minute)
+	if _, err := iss.Check(token); err == nil {
+		t.Error("expected an expired token to be refused")
+	}
+}
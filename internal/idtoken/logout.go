@@ -0,0 +1,48 @@
+package idtoken
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// BackchannelLogoutEvent is the event a logout token carries (OpenID
+// Connect Back-Channel Logout 1.0).
+const BackchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// LogoutClaims are the claims of a logout token, which tells a client that
+// a user has signed out.
+type LogoutClaims struct {
+	Issuer    string                    `json:"iss"`
+	Subject   string                    `json:"sub"`
+	Audience  string                    `json:"aud"`
+	IssuedAt  int64                     `json:"iat"`
+	ExpiresAt int64                     `json:"exp"`
+	ID        string                    `json:"jti"`
+	Events    map[string]map[string]any `json:"events"`
+}
+
+// IssueLogout returns a logout token telling clientID that user has signed
+// out.
+func (i *Issuer) IssueLogout(clientID string, user domain.UserInfo) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("issuing logout token: %w", err)
+	}
+	now := i.now()
+	token, err := i.signer.Sign(LogoutClaims{
+		Issuer:    i.issuer,
+		Subject:   subject(user),
+		Audience:  clientID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(i.ttl).Unix(),
+		ID:        base64.RawURLEncoding.EncodeToString(b),
+		Events:    map[string]map[string]any{BackchannelLogoutEvent: {}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("issuing logout token: %w", err)
+	}
+	return token, nil
+}
@@ -0,0 +1,37 @@
+package idtoken
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestIssuer_IssueLogout(t *testing.T) {
+	signer, _ := NewSigner(testutil.SigningKeyPEM(t))
+	iss := NewIssuer(signer, "https://auth.example.com", 0)
+	now := time.Unix(1_700_000_000, 0)
+	iss.SetNow(func() time.Time { return now })
+
+	token, err := iss.IssueLogout("forum", domain.UserInfo{ProviderName: "discord", ProviderID: "123"})
+	if err != nil {
+		t.Fatalf("IssueLogout error: %v", err)
+	}
+	var claims LogoutClaims
+	if err := iss.Verify(token, &claims); err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+	if claims.Issuer != "https://auth.example.com" || claims.Subject != "discord:123" || claims.Audience != "forum" ||
+		claims.IssuedAt != now.Unix() || claims.ID == "" {
+		t.Errorf("claims = %+v", claims)
+	}
+	if _, ok := claims.Events[BackchannelLogoutEvent]; !ok {
+		t.Errorf("events = %v", claims.Events)
+	}
+
+	// A logout token doesn't pass for an identity token
+	if _, err := iss.Check(token); err == nil {
+		t.Error("expected Check to reject a logout token")
+	}
+}
@@ -0,0 +1,57 @@
+package idtoken
+
+import (
+	"fmt"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// UserClaims returns user as OpenID Connect standard claims, keyed by claim
+// name. Fields the user doesn't have are left out; the provider is added as
+// the "provider" claim.
+func UserClaims(user domain.UserInfo) map[string]any {
+	claims := map[string]any{
+		"sub":      subject(user),
+		"provider": user.ProviderName,
+	}
+	for name, v := range map[string]string{
+		"preferred_username": user.Username,
+		"name":               user.DisplayName,
+		"picture":            user.AvatarURL,
+		"email":              user.Email,
+		"locale":             user.Locale,
+	} {
+		if v != "" {
+			claims[name] = v
+		}
+	}
+	if user.Email != "" {
+		claims["email_verified"] = user.EmailVerified
+	}
+	if len(user.Roles) > 0 {
+		claims["roles"] = user.Roles
+	}
+	return claims
+}
+
+// IssueOIDC returns an OpenID Connect ID token for result, issued to
+// clientID in answer to an authorization request with nonce.
+func (i *Issuer) IssueOIDC(clientID, nonce string, result domain.AuthResult) (string, error) {
+	now := i.now()
+	claims := UserClaims(result.User)
+	claims["iss"] = i.issuer
+	claims["aud"] = clientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(i.ttl).Unix()
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if len(result.Factors) > 0 {
+		claims["amr"] = result.Factors
+	}
+	token, err := i.signer.Sign(claims)
+	if err != nil {
+		return "", fmt.Errorf("issuing ID token: %w", err)
+	}
+	return token, nil
+}
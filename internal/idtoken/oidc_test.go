@@ -0,0 +1,65 @@
+package idtoken
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestUserClaims(t *testing.T) {
+	claims := UserClaims(domain.UserInfo{ProviderName: "github", ProviderID: "42", Username: "alice", Email: "alice@example.com"})
+	if claims["sub"] != "github:42" || claims["provider"] != "github" || claims["preferred_username"] != "alice" {
+		t.Errorf("claims = %v", claims)
+	}
+	if claims["email_verified"] != false {
+		t.Errorf("email_verified = %v, want false", claims["email_verified"])
+	}
+	if _, ok := claims["name"]; ok {
+		t.Error("expected no name claim for a user without a display name")
+	}
+	if _, ok := claims["roles"]; ok {
+		t.Error("expected no roles claim for a user without roles")
+	}
+
+	claims = UserClaims(domain.UserInfo{ProviderName: "discord", ProviderID: "123", Roles: []string{"role:moderator"}})
+	if roles, _ := claims["roles"].([]string); len(roles) != 1 || roles[0] != "role:moderator" {
+		t.Errorf("roles = %v", claims["roles"])
+	}
+}
+
+func TestIssuer_IssueOIDC(t *testing.T) {
+	signer, _ := NewSigner(testutil.SigningKeyPEM(t))
+	iss := NewIssuer(signer, "https://auth.example.com", 0)
+	now := time.Unix(1_700_000_000, 0)
+	iss.SetNow(func() time.Time { return now })
+
+	token, err := iss.IssueOIDC("grafana", "n-0S6", domain.AuthResult{
+		User:    domain.UserInfo{ProviderName: "discord", ProviderID: "123", DisplayName: "Alice"},
+		Factors: []string{"discord", "totp"},
+	})
+	if err != nil {
+		t.Fatalf("IssueOIDC error: %v", err)
+	}
+	var claims struct {
+		Issuer    string   `json:"iss"`
+		Subject   string   `json:"sub"`
+		Audience  string   `json:"aud"`
+		ExpiresAt int64    `json:"exp"`
+		Nonce     string   `json:"nonce"`
+		AMR       []string `json:"amr"`
+		Name      string   `json:"name"`
+	}
+	if err := iss.Verify(token, &claims); err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+	if claims.Issuer != "https://auth.example.com" || claims.Subject != "discord:123" || claims.Audience != "grafana" ||
+		claims.Nonce != "n-0S6" || claims.Name != "Alice" || claims.ExpiresAt != now.Add(DefaultTTL).Unix() {
+		t.Errorf("claims = %+v", claims)
+	}
+	if !slices.Equal(claims.AMR, []string{"discord", "totp"}) {
+		t.Errorf("amr = %v", claims.AMR)
+	}
+}
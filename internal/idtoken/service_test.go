@@ -0,0 +1,42 @@
+package idtoken
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestIssuer_IssueService(t *testing.T) {
+	signer, _ := NewSigner(testutil.SigningKeyPEM(t))
+	iss := NewIssuer(signer, "https://auth.example.com", 0)
+	now := time.Unix(1_700_000_000, 0)
+	iss.SetNow(func() time.Time { return now })
+
+	token, err := iss.IssueService(&domain.ClientApp{ID: "gameserver", Name: "Game Server"}, "stats")
+	if err != nil {
+		t.Fatalf("IssueService error: %v", err)
+	}
+	var claims ServiceClaims
+	if err := iss.Verify(token, &claims); err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+	want := ServiceClaims{
+		Issuer:     "https://auth.example.com",
+		Subject:    "client:gameserver",
+		Audience:   "stats",
+		IssuedAt:   now.Unix(),
+		ExpiresAt:  now.Add(DefaultTTL).Unix(),
+		ClientID:   "gameserver",
+		ClientName: "Game Server",
+	}
+	if claims != want {
+		t.Errorf("claims = %+v, want %+v", claims, want)
+	}
+
+	// A service token doesn't pass for a user's
+	if _, err := iss.Check(token); err == nil {
+		t.Error("expected Check to reject a service token")
+	}
+}
@@ -0,0 +1,205 @@
+package idtoken
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Signing algorithms, named as in the JWT "alg" header.
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// minRSABits is the smallest RSA key accepted for signing.
+const minRSABits = 2048
+
+// JWK is a public key as a JSON Web Key (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Crv string `json:"crv,omitempty"` // OKP
+	X   string `json:"x,omitempty"`   // OKP
+	N   string `json:"n,omitempty"`   // RSA
+	E   string `json:"e,omitempty"`   // RSA
+}
+
+// Signer signs JWTs with an RSA or Ed25519 private key.
+type Signer struct {
+	key crypto.Signer
+	alg string
+	kid string
+}
+
+// NewSigner creates a signer from a PEM-encoded private key: PKCS #8 RSA or
+// Ed25519, or PKCS #1 RSA. RSA keys sign with RS256 and Ed25519 keys with
+// EdDSA. The key ID is the key's RFC 7638 thumbprint.
+func NewSigner(pemKey []byte) (*Signer, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("signing key is not PEM")
+	}
+	var key any
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing signing key: %w", err)
+	}
+
+	s := &Signer{}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA signing key has %d bits, need at least %d", k.N.BitLen(), minRSABits)
+		}
+		s.key, s.alg = k, RS256
+	case ed25519.PrivateKey:
+		s.key, s.alg = k, EdDSA
+	default:
+		return nil, fmt.Errorf("signing key must be RSA or Ed25519, got %T", key)
+	}
+	s.kid = thumbprint(publicJWK(s.key.Public()))
+	return s, nil
+}
+
+// Algorithm returns the "alg" the signer signs with.
+func (s *Signer) Algorithm() string {
+	return s.alg
+}
+
+// KeyID returns the "kid" put in the header of the JWTs it signs.
+func (s *Signer) KeyID() string {
+	return s.kid
+}
+
+// PublicJWK returns the signer's public key, for verifiers.
+func (s *Signer) PublicJWK() JWK {
+	jwk := publicJWK(s.key.Public())
+	jwk.Use, jwk.Alg, jwk.Kid = "sig", s.alg, s.kid
+	return jwk
+}
+
+// Sign returns claims as a compact JWT.
+func (s *Signer) Sign(claims any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": s.alg, "kid": s.kid, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("encoding claims: %w", err)
+	}
+	signingInput := b64(header) + "." + b64(payload)
+
+	var sig []byte
+	switch s.alg {
+	case RS256:
+		sum := sha256.Sum256([]byte(signingInput))
+		sig, err = s.key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	case EdDSA:
+		sig, err = s.key.Sign(rand.Reader, []byte(signingInput), crypto.Hash(0))
+	}
+	if err != nil {
+		return "", fmt.Errorf("signing: %w", err)
+	}
+	return signingInput + "." + b64(sig), nil
+}
+
+// Verify checks token's signature against the signer's public key and
+// decodes its claims into v. It doesn't check the claims themselves.
+func (s *Signer) Verify(token string, v any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed token")
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil || h.Alg != s.alg {
+		return errors.New("unexpected token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+	signingInput := parts[0] + "." + parts[1]
+	switch pub := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		sum := sha256.Sum256([]byte(signingInput))
+		err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, []byte(signingInput), sig) {
+			err = errors.New("ed25519: verification error")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	return decodeSegment(parts[1], v)
+}
+
+// header is the JOSE header of a token.
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// keyID returns the kid in token's header, or "" if it has none.
+func keyID(token string) string {
+	seg, _, _ := strings.Cut(token, ".")
+	var h header
+	decodeSegment(seg, &h)
+	return h.Kid
+}
+
+// publicJWK returns the members of pub's JWK that identify the key.
+func publicJWK(pub crypto.PublicKey) JWK {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", N: b64(k.N.Bytes()), E: b64(big.NewInt(int64(k.E)).Bytes())}
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: b64(k)}
+	}
+	return JWK{}
+}
+
+// thumbprint is the RFC 7638 thumbprint of jwk: the SHA-256 of its required
+// members, in lexical order.
+func thumbprint(jwk JWK) string {
+	var canonical string
+	switch jwk.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, jwk.Crv, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return b64(sum[:])
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,101 @@
+package idtoken
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func rsaPEM(t *testing.T, bits int) []byte {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func TestSigner_SignVerify(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		key  []byte
+		alg  string
+	}{
+		{"ed25519", testutil.SigningKeyPEM(t), EdDSA},
+		{"rsa", rsaPEM(t, 2048), RS256},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSigner(tc.key)
+			if err != nil {
+				t.Fatalf("NewSigner error: %v", err)
+			}
+			if s.Algorithm() != tc.alg {
+				t.Errorf("Algorithm = %q, want %q", s.Algorithm(), tc.alg)
+			}
+			if len(s.KeyID()) != 43 {
+				t.Errorf("KeyID = %q, want a base64url SHA-256", s.KeyID())
+			}
+
+			token, err := s.Sign(map[string]string{"sub": "discord:1"})
+			if err != nil {
+				t.Fatalf("Sign error: %v", err)
+			}
+			var claims map[string]string
+			if err := s.Verify(token, &claims); err != nil {
+				t.Fatalf("Verify error: %v", err)
+			}
+			if claims["sub"] != "discord:1" {
+				t.Errorf("claims = %v", claims)
+			}
+
+			parts := strings.Split(token, ".")
+			tampered := parts[0] + "." + b64([]byte(`{"sub":"discord:2"}`)) + "." + parts[2]
+			if err := s.Verify(tampered, &claims); err == nil {
+				t.Error("expected a tampered token to fail verification")
+			}
+		})
+	}
+}
+
+func TestSigner_KeyIDStable(t *testing.T) {
+	key := testutil.SigningKeyPEM(t)
+	a, _ := NewSigner(key)
+	b, _ := NewSigner(key)
+	if a.KeyID() != b.KeyID() {
+		t.Errorf("KeyID differs for the same key: %q, %q", a.KeyID(), b.KeyID())
+	}
+	c, _ := NewSigner(testutil.SigningKeyPEM(t))
+	if a.KeyID() == c.KeyID() {
+		t.Error("KeyID is the same for different keys")
+	}
+}
+
+func TestNewSigner_Invalid(t *testing.T) {
+	for name, key := range map[string][]byte{
+		"not PEM":   []byte("secret"),
+		"small RSA": rsaPEM(t, 1024),
+		"garbage":   pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("nope")}),
+	} {
+		if _, err := NewSigner(key); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSigner_PublicJWK(t *testing.T) {
+	for _, key := range [][]byte{testutil.SigningKeyPEM(t), rsaPEM(t, 2048)} {
+		s, _ := NewSigner(key)
+		jwk := s.PublicJWK()
+		if jwk.Kid != s.KeyID() || jwk.Alg != s.Algorithm() || jwk.Use != "sig" {
+			t.Errorf("JWK = %+v", jwk)
+		}
+		if thumbprint(jwk) != s.KeyID() {
+			t.Errorf("%s: thumbprint of the JWK doesn't match the key ID", jwk.Kty)
+		}
+	}
+}
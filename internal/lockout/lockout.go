@@ -0,0 +1,134 @@
+// Package lockout locks out callers that keep failing to authenticate, such
+// as ones grinding through exchange codes or API keys. Failures are counted
+// with a token bucket and lockouts kept in a store, so with shared ones a
+// caller is locked out of every replica.
+package lockout
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/ratelimit"
+	"github.com/BlackMission/centralauth/internal/store"
+)
+
+// Defaults for a zero Policy.
+var (
+	defaultFailures   = domain.RateLimit{Requests: 10, Per: 5 * time.Minute}
+	defaultLockout    = time.Minute
+	defaultMaxLockout = time.Hour
+)
+
+// keyPrefix namespaces the failure buckets and lockouts in their stores.
+const keyPrefix = "lockout/"
+
+// Policy says when callers are locked out and for how long.
+type Policy struct {
+	// Failures is how many failures a caller may have, refilling over the
+	// period, before it is locked out: 10/5m allows a burst of ten, then
+	// one every thirty seconds. Defaults to 10/5m.
+	Failures domain.RateLimit
+
+	// Lockout is how long the first lockout lasts; each one that follows
+	// before the last has been forgotten lasts twice as long, up to
+	// MaxLockout. A caller's lockouts are forgotten MaxLockout after the
+	// last ends. They default to a minute and an hour.
+	Lockout    time.Duration
+	MaxLockout time.Duration
+}
+
+// record is a caller's lockout, as kept in the store.
+type record struct {
+	Until   time.Time `json:"until"`
+	Strikes int       `json:"strikes"` // lockouts in a row
+}
+
+// Tracker counts each caller's failures and locks out the ones that fail too
+// often. Callers are identified by keys such as "ip/203.0.113.9".
+type Tracker struct {
+	failures *ratelimit.Limiter
+	store    store.Store
+	policy   Policy
+	now      func() time.Time
+}
+
+// New creates a tracker counting failures in failures and keeping lockouts
+// in s. Zero fields of policy take their defaults.
+func New(failures *ratelimit.Limiter, s store.Store, policy Policy) *Tracker {
+	if policy.Failures.IsZero() {
+		policy.Failures = defaultFailures
+	}
+	if policy.Lockout <= 0 {
+		policy.Lockout = defaultLockout
+	}
+	if policy.MaxLockout <= 0 {
+		policy.MaxLockout = max(defaultMaxLockout, policy.Lockout)
+	}
+	return &Tracker{failures: failures, store: s, policy: policy, now: time.Now}
+}
+
+// SetNow overrides the time function (for testing).
+func (t *Tracker) SetNow(fn func() time.Time) {
+	t.now = fn
+}
+
+// Locked returns how long key is still locked out for, or zero. A store that
+// fails locks no one out.
+func (t *Tracker) Locked(ctx context.Context, key string) time.Duration {
+	rec, ok := t.get(ctx, key)
+	if !ok {
+		return 0
+	}
+	return max(0, rec.Until.Sub(t.now()))
+}
+
+// Fail records a failure by key. When it is one too many, key is locked out
+// and Fail returns for how long; otherwise it returns zero.
+func (t *Tracker) Fail(ctx context.Context, key string) time.Duration {
+	if ok, _ := t.failures.Allow(ctx, keyPrefix+key, t.policy.Failures); ok {
+		return 0
+	}
+	rec, _ := t.get(ctx, key)
+	now := t.now()
+	if rec.Until.After(now) {
+		// Already locked out by another replica's request
+		return 0
+	}
+	rec.Strikes++
+	d := t.policy.Lockout
+	for i := 1; i < rec.Strikes && d < t.policy.MaxLockout; i++ {
+		d *= 2
+	}
+	d = min(d, t.policy.MaxLockout)
+	rec.Until = now.Add(d)
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return 0
+	}
+	if err := t.store.Set(ctx, keyPrefix+key, data, d+t.policy.MaxLockout); err != nil {
+		slog.WarnContext(ctx, "lockout: store failed; not locking out", "store", t.store.String(), "key", key, "error", err)
+		return 0
+	}
+	return d
+}
+
+func (t *Tracker) get(ctx context.Context, key string) (record, bool) {
+	data, ok, err := t.store.Get(ctx, keyPrefix+key)
+	if err != nil {
+		slog.WarnContext(ctx, "lockout: store failed", "store", t.store.String(), "error", err)
+		return record{}, false
+	}
+	if !ok {
+		return record{}, false
+	}
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		slog.WarnContext(ctx, "lockout: unreadable entry", "key", key, "error", err)
+		return record{}, false
+	}
+	return rec, true
+}
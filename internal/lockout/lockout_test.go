@@ -0,0 +1,100 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/ratelimit"
+	"github.com/BlackMission/centralauth/internal/store"
+)
+
+func newTracker(t *testing.T, policy Policy) (*Tracker, func(time.Duration)) {
+	t.Helper()
+	now := time.Now()
+	clock := func() time.Time { return now }
+	buckets, s := ratelimit.NewMemory(), store.NewMemory()
+	buckets.SetNow(clock)
+	s.SetNow(clock)
+	tr := New(ratelimit.New(buckets), s, policy)
+	tr.SetNow(clock)
+	return tr, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestTracker(t *testing.T) {
+	ctx := context.Background()
+	tr, advance := newTracker(t, Policy{
+		Failures:   domain.RateLimit{Requests: 3, Per: time.Hour},
+		Lockout:    time.Minute,
+		MaxLockout: 3 * time.Minute,
+	})
+
+	for i := range 3 {
+		if d := tr.Fail(ctx, "ip/203.0.113.9"); d != 0 {
+			t.Fatalf("failure %d locked out for %s", i+1, d)
+		}
+	}
+	if d := tr.Locked(ctx, "ip/203.0.113.9"); d != 0 {
+		t.Fatalf("locked out for %s before the limit was passed", d)
+	}
+	if d := tr.Fail(ctx, "ip/203.0.113.9"); d != time.Minute {
+		t.Fatalf("fourth failure: lockout = %s, want 1m", d)
+	}
+	if d := tr.Locked(ctx, "ip/203.0.113.9"); d != time.Minute {
+		t.Errorf("Locked = %s, want 1m", d)
+	}
+	if d := tr.Locked(ctx, "ip/198.51.100.1"); d != 0 {
+		t.Errorf("another key is locked out for %s", d)
+	}
+
+	// Each lockout in a row lasts twice as long as the last, up to the max
+	advance(time.Minute)
+	if d := tr.Locked(ctx, "ip/203.0.113.9"); d != 0 {
+		t.Fatalf("still locked out for %s after the lockout", d)
+	}
+	for _, want := range []time.Duration{2 * time.Minute, 3 * time.Minute} {
+		if d := tr.Fail(ctx, "ip/203.0.113.9"); d != want {
+			t.Fatalf("lockout = %s, want %s", d, want)
+		}
+		advance(want)
+	}
+
+	// and they are forgotten once the caller behaves for MaxLockout
+	advance(3*time.Minute + time.Second)
+	if d := tr.Fail(ctx, "ip/203.0.113.9"); d != time.Minute {
+		t.Errorf("lockout after a quiet spell = %s, want 1m", d)
+	}
+}
+
+func TestNew_Defaults(t *testing.T) {
+	tr := New(nil, nil, Policy{Lockout: 2 * time.Hour})
+	if tr.policy.Failures != defaultFailures || tr.policy.MaxLockout != 2*time.Hour {
+		t.Errorf("policy = %+v", tr.policy)
+	}
+}
+
+type failingStore struct{ store.Store }
+
+func (failingStore) Get(context.Context, string) ([]byte, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+
+func (failingStore) Set(context.Context, string, []byte, time.Duration) error {
+	return errors.New("connection refused")
+}
+
+func (failingStore) String() string { return "failing" }
+
+func TestTracker_StoreErrorLocksNoOneOut(t *testing.T) {
+	ctx := context.Background()
+	tr := New(ratelimit.New(ratelimit.NewMemory()), failingStore{}, Policy{Failures: domain.RateLimit{Requests: 1, Per: time.Hour}})
+	tr.Fail(ctx, "ip/203.0.113.9")
+	if d := tr.Fail(ctx, "ip/203.0.113.9"); d != 0 {
+		t.Errorf("Fail = %s with a failing store", d)
+	}
+	if d := tr.Locked(ctx, "ip/203.0.113.9"); d != 0 {
+		t.Errorf("Locked = %s with a failing store", d)
+	}
+}
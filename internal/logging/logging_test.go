@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNew_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", "warn")
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+
+	ctx := With(context.Background(), "request_id", "r1")
+	ctx = With(ctx, "client_id", "website")
+	logger.InfoContext(ctx, "below the level")
+	logger.WarnContext(ctx, "provider exchange failed", "provider", "discord")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d records, want 1: %s", len(lines), buf.String())
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("record is not JSON: %v", err)
+	}
+	if rec["msg"] != "provider exchange failed" || rec["request_id"] != "r1" || rec["client_id"] != "website" || rec["provider"] != "discord" {
+		t.Errorf("record = %v", rec)
+	}
+}
+
+func TestNew_Text(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "", "")
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	logger.With("component", "server").DebugContext(context.Background(), "hidden")
+	logger.With("component", "server").InfoContext(With(context.Background(), "request_id", "r1"), "listening")
+	if got := buf.String(); !strings.Contains(got, "msg=listening") || !strings.Contains(got, "component=server") ||
+		!strings.Contains(got, "request_id=r1") || strings.Contains(got, "hidden") {
+		t.Errorf("output = %q", got)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "xml", "info"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if _, err := New(&bytes.Buffer{}, "json", "loud"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+	if lvl, err := ParseLevel("DEBUG"); err != nil || lvl != slog.LevelDebug {
+		t.Errorf("ParseLevel(DEBUG) = %v, %v", lvl, err)
+	}
+}
+
+func TestWith_DoesNotShareFields(t *testing.T) {
+	base := With(context.Background(), "a", 1)
+	one := With(base, "b", 2)
+	two := With(base, "c", 3)
+	if got := one.Value(fieldsKey{}).([]any); len(got) != 4 || got[2] != "b" {
+		t.Errorf("one = %v", got)
+	}
+	if got := two.Value(fieldsKey{}).([]any); len(got) != 4 || got[2] != "c" {
+		t.Errorf("two = %v", got)
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	if got := RequestID(context.Background()); got != "" {
+		t.Errorf("RequestID outside a request = %q", got)
+	}
+	ctx := WithRequestID(context.Background(), "r1")
+	if got := RequestID(ctx); got != "r1" {
+		t.Errorf("RequestID = %q, want r1", got)
+	}
+	if got := ctx.Value(fieldsKey{}).([]any); len(got) != 2 || got[0] != "request_id" || got[1] != "r1" {
+		t.Errorf("fields = %v", got)
+	}
+}
@@ -0,0 +1,83 @@
+package logout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+)
+
+// timeout bounds each notification, so a client that doesn't answer can't
+// hold up the user's sign-out for long.
+const timeout = 5 * time.Second
+
+// Notifier tells clients that a user has signed out, by posting a logout
+// token to each client's back-channel logout URI (OpenID Connect
+// Back-Channel Logout 1.0).
+type Notifier struct {
+	ids        *idtoken.Issuer
+	httpClient *http.Client
+}
+
+// New creates a notifier that signs its logout tokens with ids.
+func New(ids *idtoken.Issuer) *Notifier {
+	return &Notifier{ids: ids, httpClient: &http.Client{Timeout: timeout}}
+}
+
+// Notify tells each of clients with a back-channel logout URI that user has
+// signed out, all at once, and returns when every one has answered or timed
+// out. A client answering with anything but a 2xx is an error.
+func (n *Notifier) Notify(ctx context.Context, user domain.UserInfo, clients []*domain.ClientApp) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, c := range clients {
+		if c.BackchannelLogoutURI == "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := n.notify(ctx, user, c); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("client %s: %w", c.ID, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (n *Notifier) notify(ctx context.Context, user domain.UserInfo, c *domain.ClientApp) error {
+	token, err := n.ids.IssueLogout(c.ID, user)
+	if err != nil {
+		return err
+	}
+	body := url.Values{"logout_token": {token}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BackchannelLogoutURI, strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package logout
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestNotifier_Notify(t *testing.T) {
+	signer, _ := idtoken.NewSigner(testutil.SigningKeyPEM(t))
+	ids := idtoken.NewIssuer(signer, "https://auth.example.com", 0)
+
+	var (
+		mu       sync.Mutex
+		audience []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var claims idtoken.LogoutClaims
+		if err := ids.Verify(r.PostFormValue("logout_token"), &claims); err != nil {
+			t.Errorf("Verify error: %v", err)
+		}
+		mu.Lock()
+		audience = append(audience, claims.Audience)
+		mu.Unlock()
+		if r.URL.Path == "/broken" {
+			http.Error(w, "no session here", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	err := New(ids).Notify(context.Background(), domain.UserInfo{ProviderName: "discord", ProviderID: "123"}, []*domain.ClientApp{
+		{ID: "forum", BackchannelLogoutURI: srv.URL + "/logout"},
+		{ID: "wiki"},
+		{ID: "shop", BackchannelLogoutURI: srv.URL + "/broken"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "client shop: status 400") || strings.Contains(err.Error(), "forum") {
+		t.Errorf("Notify error = %v", err)
+	}
+	if len(audience) != 2 {
+		t.Errorf("expected forum and shop to be told, got %v", audience)
+	}
+}
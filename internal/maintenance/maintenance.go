@@ -0,0 +1,82 @@
+package maintenance
+
+import (
+	"sync"
+	"time"
+)
+
+// Mode toggles maintenance mode, in which no one can sign in, for pausing
+// logins during an incident. Unlike drain mode, which turns away new flows
+// for the minute a deploy takes, it stays on until an operator turns it off.
+type Mode struct {
+	mu      sync.RWMutex
+	on      bool
+	since   time.Time
+	message string
+	now     func() time.Time
+}
+
+// Status describes the current maintenance state.
+type Status struct {
+	Enabled bool       `json:"enabled"`
+	Since   *time.Time `json:"since,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+// NewMode creates a mode that is off.
+func NewMode() *Mode {
+	return &Mode{now: time.Now}
+}
+
+// Enable turns maintenance mode on, with message shown to users trying to
+// sign in (the default message if empty). Enabling it again replaces the
+// message but keeps the original start time.
+func (m *Mode) Enable(message string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.on {
+		m.on = true
+		m.since = m.now()
+	}
+	m.message = message
+}
+
+// Disable turns maintenance mode off.
+func (m *Mode) Disable() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.on = false
+	m.since = time.Time{}
+	m.message = ""
+}
+
+// Toggle turns maintenance mode on with the default message if it is off,
+// and off if it is on, and reports whether it is now on.
+func (m *Mode) Toggle() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.on {
+		m.on, m.since, m.message = false, time.Time{}, ""
+	} else {
+		m.on, m.since = true, m.now()
+	}
+	return m.on
+}
+
+// Enabled reports whether maintenance mode is on, and the message to show.
+func (m *Mode) Enabled() (bool, string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.on, m.message
+}
+
+// Status returns a snapshot of the mode.
+func (m *Mode) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.on {
+		return Status{}
+	}
+	since := m.since
+	return Status{Enabled: true, Since: &since, Message: m.message}
+}
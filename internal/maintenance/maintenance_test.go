@@ -0,0 +1,53 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMode(t *testing.T) {
+	m := NewMode()
+	if on, _ := m.Enabled(); on {
+		t.Fatal("expected new mode to be off")
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return start }
+	m.Enable("Investigating an outage")
+	if on, msg := m.Enabled(); !on || msg != "Investigating an outage" {
+		t.Fatalf("expected mode on with message, got %v %q", on, msg)
+	}
+
+	// Enabling again replaces the message and keeps the original start time
+	m.now = func() time.Time { return start.Add(time.Minute) }
+	m.Enable("")
+	st := m.Status()
+	if st.Since == nil || !st.Since.Equal(start) || st.Message != "" {
+		t.Errorf("expected since %v without a message, got %+v", start, st)
+	}
+
+	m.Disable()
+	if on, _ := m.Enabled(); on {
+		t.Error("expected mode off")
+	}
+	if st := m.Status(); st.Since != nil {
+		t.Errorf("expected no since when off, got %v", st.Since)
+	}
+}
+
+func TestMode_Toggle(t *testing.T) {
+	m := NewMode()
+	if !m.Toggle() {
+		t.Fatal("expected toggle to turn the mode on")
+	}
+	if st := m.Status(); !st.Enabled || st.Since == nil {
+		t.Errorf("status = %+v", st)
+	}
+	m.Enable("Investigating an outage")
+	if m.Toggle() {
+		t.Fatal("expected toggle to turn the mode off")
+	}
+	if st := m.Status(); st.Enabled || st.Message != "" {
+		t.Errorf("status = %+v", st)
+	}
+}
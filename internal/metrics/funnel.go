@@ -0,0 +1,52 @@
+package metrics
+
+// Funnel stages, in the order a successful login passes through them.
+const (
+	StageAuthorizeIssued  = "authorize_issued"
+	StageCallbackReceived = "callback_received"
+	StageCodeIssued       = "code_issued"
+	StageCodeRedeemed     = "code_redeemed"
+)
+
+// unknownLabel is used when a drop happens before the client or provider is known.
+const unknownLabel = "unknown"
+
+// Funnel counts logins as they move through the auth flow, and the reasons
+// they drop out, per client and provider. A nil *Funnel records nothing.
+type Funnel struct {
+	stages Counter
+	drops  Counter
+}
+
+// NewFunnel registers the funnel counters on b.
+func NewFunnel(b Backend) *Funnel {
+	return &Funnel{
+		stages: b.NewCounter("centralauth_funnel_total",
+			"Auth flows reaching each funnel stage.", "stage", "client", "provider"),
+		drops: b.NewCounter("centralauth_funnel_dropped_total",
+			"Auth flows that failed at a funnel stage, by reason.", "stage", "reason", "client", "provider"),
+	}
+}
+
+// Reached records a flow reaching stage.
+func (f *Funnel) Reached(stage, clientID, provider string) {
+	if f == nil {
+		return
+	}
+	f.stages.Inc(stage, orUnknown(clientID), orUnknown(provider))
+}
+
+// Dropped records a flow failing at stage for reason.
+func (f *Funnel) Dropped(stage, reason, clientID, provider string) {
+	if f == nil {
+		return
+	}
+	f.drops.Inc(stage, reason, orUnknown(clientID), orUnknown(provider))
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return unknownLabel
+	}
+	return s
+}
@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFunnel(t *testing.T) {
+	reg := NewRegistry()
+	f := NewFunnel(reg)
+
+	f.Reached(StageAuthorizeIssued, "website", "discord")
+	f.Reached(StageAuthorizeIssued, "website", "discord")
+	f.Reached(StageCodeRedeemed, "website", "discord")
+	f.Dropped(StageCodeRedeemed, "code_expired", "website", "")
+
+	out := string(reg.render())
+	for _, want := range []string{
+		`centralauth_funnel_total{stage="authorize_issued",client="website",provider="discord"} 2`,
+		`centralauth_funnel_total{stage="code_redeemed",client="website",provider="discord"} 1`,
+		`centralauth_funnel_dropped_total{stage="code_redeemed",reason="code_expired",client="website",provider="unknown"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestFunnel_NilIsNoop(t *testing.T) {
+	var f *Funnel
+	f.Reached(StageAuthorizeIssued, "website", "discord")
+	f.Dropped(StageCallbackReceived, "state_expired", "", "discord")
+}
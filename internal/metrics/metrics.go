@@ -0,0 +1,175 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Backend creates metrics. Implementations export them to a monitoring
+// system: Registry is scraped by Prometheus, StatsD pushes to a DogStatsD agent.
+type Backend interface {
+	NewCounter(name, help string, labels ...string) Counter
+	NewGauge(name, help string, labels ...string) Gauge
+}
+
+// Counter is a monotonically increasing metric partitioned by labels.
+type Counter interface {
+	Inc(labelValues ...string)
+}
+
+// Gauge is a metric that can go up and down, partitioned by labels.
+type Gauge interface {
+	Set(v float64, labelValues ...string)
+	Inc(labelValues ...string)
+	Dec(labelValues ...string)
+}
+
+// Registry holds metric families and renders them in the Prometheus text format.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// NewRegistry creates an empty metrics registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+func (r *Registry) register(name, help, kind string, labels []string) *family {
+	f := &family{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*series),
+	}
+	r.mu.Lock()
+	r.families = append(r.families, f)
+	r.mu.Unlock()
+	return f
+}
+
+func (f *family) add(delta float64, labelValues []string) {
+	f.update(labelValues, func(v float64) float64 { return v + delta })
+}
+
+func (f *family) update(labelValues []string, fn func(float64) float64) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		f.series[key] = s
+	}
+	s.value = fn(s.value)
+}
+
+type promCounter struct{ f *family }
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) Counter {
+	return &promCounter{f: r.register(name, help, "counter", labels)}
+}
+
+func (c *promCounter) Inc(labelValues ...string) {
+	c.f.add(1, labelValues)
+}
+
+type promGauge struct{ f *family }
+
+// NewGauge registers a gauge with the given label names.
+func (r *Registry) NewGauge(name, help string, labels ...string) Gauge {
+	return &promGauge{f: r.register(name, help, "gauge", labels)}
+}
+
+func (g *promGauge) Set(v float64, labelValues ...string) {
+	g.f.update(labelValues, func(float64) float64 { return v })
+}
+
+func (g *promGauge) Inc(labelValues ...string) {
+	g.f.add(1, labelValues)
+}
+
+func (g *promGauge) Dec(labelValues ...string) {
+	g.f.add(-1, labelValues)
+}
+
+// Handler serves the registry in the Prometheus text exposition format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(r.render())
+	})
+}
+
+func (r *Registry) render() []byte {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var buf bytes.Buffer
+	for _, f := range families {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", f.name, f.kind)
+
+		f.mu.Lock()
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := f.series[k]
+			buf.WriteString(f.name)
+			writeLabels(&buf, f.labels, s.labelValues)
+			buf.WriteByte(' ')
+			buf.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+			buf.WriteByte('\n')
+		}
+		f.mu.Unlock()
+	}
+	return buf.Bytes()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeLabels(buf *bytes.Buffer, names, values []string) {
+	if len(names) == 0 {
+		return
+	}
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(buf, `%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	buf.WriteByte('}')
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestCounterAndGauge(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_requests_total", "Requests.", "provider")
+	g := r.NewGauge("test_in_flight", "In flight.", "provider")
+
+	c.Inc("discord")
+	c.Inc("discord")
+	c.Inc("steam")
+	g.Inc("discord")
+	g.Inc("discord")
+	g.Dec("discord")
+	g.Set(7, "steam")
+
+	out := string(r.render())
+	for _, want := range []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{provider="discord"} 2`,
+		`test_requests_total{provider="steam"} 1`,
+		"# TYPE test_in_flight gauge",
+		`test_in_flight{provider="discord"} 1`,
+		`test_in_flight{provider="steam"} 7`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test.", "client").Inc(`we"ird\`)
+
+	out := string(r.render())
+	if !strings.Contains(out, `test_total{client="we\"ird\\"} 1`) {
+		t.Errorf("expected escaped label, got:\n%s", out)
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test.", "a", "b")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for wrong label count")
+		}
+	}()
+	c.Inc("only-one")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test.").Inc()
+
+	rr := testutil.DoRequest(t, r.Handler(), http.MethodGet, "/metrics", nil)
+	testutil.AssertStatus(t, rr, http.StatusOK)
+	if !strings.Contains(rr.Body.String(), "test_total 1") {
+		t.Errorf("unexpected body: %s", rr.Body.String())
+	}
+}
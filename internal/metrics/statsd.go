@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// StatsD pushes metrics to a StatsD agent over UDP, encoding labels as
+// DogStatsD tags (label:value).
+type StatsD struct {
+	w      io.Writer
+	prefix string
+	tags   []string
+}
+
+// NewStatsD connects to the StatsD agent at addr. Every metric name gets the
+// given prefix, and every sample carries the constant tags (e.g. "env:prod").
+func NewStatsD(addr, prefix string, tags []string) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to statsd at %s: %w", addr, err)
+	}
+	return newStatsD(conn, prefix, tags), nil
+}
+
+func newStatsD(w io.Writer, prefix string, tags []string) *StatsD {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &StatsD{w: w, prefix: prefix, tags: tags}
+}
+
+// send writes one sample. StatsD is fire-and-forget, so write errors are dropped.
+func (s *StatsD) send(name, value, kind string, labels, labelValues []string) {
+	if len(labelValues) != len(labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", name, len(labels), len(labelValues)))
+	}
+
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(kind)
+
+	if len(s.tags)+len(labels) > 0 {
+		b.WriteString("|#")
+		first := true
+		for _, t := range s.tags {
+			if !first {
+				b.WriteByte(',')
+			}
+			b.WriteString(t)
+			first = false
+		}
+		for i, l := range labels {
+			if !first {
+				b.WriteByte(',')
+			}
+			b.WriteString(l)
+			b.WriteByte(':')
+			b.WriteString(tagEscaper.Replace(labelValues[i]))
+			first = false
+		}
+	}
+	s.w.Write([]byte(b.String()))
+}
+
+// tagEscaper strips characters that would break the DogStatsD line format.
+var tagEscaper = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+type statsdCounter struct {
+	s      *StatsD
+	name   string
+	labels []string
+}
+
+// NewCounter creates a counter reported as a StatsD count.
+func (s *StatsD) NewCounter(name, help string, labels ...string) Counter {
+	return &statsdCounter{s: s, name: name, labels: labels}
+}
+
+func (c *statsdCounter) Inc(labelValues ...string) {
+	c.s.send(c.name, "1", "c", c.labels, labelValues)
+}
+
+// statsdGauge tracks values locally and always sends absolute readings, since
+// relative gauge updates aren't supported by every agent.
+type statsdGauge struct {
+	s      *StatsD
+	name   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewGauge creates a gauge reported as a StatsD gauge.
+func (s *StatsD) NewGauge(name, help string, labels ...string) Gauge {
+	return &statsdGauge{s: s, name: name, labels: labels, values: make(map[string]float64)}
+}
+
+func (g *statsdGauge) Set(v float64, labelValues ...string) {
+	g.update(labelValues, func(float64) float64 { return v })
+}
+
+func (g *statsdGauge) Inc(labelValues ...string) {
+	g.update(labelValues, func(v float64) float64 { return v + 1 })
+}
+
+func (g *statsdGauge) Dec(labelValues ...string) {
+	g.update(labelValues, func(v float64) float64 { return v - 1 })
+}
+
+func (g *statsdGauge) update(labelValues []string, fn func(float64) float64) {
+	key := strings.Join(labelValues, "\xff")
+
+	g.mu.Lock()
+	v := fn(g.values[key])
+	g.values[key] = v
+	g.mu.Unlock()
+
+	g.s.send(g.name, strconv.FormatFloat(v, 'g', -1, 64), "g", g.labels, labelValues)
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// packetRecorder captures each Write as one StatsD packet.
+type packetRecorder struct {
+	packets []string
+}
+
+func (p *packetRecorder) Write(b []byte) (int, error) {
+	p.packets = append(p.packets, string(b))
+	return len(b), nil
+}
+
+func TestStatsD_Counter(t *testing.T) {
+	rec := &packetRecorder{}
+	s := newStatsD(rec, "centralauth", []string{"env:prod"})
+
+	s.NewCounter("funnel_total", "help", "stage", "client").Inc("code_issued", "website")
+
+	want := "centralauth.funnel_total:1|c|#env:prod,stage:code_issued,client:website"
+	if len(rec.packets) != 1 || rec.packets[0] != want {
+		t.Errorf("expected %q, got %v", want, rec.packets)
+	}
+}
+
+func TestStatsD_GaugeSendsAbsoluteValues(t *testing.T) {
+	rec := &packetRecorder{}
+	s := newStatsD(rec, "", nil)
+	g := s.NewGauge("in_flight", "help", "provider")
+
+	g.Inc("steam")
+	g.Inc("steam")
+	g.Dec("steam")
+	g.Set(10, "discord")
+
+	want := []string{
+		"in_flight:1|g|#provider:steam",
+		"in_flight:2|g|#provider:steam",
+		"in_flight:1|g|#provider:steam",
+		"in_flight:10|g|#provider:discord",
+	}
+	if strings.Join(rec.packets, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected %v, got %v", want, rec.packets)
+	}
+}
+
+func TestStatsD_NoTags(t *testing.T) {
+	rec := &packetRecorder{}
+	s := newStatsD(rec, "", nil)
+	s.NewCounter("up_total", "help").Inc()
+
+	if rec.packets[0] != "up_total:1|c" {
+		t.Errorf("unexpected packet %q", rec.packets[0])
+	}
+}
+
+func TestStatsD_TagValueSanitized(t *testing.T) {
+	rec := &packetRecorder{}
+	s := newStatsD(rec, "", nil)
+	s.NewCounter("x_total", "help", "client").Inc("a,b|c")
+
+	if rec.packets[0] != "x_total:1|c|#client:a_b_c" {
+		t.Errorf("unexpected packet %q", rec.packets[0])
+	}
+}
+
+func TestStatsD_UDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer pc.Close()
+
+	s, err := NewStatsD(pc.LocalAddr().String(), "ca", nil)
+	if err != nil {
+		t.Fatalf("NewStatsD error: %v", err)
+	}
+	s.NewCounter("hits_total", "help").Inc()
+
+	buf := make([]byte, 512)
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got := string(buf[:n]); got != "ca.hits_total:1|c" {
+		t.Errorf("unexpected packet %q", got)
+	}
+}
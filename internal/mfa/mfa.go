@@ -0,0 +1,269 @@
+package mfa
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/totp"
+)
+
+// ACR2FA is the /auth acr value that requires a TOTP second factor.
+const ACR2FA = "2fa"
+
+// FactorTOTP is reported in the exchange result once a TOTP code was checked.
+const FactorTOTP = "totp"
+
+const (
+	defaultPendingTTL = 5 * time.Minute
+	maxFailures       = 5
+	lockoutWindow     = 5 * time.Minute
+)
+
+var (
+	aadPending = []byte("pending")
+	aadSecret  = []byte("secret")
+)
+
+// Pending is an auth flow paused between the provider step and the exchange
+// code, waiting for a second factor.
+type Pending struct {
+	ClientID    string          `json:"cid"`
+	RedirectURI string          `json:"rdr"`
+	FlowID      string          `json:"fid,omitempty"`
+	User        domain.UserInfo `json:"user"`
+	Factors     []string        `json:"fct"`
+	Scope       string          `json:"scp,omitempty"`
+
+	CodeChallenge string `json:"cch,omitempty"`
+	AppState      string `json:"aps,omitempty"`
+
+	Tokens *domain.ProviderTokens `json:"tok,omitempty"`
+	OIDC   *domain.OIDCRequest    `json:"oidc,omitempty"`
+	Device string                 `json:"dev,omitempty"`
+
+	// SessionUser is User before the post-auth hooks, to start the SSO
+	// session with.
+	SessionUser *domain.UserInfo `json:"susr,omitempty"`
+
+	// EnrollSecret is set while the user is enrolling a new authenticator.
+	EnrollSecret string    `json:"ens,omitempty"`
+	ExpiresAt    time.Time `json:"exp"`
+}
+
+// Service enrolls and verifies TOTP second factors and seals paused flows
+// into opaque tokens that round-trip through the browser.
+type Service struct {
+	store  Store
+	aeads  []cipher.AEAD // the current key first, then previous ones
+	issuer string
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu       sync.Mutex
+	lastStep map[string]int64 // identity -> last accepted TOTP step, to stop replays
+	failures map[string]failures
+}
+
+type failures struct {
+	count int
+	first time.Time
+}
+
+// NewService creates a second-factor service. key (32 bytes) encrypts both
+// pending tokens and stored secrets; issuer labels entries in authenticator apps.
+func NewService(key []byte, store Store, issuer string) (*Service, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{
+		store:    store,
+		aeads:    []cipher.AEAD{aead},
+		issuer:   issuer,
+		ttl:      defaultPendingTTL,
+		now:      time.Now,
+		lastStep: make(map[string]int64),
+		failures: make(map[string]failures),
+	}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating AES cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating GCM: %w", err)
+	}
+	return aead, nil
+}
+
+// SetPreviousKeys makes the service open tokens and stored secrets sealed
+// with keys it has been rotated away from. Stored secrets are re-sealed with
+// the current key the next time they verify a code.
+func (s *Service) SetPreviousKeys(keys ...[]byte) error {
+	aeads := s.aeads[:1]
+	for _, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return err
+		}
+		aeads = append(aeads, aead)
+	}
+	s.aeads = aeads
+	return nil
+}
+
+// SetNow overrides the time function (for testing).
+func (s *Service) SetNow(fn func() time.Time) {
+	s.now = fn
+}
+
+// Identity is the key second factors are enrolled under.
+func Identity(u domain.UserInfo) string {
+	return u.ProviderName + ":" + u.ProviderID
+}
+
+// Seal encrypts a paused flow into a token valid for five minutes.
+func (s *Service) Seal(p Pending) (string, error) {
+	p.ExpiresAt = s.now().Add(s.ttl)
+	return s.seal(p, aadPending)
+}
+
+// Open decrypts a token produced by Seal.
+func (s *Service) Open(token string) (*Pending, error) {
+	var p Pending
+	if _, err := s.open(token, aadPending, &p); err != nil {
+		return nil, domain.ErrInvalidMFAToken
+	}
+	if s.now().After(p.ExpiresAt) {
+		return nil, domain.ErrExpiredMFAToken
+	}
+	return &p, nil
+}
+
+// Enrolled reports whether identity has a TOTP secret.
+func (s *Service) Enrolled(identity string) (bool, error) {
+	_, err := s.store.Get(identity)
+	if errors.Is(err, domain.ErrMFANotEnrolled) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// URI returns the otpauth:// URI for enrolling secret under identity.
+func (s *Service) URI(identity, secret string) string {
+	return totp.URI(s.issuer, identity, secret)
+}
+
+// Verify checks a TOTP code for an enrolled identity.
+func (s *Service) Verify(identity, code string) error {
+	sealed, err := s.store.Get(identity)
+	if err != nil {
+		return err
+	}
+	var secret string
+	current, err := s.open(sealed, aadSecret, &secret)
+	if err != nil {
+		return fmt.Errorf("decrypting TOTP secret: %w", err)
+	}
+	if err := s.check(identity, secret, code); err != nil {
+		return err
+	}
+	if !current {
+		// Move the secret off the previous key while we have it in hand
+		if resealed, err := s.seal(secret, aadSecret); err == nil {
+			s.store.Put(identity, resealed)
+		}
+	}
+	return nil
+}
+
+// Enroll confirms that the user's authenticator produces code for secret and
+// then stores the secret for identity.
+func (s *Service) Enroll(identity, secret, code string) error {
+	if err := s.check(identity, secret, code); err != nil {
+		return err
+	}
+	sealed, err := s.seal(secret, aadSecret)
+	if err != nil {
+		return err
+	}
+	return s.store.Put(identity, sealed)
+}
+
+// check validates code, rejecting replays of an already-used step and
+// locking identity out after repeated failures.
+func (s *Service) check(identity, secret, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	f := s.failures[identity]
+	if now.Sub(f.first) >= lockoutWindow {
+		f = failures{}
+	}
+	if f.count >= maxFailures {
+		return domain.ErrMFALocked
+	}
+
+	step, ok := totp.Validate(secret, code, now)
+	if last, seen := s.lastStep[identity]; ok && seen && step <= last {
+		ok = false
+	}
+	if !ok {
+		if f.count == 0 {
+			f.first = now
+		}
+		f.count++
+		s.failures[identity] = f
+		return domain.ErrInvalidTOTPCode
+	}
+
+	delete(s.failures, identity)
+	s.lastStep[identity] = step
+	return nil
+}
+
+// seal encrypts v; aad keeps pending tokens and stored secrets from being
+// swapped for one another.
+func (s *Service) seal(v any, aad []byte) (string, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	aead := s.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generating nonce: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, aad)), nil
+}
+
+// open decrypts token into v with the current key or, failing that, a
+// previous one, and reports whether the current key was used.
+func (s *Service) open(token string, aad []byte, v any) (current bool, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return false, domain.ErrInvalidMFAToken
+	}
+	for i, aead := range s.aeads {
+		if len(raw) < aead.NonceSize() {
+			return false, domain.ErrInvalidMFAToken
+		}
+		plaintext, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], aad)
+		if err == nil {
+			return i == 0, json.Unmarshal(plaintext, v)
+		}
+	}
+	return false, domain.ErrInvalidMFAToken
+}
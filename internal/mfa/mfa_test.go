@@ -0,0 +1,168 @@
+package mfa
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/totp"
+)
+
+var testKey = []byte("01234567890123456789012345678901")
+
+func newTestService(t *testing.T) (*Service, *time.Time) {
+	t.Helper()
+	store, err := OpenFileStore(filepath.Join(t.TempDir(), "mfa.json"))
+	if err != nil {
+		t.Fatalf("OpenFileStore error: %v", err)
+	}
+	svc, err := NewService(testKey, store, "CentralAuth")
+	if err != nil {
+		t.Fatalf("NewService error: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	svc.SetNow(func() time.Time { return now })
+	return svc, &now
+}
+
+func TestSealOpen(t *testing.T) {
+	svc, now := newTestService(t)
+
+	token, err := svc.Seal(Pending{ClientID: "website", User: domain.UserInfo{ProviderID: "1"}})
+	if err != nil {
+		t.Fatalf("Seal error: %v", err)
+	}
+	p, err := svc.Open(token)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	if p.ClientID != "website" {
+		t.Errorf("ClientID = %q", p.ClientID)
+	}
+
+	*now = now.Add(10 * time.Minute)
+	if _, err := svc.Open(token); !errors.Is(err, domain.ErrExpiredMFAToken) {
+		t.Errorf("expected ErrExpiredMFAToken, got %v", err)
+	}
+	if _, err := svc.Open("garbage"); !errors.Is(err, domain.ErrInvalidMFAToken) {
+		t.Errorf("expected ErrInvalidMFAToken, got %v", err)
+	}
+}
+
+func TestEnrollAndVerify(t *testing.T) {
+	svc, now := newTestService(t)
+	secret, _ := totp.GenerateSecret()
+
+	if enrolled, _ := svc.Enrolled("discord:1"); enrolled {
+		t.Fatal("expected no enrollment yet")
+	}
+	code, _ := totp.Code(secret, *now)
+	if err := svc.Enroll("discord:1", secret, code); err != nil {
+		t.Fatalf("Enroll error: %v", err)
+	}
+	if enrolled, _ := svc.Enrolled("discord:1"); !enrolled {
+		t.Fatal("expected enrollment")
+	}
+
+	// The enrollment code can't be replayed
+	if err := svc.Verify("discord:1", code); !errors.Is(err, domain.ErrInvalidTOTPCode) {
+		t.Errorf("expected replayed code to be rejected, got %v", err)
+	}
+
+	*now = now.Add(30 * time.Second)
+	next, _ := totp.Code(secret, *now)
+	if err := svc.Verify("discord:1", next); err != nil {
+		t.Errorf("Verify error: %v", err)
+	}
+}
+
+func TestVerify_NotEnrolled(t *testing.T) {
+	svc, _ := newTestService(t)
+	if err := svc.Verify("discord:1", "123456"); !errors.Is(err, domain.ErrMFANotEnrolled) {
+		t.Errorf("expected ErrMFANotEnrolled, got %v", err)
+	}
+}
+
+func TestVerify_LocksOutAfterFailures(t *testing.T) {
+	svc, now := newTestService(t)
+	secret, _ := totp.GenerateSecret()
+	code, _ := totp.Code(secret, *now)
+	svc.Enroll("discord:1", secret, code)
+
+	for i := 0; i < maxFailures; i++ {
+		svc.Verify("discord:1", "000000")
+	}
+	*now = now.Add(30 * time.Second)
+	good, _ := totp.Code(secret, *now)
+	if err := svc.Verify("discord:1", good); !errors.Is(err, domain.ErrMFALocked) {
+		t.Errorf("expected ErrMFALocked, got %v", err)
+	}
+
+	*now = now.Add(lockoutWindow)
+	good, _ = totp.Code(secret, *now)
+	if err := svc.Verify("discord:1", good); err != nil {
+		t.Errorf("expected lockout to expire, got %v", err)
+	}
+}
+
+func TestStoredSecretIsNotAPendingToken(t *testing.T) {
+	svc, now := newTestService(t)
+	secret, _ := totp.GenerateSecret()
+	code, _ := totp.Code(secret, *now)
+	svc.Enroll("discord:1", secret, code)
+
+	sealed, _ := svc.store.Get("discord:1")
+	if _, err := svc.Open(sealed); !errors.Is(err, domain.ErrInvalidMFAToken) {
+		t.Errorf("expected sealed secret to be rejected as a pending token, got %v", err)
+	}
+}
+
+func TestPreviousKeys(t *testing.T) {
+	store, err := OpenFileStore(filepath.Join(t.TempDir(), "mfa.json"))
+	if err != nil {
+		t.Fatalf("OpenFileStore error: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	oldKey := []byte("previous-key-5678901234567890123")
+	old, _ := NewService(oldKey, store, "CentralAuth")
+	old.SetNow(func() time.Time { return now })
+
+	secret, _ := totp.GenerateSecret()
+	code, _ := totp.Code(secret, now)
+	if err := old.Enroll("discord:1", secret, code); err != nil {
+		t.Fatalf("Enroll error: %v", err)
+	}
+	token, err := old.Seal(Pending{ClientID: "website"})
+	if err != nil {
+		t.Fatalf("Seal error: %v", err)
+	}
+
+	svc, _ := NewService(testKey, store, "CentralAuth")
+	svc.SetNow(func() time.Time { return now })
+	if _, err := svc.Open(token); !errors.Is(err, domain.ErrInvalidMFAToken) {
+		t.Fatalf("expected the previous key to be unknown, got %v", err)
+	}
+	if err := svc.SetPreviousKeys(oldKey); err != nil {
+		t.Fatalf("SetPreviousKeys error: %v", err)
+	}
+	if _, err := svc.Open(token); err != nil {
+		t.Errorf("expected a token sealed with the previous key to open, got %v", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	next, _ := totp.Code(secret, now)
+	if err := svc.Verify("discord:1", next); err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+
+	// The secret was re-sealed with the current key on verification
+	current, _ := NewService(testKey, store, "CentralAuth")
+	now = now.Add(30 * time.Second)
+	current.SetNow(func() time.Time { return now })
+	later, _ := totp.Code(secret, now)
+	if err := current.Verify("discord:1", later); err != nil {
+		t.Errorf("expected the secret to have moved to the current key, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package mfa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// Store persists enrolled TOTP secrets, keyed by identity. Secrets reach the
+// store already encrypted.
+type Store interface {
+	Get(identity string) (string, error)
+	Put(identity, sealedSecret string) error
+}
+
+// FileStore keeps enrolled secrets in a JSON file, rewriting it atomically on
+// every change.
+type FileStore struct {
+	mu      sync.RWMutex
+	path    string
+	secrets map[string]string
+}
+
+// OpenFileStore loads the secrets file at path, starting empty if it does not
+// exist yet.
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, secrets: make(map[string]string)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading MFA secrets file: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.secrets); err != nil {
+		return nil, fmt.Errorf("parsing MFA secrets file: %w", err)
+	}
+	return s, nil
+}
+
+// Get returns the sealed secret enrolled for identity.
+func (s *FileStore) Get(identity string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sealed, ok := s.secrets[identity]
+	if !ok {
+		return "", domain.ErrMFANotEnrolled
+	}
+	return sealed, nil
+}
+
+// Put stores the sealed secret for identity, replacing any previous one.
+func (s *FileStore) Put(identity, sealedSecret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := make(map[string]string, len(s.secrets)+1)
+	for k, v := range s.secrets {
+		next[k] = v
+	}
+	next[identity] = sealedSecret
+
+	data, err := json.MarshalIndent(next, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding MFA secrets: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".mfa-*.json")
+	if err != nil {
+		return fmt.Errorf("writing MFA secrets file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing MFA secrets file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing MFA secrets file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("writing MFA secrets file: %w", err)
+	}
+
+	s.secrets = next
+	return nil
+}
@@ -0,0 +1,28 @@
+package mfa
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func TestFileStore_Persists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mfa.json")
+	s, _ := OpenFileStore(path)
+	if err := s.Put("discord:1", "sealed"); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	if got, err := reopened.Get("discord:1"); err != nil || got != "sealed" {
+		t.Errorf("Get = %q, %v", got, err)
+	}
+	if _, err := reopened.Get("steam:2"); !errors.Is(err, domain.ErrMFANotEnrolled) {
+		t.Errorf("expected ErrMFANotEnrolled, got %v", err)
+	}
+}
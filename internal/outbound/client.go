@@ -0,0 +1,162 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/logging"
+	"github.com/BlackMission/centralauth/internal/tracing"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	defaultBackoff = 200 * time.Millisecond
+
+	// maxDrain is how much of a failed response is read so its connection
+	// can be reused.
+	maxDrain = 4 << 10
+)
+
+// Policy controls how a provider's calls to its upstream API behave.
+type Policy struct {
+	Timeout time.Duration // per attempt; defaults to 10s
+	Retries int           // extra attempts after a transient failure; 0 means none
+	Backoff time.Duration // wait before the first retry, doubled for each one after; defaults to 200ms
+
+	// Proxy is the URL of the proxy calls go through. Empty follows the
+	// standard HTTPS_PROXY, HTTP_PROXY and NO_PROXY variables, and Direct
+	// connects directly.
+	Proxy string
+
+	// NoProxy lists hosts reached directly even though Proxy is set. It has
+	// no effect when Proxy is empty.
+	NoProxy []string
+}
+
+// NewClient returns an HTTP client that applies policy to every request.
+// Only GET and HEAD requests are retried: the POSTs providers make redeem
+// single-use authorization codes, which a second attempt can't reuse.
+// Each attempt still ends when the request's own context does, so an
+// incoming request's deadline bounds the whole call. Calls made while
+// serving a request carry its X-Request-ID and, when it is traced, are
+// recorded as spans of its trace.
+func NewClient(policy Policy) (*http.Client, error) {
+	if policy.Timeout <= 0 {
+		policy.Timeout = defaultTimeout
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = defaultBackoff
+	}
+	proxy, err := proxyFunc(policy)
+	if err != nil {
+		return nil, err
+	}
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.Proxy = proxy
+	return &http.Client{Transport: &transport{base: base, policy: policy}}, nil
+}
+
+type transport struct {
+	base   http.RoundTripper
+	policy Policy
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Spans are named for the endpoint, without the query, which can carry
+	// credentials
+	ctx, span := tracing.Start(req.Context(), req.Method+" "+req.URL.Host+req.URL.Path, tracing.KindClient)
+	id := logging.RequestID(ctx)
+	if span != nil || id != "" {
+		req = req.Clone(ctx)
+		if id != "" && req.Header.Get(logging.RequestIDHeader) == "" {
+			req.Header.Set(logging.RequestIDHeader, id)
+		}
+		if span != nil {
+			req.Header.Set(tracing.TraceParentHeader, span.TraceParent())
+		}
+	}
+	resp, attempts, err := t.send(req)
+	span.SetAttributes("http.request.method", req.Method, "server.address", req.URL.Host, "url.path", req.URL.Path)
+	if attempts > 1 {
+		span.SetAttributes("http.request.resend_count", attempts-1)
+	}
+	if err != nil {
+		span.SetError(err)
+	} else {
+		span.SetAttributes("http.response.status_code", resp.StatusCode)
+		if resp.StatusCode >= 500 {
+			span.SetError(errors.New(resp.Status))
+		}
+	}
+	span.End()
+	return resp, err
+}
+
+// send sends req, retrying transient failures as the policy allows, and
+// reports how many attempts it took.
+func (t *transport) send(req *http.Request) (*http.Response, int, error) {
+	retryable := req.Method == http.MethodGet || req.Method == http.MethodHead
+	delay := t.policy.Backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.attempt(req)
+		if !retryable || attempt >= t.policy.Retries || !transient(req, resp, err) {
+			return resp, attempt + 1, err
+		}
+		if resp != nil {
+			io.CopyN(io.Discard, resp.Body, maxDrain)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, attempt + 1, req.Context().Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// attempt sends req once, giving up after the policy's timeout. The
+// response body stays readable until it is closed.
+func (t *transport) attempt(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.policy.Timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// transient reports whether a failed attempt may succeed if repeated: a
+// network error or timeout that wasn't the caller giving up, or a 5xx that
+// signals a temporary upstream problem.
+func transient(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return req.Context().Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// cancelBody releases an attempt's timeout once the body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,213 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/logging"
+	"github.com/BlackMission/centralauth/internal/tracing"
+)
+
+func newClient(t *testing.T, policy Policy) *http.Client {
+	t.Helper()
+	c, err := NewClient(policy)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	return c
+}
+
+func TestClient_RetriesTransientFailures(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	c := newClient(t, Policy{Retries: 2, Backoff: time.Millisecond})
+	resp, err := c.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" || calls.Load() != 3 {
+		t.Errorf("got %d %q after %d calls", resp.StatusCode, body, calls.Load())
+	}
+}
+
+func TestClient_GivesUpAfterRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	resp, err := newClient(t, Policy{Retries: 1, Backoff: time.Millisecond}).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || calls.Load() != 2 {
+		t.Errorf("got %d after %d calls", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestClient_DoesNotRetry(t *testing.T) {
+	tests := map[string]struct {
+		method string
+		status int
+	}{
+		"POST":            {http.MethodPost, http.StatusBadGateway},
+		"client error":    {http.MethodGet, http.StatusNotFound},
+		"not implemented": {http.MethodGet, http.StatusNotImplemented},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			req, _ := http.NewRequest(tt.method, srv.URL, strings.NewReader("code=once"))
+			resp, err := newClient(t, Policy{Retries: 3, Backoff: time.Millisecond}).Do(req)
+			if err != nil {
+				t.Fatalf("Do error: %v", err)
+			}
+			resp.Body.Close()
+			if calls.Load() != 1 {
+				t.Errorf("expected one attempt, got %d", calls.Load())
+			}
+		})
+	}
+}
+
+func TestClient_TimeoutPerAttempt(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	resp, err := newClient(t, Policy{Timeout: 50 * time.Millisecond, Retries: 1, Backoff: time.Millisecond}).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "ok" {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestClient_StopsWhenCallerGivesUp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+
+	start := time.Now()
+	_, err := newClient(t, Policy{Retries: 10, Backoff: time.Second}).Do(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the caller's deadline, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("expected backoff to end with the caller's deadline")
+	}
+}
+
+func TestClient_ForwardsRequestID(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get(logging.RequestIDHeader))
+	}))
+	defer srv.Close()
+
+	c := newClient(t, Policy{})
+	req, _ := http.NewRequestWithContext(logging.WithRequestID(context.Background(), "r1"), http.MethodGet, srv.URL, nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("Do error: %v", err)
+	}
+	resp.Body.Close()
+	if req.Header.Get(logging.RequestIDHeader) != "" {
+		t.Error("expected the caller's request to be left unchanged")
+	}
+	resp, err = c.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	resp.Body.Close()
+	if len(got) != 2 || got[0] != "r1" || got[1] != "" {
+		t.Errorf("X-Request-ID sent = %q", got)
+	}
+}
+
+func TestClient_Traced(t *testing.T) {
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get(tracing.TraceParentHeader)
+	}))
+	defer srv.Close()
+
+	tracer := tracing.New(tracing.Config{Endpoint: "http://127.0.0.1:0", SampleRatio: 1})
+	defer tracer.Shutdown(context.Background())
+	ctx, span := tracer.StartRequest(context.Background(), "GET /callback/{provider}", http.Header{})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/users/@me?token=secret", nil)
+	resp, err := newClient(t, Policy{}).Do(req)
+	if err != nil {
+		t.Fatalf("Do error: %v", err)
+	}
+	resp.Body.Close()
+	// The provider sees the call's own span, in the request's trace
+	if !strings.HasPrefix(traceparent, "00-"+span.TraceID()+"-") || traceparent == span.TraceParent() {
+		t.Errorf("traceparent = %q, request span %q", traceparent, span.TraceParent())
+	}
+}
+
+func TestClient_Proxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte("via proxy"))
+	}))
+	defer proxy.Close()
+
+	resp, err := newClient(t, Policy{Proxy: proxy.URL}).Get("http://api.example.com/users/@me")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	defer resp.Body.Close()
+	if proxied != "http://api.example.com/users/@me" {
+		t.Errorf("expected the proxy to receive the request, got %q", proxied)
+	}
+}
+
+func TestClient_InvalidProxy(t *testing.T) {
+	for _, proxy := range []string{"proxy.internal:3128", "ftp://proxy.internal", "http://"} {
+		if _, err := NewClient(Policy{Proxy: proxy}); err == nil {
+			t.Errorf("%q: expected an error", proxy)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package outbound
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Probe checks that each of targets answers over HTTP, stopping at the
+// first that doesn't. Any status below 500 counts as up: an endpoint that
+// turns away an unauthenticated GET is still reachable.
+func Probe(ctx context.Context, client *http.Client, targets ...string) error {
+	for _, target := range targets {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return fmt.Errorf("probing %s: %w", target, err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("probing %s: %w", req.URL.Host, err)
+		}
+		io.CopyN(io.Discard, resp.Body, maxDrain)
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("probing %s: status %d", req.URL.Host, resp.StatusCode)
+		}
+	}
+	return nil
+}
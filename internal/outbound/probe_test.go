@@ -0,0 +1,34 @@
+package outbound
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	if err := Probe(context.Background(), srv.Client(), srv.URL+"/", srv.URL+"/token"); err != nil {
+		t.Errorf("expected 2xx and 4xx to count as up, got %v", err)
+	}
+	err := Probe(context.Background(), srv.Client(), srv.URL+"/", srv.URL+"/down")
+	if err == nil || !strings.Contains(err.Error(), "status 503") {
+		t.Errorf("expected a 503 to count as down, got %v", err)
+	}
+
+	srv.Close()
+	if err := Probe(context.Background(), http.DefaultClient, srv.URL+"/"); err == nil {
+		t.Error("expected an unreachable host to count as down")
+	}
+}
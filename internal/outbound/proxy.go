@@ -0,0 +1,98 @@
+package outbound
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// Direct is the Policy.Proxy value that turns proxying off, even when the
+// standard proxy variables are set.
+const Direct = "direct"
+
+// proxyFunc returns the transport's Proxy function for policy.
+func proxyFunc(policy Policy) (func(*http.Request) (*url.URL, error), error) {
+	switch policy.Proxy {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case Direct:
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(policy.Proxy)
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q", policy.Proxy)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("proxy URL %q must be http, https, or socks5", policy.Proxy)
+	}
+
+	bypass := parseNoProxy(policy.NoProxy)
+	return func(req *http.Request) (*url.URL, error) {
+		if bypass.matches(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
+
+// noProxy is a parsed NO_PROXY list.
+type noProxy struct {
+	all      bool
+	domains  []string // match the name and its subdomains
+	prefixes []netip.Prefix
+}
+
+// parseNoProxy reads entries the way NO_PROXY is commonly written: host
+// names (with or without a leading "." or "*."), IP addresses, CIDR ranges,
+// or "*" for everything.
+func parseNoProxy(entries []string) noProxy {
+	var np noProxy
+	for _, e := range entries {
+		e = strings.ToLower(strings.TrimSpace(e))
+		switch {
+		case e == "":
+		case e == "*":
+			np.all = true
+		default:
+			if p, err := netip.ParsePrefix(e); err == nil {
+				np.prefixes = append(np.prefixes, p.Masked())
+			} else if a, err := netip.ParseAddr(strings.Trim(e, "[]")); err == nil {
+				np.prefixes = append(np.prefixes, netip.PrefixFrom(a, a.BitLen()))
+			} else {
+				np.domains = append(np.domains, strings.TrimPrefix(strings.TrimPrefix(e, "*"), "."))
+			}
+		}
+	}
+	return np
+}
+
+// matches reports whether host should be reached without the proxy.
+// Loopback addresses always are.
+func (np noProxy) matches(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if np.all || host == "localhost" {
+		return true
+	}
+	if a, err := netip.ParseAddr(host); err == nil {
+		if a.IsLoopback() {
+			return true
+		}
+		for _, p := range np.prefixes {
+			if p.Contains(a.Unmap()) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, d := range np.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
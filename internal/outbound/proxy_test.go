@@ -0,0 +1,61 @@
+package outbound
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNoProxy(t *testing.T) {
+	np := parseNoProxy([]string{"internal.example.com", ".corp", "*.svc.local", "10.0.0.0/8", "192.0.2.7", " "})
+
+	tests := map[string]bool{
+		"internal.example.com":     true,
+		"api.internal.example.com": true,
+		"example.com":              false,
+		"host.corp":                true,
+		"corp":                     true,
+		"a.b.svc.local":            true,
+		"10.1.2.3":                 true,
+		"11.1.2.3":                 false,
+		"192.0.2.7":                true,
+		"192.0.2.8":                false,
+		"localhost":                true,
+		"127.0.0.1":                true,
+		"::1":                      true,
+		"discord.com":              false,
+		"notinternal.example.com":  false,
+	}
+	for host, want := range tests {
+		if got := np.matches(host); got != want {
+			t.Errorf("%s: got %v, want %v", host, got, want)
+		}
+	}
+
+	if !parseNoProxy([]string{"*"}).matches("discord.com") {
+		t.Error("expected * to match every host")
+	}
+}
+
+func TestProxyFunc(t *testing.T) {
+	proxy, err := proxyFunc(Policy{Proxy: "http://proxy.corp:3128", NoProxy: []string{"gitlab.corp"}})
+	if err != nil {
+		t.Fatalf("proxyFunc error: %v", err)
+	}
+	for target, want := range map[string]string{
+		"https://discord.com/api/users/@me": "http://proxy.corp:3128",
+		"https://gitlab.corp/api/v4/user":   "",
+	} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		got, err := proxy(req)
+		if err != nil {
+			t.Fatalf("%s: proxy error: %v", target, err)
+		}
+		if (got == nil && want != "") || (got != nil && got.String() != want) {
+			t.Errorf("%s: got proxy %v, want %q", target, got, want)
+		}
+	}
+
+	if proxy, err := proxyFunc(Policy{Proxy: Direct}); err != nil || proxy != nil {
+		t.Errorf("expected no proxy for %q, got %v", Direct, err)
+	}
+}
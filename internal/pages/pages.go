@@ -0,0 +1,159 @@
+package pages
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// Unavailable is the data for the "try again later" page.
+type Unavailable struct {
+	Title   string
+	Message string
+}
+
+// Maintenance is the data for the page shown instead of signing in while
+// maintenance mode is on. An empty Message shows the default one.
+type Maintenance struct {
+	Message string
+}
+
+// Error is the data for the page a browser-facing route shows when a
+// sign-in fails. ReturnURL, when set, links back to the client the user
+// came from; it must only ever be derived from a validated redirect_uri.
+type Error struct {
+	Message   string
+	FlowID    string
+	RequestID string
+	Client    string
+	ReturnURL string
+}
+
+// Captcha describes the CAPTCHA widget to embed in a form.
+type Captcha struct {
+	ScriptURL   string
+	WidgetClass string
+	SiteKey     string
+}
+
+// LocalLogin is the data for the local provider's sign-in page.
+type LocalLogin struct {
+	State       string
+	Email       string
+	Error       string
+	RegisterURL string // empty when registration is closed
+	Captcha     *Captcha
+}
+
+// LocalRegister is the data for the local provider's registration page.
+type LocalRegister struct {
+	State             string
+	Email             string
+	Username          string
+	Error             string
+	LoginURL          string
+	MinPasswordLength int
+	Captcha           *Captcha
+}
+
+// PhoneLogin is the data for the phone provider's number entry page.
+type PhoneLogin struct {
+	State   string
+	Phone   string
+	Error   string
+	Captcha *Captcha
+}
+
+// PhoneVerify is the data for the phone provider's code entry page.
+type PhoneVerify struct {
+	State      string
+	Challenge  string
+	Phone      string
+	Error      string
+	RestartURL string
+}
+
+// LDAPLogin is the data for the LDAP provider's sign-in page.
+type LDAPLogin struct {
+	State    string
+	Username string
+	Error    string
+	Captcha  *Captcha
+}
+
+// TOTP is the data for the second-factor page. When Enroll is set the page
+// shows Secret and URI for adding the account to an authenticator app first.
+type TOTP struct {
+	Token  string
+	Error  string
+	Enroll bool
+	Secret string
+	URI    template.URL // otpauth:// is not a scheme html/template trusts by default
+}
+
+// ProviderChoice is the data for the page where a user signing in to a
+// client that allows several providers picks one. With a Captcha, the page
+// is a form posted to Action rather than a link per provider.
+type ProviderChoice struct {
+	Client    string
+	Providers []ProviderLink
+	Action    string
+	Error     string
+	Captcha   *Captcha
+}
+
+// ProviderLink is one provider on the ProviderChoice page.
+type ProviderLink struct {
+	Name string
+	URL  string
+}
+
+// Device is the data for the page where a user approves a device. Without
+// a Client it asks for the user code; with one it asks the user to confirm
+// and pick a provider. Message replaces both once the user is done.
+type Device struct {
+	UserCode  string
+	Error     string
+	Client    string
+	Providers []string
+	Message   string
+	Captcha   *Captcha
+}
+
+// Override replaces built-in templates with those defined by the *.html
+// files in dir, so that an operator can brand the pages: a file defining
+// "header" and "footer" restyles every page, and one defining "error.html"
+// replaces just the error page. It must be called before serving.
+func Override(dir string) error {
+	t, err := templates.Clone()
+	if err != nil {
+		return fmt.Errorf("pages: %w", err)
+	}
+	if t, err = t.ParseGlob(filepath.Join(dir, "*.html")); err != nil {
+		return fmt.Errorf("pages: %w", err)
+	}
+	templates = t
+	return nil
+}
+
+// Render executes the named page template and writes it with the given status.
+func Render(w http.ResponseWriter, status int, name string, data any) error {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, err := buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,36 @@
+package pages
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOverride(t *testing.T) {
+	builtin := templates
+	t.Cleanup(func() { templates = builtin })
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "brand.html"), []byte(`{{define "footer"}}<footer>Black Mission</footer></main>{{end}}`), 0o644)
+	if err := Override(dir); err != nil {
+		t.Fatalf("Override error: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	if err := Render(rr, 400, "error.html", Error{Message: "state token expired"}); err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, "<footer>Black Mission</footer>") || !strings.Contains(body, "state token expired") {
+		t.Errorf("page = %s", body)
+	}
+
+	if err := Override(t.TempDir()); err == nil {
+		t.Error("expected an error for a directory without templates")
+	}
+	os.WriteFile(filepath.Join(dir, "broken.html"), []byte(`{{define "error.html"}}{{.Message}`), 0o644)
+	if err := Override(dir); err == nil {
+		t.Error("expected an error for a template that doesn't parse")
+	}
+}
@@ -1,15 +1,22 @@
 package discord
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"regexp"
+	"slices"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/outbound"
 )
 
 const (
@@ -17,44 +24,83 @@ const (
 	defaultAuthEndpoint = "https://discord.com/api/oauth2/authorize"
 	defaultTokenURL     = "https://discord.com/api/oauth2/token"
 	defaultUserURL      = "https://discord.com/api/users/@me"
+	defaultUsersURL     = "https://discord.com/api/users"
+	defaultGuildsURL    = "https://discord.com/api/guilds"
 )
 
+// Discord IDs are snowflakes: 64-bit integers in decimal.
+var snowflakeRegex = regexp.MustCompile(`^[0-9]{1,20}$`)
+
 // Config holds Discord OAuth2 settings.
 type Config struct {
 	ClientID     string
 	ClientSecret string
 	Scopes       []string
 	CallbackURL  string // The CentralAuth callback URL: {base_url}/callback/discord
+
+	// GuildID, if set, makes Exchange report the user's roles in that guild.
+	// They are read with BotToken if set, which needs the bot to be in the
+	// guild, and otherwise with the user's token and the guilds.members.read
+	// scope.
+	GuildID string
+
+	// GuildFilter limits the guild list reported with the guilds scope to
+	// these guild IDs. Empty reports every guild.
+	GuildFilter []string
+
+	// BotToken, if set, enables LookupUser and is used to read guild roles.
+	BotToken string
+
+	// HTTPClient makes the calls to Discord's API (http.DefaultClient if nil).
+	HTTPClient *http.Client
 }
 
 // Provider implements OAuth2 for Discord.
 type Provider struct {
 	cfg          Config
 	httpClient   *http.Client
+	scopes       atomic.Pointer[[]string]
 	authEndpoint string
 	tokenURL     string
 	userURL      string
+	usersURL     string
+	guildsURL    string
 }
 
 // New creates a Discord provider.
 func New(cfg Config) *Provider {
-	return &Provider{
+	p := &Provider{
 		cfg:          cfg,
-		httpClient:   http.DefaultClient,
+		httpClient:   cmp.Or(cfg.HTTPClient, http.DefaultClient),
 		authEndpoint: defaultAuthEndpoint,
 		tokenURL:     defaultTokenURL,
 		userURL:      defaultUserURL,
+		usersURL:     defaultUsersURL,
+		guildsURL:    defaultGuildsURL,
 	}
+	p.SetScopes(cfg.Scopes)
+	return p
 }
 
 func (p *Provider) Name() string { return providerName }
 
+// SetScopes replaces the scopes requested from now on. Flows already at
+// the provider keep the scopes they asked for.
+func (p *Provider) SetScopes(scopes []string) {
+	p.scopes.Store(&scopes)
+}
+
+// Probe checks that Discord's token and user endpoints are reachable.
+func (p *Provider) Probe(ctx context.Context) error {
+	return outbound.Probe(ctx, p.httpClient, p.tokenURL, p.userURL)
+}
+
 func (p *Provider) AuthURL(stateToken string) (string, error) {
 	params := url.Values{
 		"client_id":     {p.cfg.ClientID},
 		"redirect_uri":  {p.cfg.CallbackURL},
 		"response_type": {"code"},
-		"scope":         {strings.Join(p.cfg.Scopes, " ")},
+		"scope":         {strings.Join(*p.scopes.Load(), " ")},
 		"state":         {stateToken},
 	}
 	return p.authEndpoint + "?" + params.Encode(), nil
@@ -66,25 +112,98 @@ func (p *Provider) Exchange(ctx context.Context, params map[string]string) (*dom
 		return nil, domain.ErrMissingProviderParams
 	}
 
-	token, err := p.exchangeCode(ctx, code)
+	tokens, err := p.exchangeCode(ctx, code)
 	if err != nil {
 		return nil, err
 	}
+	token := tokens.AccessToken
+
+	// Go by what the user granted rather than what is configured now, which
+	// may have changed since the flow started
+	granted := *p.scopes.Load()
+	if tokens.Scope != "" {
+		granted = strings.Fields(tokens.Scope)
+	}
 
 	user, err := p.fetchUser(ctx, token)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := p.fetchExtras(ctx, token, user.ProviderID, granted, user.ProviderData.Discord); err != nil {
+		// Extras are best effort; leave them out rather than fail the login
+		slog.WarnContext(ctx, "discord: fetching extras failed", "provider_id", user.ProviderID, "error", err)
+		user.ProviderData = nil
+	}
+
+	// Linked accounts are only readable with the "connections" OAuth scope
+	if slices.Contains(granted, "connections") {
+		if user.Connections, err = p.fetchConnections(ctx, token); err != nil {
+			return nil, err
+		}
+	}
+
+	return &domain.AuthResult{User: *user, Tokens: tokens}, nil
+}
+
+// LookupUser resolves a Discord user ID to a profile with the bot token.
+// It is for flows a Discord bot starts, where the user can't be sent
+// through OAuth. The user has not authenticated, and the bot's view of them
+// carries no email, locale or MFA status.
+func (p *Provider) LookupUser(ctx context.Context, userID string) (*domain.AuthResult, error) {
+	if p.cfg.BotToken == "" {
+		return nil, domain.ErrLookupNotConfigured
+	}
+	if !snowflakeRegex.MatchString(userID) {
+		return nil, fmt.Errorf("%w: user ID must be a Discord snowflake", domain.ErrMissingProviderParams)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.usersURL+"/"+userID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating user request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bot "+p.cfg.BotToken)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderUserFetch, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading response: %v", domain.ErrProviderUserFetch, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: discord user %s", domain.ErrAccountNotFound, userID)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d: %s", domain.ErrProviderUserFetch, resp.StatusCode, body)
+	}
+
+	var du discordUser
+	if err := json.Unmarshal(body, &du); err != nil {
+		return nil, fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderUserFetch, err)
+	}
+
+	user := userInfo(du)
+	user.ProviderData = nil
+	user.Raw = body
 	return &domain.AuthResult{User: *user}, nil
 }
 
 type tokenResponse struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope"`
 }
 
-func (p *Provider) exchangeCode(ctx context.Context, code string) (string, error) {
+// exchangeCode trades the authorization code for the user's tokens, which
+// clients with token passthrough receive as they are.
+func (p *Provider) exchangeCode(ctx context.Context, code string) (*domain.ProviderTokens, error) {
 	data := url.Values{
 		"client_id":     {p.cfg.ClientID},
 		"client_secret": {p.cfg.ClientSecret},
@@ -95,35 +214,41 @@ func (p *Provider) exchangeCode(ctx context.Context, code string) (string, error
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(data.Encode()))
 	if err != nil {
-		return "", fmt.Errorf("creating token request: %w", err)
+		return nil, fmt.Errorf("creating token request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("%w: %v", domain.ErrProviderExchange, err)
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderExchange, err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("%w: reading response: %v", domain.ErrProviderExchange, err)
+		return nil, fmt.Errorf("%w: reading response: %v", domain.ErrProviderExchange, err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("%w: status %d: %s", domain.ErrProviderExchange, resp.StatusCode, body)
+		return nil, fmt.Errorf("%w: status %d: %s", domain.ErrProviderExchange, resp.StatusCode, body)
 	}
 
 	var tokenResp tokenResponse
 	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		return "", fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderExchange, err)
+		return nil, fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderExchange, err)
 	}
 
 	if tokenResp.AccessToken == "" {
-		return "", fmt.Errorf("%w: empty access token", domain.ErrProviderExchange)
+		return nil, fmt.Errorf("%w: empty access token", domain.ErrProviderExchange)
 	}
 
-	return tokenResp.AccessToken, nil
+	return &domain.ProviderTokens{
+		AccessToken:  tokenResp.AccessToken,
+		RefreshToken: tokenResp.RefreshToken,
+		TokenType:    tokenResp.TokenType,
+		Scope:        tokenResp.Scope,
+		ExpiresAt:    time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second),
+	}, nil
 }
 
 type discordUser struct {
@@ -132,7 +257,10 @@ type discordUser struct {
 	GlobalName    string `json:"global_name"`
 	Avatar        string `json:"avatar"`
 	Email         string `json:"email"`
+	Verified      bool   `json:"verified"`
 	Discriminator string `json:"discriminator"`
+	Locale        string `json:"locale"`
+	MFAEnabled    bool   `json:"mfa_enabled"`
 }
 
 func (p *Provider) fetchUser(ctx context.Context, accessToken string) (*domain.UserInfo, error) {
@@ -162,6 +290,12 @@ func (p *Provider) fetchUser(ctx context.Context, accessToken string) (*domain.U
 		return nil, fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderUserFetch, err)
 	}
 
+	user := userInfo(du)
+	user.Raw = body
+	return user, nil
+}
+
+func userInfo(du discordUser) *domain.UserInfo {
 	avatarURL := ""
 	if du.Avatar != "" {
 		avatarURL = fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", du.ID, du.Avatar)
@@ -173,11 +307,162 @@ func (p *Provider) fetchUser(ctx context.Context, accessToken string) (*domain.U
 	}
 
 	return &domain.UserInfo{
-		ProviderName: providerName,
-		ProviderID:   du.ID,
-		Username:     du.Username,
-		DisplayName:  displayName,
-		AvatarURL:    avatarURL,
-		Email:        du.Email,
-	}, nil
+		ProviderName:  providerName,
+		ProviderID:    du.ID,
+		Username:      du.Username,
+		DisplayName:   displayName,
+		AvatarURL:     avatarURL,
+		Email:         du.Email,
+		EmailVerified: du.Email != "" && du.Verified,
+		Locale:        du.Locale,
+		ProviderData: domain.NewDiscordData(domain.DiscordExtras{
+			Locale:     du.Locale,
+			MFAEnabled: du.MFAEnabled,
+		}),
+	}
+}
+
+// fetchExtras fills in the guild roles and guild list, if configured.
+func (p *Provider) fetchExtras(ctx context.Context, accessToken, userID string, granted []string, extras *domain.DiscordExtras) error {
+	var err error
+	if p.cfg.GuildID != "" {
+		if extras.GuildRoles, err = p.fetchGuildRoles(ctx, accessToken, userID); err != nil {
+			return err
+		}
+	}
+	if slices.Contains(granted, "guilds") {
+		if extras.Guilds, err = p.fetchGuilds(ctx, accessToken); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchGuilds returns the guilds the user is in that pass the filter.
+func (p *Provider) fetchGuilds(ctx context.Context, accessToken string) ([]domain.DiscordGuild, error) {
+	// A user can be in at most 200 guilds, which is also the page size limit
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.userURL+"/guilds?limit=200", nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating guilds request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching guilds: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading guilds: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("guilds status %d: %s", resp.StatusCode, body)
+	}
+
+	var dgs []domain.DiscordGuild
+	if err := json.Unmarshal(body, &dgs); err != nil {
+		return nil, fmt.Errorf("invalid guilds JSON: %w", err)
+	}
+
+	guilds := make([]domain.DiscordGuild, 0, len(dgs))
+	for _, g := range dgs {
+		if len(p.cfg.GuildFilter) == 0 || slices.Contains(p.cfg.GuildFilter, g.ID) {
+			guilds = append(guilds, g)
+		}
+	}
+	return guilds, nil
+}
+
+// fetchGuildRoles returns the user's role IDs in the configured guild, or
+// nil if they aren't a member.
+func (p *Provider) fetchGuildRoles(ctx context.Context, accessToken, userID string) ([]string, error) {
+	reqURL := p.userURL + "/guilds/" + url.PathEscape(p.cfg.GuildID) + "/member"
+	auth := "Bearer " + accessToken
+	if p.cfg.BotToken != "" {
+		reqURL = p.guildsURL + "/" + url.PathEscape(p.cfg.GuildID) + "/members/" + url.PathEscape(userID)
+		auth = "Bot " + p.cfg.BotToken
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating guild member request: %w", err)
+	}
+	req.Header.Set("Authorization", auth)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching guild member: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading guild member: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("guild member status %d: %s", resp.StatusCode, body)
+	}
+
+	var member struct {
+		Roles []string `json:"roles"`
+	}
+	if err := json.Unmarshal(body, &member); err != nil {
+		return nil, fmt.Errorf("invalid guild member JSON: %w", err)
+	}
+	if member.Roles == nil {
+		member.Roles = []string{}
+	}
+	return member.Roles, nil
+}
+
+type discordConnection struct {
+	Type     string `json:"type"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Verified bool   `json:"verified"`
+}
+
+func (p *Provider) fetchConnections(ctx context.Context, accessToken string) ([]domain.Connection, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.userURL+"/connections", nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating connections request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderUserFetch, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading connections: %v", domain.ErrProviderUserFetch, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: connections status %d: %s", domain.ErrProviderUserFetch, resp.StatusCode, body)
+	}
+
+	var dcs []discordConnection
+	if err := json.Unmarshal(body, &dcs); err != nil {
+		return nil, fmt.Errorf("%w: invalid connections JSON: %v", domain.ErrProviderUserFetch, err)
+	}
+
+	connections := make([]domain.Connection, 0, len(dcs))
+	for _, dc := range dcs {
+		connections = append(connections, domain.Connection{
+			Type:     dc.Type,
+			ID:       dc.ID,
+			Name:     dc.Name,
+			Verified: dc.Verified,
+		})
+	}
+	return connections, nil
 }
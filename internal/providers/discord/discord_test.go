@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/BlackMission/centralauth/internal/domain"
 )
@@ -72,8 +73,11 @@ func TestExchange_Success(t *testing.T) {
 	p := setupTestProvider(
 		func(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(tokenResponse{
-				AccessToken: "test-access-token",
-				TokenType:   "Bearer",
+				AccessToken:  "test-access-token",
+				TokenType:    "Bearer",
+				RefreshToken: "test-refresh-token",
+				ExpiresIn:    604800,
+				Scope:        "identify email",
 			})
 		},
 		func(w http.ResponseWriter, r *http.Request) {
@@ -86,6 +90,8 @@ func TestExchange_Success(t *testing.T) {
 				GlobalName: "Test User",
 				Avatar:     "abc123",
 				Email:      "test@example.com",
+				Verified:   true,
+				Locale:     "en-GB",
 			})
 		},
 	)
@@ -110,9 +116,23 @@ func TestExchange_Success(t *testing.T) {
 	if result.User.Email != "test@example.com" {
 		t.Errorf("expected email 'test@example.com', got %q", result.User.Email)
 	}
+	if !result.User.EmailVerified {
+		t.Error("expected the email to be verified")
+	}
+	if result.User.Locale != "en-GB" {
+		t.Errorf("expected locale 'en-GB', got %q", result.User.Locale)
+	}
 	if result.User.AvatarURL != "https://cdn.discordapp.com/avatars/123456789/abc123.png" {
 		t.Errorf("unexpected avatar URL: %q", result.User.AvatarURL)
 	}
+	var raw map[string]any
+	if err := json.Unmarshal(result.User.Raw, &raw); err != nil || raw["id"] != "123456789" {
+		t.Errorf("expected the raw profile, got %s (%v)", result.User.Raw, err)
+	}
+	if tok := result.Tokens; tok == nil || tok.AccessToken != "test-access-token" ||
+		tok.RefreshToken != "test-refresh-token" || tok.Scope != "identify email" || time.Until(tok.ExpiresAt) < 6*24*time.Hour {
+		t.Errorf("unexpected tokens: %+v", tok)
+	}
 }
 
 func TestExchange_TokenFailure(t *testing.T) {
@@ -207,3 +227,399 @@ func TestExchange_FallbackDisplayName(t *testing.T) {
 		t.Errorf("expected display_name to fallback to username, got %q", result.User.DisplayName)
 	}
 }
+
+func TestExchange_Connections(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(tokenResponse{AccessToken: "test-access-token", TokenType: "Bearer"})
+	})
+	mux.HandleFunc("/users/@me", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discordUser{ID: "123456789", Username: "testuser"})
+	})
+	mux.HandleFunc("/users/@me/connections", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-access-token" {
+			t.Error("expected Bearer token in Authorization header")
+		}
+		w.Write([]byte(`[{"type":"steam","id":"76561198000000000","name":"Player","verified":true,"visibility":1}]`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	p := New(Config{Scopes: []string{"identify", "connections"}})
+	p.httpClient = server.Client()
+	p.tokenURL = server.URL + "/oauth2/token"
+	p.userURL = server.URL + "/users/@me"
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	want := domain.Connection{Type: "steam", ID: "76561198000000000", Name: "Player", Verified: true}
+	if len(result.User.Connections) != 1 || result.User.Connections[0] != want {
+		t.Errorf("unexpected connections: %+v", result.User.Connections)
+	}
+}
+
+func TestExchange_NoConnectionsWithoutScope(t *testing.T) {
+	p := setupTestProvider(
+		func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(tokenResponse{AccessToken: "test-access-token", TokenType: "Bearer"})
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(discordUser{ID: "123456789", Username: "testuser"})
+		},
+	)
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if result.User.Connections != nil {
+		t.Errorf("expected no connections, got %+v", result.User.Connections)
+	}
+}
+
+func TestExchange_ProviderData(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(tokenResponse{AccessToken: "test-access-token", TokenType: "Bearer"})
+	})
+	mux.HandleFunc("/users/@me", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"123456789","username":"testuser","locale":"en-GB","mfa_enabled":true}`))
+	})
+	mux.HandleFunc("/users/@me/guilds/42/member", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"roles":["1001","1002"]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	p := New(Config{Scopes: []string{"identify", "guilds.members.read"}, GuildID: "42"})
+	p.httpClient = server.Client()
+	p.tokenURL = server.URL + "/oauth2/token"
+	p.userURL = server.URL + "/users/@me"
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	pd := result.User.ProviderData
+	if pd == nil || pd.Kind != domain.KindDiscord || pd.Discord == nil {
+		t.Fatalf("expected discord provider data, got %+v", pd)
+	}
+	if pd.Discord.Locale != "en-GB" || !pd.Discord.MFAEnabled {
+		t.Errorf("unexpected extras: %+v", pd.Discord)
+	}
+	if len(pd.Discord.GuildRoles) != 2 || pd.Discord.GuildRoles[0] != "1001" {
+		t.Errorf("unexpected guild roles: %v", pd.Discord.GuildRoles)
+	}
+}
+
+func TestExchange_NotGuildMember(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(tokenResponse{AccessToken: "test-access-token", TokenType: "Bearer"})
+	})
+	mux.HandleFunc("/users/@me", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"123456789","username":"testuser"}`))
+	})
+	mux.HandleFunc("/users/@me/guilds/42/member", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"Unknown Guild","code":10004}`, http.StatusNotFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	p := New(Config{GuildID: "42"})
+	p.httpClient = server.Client()
+	p.tokenURL = server.URL + "/oauth2/token"
+	p.userURL = server.URL + "/users/@me"
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if pd := result.User.ProviderData; pd == nil || pd.Discord.GuildRoles != nil {
+		t.Errorf("expected provider data without guild roles, got %+v", pd)
+	}
+}
+
+func setupLookupProvider(t *testing.T, botToken string, handler http.HandlerFunc) *Provider {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/{id}", handler)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	p := New(Config{BotToken: botToken})
+	p.httpClient = server.Client()
+	p.usersURL = server.URL + "/users"
+	return p
+}
+
+func TestLookupUser_Success(t *testing.T) {
+	p := setupLookupProvider(t, "test-bot-token", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bot test-bot-token" {
+			t.Errorf("unexpected Authorization header %q", got)
+		}
+		if id := r.PathValue("id"); id != "123456789" {
+			t.Errorf("unexpected user ID %q", id)
+		}
+		w.Write([]byte(`{"id":"123456789","username":"testuser","global_name":"Test User","avatar":"abc"}`))
+	})
+
+	result, err := p.LookupUser(context.Background(), "123456789")
+	if err != nil {
+		t.Fatalf("LookupUser error: %v", err)
+	}
+	u := result.User
+	if u.ProviderID != "123456789" || u.Username != "testuser" || u.DisplayName != "Test User" {
+		t.Errorf("unexpected user: %+v", u)
+	}
+	if u.AvatarURL != "https://cdn.discordapp.com/avatars/123456789/abc.png" {
+		t.Errorf("unexpected avatar URL %q", u.AvatarURL)
+	}
+	if u.ProviderData != nil {
+		t.Errorf("expected no provider data for a lookup, got %+v", u.ProviderData)
+	}
+}
+
+func TestLookupUser_NotFound(t *testing.T) {
+	p := setupLookupProvider(t, "test-bot-token", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"Unknown User","code":10013}`, http.StatusNotFound)
+	})
+
+	if _, err := p.LookupUser(context.Background(), "123456789"); !errors.Is(err, domain.ErrAccountNotFound) {
+		t.Errorf("expected ErrAccountNotFound, got %v", err)
+	}
+}
+
+func TestLookupUser_Failure(t *testing.T) {
+	p := setupLookupProvider(t, "test-bot-token", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"401: Unauthorized"}`, http.StatusUnauthorized)
+	})
+
+	if _, err := p.LookupUser(context.Background(), "123456789"); !errors.Is(err, domain.ErrProviderUserFetch) {
+		t.Errorf("expected ErrProviderUserFetch, got %v", err)
+	}
+}
+
+func TestLookupUser_BadInput(t *testing.T) {
+	p := setupLookupProvider(t, "test-bot-token", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the API should not be called")
+	})
+	for _, id := range []string{"", "@me", "12/../34", "123456789012345678901"} {
+		if _, err := p.LookupUser(context.Background(), id); !errors.Is(err, domain.ErrMissingProviderParams) {
+			t.Errorf("%q: expected ErrMissingProviderParams, got %v", id, err)
+		}
+	}
+
+	p.cfg.BotToken = ""
+	if _, err := p.LookupUser(context.Background(), "123456789"); !errors.Is(err, domain.ErrLookupNotConfigured) {
+		t.Errorf("expected ErrLookupNotConfigured without a bot token, got %v", err)
+	}
+}
+
+func setupGuildsProvider(t *testing.T, filter []string, guildsHandler http.HandlerFunc) *Provider {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(tokenResponse{AccessToken: "test-access-token", TokenType: "Bearer"})
+	})
+	mux.HandleFunc("/users/@me", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"123456789","username":"testuser","locale":"en-GB"}`))
+	})
+	mux.HandleFunc("/users/@me/guilds", guildsHandler)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	p := New(Config{Scopes: []string{"identify", "guilds"}, GuildFilter: filter})
+	p.httpClient = server.Client()
+	p.tokenURL = server.URL + "/oauth2/token"
+	p.userURL = server.URL + "/users/@me"
+	return p
+}
+
+const testGuilds = `[{"id":"42","name":"BlackMission","owner":false},{"id":"77","name":"Other"}]`
+
+func TestExchange_Guilds(t *testing.T) {
+	p := setupGuildsProvider(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer test-access-token" {
+			t.Errorf("unexpected Authorization header %q", got)
+		}
+		if r.URL.Query().Get("limit") != "200" {
+			t.Errorf("expected limit=200, got %q", r.URL.RawQuery)
+		}
+		w.Write([]byte(testGuilds))
+	})
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	pd := result.User.ProviderData
+	if pd == nil || len(pd.Discord.Guilds) != 2 {
+		t.Fatalf("expected two guilds, got %+v", pd)
+	}
+	if g := pd.Discord.Guilds[0]; g.ID != "42" || g.Name != "BlackMission" {
+		t.Errorf("unexpected guild %+v", g)
+	}
+}
+
+func TestExchange_GuildsFiltered(t *testing.T) {
+	p := setupGuildsProvider(t, []string{"42", "99"}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testGuilds))
+	})
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	guilds := result.User.ProviderData.Discord.Guilds
+	if len(guilds) != 1 || guilds[0].ID != "42" {
+		t.Errorf("expected only guild 42, got %+v", guilds)
+	}
+
+	p = setupGuildsProvider(t, []string{"99"}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testGuilds))
+	})
+	result, err = p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if guilds := result.User.ProviderData.Discord.Guilds; guilds == nil || len(guilds) != 0 {
+		t.Errorf("expected an empty, non-nil guild list, got %#v", guilds)
+	}
+}
+
+func TestExchange_GuildsFailureIsNotFatal(t *testing.T) {
+	p := setupGuildsProvider(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+	})
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if result.User.ProviderData != nil {
+		t.Errorf("expected provider data to be left out, got %+v", result.User.ProviderData)
+	}
+}
+
+func TestExchange_NoGuildsWithoutScope(t *testing.T) {
+	p := setupTestProvider(
+		func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(tokenResponse{AccessToken: "test-access-token", TokenType: "Bearer"})
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(discordUser{ID: "123456789", Username: "testuser"})
+		},
+	)
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if guilds := result.User.ProviderData.Discord.Guilds; guilds != nil {
+		t.Errorf("expected no guild list without the guilds scope, got %+v", guilds)
+	}
+}
+
+func TestSetScopes(t *testing.T) {
+	p := New(Config{ClientID: "test-client-id", Scopes: []string{"identify"}})
+	p.SetScopes([]string{"identify", "guilds"})
+
+	authURL, _ := p.AuthURL("test-state-token")
+	u, _ := url.Parse(authURL)
+	if got := u.Query().Get("scope"); got != "identify guilds" {
+		t.Errorf("expected the new scopes, got %q", got)
+	}
+}
+
+func TestExchange_UsesGrantedScopes(t *testing.T) {
+	var guildCalls int
+	p := setupGuildsProvider(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		guildCalls++
+		w.Write([]byte(testGuilds))
+	})
+	// The flow started before guilds was configured, so it wasn't granted
+	p.SetScopes([]string{"identify", "guilds"})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(tokenResponse{AccessToken: "test-access-token", TokenType: "Bearer", Scope: "identify"})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	p.tokenURL = server.URL + "/oauth2/token"
+	p.httpClient = http.DefaultClient
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if guildCalls != 0 || result.User.ProviderData.Discord.Guilds != nil {
+		t.Errorf("expected no guild lookup without the granted scope, got %d calls", guildCalls)
+	}
+}
+
+func TestExchange_GuildRolesWithBotToken(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(tokenResponse{AccessToken: "test-access-token", TokenType: "Bearer"})
+	})
+	mux.HandleFunc("/users/@me", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"123456789","username":"testuser"}`))
+	})
+	mux.HandleFunc("/users/@me/guilds/42/member", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected the bot endpoint to be used")
+	})
+	mux.HandleFunc("/guilds/42/members/123456789", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bot test-bot-token" {
+			t.Errorf("unexpected Authorization header %q", got)
+		}
+		w.Write([]byte(`{"roles":["1001"]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	p := New(Config{Scopes: []string{"identify"}, GuildID: "42", BotToken: "test-bot-token"})
+	p.httpClient = server.Client()
+	p.tokenURL = server.URL + "/oauth2/token"
+	p.userURL = server.URL + "/users/@me"
+	p.guildsURL = server.URL + "/guilds"
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if pd := result.User.ProviderData; pd == nil || len(pd.Discord.GuildRoles) != 1 || pd.Discord.GuildRoles[0] != "1001" {
+		t.Errorf("unexpected provider data: %+v", pd)
+	}
+}
+
+func TestExchange_BotTokenNotGuildMember(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(tokenResponse{AccessToken: "test-access-token", TokenType: "Bearer"})
+	})
+	mux.HandleFunc("/users/@me", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"123456789","username":"testuser"}`))
+	})
+	mux.HandleFunc("/guilds/42/members/123456789", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"Unknown Member","code":10007}`, http.StatusNotFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	p := New(Config{GuildID: "42", BotToken: "test-bot-token"})
+	p.httpClient = server.Client()
+	p.tokenURL = server.URL + "/oauth2/token"
+	p.userURL = server.URL + "/users/@me"
+	p.guildsURL = server.URL + "/guilds"
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if pd := result.User.ProviderData; pd == nil || pd.Discord.GuildRoles != nil {
+		t.Errorf("expected provider data without guild roles, got %+v", pd)
+	}
+}
@@ -0,0 +1,148 @@
+package facebook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func setupTestProvider(t *testing.T, tokenHandler, userHandler http.HandlerFunc) *Provider {
+	t.Helper()
+	mux := http.NewServeMux()
+	if tokenHandler != nil {
+		mux.HandleFunc("/oauth/access_token", tokenHandler)
+	}
+	if userHandler != nil {
+		mux.HandleFunc("/me", userHandler)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	p := New(Config{
+		AppID:       "test-app-id",
+		AppSecret:   "test-app-secret",
+		Scopes:      []string{"email", "public_profile"},
+		CallbackURL: "https://auth.example.com/callback/facebook",
+	})
+	p.httpClient = server.Client()
+	p.graphURL = server.URL
+	return p
+}
+
+func okToken(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(tokenResponse{AccessToken: "test-access-token", TokenType: "bearer"})
+}
+
+func TestAuthURL_ContainsCorrectParams(t *testing.T) {
+	p := New(Config{
+		AppID:       "test-app-id",
+		Scopes:      []string{"email", "public_profile"},
+		CallbackURL: "https://auth.example.com/callback/facebook",
+	})
+
+	authURL, err := p.AuthURL("test-state-token")
+	if err != nil {
+		t.Fatalf("AuthURL error: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	if got := u.Query().Get("client_id"); got != "test-app-id" {
+		t.Errorf("expected client_id 'test-app-id', got %q", got)
+	}
+	if got := u.Query().Get("scope"); got != "email,public_profile" {
+		t.Errorf("expected scope 'email,public_profile', got %q", got)
+	}
+	if got := u.Query().Get("state"); got != "test-state-token" {
+		t.Errorf("expected state 'test-state-token', got %q", got)
+	}
+}
+
+func TestExchange_Success(t *testing.T) {
+	p := setupTestProvider(t,
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("client_secret") != "test-app-secret" || r.URL.Query().Get("code") != "auth-code" {
+				t.Errorf("unexpected token request: %s", r.URL.RawQuery)
+			}
+			okToken(w, r)
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			mac := hmac.New(sha256.New, []byte("test-app-secret"))
+			mac.Write([]byte("test-access-token"))
+			if got := r.URL.Query().Get("appsecret_proof"); got != hex.EncodeToString(mac.Sum(nil)) {
+				t.Errorf("wrong appsecret_proof %q", got)
+			}
+			if got := r.URL.Query().Get("fields"); got != "id,name,email,picture" {
+				t.Errorf("unexpected fields %q", got)
+			}
+			w.Write([]byte(`{"id": "10158", "name": "Ada Lovelace", "email": "ada@example.com",
+				"picture": {"data": {"url": "https://platform-lookaside.fbsbx.com/ada.jpg", "is_silhouette": false}}}`))
+		},
+	)
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	user := result.User
+	if user.ProviderName != "facebook" || user.ProviderID != "10158" || user.DisplayName != "Ada Lovelace" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	if user.Email != "ada@example.com" || user.AvatarURL != "https://platform-lookaside.fbsbx.com/ada.jpg" {
+		t.Errorf("unexpected profile: %+v", user)
+	}
+}
+
+func TestExchange_SilhouetteAvatarDropped(t *testing.T) {
+	p := setupTestProvider(t, okToken, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": "10158", "name": "Ada", "picture": {"data": {"url": "https://x/default.jpg", "is_silhouette": true}}}`))
+	})
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if result.User.AvatarURL != "" {
+		t.Errorf("expected placeholder avatar to be dropped, got %q", result.User.AvatarURL)
+	}
+}
+
+func TestExchange_TokenFailure(t *testing.T) {
+	p := setupTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"Invalid verification code format."}}`))
+	}, nil)
+
+	_, err := p.Exchange(context.Background(), map[string]string{"code": "bad-code"})
+	if !errors.Is(err, domain.ErrProviderExchange) {
+		t.Errorf("expected ErrProviderExchange, got %v", err)
+	}
+}
+
+func TestExchange_UserFetchFailure(t *testing.T) {
+	p := setupTestProvider(t, okToken, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"Invalid appsecret_proof provided in the API argument"}}`))
+	})
+
+	_, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if !errors.Is(err, domain.ErrProviderUserFetch) {
+		t.Errorf("expected ErrProviderUserFetch, got %v", err)
+	}
+}
+
+func TestExchange_MissingCode(t *testing.T) {
+	p := New(Config{})
+
+	_, err := p.Exchange(context.Background(), map[string]string{})
+	if !errors.Is(err, domain.ErrMissingProviderParams) {
+		t.Errorf("expected ErrMissingProviderParams, got %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package generic
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/outbound"
+)
+
+// Config describes an OAuth2 API declaratively.
+type Config struct {
+	Name         string // provider name used in /auth/{name} and /callback/{name}
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	AuthURL      string
+	TokenURL     string
+	UserURL      string
+	CallbackURL  string // The CentralAuth callback URL: {base_url}/callback/{name}
+
+	// TokenAuth is how client credentials reach the token endpoint: "post"
+	// (form fields, the default) or "basic" (HTTP basic auth).
+	TokenAuth string
+
+	// Mapping maps UserInfo fields to paths or templates over the UserURL
+	// response (see Mapping).
+	Mapping map[string]string
+
+	// HTTPClient makes the calls to the provider (a client with a 10s timeout if nil).
+	HTTPClient *http.Client
+}
+
+// Provider implements the OAuth2 authorization code flow for an API that is
+// described entirely by Config.
+type Provider struct {
+	cfg        Config
+	mapping    Mapping
+	httpClient *http.Client
+	scopes     atomic.Pointer[[]string]
+}
+
+// New creates a generic OAuth2 provider. It fails if the mapping is invalid.
+func New(cfg Config) (*Provider, error) {
+	mapping, err := ParseMapping(cfg.Mapping)
+	if err != nil {
+		return nil, fmt.Errorf("%w: provider %s: %v", domain.ErrInvalidConfig, cfg.Name, err)
+	}
+	p := &Provider{
+		cfg:        cfg,
+		mapping:    mapping,
+		httpClient: cmp.Or(cfg.HTTPClient, &http.Client{Timeout: 10 * time.Second}),
+	}
+	p.SetScopes(cfg.Scopes)
+	return p, nil
+}
+
+func (p *Provider) Name() string { return p.cfg.Name }
+
+// SetScopes replaces the scopes requested from now on. Flows already at
+// the provider keep the scopes they asked for.
+func (p *Provider) SetScopes(scopes []string) {
+	p.scopes.Store(&scopes)
+}
+
+// Probe checks that the provider's token and user endpoints are reachable.
+func (p *Provider) Probe(ctx context.Context) error {
+	return outbound.Probe(ctx, p.httpClient, p.cfg.TokenURL, p.cfg.UserURL)
+}
+
+func (p *Provider) AuthURL(stateToken string) (string, error) {
+	params := url.Values{
+		"client_id":     {p.cfg.ClientID},
+		"redirect_uri":  {p.cfg.CallbackURL},
+		"response_type": {"code"},
+		"state":         {stateToken},
+	}
+	if len(*p.scopes.Load()) > 0 {
+		params.Set("scope", strings.Join(*p.scopes.Load(), " "))
+	}
+	sep := "?"
+	if strings.Contains(p.cfg.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.cfg.AuthURL + sep + params.Encode(), nil
+}
+
+func (p *Provider) Exchange(ctx context.Context, params map[string]string) (*domain.AuthResult, error) {
+	if e := params["error"]; e != "" {
+		return nil, fmt.Errorf("%w: %s: %s", domain.ErrProviderExchange, e, params["error_description"])
+	}
+	code, ok := params["code"]
+	if !ok || code == "" {
+		return nil, domain.ErrMissingProviderParams
+	}
+
+	token, err := p.exchangeCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := p.fetchUser(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.AuthResult{User: *user}, nil
+}
+
+func (p *Provider) exchangeCode(ctx context.Context, code string) (string, error) {
+	data := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.cfg.CallbackURL},
+	}
+	if p.cfg.TokenAuth != "basic" {
+		data.Set("client_id", p.cfg.ClientID)
+		data.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.TokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.TokenAuth == "basic" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", domain.ErrProviderExchange, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%w: reading response: %v", domain.ErrProviderExchange, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: status %d: %s", domain.ErrProviderExchange, resp.StatusCode, body)
+	}
+
+	// Some older APIs answer with a form-encoded body whatever we accept
+	var accessToken string
+	if ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); ct == "application/x-www-form-urlencoded" {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid form body: %v", domain.ErrProviderExchange, err)
+		}
+		accessToken = values.Get("access_token")
+	} else {
+		var tokenResp struct {
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.Unmarshal(body, &tokenResp); err != nil {
+			return "", fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderExchange, err)
+		}
+		accessToken = tokenResp.AccessToken
+	}
+
+	if accessToken == "" {
+		return "", fmt.Errorf("%w: empty access token", domain.ErrProviderExchange)
+	}
+
+	return accessToken, nil
+}
+
+func (p *Provider) fetchUser(ctx context.Context, accessToken string) (*domain.UserInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.UserURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating user request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderUserFetch, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading response: %v", domain.ErrProviderUserFetch, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d: %s", domain.ErrProviderUserFetch, resp.StatusCode, body)
+	}
+
+	values, err := p.mapping.Apply(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderUserFetch, err)
+	}
+	if values[FieldID] == "" {
+		return nil, fmt.Errorf("%w: mapped user ID is empty", domain.ErrProviderUserFetch)
+	}
+
+	displayName := values[FieldDisplayName]
+	if displayName == "" {
+		displayName = values[FieldUsername]
+	}
+
+	return &domain.UserInfo{
+		ProviderName: p.cfg.Name,
+		ProviderID:   values[FieldID],
+		Username:     values[FieldUsername],
+		DisplayName:  displayName,
+		AvatarURL:    values[FieldAvatarURL],
+		Email:        values[FieldEmail],
+		Raw:          body,
+	}, nil
+}
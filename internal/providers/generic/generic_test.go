@@ -0,0 +1,145 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func setupTestProvider(t *testing.T, tokenAuth string, tokenHandler, userHandler http.HandlerFunc) *Provider {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/token", tokenHandler)
+	mux.HandleFunc("/api/me", userHandler)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	p, err := New(Config{
+		Name:         "gitea",
+		ClientID:     "test-client-id",
+		ClientSecret: "test-client-secret",
+		Scopes:       []string{"read:user"},
+		AuthURL:      server.URL + "/oauth/authorize",
+		TokenURL:     server.URL + "/oauth/token",
+		UserURL:      server.URL + "/api/me",
+		CallbackURL:  "https://auth.example.com/callback/gitea",
+		TokenAuth:    tokenAuth,
+		Mapping: map[string]string{
+			"id":           "$.id",
+			"username":     "$.login",
+			"display_name": "$.full_name",
+			"email":        "$.email",
+		},
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	return p
+}
+
+func jsonToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"access_token":"test-token","token_type":"bearer"}`))
+}
+
+func userHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.Write([]byte(`{"id": 42, "login": "ada", "full_name": "", "email": "ada@example.com"}`))
+}
+
+func TestAuthURL(t *testing.T) {
+	p := setupTestProvider(t, "", jsonToken, userHandler)
+
+	authURL, _ := p.AuthURL("test-state")
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	if u.Path != "/oauth/authorize" || q.Get("client_id") != "test-client-id" || q.Get("state") != "test-state" {
+		t.Errorf("unexpected auth URL: %s", authURL)
+	}
+	if q.Get("scope") != "read:user" || q.Get("redirect_uri") != "https://auth.example.com/callback/gitea" {
+		t.Errorf("unexpected auth URL: %s", authURL)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	var form url.Values
+	p := setupTestProvider(t, "", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		jsonToken(w, r)
+	}, userHandler)
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "test-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if form.Get("client_secret") != "test-client-secret" || form.Get("code") != "test-code" {
+		t.Errorf("unexpected token request: %v", form)
+	}
+	user := result.User
+	if user.ProviderName != "gitea" || user.ProviderID != "42" || user.Username != "ada" || user.Email != "ada@example.com" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	if user.DisplayName != "ada" {
+		t.Errorf("expected display name to fall back to username, got %q", user.DisplayName)
+	}
+}
+
+func TestExchange_BasicAuthAndFormResponse(t *testing.T) {
+	p := setupTestProvider(t, "basic", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "test-client-id" || secret != "test-client-secret" || r.FormValue("client_secret") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+		w.Write([]byte("access_token=test-token&token_type=bearer"))
+	}, userHandler)
+
+	if _, err := p.Exchange(context.Background(), map[string]string{"code": "test-code"}); err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+}
+
+func TestExchange_MissingID(t *testing.T) {
+	p := setupTestProvider(t, "", jsonToken, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"login": "ada"}`))
+	})
+
+	if _, err := p.Exchange(context.Background(), map[string]string{"code": "test-code"}); !errors.Is(err, domain.ErrProviderUserFetch) {
+		t.Errorf("expected ErrProviderUserFetch, got %v", err)
+	}
+}
+
+func TestExchange_TokenError(t *testing.T) {
+	p := setupTestProvider(t, "", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+	}, userHandler)
+
+	if _, err := p.Exchange(context.Background(), map[string]string{"code": "test-code"}); !errors.Is(err, domain.ErrProviderExchange) {
+		t.Errorf("expected ErrProviderExchange, got %v", err)
+	}
+}
+
+func TestExchange_MissingCode(t *testing.T) {
+	p := setupTestProvider(t, "", jsonToken, userHandler)
+
+	if _, err := p.Exchange(context.Background(), map[string]string{}); !errors.Is(err, domain.ErrMissingProviderParams) {
+		t.Errorf("expected ErrMissingProviderParams, got %v", err)
+	}
+}
+
+func TestNew_InvalidMapping(t *testing.T) {
+	_, err := New(Config{Name: "broken", Mapping: map[string]string{"username": "$.login"}})
+	if !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package generic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Fields that a mapping can fill. "id" is required.
+const (
+	FieldID          = "id"
+	FieldUsername    = "username"
+	FieldDisplayName = "display_name"
+	FieldAvatarURL   = "avatar_url"
+	FieldEmail       = "email"
+)
+
+var fields = []string{FieldID, FieldUsername, FieldDisplayName, FieldAvatarURL, FieldEmail}
+
+// Mapping turns a provider's user JSON into UserInfo fields. Each field is
+// filled from either a path into the document:
+//
+//	$.data.id
+//	$.emails[0].address
+//
+// or a template embedding paths in braces:
+//
+//	https://cdn.example.com/avatars/{$.id}/{$.avatar}.png
+//
+// A template whose paths don't all resolve yields an empty value, so an
+// avatar URL isn't built around a missing avatar.
+type Mapping map[string]expr
+
+// ParseMapping compiles field expressions keyed by field name.
+func ParseMapping(raw map[string]string) (Mapping, error) {
+	if raw[FieldID] == "" {
+		return nil, fmt.Errorf("mapping: %q is required", FieldID)
+	}
+	m := make(Mapping, len(raw))
+	for field, src := range raw {
+		if !slices.Contains(fields, field) {
+			return nil, fmt.Errorf("mapping: unknown field %q", field)
+		}
+		e, err := parseExpr(src)
+		if err != nil {
+			return nil, fmt.Errorf("mapping: %s: %w", field, err)
+		}
+		m[field] = e
+	}
+	return m, nil
+}
+
+// Apply decodes a JSON document and evaluates every field against it.
+func (m Mapping) Apply(data []byte) (map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // keep large numeric IDs exact
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string, len(m))
+	for field, e := range m {
+		out[field] = e.eval(doc)
+	}
+	return out, nil
+}
+
+// expr is a sequence of literal text and paths.
+type expr []part
+
+type part struct {
+	literal string
+	path    []step // nil for literal text
+}
+
+// step is one segment of a path: an object key or an array index.
+type step struct {
+	key   string
+	index int
+	isIdx bool
+}
+
+func parseExpr(src string) (expr, error) {
+	src = strings.TrimSpace(src)
+	if !strings.Contains(src, "{") {
+		path, err := parsePath(src)
+		if err != nil {
+			return nil, err
+		}
+		return expr{{path: path}}, nil
+	}
+
+	var e expr
+	for src != "" {
+		open := strings.IndexByte(src, '{')
+		if open < 0 {
+			e = append(e, part{literal: src})
+			break
+		}
+		if open > 0 {
+			e = append(e, part{literal: src[:open]})
+		}
+		end := strings.IndexByte(src[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed { in %q", src)
+		}
+		path, err := parsePath(src[open+1 : open+end])
+		if err != nil {
+			return nil, err
+		}
+		e = append(e, part{path: path})
+		src = src[open+end+1:]
+	}
+	return e, nil
+}
+
+// parsePath parses $.a.b[0].c into steps.
+func parsePath(src string) ([]step, error) {
+	if !strings.HasPrefix(src, "$") {
+		return nil, fmt.Errorf("path %q must start with $", src)
+	}
+	rest := src[1:]
+	steps := []step{}
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			n := strings.IndexAny(rest, ".[")
+			if n < 0 {
+				n = len(rest)
+			}
+			if n == 0 {
+				return nil, fmt.Errorf("empty key in path %q", src)
+			}
+			steps = append(steps, step{key: rest[:n]})
+			rest = rest[n:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed [ in path %q", src)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("bad index in path %q", src)
+			}
+			steps = append(steps, step{index: i, isIdx: true})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q in path %q", rest[0], src)
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("path %q selects the whole document", src)
+	}
+	return steps, nil
+}
+
+func (e expr) eval(doc any) string {
+	var b strings.Builder
+	for _, p := range e {
+		if p.path == nil {
+			b.WriteString(p.literal)
+			continue
+		}
+		v, ok := lookup(doc, p.path)
+		if !ok {
+			return ""
+		}
+		b.WriteString(v)
+	}
+	return b.String()
+}
+
+// lookup follows path and formats the scalar it ends on. Missing values,
+// nulls, objects, and arrays don't resolve.
+func lookup(v any, path []step) (string, bool) {
+	for _, s := range path {
+		if s.isIdx {
+			arr, ok := v.([]any)
+			if !ok || s.index >= len(arr) {
+				return "", false
+			}
+			v = arr[s.index]
+			continue
+		}
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if v, ok = obj[s.key]; !ok {
+			return "", false
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v, v != ""
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
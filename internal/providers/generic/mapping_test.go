@@ -0,0 +1,75 @@
+package generic
+
+import "testing"
+
+func TestMapping_Apply(t *testing.T) {
+	m, err := ParseMapping(map[string]string{
+		"id":         "$.data.id",
+		"username":   "$.data.login",
+		"email":      "$.data.emails[1].address",
+		"avatar_url": "https://cdn.example.com/{$.data.id}/{$.data.avatar}.png",
+	})
+	if err != nil {
+		t.Fatalf("ParseMapping error: %v", err)
+	}
+
+	got, err := m.Apply([]byte(`{"data": {
+		"id": 123456789012345678,
+		"login": "ada",
+		"avatar": "a1b2",
+		"emails": [{"address": "old@example.com"}, {"address": "ada@example.com"}]
+	}}`))
+	if err != nil {
+		t.Fatalf("Apply error: %v", err)
+	}
+	want := map[string]string{
+		"id":         "123456789012345678",
+		"username":   "ada",
+		"email":      "ada@example.com",
+		"avatar_url": "https://cdn.example.com/123456789012345678/a1b2.png",
+	}
+	for field, v := range want {
+		if got[field] != v {
+			t.Errorf("%s = %q, want %q", field, got[field], v)
+		}
+	}
+}
+
+func TestMapping_MissingValues(t *testing.T) {
+	m, err := ParseMapping(map[string]string{
+		"id":         "$.id",
+		"email":      "$.emails[3].address",
+		"avatar_url": "https://cdn.example.com/{$.avatar}.png",
+		"username":   "$.profile.name",
+	})
+	if err != nil {
+		t.Fatalf("ParseMapping error: %v", err)
+	}
+
+	got, err := m.Apply([]byte(`{"id": "1", "avatar": null, "emails": [], "profile": "not an object"}`))
+	if err != nil {
+		t.Fatalf("Apply error: %v", err)
+	}
+	if got["email"] != "" || got["avatar_url"] != "" || got["username"] != "" {
+		t.Errorf("expected unresolved fields to be empty, got %v", got)
+	}
+}
+
+func TestParseMapping_Invalid(t *testing.T) {
+	tests := map[string]map[string]string{
+		"no id":          {"username": "$.login"},
+		"unknown field":  {"id": "$.id", "phone": "$.phone"},
+		"missing $":      {"id": "data.id"},
+		"whole document": {"id": "$"},
+		"empty key":      {"id": "$..id"},
+		"bad index":      {"id": "$.ids[x]"},
+		"unclosed brace": {"id": "$.id", "avatar_url": "https://cdn/{$.avatar"},
+	}
+	for name, raw := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseMapping(raw); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package gitlab
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/outbound"
+)
+
+const (
+	providerName   = "gitlab"
+	defaultBaseURL = "https://gitlab.com"
+)
+
+// Config holds GitLab OAuth2 settings.
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	CallbackURL  string // The CentralAuth callback URL: {base_url}/callback/gitlab
+
+	// BaseURL is the GitLab instance, e.g. https://gitlab.example.com for a
+	// self-hosted one (gitlab.com if empty).
+	BaseURL string
+
+	// HTTPClient makes the calls to GitLab's API (http.DefaultClient if nil).
+	HTTPClient *http.Client
+}
+
+// Provider implements OAuth2 for GitLab.com and self-hosted GitLab.
+type Provider struct {
+	cfg        Config
+	httpClient *http.Client
+	scopes     atomic.Pointer[[]string]
+	baseURL    string
+}
+
+// New creates a GitLab provider.
+func New(cfg Config) *Provider {
+	baseURL := strings.TrimSuffix(cfg.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+	p := &Provider{
+		cfg:        cfg,
+		httpClient: cmp.Or(cfg.HTTPClient, http.DefaultClient),
+		baseURL:    baseURL,
+	}
+	p.SetScopes(cfg.Scopes)
+	return p
+}
+
+func (p *Provider) Name() string { return providerName }
+
+// SetScopes replaces the scopes requested from now on. Flows already at
+// the provider keep the scopes they asked for.
+func (p *Provider) SetScopes(scopes []string) {
+	p.scopes.Store(&scopes)
+}
+
+// Probe checks that the GitLab instance's token and user endpoints are reachable.
+func (p *Provider) Probe(ctx context.Context) error {
+	return outbound.Probe(ctx, p.httpClient, p.baseURL+"/oauth/token", p.baseURL+"/api/v4/user")
+}
+
+func (p *Provider) AuthURL(stateToken string) (string, error) {
+	params := url.Values{
+		"client_id":     {p.cfg.ClientID},
+		"redirect_uri":  {p.cfg.CallbackURL},
+		"response_type": {"code"},
+		"scope":         {strings.Join(*p.scopes.Load(), " ")},
+		"state":         {stateToken},
+	}
+	return p.baseURL + "/oauth/authorize?" + params.Encode(), nil
+}
+
+func (p *Provider) Exchange(ctx context.Context, params map[string]string) (*domain.AuthResult, error) {
+	code, ok := params["code"]
+	if !ok || code == "" {
+		return nil, domain.ErrMissingProviderParams
+	}
+
+	token, err := p.exchangeCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := p.fetchUser(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.AuthResult{User: *user}, nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+}
+
+func (p *Provider) exchangeCode(ctx context.Context, code string) (string, error) {
+	data := url.Values{
+		"client_id":     {p.cfg.ClientID},
+		"client_secret": {p.cfg.ClientSecret},
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.CallbackURL},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/oauth/token", strings.NewReader(data.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", domain.ErrProviderExchange, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%w: reading response: %v", domain.ErrProviderExchange, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: status %d: %s", domain.ErrProviderExchange, resp.StatusCode, body)
+	}
+
+	var tokenResp tokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderExchange, err)
+	}
+
+	if tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("%w: empty access token", domain.ErrProviderExchange)
+	}
+
+	return tokenResp.AccessToken, nil
+}
+
+type gitlabUser struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url"`
+	Email     string `json:"email"`
+}
+
+func (p *Provider) fetchUser(ctx context.Context, accessToken string) (*domain.UserInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/api/v4/user", nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating user request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderUserFetch, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading response: %v", domain.ErrProviderUserFetch, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d: %s", domain.ErrProviderUserFetch, resp.StatusCode, body)
+	}
+
+	var gu gitlabUser
+	if err := json.Unmarshal(body, &gu); err != nil {
+		return nil, fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderUserFetch, err)
+	}
+	if gu.ID == 0 {
+		return nil, fmt.Errorf("%w: response has no user ID", domain.ErrProviderUserFetch)
+	}
+
+	displayName := gu.Name
+	if displayName == "" {
+		displayName = gu.Username
+	}
+
+	return &domain.UserInfo{
+		ProviderName: providerName,
+		ProviderID:   strconv.FormatInt(gu.ID, 10),
+		Username:     gu.Username,
+		DisplayName:  displayName,
+		AvatarURL:    gu.AvatarURL,
+		Email:        gu.Email,
+		Raw:          body,
+	}, nil
+}
@@ -0,0 +1,147 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func setupTestProvider(t *testing.T, tokenHandler, userHandler http.HandlerFunc) *Provider {
+	t.Helper()
+	mux := http.NewServeMux()
+	if tokenHandler != nil {
+		mux.HandleFunc("/oauth/token", tokenHandler)
+	}
+	if userHandler != nil {
+		mux.HandleFunc("/api/v4/user", userHandler)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	p := New(Config{
+		ClientID:     "test-client-id",
+		ClientSecret: "test-client-secret",
+		Scopes:       []string{"read_user"},
+		CallbackURL:  "https://auth.example.com/callback/gitlab",
+		BaseURL:      server.URL + "/",
+	})
+	p.httpClient = server.Client()
+	return p
+}
+
+func okToken(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(tokenResponse{AccessToken: "test-access-token", TokenType: "Bearer"})
+}
+
+func TestAuthURL_DefaultsToGitLabCom(t *testing.T) {
+	p := New(Config{
+		ClientID:    "test-client-id",
+		Scopes:      []string{"read_user"},
+		CallbackURL: "https://auth.example.com/callback/gitlab",
+	})
+
+	authURL, err := p.AuthURL("test-state-token")
+	if err != nil {
+		t.Fatalf("AuthURL error: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	if u.Host != "gitlab.com" || u.Path != "/oauth/authorize" {
+		t.Errorf("unexpected auth endpoint: %s", authURL)
+	}
+	if got := u.Query().Get("scope"); got != "read_user" {
+		t.Errorf("expected scope 'read_user', got %q", got)
+	}
+	if got := u.Query().Get("state"); got != "test-state-token" {
+		t.Errorf("expected state 'test-state-token', got %q", got)
+	}
+}
+
+func TestAuthURL_SelfHosted(t *testing.T) {
+	p := New(Config{BaseURL: "https://git.example.com/"})
+
+	authURL, _ := p.AuthURL("s")
+	u, _ := url.Parse(authURL)
+	if u.Host != "git.example.com" || u.Path != "/oauth/authorize" {
+		t.Errorf("unexpected auth endpoint: %s", authURL)
+	}
+}
+
+func TestExchange_Success(t *testing.T) {
+	p := setupTestProvider(t, okToken, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-access-token" {
+			t.Error("expected Bearer token in Authorization header")
+		}
+		json.NewEncoder(w).Encode(gitlabUser{
+			ID:        4242,
+			Username:  "ada",
+			Name:      "Ada Lovelace",
+			AvatarURL: "https://git.example.com/uploads/avatar.png",
+			Email:     "ada@example.com",
+		})
+	})
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	user := result.User
+	if user.ProviderName != "gitlab" || user.ProviderID != "4242" || user.Username != "ada" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	if user.DisplayName != "Ada Lovelace" || user.Email != "ada@example.com" || user.AvatarURL == "" {
+		t.Errorf("unexpected profile: %+v", user)
+	}
+}
+
+func TestExchange_FallbackDisplayName(t *testing.T) {
+	p := setupTestProvider(t, okToken, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(gitlabUser{ID: 1, Username: "ada"})
+	})
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if result.User.DisplayName != "ada" {
+		t.Errorf("expected display name to fall back to username, got %q", result.User.DisplayName)
+	}
+}
+
+func TestExchange_TokenFailure(t *testing.T) {
+	p := setupTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+	}, nil)
+
+	_, err := p.Exchange(context.Background(), map[string]string{"code": "bad-code"})
+	if !errors.Is(err, domain.ErrProviderExchange) {
+		t.Errorf("expected ErrProviderExchange, got %v", err)
+	}
+}
+
+func TestExchange_UserFetchFailure(t *testing.T) {
+	p := setupTestProvider(t, okToken, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message":"401 Unauthorized"}`))
+	})
+
+	_, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if !errors.Is(err, domain.ErrProviderUserFetch) {
+		t.Errorf("expected ErrProviderUserFetch, got %v", err)
+	}
+}
+
+func TestExchange_MissingCode(t *testing.T) {
+	p := New(Config{})
+
+	_, err := p.Exchange(context.Background(), map[string]string{})
+	if !errors.Is(err, domain.ErrMissingProviderParams) {
+		t.Errorf("expected ErrMissingProviderParams, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package guest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/state"
+)
+
+const (
+	providerName    = "guest"
+	defaultLifetime = 24 * time.Hour
+)
+
+// Config holds guest provider settings.
+type Config struct {
+	CallbackURL string        // The CentralAuth callback URL: {base_url}/callback/guest
+	Lifetime    time.Duration // how long a guest identity lasts (24 hours if zero)
+}
+
+// Provider issues anonymous, expiring identities so that sites can let
+// people try them out before signing in with a real account.
+//
+// There is nothing to sign in to: AuthURL points straight back at the
+// callback, and every exchange mints a new random identity. Its expiry is
+// reported in the guest provider data; honouring it is up to the client.
+type Provider struct {
+	cfg         Config
+	states      *state.Service
+	lifetimeFor func(clientID string) time.Duration
+	now         func() time.Time
+}
+
+// New creates a guest provider.
+func New(cfg Config, states *state.Service) *Provider {
+	if cfg.Lifetime <= 0 {
+		cfg.Lifetime = defaultLifetime
+	}
+	return &Provider{cfg: cfg, states: states, now: time.Now}
+}
+
+// SetLifetimes lets clients override the configured lifetime. fn returns
+// zero for clients that use the default.
+func (p *Provider) SetLifetimes(fn func(clientID string) time.Duration) {
+	p.lifetimeFor = fn
+}
+
+// SetNow overrides the time function (for testing).
+func (p *Provider) SetNow(fn func() time.Time) {
+	p.now = fn
+}
+
+func (p *Provider) Name() string { return providerName }
+
+func (p *Provider) AuthURL(stateToken string) (string, error) {
+	return p.cfg.CallbackURL + "?" + url.Values{"state": {stateToken}}.Encode(), nil
+}
+
+func (p *Provider) Exchange(ctx context.Context, params map[string]string) (*domain.AuthResult, error) {
+	stateToken := params["state"]
+	if stateToken == "" {
+		return nil, domain.ErrMissingProviderParams
+	}
+	payload, err := p.states.Validate(stateToken)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("%w: generating guest ID: %v", domain.ErrProviderExchange, err)
+	}
+	id := hex.EncodeToString(b)
+
+	return &domain.AuthResult{User: domain.UserInfo{
+		ProviderName: providerName,
+		ProviderID:   id,
+		Username:     "guest-" + id[:8],
+		DisplayName:  "Guest " + id[:8],
+		ProviderData: domain.NewGuestData(domain.GuestExtras{
+			ExpiresAt: p.now().Add(p.lifetime(payload.ClientID)).UTC().Truncate(time.Second),
+		}),
+	}}, nil
+}
+
+func (p *Provider) lifetime(clientID string) time.Duration {
+	if p.lifetimeFor != nil {
+		if d := p.lifetimeFor(clientID); d > 0 {
+			return d
+		}
+	}
+	return p.cfg.Lifetime
+}
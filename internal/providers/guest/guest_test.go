@@ -0,0 +1,102 @@
+package guest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/state"
+)
+
+func setupProvider(t *testing.T) (*Provider, *state.Service) {
+	t.Helper()
+	states := state.NewService([]byte("test-key-1234567890abcdef"))
+	p := New(Config{CallbackURL: "https://auth.example.com/callback/guest", Lifetime: time.Hour}, states)
+	return p, states
+}
+
+func newState(t *testing.T, states *state.Service, clientID string) string {
+	t.Helper()
+	tok, err := states.Generate(domain.StatePayload{ClientID: clientID, Provider: "guest"})
+	if err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	return tok
+}
+
+func TestAuthURL(t *testing.T) {
+	p, _ := setupProvider(t)
+	u, _ := p.AuthURL("abc")
+	if u != "https://auth.example.com/callback/guest?state=abc" {
+		t.Errorf("unexpected AuthURL: %s", u)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	p, states := setupProvider(t)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	p.SetNow(func() time.Time { return now })
+
+	result, err := p.Exchange(context.Background(), map[string]string{"state": newState(t, states, "website")})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	user := result.User
+	if user.ProviderName != "guest" || len(user.ProviderID) != 32 {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	if user.Username != "guest-"+user.ProviderID[:8] {
+		t.Errorf("unexpected username %q", user.Username)
+	}
+	if user.ProviderData == nil || user.ProviderData.Kind != domain.KindGuest {
+		t.Fatalf("expected guest provider data, got %+v", user.ProviderData)
+	}
+	if want := now.Add(time.Hour); !user.ProviderData.Guest.ExpiresAt.Equal(want) {
+		t.Errorf("ExpiresAt = %v, want %v", user.ProviderData.Guest.ExpiresAt, want)
+	}
+}
+
+func TestExchange_NewIdentityEachTime(t *testing.T) {
+	p, states := setupProvider(t)
+	params := map[string]string{"state": newState(t, states, "website")}
+
+	a, _ := p.Exchange(context.Background(), params)
+	b, _ := p.Exchange(context.Background(), params)
+	if a.User.ProviderID == b.User.ProviderID {
+		t.Error("expected a fresh guest ID per exchange")
+	}
+}
+
+func TestExchange_ClientLifetime(t *testing.T) {
+	p, states := setupProvider(t)
+	now := time.Now()
+	p.SetNow(func() time.Time { return now })
+	p.SetLifetimes(func(clientID string) time.Duration {
+		if clientID == "game" {
+			return 15 * time.Minute
+		}
+		return 0
+	})
+
+	for clientID, want := range map[string]time.Duration{"game": 15 * time.Minute, "website": time.Hour} {
+		result, err := p.Exchange(context.Background(), map[string]string{"state": newState(t, states, clientID)})
+		if err != nil {
+			t.Fatalf("Exchange error: %v", err)
+		}
+		if got := result.User.ProviderData.Guest.ExpiresAt.Sub(now.UTC().Truncate(time.Second)); got != want {
+			t.Errorf("%s: lifetime %v, want %v", clientID, got, want)
+		}
+	}
+}
+
+func TestExchange_InvalidState(t *testing.T) {
+	p, _ := setupProvider(t)
+	if _, err := p.Exchange(context.Background(), map[string]string{}); !errors.Is(err, domain.ErrMissingProviderParams) {
+		t.Errorf("expected ErrMissingProviderParams, got %v", err)
+	}
+	if _, err := p.Exchange(context.Background(), map[string]string{"state": "forged"}); err == nil {
+		t.Error("expected an invalid state to be rejected")
+	}
+}
@@ -0,0 +1,190 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The small subset of BER (X.690) that LDAPv3 needs. encoding/asn1 can't be
+// used because it insists on DER, while Active Directory, for one, always
+// sends long-form lengths.
+
+// BER tag classes and the constructed bit.
+const (
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// Universal tags.
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x10 | constructed
+	tagSet         = 0x11 | constructed
+)
+
+// maxMessageSize bounds a single LDAP message read from the server.
+const maxMessageSize = 1 << 20
+
+var errMalformed = errors.New("malformed BER")
+
+// element is a decoded BER TLV. Children are parsed lazily by children().
+type element struct {
+	tag     byte
+	content []byte
+}
+
+// children parses a constructed element's content into its elements.
+func (e element) children() ([]element, error) {
+	var out []element
+	rest := e.content
+	for len(rest) > 0 {
+		el, n, err := parseElement(rest)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, el)
+		rest = rest[n:]
+	}
+	return out, nil
+}
+
+func (e element) int() (int64, error) {
+	if len(e.content) == 0 || len(e.content) > 8 {
+		return 0, errMalformed
+	}
+	v := int64(int8(e.content[0])) // sign-extend
+	for _, b := range e.content[1:] {
+		v = v<<8 | int64(b)
+	}
+	return v, nil
+}
+
+// parseElement decodes one element from the front of b and returns it with
+// the number of bytes it used.
+func parseElement(b []byte) (element, int, error) {
+	if len(b) < 2 {
+		return element{}, 0, errMalformed
+	}
+	tag := b[0]
+	if tag&0x1f == 0x1f {
+		return element{}, 0, fmt.Errorf("%w: multi-byte tags are not supported", errMalformed)
+	}
+	length, n, err := parseLength(b[1:])
+	if err != nil {
+		return element{}, 0, err
+	}
+	start := 1 + n
+	if length > len(b)-start {
+		return element{}, 0, errMalformed
+	}
+	return element{tag: tag, content: b[start : start+length]}, start + length, nil
+}
+
+func parseLength(b []byte) (int, int, error) {
+	if len(b) == 0 {
+		return 0, 0, errMalformed
+	}
+	if b[0] < 0x80 {
+		return int(b[0]), 1, nil
+	}
+	n := int(b[0] & 0x7f)
+	if n == 0 || n > 4 || len(b) < 1+n {
+		// n == 0 is the indefinite form, which LDAP forbids
+		return 0, 0, errMalformed
+	}
+	length := 0
+	for _, c := range b[1 : 1+n] {
+		length = length<<8 | int(c)
+	}
+	if length > maxMessageSize {
+		return 0, 0, fmt.Errorf("%w: element of %d bytes is too large", errMalformed, length)
+	}
+	return length, 1 + n, nil
+}
+
+// readElement reads one complete top-level element from r.
+func readElement(r *bufio.Reader) (element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	header := []byte{first}
+	if first >= 0x80 {
+		n := int(first & 0x7f)
+		if n == 0 || n > 4 {
+			return element{}, errMalformed
+		}
+		header = append(header, make([]byte, n)...)
+		if _, err := io.ReadFull(r, header[1:]); err != nil {
+			return element{}, err
+		}
+	}
+	length, _, err := parseLength(header)
+	if err != nil {
+		return element{}, err
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return element{}, err
+	}
+	return element{tag: tag, content: content}, nil
+}
+
+// tlv encodes a single element.
+func tlv(tag byte, content []byte) []byte {
+	out := []byte{tag}
+	switch n := len(content); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xff:
+		out = append(out, 0x81, byte(n))
+	case n <= 0xffff:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	default:
+		out = append(out, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(out, content...)
+}
+
+// seq encodes a constructed element from already-encoded children.
+func seq(tag byte, children ...[]byte) []byte {
+	var content []byte
+	for _, c := range children {
+		content = append(content, c...)
+	}
+	return tlv(tag, content)
+}
+
+func berInt(tag byte, v int64) []byte {
+	// Minimal two's-complement encoding
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		if (v >= -0x80 && v < 0x80) || len(b) == 8 {
+			break
+		}
+		v >>= 8
+	}
+	return tlv(tag, b)
+}
+
+func berBool(v bool) []byte {
+	if v {
+		return tlv(tagBoolean, []byte{0xff})
+	}
+	return tlv(tagBoolean, []byte{0x00})
+}
+
+func octets(tag byte, s string) []byte {
+	return tlv(tag, []byte(s))
+}
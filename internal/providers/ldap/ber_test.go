@@ -0,0 +1,110 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBerInt(t *testing.T) {
+	for _, v := range []int64{0, 1, 127, 128, 255, 256, 300, -1, -128, -129, 1 << 40} {
+		el, _, err := parseElement(berInt(tagInteger, v))
+		if err != nil {
+			t.Fatalf("%d: parse error: %v", v, err)
+		}
+		got, err := el.int()
+		if err != nil || got != v {
+			t.Errorf("round trip of %d gave %d (%v)", v, got, err)
+		}
+	}
+	if enc := berInt(tagInteger, 128); !bytes.Equal(enc, []byte{0x02, 0x02, 0x00, 0x80}) {
+		t.Errorf("expected minimal encoding of 128, got % x", enc)
+	}
+}
+
+func TestTLVLengths(t *testing.T) {
+	for _, n := range []int{0, 127, 128, 255, 256, 70000} {
+		enc := tlv(tagOctetString, bytes.Repeat([]byte{'x'}, n))
+		el, used, err := parseElement(enc)
+		if err != nil || used != len(enc) || len(el.content) != n {
+			t.Errorf("length %d: used %d of %d, content %d, err %v", n, used, len(enc), len(el.content), err)
+		}
+	}
+}
+
+func TestParseElement_LongFormLength(t *testing.T) {
+	// Active Directory sends four-byte lengths even for short elements
+	b := []byte{0x04, 0x84, 0x00, 0x00, 0x00, 0x02, 'h', 'i'}
+	el, used, err := parseElement(b)
+	if err != nil || used != len(b) || string(el.content) != "hi" {
+		t.Errorf("unexpected result %q, %d, %v", el.content, used, err)
+	}
+
+	msg, err := readElement(bufio.NewReader(bytes.NewReader(b)))
+	if err != nil || string(msg.content) != "hi" {
+		t.Errorf("readElement gave %q, %v", msg.content, err)
+	}
+}
+
+func TestParseElement_Malformed(t *testing.T) {
+	for name, b := range map[string][]byte{
+		"truncated":  {0x04, 0x05, 'a'},
+		"indefinite": {0x30, 0x80, 0x00, 0x00},
+		"too large":  {0x04, 0x84, 0x7f, 0xff, 0xff, 0xff},
+		"short":      {0x04},
+	} {
+		if _, _, err := parseElement(b); !errors.Is(err, errMalformed) {
+			t.Errorf("%s: expected errMalformed, got %v", name, err)
+		}
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	resp := seq(opSearchResultEntry,
+		octets(tagOctetString, "uid=ada,dc=example,dc=com"),
+		seq(tagSequence,
+			seq(tagSequence, octets(tagOctetString, "displayName"), seq(tagSet, octets(tagOctetString, "Ada Lovelace"))),
+			seq(tagSequence, octets(tagOctetString, "mail"), seq(tagSet, octets(tagOctetString, "a@x"), octets(tagOctetString, "b@x"))),
+		),
+	)
+	el, _, err := parseElement(resp)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	e, err := parseEntry(el)
+	if err != nil {
+		t.Fatalf("parseEntry error: %v", err)
+	}
+	if e.DN != "uid=ada,dc=example,dc=com" {
+		t.Errorf("unexpected DN %q", e.DN)
+	}
+	if got := string(e.first("displayname")); got != "Ada Lovelace" {
+		t.Errorf("expected case-insensitive lookup, got %q", got)
+	}
+	if len(e.Attrs["mail"]) != 2 || string(e.first("mail")) != "a@x" {
+		t.Errorf("unexpected mail values %q", e.Attrs["mail"])
+	}
+	if e.first("cn") != nil {
+		t.Error("expected nil for a missing attribute")
+	}
+}
+
+func TestCheckResult(t *testing.T) {
+	result := func(code int64, msg string) element {
+		el, _, _ := parseElement(seq(opBindResponse, berInt(tagEnumerated, code), octets(tagOctetString, ""), octets(tagOctetString, msg)))
+		return el
+	}
+	if err := checkResult("bind", result(resultSuccess, "")); err != nil {
+		t.Errorf("expected success, got %v", err)
+	}
+	if err := checkResult("bind", result(resultInvalidCreds, "")); !errors.Is(err, errInvalidCredentials) {
+		t.Errorf("expected errInvalidCredentials, got %v", err)
+	}
+	err := checkResult("bind", result(52, "unavailable"))
+	var re *resultError
+	if !errors.As(err, &re) || re.code != 52 || !strings.Contains(err.Error(), "unavailable") {
+		t.Errorf("expected a resultError, got %v", err)
+	}
+}
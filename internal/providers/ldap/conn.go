@@ -0,0 +1,348 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LDAP protocol operations (application class tags).
+const (
+	opBindRequest       = classApplication | constructed | 0
+	opBindResponse      = classApplication | constructed | 1
+	opUnbindRequest     = classApplication | 2
+	opSearchRequest     = classApplication | constructed | 3
+	opSearchResultEntry = classApplication | constructed | 4
+	opSearchResultDone  = classApplication | constructed | 5
+	opSearchResultRef   = classApplication | constructed | 19
+	opExtendedRequest   = classApplication | constructed | 23
+	opExtendedResponse  = classApplication | constructed | 24
+)
+
+// Context-specific tags used inside operations.
+const (
+	authSimple          = classContext | 0
+	extendedRequestName = classContext | 0
+	filterEqualityMatch = classContext | constructed | 3
+	filterPresent       = classContext | 7
+)
+
+// Result codes.
+const (
+	resultSuccess           = 0
+	resultSizeLimitExceeded = 4
+	resultInvalidCreds      = 49
+)
+
+const (
+	protocolVersion   = 3
+	oidStartTLS       = "1.3.6.1.4.1.1466.20037"
+	scopeBaseObject   = 0
+	scopeWholeSubtree = 2
+	derefNever        = 0
+
+	defaultPort      = "389"
+	defaultTLSPort   = "636"
+	defaultOpTimeout = 10 * time.Second
+
+	searchSizeLimit     = 2 // one match is all a login needs; two proves ambiguity
+	searchTimeLimitSecs = 10
+	maxSearchEntries    = 16
+)
+
+// errInvalidCredentials is a bind rejected with resultCode 49.
+var errInvalidCredentials = errors.New("ldap: invalid credentials")
+
+// resultError is any other non-success LDAP result.
+type resultError struct {
+	op      string
+	code    int64
+	message string
+}
+
+func (e *resultError) Error() string {
+	return fmt.Sprintf("ldap: %s failed: result code %d: %s", e.op, e.code, e.message)
+}
+
+// entry is one search result.
+type entry struct {
+	DN    string
+	Attrs map[string][][]byte // keyed by lower-cased attribute name
+}
+
+// first returns the first value of attr, or nil if it has none.
+func (e *entry) first(attr string) []byte {
+	if vals := e.Attrs[strings.ToLower(attr)]; len(vals) > 0 {
+		return vals[0]
+	}
+	return nil
+}
+
+// conn is a synchronous LDAPv3 connection: one operation in flight at a time.
+type conn struct {
+	nc     net.Conn
+	r      *bufio.Reader
+	nextID int64
+}
+
+// dial connects to an ldap:// or ldaps:// URL, upgrading ldap:// with
+// StartTLS when startTLS is set.
+func dial(ctx context.Context, rawURL string, startTLS bool, tlsCfg *tls.Config) (*conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: parsing URL: %w", err)
+	}
+	host, port := u.Hostname(), u.Port()
+
+	var d net.Dialer
+	var nc net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if port == "" {
+			port = defaultPort
+		}
+		nc, err = d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	case "ldaps":
+		if port == "" {
+			port = defaultTLSPort
+		}
+		td := tls.Dialer{NetDialer: &d, Config: withServerName(tlsCfg, host)}
+		nc, err = td.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	default:
+		return nil, fmt.Errorf("ldap: unsupported URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap: connecting to %s: %w", host, err)
+	}
+
+	c := &conn{nc: nc, r: bufio.NewReader(nc)}
+	if deadline, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(deadline)
+	} else {
+		nc.SetDeadline(time.Now().Add(defaultOpTimeout))
+	}
+
+	if startTLS && u.Scheme == "ldap" {
+		if err := c.startTLS(withServerName(tlsCfg, host)); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func withServerName(cfg *tls.Config, host string) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	return cfg
+}
+
+// close politely unbinds and closes the connection.
+func (c *conn) close() {
+	c.send(tlv(opUnbindRequest, nil))
+	c.nc.Close()
+}
+
+func (c *conn) startTLS(cfg *tls.Config) error {
+	msgID, err := c.send(seq(opExtendedRequest, octets(extendedRequestName, oidStartTLS)))
+	if err != nil {
+		return err
+	}
+	resp, err := c.receive(msgID)
+	if err != nil {
+		return err
+	}
+	if resp.tag != opExtendedResponse {
+		return fmt.Errorf("ldap: unexpected StartTLS response tag %#x", resp.tag)
+	}
+	if err := checkResult("StartTLS", resp); err != nil {
+		return err
+	}
+
+	tc := tls.Client(c.nc, cfg)
+	if err := tc.Handshake(); err != nil {
+		return fmt.Errorf("ldap: StartTLS handshake: %w", err)
+	}
+	c.nc = tc
+	c.r = bufio.NewReader(tc)
+	return nil
+}
+
+// bind performs a simple bind. An empty password is refused here because
+// LDAP treats it as an unauthenticated bind, which most servers accept.
+func (c *conn) bind(dn, password string) error {
+	if dn != "" && password == "" {
+		return errInvalidCredentials
+	}
+	msgID, err := c.send(seq(opBindRequest,
+		berInt(tagInteger, protocolVersion),
+		octets(tagOctetString, dn),
+		octets(authSimple, password),
+	))
+	if err != nil {
+		return err
+	}
+	resp, err := c.receive(msgID)
+	if err != nil {
+		return err
+	}
+	if resp.tag != opBindResponse {
+		return fmt.Errorf("ldap: unexpected bind response tag %#x", resp.tag)
+	}
+	return checkResult("bind", resp)
+}
+
+// search runs a search and collects its entries, ignoring referrals.
+// filter must already be BER-encoded.
+func (c *conn) search(baseDN string, scope int64, filter []byte, attrs []string) ([]entry, error) {
+	attrList := make([][]byte, len(attrs))
+	for i, a := range attrs {
+		attrList[i] = octets(tagOctetString, a)
+	}
+	msgID, err := c.send(seq(opSearchRequest,
+		octets(tagOctetString, baseDN),
+		berInt(tagEnumerated, scope),
+		berInt(tagEnumerated, derefNever),
+		berInt(tagInteger, searchSizeLimit),
+		berInt(tagInteger, searchTimeLimitSecs),
+		berBool(false),
+		filter,
+		seq(tagSequence, attrList...),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []entry
+	for {
+		resp, err := c.receive(msgID)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.tag {
+		case opSearchResultEntry:
+			e, err := parseEntry(resp)
+			if err != nil {
+				return nil, err
+			}
+			if len(entries) == maxSearchEntries {
+				return nil, errors.New("ldap: server ignored the search size limit")
+			}
+			entries = append(entries, *e)
+		case opSearchResultRef:
+			continue
+		case opSearchResultDone:
+			if err := checkResult("search", resp); err != nil {
+				// sizeLimitExceeded still tells us the match is ambiguous
+				var re *resultError
+				if errors.As(err, &re) && re.code == resultSizeLimitExceeded {
+					return entries, nil
+				}
+				return nil, err
+			}
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("ldap: unexpected search response tag %#x", resp.tag)
+		}
+	}
+}
+
+func equalityFilter(attr, value string) []byte {
+	return seq(filterEqualityMatch, octets(tagOctetString, attr), octets(tagOctetString, value))
+}
+
+func presentFilter(attr string) []byte {
+	return octets(filterPresent, attr)
+}
+
+func (c *conn) send(op []byte) (int64, error) {
+	c.nextID++
+	msg := seq(tagSequence, berInt(tagInteger, c.nextID), op)
+	if _, err := c.nc.Write(msg); err != nil {
+		return 0, fmt.Errorf("ldap: writing request: %w", err)
+	}
+	return c.nextID, nil
+}
+
+// receive reads the next message and returns its protocol operation.
+func (c *conn) receive(msgID int64) (element, error) {
+	msg, err := readElement(c.r)
+	if err != nil {
+		return element{}, fmt.Errorf("ldap: reading response: %w", err)
+	}
+	parts, err := msg.children()
+	if err != nil || len(parts) < 2 || parts[0].tag != tagInteger {
+		return element{}, fmt.Errorf("ldap: %w: bad message envelope", errMalformed)
+	}
+	id, err := parts[0].int()
+	if err != nil {
+		return element{}, err
+	}
+	if id != msgID {
+		// Only unsolicited notifications (ID 0) may arrive out of turn, and
+		// the only one defined means the server is closing the connection
+		return element{}, fmt.Errorf("ldap: unexpected message ID %d", id)
+	}
+	return parts[1], nil
+}
+
+// checkResult interprets the LDAPResult at the start of a response.
+func checkResult(op string, resp element) error {
+	parts, err := resp.children()
+	if err != nil || len(parts) < 3 {
+		return fmt.Errorf("ldap: %w: bad %s result", errMalformed, op)
+	}
+	code, err := parts[0].int()
+	if err != nil {
+		return err
+	}
+	switch code {
+	case resultSuccess:
+		return nil
+	case resultInvalidCreds:
+		return errInvalidCredentials
+	default:
+		return &resultError{op: op, code: code, message: string(parts[2].content)}
+	}
+}
+
+func parseEntry(resp element) (*entry, error) {
+	parts, err := resp.children()
+	if err != nil || len(parts) != 2 {
+		return nil, fmt.Errorf("ldap: %w: bad search entry", errMalformed)
+	}
+	e := &entry{DN: string(parts[0].content), Attrs: make(map[string][][]byte)}
+	attrs, err := parts[1].children()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range attrs {
+		kv, err := a.children()
+		if err != nil || len(kv) != 2 {
+			return nil, fmt.Errorf("ldap: %w: bad attribute", errMalformed)
+		}
+		vals, err := kv[1].children()
+		if err != nil {
+			return nil, err
+		}
+		// Attribute names are case-insensitive, and servers may return
+		// the schema's spelling rather than the one requested
+		name := strings.ToLower(string(kv[0].content))
+		for _, v := range vals {
+			e.Attrs[name] = append(e.Attrs[name], v.content)
+		}
+	}
+	return e, nil
+}
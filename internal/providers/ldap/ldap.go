@@ -0,0 +1,287 @@
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BlackMission/centralauth/internal/captcha"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/hosted"
+	"github.com/BlackMission/centralauth/internal/pages"
+	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/ticket"
+)
+
+const (
+	providerName       = "ldap"
+	defaultUserAttr    = "uid"
+	defaultIDAttr      = "entryUUID"
+	maxUsernameLength  = 256
+	directoryOpTimeout = 10 * time.Second
+)
+
+// Config holds LDAP provider settings.
+type Config struct {
+	BaseURL     string // public URL of this service; pages are served under {base_url}/ldap/
+	CallbackURL string // The CentralAuth callback URL: {base_url}/callback/ldap
+
+	URL      string // ldap://host[:port] or ldaps://host[:port]
+	StartTLS bool   // upgrade an ldap:// connection before binding
+	CAFile   string // PEM bundle to trust instead of the system roots
+
+	// BindDN and BindPassword are the service account used to find users.
+	// Both empty means an anonymous search.
+	BindDN       string
+	BindPassword string
+
+	BaseDN   string // subtree searched for users
+	UserAttr string // attribute matched against the username, e.g. sAMAccountName for AD
+	IDAttr   string // stable, unique attribute used as provider_id, e.g. objectGUID for AD
+}
+
+// Provider authenticates directory users with a username and password.
+//
+// AuthURL sends the browser to a hosted sign-in page. The page finds the
+// user's entry with the service account and binds as that entry with the
+// submitted password. Success redirects to the callback with a short-lived
+// ticket for the entry's DN, bound to the flow's state token, and Exchange
+// reads the entry again to build the UserInfo.
+type Provider struct {
+	cfg     Config
+	tlsCfg  *tls.Config
+	states  *state.Service
+	tickets *ticket.Signer
+
+	captcha hosted.Captcha
+}
+
+// New creates an LDAP provider. states validates the state token carried by
+// the hosted page so that it can't be used outside an auth flow.
+func New(cfg Config, states *state.Service, tickets *ticket.Signer) (*Provider, error) {
+	if cfg.UserAttr == "" {
+		cfg.UserAttr = defaultUserAttr
+	}
+	if cfg.IDAttr == "" {
+		cfg.IDAttr = defaultIDAttr
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return nil, fmt.Errorf("%w: LDAP URL must be ldap://host or ldaps://host", domain.ErrInvalidConfig)
+	}
+
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading LDAP CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: no certificates in %s", domain.ErrInvalidConfig, cfg.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	return &Provider{cfg: cfg, tlsCfg: tlsCfg, states: states, tickets: tickets}, nil
+}
+
+// SetCaptcha puts a CAPTCHA on the sign-in form of flows whose client
+// requires one.
+func (p *Provider) SetCaptcha(v *captcha.Verifier, required func(clientID string) bool) {
+	p.captcha.Set(v, required)
+}
+
+func (p *Provider) Name() string { return providerName }
+
+func (p *Provider) AuthURL(stateToken string) (string, error) {
+	return p.cfg.BaseURL + "/ldap/login?" + url.Values{"state": {stateToken}}.Encode(), nil
+}
+
+func (p *Provider) Exchange(ctx context.Context, params map[string]string) (*domain.AuthResult, error) {
+	tkt, stateToken := params["ticket"], params["state"]
+	if tkt == "" || stateToken == "" {
+		return nil, domain.ErrMissingProviderParams
+	}
+
+	dn, err := p.tickets.Verify(tkt, hosted.Audience(stateToken))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderExchange, err)
+	}
+
+	user, err := p.lookup(ctx, dn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderUserFetch, err)
+	}
+	return &domain.AuthResult{User: *user}, nil
+}
+
+// RegisterRoutes mounts the hosted sign-in page.
+func (p *Provider) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /ldap/login", p.loginPage)
+	mux.HandleFunc("POST /ldap/login", p.login)
+}
+
+func (p *Provider) loginPage(w http.ResponseWriter, r *http.Request) {
+	stateToken := r.URL.Query().Get("state")
+	flow, ok := hosted.ValidState(w, p.states, providerName, stateToken)
+	if !ok {
+		return
+	}
+	p.renderLogin(w, http.StatusOK, flow, stateToken, "", "")
+}
+
+func (p *Provider) login(w http.ResponseWriter, r *http.Request) {
+	stateToken := r.PostFormValue("state")
+	flow, ok := hosted.ValidState(w, p.states, providerName, stateToken)
+	if !ok {
+		return
+	}
+	username := strings.TrimSpace(r.PostFormValue("username"))
+	password := r.PostFormValue("password")
+
+	if err := p.captcha.Verify(r, flow); err != nil {
+		p.renderLogin(w, http.StatusBadRequest, flow, stateToken, username, "Please complete the CAPTCHA.")
+		return
+	}
+	if username == "" || password == "" || len(username) > maxUsernameLength {
+		p.renderLogin(w, http.StatusBadRequest, flow, stateToken, username, "Enter your username and password.")
+		return
+	}
+
+	dn, err := p.authenticate(r.Context(), username, password)
+	if errors.Is(err, domain.ErrInvalidCredentials) {
+		p.renderLogin(w, http.StatusUnauthorized, flow, stateToken, username, "Incorrect username or password.")
+		return
+	}
+	if err != nil {
+		slog.InfoContext(r.Context(), "ldap: login failed", "flow_id", flow.FlowID, "username", username, "error", err)
+		p.renderLogin(w, http.StatusBadGateway, flow, stateToken, username, "The directory is unavailable. Please try again later.")
+		return
+	}
+
+	tkt, err := p.tickets.Sign(dn, hosted.Audience(stateToken))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "ldap: signing ticket failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	q := url.Values{"state": {stateToken}, "ticket": {tkt}}
+	http.Redirect(w, r, p.cfg.CallbackURL+"?"+q.Encode(), http.StatusSeeOther)
+}
+
+// authenticate finds the user's entry and binds as it, returning its DN.
+// Unknown users and wrong passwords both give ErrInvalidCredentials.
+func (p *Provider) authenticate(ctx context.Context, username, password string) (string, error) {
+	c, err := p.connect(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer c.close()
+
+	entries, err := c.search(p.cfg.BaseDN, scopeWholeSubtree, equalityFilter(p.cfg.UserAttr, username), []string{"1.1"})
+	if err != nil {
+		return "", err
+	}
+	switch len(entries) {
+	case 0:
+		return "", domain.ErrInvalidCredentials
+	case 1:
+	default:
+		return "", fmt.Errorf("%d entries match %s=%s", len(entries), p.cfg.UserAttr, username)
+	}
+
+	if err := c.bind(entries[0].DN, password); err != nil {
+		if errors.Is(err, errInvalidCredentials) {
+			return "", domain.ErrInvalidCredentials
+		}
+		return "", err
+	}
+	return entries[0].DN, nil
+}
+
+// lookup reads the entry at dn with the service account.
+func (p *Provider) lookup(ctx context.Context, dn string) (*domain.UserInfo, error) {
+	c, err := p.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	attrs := []string{p.cfg.IDAttr, p.cfg.UserAttr, "displayName", "cn", "mail"}
+	entries, err := c.search(dn, scopeBaseObject, presentFilter("objectClass"), attrs)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("entry %s not found", dn)
+	}
+	e := entries[0]
+
+	id := attrString(e.first(p.cfg.IDAttr))
+	if id == "" {
+		return nil, fmt.Errorf("entry %s has no %s attribute", dn, p.cfg.IDAttr)
+	}
+	username := attrString(e.first(p.cfg.UserAttr))
+	displayName := attrString(e.first("displayName"))
+	if displayName == "" {
+		displayName = attrString(e.first("cn"))
+	}
+	if displayName == "" {
+		displayName = username
+	}
+
+	return &domain.UserInfo{
+		ProviderName: providerName,
+		ProviderID:   id,
+		Username:     username,
+		DisplayName:  displayName,
+		Email:        attrString(e.first("mail")),
+	}, nil
+}
+
+// connect opens a connection bound as the service account.
+func (p *Provider) connect(ctx context.Context) (*conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, directoryOpTimeout)
+	defer cancel()
+
+	c, err := dial(ctx, p.cfg.URL, p.cfg.StartTLS, p.tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+	if p.cfg.BindDN != "" {
+		if err := c.bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+			c.close()
+			return nil, fmt.Errorf("binding as service account: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// attrString renders an attribute value. Binary values such as AD's
+// objectGUID are hex-encoded.
+func attrString(v []byte) string {
+	if utf8.Valid(v) {
+		return string(v)
+	}
+	return hex.EncodeToString(v)
+}
+
+func (p *Provider) renderLogin(w http.ResponseWriter, status int, flow *domain.StatePayload, stateToken, username, msg string) {
+	pages.Render(w, status, "ldap_login.html", pages.LDAPLogin{
+		State:    stateToken,
+		Username: username,
+		Error:    msg,
+		Captcha:  p.captcha.For(flow),
+	})
+}
@@ -0,0 +1,372 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/ticket"
+)
+
+const (
+	serviceDN = "cn=centralauth,ou=services,dc=example,dc=com"
+	servicePW = "service-secret"
+	adaDN     = "uid=ada,ou=people,dc=example,dc=com"
+)
+
+// fakeDirectory is a tiny in-process LDAP server holding a few entries.
+type fakeDirectory struct {
+	ln        net.Listener
+	entries   map[string]map[string][]string // DN -> attribute -> values
+	passwords map[string]string              // DN -> password
+
+	mu    sync.Mutex
+	binds []string // DNs bound as, in order
+}
+
+func newFakeDirectory(t *testing.T) *fakeDirectory {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	d := &fakeDirectory{
+		ln: ln,
+		entries: map[string]map[string][]string{
+			adaDN: {
+				"uid":         {"ada"},
+				"entryUUID":   {"5f0c1a2e-7d2b-4c1e-9a53-0d7c1e2f3a4b"},
+				"displayName": {"Ada Lovelace"},
+				"cn":          {"Ada"},
+				"mail":        {"ada@example.com"},
+				"objectClass": {"inetOrgPerson"},
+			},
+			"uid=bob,ou=people,dc=example,dc=com": {
+				"uid":         {"bob"},
+				"entryUUID":   {"6a1d2b3f-8e3c-4d2f-8b64-1e8d2f3a4b5c"},
+				"objectClass": {"inetOrgPerson"},
+			},
+		},
+		passwords: map[string]string{
+			serviceDN:                             servicePW,
+			adaDN:                                 "analytical engine",
+			"uid=bob,ou=people,dc=example,dc=com": "hunter22",
+		},
+	}
+	t.Cleanup(func() { ln.Close() })
+	go d.serve()
+	return d
+}
+
+func (d *fakeDirectory) url() string { return "ldap://" + d.ln.Addr().String() }
+
+func (d *fakeDirectory) serve() {
+	for {
+		c, err := d.ln.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(c)
+	}
+}
+
+func (d *fakeDirectory) handle(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		msg, err := readElement(r)
+		if err != nil {
+			return
+		}
+		parts, _ := msg.children()
+		id, _ := parts[0].int()
+		op := parts[1]
+		fields, _ := op.children()
+
+		reply := func(ops ...[]byte) {
+			for _, o := range ops {
+				c.Write(seq(tagSequence, berInt(tagInteger, id), o))
+			}
+		}
+		result := func(tag byte, code int64) []byte {
+			return seq(tag, berInt(tagEnumerated, code), octets(tagOctetString, ""), octets(tagOctetString, ""))
+		}
+
+		switch op.tag {
+		case opBindRequest:
+			dn, pw := string(fields[1].content), string(fields[2].content)
+			d.mu.Lock()
+			d.binds = append(d.binds, dn)
+			d.mu.Unlock()
+			if want, ok := d.passwords[dn]; ok && want == pw {
+				reply(result(opBindResponse, resultSuccess))
+			} else {
+				reply(result(opBindResponse, resultInvalidCreds))
+			}
+		case opSearchRequest:
+			base := string(fields[0].content)
+			scope, _ := fields[1].int()
+			var out [][]byte
+			for dn, attrs := range d.entries {
+				if scope == scopeBaseObject && dn != base {
+					continue
+				}
+				if scope == scopeWholeSubtree {
+					if !strings.HasSuffix(dn, ","+base) {
+						continue
+					}
+					kv, _ := fields[6].children()
+					vals := attrs[string(kv[0].content)]
+					if len(vals) == 0 || vals[0] != string(kv[1].content) {
+						continue
+					}
+				}
+				out = append(out, encodeEntry(dn, attrs, fields[7]))
+			}
+			reply(append(out, result(opSearchResultDone, resultSuccess))...)
+		case opUnbindRequest:
+			return
+		}
+	}
+}
+
+func encodeEntry(dn string, attrs map[string][]string, requested element) []byte {
+	names, _ := requested.children()
+	var list [][]byte
+	for _, n := range names {
+		name := string(n.content)
+		vals, ok := attrs[name]
+		if !ok {
+			continue
+		}
+		var set [][]byte
+		for _, v := range vals {
+			set = append(set, octets(tagOctetString, v))
+		}
+		list = append(list, seq(tagSequence, octets(tagOctetString, strings.ToLower(name)), seq(tagSet, set...)))
+	}
+	return seq(opSearchResultEntry, octets(tagOctetString, dn), seq(tagSequence, list...))
+}
+
+func setupProvider(t *testing.T, ldapURL string) (*Provider, *state.Service, http.Handler) {
+	t.Helper()
+	states := state.NewService([]byte("test-key-1234567890abcdef"))
+	p, err := New(Config{
+		BaseURL:      "https://auth.example.com",
+		CallbackURL:  "https://auth.example.com/callback/ldap",
+		URL:          ldapURL,
+		BindDN:       serviceDN,
+		BindPassword: servicePW,
+		BaseDN:       "ou=people,dc=example,dc=com",
+	}, states, ticket.NewSigner([]byte("ticket-key"), 0))
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	p.RegisterRoutes(mux)
+	return p, states, mux
+}
+
+func newState(t *testing.T, states *state.Service) string {
+	t.Helper()
+	tok, err := states.Generate(domain.StatePayload{ClientID: "intranet", Provider: "ldap"})
+	if err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	return tok
+}
+
+func postLogin(h http.Handler, stateToken, username, password string) *httptest.ResponseRecorder {
+	form := url.Values{"state": {stateToken}, "username": {username}, "password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/ldap/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+// callbackParams extracts the query of a redirect to the callback URL.
+func callbackParams(t *testing.T, rr *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d (body: %s)", rr.Code, rr.Body.String())
+	}
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse Location: %v", err)
+	}
+	if loc.Path != "/callback/ldap" {
+		t.Fatalf("unexpected redirect: %s", loc)
+	}
+	return map[string]string{"state": loc.Query().Get("state"), "ticket": loc.Query().Get("ticket")}
+}
+
+func TestAuthURL(t *testing.T) {
+	p, _, _ := setupProvider(t, "ldap://ldap.example.com")
+	u, _ := p.AuthURL("tok")
+	if u != "https://auth.example.com/ldap/login?state=tok" {
+		t.Errorf("unexpected auth URL: %s", u)
+	}
+}
+
+func TestNew_RejectsBadURL(t *testing.T) {
+	for _, u := range []string{"", "http://ldap.example.com", "ldap://"} {
+		_, err := New(Config{URL: u}, nil, nil)
+		if !errors.Is(err, domain.ErrInvalidConfig) {
+			t.Errorf("URL %q: expected ErrInvalidConfig, got %v", u, err)
+		}
+	}
+}
+
+func TestLoginPage(t *testing.T) {
+	_, states, h := setupProvider(t, "ldap://ldap.example.com")
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ldap/login?state="+url.QueryEscape(newState(t, states)), nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `name="username"`) {
+		t.Errorf("expected the login form, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ldap/login?state=forged", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a bad state token, got %d", rr.Code)
+	}
+}
+
+func TestLoginThenExchange(t *testing.T) {
+	dir := newFakeDirectory(t)
+	p, states, h := setupProvider(t, dir.url())
+	stateToken := newState(t, states)
+
+	params := callbackParams(t, postLogin(h, stateToken, "ada", "analytical engine"))
+	result, err := p.Exchange(context.Background(), params)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	user := result.User
+	if user.ProviderName != "ldap" || user.ProviderID != "5f0c1a2e-7d2b-4c1e-9a53-0d7c1e2f3a4b" || user.Username != "ada" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	if user.DisplayName != "Ada Lovelace" || user.Email != "ada@example.com" {
+		t.Errorf("unexpected profile: %+v", user)
+	}
+
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+	if len(dir.binds) < 2 || dir.binds[0] != serviceDN || dir.binds[1] != adaDN {
+		t.Errorf("expected a service bind then a user bind, got %v", dir.binds)
+	}
+}
+
+func TestExchange_FallbackDisplayName(t *testing.T) {
+	dir := newFakeDirectory(t)
+	p, states, h := setupProvider(t, dir.url())
+
+	params := callbackParams(t, postLogin(h, newState(t, states), "bob", "hunter22"))
+	result, err := p.Exchange(context.Background(), params)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if result.User.DisplayName != "bob" {
+		t.Errorf("expected display name to fall back to username, got %q", result.User.DisplayName)
+	}
+}
+
+func TestLogin_InvalidCredentials(t *testing.T) {
+	dir := newFakeDirectory(t)
+	_, states, h := setupProvider(t, dir.url())
+	stateToken := newState(t, states)
+
+	for _, creds := range [][2]string{{"ada", "wrong"}, {"nobody", "whatever"}} {
+		rr := postLogin(h, stateToken, creds[0], creds[1])
+		if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), "Incorrect username or password") {
+			t.Errorf("%s: expected 401, got %d", creds[0], rr.Code)
+		}
+	}
+}
+
+func TestLogin_EmptyPasswordNeverBinds(t *testing.T) {
+	dir := newFakeDirectory(t)
+	_, states, h := setupProvider(t, dir.url())
+
+	rr := postLogin(h, newState(t, states), "ada", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rr.Code)
+	}
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+	if len(dir.binds) != 0 {
+		t.Errorf("expected no binds, got %v", dir.binds)
+	}
+}
+
+func TestBind_RefusesUnauthenticatedBind(t *testing.T) {
+	c := &conn{}
+	if err := c.bind(adaDN, ""); !errors.Is(err, errInvalidCredentials) {
+		t.Errorf("expected errInvalidCredentials, got %v", err)
+	}
+}
+
+func TestLogin_DirectoryUnavailable(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+	_, states, h := setupProvider(t, "ldap://"+addr)
+
+	rr := postLogin(h, newState(t, states), "ada", "analytical engine")
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("expected 502, got %d", rr.Code)
+	}
+}
+
+func TestLogin_ServiceBindFails(t *testing.T) {
+	dir := newFakeDirectory(t)
+	dir.passwords[serviceDN] = "rotated"
+	_, states, h := setupProvider(t, dir.url())
+
+	rr := postLogin(h, newState(t, states), "ada", "analytical engine")
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("expected 502, got %d", rr.Code)
+	}
+}
+
+func TestExchange_TicketBoundToState(t *testing.T) {
+	dir := newFakeDirectory(t)
+	p, states, h := setupProvider(t, dir.url())
+
+	params := callbackParams(t, postLogin(h, newState(t, states), "ada", "analytical engine"))
+	params["state"] = newState(t, states)
+	_, err := p.Exchange(context.Background(), params)
+	if !errors.Is(err, domain.ErrProviderExchange) {
+		t.Errorf("expected ErrProviderExchange, got %v", err)
+	}
+}
+
+func TestExchange_MissingParams(t *testing.T) {
+	p, _, _ := setupProvider(t, "ldap://ldap.example.com")
+	_, err := p.Exchange(context.Background(), map[string]string{"state": "s"})
+	if !errors.Is(err, domain.ErrMissingProviderParams) {
+		t.Errorf("expected ErrMissingProviderParams, got %v", err)
+	}
+}
+
+func TestAttrString(t *testing.T) {
+	if got := attrString([]byte("ada")); got != "ada" {
+		t.Errorf("expected text to pass through, got %q", got)
+	}
+	guid := []byte{0x8f, 0x2e, 0x01, 0xff}
+	if got := attrString(guid); got != "8f2e01ff" {
+		t.Errorf("expected binary to be hex-encoded, got %q", got)
+	}
+}
@@ -0,0 +1,290 @@
+package local
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/BlackMission/centralauth/internal/captcha"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/hosted"
+	"github.com/BlackMission/centralauth/internal/pages"
+	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/ticket"
+)
+
+const (
+	providerName             = "local"
+	defaultMinPasswordLength = 10
+	maxPasswordLength        = 72 // bcrypt ignores anything longer
+	maxUsernameLength        = 32
+)
+
+// Config holds local provider settings.
+type Config struct {
+	BaseURL           string // public URL of this service; pages are served under {base_url}/local/
+	CallbackURL       string // The CentralAuth callback URL: {base_url}/callback/local
+	AllowRegistration bool
+	MinPasswordLength int
+}
+
+// Provider authenticates first-party accounts with an email and password.
+//
+// AuthURL sends the browser to a hosted sign-in page. A successful sign-in
+// redirects to the callback with a short-lived ticket bound to the flow's
+// state token, which Exchange turns back into the account.
+type Provider struct {
+	cfg     Config
+	store   Store
+	states  *state.Service
+	tickets *ticket.Signer
+	now     func() time.Time
+
+	captcha hosted.Captcha
+
+	dummyHash []byte // compared against for unknown emails to keep timing uniform
+}
+
+// New creates a local provider. states validates the state token carried by
+// the hosted pages so that they can't be used outside an auth flow.
+func New(cfg Config, store Store, states *state.Service, tickets *ticket.Signer) *Provider {
+	if cfg.MinPasswordLength <= 0 {
+		cfg.MinPasswordLength = defaultMinPasswordLength
+	}
+	dummy, _ := bcrypt.GenerateFromPassword([]byte("centralauth-dummy-password"), bcrypt.DefaultCost)
+	return &Provider{
+		cfg:       cfg,
+		store:     store,
+		states:    states,
+		tickets:   tickets,
+		now:       time.Now,
+		dummyHash: dummy,
+	}
+}
+
+// SetCaptcha puts a CAPTCHA on the sign-in and registration forms of flows
+// whose client requires one.
+func (p *Provider) SetCaptcha(v *captcha.Verifier, required func(clientID string) bool) {
+	p.captcha.Set(v, required)
+}
+
+func (p *Provider) Name() string { return providerName }
+
+func (p *Provider) AuthURL(stateToken string) (string, error) {
+	return p.cfg.BaseURL + "/local/login?" + url.Values{"state": {stateToken}}.Encode(), nil
+}
+
+func (p *Provider) Exchange(ctx context.Context, params map[string]string) (*domain.AuthResult, error) {
+	tkt, stateToken := params["ticket"], params["state"]
+	if tkt == "" || stateToken == "" {
+		return nil, domain.ErrMissingProviderParams
+	}
+
+	accountID, err := p.tickets.Verify(tkt, hosted.Audience(stateToken))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderExchange, err)
+	}
+	account, err := p.store.ByID(accountID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderUserFetch, err)
+	}
+
+	return &domain.AuthResult{User: domain.UserInfo{
+		ProviderName: providerName,
+		ProviderID:   account.ID,
+		Username:     account.Username,
+		DisplayName:  account.Username,
+		Email:        account.Email,
+	}}, nil
+}
+
+// RegisterRoutes mounts the hosted sign-in and registration pages.
+func (p *Provider) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /local/login", p.loginPage)
+	mux.HandleFunc("POST /local/login", p.login)
+	if p.cfg.AllowRegistration {
+		mux.HandleFunc("GET /local/register", p.registerPage)
+		mux.HandleFunc("POST /local/register", p.register)
+	}
+}
+
+func (p *Provider) loginPage(w http.ResponseWriter, r *http.Request) {
+	stateToken := r.URL.Query().Get("state")
+	flow, ok := hosted.ValidState(w, p.states, providerName, stateToken)
+	if !ok {
+		return
+	}
+	p.renderLogin(w, http.StatusOK, flow, stateToken, "", "")
+}
+
+func (p *Provider) login(w http.ResponseWriter, r *http.Request) {
+	stateToken := r.PostFormValue("state")
+	flow, ok := hosted.ValidState(w, p.states, providerName, stateToken)
+	if !ok {
+		return
+	}
+	email := normalizeEmail(r.PostFormValue("email"))
+	password := r.PostFormValue("password")
+
+	if err := p.captcha.Verify(r, flow); err != nil {
+		p.renderLogin(w, http.StatusBadRequest, flow, stateToken, email, "Please complete the CAPTCHA.")
+		return
+	}
+
+	account, err := p.authenticate(email, password)
+	if err != nil {
+		if !errors.Is(err, domain.ErrInvalidCredentials) {
+			slog.InfoContext(r.Context(), "local: login failed", "email", email, "error", err)
+		}
+		p.renderLogin(w, http.StatusUnauthorized, flow, stateToken, email, "Incorrect email or password.")
+		return
+	}
+	p.redirectToCallback(w, r, stateToken, account.ID)
+}
+
+func (p *Provider) registerPage(w http.ResponseWriter, r *http.Request) {
+	stateToken := r.URL.Query().Get("state")
+	flow, ok := hosted.ValidState(w, p.states, providerName, stateToken)
+	if !ok {
+		return
+	}
+	p.renderRegister(w, http.StatusOK, flow, pages.LocalRegister{State: stateToken})
+}
+
+func (p *Provider) register(w http.ResponseWriter, r *http.Request) {
+	stateToken := r.PostFormValue("state")
+	flow, ok := hosted.ValidState(w, p.states, providerName, stateToken)
+	if !ok {
+		return
+	}
+	form := pages.LocalRegister{
+		State:    stateToken,
+		Email:    normalizeEmail(r.PostFormValue("email")),
+		Username: strings.TrimSpace(r.PostFormValue("username")),
+	}
+	password := r.PostFormValue("password")
+
+	if err := p.captcha.Verify(r, flow); err != nil {
+		form.Error = "Please complete the CAPTCHA."
+		p.renderRegister(w, http.StatusBadRequest, flow, form)
+		return
+	}
+	if msg := p.checkRegistration(form.Email, form.Username, password); msg != "" {
+		form.Error = msg
+		p.renderRegister(w, http.StatusBadRequest, flow, form)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "local: hashing password failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	id, err := newAccountID()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "local: generating account ID failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	err = p.store.Create(Account{
+		ID:           id,
+		Email:        form.Email,
+		Username:     form.Username,
+		PasswordHash: string(hash),
+		CreatedAt:    p.now().UTC(),
+	})
+	if errors.Is(err, domain.ErrAccountExists) {
+		form.Error = "That email or username is already registered."
+		p.renderRegister(w, http.StatusConflict, flow, form)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "local: creating account failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "local: registered account", "account_id", id)
+	p.redirectToCallback(w, r, stateToken, id)
+}
+
+// authenticate checks email and password, taking the same time whether or
+// not the account exists.
+func (p *Provider) authenticate(email, password string) (*Account, error) {
+	account, err := p.store.ByEmail(email)
+	if errors.Is(err, domain.ErrAccountNotFound) {
+		bcrypt.CompareHashAndPassword(p.dummyHash, []byte(password))
+		return nil, domain.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)) != nil {
+		return nil, domain.ErrInvalidCredentials
+	}
+	return account, nil
+}
+
+// checkRegistration returns a user-facing message if the form is invalid.
+func (p *Provider) checkRegistration(email, username, password string) string {
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return "Enter a valid email address."
+	}
+	if username == "" || len(username) > maxUsernameLength {
+		return fmt.Sprintf("Usernames must be 1 to %d characters.", maxUsernameLength)
+	}
+	if len(password) < p.cfg.MinPasswordLength || len(password) > maxPasswordLength {
+		return fmt.Sprintf("Passwords must be %d to %d characters.", p.cfg.MinPasswordLength, maxPasswordLength)
+	}
+	return ""
+}
+
+func (p *Provider) redirectToCallback(w http.ResponseWriter, r *http.Request, stateToken, accountID string) {
+	tkt, err := p.tickets.Sign(accountID, hosted.Audience(stateToken))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "local: signing ticket failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	q := url.Values{"state": {stateToken}, "ticket": {tkt}}
+	http.Redirect(w, r, p.cfg.CallbackURL+"?"+q.Encode(), http.StatusSeeOther)
+}
+
+func (p *Provider) renderLogin(w http.ResponseWriter, status int, flow *domain.StatePayload, stateToken, email, msg string) {
+	data := pages.LocalLogin{State: stateToken, Email: email, Error: msg, Captcha: p.captcha.For(flow)}
+	if p.cfg.AllowRegistration {
+		data.RegisterURL = "register?" + url.Values{"state": {stateToken}}.Encode()
+	}
+	pages.Render(w, status, "login.html", data)
+}
+
+func (p *Provider) renderRegister(w http.ResponseWriter, status int, flow *domain.StatePayload, data pages.LocalRegister) {
+	data.LoginURL = "login?" + url.Values{"state": {data.State}}.Encode()
+	data.Captcha = p.captcha.For(flow)
+	data.MinPasswordLength = p.cfg.MinPasswordLength
+	pages.Render(w, status, "register.html", data)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func newAccountID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
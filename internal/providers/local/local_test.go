@@ -0,0 +1,238 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/captcha"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/ticket"
+)
+
+func setupProvider(t *testing.T, allowRegistration bool) (*Provider, *state.Service, http.Handler) {
+	t.Helper()
+	store, err := OpenFileStore(filepath.Join(t.TempDir(), "accounts.json"))
+	if err != nil {
+		t.Fatalf("OpenFileStore error: %v", err)
+	}
+	states := state.NewService([]byte("test-key-1234567890abcdef"))
+	p := New(Config{
+		BaseURL:           "https://auth.example.com",
+		CallbackURL:       "https://auth.example.com/callback/local",
+		AllowRegistration: allowRegistration,
+	}, store, states, ticket.NewSigner([]byte("ticket-key"), 0))
+
+	mux := http.NewServeMux()
+	p.RegisterRoutes(mux)
+	return p, states, mux
+}
+
+func newState(t *testing.T, states *state.Service) string {
+	t.Helper()
+	tok, err := states.Generate(domain.StatePayload{ClientID: "website", Provider: "local"})
+	if err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	return tok
+}
+
+func postForm(h http.Handler, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+// callbackParams extracts the query of a redirect to the callback URL.
+func callbackParams(t *testing.T, rr *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d (body: %s)", rr.Code, rr.Body.String())
+	}
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse Location: %v", err)
+	}
+	if loc.Path != "/callback/local" {
+		t.Fatalf("unexpected redirect: %s", loc)
+	}
+	return map[string]string{"state": loc.Query().Get("state"), "ticket": loc.Query().Get("ticket")}
+}
+
+func register(t *testing.T, h http.Handler, stateToken string) *httptest.ResponseRecorder {
+	t.Helper()
+	return postForm(h, "/local/register", url.Values{
+		"state":    {stateToken},
+		"email":    {"Player@Example.com"},
+		"username": {"player"},
+		"password": {"correct horse battery"},
+	})
+}
+
+func TestAuthURL(t *testing.T) {
+	p, _, _ := setupProvider(t, true)
+	u, _ := p.AuthURL("tok")
+	if u != "https://auth.example.com/local/login?state=tok" {
+		t.Errorf("unexpected auth URL: %s", u)
+	}
+}
+
+func TestRegisterThenExchange(t *testing.T) {
+	p, states, h := setupProvider(t, true)
+	stateToken := newState(t, states)
+
+	params := callbackParams(t, register(t, h, stateToken))
+
+	result, err := p.Exchange(context.Background(), params)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if result.User.Email != "player@example.com" || result.User.Username != "player" || result.User.ProviderName != "local" {
+		t.Errorf("unexpected user: %+v", result.User)
+	}
+}
+
+func TestRegister_Duplicate(t *testing.T) {
+	_, states, h := setupProvider(t, true)
+	register(t, h, newState(t, states))
+
+	rr := register(t, h, newState(t, states))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected 409, got %d", rr.Code)
+	}
+}
+
+func TestRegister_ShortPassword(t *testing.T) {
+	_, states, h := setupProvider(t, true)
+	rr := postForm(h, "/local/register", url.Values{
+		"state":    {newState(t, states)},
+		"email":    {"player@example.com"},
+		"username": {"player"},
+		"password": {"short"},
+	})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestRegister_Disabled(t *testing.T) {
+	_, states, h := setupProvider(t, false)
+	rr := register(t, h, newState(t, states))
+	if rr.Code != http.StatusNotFound && rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected registration route to be absent, got %d", rr.Code)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	p, states, h := setupProvider(t, true)
+	register(t, h, newState(t, states))
+
+	stateToken := newState(t, states)
+	rr := postForm(h, "/local/login", url.Values{
+		"state":    {stateToken},
+		"email":    {"player@example.com"},
+		"password": {"correct horse battery"},
+	})
+	params := callbackParams(t, rr)
+	if params["state"] != stateToken {
+		t.Error("expected the flow's state token to be passed through")
+	}
+	if _, err := p.Exchange(context.Background(), params); err != nil {
+		t.Errorf("Exchange error: %v", err)
+	}
+}
+
+func TestLogin_WrongPassword(t *testing.T) {
+	_, states, h := setupProvider(t, true)
+	register(t, h, newState(t, states))
+
+	for _, email := range []string{"player@example.com", "nobody@example.com"} {
+		rr := postForm(h, "/local/login", url.Values{
+			"state":    {newState(t, states)},
+			"email":    {email},
+			"password": {"wrong password!"},
+		})
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %d", email, rr.Code)
+		}
+	}
+}
+
+func TestLogin_Captcha(t *testing.T) {
+	p, states, h := setupProvider(t, true)
+	register(t, h, newState(t, states))
+
+	verify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("response") == "good-token" {
+			w.Write([]byte(`{"success":true}`))
+			return
+		}
+		w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+	}))
+	defer verify.Close()
+
+	v, err := captcha.New(captcha.Turnstile, "site-key", "secret")
+	if err != nil {
+		t.Fatalf("captcha.New error: %v", err)
+	}
+	v.SetVerifyURL(verify.URL)
+	p.SetCaptcha(v, func(clientID string) bool { return clientID == "website" })
+
+	stateToken := newState(t, states)
+	req := httptest.NewRequest(http.MethodGet, "/local/login?state="+url.QueryEscape(stateToken), nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), `data-sitekey="site-key"`) {
+		t.Error("expected the login page to render the CAPTCHA widget")
+	}
+
+	form := url.Values{
+		"state":    {stateToken},
+		"email":    {"player@example.com"},
+		"password": {"correct horse battery"},
+	}
+	if rr := postForm(h, "/local/login", form); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a CAPTCHA response, got %d", rr.Code)
+	}
+	form.Set("cf-turnstile-response", "bad-token")
+	if rr := postForm(h, "/local/login", form); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a rejected CAPTCHA, got %d", rr.Code)
+	}
+	form.Set("cf-turnstile-response", "good-token")
+	callbackParams(t, postForm(h, "/local/login", form))
+}
+
+func TestLoginPage_InvalidState(t *testing.T) {
+	_, _, h := setupProvider(t, true)
+	req := httptest.NewRequest(http.MethodGet, "/local/login?state=bogus", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestExchange_TicketBoundToState(t *testing.T) {
+	p, states, h := setupProvider(t, true)
+	params := callbackParams(t, register(t, h, newState(t, states)))
+
+	params["state"] = newState(t, states)
+	if _, err := p.Exchange(context.Background(), params); !errors.Is(err, domain.ErrProviderExchange) {
+		t.Errorf("expected ErrProviderExchange for a ticket from another flow, got %v", err)
+	}
+}
+
+func TestExchange_MissingParams(t *testing.T) {
+	p, _, _ := setupProvider(t, true)
+	if _, err := p.Exchange(context.Background(), map[string]string{"state": "x"}); !errors.Is(err, domain.ErrMissingProviderParams) {
+		t.Errorf("expected ErrMissingProviderParams, got %v", err)
+	}
+}
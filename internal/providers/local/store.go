@@ -0,0 +1,126 @@
+package local
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// Account is a first-party user account.
+type Account struct {
+	ID           string    `json:"id"`
+	Email        string    `json:"email"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Store persists local accounts.
+type Store interface {
+	ByID(id string) (*Account, error)
+	ByEmail(email string) (*Account, error)
+	Create(a Account) error
+}
+
+// FileStore keeps accounts in a JSON file, rewriting it atomically on every
+// change. It suits small communities running a single instance.
+type FileStore struct {
+	mu       sync.RWMutex
+	path     string
+	accounts []Account
+}
+
+// OpenFileStore loads the accounts file at path, starting empty if it does
+// not exist yet.
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading accounts file: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.accounts); err != nil {
+		return nil, fmt.Errorf("parsing accounts file: %w", err)
+	}
+	return s, nil
+}
+
+// ByID returns the account with the given ID.
+func (s *FileStore) ByID(id string) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := range s.accounts {
+		if s.accounts[i].ID == id {
+			a := s.accounts[i]
+			return &a, nil
+		}
+	}
+	return nil, domain.ErrAccountNotFound
+}
+
+// ByEmail returns the account registered with email.
+func (s *FileStore) ByEmail(email string) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := range s.accounts {
+		if s.accounts[i].Email == email {
+			a := s.accounts[i]
+			return &a, nil
+		}
+	}
+	return nil, domain.ErrAccountNotFound
+}
+
+// Create adds an account, failing if its email or username is taken.
+func (s *FileStore) Create(a Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.accounts {
+		if existing.Email == a.Email || existing.Username == a.Username {
+			return domain.ErrAccountExists
+		}
+	}
+
+	accounts := append(s.accounts[:len(s.accounts):len(s.accounts)], a)
+	if err := s.write(accounts); err != nil {
+		return err
+	}
+	s.accounts = accounts
+	return nil
+}
+
+// write replaces the accounts file via a temp file and rename. Callers must hold s.mu.
+func (s *FileStore) write(accounts []Account) error {
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding accounts: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".accounts-*.json")
+	if err != nil {
+		return fmt.Errorf("writing accounts file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing accounts file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing accounts file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("writing accounts file: %w", err)
+	}
+	return nil
+}
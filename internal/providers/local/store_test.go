@@ -0,0 +1,44 @@
+package local
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func TestFileStore_Persists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore error: %v", err)
+	}
+	if err := s.Create(Account{ID: "a1", Email: "a@example.com", Username: "a"}); err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	a, err := reopened.ByEmail("a@example.com")
+	if err != nil || a.ID != "a1" {
+		t.Errorf("ByEmail = %+v, %v", a, err)
+	}
+	if _, err := reopened.ByID("missing"); !errors.Is(err, domain.ErrAccountNotFound) {
+		t.Errorf("expected ErrAccountNotFound, got %v", err)
+	}
+}
+
+func TestFileStore_RejectsDuplicates(t *testing.T) {
+	s, _ := OpenFileStore(filepath.Join(t.TempDir(), "accounts.json"))
+	s.Create(Account{ID: "a1", Email: "a@example.com", Username: "a"})
+
+	if err := s.Create(Account{ID: "a2", Email: "a@example.com", Username: "b"}); !errors.Is(err, domain.ErrAccountExists) {
+		t.Errorf("expected ErrAccountExists for email, got %v", err)
+	}
+	if err := s.Create(Account{ID: "a3", Email: "c@example.com", Username: "a"}); !errors.Is(err, domain.ErrAccountExists) {
+		t.Errorf("expected ErrAccountExists for username, got %v", err)
+	}
+}
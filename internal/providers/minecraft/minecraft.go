@@ -0,0 +1,377 @@
+package minecraft
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/outbound"
+)
+
+const (
+	providerName        = "minecraft"
+	defaultAuthEndpoint = "https://login.microsoftonline.com/consumers/oauth2/v2.0/authorize"
+	defaultTokenURL     = "https://login.microsoftonline.com/consumers/oauth2/v2.0/token"
+	defaultXBLURL       = "https://user.auth.xboxlive.com/user/authenticate"
+	defaultXSTSURL      = "https://xsts.auth.xboxlive.com/xsts/authorize"
+	defaultLoginURL     = "https://api.minecraftservices.com/authentication/login_with_xbox"
+	defaultProfileURL   = "https://api.minecraftservices.com/minecraft/profile"
+
+	// The only Microsoft scope the Xbox Live user token needs
+	scopeXboxLive = "XboxLive.signin"
+)
+
+// XSTS error codes that mean the account can't play, rather than that the
+// service failed.
+var xstsAccountErrors = map[int64]string{
+	2148916227: "account is banned from Xbox Live",
+	2148916233: "account has no Xbox profile",
+	2148916235: "Xbox Live is not available in the account's country",
+	2148916236: "account needs adult verification",
+	2148916237: "account needs adult verification",
+	2148916238: "child account must be added to a family by an adult",
+}
+
+// Config holds the Microsoft (Azure) app settings used to sign in to Minecraft.
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	CallbackURL  string // The CentralAuth callback URL: {base_url}/callback/minecraft
+
+	// HTTPClient makes the calls to the Microsoft, Xbox and Minecraft APIs (http.DefaultClient if nil).
+	HTTPClient *http.Client
+}
+
+// Provider signs users in with their Microsoft account and walks the
+// Xbox Live → XSTS → Minecraft services chain to their Java Edition profile.
+type Provider struct {
+	cfg          Config
+	httpClient   *http.Client
+	authEndpoint string
+	tokenURL     string
+	xblURL       string
+	xstsURL      string
+	loginURL     string
+	profileURL   string
+}
+
+// New creates a Minecraft provider.
+func New(cfg Config) *Provider {
+	return &Provider{
+		cfg:          cfg,
+		httpClient:   cmp.Or(cfg.HTTPClient, http.DefaultClient),
+		authEndpoint: defaultAuthEndpoint,
+		tokenURL:     defaultTokenURL,
+		xblURL:       defaultXBLURL,
+		xstsURL:      defaultXSTSURL,
+		loginURL:     defaultLoginURL,
+		profileURL:   defaultProfileURL,
+	}
+}
+
+func (p *Provider) Name() string { return providerName }
+
+// Probe checks that the Microsoft token endpoint and the Minecraft profile API are reachable.
+func (p *Provider) Probe(ctx context.Context) error {
+	return outbound.Probe(ctx, p.httpClient, p.tokenURL, p.profileURL)
+}
+
+func (p *Provider) AuthURL(stateToken string) (string, error) {
+	params := url.Values{
+		"client_id":     {p.cfg.ClientID},
+		"redirect_uri":  {p.cfg.CallbackURL},
+		"response_type": {"code"},
+		"scope":         {scopeXboxLive},
+		"state":         {stateToken},
+		"prompt":        {"select_account"},
+	}
+	return p.authEndpoint + "?" + params.Encode(), nil
+}
+
+func (p *Provider) Exchange(ctx context.Context, params map[string]string) (*domain.AuthResult, error) {
+	if e := params["error"]; e != "" {
+		return nil, fmt.Errorf("%w: %s: %s", domain.ErrProviderExchange, e, params["error_description"])
+	}
+	code, ok := params["code"]
+	if !ok || code == "" {
+		return nil, domain.ErrMissingProviderParams
+	}
+
+	msToken, err := p.exchangeCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	xbl, err := p.xboxUserToken(ctx, msToken)
+	if err != nil {
+		return nil, err
+	}
+
+	xsts, err := p.xstsToken(ctx, xbl.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	mcToken, err := p.loginWithXbox(ctx, xsts)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := p.fetchProfile(ctx, mcToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.AuthResult{User: *user}, nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+}
+
+func (p *Provider) exchangeCode(ctx context.Context, code string) (string, error) {
+	data := url.Values{
+		"client_id":     {p.cfg.ClientID},
+		"client_secret": {p.cfg.ClientSecret},
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.CallbackURL},
+		"scope":         {scopeXboxLive},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", domain.ErrProviderExchange, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%w: reading response: %v", domain.ErrProviderExchange, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: status %d: %s", domain.ErrProviderExchange, resp.StatusCode, body)
+	}
+
+	var tokenResp tokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderExchange, err)
+	}
+
+	if tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("%w: empty access token", domain.ErrProviderExchange)
+	}
+
+	return tokenResp.AccessToken, nil
+}
+
+// xboxToken is the response shape shared by the Xbox Live user and XSTS endpoints.
+type xboxToken struct {
+	Token         string `json:"Token"`
+	DisplayClaims struct {
+		XUI []struct {
+			UHS string `json:"uhs"`
+		} `json:"xui"`
+	} `json:"DisplayClaims"`
+}
+
+func (t *xboxToken) userHash() string {
+	if len(t.DisplayClaims.XUI) == 0 {
+		return ""
+	}
+	return t.DisplayClaims.XUI[0].UHS
+}
+
+func (p *Provider) xboxUserToken(ctx context.Context, msToken string) (*xboxToken, error) {
+	reqBody := map[string]any{
+		"Properties": map[string]any{
+			"AuthMethod": "RPS",
+			"SiteName":   "user.auth.xboxlive.com",
+			"RpsTicket":  "d=" + msToken, // "d=" marks a token from a custom Azure app
+		},
+		"RelyingParty": "http://auth.xboxlive.com",
+		"TokenType":    "JWT",
+	}
+
+	resp, body, err := p.postJSON(ctx, p.xblURL, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("%w: xbox live: %v", domain.ErrProviderExchange, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: xbox live: status %d: %s", domain.ErrProviderExchange, resp.StatusCode, body)
+	}
+
+	var xbl xboxToken
+	if err := json.Unmarshal(body, &xbl); err != nil {
+		return nil, fmt.Errorf("%w: xbox live: invalid JSON: %v", domain.ErrProviderExchange, err)
+	}
+	if xbl.Token == "" {
+		return nil, fmt.Errorf("%w: xbox live: empty token", domain.ErrProviderExchange)
+	}
+	return &xbl, nil
+}
+
+func (p *Provider) xstsToken(ctx context.Context, xblToken string) (*xboxToken, error) {
+	reqBody := map[string]any{
+		"Properties": map[string]any{
+			"SandboxId":  "RETAIL",
+			"UserTokens": []string{xblToken},
+		},
+		"RelyingParty": "rp://api.minecraftservices.com/",
+		"TokenType":    "JWT",
+	}
+
+	resp, body, err := p.postJSON(ctx, p.xstsURL, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("%w: xsts: %v", domain.ErrProviderExchange, err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		var xerr struct {
+			XErr int64 `json:"XErr"`
+		}
+		if json.Unmarshal(body, &xerr) == nil {
+			if reason, ok := xstsAccountErrors[xerr.XErr]; ok {
+				return nil, fmt.Errorf("%w: %s", domain.ErrNoGameAccount, reason)
+			}
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: xsts: status %d: %s", domain.ErrProviderExchange, resp.StatusCode, body)
+	}
+
+	var xsts xboxToken
+	if err := json.Unmarshal(body, &xsts); err != nil {
+		return nil, fmt.Errorf("%w: xsts: invalid JSON: %v", domain.ErrProviderExchange, err)
+	}
+	if xsts.Token == "" || xsts.userHash() == "" {
+		return nil, fmt.Errorf("%w: xsts: missing token or user hash", domain.ErrProviderExchange)
+	}
+	return &xsts, nil
+}
+
+func (p *Provider) loginWithXbox(ctx context.Context, xsts *xboxToken) (string, error) {
+	reqBody := map[string]string{
+		"identityToken": "XBL3.0 x=" + xsts.userHash() + ";" + xsts.Token,
+	}
+
+	resp, body, err := p.postJSON(ctx, p.loginURL, reqBody)
+	if err != nil {
+		return "", fmt.Errorf("%w: minecraft login: %v", domain.ErrProviderExchange, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: minecraft login: status %d: %s", domain.ErrProviderExchange, resp.StatusCode, body)
+	}
+
+	var tokenResp tokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", fmt.Errorf("%w: minecraft login: invalid JSON: %v", domain.ErrProviderExchange, err)
+	}
+	if tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("%w: minecraft login: empty access token", domain.ErrProviderExchange)
+	}
+	return tokenResp.AccessToken, nil
+}
+
+type minecraftProfile struct {
+	ID   string `json:"id"` // UUID without dashes
+	Name string `json:"name"`
+}
+
+func (p *Provider) fetchProfile(ctx context.Context, mcToken string) (*domain.UserInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.profileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating profile request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+mcToken)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderUserFetch, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading response: %v", domain.ErrProviderUserFetch, err)
+	}
+
+	// A Microsoft account that doesn't own Java Edition has no profile
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: account does not own Minecraft", domain.ErrNoGameAccount)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d: %s", domain.ErrProviderUserFetch, resp.StatusCode, body)
+	}
+
+	var mp minecraftProfile
+	if err := json.Unmarshal(body, &mp); err != nil {
+		return nil, fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderUserFetch, err)
+	}
+	uuid, ok := dashUUID(mp.ID)
+	if !ok {
+		return nil, fmt.Errorf("%w: unexpected profile ID %q", domain.ErrProviderUserFetch, mp.ID)
+	}
+
+	return &domain.UserInfo{
+		ProviderName: providerName,
+		ProviderID:   uuid,
+		Username:     mp.Name,
+		DisplayName:  mp.Name,
+		Raw:          body,
+	}, nil
+}
+
+func (p *Provider) postJSON(ctx context.Context, endpoint string, v any) (*http.Response, []byte, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading response: %w", err)
+	}
+	return resp, body, nil
+}
+
+// dashUUID formats the 32 hex digits Mojang returns as a canonical
+// 8-4-4-4-12 UUID, the form game servers key players by.
+func dashUUID(id string) (string, bool) {
+	if len(id) != 32 {
+		return "", false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return "", false
+		}
+	}
+	id = strings.ToLower(id)
+	return id[0:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:], true
+}
@@ -0,0 +1,219 @@
+package minecraft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// fakeChain serves every hop of the sign-in chain. Handlers left nil get
+// a successful default response.
+type fakeChain struct {
+	token, xbl, xsts, login, profile http.HandlerFunc
+}
+
+func setupTestProvider(t *testing.T, chain fakeChain) *Provider {
+	t.Helper()
+	if chain.token == nil {
+		chain.token = func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(tokenResponse{AccessToken: "ms-token", TokenType: "Bearer"})
+		}
+	}
+	if chain.xbl == nil {
+		chain.xbl = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Token": "xbl-token", "DisplayClaims": {"xui": [{"uhs": "user-hash"}]}}`))
+		}
+	}
+	if chain.xsts == nil {
+		chain.xsts = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Token": "xsts-token", "DisplayClaims": {"xui": [{"uhs": "user-hash"}]}}`))
+		}
+	}
+	if chain.login == nil {
+		chain.login = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"username": "mc-user", "access_token": "mc-token", "token_type": "Bearer"}`))
+		}
+	}
+	if chain.profile == nil {
+		chain.profile = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"id": "069A79F444E94726A5BEFCA90E38AAF5", "name": "Notch", "skins": []}`))
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", chain.token)
+	mux.HandleFunc("/xbl", chain.xbl)
+	mux.HandleFunc("/xsts", chain.xsts)
+	mux.HandleFunc("/login", chain.login)
+	mux.HandleFunc("/profile", chain.profile)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	p := New(Config{
+		ClientID:     "test-client-id",
+		ClientSecret: "test-client-secret",
+		CallbackURL:  "https://auth.example.com/callback/minecraft",
+	})
+	p.httpClient = server.Client()
+	p.tokenURL = server.URL + "/token"
+	p.xblURL = server.URL + "/xbl"
+	p.xstsURL = server.URL + "/xsts"
+	p.loginURL = server.URL + "/login"
+	p.profileURL = server.URL + "/profile"
+	return p
+}
+
+func TestAuthURL_ContainsCorrectParams(t *testing.T) {
+	p := New(Config{
+		ClientID:    "test-client-id",
+		CallbackURL: "https://auth.example.com/callback/minecraft",
+	})
+
+	authURL, err := p.AuthURL("test-state-token")
+	if err != nil {
+		t.Fatalf("AuthURL error: %v", err)
+	}
+	q, _ := url.Parse(authURL)
+	if got := q.Query().Get("scope"); got != "XboxLive.signin" {
+		t.Errorf("expected scope 'XboxLive.signin', got %q", got)
+	}
+	if got := q.Query().Get("state"); got != "test-state-token" {
+		t.Errorf("expected state 'test-state-token', got %q", got)
+	}
+}
+
+func TestExchange_Success(t *testing.T) {
+	p := setupTestProvider(t, fakeChain{
+		xbl: func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				Properties struct{ RpsTicket string }
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.Properties.RpsTicket != "d=ms-token" {
+				t.Errorf("unexpected RpsTicket %q", body.Properties.RpsTicket)
+			}
+			w.Write([]byte(`{"Token": "xbl-token", "DisplayClaims": {"xui": [{"uhs": "user-hash"}]}}`))
+		},
+		xsts: func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				Properties   struct{ UserTokens []string }
+				RelyingParty string
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			if len(body.Properties.UserTokens) != 1 || body.Properties.UserTokens[0] != "xbl-token" {
+				t.Errorf("unexpected user tokens %v", body.Properties.UserTokens)
+			}
+			if body.RelyingParty != "rp://api.minecraftservices.com/" {
+				t.Errorf("unexpected relying party %q", body.RelyingParty)
+			}
+			w.Write([]byte(`{"Token": "xsts-token", "DisplayClaims": {"xui": [{"uhs": "user-hash"}]}}`))
+		},
+		login: func(w http.ResponseWriter, r *http.Request) {
+			var body struct{ IdentityToken string }
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.IdentityToken != "XBL3.0 x=user-hash;xsts-token" {
+				t.Errorf("unexpected identity token %q", body.IdentityToken)
+			}
+			w.Write([]byte(`{"access_token": "mc-token", "token_type": "Bearer"}`))
+		},
+		profile: func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer mc-token" {
+				t.Error("expected the Minecraft token in Authorization header")
+			}
+			w.Write([]byte(`{"id": "069a79f444e94726a5befca90e38aaf5", "name": "Notch"}`))
+		},
+	})
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	user := result.User
+	if user.ProviderName != "minecraft" || user.ProviderID != "069a79f4-44e9-4726-a5be-fca90e38aaf5" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	if user.Username != "Notch" || user.DisplayName != "Notch" {
+		t.Errorf("unexpected profile: %+v", user)
+	}
+}
+
+func TestExchange_NoXboxProfile(t *testing.T) {
+	p := setupTestProvider(t, fakeChain{
+		xsts: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"Identity": "0", "XErr": 2148916233, "Message": "", "Redirect": "https://start.ui.xboxlive.com/CreateAccount"}`))
+		},
+	})
+
+	_, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if !errors.Is(err, domain.ErrNoGameAccount) {
+		t.Errorf("expected ErrNoGameAccount, got %v", err)
+	}
+}
+
+func TestExchange_GameNotOwned(t *testing.T) {
+	p := setupTestProvider(t, fakeChain{
+		profile: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"path": "/minecraft/profile", "error": "NOT_FOUND"}`))
+		},
+	})
+
+	_, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if !errors.Is(err, domain.ErrNoGameAccount) {
+		t.Errorf("expected ErrNoGameAccount, got %v", err)
+	}
+}
+
+func TestExchange_XSTSFailure(t *testing.T) {
+	p := setupTestProvider(t, fakeChain{
+		xsts: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	})
+
+	_, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if !errors.Is(err, domain.ErrProviderExchange) || errors.Is(err, domain.ErrNoGameAccount) {
+		t.Errorf("expected ErrProviderExchange, got %v", err)
+	}
+}
+
+func TestExchange_TokenFailure(t *testing.T) {
+	p := setupTestProvider(t, fakeChain{
+		token: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+		},
+	})
+
+	_, err := p.Exchange(context.Background(), map[string]string{"code": "bad-code"})
+	if !errors.Is(err, domain.ErrProviderExchange) {
+		t.Errorf("expected ErrProviderExchange, got %v", err)
+	}
+}
+
+func TestExchange_MissingCode(t *testing.T) {
+	p := New(Config{})
+
+	_, err := p.Exchange(context.Background(), map[string]string{})
+	if !errors.Is(err, domain.ErrMissingProviderParams) {
+		t.Errorf("expected ErrMissingProviderParams, got %v", err)
+	}
+}
+
+func TestDashUUID(t *testing.T) {
+	if got, ok := dashUUID("069A79F444E94726A5BEFCA90E38AAF5"); !ok || got != "069a79f4-44e9-4726-a5be-fca90e38aaf5" {
+		t.Errorf("unexpected result %q, %v", got, ok)
+	}
+	for _, bad := range []string{"", "069a79f4-44e9-4726-a5be-fca90e38aaf5", "zz9a79f444e94726a5befca90e38aaf5"} {
+		if _, ok := dashUUID(bad); ok {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"testing"
+)
+
+func TestVerifySignature(t *testing.T) {
+	signed := []byte("header.payload")
+	digest := sha256.Sum256(signed)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pss, _ := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	pkcs, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	es := make([]byte, 64)
+	r.FillBytes(es[:32])
+	s.FillBytes(es[32:])
+
+	tests := []struct {
+		alg  string
+		key  crypto.PublicKey
+		sig  []byte
+		good bool
+	}{
+		{"RS256", &rsaKey.PublicKey, pkcs, true},
+		{"PS256", &rsaKey.PublicKey, pss, true},
+		{"ES256", &ecKey.PublicKey, es, true},
+		{"RS256", &rsaKey.PublicKey, pss, false},
+		{"ES256", &rsaKey.PublicKey, pkcs, false},
+		{"HS256", &rsaKey.PublicKey, pkcs, false},
+		{"none", &rsaKey.PublicKey, nil, false},
+		{"", &rsaKey.PublicKey, nil, false},
+	}
+	for _, tt := range tests {
+		err := verifySignature(tt.alg, tt.key, signed, tt.sig)
+		if (err == nil) != tt.good {
+			t.Errorf("verifySignature(%q) = %v, want ok=%v", tt.alg, err, tt.good)
+		}
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	raw, _ := ecKey.PublicKey.Bytes()
+	x := base64.RawURLEncoding.EncodeToString(raw[1:33])
+	y := base64.RawURLEncoding.EncodeToString(raw[33:])
+
+	data := fmt.Sprintf(`{"keys":[
+		{"kty":"EC","kid":"ec","crv":"P-256","x":%q,"y":%q},
+		{"kty":"RSA","kid":"enc","use":"enc","n":"AQAB","e":"AQAB"},
+		{"kty":"oct","kid":"hmac","k":"c2VjcmV0"}
+	]}`, x, y)
+	keys, err := parseJWKS([]byte(data))
+	if err != nil {
+		t.Fatalf("parseJWKS error: %v", err)
+	}
+	if len(keys) != 1 || keys[0].Kid != "ec" {
+		t.Fatalf("expected only the EC signing key, got %+v", keys)
+	}
+	if !keys[0].Key.(*ecdsa.PublicKey).Equal(&ecKey.PublicKey) {
+		t.Error("parsed EC key does not match")
+	}
+}
+
+func TestParseJWKS_PointNotOnCurve(t *testing.T) {
+	data := `{"keys":[{"kty":"EC","kid":"bad","crv":"P-256","x":"AQ","y":"AQ"}]}`
+	if _, err := parseJWKS([]byte(data)); err == nil {
+		t.Error("expected an error for a point that isn't on the curve")
+	}
+}
@@ -0,0 +1,433 @@
+package oidc
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/outbound"
+)
+
+const (
+	providerName = "oidc"
+
+	// clockSkew is how far the issuer's clock may drift from ours.
+	clockSkew = time.Minute
+
+	// minKeyRefresh limits how often an unknown key ID triggers a JWKS fetch.
+	minKeyRefresh = time.Minute
+)
+
+// Config holds OpenID Connect settings.
+type Config struct {
+	IssuerURL    string // must match the issuer the IdP reports, e.g. https://id.example.com/realms/main
+	ClientID     string
+	ClientSecret string
+	Scopes       []string // "openid" is always requested
+	CallbackURL  string   // The CentralAuth callback URL: {base_url}/callback/oidc
+
+	// HTTPClient makes the calls to the IdP (a client with a 10s timeout if nil).
+	HTTPClient *http.Client
+}
+
+// Provider implements the OpenID Connect authorization code flow against any
+// compliant identity provider. Endpoints and signing keys are discovered
+// from the issuer on first use and cached.
+type Provider struct {
+	cfg        Config
+	httpClient *http.Client
+	scopes     atomic.Pointer[[]string]
+	now        func() time.Time
+
+	mu          sync.Mutex
+	meta        *metadata
+	keys        []jsonWebKey
+	keysFetched time.Time
+}
+
+// metadata is the subset of the issuer's discovery document that we use.
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// New creates an OpenID Connect provider.
+func New(cfg Config) *Provider {
+	p := &Provider{
+		cfg:        cfg,
+		httpClient: cmp.Or(cfg.HTTPClient, &http.Client{Timeout: 10 * time.Second}),
+		now:        time.Now,
+	}
+	p.SetScopes(cfg.Scopes)
+	return p
+}
+
+// SetNow overrides the time function (for testing).
+func (p *Provider) SetNow(fn func() time.Time) {
+	p.now = fn
+}
+
+func (p *Provider) Name() string { return providerName }
+
+// SetScopes replaces the scopes requested from now on; "openid" is always
+// added. Flows already at the provider keep the scopes they asked for.
+func (p *Provider) SetScopes(scopes []string) {
+	if !slices.Contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+	p.scopes.Store(&scopes)
+}
+
+// Probe checks that the issuer's discovery document, token endpoint, and
+// signing keys are reachable.
+func (p *Provider) Probe(ctx context.Context) error {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return err
+	}
+	return outbound.Probe(ctx, p.httpClient, meta.TokenEndpoint, meta.JWKSURI)
+}
+
+func (p *Provider) AuthURL(stateToken string) (string, error) {
+	meta, err := p.discover(context.Background())
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{
+		"client_id":     {p.cfg.ClientID},
+		"redirect_uri":  {p.cfg.CallbackURL},
+		"response_type": {"code"},
+		"scope":         {strings.Join(*p.scopes.Load(), " ")},
+		"state":         {stateToken},
+		"nonce":         {nonce(stateToken)},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+func (p *Provider) Exchange(ctx context.Context, params map[string]string) (*domain.AuthResult, error) {
+	if e := params["error"]; e != "" {
+		return nil, fmt.Errorf("%w: %s: %s", domain.ErrProviderExchange, e, params["error_description"])
+	}
+	code, stateToken := params["code"], params["state"]
+	if code == "" || stateToken == "" {
+		return nil, domain.ErrMissingProviderParams
+	}
+
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := p.exchangeCode(ctx, meta, code)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := p.verifyIDToken(ctx, meta, tokens.IDToken, nonce(stateToken))
+	if err != nil {
+		return nil, err
+	}
+
+	// Some IdPs keep profile claims out of the ID token unless asked
+	if meta.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		if err := p.fillFromUserinfo(ctx, meta, tokens.AccessToken, c); err != nil {
+			return nil, err
+		}
+	}
+
+	return &domain.AuthResult{User: c.userInfo()}, nil
+}
+
+// nonce binds an authorization request to its state token, so an ID token
+// minted for one flow can't be replayed into another.
+func nonce(stateToken string) string {
+	sum := sha256.Sum256([]byte(stateToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// discover fetches and caches the issuer's discovery document.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	body, err := p.get(ctx, strings.TrimSuffix(p.cfg.IssuerURL, "/")+"/.well-known/openid-configuration", "")
+	if err != nil {
+		return nil, fmt.Errorf("%w: discovery: %v", domain.ErrProviderExchange, err)
+	}
+	var meta metadata
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return nil, fmt.Errorf("%w: discovery: invalid JSON: %v", domain.ErrProviderExchange, err)
+	}
+	if meta.Issuer != p.cfg.IssuerURL {
+		return nil, fmt.Errorf("%w: discovery: issuer %q does not match %q", domain.ErrProviderExchange, meta.Issuer, p.cfg.IssuerURL)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery: document is missing required endpoints", domain.ErrProviderExchange)
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+}
+
+func (p *Provider) exchangeCode(ctx context.Context, meta *metadata, code string) (*tokenResponse, error) {
+	data := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.cfg.CallbackURL},
+	}
+	// client_secret_basic is the default when the IdP doesn't say
+	basic := len(meta.TokenAuthMethods) == 0 || slices.Contains(meta.TokenAuthMethods, "client_secret_basic")
+	if !basic {
+		data.Set("client_id", p.cfg.ClientID)
+		data.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderExchange, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading response: %v", domain.ErrProviderExchange, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d: %s", domain.ErrProviderExchange, resp.StatusCode, body)
+	}
+
+	var tokens tokenResponse
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderExchange, err)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: no ID token in response", domain.ErrProviderExchange)
+	}
+	return &tokens, nil
+}
+
+// claims are the standard claims we map to UserInfo.
+type claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	AZP       string   `json:"azp"`
+	ExpiresAt float64  `json:"exp"`
+	Nonce     string   `json:"nonce"`
+
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Nickname          string `json:"nickname"`
+	Picture           string `json:"picture"`
+	Email             string `json:"email"`
+	EmailVerified     *bool  `json:"email_verified"`
+
+	// raw is the userinfo response if there was one, else the ID token's claims
+	raw json.RawMessage
+}
+
+// verifyIDToken checks the ID token's signature and its iss, aud, azp, exp,
+// and nonce claims, as OpenID Connect Core section 3.1.3.7 requires.
+func (p *Provider) verifyIDToken(ctx context.Context, meta *metadata, token, wantNonce string) (*claims, error) {
+	header, payload, signed, sig, err := parseJWT(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidIDToken, err)
+	}
+
+	keys, err := p.signingKeys(ctx, meta, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	verified := false
+	for _, k := range keys {
+		if verifySignature(header.Alg, k.Key, signed, sig) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: bad signature", domain.ErrInvalidIDToken)
+	}
+
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, fmt.Errorf("%w: invalid claims: %v", domain.ErrInvalidIDToken, err)
+	}
+	c.raw = payload
+	switch {
+	case c.Issuer != meta.Issuer:
+		return nil, fmt.Errorf("%w: issuer %q", domain.ErrInvalidIDToken, c.Issuer)
+	case !slices.Contains(c.Audience, p.cfg.ClientID):
+		return nil, fmt.Errorf("%w: not issued to this client", domain.ErrInvalidIDToken)
+	case len(c.Audience) > 1 && c.AZP != p.cfg.ClientID:
+		return nil, fmt.Errorf("%w: authorized party %q", domain.ErrInvalidIDToken, c.AZP)
+	case p.now().Add(-clockSkew).After(time.Unix(int64(c.ExpiresAt), 0)):
+		return nil, fmt.Errorf("%w: expired", domain.ErrInvalidIDToken)
+	case subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(wantNonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce mismatch", domain.ErrInvalidIDToken)
+	case c.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", domain.ErrInvalidIDToken)
+	}
+	return &c, nil
+}
+
+// signingKeys returns the issuer's keys that match kid (all keys if kid is
+// empty), refetching the JWKS when kid is new so key rotation needs no
+// restart.
+func (p *Provider) signingKeys(ctx context.Context, meta *metadata, kid string) ([]jsonWebKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	match := func() []jsonWebKey {
+		var out []jsonWebKey
+		for _, k := range p.keys {
+			if kid == "" || k.Kid == kid {
+				out = append(out, k)
+			}
+		}
+		return out
+	}
+	if keys := match(); len(keys) > 0 {
+		return keys, nil
+	}
+	if !p.keysFetched.IsZero() && p.now().Sub(p.keysFetched) < minKeyRefresh {
+		return nil, fmt.Errorf("%w: unknown key %q", domain.ErrInvalidIDToken, kid)
+	}
+
+	body, err := p.get(ctx, meta.JWKSURI, "")
+	if err != nil {
+		return nil, fmt.Errorf("%w: fetching JWKS: %v", domain.ErrProviderExchange, err)
+	}
+	keys, err := parseJWKS(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderExchange, err)
+	}
+	p.keys, p.keysFetched = keys, p.now()
+
+	if keys := match(); len(keys) > 0 {
+		return keys, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", domain.ErrInvalidIDToken, kid)
+}
+
+// fillFromUserinfo fills profile claims missing from the ID token with the
+// ones from the userinfo endpoint.
+func (p *Provider) fillFromUserinfo(ctx context.Context, meta *metadata, accessToken string, c *claims) error {
+	body, err := p.get(ctx, meta.UserinfoEndpoint, accessToken)
+	if err != nil {
+		return fmt.Errorf("%w: %v", domain.ErrProviderUserFetch, err)
+	}
+	var info claims
+	if err := json.Unmarshal(body, &info); err != nil {
+		return fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderUserFetch, err)
+	}
+	c.raw = body
+	if info.Subject != c.Subject {
+		return fmt.Errorf("%w: userinfo subject %q does not match ID token", domain.ErrProviderUserFetch, info.Subject)
+	}
+
+	fill := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	fill(&c.Name, info.Name)
+	fill(&c.PreferredUsername, info.PreferredUsername)
+	fill(&c.Nickname, info.Nickname)
+	fill(&c.Picture, info.Picture)
+	if c.Email == "" {
+		c.Email, c.EmailVerified = info.Email, info.EmailVerified
+	}
+	return nil
+}
+
+func (c *claims) userInfo() domain.UserInfo {
+	username := c.PreferredUsername
+	if username == "" {
+		username = c.Nickname
+	}
+	displayName := c.Name
+	if displayName == "" {
+		displayName = username
+	}
+	email := c.Email
+	if c.EmailVerified != nil && !*c.EmailVerified {
+		email = "" // don't vouch for an address the IdP hasn't checked
+	}
+	return domain.UserInfo{
+		ProviderName:  providerName,
+		ProviderID:    c.Subject,
+		Username:      username,
+		DisplayName:   displayName,
+		AvatarURL:     c.Picture,
+		Email:         email,
+		EmailVerified: email != "" && c.EmailVerified != nil && *c.EmailVerified,
+		Raw:           c.raw,
+	}
+}
+
+// get fetches a JSON document, with a bearer token if one is given.
+func (p *Provider) get(ctx context.Context, endpoint, bearer string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	return body, nil
+}
@@ -0,0 +1,369 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// testIdP is a minimal OpenID provider backed by httptest.
+type testIdP struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	kid      string
+	claims   map[string]any // overrides for the next ID token
+	userinfo map[string]any // nil disables the userinfo endpoint
+
+	jwksFetches int
+	tokenAuth   string // Authorization header of the last token request
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	idp := &testIdP{key: key, kid: "k1", claims: map[string]any{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		meta := map[string]any{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		}
+		if idp.userinfo != nil {
+			meta["userinfo_endpoint"] = idp.server.URL + "/userinfo"
+		}
+		json.NewEncoder(w).Encode(meta)
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.jwksFetches++
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"kid": idp.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		idp.tokenAuth = r.Header.Get("Authorization")
+		if r.FormValue("code") != "good-code" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access-token",
+			"id_token":     idp.idToken(t),
+		})
+	})
+	mux.HandleFunc("GET /userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(idp.userinfo)
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// idToken signs an ID token for the "test-state" flow with the overrides in
+// idp.claims applied (a nil value removes the claim).
+func (idp *testIdP) idToken(t *testing.T) string {
+	t.Helper()
+	c := map[string]any{
+		"iss":                idp.server.URL,
+		"sub":                "user-123",
+		"aud":                "test-client",
+		"exp":                time.Now().Add(5 * time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              nonce("test-state"),
+		"name":               "Ada Lovelace",
+		"preferred_username": "ada",
+		"email":              "ada@example.com",
+		"email_verified":     true,
+	}
+	for k, v := range idp.claims {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	return signRS256(t, idp.key, idp.kid, c)
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, c map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(c)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("SignPKCS1v15: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newProvider(idp *testIdP) *Provider {
+	return New(Config{
+		IssuerURL:    idp.server.URL,
+		ClientID:     "test-client",
+		ClientSecret: "test-secret",
+		Scopes:       []string{"profile", "email"},
+		CallbackURL:  "https://auth.example.com/callback/oidc",
+	})
+}
+
+func exchange(p *Provider) (*domain.AuthResult, error) {
+	return p.Exchange(context.Background(), map[string]string{"code": "good-code", "state": "test-state"})
+}
+
+func TestAuthURL(t *testing.T) {
+	idp := newTestIdP(t)
+	p := newProvider(idp)
+
+	authURL, err := p.AuthURL("test-state")
+	if err != nil {
+		t.Fatalf("AuthURL error: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("client_id") != "test-client" || q.Get("response_type") != "code" {
+		t.Errorf("unexpected auth URL: %s", authURL)
+	}
+	if q.Get("scope") != "openid profile email" {
+		t.Errorf("expected openid to be added to the scopes, got %q", q.Get("scope"))
+	}
+	if q.Get("nonce") != nonce("test-state") {
+		t.Errorf("expected nonce bound to the state token, got %q", q.Get("nonce"))
+	}
+}
+
+func TestSetScopes_KeepsOpenID(t *testing.T) {
+	idp := newTestIdP(t)
+	p := newProvider(idp)
+	p.SetScopes([]string{"profile"})
+
+	authURL, err := p.AuthURL("test-state")
+	if err != nil {
+		t.Fatalf("AuthURL error: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	if got := u.Query().Get("scope"); got != "openid profile" {
+		t.Errorf("expected openid to be kept, got %q", got)
+	}
+}
+
+func TestAuthURL_IssuerMismatch(t *testing.T) {
+	idp := newTestIdP(t)
+	p := New(Config{IssuerURL: idp.server.URL + "/", ClientID: "test-client"})
+
+	if _, err := p.AuthURL("test-state"); err == nil {
+		t.Fatal("expected discovery to reject a different issuer")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	idp := newTestIdP(t)
+	p := newProvider(idp)
+
+	result, err := exchange(p)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	want := domain.UserInfo{
+		ProviderName: "oidc",
+		ProviderID:   "user-123",
+		Username:     "ada",
+		DisplayName:  "Ada Lovelace",
+		Email:        "ada@example.com",
+	}
+	if result.User.ProviderName != want.ProviderName || result.User.ProviderID != want.ProviderID ||
+		result.User.Username != want.Username || result.User.DisplayName != want.DisplayName || result.User.Email != want.Email {
+		t.Errorf("got %+v, want %+v", result.User, want)
+	}
+	if !result.User.EmailVerified {
+		t.Error("expected email_verified to carry over")
+	}
+	if !strings.HasPrefix(idp.tokenAuth, "Basic ") {
+		t.Errorf("expected client_secret_basic, got Authorization %q", idp.tokenAuth)
+	}
+}
+
+func TestExchange_UnverifiedEmailDropped(t *testing.T) {
+	idp := newTestIdP(t)
+	idp.claims["email_verified"] = false
+	p := newProvider(idp)
+
+	result, err := exchange(p)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if result.User.Email != "" {
+		t.Errorf("expected unverified email to be dropped, got %q", result.User.Email)
+	}
+}
+
+func TestExchange_Userinfo(t *testing.T) {
+	idp := newTestIdP(t)
+	idp.claims = map[string]any{"name": nil, "preferred_username": nil, "email": nil, "email_verified": nil}
+	idp.userinfo = map[string]any{
+		"sub":                "user-123",
+		"preferred_username": "ada",
+		"picture":            "https://id.example.com/ada.png",
+		"email":              "ada@example.com",
+	}
+	p := newProvider(idp)
+
+	result, err := exchange(p)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if result.User.Username != "ada" || result.User.DisplayName != "ada" ||
+		result.User.AvatarURL != "https://id.example.com/ada.png" || result.User.Email != "ada@example.com" {
+		t.Errorf("expected claims from userinfo, got %+v", result.User)
+	}
+	if result.User.EmailVerified {
+		t.Error("expected an email without email_verified to read as unverified")
+	}
+	if !strings.Contains(string(result.User.Raw), "id.example.com/ada.png") {
+		t.Errorf("expected the userinfo response as raw, got %s", result.User.Raw)
+	}
+}
+
+func TestExchange_UserinfoSubjectMismatch(t *testing.T) {
+	idp := newTestIdP(t)
+	idp.userinfo = map[string]any{"sub": "someone-else"}
+	p := newProvider(idp)
+
+	if _, err := exchange(p); !errors.Is(err, domain.ErrProviderUserFetch) {
+		t.Errorf("expected ErrProviderUserFetch, got %v", err)
+	}
+}
+
+func TestExchange_InvalidIDToken(t *testing.T) {
+	tests := map[string]map[string]any{
+		"wrong issuer":    {"iss": "https://evil.example.com"},
+		"wrong audience":  {"aud": "other-client"},
+		"wrong azp":       {"aud": []string{"test-client", "other"}, "azp": "other"},
+		"expired":         {"exp": time.Now().Add(-time.Hour).Unix()},
+		"wrong nonce":     {"nonce": nonce("other-state")},
+		"missing nonce":   {"nonce": nil},
+		"missing subject": {"sub": nil},
+	}
+	for name, claims := range tests {
+		t.Run(name, func(t *testing.T) {
+			idp := newTestIdP(t)
+			idp.claims = claims
+			p := newProvider(idp)
+
+			if _, err := exchange(p); !errors.Is(err, domain.ErrInvalidIDToken) {
+				t.Errorf("expected ErrInvalidIDToken, got %v", err)
+			}
+		})
+	}
+}
+
+func TestExchange_MultipleAudiences(t *testing.T) {
+	idp := newTestIdP(t)
+	idp.claims = map[string]any{"aud": []string{"test-client", "api"}, "azp": "test-client"}
+	p := newProvider(idp)
+
+	if _, err := exchange(p); err != nil {
+		t.Errorf("Exchange error: %v", err)
+	}
+}
+
+func TestExchange_ForgedSignature(t *testing.T) {
+	idp := newTestIdP(t)
+	p := newProvider(idp)
+	if _, err := exchange(p); err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+
+	// A token signed by another key under the same key ID must not verify
+	idp.key, _ = rsa.GenerateKey(rand.Reader, 2048)
+	if _, err := exchange(p); !errors.Is(err, domain.ErrInvalidIDToken) {
+		t.Errorf("expected ErrInvalidIDToken, got %v", err)
+	}
+}
+
+func TestExchange_KeyRotation(t *testing.T) {
+	idp := newTestIdP(t)
+	p := newProvider(idp)
+	now := time.Now()
+	p.SetNow(func() time.Time { return now })
+	if _, err := exchange(p); err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+
+	idp.key, _ = rsa.GenerateKey(rand.Reader, 2048)
+	idp.kid = "k2"
+
+	// Unknown key IDs don't hammer the JWKS endpoint...
+	if _, err := exchange(p); !errors.Is(err, domain.ErrInvalidIDToken) {
+		t.Errorf("expected ErrInvalidIDToken within the refresh window, got %v", err)
+	}
+	if idp.jwksFetches != 1 {
+		t.Errorf("expected 1 JWKS fetch, got %d", idp.jwksFetches)
+	}
+
+	// ...but are picked up once it has passed
+	now = now.Add(minKeyRefresh)
+	if _, err := exchange(p); err != nil {
+		t.Fatalf("Exchange error after rotation: %v", err)
+	}
+	if idp.jwksFetches != 2 {
+		t.Errorf("expected 2 JWKS fetches, got %d", idp.jwksFetches)
+	}
+}
+
+func TestExchange_ErrorParam(t *testing.T) {
+	idp := newTestIdP(t)
+	p := newProvider(idp)
+
+	_, err := p.Exchange(context.Background(), map[string]string{"error": "access_denied", "state": "test-state"})
+	if !errors.Is(err, domain.ErrProviderExchange) {
+		t.Errorf("expected ErrProviderExchange, got %v", err)
+	}
+}
+
+func TestExchange_MissingParams(t *testing.T) {
+	idp := newTestIdP(t)
+	p := newProvider(idp)
+
+	if _, err := p.Exchange(context.Background(), map[string]string{"state": "test-state"}); !errors.Is(err, domain.ErrMissingProviderParams) {
+		t.Errorf("expected ErrMissingProviderParams, got %v", err)
+	}
+}
+
+func TestExchange_BadCode(t *testing.T) {
+	idp := newTestIdP(t)
+	p := newProvider(idp)
+
+	_, err := p.Exchange(context.Background(), map[string]string{"code": "bad-code", "state": "test-state"})
+	if !errors.Is(err, domain.ErrProviderExchange) {
+		t.Errorf("expected ErrProviderExchange, got %v", err)
+	}
+}
@@ -0,0 +1,321 @@
+package phone
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/captcha"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/hosted"
+	"github.com/BlackMission/centralauth/internal/pages"
+	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/ticket"
+)
+
+const (
+	providerName   = "phone"
+	defaultCodeTTL = 5 * time.Minute
+	defaultAppName = "CentralAuth"
+	maxAttempts    = 5
+)
+
+// Config holds phone provider settings.
+type Config struct {
+	BaseURL     string        // public URL of this service; pages are served under {base_url}/phone/
+	CallbackURL string        // The CentralAuth callback URL: {base_url}/callback/phone
+	CodeTTL     time.Duration // how long a texted code stays valid (5 minutes if zero)
+	AppName     string        // name used in the text message
+}
+
+// Provider authenticates users by texting a 6-digit code to their phone.
+//
+// The hosted page asks for a number and texts a code to it. The code is not
+// stored: the verify form carries a challenge, a ticket signed for the flow's
+// state token and a keyed hash of the code, so any replica can check it.
+// A correct code redirects to the callback with a ticket for the number,
+// which Exchange turns into a UserInfo keyed by the E.164 number.
+type Provider struct {
+	cfg        Config
+	gateway    Gateway
+	states     *state.Service
+	tickets    *ticket.Signer
+	challenges *ticket.Signer
+	codeKey    []byte
+	now        func() time.Time
+
+	captcha hosted.Captcha
+
+	mu       sync.Mutex
+	attempts map[string]*attempt // by challenge; per replica
+}
+
+type attempt struct {
+	failures  int
+	used      bool
+	expiresAt time.Time
+}
+
+// New creates a phone provider. codeKey authenticates challenges and must be
+// independent of the key tickets are signed with.
+func New(cfg Config, gateway Gateway, states *state.Service, tickets *ticket.Signer, codeKey []byte) *Provider {
+	if cfg.CodeTTL <= 0 {
+		cfg.CodeTTL = defaultCodeTTL
+	}
+	if cfg.AppName == "" {
+		cfg.AppName = defaultAppName
+	}
+	return &Provider{
+		cfg:        cfg,
+		gateway:    gateway,
+		states:     states,
+		tickets:    tickets,
+		challenges: ticket.NewSigner(codeKey, cfg.CodeTTL),
+		codeKey:    codeKey,
+		now:        time.Now,
+		attempts:   make(map[string]*attempt),
+	}
+}
+
+// SetNow overrides the time function (for testing).
+func (p *Provider) SetNow(fn func() time.Time) {
+	p.now = fn
+	p.challenges.SetNow(fn)
+}
+
+// SetCaptcha puts a CAPTCHA on the phone number form of flows whose client
+// requires one, so the form can't be scripted into sending texts.
+func (p *Provider) SetCaptcha(v *captcha.Verifier, required func(clientID string) bool) {
+	p.captcha.Set(v, required)
+}
+
+func (p *Provider) Name() string { return providerName }
+
+func (p *Provider) AuthURL(stateToken string) (string, error) {
+	return p.cfg.BaseURL + "/phone/login?" + url.Values{"state": {stateToken}}.Encode(), nil
+}
+
+func (p *Provider) Exchange(ctx context.Context, params map[string]string) (*domain.AuthResult, error) {
+	tkt, stateToken := params["ticket"], params["state"]
+	if tkt == "" || stateToken == "" {
+		return nil, domain.ErrMissingProviderParams
+	}
+
+	number, err := p.tickets.Verify(tkt, hosted.Audience(stateToken))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderExchange, err)
+	}
+
+	return &domain.AuthResult{User: domain.UserInfo{
+		ProviderName: providerName,
+		ProviderID:   number,
+		Username:     number,
+		DisplayName:  number,
+	}}, nil
+}
+
+// RegisterRoutes mounts the hosted phone number and code pages.
+func (p *Provider) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /phone/login", p.loginPage)
+	mux.HandleFunc("POST /phone/login", p.sendCode)
+	mux.HandleFunc("POST /phone/verify", p.verifyCode)
+}
+
+func (p *Provider) loginPage(w http.ResponseWriter, r *http.Request) {
+	stateToken := r.URL.Query().Get("state")
+	flow, ok := hosted.ValidState(w, p.states, providerName, stateToken)
+	if !ok {
+		return
+	}
+	p.renderLogin(w, http.StatusOK, flow, stateToken, "", "")
+}
+
+func (p *Provider) sendCode(w http.ResponseWriter, r *http.Request) {
+	stateToken := r.PostFormValue("state")
+	flow, ok := hosted.ValidState(w, p.states, providerName, stateToken)
+	if !ok {
+		return
+	}
+	input := strings.TrimSpace(r.PostFormValue("phone"))
+
+	if err := p.captcha.Verify(r, flow); err != nil {
+		p.renderLogin(w, http.StatusBadRequest, flow, stateToken, input, "Please complete the CAPTCHA.")
+		return
+	}
+	number, err := NormalizeNumber(input)
+	if err != nil {
+		p.renderLogin(w, http.StatusBadRequest, flow, stateToken, input, "Enter your number in international format, starting with + and the country code.")
+		return
+	}
+
+	code, err := newCode()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "phone: generating code failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	challenge, err := p.challenges.Sign(number, p.challengeAudience(stateToken, code))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "phone: signing challenge failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	msg := fmt.Sprintf("Your %s code is %s. It expires in %d minutes.", p.cfg.AppName, code, int(p.cfg.CodeTTL.Minutes()))
+	if err := p.gateway.Send(r.Context(), number, msg); err != nil {
+		slog.ErrorContext(r.Context(), "phone: sending code failed", "flow_id", flow.FlowID, "error", err)
+		p.renderLogin(w, http.StatusBadGateway, flow, stateToken, input, "We couldn't send a text to that number. Check it and try again.")
+		return
+	}
+	slog.InfoContext(r.Context(), "phone: code sent", "flow_id", flow.FlowID)
+
+	p.renderVerify(w, http.StatusOK, pages.PhoneVerify{State: stateToken, Challenge: challenge, Phone: number})
+}
+
+func (p *Provider) verifyCode(w http.ResponseWriter, r *http.Request) {
+	stateToken := r.PostFormValue("state")
+	flow, ok := hosted.ValidState(w, p.states, providerName, stateToken)
+	if !ok {
+		return
+	}
+	challenge := r.PostFormValue("challenge")
+	code := strings.TrimSpace(r.PostFormValue("code"))
+
+	number, err := p.checkCode(challenge, stateToken, code)
+	switch {
+	case err == nil:
+		p.redirectToCallback(w, r, stateToken, number)
+	case errors.Is(err, domain.ErrInvalidOTP):
+		p.renderVerify(w, http.StatusUnauthorized, pages.PhoneVerify{
+			State:     stateToken,
+			Challenge: challenge,
+			Phone:     number,
+			Error:     "That code is incorrect.",
+		})
+	case errors.Is(err, domain.ErrOTPAttempts):
+		slog.WarnContext(r.Context(), "phone: too many code attempts", "flow_id", flow.FlowID)
+		p.renderLogin(w, http.StatusTooManyRequests, flow, stateToken, number, "Too many incorrect codes. Request a new one.")
+	default:
+		// Expired or tampered with: start over with a new code.
+		p.renderLogin(w, http.StatusBadRequest, flow, stateToken, "", "That code has expired. Request a new one.")
+	}
+}
+
+// checkCode verifies code against challenge and returns the challenge's
+// phone number. A challenge allows maxAttempts wrong codes and one right one.
+func (p *Provider) checkCode(challenge, stateToken, code string) (string, error) {
+	number, err := p.challenges.Subject(challenge)
+	if err != nil {
+		return "", err
+	}
+	now := p.now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	a, ok := p.attempts[challenge]
+	if !ok {
+		p.sweep(now)
+		a = &attempt{expiresAt: now.Add(p.cfg.CodeTTL)}
+		p.attempts[challenge] = a
+	}
+	if a.used || a.failures >= maxAttempts {
+		return number, domain.ErrOTPAttempts
+	}
+	if _, err := p.challenges.Verify(challenge, p.challengeAudience(stateToken, code)); err != nil {
+		a.failures++
+		if a.failures >= maxAttempts {
+			return number, domain.ErrOTPAttempts
+		}
+		return number, domain.ErrInvalidOTP
+	}
+	a.used = true
+	return number, nil
+}
+
+// sweep drops expired attempt records. Callers must hold p.mu.
+func (p *Provider) sweep(now time.Time) {
+	for k, a := range p.attempts {
+		if now.After(a.expiresAt) {
+			delete(p.attempts, k)
+		}
+	}
+}
+
+// challengeAudience binds a challenge to the flow's state token and the code.
+func (p *Provider) challengeAudience(stateToken, code string) string {
+	mac := hmac.New(sha256.New, p.codeKey)
+	mac.Write([]byte("phone code\x00" + stateToken + "\x00" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *Provider) redirectToCallback(w http.ResponseWriter, r *http.Request, stateToken, number string) {
+	tkt, err := p.tickets.Sign(number, hosted.Audience(stateToken))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "phone: signing ticket failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	q := url.Values{"state": {stateToken}, "ticket": {tkt}}
+	http.Redirect(w, r, p.cfg.CallbackURL+"?"+q.Encode(), http.StatusSeeOther)
+}
+
+func (p *Provider) renderLogin(w http.ResponseWriter, status int, flow *domain.StatePayload, stateToken, phone, msg string) {
+	pages.Render(w, status, "phone_login.html", pages.PhoneLogin{
+		State:   stateToken,
+		Phone:   phone,
+		Error:   msg,
+		Captcha: p.captcha.For(flow),
+	})
+}
+
+func (p *Provider) renderVerify(w http.ResponseWriter, status int, data pages.PhoneVerify) {
+	data.RestartURL = "login?" + url.Values{"state": {data.State}}.Encode()
+	pages.Render(w, status, "phone_verify.html", data)
+}
+
+// NormalizeNumber converts a phone number typed in international format to
+// E.164 (+ followed by up to 15 digits). Spaces, dots, dashes, and
+// parentheses are ignored, and a leading 00 is read as +.
+func NormalizeNumber(s string) (string, error) {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '.', '-', '(', ')':
+			return -1
+		}
+		return r
+	}, s)
+	if rest, ok := strings.CutPrefix(s, "00"); ok {
+		s = "+" + rest
+	}
+
+	digits, ok := strings.CutPrefix(s, "+")
+	if !ok || len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return "", domain.ErrInvalidPhoneNumber
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return "", domain.ErrInvalidPhoneNumber
+		}
+	}
+	return "+" + digits, nil
+}
+
+func newCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
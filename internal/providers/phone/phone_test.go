@@ -0,0 +1,245 @@
+package phone
+
+import (
+	"context"
+	"errors"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/ticket"
+)
+
+// fakeGateway records the last message sent.
+type fakeGateway struct {
+	to, message string
+	err         error
+}
+
+func (g *fakeGateway) Send(_ context.Context, to, message string) error {
+	g.to, g.message = to, message
+	return g.err
+}
+
+var (
+	codeRe      = regexp.MustCompile(`code is (\d{6})`)
+	challengeRe = regexp.MustCompile(`name="challenge" value="([^"]+)"`)
+)
+
+func setupProvider(t *testing.T) (*Provider, *state.Service, *fakeGateway, http.Handler) {
+	t.Helper()
+	states := state.NewService([]byte("test-key-1234567890abcdef"))
+	gw := &fakeGateway{}
+	p := New(Config{
+		BaseURL:     "https://auth.example.com",
+		CallbackURL: "https://auth.example.com/callback/phone",
+		AppName:     "BlackMission",
+	}, gw, states, ticket.NewSigner([]byte("ticket-key"), 0), []byte("code-key"))
+
+	mux := http.NewServeMux()
+	p.RegisterRoutes(mux)
+	return p, states, gw, mux
+}
+
+func newState(t *testing.T, states *state.Service) string {
+	t.Helper()
+	tok, err := states.Generate(domain.StatePayload{ClientID: "website", Provider: "phone"})
+	if err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	return tok
+}
+
+func postForm(h http.Handler, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+// requestCode submits a number and returns the texted code and the challenge.
+func requestCode(t *testing.T, h http.Handler, gw *fakeGateway, stateToken string) (string, string) {
+	t.Helper()
+	rr := postForm(h, "/phone/login", url.Values{"state": {stateToken}, "phone": {"+44 7700 900123"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d (body: %s)", rr.Code, rr.Body.String())
+	}
+	if gw.to != "+447700900123" {
+		t.Fatalf("code sent to %q, want +447700900123", gw.to)
+	}
+	code := codeRe.FindStringSubmatch(gw.message)
+	challenge := challengeRe.FindStringSubmatch(rr.Body.String())
+	if code == nil || challenge == nil {
+		t.Fatalf("missing code or challenge (message: %q)", gw.message)
+	}
+	return code[1], html.UnescapeString(challenge[1])
+}
+
+func verify(h http.Handler, stateToken, challenge, code string) *httptest.ResponseRecorder {
+	return postForm(h, "/phone/verify", url.Values{"state": {stateToken}, "challenge": {challenge}, "code": {code}})
+}
+
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func TestAuthURL(t *testing.T) {
+	p, _, _, _ := setupProvider(t)
+	u, _ := p.AuthURL("abc")
+	if u != "https://auth.example.com/phone/login?state=abc" {
+		t.Errorf("unexpected AuthURL: %s", u)
+	}
+}
+
+func TestLoginThenExchange(t *testing.T) {
+	p, states, gw, h := setupProvider(t)
+	stateToken := newState(t, states)
+	code, challenge := requestCode(t, h, gw, stateToken)
+	if !strings.HasPrefix(gw.message, "Your BlackMission code is") {
+		t.Errorf("unexpected message: %q", gw.message)
+	}
+
+	rr := verify(h, stateToken, challenge, code)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d (body: %s)", rr.Code, rr.Body.String())
+	}
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	if loc.Path != "/callback/phone" || loc.Query().Get("state") != stateToken {
+		t.Fatalf("unexpected redirect: %s", loc)
+	}
+
+	result, err := p.Exchange(context.Background(), map[string]string{
+		"state":  stateToken,
+		"ticket": loc.Query().Get("ticket"),
+	})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if result.User.ProviderName != "phone" || result.User.ProviderID != "+447700900123" {
+		t.Errorf("unexpected user: %+v", result.User)
+	}
+}
+
+func TestVerify_WrongCode(t *testing.T) {
+	_, states, gw, h := setupProvider(t)
+	stateToken := newState(t, states)
+	code, challenge := requestCode(t, h, gw, stateToken)
+
+	if rr := verify(h, stateToken, challenge, wrongCode(code)); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+	if rr := verify(h, stateToken, challenge, code); rr.Code != http.StatusSeeOther {
+		t.Errorf("expected the right code to still work, got %d", rr.Code)
+	}
+}
+
+func TestVerify_TooManyAttempts(t *testing.T) {
+	_, states, gw, h := setupProvider(t)
+	stateToken := newState(t, states)
+	code, challenge := requestCode(t, h, gw, stateToken)
+
+	for range maxAttempts {
+		verify(h, stateToken, challenge, wrongCode(code))
+	}
+	if rr := verify(h, stateToken, challenge, code); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 after %d wrong codes, got %d", maxAttempts, rr.Code)
+	}
+}
+
+func TestVerify_SingleUse(t *testing.T) {
+	_, states, gw, h := setupProvider(t)
+	stateToken := newState(t, states)
+	code, challenge := requestCode(t, h, gw, stateToken)
+
+	verify(h, stateToken, challenge, code)
+	if rr := verify(h, stateToken, challenge, code); rr.Code == http.StatusSeeOther {
+		t.Error("expected a used code to be rejected")
+	}
+}
+
+func TestVerify_OtherFlow(t *testing.T) {
+	_, states, gw, h := setupProvider(t)
+	code, challenge := requestCode(t, h, gw, newState(t, states))
+
+	if rr := verify(h, newState(t, states), challenge, code); rr.Code == http.StatusSeeOther {
+		t.Error("expected a challenge from another flow to be rejected")
+	}
+}
+
+func TestVerify_Expired(t *testing.T) {
+	p, states, gw, h := setupProvider(t)
+	now := time.Now()
+	p.SetNow(func() time.Time { return now })
+	stateToken := newState(t, states)
+	code, challenge := requestCode(t, h, gw, stateToken)
+
+	now = now.Add(defaultCodeTTL + time.Second)
+	if rr := verify(h, stateToken, challenge, code); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an expired code, got %d", rr.Code)
+	}
+}
+
+func TestSendCode_GatewayError(t *testing.T) {
+	_, states, gw, h := setupProvider(t)
+	gw.err = domain.ErrSMSDelivery
+
+	rr := postForm(h, "/phone/login", url.Values{"state": {newState(t, states)}, "phone": {"+447700900123"}})
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("expected 502, got %d", rr.Code)
+	}
+}
+
+func TestSendCode_InvalidNumber(t *testing.T) {
+	_, states, gw, h := setupProvider(t)
+
+	rr := postForm(h, "/phone/login", url.Values{"state": {newState(t, states)}, "phone": {"07700 900123"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rr.Code)
+	}
+	if gw.to != "" {
+		t.Error("expected no text to be sent")
+	}
+}
+
+func TestNormalizeNumber(t *testing.T) {
+	tests := map[string]string{
+		"+1 (415) 555-0100": "+14155550100",
+		"0044 7700 900123":  "+447700900123",
+		"+49.30.1234567":    "+49301234567",
+		"4155550100":        "",
+		"+0123456789":       "",
+		"+1415555abcd":      "",
+		"+1234567":          "",
+		"+1234567890123456": "",
+	}
+	for in, want := range tests {
+		got, err := NormalizeNumber(in)
+		if want == "" {
+			if !errors.Is(err, domain.ErrInvalidPhoneNumber) {
+				t.Errorf("NormalizeNumber(%q) = %q, %v; want ErrInvalidPhoneNumber", in, got, err)
+			}
+			continue
+		}
+		if got != want || err != nil {
+			t.Errorf("NormalizeNumber(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+}
+
+func TestExchange_MissingParams(t *testing.T) {
+	p, _, _, _ := setupProvider(t)
+	if _, err := p.Exchange(context.Background(), map[string]string{"state": "abc"}); !errors.Is(err, domain.ErrMissingProviderParams) {
+		t.Errorf("expected ErrMissingProviderParams, got %v", err)
+	}
+}
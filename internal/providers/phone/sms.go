@@ -0,0 +1,119 @@
+package phone
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+const twilioBaseURL = "https://api.twilio.com"
+
+// Gateway delivers text messages to E.164 phone numbers.
+type Gateway interface {
+	Send(ctx context.Context, to, message string) error
+}
+
+// Twilio sends messages with Twilio's Programmable Messaging API.
+type Twilio struct {
+	accountSID string
+	authToken  string
+	from       string
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewTwilio creates a Twilio gateway sending from the given number or
+// messaging service SID.
+func NewTwilio(accountSID, authToken, from string) *Twilio {
+	return &Twilio{
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		baseURL:    twilioBaseURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SetBaseURL overrides the Twilio API URL (for testing).
+func (t *Twilio) SetBaseURL(u string) {
+	t.baseURL = u
+}
+
+func (t *Twilio) Send(ctx context.Context, to, message string) error {
+	form := url.Values{"To": {to}, "Body": {message}}
+	if strings.HasPrefix(t.from, "MG") {
+		form.Set("MessagingServiceSid", t.from)
+	} else {
+		form.Set("From", t.from)
+	}
+
+	endpoint := t.baseURL + "/2010-04-01/Accounts/" + url.PathEscape(t.accountSID) + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("%w: creating request: %v", domain.ErrSMSDelivery, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.accountSID, t.authToken)
+
+	return send(t.httpClient, req)
+}
+
+// Webhook posts messages as JSON to an operator-run SMS relay:
+//
+//	{"to": "+14155550100", "message": "..."}
+//
+// Any 2xx response counts as delivered.
+type Webhook struct {
+	url        string
+	token      string
+	httpClient *http.Client
+}
+
+// NewWebhook creates a webhook gateway. If token is set it is sent as a
+// bearer token.
+func NewWebhook(url, token string) *Webhook {
+	return &Webhook{
+		url:        url,
+		token:      token,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (wh *Webhook) Send(ctx context.Context, to, message string) error {
+	body, err := json.Marshal(map[string]string{"to": to, "message": message})
+	if err != nil {
+		return fmt.Errorf("%w: %v", domain.ErrSMSDelivery, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: creating request: %v", domain.ErrSMSDelivery, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wh.token != "" {
+		req.Header.Set("Authorization", "Bearer "+wh.token)
+	}
+
+	return send(wh.httpClient, req)
+}
+
+func send(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", domain.ErrSMSDelivery, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%w: status %d: %s", domain.ErrSMSDelivery, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
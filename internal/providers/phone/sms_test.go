@@ -0,0 +1,97 @@
+package phone
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func TestTwilio_Send(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if user, pass, _ := r.BasicAuth(); user != "AC123" || pass != "token" {
+			t.Errorf("unexpected credentials: %s:%s", user, pass)
+		}
+		if r.PostFormValue("To") != "+14155550100" || r.PostFormValue("From") != "+14155550199" || r.PostFormValue("Body") != "hello" {
+			t.Errorf("unexpected form: %v", r.PostForm)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	tw := NewTwilio("AC123", "token", "+14155550199")
+	tw.SetBaseURL(srv.URL)
+	if err := tw.Send(context.Background(), "+14155550100", "hello"); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+}
+
+func TestTwilio_MessagingService(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("MessagingServiceSid") != "MG456" || r.PostFormValue("From") != "" {
+			t.Errorf("unexpected form: %v", r.PostForm)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	tw := NewTwilio("AC123", "token", "MG456")
+	tw.SetBaseURL(srv.URL)
+	if err := tw.Send(context.Background(), "+14155550100", "hello"); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+}
+
+func TestTwilio_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"code":21211,"message":"Invalid 'To' Phone Number"}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	tw := NewTwilio("AC123", "token", "+14155550199")
+	tw.SetBaseURL(srv.URL)
+	if err := tw.Send(context.Background(), "+14155550100", "hello"); !errors.Is(err, domain.ErrSMSDelivery) {
+		t.Errorf("expected ErrSMSDelivery, got %v", err)
+	}
+}
+
+func TestWebhook_Send(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer relay-token" {
+			t.Errorf("unexpected Authorization: %q", r.Header.Get("Authorization"))
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		if body["to"] != "+14155550100" || body["message"] != "hello" {
+			t.Errorf("unexpected body: %v", body)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	wh := NewWebhook(srv.URL, "relay-token")
+	if err := wh.Send(context.Background(), "+14155550100", "hello"); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+}
+
+func TestWebhook_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	wh := NewWebhook(srv.URL, "")
+	if err := wh.Send(context.Background(), "+14155550100", "hello"); !errors.Is(err, domain.ErrSMSDelivery) {
+		t.Errorf("expected ErrSMSDelivery, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package reddit
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/outbound"
+)
+
+const (
+	providerName        = "reddit"
+	defaultAuthEndpoint = "https://www.reddit.com/api/v1/authorize"
+	defaultTokenURL     = "https://www.reddit.com/api/v1/access_token"
+	defaultUserURL      = "https://oauth.reddit.com/api/v1/me"
+	defaultUserAgent    = "web:centralauth:1.0"
+)
+
+// Config holds Reddit OAuth2 settings.
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	CallbackURL  string // The CentralAuth callback URL: {base_url}/callback/reddit
+
+	// UserAgent identifies the app to Reddit, which throttles or blocks
+	// generic agents. Reddit asks for <platform>:<app ID>:<version> (by /u/<username>).
+	UserAgent string
+
+	// HTTPClient makes the calls to Reddit's API (http.DefaultClient if nil).
+	HTTPClient *http.Client
+}
+
+// Provider implements OAuth2 for Reddit.
+type Provider struct {
+	cfg          Config
+	httpClient   *http.Client
+	scopes       atomic.Pointer[[]string]
+	authEndpoint string
+	tokenURL     string
+	userURL      string
+}
+
+// New creates a Reddit provider.
+func New(cfg Config) *Provider {
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = defaultUserAgent
+	}
+	p := &Provider{
+		cfg:          cfg,
+		httpClient:   cmp.Or(cfg.HTTPClient, http.DefaultClient),
+		authEndpoint: defaultAuthEndpoint,
+		tokenURL:     defaultTokenURL,
+		userURL:      defaultUserURL,
+	}
+	p.SetScopes(cfg.Scopes)
+	return p
+}
+
+func (p *Provider) Name() string { return providerName }
+
+// SetScopes replaces the scopes requested from now on. Flows already at
+// the provider keep the scopes they asked for.
+func (p *Provider) SetScopes(scopes []string) {
+	p.scopes.Store(&scopes)
+}
+
+// Probe checks that Reddit's token and user endpoints are reachable.
+func (p *Provider) Probe(ctx context.Context) error {
+	return outbound.Probe(ctx, p.httpClient, p.tokenURL, p.userURL)
+}
+
+func (p *Provider) AuthURL(stateToken string) (string, error) {
+	params := url.Values{
+		"client_id":     {p.cfg.ClientID},
+		"redirect_uri":  {p.cfg.CallbackURL},
+		"response_type": {"code"},
+		"duration":      {"temporary"}, // we never need a refresh token
+		"scope":         {strings.Join(*p.scopes.Load(), " ")},
+		"state":         {stateToken},
+	}
+	return p.authEndpoint + "?" + params.Encode(), nil
+}
+
+func (p *Provider) Exchange(ctx context.Context, params map[string]string) (*domain.AuthResult, error) {
+	if e := params["error"]; e != "" {
+		return nil, fmt.Errorf("%w: %s", domain.ErrProviderExchange, e)
+	}
+	code, ok := params["code"]
+	if !ok || code == "" {
+		return nil, domain.ErrMissingProviderParams
+	}
+
+	token, err := p.exchangeCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := p.fetchUser(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.AuthResult{User: *user}, nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	Error       string `json:"error"`
+}
+
+func (p *Provider) exchangeCode(ctx context.Context, code string) (string, error) {
+	data := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.cfg.CallbackURL},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", p.cfg.UserAgent)
+	// Reddit only accepts client credentials as HTTP basic auth
+	req.SetBasicAuth(p.cfg.ClientID, p.cfg.ClientSecret)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", domain.ErrProviderExchange, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%w: reading response: %v", domain.ErrProviderExchange, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: status %d: %s", domain.ErrProviderExchange, resp.StatusCode, body)
+	}
+
+	var tokenResp tokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderExchange, err)
+	}
+
+	// Reddit reports a bad code as 200 with an error field
+	if tokenResp.Error != "" {
+		return "", fmt.Errorf("%w: %s", domain.ErrProviderExchange, tokenResp.Error)
+	}
+	if tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("%w: empty access token", domain.ErrProviderExchange)
+	}
+
+	return tokenResp.AccessToken, nil
+}
+
+type redditUser struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	IconImg      string `json:"icon_img"`
+	SnoovatarImg string `json:"snoovatar_img"`
+}
+
+func (p *Provider) fetchUser(ctx context.Context, accessToken string) (*domain.UserInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.userURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating user request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("User-Agent", p.cfg.UserAgent)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderUserFetch, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading response: %v", domain.ErrProviderUserFetch, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d: %s", domain.ErrProviderUserFetch, resp.StatusCode, body)
+	}
+
+	var ru redditUser
+	if err := json.Unmarshal(body, &ru); err != nil {
+		return nil, fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderUserFetch, err)
+	}
+	if ru.ID == "" {
+		return nil, fmt.Errorf("%w: response has no user ID", domain.ErrProviderUserFetch)
+	}
+
+	avatar := ru.SnoovatarImg
+	if avatar == "" {
+		avatar = ru.IconImg
+	}
+
+	// Reddit has no separate display name and never shares email addresses
+	return &domain.UserInfo{
+		ProviderName: providerName,
+		ProviderID:   ru.ID,
+		Username:     ru.Name,
+		DisplayName:  ru.Name,
+		AvatarURL:    html.UnescapeString(avatar), // image URLs come HTML-escaped (&amp;)
+		Raw:          body,
+	}, nil
+}
@@ -0,0 +1,155 @@
+package reddit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func setupTestProvider(t *testing.T, tokenHandler, userHandler http.HandlerFunc) *Provider {
+	t.Helper()
+	mux := http.NewServeMux()
+	if tokenHandler != nil {
+		mux.HandleFunc("/api/v1/access_token", tokenHandler)
+	}
+	if userHandler != nil {
+		mux.HandleFunc("/api/v1/me", userHandler)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	p := New(Config{
+		ClientID:     "test-client-id",
+		ClientSecret: "test-client-secret",
+		Scopes:       []string{"identity"},
+		CallbackURL:  "https://auth.example.com/callback/reddit",
+		UserAgent:    "web:test:1.0 (by /u/tester)",
+	})
+	p.httpClient = server.Client()
+	p.tokenURL = server.URL + "/api/v1/access_token"
+	p.userURL = server.URL + "/api/v1/me"
+	return p
+}
+
+func okToken(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(tokenResponse{AccessToken: "test-access-token", TokenType: "bearer"})
+}
+
+func TestAuthURL_ContainsCorrectParams(t *testing.T) {
+	p := New(Config{
+		ClientID:    "test-client-id",
+		Scopes:      []string{"identity"},
+		CallbackURL: "https://auth.example.com/callback/reddit",
+	})
+
+	authURL, err := p.AuthURL("test-state-token")
+	if err != nil {
+		t.Fatalf("AuthURL error: %v", err)
+	}
+	q, _ := url.Parse(authURL)
+	if got := q.Query().Get("duration"); got != "temporary" {
+		t.Errorf("expected duration 'temporary', got %q", got)
+	}
+	if got := q.Query().Get("scope"); got != "identity" {
+		t.Errorf("expected scope 'identity', got %q", got)
+	}
+	if got := q.Query().Get("state"); got != "test-state-token" {
+		t.Errorf("expected state 'test-state-token', got %q", got)
+	}
+}
+
+func TestExchange_Success(t *testing.T) {
+	p := setupTestProvider(t,
+		func(w http.ResponseWriter, r *http.Request) {
+			id, secret, ok := r.BasicAuth()
+			if !ok || id != "test-client-id" || secret != "test-client-secret" {
+				t.Error("expected client credentials as basic auth")
+			}
+			if r.UserAgent() != "web:test:1.0 (by /u/tester)" {
+				t.Errorf("unexpected token request User-Agent %q", r.UserAgent())
+			}
+			okToken(w, r)
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer test-access-token" {
+				t.Error("expected Bearer token in Authorization header")
+			}
+			if r.UserAgent() != "web:test:1.0 (by /u/tester)" {
+				t.Errorf("unexpected user request User-Agent %q", r.UserAgent())
+			}
+			w.Write([]byte(`{"id": "abc12", "name": "spez", "icon_img": "https://styles.redditmedia.com/icon.png?width=256&amp;s=x"}`))
+		},
+	)
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	user := result.User
+	if user.ProviderName != "reddit" || user.ProviderID != "abc12" || user.Username != "spez" || user.DisplayName != "spez" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	if user.AvatarURL != "https://styles.redditmedia.com/icon.png?width=256&s=x" {
+		t.Errorf("expected unescaped avatar URL, got %q", user.AvatarURL)
+	}
+}
+
+func TestExchange_PrefersSnoovatar(t *testing.T) {
+	p := setupTestProvider(t, okToken, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": "abc12", "name": "spez", "icon_img": "https://i.redd.it/icon.png", "snoovatar_img": "https://i.redd.it/snoo.png"}`))
+	})
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if result.User.AvatarURL != "https://i.redd.it/snoo.png" {
+		t.Errorf("expected snoovatar, got %q", result.User.AvatarURL)
+	}
+}
+
+func TestExchange_TokenErrorInBody(t *testing.T) {
+	p := setupTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error": "invalid_grant"}`))
+	}, nil)
+
+	_, err := p.Exchange(context.Background(), map[string]string{"code": "bad-code"})
+	if !errors.Is(err, domain.ErrProviderExchange) {
+		t.Errorf("expected ErrProviderExchange, got %v", err)
+	}
+}
+
+func TestExchange_UserFetchFailure(t *testing.T) {
+	p := setupTestProvider(t, okToken, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	_, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if !errors.Is(err, domain.ErrProviderUserFetch) {
+		t.Errorf("expected ErrProviderUserFetch, got %v", err)
+	}
+}
+
+func TestExchange_AccessDenied(t *testing.T) {
+	p := New(Config{})
+
+	_, err := p.Exchange(context.Background(), map[string]string{"error": "access_denied"})
+	if !errors.Is(err, domain.ErrProviderExchange) {
+		t.Errorf("expected ErrProviderExchange, got %v", err)
+	}
+}
+
+func TestExchange_MissingCode(t *testing.T) {
+	p := New(Config{})
+
+	_, err := p.Exchange(context.Background(), map[string]string{})
+	if !errors.Is(err, domain.ErrMissingProviderParams) {
+		t.Errorf("expected ErrMissingProviderParams, got %v", err)
+	}
+}
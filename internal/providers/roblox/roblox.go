@@ -0,0 +1,203 @@
+package roblox
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/outbound"
+)
+
+const (
+	providerName        = "roblox"
+	defaultAuthEndpoint = "https://apis.roblox.com/oauth/v1/authorize"
+	defaultTokenURL     = "https://apis.roblox.com/oauth/v1/token"
+	defaultUserURL      = "https://apis.roblox.com/oauth/v1/userinfo"
+)
+
+// Config holds Roblox OAuth 2.0 settings.
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	CallbackURL  string // The CentralAuth callback URL: {base_url}/callback/roblox
+
+	// HTTPClient makes the calls to Roblox's API (http.DefaultClient if nil).
+	HTTPClient *http.Client
+}
+
+// Provider implements Roblox's OAuth 2.0 / OpenID Connect sign-in.
+type Provider struct {
+	cfg          Config
+	httpClient   *http.Client
+	scopes       atomic.Pointer[[]string]
+	authEndpoint string
+	tokenURL     string
+	userURL      string
+}
+
+// New creates a Roblox provider.
+func New(cfg Config) *Provider {
+	p := &Provider{
+		cfg:          cfg,
+		httpClient:   cmp.Or(cfg.HTTPClient, http.DefaultClient),
+		authEndpoint: defaultAuthEndpoint,
+		tokenURL:     defaultTokenURL,
+		userURL:      defaultUserURL,
+	}
+	p.SetScopes(cfg.Scopes)
+	return p
+}
+
+func (p *Provider) Name() string { return providerName }
+
+// SetScopes replaces the scopes requested from now on. Flows already at
+// the provider keep the scopes they asked for.
+func (p *Provider) SetScopes(scopes []string) {
+	p.scopes.Store(&scopes)
+}
+
+// Probe checks that Roblox's token and userinfo endpoints are reachable.
+func (p *Provider) Probe(ctx context.Context) error {
+	return outbound.Probe(ctx, p.httpClient, p.tokenURL, p.userURL)
+}
+
+func (p *Provider) AuthURL(stateToken string) (string, error) {
+	params := url.Values{
+		"client_id":     {p.cfg.ClientID},
+		"redirect_uri":  {p.cfg.CallbackURL},
+		"response_type": {"code"},
+		"scope":         {strings.Join(*p.scopes.Load(), " ")},
+		"state":         {stateToken},
+	}
+	return p.authEndpoint + "?" + params.Encode(), nil
+}
+
+func (p *Provider) Exchange(ctx context.Context, params map[string]string) (*domain.AuthResult, error) {
+	if e := params["error"]; e != "" {
+		return nil, fmt.Errorf("%w: %s: %s", domain.ErrProviderExchange, e, params["error_description"])
+	}
+	code, ok := params["code"]
+	if !ok || code == "" {
+		return nil, domain.ErrMissingProviderParams
+	}
+
+	token, err := p.exchangeCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := p.fetchUser(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.AuthResult{User: *user}, nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+}
+
+func (p *Provider) exchangeCode(ctx context.Context, code string) (string, error) {
+	data := url.Values{
+		"client_id":     {p.cfg.ClientID},
+		"client_secret": {p.cfg.ClientSecret},
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", domain.ErrProviderExchange, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%w: reading response: %v", domain.ErrProviderExchange, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: status %d: %s", domain.ErrProviderExchange, resp.StatusCode, body)
+	}
+
+	var tokenResp tokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderExchange, err)
+	}
+
+	if tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("%w: empty access token", domain.ErrProviderExchange)
+	}
+
+	return tokenResp.AccessToken, nil
+}
+
+// robloxUser is the userinfo response. Without the profile scope only sub is set.
+type robloxUser struct {
+	Sub               string `json:"sub"`
+	PreferredUsername string `json:"preferred_username"`
+	Nickname          string `json:"nickname"`
+	Picture           string `json:"picture"`
+}
+
+func (p *Provider) fetchUser(ctx context.Context, accessToken string) (*domain.UserInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.userURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating user request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderUserFetch, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading response: %v", domain.ErrProviderUserFetch, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d: %s", domain.ErrProviderUserFetch, resp.StatusCode, body)
+	}
+
+	var ru robloxUser
+	if err := json.Unmarshal(body, &ru); err != nil {
+		return nil, fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderUserFetch, err)
+	}
+	if ru.Sub == "" {
+		return nil, fmt.Errorf("%w: response has no user ID", domain.ErrProviderUserFetch)
+	}
+
+	displayName := ru.Nickname
+	if displayName == "" {
+		displayName = ru.PreferredUsername
+	}
+
+	// sub is the numeric Roblox user ID; Roblox never shares email addresses
+	return &domain.UserInfo{
+		ProviderName: providerName,
+		ProviderID:   ru.Sub,
+		Username:     ru.PreferredUsername,
+		DisplayName:  displayName,
+		AvatarURL:    ru.Picture,
+		Raw:          body,
+	}, nil
+}
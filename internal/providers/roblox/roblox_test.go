@@ -0,0 +1,138 @@
+package roblox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func setupTestProvider(t *testing.T, tokenHandler, userHandler http.HandlerFunc) *Provider {
+	t.Helper()
+	mux := http.NewServeMux()
+	if tokenHandler != nil {
+		mux.HandleFunc("/oauth/v1/token", tokenHandler)
+	}
+	if userHandler != nil {
+		mux.HandleFunc("/oauth/v1/userinfo", userHandler)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	p := New(Config{
+		ClientID:     "test-client-id",
+		ClientSecret: "test-client-secret",
+		Scopes:       []string{"openid", "profile"},
+		CallbackURL:  "https://auth.example.com/callback/roblox",
+	})
+	p.httpClient = server.Client()
+	p.tokenURL = server.URL + "/oauth/v1/token"
+	p.userURL = server.URL + "/oauth/v1/userinfo"
+	return p
+}
+
+func okToken(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(tokenResponse{AccessToken: "test-access-token", TokenType: "Bearer"})
+}
+
+func TestAuthURL_ContainsCorrectParams(t *testing.T) {
+	p := New(Config{
+		ClientID:    "test-client-id",
+		Scopes:      []string{"openid", "profile"},
+		CallbackURL: "https://auth.example.com/callback/roblox",
+	})
+
+	authURL, err := p.AuthURL("test-state-token")
+	if err != nil {
+		t.Fatalf("AuthURL error: %v", err)
+	}
+	q, _ := url.Parse(authURL)
+	if got := q.Query().Get("scope"); got != "openid profile" {
+		t.Errorf("expected scope 'openid profile', got %q", got)
+	}
+	if got := q.Query().Get("state"); got != "test-state-token" {
+		t.Errorf("expected state 'test-state-token', got %q", got)
+	}
+}
+
+func TestExchange_Success(t *testing.T) {
+	p := setupTestProvider(t,
+		func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			if r.PostForm.Get("client_secret") != "test-client-secret" || r.PostForm.Get("code") != "auth-code" {
+				t.Errorf("unexpected token request: %v", r.PostForm)
+			}
+			okToken(w, r)
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer test-access-token" {
+				t.Error("expected Bearer token in Authorization header")
+			}
+			w.Write([]byte(`{"sub": "1516563360", "name": "Builderman", "nickname": "Builder Man",
+				"preferred_username": "builderman", "picture": "https://tr.rbxcdn.com/avatar.png"}`))
+		},
+	)
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	user := result.User
+	if user.ProviderName != "roblox" || user.ProviderID != "1516563360" || user.Username != "builderman" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	if user.DisplayName != "Builder Man" || user.AvatarURL != "https://tr.rbxcdn.com/avatar.png" {
+		t.Errorf("unexpected profile: %+v", user)
+	}
+}
+
+func TestExchange_FallbackDisplayName(t *testing.T) {
+	p := setupTestProvider(t, okToken, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"sub": "1516563360", "preferred_username": "builderman"}`))
+	})
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if result.User.DisplayName != "builderman" {
+		t.Errorf("expected display name to fall back to username, got %q", result.User.DisplayName)
+	}
+}
+
+func TestExchange_TokenFailure(t *testing.T) {
+	p := setupTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+	}, nil)
+
+	_, err := p.Exchange(context.Background(), map[string]string{"code": "bad-code"})
+	if !errors.Is(err, domain.ErrProviderExchange) {
+		t.Errorf("expected ErrProviderExchange, got %v", err)
+	}
+}
+
+func TestExchange_UserFetchFailure(t *testing.T) {
+	p := setupTestProvider(t, okToken, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	_, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code"})
+	if !errors.Is(err, domain.ErrProviderUserFetch) {
+		t.Errorf("expected ErrProviderUserFetch, got %v", err)
+	}
+}
+
+func TestExchange_MissingCode(t *testing.T) {
+	p := New(Config{})
+
+	_, err := p.Exchange(context.Background(), map[string]string{})
+	if !errors.Is(err, domain.ErrMissingProviderParams) {
+		t.Errorf("expected ErrMissingProviderParams, got %v", err)
+	}
+}
@@ -1,31 +1,60 @@
 package steam
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/outbound"
 )
 
 const (
 	providerName            = "steam"
 	defaultOpenIDEndpoint   = "https://steamcommunity.com/openid/login"
 	defaultPlayerSummaryURL = "https://api.steampowered.com/ISteamUser/GetPlayerSummaries/v2/"
+	defaultPlayerBansURL    = "https://api.steampowered.com/ISteamUser/GetPlayerBans/v1/"
+	defaultOwnedGamesURL    = "https://api.steampowered.com/IPlayerService/GetOwnedGames/v1/"
+	defaultAuthTicketURL    = "https://api.steampowered.com/ISteamUserAuth/AuthenticateUserTicket/v1/"
+
+	maxTicketLength = 4096 // hex characters; real tickets are a few hundred
 )
 
-var steamIDRegex = regexp.MustCompile(`https?://steamcommunity\.com/openid/id/(\d+)`)
+var (
+	steamIDRegex = regexp.MustCompile(`https?://steamcommunity\.com/openid/id/(\d+)`)
+	ticketRegex  = regexp.MustCompile(`^[0-9A-Fa-f]+$`)
+)
 
 // Config holds Steam OpenID settings.
 type Config struct {
 	APIKey      string
 	Realm       string // e.g. https://auth.blackmission.com
 	CallbackURL string // {base_url}/callback/steam
+
+	// Extras makes Exchange report Steam extras. With AppID set, they say
+	// whether the player owns that app, how long they have played it, and
+	// whether they are playing it now.
+	Extras bool
+
+	// FetchBans makes Exchange look up the player's bans for the extras,
+	// whether or not Extras is set.
+	FetchBans bool
+
+	// AppID is the game's Steam app ID. Session tickets can only be
+	// validated for it, with a publisher Web API key in APIKey.
+	AppID string
+
+	// HTTPClient makes the calls to the Steam Web API (http.DefaultClient if nil).
+	HTTPClient *http.Client
 }
 
 // Provider implements OpenID 2.0 for Steam.
@@ -34,20 +63,31 @@ type Provider struct {
 	httpClient       *http.Client
 	openIDEndpoint   string
 	playerSummaryURL string
+	playerBansURL    string
+	ownedGamesURL    string
+	authTicketURL    string
 }
 
 // New creates a Steam provider.
 func New(cfg Config) *Provider {
 	return &Provider{
 		cfg:              cfg,
-		httpClient:       http.DefaultClient,
+		httpClient:       cmp.Or(cfg.HTTPClient, http.DefaultClient),
 		openIDEndpoint:   defaultOpenIDEndpoint,
 		playerSummaryURL: defaultPlayerSummaryURL,
+		playerBansURL:    defaultPlayerBansURL,
+		ownedGamesURL:    defaultOwnedGamesURL,
+		authTicketURL:    defaultAuthTicketURL,
 	}
 }
 
 func (p *Provider) Name() string { return providerName }
 
+// Probe checks that Steam's OpenID endpoint and Web API are reachable.
+func (p *Provider) Probe(ctx context.Context) error {
+	return outbound.Probe(ctx, p.httpClient, p.openIDEndpoint, p.playerSummaryURL)
+}
+
 func (p *Provider) AuthURL(stateToken string) (string, error) {
 	// Embed state token in return_to as a query param
 	returnTo, err := url.Parse(p.cfg.CallbackURL)
@@ -84,12 +124,91 @@ func (p *Provider) Exchange(ctx context.Context, params map[string]string) (*dom
 		return nil, err
 	}
 
-	user, err := p.fetchPlayerSummary(ctx, steamID)
+	return p.authResult(ctx, steamID)
+}
+
+// AuthenticateTicket validates a session ticket from the game client
+// (GetAuthSessionTicket or GetAuthTicketForWebApi, hex-encoded) with the
+// Steam Web API and returns the player it belongs to.
+func (p *Provider) AuthenticateTicket(ctx context.Context, ticket, identity string) (*domain.AuthResult, error) {
+	if ticket == "" {
+		return nil, domain.ErrMissingProviderParams
+	}
+	if len(ticket) > maxTicketLength || !ticketRegex.MatchString(ticket) {
+		return nil, fmt.Errorf("%w: ticket must be hex-encoded", domain.ErrInvalidSessionTicket)
+	}
+	if p.cfg.AppID == "" {
+		return nil, fmt.Errorf("%w: validating session tickets needs an app ID", domain.ErrProviderExchange)
+	}
+
+	params := url.Values{
+		"key":    {p.cfg.APIKey},
+		"appid":  {p.cfg.AppID},
+		"ticket": {ticket},
+	}
+	if identity != "" {
+		params.Set("identity", identity)
+	}
+
+	var ticketResp struct {
+		Response struct {
+			Params *struct {
+				Result       string `json:"result"`
+				SteamID      string `json:"steamid"`
+				OwnerSteamID string `json:"ownersteamid"` // differs for Family Sharing borrowers
+			} `json:"params"`
+			Error *struct {
+				Code int    `json:"errorcode"`
+				Desc string `json:"errordesc"`
+			} `json:"error"`
+		} `json:"response"`
+	}
+	if err := p.getJSON(ctx, p.authTicketURL+"?"+params.Encode(), &ticketResp); err != nil {
+		return nil, fmt.Errorf("%w: authenticating ticket: %v", domain.ErrProviderExchange, err)
+	}
+	if e := ticketResp.Response.Error; e != nil {
+		return nil, fmt.Errorf("%w: %d: %s", domain.ErrInvalidSessionTicket, e.Code, e.Desc)
+	}
+	tp := ticketResp.Response.Params
+	if tp == nil || tp.Result != "OK" || tp.SteamID == "" {
+		return nil, fmt.Errorf("%w: ticket was not accepted", domain.ErrInvalidSessionTicket)
+	}
+
+	return p.authResult(ctx, tp.SteamID)
+}
+
+// authResult builds the result for an authenticated Steam ID.
+func (p *Provider) authResult(ctx context.Context, steamID string) (*domain.AuthResult, error) {
+	player, raw, err := p.fetchPlayerSummary(ctx, steamID)
 	if err != nil {
 		return nil, err
 	}
+	user := domain.UserInfo{
+		ProviderName: providerName,
+		ProviderID:   player.SteamID,
+		Username:     player.PersonaName,
+		DisplayName:  player.PersonaName,
+		AvatarURL:    player.AvatarFull,
+		Country:      player.CountryCode,
+		Raw:          raw,
+	}
 
-	return &domain.AuthResult{User: *user}, nil
+	if p.cfg.Extras || p.cfg.FetchBans {
+		// Extras are best effort; leave them out rather than fail the login
+		extras, err := p.fetchExtras(ctx, steamID)
+		if err != nil {
+			slog.WarnContext(ctx, "steam: fetching extras failed", "provider_id", steamID, "error", err)
+		} else {
+			// The summary was fetched just now, so it says what they are playing at sign-in
+			if p.cfg.Extras && p.cfg.AppID != "" && player.GameID == p.cfg.AppID {
+				extras.InGame = true
+				extras.GameServer = player.GameServerIP
+			}
+			user.ProviderData = domain.NewSteamData(*extras)
+		}
+	}
+
+	return &domain.AuthResult{User: user}, nil
 }
 
 func (p *Provider) validateAssertion(ctx context.Context, params map[string]string) error {
@@ -135,19 +254,25 @@ func extractSteamID(claimedID string) (string, error) {
 	return matches[1], nil
 }
 
+type playerSummary struct {
+	SteamID      string `json:"steamid"`
+	PersonaName  string `json:"personaname"`
+	AvatarFull   string `json:"avatarfull"`
+	ProfileURL   string `json:"profileurl"`
+	RealName     string `json:"realname"`
+	CountryCode  string `json:"loccountrycode"` // only visible on public profiles
+	GameID       string `json:"gameid"`         // app being played; only visible on public profiles
+	GameServerIP string `json:"gameserverip"`   // ip:port of the server being played on
+}
+
 type playerSummaryResponse struct {
 	Response struct {
-		Players []struct {
-			SteamID      string `json:"steamid"`
-			PersonaName  string `json:"personaname"`
-			AvatarFull   string `json:"avatarfull"`
-			ProfileURL   string `json:"profileurl"`
-			RealName     string `json:"realname"`
-		} `json:"players"`
+		Players []playerSummary `json:"players"`
 	} `json:"response"`
 }
 
-func (p *Provider) fetchPlayerSummary(ctx context.Context, steamID string) (*domain.UserInfo, error) {
+// fetchPlayerSummary returns the player and the response it came from.
+func (p *Provider) fetchPlayerSummary(ctx context.Context, steamID string) (*playerSummary, json.RawMessage, error) {
 	params := url.Values{
 		"key":      {p.cfg.APIKey},
 		"steamids": {steamID},
@@ -156,39 +281,126 @@ func (p *Provider) fetchPlayerSummary(ctx context.Context, steamID string) (*dom
 	reqURL := p.playerSummaryURL + "?" + params.Encode()
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("creating player summary request: %w", err)
+		return nil, nil, fmt.Errorf("creating player summary request: %w", err)
 	}
 
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", domain.ErrProviderUserFetch, err)
+		return nil, nil, fmt.Errorf("%w: %v", domain.ErrProviderUserFetch, err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("%w: reading response: %v", domain.ErrProviderUserFetch, err)
+		return nil, nil, fmt.Errorf("%w: reading response: %v", domain.ErrProviderUserFetch, err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("%w: status %d: %s", domain.ErrProviderUserFetch, resp.StatusCode, body)
+		return nil, nil, fmt.Errorf("%w: status %d: %s", domain.ErrProviderUserFetch, resp.StatusCode, body)
 	}
 
 	var summaryResp playerSummaryResponse
 	if err := json.Unmarshal(body, &summaryResp); err != nil {
-		return nil, fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderUserFetch, err)
+		return nil, nil, fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderUserFetch, err)
 	}
 
 	if len(summaryResp.Response.Players) == 0 {
-		return nil, fmt.Errorf("%w: no player data returned", domain.ErrProviderUserFetch)
+		return nil, nil, fmt.Errorf("%w: no player data returned", domain.ErrProviderUserFetch)
 	}
 
-	player := summaryResp.Response.Players[0]
-	return &domain.UserInfo{
-		ProviderName: providerName,
-		ProviderID:   player.SteamID,
-		Username:     player.PersonaName,
-		DisplayName:  player.PersonaName,
-		AvatarURL:    player.AvatarFull,
+	return &summaryResp.Response.Players[0], body, nil
+}
+
+func (p *Provider) fetchExtras(ctx context.Context, steamID string) (*domain.SteamExtras, error) {
+	var extras domain.SteamExtras
+
+	if p.cfg.FetchBans {
+		bans, err := p.fetchBans(ctx, steamID)
+		if err != nil {
+			return nil, err
+		}
+		extras.Bans = *bans
+	}
+
+	if !p.cfg.Extras || p.cfg.AppID == "" {
+		return &extras, nil
+	}
+	extras.AppID = p.cfg.AppID
+
+	// Private game details come back as an empty response, not an error
+	var gamesResp struct {
+		Response struct {
+			Games []struct {
+				AppID           int `json:"appid"`
+				PlaytimeForever int `json:"playtime_forever"`
+			} `json:"games"`
+		} `json:"response"`
+	}
+	params := url.Values{
+		"key":                       {p.cfg.APIKey},
+		"steamid":                   {steamID},
+		"include_played_free_games": {"1"},
+		"appids_filter[0]":          {p.cfg.AppID},
+	}
+	if err := p.getJSON(ctx, p.ownedGamesURL+"?"+params.Encode(), &gamesResp); err != nil {
+		return nil, fmt.Errorf("fetching owned games: %w", err)
+	}
+	for _, g := range gamesResp.Response.Games {
+		if strconv.Itoa(g.AppID) == p.cfg.AppID {
+			extras.OwnsGame = true
+			extras.Playtime = g.PlaytimeForever
+		}
+	}
+	return &extras, nil
+}
+
+func (p *Provider) fetchBans(ctx context.Context, steamID string) (*domain.SteamBans, error) {
+	var bansResp struct {
+		Players []struct {
+			CommunityBanned  bool   `json:"CommunityBanned"`
+			VACBanned        bool   `json:"VACBanned"`
+			NumberOfVACBans  int    `json:"NumberOfVACBans"`
+			DaysSinceLastBan int    `json:"DaysSinceLastBan"`
+			NumberOfGameBans int    `json:"NumberOfGameBans"`
+			EconomyBan       string `json:"EconomyBan"`
+		} `json:"players"`
+	}
+	params := url.Values{"key": {p.cfg.APIKey}, "steamids": {steamID}}
+	if err := p.getJSON(ctx, p.playerBansURL+"?"+params.Encode(), &bansResp); err != nil {
+		return nil, fmt.Errorf("fetching bans: %w", err)
+	}
+	if len(bansResp.Players) == 0 {
+		return nil, errors.New("fetching bans: no player data returned")
+	}
+	b := bansResp.Players[0]
+	return &domain.SteamBans{
+		VACBanned:        b.VACBanned,
+		VACBans:          b.NumberOfVACBans,
+		GameBans:         b.NumberOfGameBans,
+		CommunityBanned:  b.CommunityBanned,
+		EconomyBan:       b.EconomyBan,
+		DaysSinceLastBan: b.DaysSinceLastBan,
 	}, nil
 }
+
+func (p *Provider) getJSON(ctx context.Context, reqURL string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, v)
+}
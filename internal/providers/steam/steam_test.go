@@ -95,17 +95,12 @@ func TestExchange_Success(t *testing.T) {
 		},
 		func(w http.ResponseWriter, r *http.Request) {
 			resp := playerSummaryResponse{}
-			resp.Response.Players = []struct {
-				SteamID     string `json:"steamid"`
-				PersonaName string `json:"personaname"`
-				AvatarFull  string `json:"avatarfull"`
-				ProfileURL  string `json:"profileurl"`
-				RealName    string `json:"realname"`
-			}{
+			resp.Response.Players = []playerSummary{
 				{
 					SteamID:     "76561198012345678",
 					PersonaName: "GamerTag",
 					AvatarFull:  "https://avatars.example.com/full.jpg",
+					CountryCode: "DE",
 				},
 			}
 			json.NewEncoder(w).Encode(resp)
@@ -132,6 +127,9 @@ func TestExchange_Success(t *testing.T) {
 	if result.User.Username != "GamerTag" {
 		t.Errorf("expected username 'GamerTag', got %q", result.User.Username)
 	}
+	if result.User.Country != "DE" {
+		t.Errorf("expected country 'DE', got %q", result.User.Country)
+	}
 }
 
 func TestExchange_AssertionFailure(t *testing.T) {
@@ -155,6 +153,24 @@ func TestExchange_AssertionFailure(t *testing.T) {
 	}
 }
 
+func TestProbe(t *testing.T) {
+	p := setupTestProvider(
+		func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("<xrds/>")) },
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusForbidden) },
+	)
+	if err := p.Probe(context.Background()); err != nil {
+		t.Errorf("expected Steam to be reachable, got %v", err)
+	}
+
+	p = setupTestProvider(
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusServiceUnavailable) },
+		nil,
+	)
+	if err := p.Probe(context.Background()); err == nil {
+		t.Error("expected a failing OpenID endpoint to be reported")
+	}
+}
+
 func TestExtractSteamID(t *testing.T) {
 	tests := []struct {
 		claimedID string
@@ -240,13 +256,7 @@ func TestValidateAssertion_SendsCheckAuthentication(t *testing.T) {
 		},
 		func(w http.ResponseWriter, r *http.Request) {
 			resp := playerSummaryResponse{}
-			resp.Response.Players = []struct {
-				SteamID     string `json:"steamid"`
-				PersonaName string `json:"personaname"`
-				AvatarFull  string `json:"avatarfull"`
-				ProfileURL  string `json:"profileurl"`
-				RealName    string `json:"realname"`
-			}{{SteamID: "123", PersonaName: "test"}}
+			resp.Response.Players = []playerSummary{{SteamID: "123", PersonaName: "test"}}
 			json.NewEncoder(w).Encode(resp)
 		},
 	)
@@ -263,3 +273,278 @@ func TestValidateAssertion_SendsCheckAuthentication(t *testing.T) {
 		t.Errorf("expected check_authentication mode, got %q", receivedMode)
 	}
 }
+
+const defaultSummary = `{"response":{"players":[{"steamid":"76561198012345678","personaname":"GamerTag"}]}}`
+
+func setupExtrasProvider(t *testing.T, appID, summary string, bansHandler, gamesHandler http.HandlerFunc) *Provider {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/openid/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ns:http://specs.openid.net/auth/2.0\nis_valid:true\n"))
+	})
+	mux.HandleFunc("/ISteamUser/GetPlayerSummaries/v2/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(summary))
+	})
+	if bansHandler != nil {
+		mux.HandleFunc("/ISteamUser/GetPlayerBans/v1/", bansHandler)
+	}
+	if gamesHandler != nil {
+		mux.HandleFunc("/IPlayerService/GetOwnedGames/v1/", gamesHandler)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	p := New(Config{APIKey: "test-steam-api-key", Extras: true, AppID: appID})
+	p.httpClient = server.Client()
+	p.openIDEndpoint = server.URL + "/openid/login"
+	p.playerSummaryURL = server.URL + "/ISteamUser/GetPlayerSummaries/v2/"
+	p.playerBansURL = server.URL + "/ISteamUser/GetPlayerBans/v1/"
+	p.ownedGamesURL = server.URL + "/IPlayerService/GetOwnedGames/v1/"
+	return p
+}
+
+var extrasParams = map[string]string{
+	"openid.claimed_id": "https://steamcommunity.com/openid/id/76561198012345678",
+}
+
+// privateGames answers GetOwnedGames the way Steam does for private game details.
+func privateGames(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(`{"response":{}}`))
+}
+
+func TestExchange_ExtrasInGame(t *testing.T) {
+	summary := `{"response":{"players":[{"steamid":"76561198012345678","personaname":"GamerTag",` +
+		`"gameid":"304930","gameserverip":"203.0.113.7:27015"}]}}`
+	p := setupExtrasProvider(t, "304930", summary, nil, privateGames)
+
+	result, err := p.Exchange(context.Background(), extrasParams)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	pd := result.User.ProviderData
+	if pd == nil || pd.Kind != domain.KindSteam || pd.Steam == nil {
+		t.Fatalf("expected steam provider data, got %+v", pd)
+	}
+	if !pd.Steam.InGame || pd.Steam.GameServer != "203.0.113.7:27015" {
+		t.Errorf("expected the player to be in game, got %+v", pd.Steam)
+	}
+}
+
+func TestExchange_ExtrasPlayingOtherGame(t *testing.T) {
+	summary := `{"response":{"players":[{"steamid":"76561198012345678","personaname":"GamerTag","gameid":"730"}]}}`
+	p := setupExtrasProvider(t, "304930", summary, nil, privateGames)
+
+	result, err := p.Exchange(context.Background(), extrasParams)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if pd := result.User.ProviderData; pd == nil || pd.Steam.InGame {
+		t.Errorf("expected the player not to be in the configured game, got %+v", pd)
+	}
+}
+
+func TestExchange_NoExtras(t *testing.T) {
+	p := setupExtrasProvider(t, "304930", defaultSummary, nil, nil)
+	p.cfg.Extras = false
+
+	result, err := p.Exchange(context.Background(), extrasParams)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if result.User.ProviderData != nil {
+		t.Errorf("expected no provider data, got %+v", result.User.ProviderData)
+	}
+}
+
+func TestExchange_FetchBans(t *testing.T) {
+	p := setupExtrasProvider(t, "", defaultSummary, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("steamids") != "76561198012345678" {
+			t.Errorf("unexpected query: %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(`{"players":[{"SteamId":"76561198012345678","CommunityBanned":false,"VACBanned":true,` +
+			`"NumberOfVACBans":1,"DaysSinceLastBan":400,"NumberOfGameBans":0,"EconomyBan":"none"}]}`))
+	}, nil)
+	p.cfg.Extras = false
+	p.cfg.FetchBans = true
+
+	result, err := p.Exchange(context.Background(), extrasParams)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	pd := result.User.ProviderData
+	if pd == nil || pd.Kind != domain.KindSteam || pd.Steam == nil {
+		t.Fatalf("expected steam provider data, got %+v", pd)
+	}
+	want := domain.SteamExtras{
+		Bans: domain.SteamBans{VACBanned: true, VACBans: 1, EconomyBan: "none", DaysSinceLastBan: 400},
+	}
+	if *pd.Steam != want {
+		t.Errorf("extras = %+v, want %+v", *pd.Steam, want)
+	}
+}
+
+func TestExchange_ExtrasWithoutFetchBans(t *testing.T) {
+	p := setupExtrasProvider(t, "304930", defaultSummary, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("bans should only be looked up with FetchBans")
+	}, privateGames)
+
+	result, err := p.Exchange(context.Background(), extrasParams)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if result.User.ProviderData == nil {
+		t.Error("expected provider data")
+	}
+}
+
+func TestExchange_BansFailureIsNotFatal(t *testing.T) {
+	p := setupExtrasProvider(t, "", defaultSummary, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}, nil)
+	p.cfg.FetchBans = true
+
+	result, err := p.Exchange(context.Background(), extrasParams)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if result.User.ProviderData != nil {
+		t.Errorf("expected no provider data, got %+v", result.User.ProviderData)
+	}
+}
+
+func TestExchange_ExtrasOwnership(t *testing.T) {
+	p := setupExtrasProvider(t, "304930", defaultSummary,
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"players":[{"EconomyBan":"none"}]}`))
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("appids_filter[0]") != "304930" {
+				t.Errorf("unexpected app filter: %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"response":{"game_count":1,"games":[{"appid":304930,"playtime_forever":1234}]}}`))
+		},
+	)
+	p.cfg.FetchBans = true
+
+	result, err := p.Exchange(context.Background(), extrasParams)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	pd := result.User.ProviderData
+	if pd == nil || pd.Steam == nil {
+		t.Fatalf("expected steam provider data, got %+v", pd)
+	}
+	want := domain.SteamExtras{
+		Bans:     domain.SteamBans{EconomyBan: "none"},
+		AppID:    "304930",
+		OwnsGame: true,
+		Playtime: 1234,
+	}
+	if *pd.Steam != want {
+		t.Errorf("extras = %+v, want %+v", *pd.Steam, want)
+	}
+}
+
+func TestExchange_ExtrasPrivateGames(t *testing.T) {
+	p := setupExtrasProvider(t, "304930", defaultSummary, nil, privateGames)
+
+	result, err := p.Exchange(context.Background(), extrasParams)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if pd := result.User.ProviderData; pd == nil || pd.Steam.OwnsGame {
+		t.Errorf("expected extras without ownership, got %+v", pd)
+	}
+}
+
+func TestExchange_OwnershipNeedsExtras(t *testing.T) {
+	p := setupExtrasProvider(t, "304930", defaultSummary,
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"players":[{"EconomyBan":"none"}]}`))
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			t.Error("ownership should only be looked up with Extras")
+		},
+	)
+	p.cfg.Extras = false
+	p.cfg.FetchBans = true
+
+	if _, err := p.Exchange(context.Background(), extrasParams); err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+}
+
+func setupTicketProvider(t *testing.T, appID string, ticketHandler http.HandlerFunc) *Provider {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ISteamUserAuth/AuthenticateUserTicket/v1/", ticketHandler)
+	mux.HandleFunc("/ISteamUser/GetPlayerSummaries/v2/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(defaultSummary))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	p := New(Config{APIKey: "test-steam-api-key", AppID: appID})
+	p.httpClient = server.Client()
+	p.authTicketURL = server.URL + "/ISteamUserAuth/AuthenticateUserTicket/v1/"
+	p.playerSummaryURL = server.URL + "/ISteamUser/GetPlayerSummaries/v2/"
+	return p
+}
+
+func TestAuthenticateTicket_Success(t *testing.T) {
+	p := setupTicketProvider(t, "304930", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("appid") != "304930" || q.Get("ticket") != "14000000ABCDEF" || q.Get("identity") != "centralauth" {
+			t.Errorf("unexpected query: %s", r.URL.RawQuery)
+		}
+		if q.Get("key") != "test-steam-api-key" {
+			t.Errorf("expected the API key to be sent")
+		}
+		w.Write([]byte(`{"response":{"params":{"result":"OK","steamid":"76561198012345678",` +
+			`"ownersteamid":"76561198012345678","vacbanned":false,"publisherbanned":false}}}`))
+	})
+
+	result, err := p.AuthenticateTicket(context.Background(), "14000000ABCDEF", "centralauth")
+	if err != nil {
+		t.Fatalf("AuthenticateTicket error: %v", err)
+	}
+	if result.User.ProviderID != "76561198012345678" || result.User.Username != "GamerTag" {
+		t.Errorf("unexpected user: %+v", result.User)
+	}
+}
+
+func TestAuthenticateTicket_Rejected(t *testing.T) {
+	p := setupTicketProvider(t, "304930", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"error":{"errorcode":101,"errordesc":"Invalid ticket"}}}`))
+	})
+
+	_, err := p.AuthenticateTicket(context.Background(), "deadbeef", "")
+	if !errors.Is(err, domain.ErrInvalidSessionTicket) {
+		t.Errorf("expected ErrInvalidSessionTicket, got %v", err)
+	}
+}
+
+func TestAuthenticateTicket_BadInput(t *testing.T) {
+	p := setupTicketProvider(t, "304930", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the Web API should not be called")
+	})
+
+	if _, err := p.AuthenticateTicket(context.Background(), "", ""); !errors.Is(err, domain.ErrMissingProviderParams) {
+		t.Errorf("empty ticket: expected ErrMissingProviderParams, got %v", err)
+	}
+	for _, tkt := range []string{"not-hex", strings.Repeat("ab", maxTicketLength)} {
+		if _, err := p.AuthenticateTicket(context.Background(), tkt, ""); !errors.Is(err, domain.ErrInvalidSessionTicket) {
+			t.Errorf("%.10s: expected ErrInvalidSessionTicket, got %v", tkt, err)
+		}
+	}
+}
+
+func TestAuthenticateTicket_RequiresAppID(t *testing.T) {
+	p := setupTicketProvider(t, "", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the Web API should not be called")
+	})
+
+	if _, err := p.AuthenticateTicket(context.Background(), "deadbeef", ""); !errors.Is(err, domain.ErrProviderExchange) {
+		t.Errorf("expected ErrProviderExchange, got %v", err)
+	}
+}
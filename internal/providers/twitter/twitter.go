@@ -0,0 +1,222 @@
+package twitter
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/outbound"
+)
+
+const (
+	providerName        = "twitter"
+	defaultAuthEndpoint = "https://x.com/i/oauth2/authorize"
+	defaultTokenURL     = "https://api.x.com/2/oauth2/token"
+	defaultUserURL      = "https://api.x.com/2/users/me"
+)
+
+// Config holds X (Twitter) OAuth 2.0 settings.
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	CallbackURL  string // The CentralAuth callback URL: {base_url}/callback/twitter
+
+	// HTTPClient makes the calls to the X API (http.DefaultClient if nil).
+	HTTPClient *http.Client
+}
+
+// Provider implements OAuth 2.0 with PKCE for X (Twitter), authenticating
+// to the token endpoint as a confidential client.
+type Provider struct {
+	cfg          Config
+	httpClient   *http.Client
+	scopes       atomic.Pointer[[]string]
+	authEndpoint string
+	tokenURL     string
+	userURL      string
+}
+
+// New creates an X (Twitter) provider.
+func New(cfg Config) *Provider {
+	p := &Provider{
+		cfg:          cfg,
+		httpClient:   cmp.Or(cfg.HTTPClient, http.DefaultClient),
+		authEndpoint: defaultAuthEndpoint,
+		tokenURL:     defaultTokenURL,
+		userURL:      defaultUserURL,
+	}
+	p.SetScopes(cfg.Scopes)
+	return p
+}
+
+func (p *Provider) Name() string { return providerName }
+
+// SetScopes replaces the scopes requested from now on. Flows already at
+// the provider keep the scopes they asked for.
+func (p *Provider) SetScopes(scopes []string) {
+	p.scopes.Store(&scopes)
+}
+
+// Probe checks that the X token and user endpoints are reachable.
+func (p *Provider) Probe(ctx context.Context) error {
+	return outbound.Probe(ctx, p.httpClient, p.tokenURL, p.userURL)
+}
+
+func (p *Provider) AuthURL(stateToken string) (string, error) {
+	challenge := sha256.Sum256([]byte(p.codeVerifier(stateToken)))
+	params := url.Values{
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.CallbackURL},
+		"response_type":         {"code"},
+		"scope":                 {strings.Join(*p.scopes.Load(), " ")},
+		"state":                 {stateToken},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	return p.authEndpoint + "?" + params.Encode(), nil
+}
+
+func (p *Provider) Exchange(ctx context.Context, params map[string]string) (*domain.AuthResult, error) {
+	if e := params["error"]; e != "" {
+		return nil, fmt.Errorf("%w: %s: %s", domain.ErrProviderExchange, e, params["error_description"])
+	}
+	code, stateToken := params["code"], params["state"]
+	if code == "" || stateToken == "" {
+		return nil, domain.ErrMissingProviderParams
+	}
+
+	token, err := p.exchangeCode(ctx, code, p.codeVerifier(stateToken))
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := p.fetchUser(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.AuthResult{User: *user}, nil
+}
+
+// codeVerifier derives the PKCE verifier from the state token, so the
+// callback can recompute it without server-side storage. It is keyed with
+// the client secret because the state token itself travels in the URL.
+func (p *Provider) codeVerifier(stateToken string) string {
+	mac := hmac.New(sha256.New, []byte(p.cfg.ClientSecret))
+	mac.Write([]byte("pkce:" + stateToken))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+}
+
+func (p *Provider) exchangeCode(ctx context.Context, code, verifier string) (string, error) {
+	data := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.CallbackURL},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(p.cfg.ClientID, p.cfg.ClientSecret)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", domain.ErrProviderExchange, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%w: reading response: %v", domain.ErrProviderExchange, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: status %d: %s", domain.ErrProviderExchange, resp.StatusCode, body)
+	}
+
+	var tokenResp tokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderExchange, err)
+	}
+
+	if tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("%w: empty access token", domain.ErrProviderExchange)
+	}
+
+	return tokenResp.AccessToken, nil
+}
+
+type twitterUser struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Username        string `json:"username"`
+	ProfileImageURL string `json:"profile_image_url"`
+}
+
+func (p *Provider) fetchUser(ctx context.Context, accessToken string) (*domain.UserInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.userURL+"?user.fields=profile_image_url", nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating user request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrProviderUserFetch, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading response: %v", domain.ErrProviderUserFetch, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d: %s", domain.ErrProviderUserFetch, resp.StatusCode, body)
+	}
+
+	var envelope struct {
+		Data twitterUser `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("%w: invalid JSON: %v", domain.ErrProviderUserFetch, err)
+	}
+	tu := envelope.Data
+	if tu.ID == "" {
+		return nil, fmt.Errorf("%w: response has no user ID", domain.ErrProviderUserFetch)
+	}
+
+	displayName := tu.Name
+	if displayName == "" {
+		displayName = tu.Username
+	}
+
+	// The v2 API only shares email addresses with apps that have elevated access
+	return &domain.UserInfo{
+		ProviderName: providerName,
+		ProviderID:   tu.ID,
+		Username:     tu.Username,
+		DisplayName:  displayName,
+		AvatarURL:    tu.ProfileImageURL,
+		Raw:          body,
+	}, nil
+}
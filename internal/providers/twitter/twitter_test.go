@@ -0,0 +1,158 @@
+package twitter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func setupTestProvider(t *testing.T, tokenHandler, userHandler http.HandlerFunc) *Provider {
+	t.Helper()
+	mux := http.NewServeMux()
+	if tokenHandler != nil {
+		mux.HandleFunc("/2/oauth2/token", tokenHandler)
+	}
+	if userHandler != nil {
+		mux.HandleFunc("/2/users/me", userHandler)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	p := New(Config{
+		ClientID:     "test-client-id",
+		ClientSecret: "test-client-secret",
+		Scopes:       []string{"tweet.read", "users.read"},
+		CallbackURL:  "https://auth.example.com/callback/twitter",
+	})
+	p.httpClient = server.Client()
+	p.tokenURL = server.URL + "/2/oauth2/token"
+	p.userURL = server.URL + "/2/users/me"
+	return p
+}
+
+func okToken(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(tokenResponse{AccessToken: "test-access-token", TokenType: "bearer"})
+}
+
+func TestAuthURL_ContainsPKCEChallenge(t *testing.T) {
+	p := New(Config{
+		ClientID:     "test-client-id",
+		ClientSecret: "test-client-secret",
+		Scopes:       []string{"tweet.read", "users.read"},
+		CallbackURL:  "https://auth.example.com/callback/twitter",
+	})
+
+	authURL, err := p.AuthURL("test-state-token")
+	if err != nil {
+		t.Fatalf("AuthURL error: %v", err)
+	}
+	q, _ := url.Parse(authURL)
+	if got := q.Query().Get("scope"); got != "tweet.read users.read" {
+		t.Errorf("expected scope 'tweet.read users.read', got %q", got)
+	}
+	if got := q.Query().Get("code_challenge_method"); got != "S256" {
+		t.Errorf("expected code_challenge_method 'S256', got %q", got)
+	}
+	sum := sha256.Sum256([]byte(p.codeVerifier("test-state-token")))
+	if got := q.Query().Get("code_challenge"); got != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Errorf("code_challenge does not match the verifier: %q", got)
+	}
+}
+
+func TestCodeVerifier(t *testing.T) {
+	p := New(Config{ClientSecret: "secret"})
+
+	v := p.codeVerifier("state-a")
+	if len(v) < 43 || len(v) > 128 {
+		t.Errorf("verifier length %d outside RFC 7636 bounds", len(v))
+	}
+	if v != p.codeVerifier("state-a") {
+		t.Error("expected the verifier to be stable for a state token")
+	}
+	if v == p.codeVerifier("state-b") {
+		t.Error("expected different state tokens to give different verifiers")
+	}
+	if v == New(Config{ClientSecret: "other"}).codeVerifier("state-a") {
+		t.Error("expected the verifier to depend on the client secret")
+	}
+}
+
+func TestExchange_Success(t *testing.T) {
+	p := setupTestProvider(t,
+		func(w http.ResponseWriter, r *http.Request) {
+			id, secret, ok := r.BasicAuth()
+			if !ok || id != "test-client-id" || secret != "test-client-secret" {
+				t.Error("expected client credentials as basic auth")
+			}
+			r.ParseForm()
+			if got := r.PostForm.Get("code_verifier"); got != New(Config{ClientSecret: "test-client-secret"}).codeVerifier("test-state") {
+				t.Errorf("unexpected code_verifier %q", got)
+			}
+			okToken(w, r)
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer test-access-token" {
+				t.Error("expected Bearer token in Authorization header")
+			}
+			if r.URL.Query().Get("user.fields") != "profile_image_url" {
+				t.Errorf("expected profile_image_url to be requested, got %q", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"data": {"id": "2244994945", "name": "Ada Lovelace", "username": "ada",
+				"profile_image_url": "https://pbs.twimg.com/profile_images/1/ada_normal.jpg"}}`))
+		},
+	)
+
+	result, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code", "state": "test-state"})
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	user := result.User
+	if user.ProviderName != "twitter" || user.ProviderID != "2244994945" || user.Username != "ada" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	if user.DisplayName != "Ada Lovelace" || user.AvatarURL == "" {
+		t.Errorf("unexpected profile: %+v", user)
+	}
+}
+
+func TestExchange_TokenFailure(t *testing.T) {
+	p := setupTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_request","error_description":"Value passed for the authorization code was invalid."}`))
+	}, nil)
+
+	_, err := p.Exchange(context.Background(), map[string]string{"code": "bad-code", "state": "s"})
+	if !errors.Is(err, domain.ErrProviderExchange) {
+		t.Errorf("expected ErrProviderExchange, got %v", err)
+	}
+}
+
+func TestExchange_UserFetchFailure(t *testing.T) {
+	p := setupTestProvider(t, okToken, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	_, err := p.Exchange(context.Background(), map[string]string{"code": "auth-code", "state": "s"})
+	if !errors.Is(err, domain.ErrProviderUserFetch) {
+		t.Errorf("expected ErrProviderUserFetch, got %v", err)
+	}
+}
+
+func TestExchange_MissingParams(t *testing.T) {
+	p := New(Config{})
+
+	for _, params := range []map[string]string{{}, {"code": "c"}, {"state": "s"}} {
+		_, err := p.Exchange(context.Background(), params)
+		if !errors.Is(err, domain.ErrMissingProviderParams) {
+			t.Errorf("params %v: expected ErrMissingProviderParams, got %v", params, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// sweepInterval is how often buckets that have refilled are dropped.
+const sweepInterval = time.Minute
+
+// Memory keeps token buckets in process memory. Each key's bucket holds up
+// to limit.Requests tokens and refills at limit.Requests per limit.Per.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  domain.RateLimit
+}
+
+// NewMemory creates an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// SetNow overrides the time function (for testing).
+func (l *Memory) SetNow(fn func() time.Time) {
+	l.now = fn
+}
+
+// Take takes a token from key's bucket.
+func (l *Memory) Take(ctx context.Context, key string, limit domain.RateLimit) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweepLocked(now)
+	b, ok := l.buckets[key]
+	if !ok || b.limit != limit {
+		// New key, or its limit was reconfigured: start with a full bucket.
+		b = &bucket{tokens: float64(limit.Requests), last: now, limit: limit}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+	if b.tokens < 1 {
+		return false, time.Duration(math.Ceil((1 - b.tokens) / b.rate())), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// rate is the refill rate in tokens per nanosecond.
+func (b *bucket) rate() float64 {
+	return float64(b.limit.Requests) / float64(b.limit.Per)
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(float64(b.limit.Requests), b.tokens+float64(elapsed)*b.rate())
+		b.last = now
+	}
+}
+
+// sweepLocked drops the buckets that are full again, which behave the same
+// as missing ones. Callers must hold l.mu.
+func (l *Memory) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Requests) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *Memory) String() string {
+	return "memory"
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	l := NewMemory()
+	l.SetNow(func() time.Time { return now })
+	limit := domain.RateLimit{Requests: 3, Per: 3 * time.Second}
+
+	for i := range 3 {
+		if ok, _, _ := l.Take(ctx, "game", limit); !ok {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+	ok, retry, _ := l.Take(ctx, "game", limit)
+	if ok {
+		t.Fatal("expected the request past the burst to be refused")
+	}
+	if retry != time.Second {
+		t.Errorf("retry after = %v, want 1s", retry)
+	}
+	if ok, _, _ := l.Take(ctx, "website", limit); !ok {
+		t.Error("expected other keys to have their own bucket")
+	}
+
+	now = now.Add(time.Second)
+	if ok, _, _ := l.Take(ctx, "game", limit); !ok {
+		t.Error("expected a token after one refill period")
+	}
+	if ok, _, _ := l.Take(ctx, "game", limit); ok {
+		t.Error("expected only one token to have been refilled")
+	}
+}
+
+func TestMemory_SweepsFullBuckets(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	l := NewMemory()
+	l.SetNow(func() time.Time { return now })
+	limit := domain.RateLimit{Requests: 10, Per: time.Second}
+
+	l.Take(ctx, "game", limit)
+	now = now.Add(sweepInterval)
+	l.Take(ctx, "website", limit)
+	if _, ok := l.buckets["game"]; ok {
+		t.Error("expected the refilled bucket to be swept")
+	}
+	if _, ok := l.buckets["website"]; !ok {
+		t.Error("expected the bucket in use to be kept")
+	}
+}
@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/store"
+)
+
+// Store holds token buckets. Each key's bucket holds up to limit.Requests
+// tokens and refills at limit.Requests per limit.Per.
+type Store interface {
+	// Take takes a token from key's bucket. When the bucket is empty it
+	// returns false and how long until the next token.
+	Take(ctx context.Context, key string, limit domain.RateLimit) (bool, time.Duration, error)
+
+	// String describes the store for logs.
+	String() string
+}
+
+// Limiter enforces token-bucket rate limits on keys such as client IDs,
+// keeping the buckets in a Store.
+type Limiter struct {
+	store Store
+}
+
+// New creates a limiter backed by store.
+func New(store Store) *Limiter {
+	return &Limiter{store: store}
+}
+
+// Ping checks that the limiter's store is reachable, when it is kept in a
+// service such as Redis.
+func (l *Limiter) Ping(ctx context.Context) error {
+	if p, ok := l.store.(store.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// Allow takes a token from key's bucket. When the bucket is empty it returns
+// false and how long until the next token. A zero limit always allows, and
+// so does a store that fails, so that an outage of a shared store doesn't
+// take sign-ins down with it.
+func (l *Limiter) Allow(ctx context.Context, key string, limit domain.RateLimit) (bool, time.Duration) {
+	if limit.IsZero() {
+		return true, 0
+	}
+	ok, retry, err := l.store.Take(ctx, key, limit)
+	if err != nil {
+		slog.WarnContext(ctx, "ratelimit: store failed; allowing the request", "store", l.store.String(), "key", key, "error", err)
+		return true, 0
+	}
+	return ok, retry
+}
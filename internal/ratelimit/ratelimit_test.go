@@ -0,0 +1,38 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, domain.RateLimit) (bool, time.Duration, error) {
+	return false, 0, errors.New("connection refused")
+}
+
+func (failingStore) String() string { return "failing" }
+
+func TestAllow_ZeroLimit(t *testing.T) {
+	store := NewMemory()
+	l := New(store)
+	for range 100 {
+		if ok, _ := l.Allow(context.Background(), "game", domain.RateLimit{}); !ok {
+			t.Fatal("expected a zero limit to allow everything")
+		}
+	}
+	if len(store.buckets) != 0 {
+		t.Error("expected no bucket for an unlimited key")
+	}
+}
+
+func TestAllow_StoreErrorAllows(t *testing.T) {
+	l := New(failingStore{})
+	if ok, _ := l.Allow(context.Background(), "game", domain.RateLimit{Requests: 1, Per: time.Minute}); !ok {
+		t.Error("expected a failing store to allow the request")
+	}
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/store"
+)
+
+// redisKeyPrefix namespaces the buckets in a shared Redis.
+const redisKeyPrefix = "centralauth:ratelimit:"
+
+// gcraScript is the token bucket as a generic cell rate algorithm: the key
+// holds the time, in microseconds of the Redis clock, at which the bucket
+// will be full again. ARGV is the period in microseconds and the number of
+// requests allowed per period. It returns {1, 0} when allowed, or {0, retry
+// in microseconds}.
+const gcraScript = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local per = tonumber(ARGV[1])
+local interval = per / tonumber(ARGV[2])
+local full = tonumber(redis.call('GET', KEYS[1]) or now)
+if full < now then full = now end
+local nextFull = full + interval
+if nextFull - now > per then
+  return {0, math.ceil(nextFull - now - per)}
+end
+redis.call('SET', KEYS[1], string.format('%.0f', nextFull), 'PX', math.ceil((nextFull - now) / 1000))
+return {1, 0}
+`
+
+// Redis keeps token buckets in Redis, so that every replica shares them.
+type Redis struct {
+	conn *store.Redis
+}
+
+// NewRedis creates a store that keeps its buckets in conn's Redis.
+func NewRedis(conn *store.Redis) *Redis {
+	return &Redis{conn: conn}
+}
+
+// Take takes a token from key's bucket.
+func (s *Redis) Take(ctx context.Context, key string, limit domain.RateLimit) (bool, time.Duration, error) {
+	reply, err := s.conn.Do(ctx, "EVAL", gcraScript, "1", redisKeyPrefix+key,
+		strconv.FormatInt(limit.Per.Microseconds(), 10), strconv.Itoa(limit.Requests))
+	if err != nil {
+		return false, 0, err
+	}
+	vals, ok := reply.([]any)
+	if !ok || len(vals) != 2 {
+		return false, 0, fmt.Errorf("unexpected reply %v", reply)
+	}
+	allowed, _ := vals[0].(int64)
+	retry, _ := vals[1].(int64)
+	return allowed == 1, time.Duration(retry) * time.Microsecond, nil
+}
+
+func (s *Redis) String() string {
+	return s.conn.String()
+}
+
+// Ping checks that Redis answers.
+func (s *Redis) Ping(ctx context.Context) error {
+	return s.conn.Ping(ctx)
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/store"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestRedis_Take(t *testing.T) {
+	var refuse atomic.Bool
+	f := testutil.StartRedis(t, func(args []string) string {
+		if args[0] != "EVAL" {
+			return "-ERR unknown command\r\n"
+		}
+		if refuse.Load() {
+			return "*2\r\n:0\r\n:1500000\r\n"
+		}
+		return "*2\r\n:1\r\n:0\r\n"
+	})
+	conn, err := store.NewRedis("redis://" + f.Addr)
+	if err != nil {
+		t.Fatalf("NewRedis error: %v", err)
+	}
+	defer conn.Close()
+	s := NewRedis(conn)
+	ctx := context.Background()
+	limit := domain.RateLimit{Requests: 10, Per: time.Minute}
+
+	ok, _, err := s.Take(ctx, "ip/192.0.2.1", limit)
+	if err != nil || !ok {
+		t.Fatalf("Take = %v, %v; want allowed", ok, err)
+	}
+	refuse.Store(true)
+	ok, retry, err := s.Take(ctx, "ip/192.0.2.1", limit)
+	if err != nil || ok || retry != 1500*time.Millisecond {
+		t.Fatalf("Take = %v, %v, %v; want refused with retry 1.5s", ok, retry, err)
+	}
+
+	eval := f.Commands()[0]
+	if eval[0] != "EVAL" || eval[3] != "centralauth:ratelimit:ip/192.0.2.1" || eval[4] != "60000000" || eval[5] != "10" {
+		t.Errorf("EVAL args = %q", eval[2:])
+	}
+}
+
+func TestRedis_ErrorReply(t *testing.T) {
+	f := testutil.StartRedis(t, func(args []string) string {
+		return "-NOPERM this user has no permissions to run the 'eval' command\r\n"
+	})
+	conn, _ := store.NewRedis("redis://" + f.Addr)
+	defer conn.Close()
+
+	if _, _, err := NewRedis(conn).Take(context.Background(), "global", domain.RateLimit{Requests: 1, Per: time.Second}); err == nil {
+		t.Error("expected the error reply")
+	}
+}
@@ -0,0 +1,171 @@
+package refresh
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/store"
+)
+
+// tokenBytes is the number of random bytes in a refresh token.
+const tokenBytes = 32
+
+// Store key prefixes. Tokens are stored under their hash, so the store never
+// holds a token that could be presented.
+const (
+	tokenPrefix   = "refresh/token/"
+	usedPrefix    = "refresh/used/"
+	revokedPrefix = "refresh/revoked/"
+)
+
+// session is what a refresh token stands for: a sign-in's result, held for
+// the client it was issued to. Every token rotated from one sign-in shares
+// its family, so revoking one revokes them all.
+type session struct {
+	ClientID  string            `json:"client_id"`
+	Family    string            `json:"family"`
+	Result    domain.AuthResult `json:"result"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Service issues refresh tokens and keeps what they stand for in a store.
+// Each token can be used once: refreshing returns a new token in its place,
+// and presenting a used token again revokes every token of its sign-in, since
+// one of the two presenting it must have stolen it.
+type Service struct {
+	store store.Store
+	now   func() time.Time
+	rand  io.Reader
+}
+
+// New creates a service that keeps its sessions in s.
+func New(s store.Store) *Service {
+	return &Service{store: s, now: time.Now, rand: rand.Reader}
+}
+
+// SetNow overrides the time function (for testing).
+func (s *Service) SetNow(fn func() time.Time) {
+	s.now = fn
+}
+
+// Issue returns a refresh token for result, issued to clientID and valid for
+// ttl. Provider tokens are not kept.
+func (s *Service) Issue(ctx context.Context, clientID string, result domain.AuthResult, ttl time.Duration) (string, error) {
+	family, err := s.random()
+	if err != nil {
+		return "", err
+	}
+	result.Tokens, result.IDToken, result.RefreshToken = nil, "", ""
+	return s.issue(ctx, session{ClientID: clientID, Family: family, Result: result}, ttl)
+}
+
+// Refresh redeems token for clientID, returning the result it stands for and
+// the token that replaces it, valid for ttl.
+func (s *Service) Refresh(ctx context.Context, clientID, token string, ttl time.Duration) (domain.AuthResult, string, error) {
+	sess, err := s.lookup(ctx, clientID, token)
+	if err != nil {
+		return domain.AuthResult{}, "", err
+	}
+	first, err := s.store.Add(ctx, usedPrefix+hash(token), []byte(sess.Family), max(sess.ExpiresAt.Sub(s.now()), time.Second))
+	if err != nil {
+		return domain.AuthResult{}, "", fmt.Errorf("refresh: %w", err)
+	}
+	if !first {
+		if err := s.revoke(ctx, sess, ttl); err != nil {
+			return domain.AuthResult{}, "", err
+		}
+		return domain.AuthResult{}, "", domain.ErrRefreshTokenReused
+	}
+
+	next, err := s.issue(ctx, session{ClientID: sess.ClientID, Family: sess.Family, Result: sess.Result}, ttl)
+	if err != nil {
+		return domain.AuthResult{}, "", err
+	}
+	return sess.Result, next, nil
+}
+
+// Revoke revokes token and every other token of its sign-in, whose tokens
+// are valid for ttl. A token that isn't one of clientID's is ignored, so
+// revoking can't be used to probe for tokens.
+func (s *Service) Revoke(ctx context.Context, clientID, token string, ttl time.Duration) error {
+	sess, err := s.lookup(ctx, clientID, token)
+	switch {
+	case errors.Is(err, domain.ErrInvalidRefreshToken), errors.Is(err, domain.ErrExpiredRefreshToken), errors.Is(err, domain.ErrRevokedRefreshToken):
+		return nil
+	case err != nil:
+		return err
+	}
+	return s.revoke(ctx, sess, ttl)
+}
+
+func (s *Service) issue(ctx context.Context, sess session, ttl time.Duration) (string, error) {
+	token, err := s.random()
+	if err != nil {
+		return "", err
+	}
+	sess.ExpiresAt = s.now().Add(ttl)
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return "", fmt.Errorf("refresh: encoding session: %w", err)
+	}
+	if err := s.store.Set(ctx, tokenPrefix+hash(token), data, ttl); err != nil {
+		return "", fmt.Errorf("refresh: %w", err)
+	}
+	return token, nil
+}
+
+// lookup returns the live session token stands for, if it was issued to
+// clientID.
+func (s *Service) lookup(ctx context.Context, clientID, token string) (session, error) {
+	data, ok, err := s.store.Get(ctx, tokenPrefix+hash(token))
+	if err != nil {
+		return session{}, fmt.Errorf("refresh: %w", err)
+	}
+	var sess session
+	if !ok || json.Unmarshal(data, &sess) != nil || sess.ClientID != clientID {
+		return session{}, domain.ErrInvalidRefreshToken
+	}
+	if !s.now().Before(sess.ExpiresAt) {
+		return session{}, domain.ErrExpiredRefreshToken
+	}
+	_, revoked, err := s.store.Get(ctx, revokedPrefix+sess.Family)
+	if err != nil {
+		return session{}, fmt.Errorf("refresh: %w", err)
+	}
+	if revoked {
+		return session{}, domain.ErrRevokedRefreshToken
+	}
+	return sess, nil
+}
+
+// revoke marks sess's family revoked for as long as any of its tokens can
+// be live: until sess expires, or for ttl in case sess has been rotated to
+// a newer token.
+func (s *Service) revoke(ctx context.Context, sess session, ttl time.Duration) error {
+	ttl = max(sess.ExpiresAt.Sub(s.now()), ttl, time.Second)
+	if err := s.store.Set(ctx, revokedPrefix+sess.Family, []byte(sess.ClientID), ttl); err != nil {
+		return fmt.Errorf("refresh: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) random() (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := io.ReadFull(s.rand, b); err != nil {
+		return "", fmt.Errorf("refresh: generating token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,136 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/store"
+)
+
+func newTestService() (*Service, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	mem := store.NewMemory()
+	mem.SetNow(func() time.Time { return now })
+	s := New(mem)
+	s.SetNow(func() time.Time { return now })
+	return s, &now
+}
+
+var signIn = domain.AuthResult{
+	User:    domain.UserInfo{ProviderName: "discord", ProviderID: "123", Username: "alice"},
+	Factors: []string{"discord"},
+	Scope:   "profile",
+	Tokens:  &domain.ProviderTokens{AccessToken: "provider-secret"},
+}
+
+func TestService_Rotation(t *testing.T) {
+	s, _ := newTestService()
+	ctx := context.Background()
+
+	first, err := s.Issue(ctx, "webapp", signIn, time.Hour)
+	if err != nil {
+		t.Fatalf("Issue error: %v", err)
+	}
+	result, second, err := s.Refresh(ctx, "webapp", first, time.Hour)
+	if err != nil {
+		t.Fatalf("Refresh error: %v", err)
+	}
+	if result.User.Username != "alice" || result.Scope != "profile" || result.Tokens != nil {
+		t.Errorf("result = %+v, want the sign-in without provider tokens", result)
+	}
+	if second == "" || second == first {
+		t.Fatalf("expected a new token, got %q", second)
+	}
+	if _, _, err := s.Refresh(ctx, "webapp", second, time.Hour); err != nil {
+		t.Errorf("Refresh with the new token: %v", err)
+	}
+}
+
+func TestService_ReuseRevokesSignIn(t *testing.T) {
+	s, _ := newTestService()
+	ctx := context.Background()
+
+	first, _ := s.Issue(ctx, "webapp", signIn, time.Hour)
+	_, second, _ := s.Refresh(ctx, "webapp", first, time.Hour)
+
+	if _, _, err := s.Refresh(ctx, "webapp", first, time.Hour); !errors.Is(err, domain.ErrRefreshTokenReused) {
+		t.Fatalf("reused token: got %v, want ErrRefreshTokenReused", err)
+	}
+	if _, _, err := s.Refresh(ctx, "webapp", second, time.Hour); !errors.Is(err, domain.ErrRevokedRefreshToken) {
+		t.Errorf("token rotated from a reused one: got %v, want ErrRevokedRefreshToken", err)
+	}
+
+	// Other sign-ins are unaffected
+	other, _ := s.Issue(ctx, "webapp", signIn, time.Hour)
+	if _, _, err := s.Refresh(ctx, "webapp", other, time.Hour); err != nil {
+		t.Errorf("another sign-in: %v", err)
+	}
+}
+
+func TestService_Invalid(t *testing.T) {
+	s, now := newTestService()
+	ctx := context.Background()
+	token, _ := s.Issue(ctx, "webapp", signIn, time.Hour)
+
+	if _, _, err := s.Refresh(ctx, "other", token, time.Hour); !errors.Is(err, domain.ErrInvalidRefreshToken) {
+		t.Errorf("other client: got %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, _, err := s.Refresh(ctx, "webapp", "made-up", time.Hour); !errors.Is(err, domain.ErrInvalidRefreshToken) {
+		t.Errorf("unknown token: got %v, want ErrInvalidRefreshToken", err)
+	}
+	*now = now.Add(time.Hour)
+	if _, _, err := s.Refresh(ctx, "webapp", token, time.Hour); err == nil {
+		t.Error("expected an expired token to fail")
+	}
+}
+
+func TestService_Revoke(t *testing.T) {
+	s, _ := newTestService()
+	ctx := context.Background()
+	first, _ := s.Issue(ctx, "webapp", signIn, time.Hour)
+	_, second, _ := s.Refresh(ctx, "webapp", first, time.Hour)
+
+	// Revoking through a token that has been rotated still reaches the live one
+	if err := s.Revoke(ctx, "webapp", first, time.Hour); err != nil {
+		t.Fatalf("Revoke error: %v", err)
+	}
+	if _, _, err := s.Refresh(ctx, "webapp", second, time.Hour); !errors.Is(err, domain.ErrRevokedRefreshToken) {
+		t.Errorf("got %v, want ErrRevokedRefreshToken", err)
+	}
+	for _, token := range []string{first, "made-up"} {
+		if err := s.Revoke(ctx, "webapp", token, time.Hour); err != nil {
+			t.Errorf("Revoke(%q) error: %v", token, err)
+		}
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Get(context.Context, string) ([]byte, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+func (failingStore) Set(context.Context, string, []byte, time.Duration) error {
+	return errors.New("connection refused")
+}
+func (failingStore) Add(context.Context, string, []byte, time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
+func (failingStore) String() string { return "failing" }
+
+func TestService_StoreErrors(t *testing.T) {
+	s := New(failingStore{})
+	ctx := context.Background()
+	if _, err := s.Issue(ctx, "webapp", signIn, time.Hour); err == nil {
+		t.Error("Issue: expected the store error")
+	}
+	_, _, err := s.Refresh(ctx, "webapp", "token", time.Hour)
+	if err == nil || errors.Is(err, domain.ErrInvalidRefreshToken) {
+		t.Errorf("Refresh: got %v, want the store error", err)
+	}
+	if err := s.Revoke(ctx, "webapp", "token", time.Hour); err == nil {
+		t.Error("Revoke: expected the store error")
+	}
+}
@@ -0,0 +1,108 @@
+// Package roles maps what providers say about a user to application roles,
+// so that clients are handed user.roles rather than each working them out
+// from guild roles and game ownership themselves.
+package roles
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// Rule grants Role to users who meet every condition it sets. A rule sets
+// at least one.
+type Rule struct {
+	Role string `json:"role"` // e.g. "role:moderator"
+
+	// Provider is the provider the user signed in with.
+	Provider string `json:"provider,omitempty"`
+
+	// DiscordRole is a role ID the user has in the configured Discord guild,
+	// and DiscordGuild a guild they are a member of.
+	DiscordRole  string `json:"discord_role,omitempty"`
+	DiscordGuild string `json:"discord_guild,omitempty"`
+
+	// SteamOwnsApp is a Steam app the user owns. Ownership is only known for
+	// the configured app, STEAM_APP_ID.
+	SteamOwnsApp string `json:"steam_owns_app,omitempty"`
+
+	// Connection is the type of a verified account the user linked at their
+	// provider, e.g. "twitch".
+	Connection string `json:"connection,omitempty"`
+}
+
+// Rules are the rules in force. Nil Rules grant no roles.
+type Rules []Rule
+
+// LoadFile reads a JSON array of rules from path.
+func LoadFile(path string) (Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading role rules file: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse decodes and checks a JSON array of rules.
+func Parse(data []byte) (Rules, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields() // a misspelt condition would otherwise match everyone
+	var rules Rules
+	if err := dec.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("parsing role rules: %w", err)
+	}
+	for i, r := range rules {
+		if r.Role == "" {
+			return nil, fmt.Errorf("role rule %d: role is required", i)
+		}
+		if r == (Rule{Role: r.Role}) {
+			return nil, fmt.Errorf("role rule %d (%s): at least one condition is required", i, r.Role)
+		}
+	}
+	return rules, nil
+}
+
+// Apply returns the roles rules grant user, sorted and without repeats, or
+// nil if they grant none.
+func (rules Rules) Apply(user domain.UserInfo) []string {
+	var granted []string
+	for _, r := range rules {
+		if r.matches(user) && !slices.Contains(granted, r.Role) {
+			granted = append(granted, r.Role)
+		}
+	}
+	slices.Sort(granted)
+	return granted
+}
+
+func (r Rule) matches(user domain.UserInfo) bool {
+	if r.Provider != "" && user.ProviderName != r.Provider {
+		return false
+	}
+	var discord *domain.DiscordExtras
+	var steam *domain.SteamExtras
+	if d := user.ProviderData; d != nil {
+		discord, steam = d.Discord, d.Steam
+	}
+	if r.DiscordRole != "" && (discord == nil || !slices.Contains(discord.GuildRoles, r.DiscordRole)) {
+		return false
+	}
+	if r.DiscordGuild != "" && (discord == nil || !slices.ContainsFunc(discord.Guilds, func(g domain.DiscordGuild) bool {
+		return g.ID == r.DiscordGuild
+	})) {
+		return false
+	}
+	if r.SteamOwnsApp != "" && (steam == nil || steam.AppID != r.SteamOwnsApp || !steam.OwnsGame) {
+		return false
+	}
+	if r.Connection != "" && !slices.ContainsFunc(user.Connections, func(c domain.Connection) bool {
+		return c.Type == r.Connection && c.Verified
+	}) {
+		return false
+	}
+	return true
+}
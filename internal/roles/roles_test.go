@@ -0,0 +1,100 @@
+package roles
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+const rulesJSON = `[
+	{"role": "role:moderator", "provider": "discord", "discord_role": "555"},
+	{"role": "role:member", "discord_guild": "42"},
+	{"role": "role:player", "steam_owns_app": "304930"},
+	{"role": "role:player", "connection": "steam"},
+	{"role": "role:streamer", "connection": "twitch"}
+]`
+
+func TestApply(t *testing.T) {
+	rules, err := Parse([]byte(rulesJSON))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		user domain.UserInfo
+		want []string
+	}{
+		{
+			name: "discord roles and guilds",
+			user: domain.UserInfo{ProviderName: "discord", ProviderData: domain.NewDiscordData(domain.DiscordExtras{
+				GuildRoles: []string{"111", "555"},
+				Guilds:     []domain.DiscordGuild{{ID: "42", Name: "Black Mission"}},
+			})},
+			want: []string{"role:member", "role:moderator"},
+		},
+		{
+			name: "steam owner",
+			user: domain.UserInfo{ProviderName: "steam", ProviderData: domain.NewSteamData(domain.SteamExtras{AppID: "304930", OwnsGame: true})},
+			want: []string{"role:player"},
+		},
+		{
+			name: "steam ownership of another app",
+			user: domain.UserInfo{ProviderName: "steam", ProviderData: domain.NewSteamData(domain.SteamExtras{AppID: "440", OwnsGame: true})},
+		},
+		{
+			name: "verified connections only",
+			user: domain.UserInfo{ProviderName: "discord", Connections: []domain.Connection{
+				{Type: "steam", ID: "7656", Verified: true},
+				{Type: "twitch", ID: "abc"},
+			}},
+			want: []string{"role:player"},
+		},
+		{
+			name: "no provider data",
+			user: domain.UserInfo{ProviderName: "discord"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.Apply(tt.user); !slices.Equal(got, tt.want) {
+				t.Errorf("Apply = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	var none Rules
+	if got := none.Apply(domain.UserInfo{ProviderName: "discord"}); got != nil {
+		t.Errorf("nil Rules granted %v", got)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, data := range []string{
+		`{`,
+		`[{"discord_role": "555"}]`,
+		`[{"role": "role:everyone"}]`,
+		`[{"role": "role:moderator", "discord_roles": "555"}]`,
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%s): expected an error", data)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roles.json")
+	if err := os.WriteFile(path, []byte(rulesJSON), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadFile(path)
+	if err != nil || len(rules) != 5 {
+		t.Fatalf("LoadFile = %d rules, %v", len(rules), err)
+	}
+	if _, err := LoadFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,120 @@
+package scope
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// Known scopes. The provider name and provider ID are always released.
+const (
+	Profile     = "profile"     // username, display name, avatar, provider data
+	Email       = "email"       // email address
+	Connections = "connections" // linked third-party accounts
+)
+
+// known lists the scopes in their canonical order.
+var known = []string{Profile, Email, Connections}
+
+// Default is granted when a client doesn't ask for anything in particular.
+// It matches what /exchange returned before scopes existed.
+const Default = Profile + " " + Email
+
+// Parse validates a space- or comma-separated scope request and returns it
+// in canonical form. An empty request yields Default.
+func Parse(raw string) (string, error) {
+	fields := strings.FieldsFunc(raw, func(r rune) bool { return r == ' ' || r == ',' })
+	if len(fields) == 0 {
+		return Default, nil
+	}
+
+	requested := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		if !slices.Contains(known, f) {
+			return "", fmt.Errorf("%w: %q", domain.ErrInvalidScope, f)
+		}
+		requested[f] = true
+	}
+
+	granted := make([]string, 0, len(requested))
+	for _, s := range known {
+		if requested[s] {
+			granted = append(granted, s)
+		}
+	}
+	return strings.Join(granted, " "), nil
+}
+
+// Has reports whether the canonical scope string granted includes s.
+// An empty granted string means Default.
+func Has(granted, s string) bool {
+	if granted == "" {
+		granted = Default
+	}
+	return slices.Contains(strings.Fields(granted), s)
+}
+
+// Filter returns user with every field outside granted cleared.
+func Filter(user domain.UserInfo, granted string) domain.UserInfo {
+	if !Has(granted, Profile) {
+		user.Username = ""
+		user.DisplayName = ""
+		user.AvatarURL = ""
+		user.Locale = ""
+		user.Country = ""
+		user.ProviderData = nil
+	}
+	if !Has(granted, Email) {
+		user.Email = ""
+		user.EmailVerified = false
+	}
+	if !Has(granted, Connections) {
+		user.Connections = nil
+	}
+	if !Has(granted, Profile) || !Has(granted, Email) {
+		user.Raw = nil
+	}
+	return user
+}
+
+// strippable maps the user fields a client can be configured never to
+// receive to a function clearing them. The provider name and provider ID
+// identify the user and can't be stripped.
+var strippable = map[string]func(*domain.UserInfo){
+	"username":       func(u *domain.UserInfo) { u.Username = "" },
+	"display_name":   func(u *domain.UserInfo) { u.DisplayName = "" },
+	"avatar_url":     func(u *domain.UserInfo) { u.AvatarURL = "" },
+	"email":          func(u *domain.UserInfo) { u.Email = ""; u.EmailVerified = false },
+	"email_verified": func(u *domain.UserInfo) { u.EmailVerified = false },
+	"locale":         func(u *domain.UserInfo) { u.Locale = "" },
+	"country":        func(u *domain.UserInfo) { u.Country = "" },
+	"connections":    func(u *domain.UserInfo) { u.Connections = nil },
+	"provider_data":  func(u *domain.UserInfo) { u.ProviderData = nil },
+	"roles":          func(u *domain.UserInfo) { u.Roles = nil },
+	"raw":            func(u *domain.UserInfo) { u.Raw = nil },
+}
+
+// CheckFields reports the first of fields that can't be stripped.
+func CheckFields(fields []string) error {
+	for _, f := range fields {
+		if _, ok := strippable[f]; !ok {
+			return fmt.Errorf("unknown user field %q", f)
+		}
+	}
+	return nil
+}
+
+// Strip returns user with fields, named by their JSON keys, cleared.
+// Stripping "email" also clears email_verified. Since the raw profile may
+// hold any of them, stripping anything also drops it.
+func Strip(user domain.UserInfo, fields []string) domain.UserInfo {
+	for _, f := range fields {
+		if clear, ok := strippable[f]; ok {
+			clear(&user)
+			user.Raw = nil
+		}
+	}
+	return user
+}
@@ -0,0 +1,140 @@
+package scope
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]string{
+		"":                          Default,
+		"email":                     "email",
+		"email profile":             "profile email",
+		"connections,profile":       "profile connections",
+		"profile profile  email":    "profile email",
+		"connections email profile": "profile email connections",
+	}
+	for in, want := range tests {
+		got, err := Parse(in)
+		if err != nil || got != want {
+			t.Errorf("Parse(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+}
+
+func TestParse_Unknown(t *testing.T) {
+	if _, err := Parse("profile guilds"); !errors.Is(err, domain.ErrInvalidScope) {
+		t.Errorf("expected ErrInvalidScope, got %v", err)
+	}
+}
+
+func TestHas(t *testing.T) {
+	if !Has("", Email) || Has("", Connections) {
+		t.Error("expected an empty scope to mean the default")
+	}
+	if !Has("profile connections", Connections) || Has("profile connections", Email) {
+		t.Error("unexpected Has result")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	user := domain.UserInfo{
+		ProviderName: "discord",
+		ProviderID:   "123",
+		Username:     "player",
+		DisplayName:  "Player",
+		AvatarURL:    "https://cdn.example.com/a.png",
+		Email:        "player@example.com",
+		Connections:  []domain.Connection{{Type: "steam", ID: "765"}},
+	}
+
+	got := Filter(user, "email")
+	if got.ProviderName != "discord" || got.ProviderID != "123" {
+		t.Error("expected the provider identity to always be released")
+	}
+	if got.Username != "" || got.DisplayName != "" || got.AvatarURL != "" {
+		t.Errorf("expected profile fields to be cleared: %+v", got)
+	}
+	if got.Email != "player@example.com" || got.Connections != nil {
+		t.Errorf("unexpected filtered user: %+v", got)
+	}
+
+	if got := Filter(user, "profile connections"); got.Email != "" || len(got.Connections) != 1 || got.Username != "player" {
+		t.Errorf("unexpected filtered user: %+v", got)
+	}
+}
+
+func TestFilter_LocaleAndCountry(t *testing.T) {
+	user := domain.UserInfo{Locale: "en-GB", Country: "GB"}
+
+	if got := Filter(user, "profile"); got.Locale != "en-GB" || got.Country != "GB" {
+		t.Errorf("expected locale and country under profile, got %+v", got)
+	}
+	if got := Filter(user, "email"); got.Locale != "" || got.Country != "" {
+		t.Errorf("expected locale and country to be withheld, got %+v", got)
+	}
+}
+
+func TestFilter_EmailVerified(t *testing.T) {
+	user := domain.UserInfo{Email: "player@example.com", EmailVerified: true}
+
+	if got := Filter(user, "email"); !got.EmailVerified {
+		t.Error("expected email_verified to be kept with the email scope")
+	}
+	if got := Filter(user, "profile"); got.EmailVerified {
+		t.Error("expected email_verified to be withheld without the email scope")
+	}
+}
+
+func TestFilter_Raw(t *testing.T) {
+	user := domain.UserInfo{ProviderName: "discord", ProviderID: "123", Raw: []byte(`{"id":"123"}`)}
+
+	if got := Filter(user, "profile email"); got.Raw == nil {
+		t.Error("expected raw to be kept under profile and email")
+	}
+	for _, granted := range []string{"profile", "email", "profile connections"} {
+		if got := Filter(user, granted); got.Raw != nil {
+			t.Errorf("%q: expected raw to be withheld", granted)
+		}
+	}
+}
+
+func TestStrip(t *testing.T) {
+	user := domain.UserInfo{
+		ProviderName:  "discord",
+		ProviderID:    "123",
+		Username:      "player",
+		Email:         "player@example.com",
+		EmailVerified: true,
+		Country:       "GB",
+		Raw:           []byte(`{"id":"123"}`),
+	}
+
+	got := Strip(user, []string{"email", "country"})
+	if got.Email != "" || got.EmailVerified || got.Country != "" {
+		t.Errorf("expected email and country stripped, got %+v", got)
+	}
+	if got.Raw != nil {
+		t.Error("expected raw to be dropped along with a stripped field")
+	}
+	if got.ProviderID != "123" || got.Username != "player" {
+		t.Errorf("expected the other fields kept, got %+v", got)
+	}
+
+	if got := Strip(user, nil); got.Raw == nil || got.Email == "" {
+		t.Errorf("expected nothing stripped, got %+v", got)
+	}
+}
+
+func TestCheckFields(t *testing.T) {
+	if err := CheckFields([]string{"email", "avatar_url", "raw"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, bad := range []string{"provider_id", "Email", "phone"} {
+		if err := CheckFields([]string{bad}); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	// renewBefore is how long before expiry a certificate is renewed.
+	renewBefore   = 30 * 24 * time.Hour
+	obtainTimeout = 5 * time.Minute
+	// retryAfter spaces out attempts after the CA failed, to stay clear of
+	// its rate limits.
+	retryAfter = time.Minute
+)
+
+// AutocertConfig obtains and renews a certificate from an ACME CA such as
+// Let's Encrypt. Challenges are answered with tls-alpn-01 on the server's own
+// listener, so it must be reachable from the internet on port 443.
+type AutocertConfig struct {
+	// Domains are the host names on the certificate. Handshakes for any
+	// other name are refused.
+	Domains []string
+
+	// Email is given to the CA for expiry notices (optional).
+	Email string
+
+	// CacheDir keeps the account key and certificate across restarts.
+	CacheDir string
+
+	// DirectoryURL is the CA's ACME directory (Let's Encrypt if empty).
+	DirectoryURL string
+}
+
+// certManager serves the certificate described by an AutocertConfig,
+// obtaining it on first use and renewing it in the background.
+type certManager struct {
+	cfg    AutocertConfig
+	client *acme.Client
+	now    func() time.Time
+
+	mu       sync.Mutex // guards the fields below; held while obtaining a first certificate
+	cert     *tls.Certificate
+	renewing bool
+	lastErr  error
+	retryAt  time.Time
+
+	chalMu     sync.Mutex
+	challenges map[string]*tls.Certificate // domain -> tls-alpn-01 certificate
+}
+
+func newCertManager(cfg AutocertConfig) *certManager {
+	if cfg.DirectoryURL == "" {
+		cfg.DirectoryURL = acme.LetsEncryptURL
+	}
+	return &certManager{
+		cfg:        cfg,
+		client:     &acme.Client{DirectoryURL: cfg.DirectoryURL, UserAgent: "centralauth"},
+		now:        time.Now,
+		challenges: make(map[string]*tls.Certificate),
+	}
+}
+
+// TLSConfig returns a TLS configuration that serves the managed certificate.
+func (m *certManager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1", acme.ALPNProto},
+	}
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (m *certManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name != "" && !slices.Contains(m.cfg.Domains, name) {
+		return nil, fmt.Errorf("autocert: host %q is not configured", hello.ServerName)
+	}
+
+	if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+		m.chalMu.Lock()
+		defer m.chalMu.Unlock()
+		if cert, ok := m.challenges[name]; ok {
+			return cert, nil
+		}
+		return nil, fmt.Errorf("autocert: no pending challenge for %q", name)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil {
+		m.cert = m.load()
+	}
+	if m.cert == nil {
+		if m.now().Before(m.retryAt) {
+			return nil, m.lastErr
+		}
+		cert, err := m.obtain()
+		if err != nil {
+			m.lastErr, m.retryAt = err, m.now().Add(retryAfter)
+			return nil, err
+		}
+		m.cert = cert
+	}
+	if m.cert.Leaf.NotAfter.Sub(m.now()) < renewBefore && !m.renewing {
+		m.renewing = true
+		go m.renew()
+	}
+	return m.cert, nil
+}
+
+// renew replaces the current certificate, which keeps being served until the
+// new one is ready.
+func (m *certManager) renew() {
+	cert, err := m.obtain()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.renewing = false
+	if err != nil {
+		slog.Warn("autocert: renewing certificate failed", "error", err)
+		return
+	}
+	m.cert = cert
+}
+
+// obtain orders a new certificate for all domains and stores it in the cache.
+func (m *certManager) obtain() (*tls.Certificate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), obtainTimeout)
+	defer cancel()
+
+	if err := m.register(ctx); err != nil {
+		return nil, err
+	}
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(m.cfg.Domains...))
+	if err != nil {
+		return nil, fmt.Errorf("autocert: creating order: %w", err)
+	}
+	for _, zurl := range order.AuthzURLs {
+		if err := m.authorize(ctx, zurl); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = m.client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, fmt.Errorf("autocert: waiting for order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("autocert: generating key: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.cfg.Domains[0]},
+		DNSNames: m.cfg.Domains,
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("autocert: creating CSR: %w", err)
+	}
+	chain, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("autocert: finalizing order: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("autocert: parsing certificate: %w", err)
+	}
+	cert := &tls.Certificate{Certificate: chain, PrivateKey: key, Leaf: leaf}
+	if err := m.save(cert); err != nil {
+		slog.Warn("autocert: caching certificate failed", "error", err)
+	}
+	slog.Info("autocert: obtained certificate", "domains", strings.Join(m.cfg.Domains, ","), "not_after", leaf.NotAfter.Format(time.RFC3339))
+	return cert, nil
+}
+
+// register loads or creates the account key and registers it with the CA.
+func (m *certManager) register(ctx context.Context) error {
+	if m.client.Key != nil {
+		return nil
+	}
+	key, err := m.accountKey()
+	if err != nil {
+		return err
+	}
+	m.client.Key = key
+	acct := &acme.Account{}
+	if m.cfg.Email != "" {
+		acct.Contact = []string{"mailto:" + m.cfg.Email}
+	}
+	if _, err := m.client.Register(ctx, acct, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		m.client.Key = nil
+		return fmt.Errorf("autocert: registering account: %w", err)
+	}
+	return nil
+}
+
+// authorize proves control of one domain by answering its tls-alpn-01
+// challenge.
+func (m *certManager) authorize(ctx context.Context, zurl string) error {
+	z, err := m.client.GetAuthorization(ctx, zurl)
+	if err != nil {
+		return fmt.Errorf("autocert: fetching authorization: %w", err)
+	}
+	if z.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == "tls-alpn-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("autocert: CA offers no tls-alpn-01 challenge for %s", z.Identifier.Value)
+	}
+	cert, err := m.client.TLSALPN01ChallengeCert(chal.Token, z.Identifier.Value)
+	if err != nil {
+		return fmt.Errorf("autocert: creating challenge certificate: %w", err)
+	}
+
+	domain := z.Identifier.Value
+	m.chalMu.Lock()
+	m.challenges[domain] = &cert
+	m.chalMu.Unlock()
+	defer func() {
+		m.chalMu.Lock()
+		delete(m.challenges, domain)
+		m.chalMu.Unlock()
+	}()
+
+	if _, err := m.client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("autocert: accepting challenge for %s: %w", domain, err)
+	}
+	if _, err := m.client.WaitAuthorization(ctx, z.URI); err != nil {
+		return fmt.Errorf("autocert: authorizing %s: %w", domain, err)
+	}
+	return nil
+}
+
+func (m *certManager) accountKey() (crypto.Signer, error) {
+	path := filepath.Join(m.cfg.CacheDir, "account.key")
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("autocert: %s is not PEM", path)
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("autocert: parsing %s: %w", path, err)
+		}
+		return key, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("autocert: generating account key: %w", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.write(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, fmt.Errorf("autocert: saving account key: %w", err)
+	}
+	return key, nil
+}
+
+// load returns the cached certificate if it still covers every domain and
+// hasn't expired.
+func (m *certManager) load() *tls.Certificate {
+	data, err := os.ReadFile(filepath.Join(m.cfg.CacheDir, "cert.pem"))
+	if err != nil {
+		return nil
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		slog.Warn("autocert: ignoring cached certificate", "error", err)
+		return nil
+	}
+	for _, d := range m.cfg.Domains {
+		if cert.Leaf.VerifyHostname(d) != nil {
+			return nil
+		}
+	}
+	if !m.now().Before(cert.Leaf.NotAfter) {
+		return nil
+	}
+	return &cert
+}
+
+// save writes cert's private key and chain to the cache as one PEM file.
+func (m *certManager) save(cert *tls.Certificate) error {
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	for _, c := range cert.Certificate {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+	}
+	return m.write(filepath.Join(m.cfg.CacheDir, "cert.pem"), data)
+}
+
+func (m *certManager) write(path string, data []byte) error {
+	if err := os.MkdirAll(m.cfg.CacheDir, 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// selfSigned returns a PEM private key and certificate for host.
+func selfSigned(t *testing.T, host string, notAfter time.Time) (keyPEM, certPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// newTestCertManager returns a manager for auth.example.com whose CA counts
+// requests and fails them all.
+func newTestCertManager(t *testing.T) (*certManager, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	ca := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(ca.Close)
+	m := newCertManager(AutocertConfig{
+		Domains:      []string{"auth.example.com"},
+		CacheDir:     t.TempDir(),
+		DirectoryURL: ca.URL,
+	})
+	return m, &calls
+}
+
+func TestCertManager_ServesCachedCertificate(t *testing.T) {
+	m, calls := newTestCertManager(t)
+	key, cert := selfSigned(t, "auth.example.com", time.Now().Add(60*24*time.Hour))
+	os.WriteFile(filepath.Join(m.cfg.CacheDir, "cert.pem"), append(key, cert...), 0o600)
+
+	got, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "auth.example.com"})
+	if err != nil {
+		t.Fatalf("GetCertificate error: %v", err)
+	}
+	if got.Leaf.Subject.CommonName != "auth.example.com" {
+		t.Errorf("unexpected certificate for %q", got.Leaf.Subject.CommonName)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("expected no CA requests, got %d", calls.Load())
+	}
+}
+
+func TestCertManager_RejectsUnknownHost(t *testing.T) {
+	m, _ := newTestCertManager(t)
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "evil.example.com"}); err == nil {
+		t.Error("expected an error for a host that isn't configured")
+	}
+}
+
+func TestCertManager_ServesChallenge(t *testing.T) {
+	m, _ := newTestCertManager(t)
+	hello := &tls.ClientHelloInfo{ServerName: "auth.example.com", SupportedProtos: []string{acme.ALPNProto}}
+
+	if _, err := m.GetCertificate(hello); err == nil {
+		t.Error("expected an error without a pending challenge")
+	}
+	chal := &tls.Certificate{}
+	m.challenges["auth.example.com"] = chal
+	if got, err := m.GetCertificate(hello); err != nil || got != chal {
+		t.Errorf("expected the challenge certificate, got %v, %v", got, err)
+	}
+}
+
+func TestCertManager_BacksOffAfterFailure(t *testing.T) {
+	m, calls := newTestCertManager(t)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	// An expired cached certificate isn't served
+	key, cert := selfSigned(t, "auth.example.com", now.Add(-time.Hour))
+	os.WriteFile(filepath.Join(m.cfg.CacheDir, "cert.pem"), append(key, cert...), 0o600)
+
+	hello := &tls.ClientHelloInfo{ServerName: "auth.example.com"}
+	if _, err := m.GetCertificate(hello); err == nil {
+		t.Fatal("expected an error while the CA is down")
+	}
+	first := calls.Load()
+	if first == 0 {
+		t.Fatal("expected the CA to be asked for a certificate")
+	}
+
+	if _, err := m.GetCertificate(hello); err == nil {
+		t.Fatal("expected the last error again")
+	}
+	if calls.Load() != first {
+		t.Errorf("expected no new CA requests within %s, got %d", retryAfter, calls.Load()-first)
+	}
+
+	now = now.Add(retryAfter)
+	m.GetCertificate(hello)
+	if calls.Load() == first {
+		t.Error("expected another attempt once the backoff passed")
+	}
+}
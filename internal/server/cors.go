@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/handler"
+	"github.com/BlackMission/centralauth/internal/logging"
+)
+
+// corsMaxAge is how long, in seconds, browsers may cache a preflight
+// response.
+const corsMaxAge = "600"
+
+var (
+	corsAllowedHeaders = strings.Join([]string{
+		"Authorization", "Content-Type", handler.IdempotencyKeyHeader, logging.RequestIDHeader, "traceparent",
+	}, ", ")
+	corsExposedHeaders = strings.Join([]string{
+		logging.RequestIDHeader, "Retry-After", "Idempotent-Replayed", "WWW-Authenticate",
+	}, ", ")
+)
+
+// corsMiddleware lets browser apps call the API from the origins of the
+// clients' allowed callbacks, and from origins. Preflight requests from
+// those origins are answered here; requests from any other origin are
+// served without CORS headers, so browsers keep their responses from the
+// page. The admin API is never opened to other origins.
+//
+// Requests are authenticated with API keys or PKCE, not cookies, so
+// credentialed requests are not allowed.
+func corsMiddleware(clients *client.Registry, origins []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" || strings.HasPrefix(r.URL.Path, "/admin/") ||
+			(!slices.Contains(origins, strings.ToLower(origin)) && !clients.AllowsOrigin(origin)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+		w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/state"
+)
+
+func setupCORSServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "spa", Name: "SPA", Public: true, AllowedCallbacks: []string{"https://app.example.com/callback"}},
+	})
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	srv := New(Config{Host: "127.0.0.1", CORS: true, CORSOrigins: []string{"https://docs.example.com"}, AdminAPIKey: "admin-key"}, Deps{
+		Clients: clients, Providers: auth.NewRegistry(), State: state.NewService([]byte("key-1234567890abcdef12345678")), Exchange: codec,
+	})
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func corsRequest(t *testing.T, method, url, origin string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, nil)
+	req.Header.Set("Origin", origin)
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestCORS_ClientOrigin(t *testing.T) {
+	ts := setupCORSServer(t)
+
+	resp := corsRequest(t, http.MethodOptions, ts.URL+"/providers", "https://app.example.com")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("preflight status = %d, want 204", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q", got)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Authorization") {
+		t.Errorf("Access-Control-Allow-Headers = %q", got)
+	}
+
+	resp = corsRequest(t, http.MethodGet, ts.URL+"/providers", "https://app.example.com")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("GET status = %d, Access-Control-Allow-Origin = %q", resp.StatusCode, resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+	if got := resp.Header.Get("Access-Control-Expose-Headers"); !strings.Contains(got, "X-Request-ID") {
+		t.Errorf("Access-Control-Expose-Headers = %q", got)
+	}
+	if got := resp.Header.Values("Vary"); len(got) == 0 || got[0] != "Origin" {
+		t.Errorf("Vary = %q", got)
+	}
+}
+
+func TestCORS_ConfiguredOrigin(t *testing.T) {
+	ts := setupCORSServer(t)
+	resp := corsRequest(t, http.MethodGet, ts.URL+"/providers", "https://docs.example.com")
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://docs.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q", got)
+	}
+}
+
+func TestCORS_RefusesOtherOrigins(t *testing.T) {
+	ts := setupCORSServer(t)
+
+	for _, tt := range []struct {
+		method, path, origin string
+	}{
+		{http.MethodGet, "/providers", "https://evil.example.com"},
+		{http.MethodOptions, "/providers", "https://evil.example.com"},
+		{http.MethodOptions, "/admin/drain", "https://app.example.com"},
+	} {
+		resp := corsRequest(t, tt.method, ts.URL+tt.path, tt.origin)
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("%s %s from %s: Access-Control-Allow-Origin = %q", tt.method, tt.path, tt.origin, got)
+		}
+	}
+}
+
+func TestCORS_Disabled(t *testing.T) {
+	ts, _, _ := setupTestServer()
+	defer ts.Close()
+	resp := corsRequest(t, http.MethodGet, ts.URL+"/providers", "https://example.com")
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q without CORS enabled", got)
+	}
+}
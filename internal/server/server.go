@@ -1,24 +1,133 @@
 package server
 
 import (
+	"cmp"
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/http/pprof"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/BlackMission/centralauth/internal/audit"
 	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/ban"
+	"github.com/BlackMission/centralauth/internal/captcha"
 	"github.com/BlackMission/centralauth/internal/client"
+	"github.com/BlackMission/centralauth/internal/device"
+	"github.com/BlackMission/centralauth/internal/drain"
+	"github.com/BlackMission/centralauth/internal/events"
 	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/geoip"
 	"github.com/BlackMission/centralauth/internal/handler"
+	"github.com/BlackMission/centralauth/internal/hook"
+	"github.com/BlackMission/centralauth/internal/idempotency"
+	"github.com/BlackMission/centralauth/internal/identity"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/internal/lockout"
+	"github.com/BlackMission/centralauth/internal/logging"
+	"github.com/BlackMission/centralauth/internal/logout"
+	"github.com/BlackMission/centralauth/internal/maintenance"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/internal/mfa"
+	"github.com/BlackMission/centralauth/internal/ratelimit"
+	"github.com/BlackMission/centralauth/internal/refresh"
+	"github.com/BlackMission/centralauth/internal/roles"
+	"github.com/BlackMission/centralauth/internal/session"
 	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/store"
+	"github.com/BlackMission/centralauth/internal/ticket"
+	"github.com/BlackMission/centralauth/internal/tracing"
 )
 
 // Config holds the server configuration.
 type Config struct {
 	Host string
 	Port int
+
+	// Region labels this instance; it is sent as the X-CentralAuth-Region header.
+	Region string
+
+	// BasePath prefixes every route, e.g. /authsvc behind a reverse proxy
+	// that forwards https://example.com/authsvc/ unchanged.
+	BasePath string
+
+	// IPRateLimits limit the browser-facing auth routes by client IP and
+	// overall. They are enforced with Deps.RateLimiter.
+	IPRateLimits handler.IPLimits
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For header
+	// names the client IP, for rate limits and binding exchange codes.
+	TrustedProxies []netip.Prefix
+
+	// BlockedCountries are the ISO 3166-1 alpha-2 codes of the countries no
+	// one may start signing in from, as located by Deps.GeoIP. Clients can
+	// block more with their own BlockedCountries.
+	BlockedCountries []string
+
+	// OIDC serves the OpenID Connect provider endpoints when Deps.IDTokens
+	// is set. They, and the device grant's, are advertised under PublicURL.
+	OIDC      bool
+	PublicURL string
+
+	// CORS lets browser apps call the API from the origins of the clients'
+	// allowed callbacks, and from CORSOrigins, e.g. "https://app.example.com".
+	CORS        bool
+	CORSOrigins []string
+
+	// MaxQueryBytes refuses longer query strings with 414, and
+	// MaxHeaderBytes bounds the request line and headers, which are refused
+	// with 431 past it. Zero leaves either unbounded, or at net/http's
+	// default for headers.
+	MaxQueryBytes  int
+	MaxHeaderBytes int
+
+	// AdminAPIKey enables the /admin endpoints when set.
+	AdminAPIKey string
+
+	// ClientCerts asks clients for a certificate when serving HTTPS, so that
+	// API clients can authenticate with one. AdminCertFingerprints, when set,
+	// are the only certificates the /admin endpoints accept requests from,
+	// along with the admin API key.
+	ClientCerts           bool
+	AdminCertFingerprints []string
+
+	// AdminPort, when set, moves /metrics and the /admin endpoints to a
+	// second plain-HTTP listener on AdminHost, so the public listener
+	// serves none of them.
+	AdminHost string
+	AdminPort int
+
+	// Debug serves /debug/pprof and /debug/runtime on the admin listener.
+	Debug bool
+
+	// ShutdownDelay is how long Shutdown keeps serving with /readyz
+	// failing and new sign-ins refused, so load balancers stop sending
+	// requests and flows in progress come back for their callbacks before
+	// the listener closes.
+	ShutdownDelay time.Duration
+
+	// DrainTimeout bounds how long Shutdown then waits for requests in
+	// flight, such as callbacks and exchanges, before cutting their
+	// connections. Zero waits as long as Shutdown's context allows.
+	DrainTimeout time.Duration
+
+	// TLSCertFile and TLSKeyFile make the server speak HTTPS with this
+	// certificate and key (PEM files).
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Autocert makes the server speak HTTPS with a certificate it obtains
+	// itself (optional; ignored when TLSCertFile is set).
+	Autocert *AutocertConfig
 }
 
 // Deps holds the service dependencies.
@@ -27,37 +136,320 @@ type Deps struct {
 	Providers *auth.Registry
 	State     *state.Service
 	Exchange  *exchange.Codec
+
+	// Idempotency caches /exchange results for retried requests (optional).
+	Idempotency *idempotency.Cache
+
+	// Redeemed records spent exchange codes so each is redeemed once
+	// (optional).
+	Redeemed store.Store
+
+	// IDTokens signs /exchange results for clients that ask for them as
+	// JWTs (optional).
+	IDTokens *idtoken.Issuer
+
+	// Devices serves the device authorization grant when set, with
+	// IDTokens.
+	Devices *device.Service
+
+	// Identities gives each user a stable central ID, returned from
+	// /exchange and /token (optional).
+	Identities *identity.Store
+
+	// Bans refuses sign-ins to the users on it, and is managed through the
+	// admin API (optional).
+	Bans *ban.List
+
+	// Roles grants users application roles at sign-in (optional).
+	Roles roles.Rules
+
+	// Hooks run on each sign-in before the client is given a code, and may
+	// change the result or refuse it (optional).
+	Hooks hook.Chain
+
+	// Captcha puts a CAPTCHA on the provider chooser and device pages of
+	// the clients that require one (optional).
+	Captcha *captcha.Verifier
+
+	// CaptchaPasses signs the passes the provider chooser gives browsers
+	// that solved its CAPTCHA, which /auth/{provider} requires of the
+	// clients that require one. Required with Captcha.
+	CaptchaPasses *ticket.Signer
+
+	// Refresh issues refresh tokens to the clients that use them and serves
+	// /token/refresh and /token/revoke (optional).
+	Refresh *refresh.Service
+
+	// Drain stops new auth flows from starting when enabled. Optional; a
+	// switch in the off position is created when nil.
+	Drain *drain.Switch
+
+	// Maintenance stops anyone from signing in when enabled. Optional; a
+	// mode that is off is created when nil.
+	Maintenance *maintenance.Mode
+
+	// GeoIP locates the address each request came from, for events, audit
+	// entries and Config.BlockedCountries (optional).
+	GeoIP *geoip.DB
+
+	// Limiter bounds concurrent provider exchanges (optional).
+	Limiter *auth.Limiter
+
+	// RateLimiter enforces each client's rate limit and the IP rate limits
+	// (optional).
+	RateLimiter *ratelimit.Limiter
+
+	// Lockouts lock out callers of the API key endpoints that keep
+	// presenting invalid codes, API keys or client certificates (optional).
+	Lockouts *lockout.Tracker
+
+	// Metrics is served on /metrics when set.
+	Metrics *metrics.Registry
+
+	// Funnel counts flows through each auth stage (optional).
+	Funnel *metrics.Funnel
+
+	// Events publishes sign-ins and code exchanges to a message broker
+	// (optional).
+	Events *events.Bus
+
+	// MFA enables acr=2fa and the /mfa pages when set.
+	MFA *mfa.Service
+
+	// Sessions keeps users signed in to CentralAuth between clients, and
+	// serves /logout, when set.
+	Sessions *session.Service
+
+	// Audit records admin actions. Optional; an in-memory log is created
+	// when nil.
+	Audit *audit.Log
+
+	// Tracer records a span for each sampled request, with the provider
+	// calls made while serving it (optional).
+	Tracer *tracing.Tracer
 }
 
-// Server wraps the HTTP server and router.
+// Server wraps the HTTP server and router, and the admin listener's.
 type Server struct {
 	httpServer *http.Server
 	handler    http.Handler
+
+	adminServer *http.Server // nil without an admin port
+
+	// stopping fails /readyz once Shutdown is called
+	stopping      *atomic.Bool
+	drain         *drain.Switch
+	shutdownDelay time.Duration
+	drainTimeout  time.Duration
+
+	certFile, keyFile string
 }
 
 // New creates a new Server with all routes wired.
 func New(cfg Config, deps Deps) *Server {
+	if deps.Drain == nil {
+		deps.Drain = drain.NewSwitch()
+	}
+	if deps.Maintenance == nil {
+		deps.Maintenance = maintenance.NewMode()
+	}
+	if deps.Audit == nil {
+		deps.Audit = audit.NewLog(0)
+	}
+
 	mux := http.NewServeMux()
+	health := auth.NewHealthChecker(deps.Providers)
+	stopping := new(atomic.Bool)
+	readyChecks := make(map[string]handler.ReadyCheck)
+	if p, ok := deps.Redeemed.(store.Pinger); ok {
+		readyChecks["store"] = p.Ping
+	}
+	if deps.RateLimiter != nil {
+		readyChecks["rate_limit_store"] = deps.RateLimiter.Ping
+	}
+	if deps.Identities != nil {
+		readyChecks["identity_db"] = deps.Identities.Ping
+	}
 
-	mux.HandleFunc("GET /health", handler.Health())
-	mux.HandleFunc("GET /auth/{provider}", handler.Authorize(deps.Clients, deps.Providers, deps.State))
-	mux.HandleFunc("GET /callback/{provider}", handler.Callback(deps.Providers, deps.State, deps.Exchange))
-	mux.HandleFunc("GET /exchange", handler.Exchange(deps.Clients, deps.Exchange))
+	mux.HandleFunc("GET /health", handler.Health(health))
+	mux.HandleFunc("GET /health/providers", handler.HealthProviders(health))
+	mux.HandleFunc("GET /livez", handler.Health(nil))
+	mux.HandleFunc("GET /readyz", handler.Ready(deps.Providers, stopping.Load, readyChecks))
+	perClient := func(h http.HandlerFunc) http.HandlerFunc {
+		return handler.APIKeyRateLimited(deps.Clients, deps.RateLimiter, h)
+	}
+	perIP := func(h http.HandlerFunc) http.HandlerFunc {
+		return handler.IPRateLimited(cfg.IPRateLimits, deps.RateLimiter, h)
+	}
+	throttled := func(h http.HandlerFunc) http.HandlerFunc {
+		return handler.Throttled(deps.Lockouts, deps.Events, h)
+	}
+	signIn := func(h http.HandlerFunc) http.HandlerFunc {
+		return handler.Maintainable(deps.Maintenance, h)
+	}
+	// Sign-ins started from a browser, rather than by a client's server
+	browser := func(h http.HandlerFunc) http.HandlerFunc {
+		return signIn(handler.GeoBlocked(cfg.BlockedCountries, deps.Clients, h))
+	}
+	mux.HandleFunc("GET /auth/{provider}", browser(handler.Drainable(deps.Drain, perIP(handler.ClientRateLimited(deps.Clients, deps.RateLimiter,
+		handler.Authorize(deps.Clients, deps.Providers, deps.State, deps.Funnel, deps.Events, deps.MFA, deps.Exchange, deps.Sessions, deps.Bans, deps.Hooks, deps.Captcha, deps.CaptchaPasses))))))
+	mux.HandleFunc("GET /auth", browser(perIP(handler.ChooseProvider(deps.Clients, deps.Providers, deps.Sessions, deps.Captcha))))
+	mux.HandleFunc("POST /auth", browser(perIP(handler.ProviderChosen(deps.Clients, deps.Providers, deps.Captcha, deps.CaptchaPasses))))
+	mux.HandleFunc("GET /callback/{provider}", perIP(handler.Callback(deps.Providers, deps.State, deps.Exchange, deps.Limiter, deps.Funnel, deps.Events, deps.MFA, deps.Sessions, deps.Bans, deps.Roles, deps.Hooks)))
+	mux.HandleFunc("POST /auth/{provider}/ticket", signIn(throttled(perClient(handler.Ticket(deps.Clients, deps.Providers, deps.Exchange, deps.Limiter, deps.Funnel, deps.Events, deps.Bans, deps.Roles, deps.Hooks)))))
+	mux.HandleFunc("POST /auth/{provider}/lookup", throttled(perClient(handler.Lookup(deps.Clients, deps.Providers, deps.Limiter))))
+	mux.HandleFunc("GET /exchange", throttled(perClient(handler.Exchange(deps.Clients, deps.Exchange, deps.Idempotency, deps.Redeemed, deps.IDTokens, deps.Refresh, deps.Funnel, deps.Events, deps.Identities))))
 	mux.HandleFunc("GET /providers", handler.Providers(deps.Providers))
+	if deps.Identities != nil {
+		mux.HandleFunc("GET /users/{central_id}", throttled(perClient(handler.User(deps.Clients, deps.Identities))))
+		mux.HandleFunc("GET /users/by-provider/{provider}/{provider_id}", throttled(perClient(handler.UserByProvider(deps.Clients, deps.Identities))))
+		mux.HandleFunc("GET /users/{central_id}/logins", throttled(perClient(handler.UserLogins(deps.Clients, deps.Identities))))
+	}
+	if deps.IDTokens != nil {
+		mux.HandleFunc("GET /.well-known/jwks.json", handler.JWKS(deps.IDTokens))
+	}
+	if deps.IDTokens == nil {
+		deps.Devices = nil
+	}
+	if cfg.OIDC && deps.IDTokens != nil {
+		mux.HandleFunc("GET /.well-known/openid-configuration", handler.OIDCDiscovery(deps.IDTokens, deps.Devices, cfg.PublicURL))
+		authorize := browser(handler.Drainable(deps.Drain, perIP(handler.ClientRateLimited(deps.Clients, deps.RateLimiter,
+			handler.OIDCAuthorize(deps.Clients, deps.Providers, deps.State, deps.Funnel, deps.Events, deps.Exchange, deps.Sessions, deps.Bans, deps.Hooks, deps.Captcha)))))
+		mux.HandleFunc("GET /authorize", authorize)
+		mux.HandleFunc("POST /authorize", authorize)
+		userInfo := handler.OIDCUserInfo(deps.IDTokens)
+		mux.HandleFunc("GET /userinfo", userInfo)
+		mux.HandleFunc("POST /userinfo", userInfo)
+	}
+	if deps.Devices != nil {
+		mux.HandleFunc("POST /device/code", perIP(handler.DeviceCode(deps.Clients, deps.Devices, cfg.PublicURL)))
+		mux.HandleFunc("GET /device", perIP(handler.DevicePage(deps.Clients, deps.Providers, deps.Devices, deps.Captcha)))
+		mux.HandleFunc("POST /device", browser(handler.Drainable(deps.Drain, perIP(
+			handler.DeviceStart(deps.Clients, deps.Providers, deps.State, deps.Devices, deps.Funnel, cfg.PublicURL, deps.Captcha)))))
+		mux.HandleFunc("GET /device/complete", perIP(handler.DeviceComplete(deps.Exchange, deps.Devices, deps.Funnel, deps.Events)))
+	}
+	if deps.IDTokens != nil {
+		mux.HandleFunc("POST /token", throttled(perIP(handler.Token(deps.Clients, deps.Exchange, deps.Redeemed, deps.IDTokens, deps.Refresh, deps.Devices, deps.Funnel, deps.Events, deps.Identities, deps.Bans))))
+	}
+	if deps.Refresh != nil {
+		mux.HandleFunc("POST /token/refresh", throttled(perClient(handler.RefreshToken(deps.Clients, deps.Refresh, deps.IDTokens, deps.Bans))))
+		mux.HandleFunc("POST /token/revoke", throttled(perClient(handler.RevokeToken(deps.Clients, deps.Refresh))))
+	}
+	if deps.Sessions != nil {
+		// Back-channel logout tokens are signed like identity tokens
+		var notifier *logout.Notifier
+		if deps.IDTokens != nil {
+			notifier = logout.New(deps.IDTokens)
+		}
+		mux.HandleFunc("GET /logout", perIP(handler.Logout(deps.Clients, deps.Sessions, notifier)))
+	}
+	if deps.MFA != nil {
+		mux.HandleFunc("GET /mfa/totp", handler.TOTPPrompt(deps.MFA))
+		mux.HandleFunc("POST /mfa/totp", handler.TOTPVerify(deps.MFA, deps.Exchange, deps.Funnel, deps.Events, deps.Sessions))
+	}
+	for _, p := range deps.Providers.All() {
+		if rp, ok := p.(auth.RouteProvider); ok {
+			rp.RegisterRoutes(mux)
+		}
+	}
 
-	logged := loggingMiddleware(mux)
+	// Operational endpoints go on the admin listener when there is one
+	ops := mux
+	if cfg.AdminPort != 0 {
+		ops = http.NewServeMux()
+	}
+	if cfg.AdminPort != 0 && cfg.Debug {
+		ops.HandleFunc("GET /debug/pprof/", pprof.Index)
+		ops.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+		ops.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+		ops.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+		ops.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+		ops.HandleFunc("GET /debug/runtime", handler.RuntimeStats(time.Now()))
+	}
+	if deps.Metrics != nil {
+		ops.Handle("GET /metrics", deps.Metrics.Handler())
+	}
+
+	if cfg.AdminAPIKey != "" {
+		admin := func(h http.HandlerFunc) http.Handler {
+			return handler.AdminAuth(cfg.AdminAPIKey, cfg.AdminCertFingerprints, h)
+		}
+		ops.Handle("GET /admin/drain", admin(handler.DrainStatus(deps.Drain)))
+		ops.Handle("POST /admin/drain", admin(handler.StartDrain(deps.Drain, deps.Audit)))
+		ops.Handle("DELETE /admin/drain", admin(handler.StopDrain(deps.Drain, deps.Audit)))
+		ops.Handle("GET /admin/maintenance", admin(handler.MaintenanceStatus(deps.Maintenance)))
+		ops.Handle("POST /admin/maintenance", admin(handler.StartMaintenance(deps.Maintenance, deps.Audit)))
+		ops.Handle("DELETE /admin/maintenance", admin(handler.StopMaintenance(deps.Maintenance, deps.Audit)))
+		ops.Handle("GET /admin/clients/deleted", admin(handler.DeletedClients(deps.Clients)))
+		ops.Handle("DELETE /admin/clients/{id}", admin(handler.DeleteClient(deps.Clients, deps.Audit)))
+		ops.Handle("POST /admin/clients/{id}/restore", admin(handler.RestoreClient(deps.Clients, deps.Audit)))
+		ops.Handle("GET /admin/clients/disabled", admin(handler.DisabledClients(deps.Clients)))
+		ops.Handle("POST /admin/clients/{id}/disable", admin(handler.DisableClient(deps.Clients, deps.Audit)))
+		ops.Handle("POST /admin/clients/{id}/enable", admin(handler.EnableClient(deps.Clients, deps.Audit)))
+		ops.Handle("POST /admin/clients/{id}/rotate-key", admin(handler.RotateClientKey(deps.Clients, deps.Audit)))
+		ops.Handle("POST /admin/state/revoke", admin(handler.RevokeState(deps.State, deps.Audit)))
+		ops.Handle("GET /admin/audit", admin(handler.AuditEvents(deps.Audit)))
+		if deps.Identities != nil {
+			ops.Handle("DELETE /admin/users/{central_id}/identities/{provider}/{provider_id}", admin(handler.UnlinkIdentity(deps.Identities, deps.Audit)))
+			ops.Handle("POST /admin/users/{central_id}/merge", admin(handler.MergeUsers(deps.Identities, deps.Audit)))
+			ops.Handle("GET /admin/users/{central_id}/logins", admin(handler.AdminUserLogins(deps.Identities)))
+		}
+		if deps.Bans != nil {
+			ops.Handle("GET /admin/bans", admin(handler.Bans(deps.Bans)))
+			ops.Handle("POST /admin/bans", admin(handler.AddBan(deps.Bans, deps.Audit)))
+			ops.Handle("DELETE /admin/bans/{id}", admin(handler.RemoveBan(deps.Bans, deps.Audit)))
+		}
+	}
+
+	routes := handler.QueryLimited(cfg.MaxQueryBytes, handler.MethodChecked(mux, tracingMiddleware(deps.Tracer, mux)))
+	if cfg.CORS {
+		routes = corsMiddleware(deps.Clients, cfg.CORSOrigins, routes)
+	}
+	if cfg.BasePath != "" {
+		routes = http.StripPrefix(cfg.BasePath, routes)
+	}
+	logged := requestIDMiddleware(loggingMiddleware(regionMiddleware(cfg.Region, handler.ClientIP(cfg.TrustedProxies, handler.Located(deps.GeoIP, routes)))))
 
 	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
-	return &Server{
+	s := &Server{
 		handler: logged,
 		httpServer: &http.Server{
-			Addr:         addr,
-			Handler:      logged,
-			ReadTimeout:  10 * time.Second,
-			WriteTimeout: 10 * time.Second,
-			IdleTimeout:  60 * time.Second,
+			Addr:           addr,
+			Handler:        logged,
+			ReadTimeout:    10 * time.Second,
+			WriteTimeout:   10 * time.Second,
+			IdleTimeout:    60 * time.Second,
+			MaxHeaderBytes: cfg.MaxHeaderBytes,
 		},
+		certFile:      cfg.TLSCertFile,
+		keyFile:       cfg.TLSKeyFile,
+		stopping:      stopping,
+		drain:         deps.Drain,
+		shutdownDelay: cfg.ShutdownDelay,
+		drainTimeout:  cfg.DrainTimeout,
+	}
+	if cfg.TLSCertFile == "" && cfg.Autocert != nil {
+		s.httpServer.TLSConfig = newCertManager(*cfg.Autocert).TLSConfig()
 	}
+	if cfg.ClientCerts && (cfg.TLSCertFile != "" || s.httpServer.TLSConfig != nil) {
+		if s.httpServer.TLSConfig == nil {
+			s.httpServer.TLSConfig = &tls.Config{}
+		}
+		// Certificates are pinned by fingerprint, so any is requested and
+		// none is required: browsers on the same listener go without
+		s.httpServer.TLSConfig.ClientAuth = tls.RequestClientCert
+	}
+	if cfg.AdminPort != 0 {
+		s.adminServer = &http.Server{
+			Addr:        net.JoinHostPort(cfg.AdminHost, strconv.Itoa(cfg.AdminPort)),
+			Handler:     requestIDMiddleware(loggingMiddleware(handler.MethodChecked(ops, ops))),
+			ReadTimeout: 10 * time.Second,
+			// Long enough for a CPU profile or execution trace
+			WriteTimeout: 2 * time.Minute,
+			IdleTimeout:  60 * time.Second,
+		}
+	}
+	return s
 }
 
 // Handler returns the server's HTTP handler (for testing).
@@ -65,19 +457,86 @@ func (s *Server) Handler() http.Handler {
 	return s.handler
 }
 
-// Start begins listening and serving.
+// AdminHandler returns the admin listener's HTTP handler, or nil without an
+// admin port (for testing).
+func (s *Server) AdminHandler() http.Handler {
+	if s.adminServer == nil {
+		return nil
+	}
+	return s.adminServer.Handler
+}
+
+// Start begins listening and serving, on the admin port too if there is
+// one. It returns when either listener stops.
 func (s *Server) Start() error {
 	ln, err := net.Listen("tcp", s.httpServer.Addr)
 	if err != nil {
 		return fmt.Errorf("listening on %s: %w", s.httpServer.Addr, err)
 	}
-	log.Printf("CentralAuth listening on %s", s.httpServer.Addr)
+	if s.adminServer == nil {
+		return s.Serve(ln)
+	}
+	adminLn, err := net.Listen("tcp", s.adminServer.Addr)
+	if err != nil {
+		ln.Close()
+		return fmt.Errorf("listening on %s: %w", s.adminServer.Addr, err)
+	}
+	errc := make(chan error, 2)
+	go func() { errc <- s.Serve(ln) }()
+	go func() { errc <- s.ServeAdmin(adminLn) }()
+	return <-errc
+}
+
+// ServeAdmin serves the admin endpoints on ln, in plain HTTP.
+func (s *Server) ServeAdmin(ln net.Listener) error {
+	slog.Info("admin listening", "addr", ln.Addr().String())
+	return s.adminServer.Serve(ln)
+}
+
+// Serve accepts connections on ln, over TLS if the server is configured for it.
+func (s *Server) Serve(ln net.Listener) error {
+	if s.certFile != "" || s.httpServer.TLSConfig != nil {
+		slog.Info("CentralAuth listening", "addr", ln.Addr().String(), "tls", true)
+		return s.httpServer.ServeTLS(ln, s.certFile, s.keyFile)
+	}
+	slog.Info("CentralAuth listening", "addr", ln.Addr().String())
 	return s.httpServer.Serve(ln)
 }
 
-// Shutdown gracefully shuts down the server.
+// Shutdown gracefully shuts down the server and the admin listener, in
+// stages: it fails /readyz and turns drain mode on, so new sign-ins are
+// refused with 503 and Retry-After, and keeps serving for the shutdown
+// delay. It then closes the listeners and waits up to the drain timeout for
+// the requests in flight, after which it closes their connections and
+// returns an error.
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.httpServer.Shutdown(ctx)
+	s.stopping.Store(true)
+	s.drain.Enable()
+	if s.shutdownDelay > 0 {
+		slog.Info("refusing new sign-ins; waiting before closing the listener", "delay", s.shutdownDelay)
+		select {
+		case <-time.After(s.shutdownDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	if s.drainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.drainTimeout)
+		defer cancel()
+	}
+	servers := []*http.Server{s.httpServer}
+	if s.adminServer != nil {
+		servers = append(servers, s.adminServer)
+	}
+	var errs []error
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+			errs = append(errs, fmt.Errorf("requests still in flight on %s were cut off: %w", srv.Addr, err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func loggingMiddleware(next http.Handler) http.Handler {
@@ -85,7 +544,78 @@ func loggingMiddleware(next http.Handler) http.Handler {
 		start := time.Now()
 		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
 		next.ServeHTTP(sw, r)
-		log.Printf("%s %s %d %s", r.Method, r.URL.Path, sw.status, time.Since(start))
+		slog.InfoContext(r.Context(), "request",
+			"method", r.Method, "path", r.URL.Path, "status", sw.status, "duration", time.Since(start))
+	})
+}
+
+// tracingMiddleware starts a span for each request mux serves, named for
+// the route it matches, and tags the request's log records with its trace.
+func tracingMiddleware(tracer *tracing.Tracer, mux *http.ServeMux) http.Handler {
+	if tracer == nil {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		ctx, span := tracer.StartRequest(r.Context(), cmp.Or(route, r.Method), r.Header)
+		if span == nil {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(sw, r.WithContext(logging.With(ctx, "trace_id", span.TraceID())))
+		span.SetAttributes("http.request.method", r.Method, "http.route", route, "http.response.status_code", sw.status)
+		if sw.status >= 500 {
+			span.SetError(errors.New(http.StatusText(sw.status)))
+		}
+		span.End()
+	})
+}
+
+// maxRequestIDLength bounds the request IDs taken from clients.
+const maxRequestIDLength = 128
+
+// requestIDMiddleware gives each request an ID: the one it arrived with, if
+// safe to use, or a new one. The ID is returned in the response header and
+// error bodies, carried by the request's log records, and sent on to the
+// providers called while serving it.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(logging.RequestIDHeader)
+		if !validRequestID(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID reports whether id is safe to echo and log: short, and
+// printable ASCII without spaces.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RegionHeader identifies the region that served a response.
+const RegionHeader = "X-CentralAuth-Region"
+
+func regionMiddleware(region string, next http.Handler) http.Handler {
+	if region == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(RegionHeader, region)
+		next.ServeHTTP(w, r)
 	})
 }
 
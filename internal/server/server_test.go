@@ -1,20 +1,40 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/BlackMission/centralauth/internal/auth"
 	"github.com/BlackMission/centralauth/internal/client"
 	"github.com/BlackMission/centralauth/internal/domain"
 	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/handler"
+	"github.com/BlackMission/centralauth/internal/idtoken"
+	"github.com/BlackMission/centralauth/internal/lockout"
+	"github.com/BlackMission/centralauth/internal/logging"
+	"github.com/BlackMission/centralauth/internal/metrics"
+	"github.com/BlackMission/centralauth/internal/ratelimit"
+	"github.com/BlackMission/centralauth/internal/refresh"
 	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/store"
+	"github.com/BlackMission/centralauth/internal/tracing"
+	"github.com/BlackMission/centralauth/pkg/testutil"
 )
 
 // fakeProvider allows full control over Exchange results for integration tests.
@@ -99,7 +119,10 @@ func TestIntegration_ProvidersEndpoint(t *testing.T) {
 	ts := httptest.NewServer(srv.Handler())
 	defer ts.Close()
 
-	resp, _ := http.Get(ts.URL + "/providers")
+	resp, err := http.Get(ts.URL + "/providers")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
 	defer resp.Body.Close()
 
 	var names []string
@@ -264,3 +287,730 @@ func TestIntegration_GracefulShutdown(t *testing.T) {
 		t.Errorf("shutdown error: %v", err)
 	}
 }
+
+func TestIntegration_TLSCertFiles(t *testing.T) {
+	dir := t.TempDir()
+	key, cert := selfSigned(t, "localhost", time.Now().Add(time.Hour))
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, cert, 0o600)
+	os.WriteFile(keyFile, key, 0o600)
+
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "t", Name: "T", APIKey: "k"},
+	})
+	stateSvc := state.NewService([]byte("key-1234567890abcdef12345678"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+
+	srv := New(Config{Host: "127.0.0.1", Port: 0, TLSCertFile: certFile, TLSKeyFile: keyFile}, Deps{
+		Clients: clients, Providers: auth.NewRegistry(), State: stateSvc, Exchange: codec,
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	go srv.Serve(ln)
+	defer srv.Shutdown(context.Background())
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(cert)
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	resp, err := httpClient.Get("https://localhost:" + port + "/health")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_ClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	key, cert := selfSigned(t, "localhost", time.Now().Add(time.Hour))
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, cert, 0o600)
+	os.WriteFile(keyFile, key, 0o600)
+
+	clientKey, clientCert := selfSigned(t, "backend", time.Now().Add(time.Hour))
+	pair, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(pair.Certificate[0])
+
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "t", Name: "T", APIKey: "k", CertFingerprints: []string{client.CertFingerprint(leaf)}},
+	})
+	stateSvc := state.NewService([]byte("key-1234567890abcdef12345678"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+
+	srv := New(Config{Host: "127.0.0.1", Port: 0, TLSCertFile: certFile, TLSKeyFile: keyFile, ClientCerts: true,
+		AdminAPIKey: "admin-key", AdminCertFingerprints: []string{client.CertFingerprint(leaf)}}, Deps{
+		Clients: clients, Providers: auth.NewRegistry(), State: stateSvc, Exchange: codec,
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	go srv.Serve(ln)
+	defer srv.Shutdown(context.Background())
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(cert)
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	do := func(path string, certs []tls.Certificate, headers map[string]string) int {
+		t.Helper()
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: certs}}}
+		req, _ := http.NewRequest(http.MethodGet, "https://localhost:"+port+path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	withCert := []tls.Certificate{pair}
+	apiKey := map[string]string{"Authorization": "Bearer k"}
+	adminKey := map[string]string{"Authorization": "Bearer admin-key"}
+	// The certificate authenticates the client, so only the code is wrong
+	if got := do("/exchange?code=bogus", withCert, nil); got != http.StatusBadRequest {
+		t.Errorf("exchange with the client's certificate: status %d, want 400", got)
+	}
+	if got := do("/exchange?code=bogus", nil, apiKey); got != http.StatusForbidden {
+		t.Errorf("exchange with the API key alone: status %d, want 403", got)
+	}
+	if got := do("/admin/drain", withCert, adminKey); got != http.StatusOK {
+		t.Errorf("admin with the certificate: status %d, want 200", got)
+	}
+	if got := do("/admin/drain", nil, adminKey); got != http.StatusForbidden {
+		t.Errorf("admin without the certificate: status %d, want 403", got)
+	}
+}
+
+func TestIntegration_RegionHeader(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "t", Name: "T", APIKey: "k"},
+	})
+	stateSvc := state.NewService([]byte("key-1234567890abcdef12345678"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+
+	srv := New(Config{Host: "127.0.0.1", Port: 0, Region: "eu-west"}, Deps{
+		Clients: clients, Providers: auth.NewRegistry(), State: stateSvc, Exchange: codec,
+	})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := resp.Header.Get(RegionHeader); got != "eu-west" {
+		t.Errorf("expected region header 'eu-west', got %q", got)
+	}
+}
+
+func TestIntegration_RequestID(t *testing.T) {
+	var logs bytes.Buffer
+	logger, _ := logging.New(&logs, "json", "info")
+	prev := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	ts, _, _ := setupTestServer()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	id := resp.Header.Get(logging.RequestIDHeader)
+	if len(id) != 16 {
+		t.Fatalf("expected a generated request ID, got %q", id)
+	}
+	var rec map[string]any
+	json.Unmarshal(bytes.TrimSpace(logs.Bytes()), &rec)
+	if rec["msg"] != "request" || rec["request_id"] != id || rec["path"] != "/health" || rec["status"] != float64(200) {
+		t.Errorf("access log = %s", logs.String())
+	}
+
+	// A proxy's ID is kept; one that isn't safe to log is replaced
+	for header, keep := range map[string]bool{"edge-7f3a": true, "bad id": false} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/health", nil)
+		req.Header.Set(logging.RequestIDHeader, header)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get(logging.RequestIDHeader); (got == header) != keep || got == "" {
+			t.Errorf("X-Request-ID %q came back as %q", header, got)
+		}
+	}
+
+	// Error bodies carry it too, for clients to report
+	resp, err = http.Get(ts.URL + "/exchange?code=x")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		RequestID string `json:"request_id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusUnauthorized || body.RequestID == "" || body.RequestID != resp.Header.Get(logging.RequestIDHeader) {
+		t.Errorf("error body request_id = %q, header %q", body.RequestID, resp.Header.Get(logging.RequestIDHeader))
+	}
+}
+
+func TestIntegration_Tracing(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		names []string
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						TraceID string `json:"traceId"`
+						Name    string `json:"name"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, s := range req.ResourceSpans[0].ScopeSpans[0].Spans {
+			names = append(names, s.TraceID+" "+s.Name)
+		}
+	}))
+	defer collector.Close()
+
+	tracer := tracing.New(tracing.Config{Endpoint: collector.URL, SampleRatio: 1})
+	clients, _ := client.NewRegistry([]domain.ClientApp{{ID: "t", Name: "T", APIKey: "k"}})
+	stateSvc := state.NewService([]byte("key-1234567890abcdef12345678"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	srv := New(Config{BasePath: "/authsvc"}, Deps{Clients: clients, Providers: auth.NewRegistry(), State: stateSvc, Exchange: codec, Tracer: tracer})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/authsvc/health", nil)
+	req.Header.Set(tracing.TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	tracer.Shutdown(context.Background())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(names) != 1 || names[0] != "4bf92f3577b34da6a3ce929d0e0e4736 GET /health" {
+		t.Errorf("exported spans = %q", names)
+	}
+}
+
+func TestIntegration_BasePath(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "t", Name: "T", APIKey: "k"},
+	})
+	stateSvc := state.NewService([]byte("key-1234567890abcdef12345678"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+
+	srv := New(Config{Host: "127.0.0.1", Port: 0, BasePath: "/authsvc"}, Deps{
+		Clients: clients, Providers: auth.NewRegistry(), State: stateSvc, Exchange: codec,
+	})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	for path, want := range map[string]int{
+		"/authsvc/health":    http.StatusOK,
+		"/authsvc/providers": http.StatusOK,
+		"/health":            http.StatusNotFound,
+		"/authsvchealth":     http.StatusNotFound,
+	} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: expected %d, got %d", path, want, resp.StatusCode)
+		}
+	}
+}
+
+func TestIntegration_JWKS(t *testing.T) {
+	ts, _, _ := setupTestServer()
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/.well-known/jwks.json")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 without identity tokens, got %d", resp.StatusCode)
+	}
+
+	clients, _ := client.NewRegistry([]domain.ClientApp{{ID: "t", Name: "T", APIKey: "k"}})
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	signer, _ := idtoken.NewSigner(testutil.SigningKeyPEM(t))
+	srv := New(Config{Host: "127.0.0.1", Port: 0}, Deps{
+		Clients: clients, Providers: auth.NewRegistry(), State: state.NewService([]byte("key-1234567890abcdef12345678")), Exchange: codec,
+		IDTokens: idtoken.NewIssuer(signer, "https://auth.example.com", 0),
+	})
+	ts2 := httptest.NewServer(srv.Handler())
+	defer ts2.Close()
+
+	resp, err = http.Get(ts2.URL + "/.well-known/jwks.json")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+	var set idtoken.JWKSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil || len(set.Keys) != 1 || set.Keys[0].Kid != signer.KeyID() {
+		t.Errorf("JWKS = %+v, %v", set, err)
+	}
+}
+
+func TestIntegration_DrainMode(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{
+			ID:               "website",
+			Name:             "Test Website",
+			APIKey:           "test-api-key",
+			AllowedCallbacks: []string{"https://example.com/auth/callback"},
+			AllowedProviders: []string{"discord"},
+		},
+	})
+	providers := auth.NewRegistry()
+	providers.Register(&fakeProvider{name: "discord", authURL: "https://discord.example/authorize"})
+	stateSvc := state.NewService([]byte("key-1234567890abcdef12345678"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+
+	srv := New(Config{Host: "127.0.0.1", Port: 0, AdminAPIKey: "admin-key"}, Deps{
+		Clients: clients, Providers: providers, State: stateSvc, Exchange: codec,
+	})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	httpClient := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	authURL := ts.URL + "/auth/discord?client_id=website&redirect_uri=" +
+		url.QueryEscape("https://example.com/auth/callback")
+
+	admin := func(method string) int {
+		req, _ := http.NewRequest(method, ts.URL+"/admin/drain", nil)
+		req.Header.Set("Authorization", "Bearer admin-key")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("admin request error: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := admin(http.MethodPost); code != http.StatusOK {
+		t.Fatalf("expected 200 enabling drain, got %d", code)
+	}
+
+	resp, err := httpClient.Get(authURL)
+	if err != nil {
+		t.Fatalf("auth request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while draining, got %d", resp.StatusCode)
+	}
+
+	if code := admin(http.MethodDelete); code != http.StatusOK {
+		t.Fatalf("expected 200 disabling drain, got %d", code)
+	}
+
+	resp, err = httpClient.Get(authURL)
+	if err != nil {
+		t.Fatalf("auth request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("expected 302 after drain, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_MaintenanceMode(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "website", Name: "Test Website", APIKey: "test-api-key", AllowedCallbacks: []string{"https://example.com/auth/callback"}},
+	})
+	providers := auth.NewRegistry()
+	providers.Register(&fakeProvider{name: "discord", authURL: "https://discord.example/authorize"})
+	stateSvc := state.NewService([]byte("key-1234567890abcdef12345678"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+
+	srv := New(Config{Host: "127.0.0.1", Port: 0, AdminAPIKey: "admin-key"}, Deps{
+		Clients: clients, Providers: providers, State: stateSvc, Exchange: codec,
+	})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/admin/maintenance", strings.NewReader(`{"message":"Investigating an outage"}`))
+	req.Header.Set("Authorization", "Bearer admin-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("admin request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 enabling maintenance mode, got %d", resp.StatusCode)
+	}
+
+	for path, want := range map[string]int{
+		"/auth?client_id=website":                  http.StatusServiceUnavailable,
+		"/auth/discord?client_id=website":          http.StatusServiceUnavailable,
+		"/exchange?code=x":                         http.StatusUnauthorized,
+		"/callback/discord?code=x&state=malformed": http.StatusBadRequest,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("Accept", "text/html")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: expected %d, got %d", path, want, resp.StatusCode)
+		}
+		if want == http.StatusServiceUnavailable && !strings.Contains(string(body), "Investigating an outage") {
+			t.Errorf("%s: expected the maintenance page, got %s", path, body)
+		}
+	}
+}
+
+func TestIntegration_IPRateLimits(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "website", APIKey: "test-api-key", AllowedCallbacks: []string{"https://example.com/auth/callback"}, AllowedProviders: []string{"discord"}},
+	})
+	providers := auth.NewRegistry()
+	providers.Register(&fakeProvider{name: "discord", authURL: "https://discord.example/authorize"})
+	stateSvc := state.NewService([]byte("key-1234567890abcdef12345678"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+
+	srv := New(Config{
+		Host:         "127.0.0.1",
+		IPRateLimits: handler.IPLimits{PerIP: domain.RateLimit{Requests: 2, Per: time.Minute}},
+	}, Deps{
+		Clients: clients, Providers: providers, State: stateSvc, Exchange: codec,
+		RateLimiter: ratelimit.New(ratelimit.NewMemory()),
+	})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	httpClient := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	get := func(path string) int {
+		resp, err := httpClient.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get("/auth/discord?client_id=website&redirect_uri=" + url.QueryEscape("https://example.com/auth/callback")); code != http.StatusFound {
+		t.Fatalf("expected 302, got %d", code)
+	}
+	if code := get("/callback/discord?state=bogus"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad state, got %d", code)
+	}
+	// Callbacks and new flows draw on the same per-IP budget
+	if code := get("/auth/discord?client_id=website"); code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 over the IP limit, got %d", code)
+	}
+	if code := get("/health"); code != http.StatusOK {
+		t.Errorf("expected other routes to be unlimited, got %d", code)
+	}
+}
+
+func TestIntegration_LockoutsOnEveryAPIKeyRoute(t *testing.T) {
+	for _, path := range []string{"/auth/steam/ticket", "/auth/discord/lookup", "/token/refresh", "/token/revoke"} {
+		t.Run(path, func(t *testing.T) {
+			clients, _ := client.NewRegistry([]domain.ClientApp{{ID: "website", APIKey: "test-api-key"}})
+			codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+			signer, _ := idtoken.NewSigner(testutil.SigningKeyPEM(t))
+			srv := New(Config{Host: "127.0.0.1"}, Deps{
+				Clients: clients, Providers: auth.NewRegistry(), Exchange: codec,
+				IDTokens: idtoken.NewIssuer(signer, "https://auth.example.com", 0),
+				Refresh:  refresh.New(store.NewMemory()),
+				Lockouts: lockout.New(ratelimit.New(ratelimit.NewMemory()), store.NewMemory(), lockout.Policy{
+					Failures: domain.RateLimit{Requests: 1, Per: time.Hour},
+				}),
+			})
+			ts := httptest.NewServer(srv.Handler())
+			defer ts.Close()
+
+			post := func(apiKey string) int {
+				req, _ := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader("{}"))
+				req.Header.Set("Authorization", "Bearer "+apiKey)
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("request error: %v", err)
+				}
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+			for range 2 {
+				if code := post("wrong-key"); code != http.StatusUnauthorized {
+					t.Fatalf("expected 401 for a bad key, got %d", code)
+				}
+			}
+			if code := post("test-api-key"); code != http.StatusTooManyRequests {
+				t.Errorf("expected the address to be locked out, got %d", code)
+			}
+		})
+	}
+}
+
+func TestIntegration_AdminDisabledWithoutKey(t *testing.T) {
+	ts, _, _ := setupTestServer()
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/admin/drain", "", nil)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 without admin key configured, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegration_AdminListener(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "t", Name: "T", APIKey: "k"},
+	})
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	srv := New(Config{Host: "127.0.0.1", AdminHost: "127.0.0.1", AdminPort: 9090, AdminAPIKey: "admin-key", Debug: true}, Deps{
+		Clients: clients, Providers: auth.NewRegistry(), State: state.NewService([]byte("key-1234567890abcdef12345678")), Exchange: codec,
+		Metrics: metrics.NewRegistry(),
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeAdmin(ln)
+	defer srv.Shutdown(context.Background())
+	public := httptest.NewServer(srv.Handler())
+	defer public.Close()
+	adminURL := "http://" + ln.Addr().String()
+
+	get := func(url string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Authorization", "Bearer admin-key")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, path := range []string{"/metrics", "/admin/drain", "/debug/pprof/", "/debug/runtime"} {
+		if code := get(adminURL + path); code != http.StatusOK {
+			t.Errorf("admin listener %s: status %d, want 200", path, code)
+		}
+		if code := get(public.URL + path); code != http.StatusNotFound {
+			t.Errorf("public listener %s: status %d, want 404", path, code)
+		}
+	}
+	if code := get(adminURL + "/health"); code != http.StatusNotFound {
+		t.Errorf("admin listener /health: status %d, want 404", code)
+	}
+	if code := get(public.URL + "/health"); code != http.StatusOK {
+		t.Errorf("public listener /health: status %d, want 200", code)
+	}
+}
+
+func TestIntegration_DebugEndpointsOff(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "t", Name: "T", APIKey: "k"},
+	})
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	srv := New(Config{Host: "127.0.0.1", AdminHost: "127.0.0.1", AdminPort: 9090}, Deps{
+		Clients: clients, Providers: auth.NewRegistry(), State: state.NewService([]byte("key-1234567890abcdef12345678")), Exchange: codec,
+	})
+
+	for _, path := range []string{"/debug/pprof/", "/debug/runtime"} {
+		rr := testutil.DoRequest(t, srv.AdminHandler(), http.MethodGet, path, nil)
+		testutil.AssertStatus(t, rr, http.StatusNotFound)
+	}
+}
+
+func TestIntegration_ReadinessDuringShutdown(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "t", Name: "T", APIKey: "k"},
+	})
+	providers := auth.NewRegistry()
+	providers.Register(&fakeProvider{name: "discord"})
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	srv := New(Config{Host: "127.0.0.1", ShutdownDelay: 300 * time.Millisecond}, Deps{
+		Clients: clients, Providers: providers, State: state.NewService([]byte("key-1234567890abcdef12345678")), Exchange: codec,
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	base := "http://" + ln.Addr().String()
+
+	status := func(path string) int {
+		t.Helper()
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatalf("GET %s error: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := status("/readyz"); code != http.StatusOK {
+		t.Fatalf("/readyz = %d before shutdown, want 200", code)
+	}
+
+	done := make(chan error)
+	go func() { done <- srv.Shutdown(context.Background()) }()
+	deadline := time.Now().Add(time.Second)
+	for status("/readyz") != http.StatusServiceUnavailable {
+		if time.Now().After(deadline) {
+			t.Fatal("/readyz kept passing after Shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code := status("/livez"); code != http.StatusOK {
+		t.Errorf("/livez = %d during the shutdown delay, want 200", code)
+	}
+	resp, err := http.Get(base + "/auth/discord?client_id=t")
+	if err != nil {
+		t.Fatalf("GET /auth/discord error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("new sign-in during shutdown: status %d, Retry-After %q; want 503 with Retry-After", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown error: %v", err)
+	}
+}
+
+// slowProvider serves GET /slow, which answers once release is closed.
+type slowProvider struct {
+	fakeProvider
+	started, release chan struct{}
+}
+
+func (p *slowProvider) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /slow", func(w http.ResponseWriter, r *http.Request) {
+		close(p.started)
+		<-p.release
+	})
+}
+
+func TestIntegration_DrainTimeout(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "t", Name: "T", APIKey: "k"},
+	})
+	slow := &slowProvider{fakeProvider: fakeProvider{name: "slow"}, started: make(chan struct{}), release: make(chan struct{})}
+	defer close(slow.release)
+	providers := auth.NewRegistry()
+	providers.Register(slow)
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+	srv := New(Config{Host: "127.0.0.1", DrainTimeout: 100 * time.Millisecond}, Deps{
+		Clients: clients, Providers: providers, State: state.NewService([]byte("key-1234567890abcdef12345678")), Exchange: codec,
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+
+	go http.Get("http://" + ln.Addr().String() + "/slow")
+	<-slow.started
+	start := time.Now()
+	if err := srv.Shutdown(context.Background()); err == nil {
+		t.Error("expected an error for the request cut off")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took %v with a 100ms drain timeout", elapsed)
+	}
+}
+
+func TestIntegration_RequestLimits(t *testing.T) {
+	clients, _ := client.NewRegistry([]domain.ClientApp{
+		{ID: "t", Name: "T", APIKey: "k"},
+	})
+	stateSvc := state.NewService([]byte("key-1234567890abcdef12345678"))
+	codec, _ := exchange.NewCodec([]byte("01234567890123456789012345678901"))
+
+	srv := New(Config{Host: "127.0.0.1", BasePath: "/authsvc", MaxQueryBytes: 64, MaxHeaderBytes: 1 << 10, AdminAPIKey: "admin-key"}, Deps{
+		Clients: clients, Providers: auth.NewRegistry(), State: stateSvc, Exchange: codec,
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer srv.Shutdown(context.Background())
+	base := "http://" + ln.Addr().String() + "/authsvc"
+
+	req, _ := http.NewRequest(http.MethodGet, base+"/health", nil)
+	req.Header.Set("X-Padding", strings.Repeat("a", 16<<10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("large headers: expected 431, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(base + "/exchange?code=" + strings.Repeat("a", 64))
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestURITooLong {
+		t.Errorf("long query: expected 414, got %d", resp.StatusCode)
+	}
+
+	for _, tt := range []struct {
+		method, path, allow string
+	}{
+		{http.MethodPost, "/exchange", "GET, HEAD"},
+		{http.MethodPut, "/admin/drain", "GET, HEAD, POST, DELETE"},
+	} {
+		req, _ := http.NewRequest(tt.method, base+tt.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		var body map[string]string
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed || body["error"] != "method not allowed" {
+			t.Errorf("%s %s: expected a 405 error, got %d %v", tt.method, tt.path, resp.StatusCode, body)
+		}
+		if got := resp.Header.Get("Allow"); got != tt.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tt.method, tt.path, got, tt.allow)
+		}
+	}
+}
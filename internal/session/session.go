@@ -0,0 +1,206 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/store"
+)
+
+// DefaultTTL is how long a session lasts unless configured otherwise.
+const DefaultTTL = 8 * time.Hour
+
+// CookieName is the cookie that carries a browser's session ID.
+const CookieName = "centralauth_session"
+
+// idBytes is the number of random bytes in a session ID.
+const idBytes = 32
+
+// Store key prefixes. Sessions are stored under the hash of their ID, so
+// the store never holds a cookie that could be presented.
+const (
+	sessionPrefix = "session/"
+	endedPrefix   = "session/ended/"
+)
+
+// Session is a user's sign-in at CentralAuth itself, which lets the same
+// browser sign in to other clients without going back to the provider.
+type Session struct {
+	ID        string          `json:"-"`
+	User      domain.UserInfo `json:"user"`
+	Factors   []string        `json:"factors"`
+	AuthTime  time.Time       `json:"auth_time"`
+	ExpiresAt time.Time       `json:"expires_at"`
+
+	// Clients are the clients the session has signed the user in to.
+	Clients []string `json:"clients,omitempty"`
+}
+
+// Service keeps sessions in a store, so that any replica can serve them,
+// and hands them to browsers as cookies.
+type Service struct {
+	store  store.Store
+	ttl    time.Duration
+	path   string
+	secure bool
+	now    func() time.Time
+	rand   io.Reader
+}
+
+// New creates a service that keeps its sessions in s for ttl (zero uses the
+// default). Cookies are scoped to publicURL's path, and only sent over HTTPS
+// when it is an https URL.
+func New(s store.Store, ttl time.Duration, publicURL string) *Service {
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	svc := &Service{store: s, ttl: ttl, path: "/", now: time.Now, rand: rand.Reader}
+	if u, err := url.Parse(publicURL); err == nil {
+		if u.Path != "" {
+			svc.path = u.Path
+		}
+		svc.secure = u.Scheme == "https"
+	}
+	return svc
+}
+
+// SetNow overrides the time function (for testing).
+func (s *Service) SetNow(fn func() time.Time) {
+	s.now = fn
+}
+
+// Create starts a session for user, who signed in to clientID with factors.
+// The user's raw profile is not kept.
+func (s *Service) Create(ctx context.Context, clientID string, user domain.UserInfo, factors []string) (Session, error) {
+	b := make([]byte, idBytes)
+	if _, err := io.ReadFull(s.rand, b); err != nil {
+		return Session{}, fmt.Errorf("session: generating ID: %w", err)
+	}
+	user.Raw = nil
+	now := s.now()
+	sess := Session{
+		ID:        base64.RawURLEncoding.EncodeToString(b),
+		User:      user,
+		Factors:   factors,
+		AuthTime:  now,
+		ExpiresAt: now.Add(s.ttl),
+		Clients:   []string{clientID},
+	}
+	if err := s.save(ctx, sess); err != nil {
+		return Session{}, err
+	}
+	return sess, nil
+}
+
+// AddClient records that sess has signed the user in to clientID too.
+func (s *Service) AddClient(ctx context.Context, sess Session, clientID string) error {
+	if slices.Contains(sess.Clients, clientID) {
+		return nil
+	}
+	sess.Clients = append(slices.Clip(sess.Clients), clientID)
+	return s.save(ctx, sess)
+}
+
+// End signs the browser out of the session with id, and returns it so that
+// the clients it signed in to can be told. It can't be used again, even if
+// the browser kept its cookie.
+func (s *Service) End(ctx context.Context, id string) (Session, error) {
+	sess, err := s.Get(ctx, id)
+	if err != nil {
+		return Session{}, err
+	}
+	if err := s.store.Set(ctx, endedPrefix+hash(id), nil, s.remaining(sess)); err != nil {
+		return Session{}, fmt.Errorf("session: %w", err)
+	}
+	return sess, nil
+}
+
+// Get returns the live session with id.
+func (s *Service) Get(ctx context.Context, id string) (Session, error) {
+	if id == "" {
+		return Session{}, domain.ErrInvalidSession
+	}
+	data, ok, err := s.store.Get(ctx, sessionPrefix+hash(id))
+	if err != nil {
+		return Session{}, fmt.Errorf("session: %w", err)
+	}
+	var sess Session
+	if !ok || json.Unmarshal(data, &sess) != nil || !s.now().Before(sess.ExpiresAt) {
+		return Session{}, domain.ErrInvalidSession
+	}
+	_, ended, err := s.store.Get(ctx, endedPrefix+hash(id))
+	if err != nil {
+		return Session{}, fmt.Errorf("session: %w", err)
+	}
+	if ended {
+		return Session{}, domain.ErrInvalidSession
+	}
+	sess.ID = id
+	return sess, nil
+}
+
+// FromRequest returns the session of the browser that sent r.
+func (s *Service) FromRequest(r *http.Request) (Session, error) {
+	c, err := r.Cookie(CookieName)
+	if err != nil {
+		return Session{}, domain.ErrInvalidSession
+	}
+	return s.Get(r.Context(), c.Value)
+}
+
+// Cookie returns the cookie that hands sess to the browser.
+func (s *Service) Cookie(sess Session) *http.Cookie {
+	return &http.Cookie{
+		Name:     CookieName,
+		Value:    sess.ID,
+		Path:     s.path,
+		Expires:  sess.ExpiresAt,
+		HttpOnly: true,
+		Secure:   s.secure,
+		// Lax, so it comes along when a client sends the browser here
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// ClearCookie returns the cookie that removes the session cookie from the
+// browser.
+func (s *Service) ClearCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     CookieName,
+		Path:     s.path,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (s *Service) save(ctx context.Context, sess Session) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return fmt.Errorf("session: encoding: %w", err)
+	}
+	if err := s.store.Set(ctx, sessionPrefix+hash(sess.ID), data, s.remaining(sess)); err != nil {
+		return fmt.Errorf("session: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) remaining(sess Session) time.Duration {
+	return max(sess.ExpiresAt.Sub(s.now()), time.Second)
+}
+
+func hash(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
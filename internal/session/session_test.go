@@ -0,0 +1,83 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/store"
+)
+
+func TestService_CreateAndGet(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := New(store.NewMemory(), 0, "http://localhost:8080")
+	s.SetNow(func() time.Time { return now })
+	ctx := context.Background()
+
+	user := domain.UserInfo{ProviderName: "discord", ProviderID: "123", Raw: json.RawMessage(`{"id":"123"}`)}
+	sess, err := s.Create(ctx, "website", user, []string{"discord"})
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+	got, err := s.Get(ctx, sess.ID)
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if got.User.ProviderID != "123" || got.User.Raw != nil || !got.AuthTime.Equal(now) || !got.ExpiresAt.Equal(now.Add(DefaultTTL)) {
+		t.Errorf("session = %+v", got)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(s.Cookie(sess))
+	if got, err := s.FromRequest(req); err != nil || got.ID != sess.ID {
+		t.Errorf("FromRequest = %+v, %v", got, err)
+	}
+	if c := s.Cookie(sess); c.Path != "/" || c.Secure || !c.HttpOnly {
+		t.Errorf("cookie = %+v", c)
+	}
+
+	now = now.Add(DefaultTTL)
+	if _, err := s.Get(ctx, sess.ID); !errors.Is(err, domain.ErrInvalidSession) {
+		t.Errorf("Get after expiry = %v, want ErrInvalidSession", err)
+	}
+}
+
+func TestService_End(t *testing.T) {
+	s := New(store.NewMemory(), 0, "https://auth.example.com")
+	ctx := context.Background()
+	sess, _ := s.Create(ctx, "website", domain.UserInfo{ProviderName: "discord", ProviderID: "123"}, []string{"discord"})
+	if err := s.AddClient(ctx, sess, "forum"); err != nil {
+		t.Fatalf("AddClient error: %v", err)
+	}
+
+	ended, err := s.End(ctx, sess.ID)
+	if err != nil {
+		t.Fatalf("End error: %v", err)
+	}
+	if !slices.Equal(ended.Clients, []string{"website", "forum"}) {
+		t.Errorf("clients = %v", ended.Clients)
+	}
+	if _, err := s.Get(ctx, sess.ID); !errors.Is(err, domain.ErrInvalidSession) {
+		t.Errorf("Get after End = %v, want ErrInvalidSession", err)
+	}
+	if _, err := s.End(ctx, sess.ID); !errors.Is(err, domain.ErrInvalidSession) {
+		t.Errorf("second End = %v, want ErrInvalidSession", err)
+	}
+}
+
+func TestService_Invalid(t *testing.T) {
+	s := New(store.NewMemory(), 0, "https://auth.example.com")
+	for _, id := range []string{"", "made-up"} {
+		if _, err := s.Get(context.Background(), id); !errors.Is(err, domain.ErrInvalidSession) {
+			t.Errorf("Get(%q) = %v, want ErrInvalidSession", id, err)
+		}
+	}
+	if _, err := s.FromRequest(httptest.NewRequest("GET", "/", nil)); !errors.Is(err, domain.ErrInvalidSession) {
+		t.Errorf("FromRequest without a cookie = %v, want ErrInvalidSession", err)
+	}
+}
@@ -0,0 +1,5 @@
+//go:build postgres
+
+package sqldb
+
+import _ "github.com/jackc/pgx/v5/stdlib" // registers "pgx"
@@ -0,0 +1,5 @@
+//go:build sqlite
+
+package sqldb
+
+import _ "modernc.org/sqlite" // registers "sqlite"; pure Go, no cgo
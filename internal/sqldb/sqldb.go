@@ -0,0 +1,145 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+// Supported dialects.
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+// driverNames maps each dialect to the database/sql driver that serves it.
+// The drivers are compiled in with the build tag of the same name (see
+// driver_postgres.go and driver_sqlite.go).
+var driverNames = map[string]string{
+	Postgres: "pgx",
+	SQLite:   "sqlite",
+}
+
+const pingTimeout = 10 * time.Second
+
+// DB is a database handle. Queries are written with ? placeholders and
+// rewritten for the dialect, so one query string serves Postgres and SQLite.
+type DB struct {
+	db      *sql.DB
+	dialect string
+}
+
+// Open connects to a database of the given dialect and checks that it is
+// reachable.
+func Open(dialect, dsn string) (*DB, error) {
+	name, ok := driverNames[dialect]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown database %q (use postgres or sqlite)", domain.ErrInvalidConfig, dialect)
+	}
+	if !slices.Contains(sql.Drivers(), name) {
+		return nil, fmt.Errorf("%w: this build has no %s driver; rebuild with -tags %s", domain.ErrInvalidConfig, dialect, dialect)
+	}
+	db, err := sql.Open(name, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s database: %w", dialect, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to %s database: %w", dialect, err)
+	}
+	return New(db, dialect), nil
+}
+
+// New wraps an already open database.
+func New(db *sql.DB, dialect string) *DB {
+	return &DB{db: db, dialect: dialect}
+}
+
+// Dialect returns the database's dialect.
+func (d *DB) Dialect() string {
+	return d.dialect
+}
+
+// Exec runs a statement that returns no rows.
+func (d *DB) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return d.db.ExecContext(ctx, d.rebind(query), args...)
+}
+
+// Query runs a query that returns rows.
+func (d *DB) Query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return d.db.QueryContext(ctx, d.rebind(query), args...)
+}
+
+// QueryRow runs a query that returns at most one row.
+func (d *DB) QueryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return d.db.QueryRowContext(ctx, d.rebind(query), args...)
+}
+
+// Ping checks that the database is reachable.
+func (d *DB) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Migrate brings the schema named name up to date by running the steps it
+// hasn't run yet, in order. Steps are only ever appended: the number of
+// steps run so far is recorded in the schema_migrations table.
+func (d *DB) Migrate(ctx context.Context, name string, steps []string) error {
+	if _, err := d.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		name    TEXT PRIMARY KEY,
+		version INTEGER NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	var version int
+	err := d.QueryRow(ctx, `SELECT version FROM schema_migrations WHERE name = ?`, name).Scan(&version)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("reading %s schema version: %w", name, err)
+	}
+	for i := version; i < len(steps); i++ {
+		if _, err := d.Exec(ctx, steps[i]); err != nil {
+			return fmt.Errorf("migrating %s schema to version %d: %w", name, i+1, err)
+		}
+		if _, err := d.Exec(ctx, `INSERT INTO schema_migrations (name, version) VALUES (?, ?)
+			ON CONFLICT (name) DO UPDATE SET version = excluded.version`, name, i+1); err != nil {
+			return fmt.Errorf("recording %s schema version: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// rebind rewrites ? placeholders as $1, $2, ... for Postgres. Question marks
+// inside string literals are left alone.
+func (d *DB) rebind(query string) string {
+	if d.dialect != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n, quoted := 0, false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,98 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func TestOpen_UnknownDialect(t *testing.T) {
+	if _, err := Open("oracle", "dsn"); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	query := `SELECT a FROM t WHERE b = ? AND c = '?' AND d = ?`
+	if got := New(nil, SQLite).rebind(query); got != query {
+		t.Errorf("sqlite: got %q, want the query unchanged", got)
+	}
+	want := `SELECT a FROM t WHERE b = $1 AND c = '?' AND d = $2`
+	if got := New(nil, Postgres).rebind(query); got != want {
+		t.Errorf("postgres: got %q, want %q", got, want)
+	}
+}
+
+// migrationsDB fakes a database that only knows about schema_migrations and
+// records every other statement it runs.
+func migrationsDB(t *testing.T, ran *[]string) *DB {
+	versions := map[string]int64{}
+	return New(testutil.OpenSQL(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		switch {
+		case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS schema_migrations"):
+			return nil, nil, nil
+		case strings.HasPrefix(query, "SELECT version FROM schema_migrations"):
+			v, ok := versions[args[0].(string)]
+			if !ok {
+				return []string{"version"}, nil, nil
+			}
+			return []string{"version"}, [][]driver.Value{{v}}, nil
+		case strings.HasPrefix(query, "INSERT INTO schema_migrations"):
+			versions[args[0].(string)] = args[1].(int64)
+			return nil, nil, nil
+		}
+		*ran = append(*ran, query)
+		return nil, nil, nil
+	}), SQLite)
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	var ran []string
+	db := migrationsDB(t, &ran)
+
+	if err := db.Migrate(ctx, "things", []string{"step 1", "step 2"}); err != nil {
+		t.Fatalf("Migrate error: %v", err)
+	}
+	if strings.Join(ran, ",") != "step 1,step 2" {
+		t.Fatalf("expected both steps to run, got %q", ran)
+	}
+
+	ran = nil
+	if err := db.Migrate(ctx, "things", []string{"step 1", "step 2", "step 3"}); err != nil {
+		t.Fatalf("Migrate error: %v", err)
+	}
+	if strings.Join(ran, ",") != "step 3" {
+		t.Errorf("expected only the new step to run, got %q", ran)
+	}
+
+	ran = nil
+	if err := db.Migrate(ctx, "others", []string{"other 1"}); err != nil {
+		t.Fatalf("Migrate error: %v", err)
+	}
+	if strings.Join(ran, ",") != "other 1" {
+		t.Errorf("expected schemas to be versioned separately, got %q", ran)
+	}
+}
+
+func TestMigrate_FailedStep(t *testing.T) {
+	db := New(testutil.OpenSQL(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if query == "bad step" {
+			return nil, nil, errors.New("syntax error")
+		}
+		if strings.HasPrefix(query, "SELECT") {
+			return []string{"version"}, nil, nil
+		}
+		return nil, nil, nil
+	}), SQLite)
+
+	err := db.Migrate(context.Background(), "things", []string{"bad step"})
+	if err == nil || !strings.Contains(err.Error(), "version 1") {
+		t.Errorf("expected the failed version in the error, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package state
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
@@ -8,7 +9,11 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/BlackMission/centralauth/internal/domain"
@@ -19,30 +24,120 @@ const (
 	nonceBytes    = 16
 )
 
+// consumedPrefix namespaces the nonces of consumed tokens in the store.
+const consumedPrefix = "state/consumed/"
+
+const (
+	// epochKey is where the state epoch is kept in the store.
+	epochKey = "state/epoch"
+	// epochTTL is how long the store remembers a revocation. Every token
+	// issued before it expired long before.
+	epochTTL = 30 * 24 * time.Hour
+	// epochRefresh is how long a service uses the epoch it last read.
+	epochRefresh = 5 * time.Second
+)
+
+// EpochStore is where the state epoch is kept, so that every replica
+// sharing it agrees on which tokens are revoked; any store.Store will do.
+type EpochStore interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	String() string
+}
+
+// ConsumedStore is where the nonces of consumed tokens are kept; any
+// store.Store will do.
+type ConsumedStore interface {
+	Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	String() string
+}
+
 // Service generates and validates HMAC-signed state tokens.
 type Service struct {
-	key    []byte
+	keys   []signingKey // the current key first, then previous ones
 	expiry time.Duration
+	region string
 	now    func() time.Time
+	rand   io.Reader
+
+	clientExpiry func(clientID string) time.Duration
+
+	// epoch is the state epoch as last read from epochs, at epochRead
+	epochMu   sync.Mutex
+	epoch     uint64
+	epochRead time.Time
+	epochs    EpochStore
+
+	// consumed holds the nonces of tokens that have completed a flow
+	consumed ConsumedStore
+}
+
+// signingKey is an HMAC key and the ID that tokens signed with it carry.
+type signingKey struct {
+	id  string
+	key []byte
+}
+
+func newSigningKey(key []byte) signingKey {
+	sum := sha256.Sum256(append([]byte("centralauth key id\x00"), key...))
+	return signingKey{id: base64.RawURLEncoding.EncodeToString(sum[:6]), key: key}
+}
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithExpiry sets how long tokens stay valid (5 minutes if zero).
+func WithExpiry(d time.Duration) Option {
+	return func(s *Service) {
+		if d > 0 {
+			s.expiry = d
+		}
+	}
+}
+
+// WithPreviousKeys makes the service accept tokens signed with keys it has
+// been rotated away from, so flows started before a rotation can finish.
+// New tokens are always signed with the current key.
+func WithPreviousKeys(keys ...[]byte) Option {
+	return func(s *Service) {
+		for _, key := range keys {
+			s.keys = append(s.keys, newSigningKey(key))
+		}
+	}
+}
+
+// WithClientExpiry lets clients override the expiry of their tokens. fn
+// returns 0 for clients that use the service's expiry.
+func WithClientExpiry(fn func(clientID string) time.Duration) Option {
+	return func(s *Service) {
+		s.clientExpiry = fn
+	}
 }
 
 // NewService creates a state token service with the given HMAC signing key.
-func NewService(key []byte) *Service {
-	return &Service{
-		key:    key,
+func NewService(key []byte, opts ...Option) *Service {
+	s := &Service{
+		keys:   []signingKey{newSigningKey(key)},
 		expiry: defaultExpiry,
 		now:    time.Now,
+		rand:   rand.Reader,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // Generate creates an HMAC-signed state token containing the given payload.
 func (s *Service) Generate(payload domain.StatePayload) (string, error) {
 	nonce := make([]byte, nonceBytes)
-	if _, err := rand.Read(nonce); err != nil {
+	if _, err := io.ReadFull(s.rand, nonce); err != nil {
 		return "", fmt.Errorf("generating nonce: %w", err)
 	}
 	payload.Nonce = hex.EncodeToString(nonce)
-	payload.ExpiresAt = s.now().Add(s.expiry)
+	payload.ExpiresAt = s.now().Add(s.expiryFor(payload.ClientID))
+	payload.Region = s.region
+	payload.Epoch = s.Epoch()
 
 	data, err := json.Marshal(payload)
 	if err != nil {
@@ -50,22 +145,42 @@ func (s *Service) Generate(payload domain.StatePayload) (string, error) {
 	}
 
 	encoded := base64.RawURLEncoding.EncodeToString(data)
-	sig := s.sign(encoded)
+	current := s.keys[0]
 
-	return encoded + "." + sig, nil
+	// key ID . payload . signature
+	return current.id + "." + encoded + "." + sign(current.key, encoded), nil
 }
 
 // Validate verifies the HMAC signature and expiry of a state token.
 func (s *Service) Validate(token string) (*domain.StatePayload, error) {
-	parts := strings.SplitN(token, ".", 2)
-	if len(parts) != 2 {
+	parts := strings.Split(token, ".")
+	var encoded, sig string
+	candidates := s.keys
+	switch len(parts) {
+	case 3:
+		encoded, sig = parts[1], parts[2]
+		candidates = nil
+		for _, k := range s.keys {
+			if k.id == parts[0] {
+				candidates = []signingKey{k}
+				break
+			}
+		}
+	case 2:
+		// Issued before tokens carried a key ID
+		encoded, sig = parts[0], parts[1]
+	default:
 		return nil, domain.ErrMalformedState
 	}
 
-	encoded, sig := parts[0], parts[1]
-
-	expectedSig := s.sign(encoded)
-	if !hmac.Equal([]byte(sig), []byte(expectedSig)) {
+	valid := false
+	for _, k := range candidates {
+		if hmac.Equal([]byte(sig), []byte(sign(k.key, encoded))) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
 		return nil, domain.ErrInvalidState
 	}
 
@@ -83,16 +198,138 @@ func (s *Service) Validate(token string) (*domain.StatePayload, error) {
 		return nil, domain.ErrExpiredState
 	}
 
+	if payload.Epoch < s.Epoch() {
+		return nil, domain.ErrRevokedState
+	}
+
 	return &payload, nil
 }
 
+// Consume marks the token payload was validated from as used, failing with
+// ErrUsedState if it was used before. Each token can be consumed any number
+// of times unless SetConsumedStore was called.
+func (s *Service) Consume(ctx context.Context, payload *domain.StatePayload) error {
+	if s.consumed == nil || payload.Nonce == "" {
+		return nil
+	}
+	first, err := s.consumed.Add(ctx, consumedPrefix+payload.Nonce, nil, max(payload.ExpiresAt.Sub(s.now()), time.Second))
+	if err != nil {
+		return fmt.Errorf("state: %s store: %w", s.consumed, err)
+	}
+	if !first {
+		return domain.ErrUsedState
+	}
+	return nil
+}
+
+// SetConsumedStore makes each token usable once, recording the nonces of
+// consumed tokens in st until the tokens expire.
+func (s *Service) SetConsumedStore(st ConsumedStore) {
+	s.consumed = st
+}
+
+// SetRegion sets the region label stamped into generated tokens.
+func (s *Service) SetRegion(region string) {
+	s.region = region
+}
+
+// Region returns the region label of this service.
+func (s *Service) Region() string {
+	return s.region
+}
+
+// SetEpochStore keeps the state epoch in st, so that a revocation applies
+// to every service sharing it, within a few seconds, and survives restarts
+// if st does. Without one, or with an in-memory st, a restart brings
+// revoked tokens back until they expire.
+func (s *Service) SetEpochStore(st EpochStore) {
+	s.epochMu.Lock()
+	defer s.epochMu.Unlock()
+	s.epochs = st
+	s.epochRead = time.Time{}
+}
+
+// RevokeAll bumps the state epoch so that every token issued so far fails
+// validation, and returns the new epoch.
+func (s *Service) RevokeAll(ctx context.Context) (uint64, error) {
+	s.epochMu.Lock()
+	defer s.epochMu.Unlock()
+
+	epoch := s.epoch + 1
+	if s.epochs != nil {
+		stored, err := s.loadEpoch(ctx)
+		if err != nil {
+			return 0, err
+		}
+		epoch = stored + 1
+		if err := s.epochs.Set(ctx, epochKey, []byte(strconv.FormatUint(epoch, 10)), epochTTL); err != nil {
+			return 0, fmt.Errorf("state: %s store: %w", s.epochs, err)
+		}
+	}
+	s.epoch, s.epochRead = epoch, s.now()
+	return epoch, nil
+}
+
+// Epoch returns the current state epoch. Tokens issued under an earlier
+// one are revoked.
+func (s *Service) Epoch() uint64 {
+	s.epochMu.Lock()
+	defer s.epochMu.Unlock()
+
+	if s.epochs == nil || s.now().Sub(s.epochRead) < epochRefresh {
+		return s.epoch
+	}
+	// Tried again only after epochRefresh, even if the store fails
+	s.epochRead = s.now()
+	epoch, err := s.loadEpoch(context.Background())
+	if err != nil {
+		// A store that fails leaves the last epoch read in place
+		slog.Warn("state: epoch not refreshed", "error", err)
+		return s.epoch
+	}
+	s.epoch = epoch
+	return s.epoch
+}
+
+// loadEpoch reads the state epoch from the store, which holds none until
+// the first revocation. Callers must hold s.epochMu.
+func (s *Service) loadEpoch(ctx context.Context) (uint64, error) {
+	value, ok, err := s.epochs.Get(ctx, epochKey)
+	if err != nil {
+		return 0, fmt.Errorf("state: %s store: %w", s.epochs, err)
+	}
+	if !ok {
+		return 0, nil
+	}
+	epoch, err := strconv.ParseUint(string(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("state: %s store: invalid epoch %q", s.epochs, value)
+	}
+	return epoch, nil
+}
+
 // SetNow overrides the time function (for testing).
 func (s *Service) SetNow(fn func() time.Time) {
 	s.now = fn
 }
 
-func (s *Service) sign(data string) string {
-	mac := hmac.New(sha256.New, s.key)
+// SetRand overrides the nonce source (for testing).
+func (s *Service) SetRand(r io.Reader) {
+	s.rand = r
+}
+
+// expiryFor returns how long tokens issued to clientID stay valid.
+func (s *Service) expiryFor(clientID string) time.Duration {
+	if s.clientExpiry != nil {
+		if d := s.clientExpiry(clientID); d > 0 {
+			return d
+		}
+	}
+	return s.expiry
+}
+
+func sign(key []byte, data string) string {
+	mac := hmac.New(sha256.New, key)
 	mac.Write([]byte(data))
 	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
 }
@@ -1,6 +1,7 @@
 package state
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"strings"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/store"
 )
 
 var testKey = []byte("test-signing-key-1234567890abcdef")
@@ -60,13 +62,13 @@ func TestTamperedPayload(t *testing.T) {
 		t.Fatalf("Generate error: %v", err)
 	}
 
-	parts := strings.SplitN(token, ".", 2)
+	parts := strings.Split(token, ".")
 	// Decode, modify, re-encode the payload
-	data, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	data, _ := base64.RawURLEncoding.DecodeString(parts[1])
 	modified := strings.Replace(string(data), "website", "hacked!", 1)
-	parts[0] = base64.RawURLEncoding.EncodeToString([]byte(modified))
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(modified))
 
-	_, err = svc.Validate(parts[0] + "." + parts[1])
+	_, err = svc.Validate(strings.Join(parts, "."))
 	if !errors.Is(err, domain.ErrInvalidState) {
 		t.Errorf("expected ErrInvalidState, got %v", err)
 	}
@@ -83,13 +85,13 @@ func TestTamperedSignature(t *testing.T) {
 		t.Fatalf("Generate error: %v", err)
 	}
 
-	parts := strings.SplitN(token, ".", 2)
+	parts := strings.Split(token, ".")
 	// Flip a character in the signature
-	tampered := []byte(parts[1])
+	tampered := []byte(parts[2])
 	tampered[0] ^= 0xFF
-	parts[1] = string(tampered)
+	parts[2] = string(tampered)
 
-	_, err = svc.Validate(parts[0] + "." + parts[1])
+	_, err = svc.Validate(strings.Join(parts, "."))
 	if !errors.Is(err, domain.ErrInvalidState) {
 		t.Errorf("expected ErrInvalidState, got %v", err)
 	}
@@ -117,6 +119,31 @@ func TestExpiredToken(t *testing.T) {
 	}
 }
 
+func TestExpiryOptions(t *testing.T) {
+	svc := NewService(testKey,
+		WithExpiry(10*time.Minute),
+		WithClientExpiry(func(clientID string) time.Duration {
+			if clientID == "launcher" {
+				return time.Minute
+			}
+			return 0
+		}),
+	)
+	now := time.Now()
+	svc.now = func() time.Time { return now }
+
+	website, _ := svc.Generate(domain.StatePayload{ClientID: "website", Provider: "discord"})
+	launcher, _ := svc.Generate(domain.StatePayload{ClientID: "launcher", Provider: "discord"})
+
+	svc.now = func() time.Time { return now.Add(6 * time.Minute) }
+	if _, err := svc.Validate(website); err != nil {
+		t.Errorf("expected the configured expiry to apply, got %v", err)
+	}
+	if _, err := svc.Validate(launcher); !errors.Is(err, domain.ErrExpiredState) {
+		t.Errorf("expected the client's expiry to apply, got %v", err)
+	}
+}
+
 func TestWrongKey(t *testing.T) {
 	svc1 := NewService([]byte("key-one-1234567890abcdef12345678"))
 	svc2 := NewService([]byte("key-two-1234567890abcdef12345678"))
@@ -135,6 +162,39 @@ func TestWrongKey(t *testing.T) {
 	}
 }
 
+func TestKeyRotation(t *testing.T) {
+	oldKey := []byte("old-signing-key-1234567890abcdef")
+	before := NewService(oldKey)
+	after := NewService(testKey, WithPreviousKeys(oldKey))
+
+	token, err := before.Generate(domain.StatePayload{ClientID: "website", Provider: "discord"})
+	if err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	if _, err := after.Validate(token); err != nil {
+		t.Errorf("expected a token from the previous key to validate, got %v", err)
+	}
+
+	token, _ = after.Generate(domain.StatePayload{ClientID: "website", Provider: "discord"})
+	if _, err := newTestService().Validate(token); err != nil {
+		t.Errorf("expected new tokens to use the current key, got %v", err)
+	}
+	if _, err := before.Validate(token); !errors.Is(err, domain.ErrInvalidState) {
+		t.Errorf("expected the old key alone to reject new tokens, got %v", err)
+	}
+}
+
+func TestTokenWithoutKeyID(t *testing.T) {
+	svc := newTestService()
+	token, _ := svc.Generate(domain.StatePayload{ClientID: "website", Provider: "discord"})
+
+	// Tokens issued before key IDs were added are payload.signature
+	_, legacy, _ := strings.Cut(token, ".")
+	if _, err := svc.Validate(legacy); err != nil {
+		t.Errorf("expected a token without a key ID to validate, got %v", err)
+	}
+}
+
 func TestMalformedInput(t *testing.T) {
 	svc := newTestService()
 
@@ -177,3 +237,114 @@ func TestNonceUniqueness(t *testing.T) {
 		t.Error("nonces should be different")
 	}
 }
+
+func TestRegionStampedAndAcceptedElsewhere(t *testing.T) {
+	eu := newTestService()
+	eu.SetRegion("eu-west")
+	us := newTestService()
+	us.SetRegion("us-east")
+
+	token, err := eu.Generate(domain.StatePayload{ClientID: "website", Provider: "discord"})
+	if err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+
+	// A region sharing the signing key accepts tokens issued by another region
+	got, err := us.Validate(token)
+	if err != nil {
+		t.Fatalf("Validate error: %v", err)
+	}
+	if got.Region != "eu-west" {
+		t.Errorf("expected region 'eu-west', got %q", got.Region)
+	}
+}
+
+func TestRevokeAll(t *testing.T) {
+	svc := NewService([]byte("test-signing-key"))
+
+	before, err := svc.Generate(domain.StatePayload{ClientID: "website"})
+	if err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+
+	if epoch, err := svc.RevokeAll(context.Background()); epoch != 1 || err != nil {
+		t.Errorf("RevokeAll = %d, %v, want 1", epoch, err)
+	}
+	if _, err := svc.Validate(before); !errors.Is(err, domain.ErrRevokedState) {
+		t.Errorf("expected ErrRevokedState, got %v", err)
+	}
+
+	after, _ := svc.Generate(domain.StatePayload{ClientID: "website"})
+	if _, err := svc.Validate(after); err != nil {
+		t.Errorf("expected token issued after revocation to be valid, got %v", err)
+	}
+}
+
+func TestRevokeAll_SharedStore(t *testing.T) {
+	shared := store.NewMemory()
+	now := time.Now()
+	clock := func() time.Time { return now }
+	a, b := newTestService(), newTestService()
+	for _, svc := range []*Service{a, b} {
+		svc.SetNow(clock)
+		svc.SetEpochStore(shared)
+	}
+
+	before, _ := a.Generate(domain.StatePayload{ClientID: "website"})
+	if _, err := b.RevokeAll(context.Background()); err != nil {
+		t.Fatalf("RevokeAll error: %v", err)
+	}
+	after, _ := b.Generate(domain.StatePayload{ClientID: "website"})
+
+	// Tokens from the revoking service are valid on the others straight away
+	if _, err := a.Validate(after); err != nil {
+		t.Errorf("token issued after revocation rejected elsewhere: %v", err)
+	}
+	// and the revocation reaches them once they read the epoch again
+	now = now.Add(epochRefresh)
+	if _, err := a.Validate(before); !errors.Is(err, domain.ErrRevokedState) {
+		t.Errorf("expected ErrRevokedState on another service, got %v", err)
+	}
+
+	// A restarted service keeps the revocation
+	restarted := newTestService()
+	restarted.SetNow(clock)
+	restarted.SetEpochStore(shared)
+	if _, err := restarted.Validate(before); !errors.Is(err, domain.ErrRevokedState) {
+		t.Errorf("expected ErrRevokedState after a restart, got %v", err)
+	}
+	if epoch, _ := a.RevokeAll(context.Background()); epoch != 2 {
+		t.Errorf("RevokeAll = %d, want 2", epoch)
+	}
+}
+
+func TestConsume(t *testing.T) {
+	svc := newTestService()
+	ctx := context.Background()
+	token, _ := svc.Generate(domain.StatePayload{ClientID: "website"})
+	payload, err := svc.Validate(token)
+	if err != nil {
+		t.Fatalf("Validate error: %v", err)
+	}
+
+	// Without a store, tokens can be used again within their lifetime
+	for range 2 {
+		if err := svc.Consume(ctx, payload); err != nil {
+			t.Errorf("Consume without a store = %v", err)
+		}
+	}
+
+	svc.SetConsumedStore(store.NewMemory())
+	if err := svc.Consume(ctx, payload); err != nil {
+		t.Fatalf("first Consume = %v", err)
+	}
+	if err := svc.Consume(ctx, payload); !errors.Is(err, domain.ErrUsedState) {
+		t.Errorf("second Consume = %v, want ErrUsedState", err)
+	}
+
+	other, _ := svc.Generate(domain.StatePayload{ClientID: "website"})
+	payload, _ = svc.Validate(other)
+	if err := svc.Consume(ctx, payload); err != nil {
+		t.Errorf("Consume of another token = %v", err)
+	}
+}
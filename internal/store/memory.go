@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired values are dropped.
+const sweepInterval = time.Minute
+
+// Memory keeps values in process memory, so each replica has its own.
+type Memory struct {
+	mu        sync.Mutex
+	items     map[string]item
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+type item struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewMemory creates an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		items: make(map[string]item),
+		now:   time.Now,
+	}
+}
+
+// SetNow overrides the time function (for testing).
+func (m *Memory) SetNow(fn func() time.Time) {
+	m.now = fn
+}
+
+// Get returns the live value under key.
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it, ok := m.items[key]
+	if !ok || !m.now().Before(it.expiresAt) {
+		return nil, false, nil
+	}
+	return it.value, true, nil
+}
+
+// Set stores value under key for ttl.
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweepLocked(now)
+	m.items[key] = item{value: value, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+// Add stores value under key for ttl unless a live value is already there.
+func (m *Memory) Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweepLocked(now)
+	if it, ok := m.items[key]; ok && now.Before(it.expiresAt) {
+		return false, nil
+	}
+	m.items[key] = item{value: value, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+// sweepLocked drops expired values. Callers must hold m.mu.
+func (m *Memory) sweepLocked(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, it := range m.items {
+		if !now.Before(it.expiresAt) {
+			delete(m.items, key)
+		}
+	}
+}
+
+func (m *Memory) String() string {
+	return "memory"
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemory_GetSet(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	if _, ok, _ := m.Get(ctx, "k"); ok {
+		t.Fatal("expected no value")
+	}
+	m.Set(ctx, "k", []byte("v1"), time.Minute)
+	m.Set(ctx, "k", []byte("v2"), time.Minute)
+	if v, ok, _ := m.Get(ctx, "k"); !ok || string(v) != "v2" {
+		t.Errorf("Get = %q, %v; want v2", v, ok)
+	}
+}
+
+func TestMemory_Add(t *testing.T) {
+	m := NewMemory()
+	now := time.Now()
+	m.SetNow(func() time.Time { return now })
+	ctx := context.Background()
+
+	if added, _ := m.Add(ctx, "k", []byte("first"), time.Minute); !added {
+		t.Fatal("expected the first Add to store")
+	}
+	if added, _ := m.Add(ctx, "k", []byte("second"), time.Minute); added {
+		t.Error("expected the second Add to be refused")
+	}
+	if v, _, _ := m.Get(ctx, "k"); string(v) != "first" {
+		t.Errorf("Get = %q, want the first value", v)
+	}
+
+	m.SetNow(func() time.Time { return now.Add(time.Minute) })
+	if added, _ := m.Add(ctx, "k", []byte("third"), time.Minute); !added {
+		t.Error("expected Add to store over an expired value")
+	}
+}
+
+func TestMemory_Expires(t *testing.T) {
+	m := NewMemory()
+	now := time.Now()
+	m.SetNow(func() time.Time { return now })
+	ctx := context.Background()
+	m.Set(ctx, "k", []byte("v"), time.Minute)
+
+	m.SetNow(func() time.Time { return now.Add(61 * time.Second) })
+	if _, ok, _ := m.Get(ctx, "k"); ok {
+		t.Error("expected the value to expire")
+	}
+}
+
+func TestMemory_Sweep(t *testing.T) {
+	m := NewMemory()
+	now := time.Now()
+	m.SetNow(func() time.Time { return now })
+	ctx := context.Background()
+	m.Set(ctx, "old", nil, time.Second)
+
+	m.SetNow(func() time.Time { return now.Add(2 * sweepInterval) })
+	m.Set(ctx, "new", nil, time.Second)
+
+	if len(m.items) != 1 {
+		t.Errorf("expected the expired value to be swept, have %d items", len(m.items))
+	}
+}
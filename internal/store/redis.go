@@ -0,0 +1,277 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// redisTimeout bounds each round trip, so that a slow Redis delays
+	// requests by at most this much before they give up on it.
+	redisTimeout = time.Second
+
+	// redisMaxIdle is how many connections are kept open between requests.
+	redisMaxIdle = 16
+
+	// redisKeyPrefix namespaces the keys in a shared Redis.
+	redisKeyPrefix = "centralauth:"
+)
+
+// Redis keeps values in Redis, so that every replica shares them. Keys are
+// stored under the "centralauth:" prefix.
+type Redis struct {
+	addr      string
+	tlsConfig *tls.Config
+	username  string
+	password  string
+	db        int
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+// NewRedis creates a store for the Redis server at rawURL, of the form
+// redis://[[user]:password@]host[:port][/db], or rediss:// for TLS. It
+// connects on first use.
+func NewRedis(rawURL string) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "redis" && u.Scheme != "rediss") {
+		return nil, fmt.Errorf("invalid Redis URL: must be redis://host:port or rediss://host:port")
+	}
+	s := &Redis{addr: u.Host}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.Scheme == "rediss" {
+		s.tlsConfig = &tls.Config{ServerName: u.Hostname()}
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if s.db, err = strconv.Atoi(db); err != nil || s.db < 0 {
+			return nil, fmt.Errorf("invalid Redis URL: database %q must be a number", db)
+		}
+	}
+	return s, nil
+}
+
+// Get returns the value under key.
+func (s *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := s.Do(ctx, "GET", redisKeyPrefix+key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	v, ok := reply.(string)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected reply %v", reply)
+	}
+	return []byte(v), true, nil
+}
+
+// Set stores value under key for ttl.
+func (s *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := s.Do(ctx, "SET", redisKeyPrefix+key, string(value), "PX", formatTTL(ttl))
+	return err
+}
+
+// Add stores value under key for ttl unless the key exists.
+func (s *Redis) Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	reply, err := s.Do(ctx, "SET", redisKeyPrefix+key, string(value), "PX", formatTTL(ttl), "NX")
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+// formatTTL formats ttl in whole milliseconds, at least one, since Redis
+// refuses a zero expiry.
+func formatTTL(ttl time.Duration) string {
+	return strconv.FormatInt(max(ttl.Milliseconds(), 1), 10)
+}
+
+// Close closes the idle connections.
+func (s *Redis) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.idle {
+		c.conn.Close()
+	}
+	s.idle = nil
+	return nil
+}
+
+func (s *Redis) String() string {
+	return "redis " + s.addr
+}
+
+// Ping checks that Redis answers.
+func (s *Redis) Ping(ctx context.Context) error {
+	_, err := s.Do(ctx, "PING")
+	return err
+}
+
+// Do sends one command on a pooled connection and returns the reply: a
+// string, an int64, nil, or a []any of those. Unlike Get, Set, and Add it
+// doesn't prefix keys, so callers namespace their own.
+func (s *Redis) Do(ctx context.Context, args ...string) (any, error) {
+	c, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.do(deadline(ctx), args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// The connection is in an unknown state.
+		c.conn.Close()
+		return nil, err
+	}
+	s.put(c)
+	return reply, err
+}
+
+func (s *Redis) get(ctx context.Context) (*redisConn, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		c := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return c, nil
+	}
+	s.mu.Unlock()
+	return s.dial(ctx)
+}
+
+func (s *Redis) put(c *redisConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.idle) >= redisMaxIdle {
+		c.conn.Close()
+		return
+	}
+	s.idle = append(s.idle, c)
+}
+
+// dial opens a connection, authenticated and with the database selected.
+func (s *Redis) dial(ctx context.Context) (*redisConn, error) {
+	ctx, cancel := context.WithDeadline(ctx, deadline(ctx))
+	defer cancel()
+	var conn net.Conn
+	var err error
+	if s.tlsConfig != nil {
+		conn, err = (&tls.Dialer{Config: s.tlsConfig}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	var setup [][]string
+	switch {
+	case s.username != "":
+		setup = append(setup, []string{"AUTH", s.username, s.password})
+	case s.password != "":
+		setup = append(setup, []string{"AUTH", s.password})
+	}
+	if s.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	for _, args := range setup {
+		if _, err := c.do(deadline(ctx), args...); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: %w", args[0], err)
+		}
+	}
+	return c, nil
+}
+
+// deadline is the earlier of ctx's deadline and one redisTimeout from now.
+func deadline(ctx context.Context) time.Time {
+	d := time.Now().Add(redisTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(d) {
+		return ctxDeadline
+	}
+	return d
+}
+
+// redisConn speaks RESP, the Redis protocol, on one connection.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// redisError is an error reply. The connection stays usable after one.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func (c *redisConn) do(deadline time.Time, args ...string) (any, error) {
+	c.conn.SetDeadline(deadline)
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+// readReply reads one RESP reply: a string, an int64, nil, or a []any of
+// those. Error replies are returned as a redisError.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		vals := make([]any, n)
+		for i := range vals {
+			if vals[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return vals, nil
+	}
+	return nil, fmt.Errorf("unknown reply type %q", kind)
+}
@@ -0,0 +1,30 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Store holds short-lived values shared by every request a replica serves,
+// or by every replica when the store is shared. Values expire after their
+// TTL.
+type Store interface {
+	// Get returns the value under key, or false if there is none.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+
+	// Set stores value under key for ttl, replacing any previous value.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Add stores value under key for ttl unless the key already holds a
+	// value, and reports whether it stored it.
+	Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+
+	// String describes the store for logs.
+	String() string
+}
+
+// Pinger is implemented by stores kept in a service, which can check that
+// it is reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
@@ -0,0 +1,70 @@
+package ticket
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+func TestSignVerify(t *testing.T) {
+	s := NewSigner([]byte("ticket-key"), 0)
+
+	tok, err := s.Sign("acct-1", "flow-a")
+	if err != nil {
+		t.Fatalf("Sign error: %v", err)
+	}
+	sub, err := s.Verify(tok, "flow-a")
+	if err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+	if sub != "acct-1" {
+		t.Errorf("subject = %q, want %q", sub, "acct-1")
+	}
+}
+
+func TestVerify_WrongAudience(t *testing.T) {
+	s := NewSigner([]byte("ticket-key"), 0)
+	tok, _ := s.Sign("acct-1", "flow-a")
+
+	if _, err := s.Verify(tok, "flow-b"); !errors.Is(err, domain.ErrInvalidTicket) {
+		t.Errorf("expected ErrInvalidTicket, got %v", err)
+	}
+}
+
+func TestVerify_Tampered(t *testing.T) {
+	s := NewSigner([]byte("ticket-key"), 0)
+	tok, _ := s.Sign("acct-1", "flow-a")
+
+	other := NewSigner([]byte("other-key"), 0)
+	if _, err := other.Verify(tok, "flow-a"); !errors.Is(err, domain.ErrInvalidTicket) {
+		t.Errorf("expected ErrInvalidTicket, got %v", err)
+	}
+}
+
+func TestVerify_Expired(t *testing.T) {
+	s := NewSigner([]byte("ticket-key"), time.Minute)
+	now := time.Now()
+	s.SetNow(func() time.Time { return now })
+	tok, _ := s.Sign("acct-1", "flow-a")
+
+	now = now.Add(2 * time.Minute)
+	if _, err := s.Verify(tok, "flow-a"); !errors.Is(err, domain.ErrExpiredTicket) {
+		t.Errorf("expected ErrExpiredTicket, got %v", err)
+	}
+}
+
+func TestSubject(t *testing.T) {
+	s := NewSigner([]byte("ticket-key"), 0)
+	tok, _ := s.Sign("acct-1", "flow-a")
+
+	sub, err := s.Subject(tok)
+	if err != nil || sub != "acct-1" {
+		t.Errorf("Subject = %q, %v; want %q", sub, err, "acct-1")
+	}
+	other := NewSigner([]byte("other-key"), 0)
+	if _, err := other.Subject(tok); !errors.Is(err, domain.ErrInvalidTicket) {
+		t.Errorf("expected ErrInvalidTicket, got %v", err)
+	}
+}
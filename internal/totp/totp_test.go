@@ -0,0 +1,59 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B test secret (SHA-1).
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode_RFC6238Vectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code error: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate_Skew(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret error: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	prev, _ := Code(secret, now.Add(-30*time.Second))
+
+	if _, ok := Validate(secret, prev, now); !ok {
+		t.Error("expected previous step's code to be accepted")
+	}
+	old, _ := Code(secret, now.Add(-90*time.Second))
+	if _, ok := Validate(secret, old, now); ok {
+		t.Error("expected code three steps old to be rejected")
+	}
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Error("expected short code to be rejected")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("CentralAuth", "discord:123", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/CentralAuth:discord:123?") || !strings.Contains(uri, "secret=ABC") {
+		t.Errorf("unexpected URI: %s", uri)
+	}
+}
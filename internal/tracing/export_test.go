@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		got    otlpRequest
+		path   string
+		apiKey string
+		posted int
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		path, apiKey = r.URL.Path, r.Header.Get("X-Api-Key")
+		json.NewDecoder(r.Body).Decode(&got)
+		posted++
+	}))
+	defer collector.Close()
+
+	tr := New(Config{Endpoint: collector.URL + "/", Headers: map[string]string{"X-Api-Key": "secret"}, ServiceName: "centralauth", SampleRatio: 1})
+	ctx, root := tr.StartRequest(context.Background(), "GET /callback/{provider}", http.Header{})
+	_, child := Start(ctx, "POST discord.com/api/oauth2/token", KindClient)
+	child.SetAttributes("http.response.status_code", 502, "server.address", "discord.com")
+	child.SetError(errors.New("provider exchange failed"))
+	child.End()
+	root.End()
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if posted != 1 || path != "/v1/traces" || apiKey != "secret" {
+		t.Fatalf("posted %d to %q with key %q", posted, path, apiKey)
+	}
+	rs := got.ResourceSpans[0]
+	if rs.Resource.Attributes[0].Key != "service.name" || rs.Resource.Attributes[0].Value["stringValue"] != "centralauth" {
+		t.Errorf("resource = %+v", rs.Resource)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	c, r := spans[0], spans[1]
+	if c.TraceID != r.TraceID || c.ParentSpanID != r.SpanID || r.ParentSpanID != "" || c.Kind != KindClient || r.Kind != KindServer {
+		t.Errorf("spans = %+v", spans)
+	}
+	if c.Status.Code != 2 || c.Status.Message != "provider exchange failed" || r.Status.Code != 0 {
+		t.Errorf("statuses = %+v, %+v", c.Status, r.Status)
+	}
+	if len(c.Attributes) != 2 || c.Attributes[0].Value["intValue"] != "502" || c.Attributes[1].Value["stringValue"] != "discord.com" {
+		t.Errorf("attributes = %+v", c.Attributes)
+	}
+}
+
+func TestExport_CollectorDown(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	tr := New(Config{Endpoint: collector.URL, SampleRatio: 1})
+	_, span := tr.StartRequest(context.Background(), "GET /health", http.Header{})
+	span.End()
+	span.End()
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown error: %v", err)
+	}
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Errorf("second Shutdown error: %v", err)
+	}
+}
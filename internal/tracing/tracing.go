@@ -0,0 +1,182 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mrand "math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TraceParentHeader carries the W3C trace context of a call, in requests to
+// CentralAuth and in its calls to providers.
+const TraceParentHeader = "traceparent"
+
+// Kind says which side of a call a span stands for.
+type Kind int
+
+// Span kinds, numbered as in OTLP.
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Tracer records the spans of sampled requests and exports them to an
+// OpenTelemetry collector.
+type Tracer struct {
+	ratio    float64
+	exporter *exporter
+}
+
+// New returns a tracer exporting to the collector cfg names. Its exporter
+// runs until Shutdown.
+func New(cfg Config) *Tracer {
+	return &Tracer{ratio: cfg.SampleRatio, exporter: newExporter(cfg)}
+}
+
+// Shutdown exports the spans still queued and stops the exporter, giving up
+// when ctx ends.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	return t.exporter.shutdown(ctx)
+}
+
+// StartRequest starts the server span for an incoming request, continuing
+// the trace in its traceparent header when there is one. Requests whose
+// caller didn't sample them, and a share of the rest set by the sample
+// ratio, aren't traced: the span is nil and ctx is returned as is.
+func (t *Tracer) StartRequest(ctx context.Context, name string, header http.Header) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, name: name, kind: KindServer, start: time.Now()}
+	if traceID, parentID, sampled, ok := parseTraceParent(header.Get(TraceParentHeader)); ok {
+		if !sampled {
+			return ctx, nil
+		}
+		s.traceID, s.parentID = traceID, parentID
+	} else {
+		if mrand.Float64() >= t.ratio {
+			return ctx, nil
+		}
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+type spanKey struct{}
+
+// FromContext returns the span ctx is in, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start starts a span for work done within the span ctx is in. Outside of a
+// traced request it does nothing: the span is nil, and ctx is returned as
+// is, so callers need not check whether tracing is enabled.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: parent.tracer, traceID: parent.traceID, parentID: parent.spanID, name: name, kind: kind, start: time.Now()}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Span is one timed operation in a trace. A nil *Span is valid and records
+// nothing. A span is used by one goroutine at a time.
+type Span struct {
+	tracer   *Tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     Kind
+	start    time.Time
+	end      time.Time
+	attrs    []attribute
+	errMsg   string
+	failed   bool
+}
+
+type attribute struct {
+	key   string
+	value any
+}
+
+// SetAttributes records key-value pairs on the span. Values are kept as
+// strings, integers, floats or booleans; anything else is formatted.
+func (s *Span) SetAttributes(kv ...any) {
+	if s == nil {
+		return
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, _ := kv[i].(string)
+		switch v := kv[i+1].(type) {
+		case string, bool, int, int64, float64:
+			s.attrs = append(s.attrs, attribute{key, v})
+		default:
+			s.attrs = append(s.attrs, attribute{key, fmt.Sprint(v)})
+		}
+	}
+}
+
+// SetError marks the span as failed with err. A nil err does nothing.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.failed, s.errMsg = true, err.Error()
+}
+
+// End ends the span and queues it for export. Spans are dropped while the
+// queue is full rather than slowing requests down.
+func (s *Span) End() {
+	if s == nil || !s.end.IsZero() {
+		return
+	}
+	s.end = time.Now()
+	s.tracer.exporter.enqueue(s)
+}
+
+// TraceID returns the hex ID of the span's trace, or "" for a nil span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// TraceParent returns the traceparent header value that makes a call part
+// of the span's trace, or "" for a nil span.
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-01"
+}
+
+// parseTraceParent parses a W3C traceparent header value.
+func parseTraceParent(v string) (traceID [16]byte, parentID [8]byte, sampled, ok bool) {
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == [16]byte{} {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || parentID == [8]byte{} {
+		return traceID, parentID, false, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return traceID, parentID, false, false
+	}
+	return traceID, parentID, flags[0]&1 == 1, true
+}
@@ -0,0 +1,88 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func newTestTracer(t *testing.T, ratio float64) *Tracer {
+	t.Helper()
+	tr := New(Config{Endpoint: "http://127.0.0.1:0", ServiceName: "centralauth", SampleRatio: ratio})
+	t.Cleanup(func() { tr.Shutdown(context.Background()) })
+	return tr
+}
+
+func TestStartRequest_Sampling(t *testing.T) {
+	if _, span := newTestTracer(t, 0).StartRequest(context.Background(), "GET /health", http.Header{}); span != nil {
+		t.Error("expected no span at a sample ratio of 0")
+	}
+	ctx, span := newTestTracer(t, 1).StartRequest(context.Background(), "GET /health", http.Header{})
+	if span == nil || FromContext(ctx) != span || len(span.TraceID()) != 32 {
+		t.Fatalf("span = %+v", span)
+	}
+
+	var nilTracer *Tracer
+	if ctx, span := nilTracer.StartRequest(context.Background(), "GET /health", http.Header{}); span != nil || FromContext(ctx) != nil {
+		t.Error("expected a nil tracer to trace nothing")
+	}
+}
+
+func TestStartRequest_ContinuesTrace(t *testing.T) {
+	tr := newTestTracer(t, 0)
+	h := http.Header{}
+	h.Set(TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, span := tr.StartRequest(context.Background(), "GET /auth/{provider}", h)
+	if span == nil {
+		t.Fatal("expected the caller's sampling decision to be kept")
+	}
+	if span.TraceID() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace ID = %s", span.TraceID())
+	}
+	if tp := span.TraceParent(); !strings.HasPrefix(tp, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || strings.Contains(tp, "00f067aa0ba902b7") {
+		t.Errorf("traceparent = %s", tp)
+	}
+
+	h.Set(TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if _, span := newTestTracer(t, 1).StartRequest(context.Background(), "GET /", h); span != nil {
+		t.Error("expected a trace the caller didn't sample to be left untraced")
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	for _, v := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	} {
+		if _, _, _, ok := parseTraceParent(v); ok {
+			t.Errorf("%q: expected it to be rejected", v)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	if ctx, span := Start(context.Background(), "discord token", KindClient); span != nil || ctx != context.Background() {
+		t.Error("expected no span outside a traced request")
+	}
+
+	ctx, root := newTestTracer(t, 1).StartRequest(context.Background(), "GET /callback/{provider}", http.Header{})
+	_, child := Start(ctx, "POST discord.com/api/oauth2/token", KindClient)
+	if child.TraceID() != root.TraceID() || child.parentID != root.spanID || child.spanID == root.spanID {
+		t.Errorf("child = %+v, root = %+v", child, root)
+	}
+
+	// A nil span is safe to use
+	var none *Span
+	none.SetAttributes("k", "v")
+	none.SetError(errors.New("boom"))
+	none.End()
+	if none.TraceID() != "" || none.TraceParent() != "" {
+		t.Error("expected a nil span to have no IDs")
+	}
+}
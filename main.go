@@ -1,100 +1,86 @@
 package main
 
 import (
+	"bufio"
 	"context"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/BlackMission/centralauth/internal/auth"
+	"github.com/BlackMission/centralauth/internal/app"
 	"github.com/BlackMission/centralauth/internal/client"
 	"github.com/BlackMission/centralauth/internal/config"
 	"github.com/BlackMission/centralauth/internal/domain"
-	"github.com/BlackMission/centralauth/internal/exchange"
-	"github.com/BlackMission/centralauth/internal/providers/discord"
-	"github.com/BlackMission/centralauth/internal/providers/steam"
-	"github.com/BlackMission/centralauth/internal/server"
-	"github.com/BlackMission/centralauth/internal/state"
+	"github.com/BlackMission/centralauth/internal/logging"
+	"github.com/BlackMission/centralauth/internal/sqldb"
 )
 
 func main() {
-	cfg, err := config.LoadFromEnv()
-	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
-	}
-
-	// Build client registry
-	clientApps := make([]domain.ClientApp, len(cfg.Clients))
-	for i, c := range cfg.Clients {
-		clientApps[i] = domain.ClientApp{
-			ID:               c.ID,
-			Name:             c.Name,
-			APIKey:           c.APIKey,
-			AllowedCallbacks: c.AllowedCallbacks,
-			AllowedProviders: c.AllowedProviders,
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate-config":
+			os.Exit(validateConfig())
+		case "genkeys":
+			os.Exit(genKeys())
+		case "hash-api-key":
+			os.Exit(hashAPIKey())
+		default:
+			fatal("unknown command", "command", os.Args[1], "available", "validate-config, genkeys, hash-api-key")
 		}
 	}
-	clients, err := client.NewRegistry(clientApps)
+
+	cfg, err := loadConfig()
 	if err != nil {
-		log.Fatalf("failed to create client registry: %v", err)
+		fatal("failed to load config", "error", err)
 	}
-
-	// Build state service
-	stateSvc := state.NewService([]byte(cfg.Secrets.StateSigningKey))
-
-	// Build exchange codec
-	encKey := []byte(cfg.Secrets.ExchangeEncryptionKey)
-	if len(encKey) != 32 {
-		log.Fatalf("exchange_encryption_key must be exactly 32 bytes, got %d", len(encKey))
+	logger, err := logging.New(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		fatal("failed to set up logging", "error", err)
 	}
-	codec, err := exchange.NewCodec(encKey)
+	// Also routes the log package, which dependencies may still write to
+	slog.SetDefault(logger)
+
+	a, err := app.New(cfg)
 	if err != nil {
-		log.Fatalf("failed to create exchange codec: %v", err)
-	}
-
-	// Build provider registry
-	providers := auth.NewRegistry()
-
-	if dc, ok := cfg.Providers["discord"]; ok {
-		callbackURL := cfg.Server.BaseURL + "/callback/discord"
-		p := discord.New(discord.Config{
-			ClientID:     dc.ClientID,
-			ClientSecret: dc.ClientSecret,
-			Scopes:       dc.Scopes,
-			CallbackURL:  callbackURL,
-		})
-		if err := providers.Register(p); err != nil {
-			log.Fatalf("failed to register discord provider: %v", err)
-		}
-		log.Println("Registered provider: discord")
-	}
-
-	if sc, ok := cfg.Providers["steam"]; ok {
-		callbackURL := cfg.Server.BaseURL + "/callback/steam"
-		p := steam.New(steam.Config{
-			APIKey:      sc.APIKey,
-			Realm:       sc.Realm,
-			CallbackURL: callbackURL,
-		})
-		if err := providers.Register(p); err != nil {
-			log.Fatalf("failed to register steam provider: %v", err)
-		}
-		log.Println("Registered provider: steam")
+		fatal("failed to start", "error", err)
 	}
+	srv, deps := a.Server, &a.Deps
 
-	// Build and start server
-	srv := server.New(server.Config{
-		Host: cfg.Server.Host,
-		Port: cfg.Server.Port,
-	}, server.Deps{
-		Clients:   clients,
-		Providers: providers,
-		State:     stateSvc,
-		Exchange:  codec,
-	})
+	// Apply client and scope changes, and updated GeoIP databases, on
+	// SIGHUP, without a restart
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reload(a)
+			if deps.GeoIP != nil {
+				if err := deps.GeoIP.Reload(); err != nil {
+					slog.Error("reload: GeoIP databases not updated", "error", err)
+				}
+			}
+		}
+	}()
+
+	// Toggle maintenance mode on SIGUSR1, for when the admin API can't be
+	// reached during an incident
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			action := "maintenance.stop"
+			if deps.Maintenance.Toggle() {
+				action = "maintenance.start"
+			}
+			deps.Audit.Record("SIGUSR1", "", action, "")
+		}
+	}()
 
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
@@ -102,19 +88,137 @@ func main() {
 
 	go func() {
 		if err := srv.Start(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("server error: %v", err)
+			fatal("server error", "error", err)
 		}
 	}()
 
 	<-quit
-	log.Println("Shutting down...")
+	slog.Info("shutting down")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := srv.Shutdown(context.Background()); err != nil {
+		slog.Warn("shutdown: drain timeout reached", "error", err)
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	if err := a.Close(shutdownCtx); err != nil {
+		slog.Warn("shutdown: not closed cleanly", "error", err)
+	}
+
+	slog.Info("server stopped")
+}
+
+// loadConfig reads the configuration from CONFIG_FILE, if set, or from the
+// environment alone.
+func loadConfig() (*config.Config, error) {
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return config.LoadFromFile(path)
+	}
+	return config.LoadFromEnv()
+}
+
+// validateConfig loads the configuration as the server would, prints a
+// summary with the secrets redacted, and reports anything that would fail
+// once running. It returns the exit status.
+func validateConfig() int {
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		return 1
+	}
+	clientApps := app.ClientApps(cfg)
+	ctx := context.Background()
+	store, err := app.ClientStore(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		return 1
+	}
+	if store != nil {
+		if s, ok := store.(*client.SQLStore); ok {
+			defer s.Close()
+		}
+		fromStore, err := store.Load(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+			return 1
+		}
+		if cfg.ClientsDBSeed {
+			// Seeding only adds the clients missing from the database
+			fromStore = slices.DeleteFunc(fromStore, func(c domain.ClientApp) bool {
+				return slices.ContainsFunc(clientApps, func(e domain.ClientApp) bool { return e.ID == c.ID })
+			})
+		}
+		clientApps = append(clientApps, fromStore...)
+	}
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("shutdown error: %v", err)
+	config.WriteSummary(os.Stdout, cfg, clientApps)
+	problems := config.Check(cfg, clientApps)
+	if _, err := client.NewRegistry(clientApps); err != nil {
+		problems = append(problems, err.Error())
 	}
+	if cfg.IdentityDB.Driver != "" {
+		if db, err := sqldb.Open(cfg.IdentityDB.Driver, cfg.IdentityDB.DSN); err != nil {
+			problems = append(problems, err.Error())
+		} else {
+			db.Close()
+		}
+	}
+	if len(problems) == 0 {
+		fmt.Println("\nConfiguration OK")
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "\n%d problem(s) found:\n", len(problems))
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "  - %s\n", p)
+	}
+	return 1
+}
 
-	log.Println("Server stopped")
+// genKeys prints a fresh pair of secrets in .env format.
+func genKeys() int {
+	stateKey, exchangeKey, err := config.GenerateKeys()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Printf("STATE_SIGNING_KEY=%s\nEXCHANGE_ENCRYPTION_KEY=%s\n", stateKey, exchangeKey)
+	return 0
+}
+
+// hashAPIKey reads an API key from stdin and prints the hash to configure in
+// its place. Given no key, it generates one.
+func hashAPIKey() int {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Fprintf(os.Stderr, "reading API key: %v\n", err)
+		return 1
+	}
+	key := strings.TrimSpace(line)
+	if key == "" {
+		if key, err = client.GenerateAPIKey(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Printf("API key: %s\n", key)
+	}
+	fmt.Printf("Hash:    %s\n", client.HashAPIKey(key))
+	return 0
+}
+
+// fatal logs msg and args as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// reload re-reads the configuration and applies what can change while
+// running to a. Anything else, including new providers, needs a restart.
+func reload(a *app.App) {
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("reload failed; keeping the running configuration", "error", err)
+		return
+	}
+	if err := a.Reload(context.Background(), cfg); err != nil {
+		slog.Error("reload: configuration not applied", "error", err)
+	}
 }
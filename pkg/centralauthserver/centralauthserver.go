@@ -0,0 +1,115 @@
+// Package centralauthserver runs CentralAuth inside another Go program.
+// New builds the service the centralauth binary runs, as an http.Handler
+// for the program to mount on its own mux:
+//
+//	settings, err := centralauthserver.LoadSettings("")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	settings.Server.BasePath = "/auth"
+//	auth, err := centralauthserver.New(centralauthserver.Config{Settings: settings})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	mux.Handle("/auth/", auth)
+//
+// Routes are served under the configured BASE_PATH, which must match where
+// the handler is mounted. The admin endpoints and /metrics are served by
+// the same handler, since there is no admin listener to move them to; the
+// listener, TLS and logging settings are the program's own and are ignored.
+package centralauthserver
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/app"
+	"github.com/BlackMission/centralauth/internal/config"
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/hook"
+)
+
+// Settings is CentralAuth's configuration, as the centralauth binary reads
+// it from its environment. Load it with LoadSettings and adjust it before
+// calling New; the adjustments are not validated again.
+type Settings = config.Config
+
+// Hook is a post-auth hook, run on each sign-in after the provider has
+// authenticated the user and before the client is given an exchange code.
+// It may change the result, or refuse the sign-in by returning an error
+// from Reject.
+type Hook = hook.Hook
+
+// HookFunc adapts a function to a Hook.
+type HookFunc = hook.Func
+
+// Rejection is the error of a hook that refuses a sign-in.
+type Rejection = hook.Rejection
+
+// AuthResult is what a provider reports of a sign-in.
+type AuthResult = domain.AuthResult
+
+// UserInfo is the normalized profile of a signed-in user.
+type UserInfo = domain.UserInfo
+
+// Reject returns the error a hook refuses a sign-in with. reason is shown
+// to the user.
+func Reject(reason string) error {
+	return hook.Reject(reason)
+}
+
+// Config configures an embedded CentralAuth.
+type Config struct {
+	// Settings is the service's configuration. If nil it is loaded as the
+	// binary loads it, from CONFIG_FILE and the environment.
+	Settings *Settings
+
+	// Hooks run on each sign-in, after any post-auth hooks Settings
+	// configures.
+	Hooks []Hook
+}
+
+// LoadSettings reads the configuration from the JSON file at path, with the
+// environment taking precedence over it, or from the environment alone if
+// path is empty.
+func LoadSettings(path string) (*Settings, error) {
+	if path != "" {
+		return config.LoadFromFile(path)
+	}
+	return config.LoadFromEnv()
+}
+
+// New builds CentralAuth from cfg and returns its handler. The handler also
+// implements io.Closer: close it once the program has stopped serving, to
+// stop watching the clients, flush buffered events and close the databases.
+func New(cfg Config) (http.Handler, error) {
+	settings := cfg.Settings
+	if settings == nil {
+		var err error
+		if settings, err = LoadSettings(os.Getenv("CONFIG_FILE")); err != nil {
+			return nil, err
+		}
+	}
+	embedded := *settings
+	embedded.Admin.Port = 0
+
+	a, err := app.New(&embedded, cfg.Hooks...)
+	if err != nil {
+		return nil, err
+	}
+	return &server{Handler: a.Server.Handler(), app: a}, nil
+}
+
+// server is an embedded CentralAuth's handler.
+type server struct {
+	http.Handler
+	app *app.App
+}
+
+func (s *server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.app.Close(ctx)
+}
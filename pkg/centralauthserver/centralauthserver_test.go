@@ -0,0 +1,106 @@
+package centralauthserver
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/BlackMission/centralauth/pkg/testutil"
+)
+
+func setEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("BASE_URL", "https://example.com")
+	t.Setenv("BASE_PATH", "/auth")
+	t.Setenv("STATE_SIGNING_KEY", "test-signing-key-1234567890123456")
+	t.Setenv("EXCHANGE_ENCRYPTION_KEY", "test-encrypt-key-1234567890123456")
+	t.Setenv("GUEST_ENABLED", "true")
+	t.Setenv("CLIENT_WEBSITE_API_KEY", "test-api-key")
+	t.Setenv("CLIENT_WEBSITE_ALLOWED_CALLBACKS", "https://example.com/callback")
+	t.Setenv("CLIENT_WEBSITE_ALLOWED_PROVIDERS", "guest")
+	t.Setenv("ADMIN_API_KEY", "test-admin-key")
+	t.Setenv("ADMIN_PORT", "9091")
+}
+
+func newHandler(t *testing.T, cfg Config) http.Handler {
+	t.Helper()
+	h, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := h.(io.Closer).Close(); err != nil {
+			t.Errorf("Close error: %v", err)
+		}
+	})
+	return h
+}
+
+func TestNew(t *testing.T) {
+	setEnv(t)
+	h := newHandler(t, Config{})
+
+	if rec := testutil.DoRequest(t, h, http.MethodGet, "/auth/health", nil); rec.Code != http.StatusOK {
+		t.Errorf("GET /auth/health = %d, want 200", rec.Code)
+	}
+	// Without an admin listener, the admin API is on the embedded handler
+	if rec := testutil.DoRequest(t, h, http.MethodGet, "/auth/admin/audit", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /auth/admin/audit = %d, want 401", rec.Code)
+	}
+}
+
+func TestNew_InvalidSettings(t *testing.T) {
+	setEnv(t)
+	settings, err := LoadSettings("")
+	if err != nil {
+		t.Fatalf("LoadSettings error: %v", err)
+	}
+	settings.Secrets.ExchangeEncryptionKey = "too short"
+	if _, err := New(Config{Settings: settings}); err == nil {
+		t.Error("expected an error for an invalid exchange key")
+	}
+}
+
+func TestNew_Hooks(t *testing.T) {
+	setEnv(t)
+	settings, err := LoadSettings("")
+	if err != nil {
+		t.Fatalf("LoadSettings error: %v", err)
+	}
+	var clientID, provider string
+	h := newHandler(t, Config{Settings: settings, Hooks: []Hook{
+		HookFunc(func(ctx context.Context, id string, result *AuthResult) error {
+			clientID, provider = id, result.User.ProviderName
+			return Reject("closed for the weekend")
+		}),
+	}})
+
+	rec := testutil.DoRequest(t, h, http.MethodGet, "/auth/auth/guest?client_id=website&redirect_uri="+
+		url.QueryEscape("https://example.com/callback"), nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("GET /auth/auth/guest = %d, want 302", rec.Code)
+	}
+	callback, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = testutil.DoRequest(t, h, http.MethodGet, callback.RequestURI(), nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("GET %s = %d, want 302", callback.Path, rec.Code)
+	}
+	back, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := back.Query().Get("error"); got != "access_denied" {
+		t.Errorf("error = %q, want access_denied", got)
+	}
+	if got := back.Query().Get("error_description"); got != "closed for the weekend" {
+		t.Errorf("error_description = %q", got)
+	}
+	if clientID != "website" || provider != "guest" {
+		t.Errorf("hook ran for %q via %q", clientID, provider)
+	}
+}
@@ -0,0 +1,101 @@
+package testutil
+
+import (
+	"io"
+	"time"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+	"github.com/BlackMission/centralauth/internal/exchange"
+	"github.com/BlackMission/centralauth/internal/state"
+)
+
+// The types the fixtures take and return, named here so that tests outside
+// this module can use them.
+type (
+	// Codec encodes and decodes exchange codes.
+	Codec = exchange.Codec
+	// StateService generates and validates state tokens.
+	StateService = state.Service
+	// CodePayload is what an exchange code carries.
+	CodePayload = domain.ExchangePayload
+	// StatePayload is what a state token carries.
+	StatePayload = domain.StatePayload
+	// User is a signed-in user, as /exchange returns it.
+	User = domain.UserInfo
+)
+
+// FixtureTime is the instant the fixture services are frozen at.
+var FixtureTime = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// FixedClock returns a time function that always reports t.
+func FixedClock(t time.Time) func() time.Time {
+	return func() time.Time { return t }
+}
+
+// SeqReader is a deterministic stand-in for crypto/rand. It yields the byte
+// sequence 0, 1, 2, ... 255, 0, 1, ... so the Nth token or code produced by a
+// service reading from it is always the same.
+type SeqReader struct {
+	next byte
+}
+
+// Read fills p with the next bytes of the sequence.
+func (r *SeqReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.next
+		r.next++
+	}
+	return len(p), nil
+}
+
+// NewStateService returns a state service whose clock is frozen at now and
+// whose nonces come from rand. A nil now uses FixtureTime and a nil rand a
+// fresh SeqReader, which makes the generated tokens byte-for-byte stable.
+func NewStateService(key []byte, now func() time.Time, rand io.Reader) *StateService {
+	svc := state.NewService(key)
+	svc.SetNow(fixtureClock(now))
+	svc.SetRand(fixtureRand(rand))
+	return svc
+}
+
+// NewCodec returns an exchange codec configured like NewStateService.
+// key must be 32 bytes.
+func NewCodec(key []byte, now func() time.Time, rand io.Reader) (*Codec, error) {
+	codec, err := exchange.NewCodec(key)
+	if err != nil {
+		return nil, err
+	}
+	codec.SetNow(fixtureClock(now))
+	codec.SetRand(fixtureRand(rand))
+	return codec, nil
+}
+
+// EncodeCode returns payload as the first exchange code of a fresh fixture
+// codec: the same code for the same key and payload every time.
+func EncodeCode(key []byte, payload CodePayload) (string, error) {
+	codec, err := NewCodec(key, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	return codec.Encode(payload)
+}
+
+// StateToken returns payload as the first state token of a fresh fixture
+// state service: the same token for the same key and payload every time.
+func StateToken(key []byte, payload StatePayload) (string, error) {
+	return NewStateService(key, nil, nil).Generate(payload)
+}
+
+func fixtureClock(now func() time.Time) func() time.Time {
+	if now == nil {
+		return FixedClock(FixtureTime)
+	}
+	return now
+}
+
+func fixtureRand(r io.Reader) io.Reader {
+	if r == nil {
+		return &SeqReader{}
+	}
+	return r
+}
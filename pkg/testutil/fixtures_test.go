@@ -0,0 +1,91 @@
+package testutil
+
+import (
+	"testing"
+
+	"github.com/BlackMission/centralauth/internal/domain"
+)
+
+var fixtureKey = []byte("01234567890123456789012345678901")
+
+func TestNewStateService_Deterministic(t *testing.T) {
+	payload := domain.StatePayload{ClientID: "website", Provider: "discord"}
+
+	a, err := NewStateService(fixtureKey, nil, nil).Generate(payload)
+	if err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	b, _ := NewStateService(fixtureKey, nil, nil).Generate(payload)
+	if a != b {
+		t.Errorf("expected identical tokens, got %q and %q", a, b)
+	}
+
+	svc := NewStateService(fixtureKey, nil, nil)
+	if _, err := svc.Validate(a); err != nil {
+		t.Errorf("expected fixture token to validate, got %v", err)
+	}
+}
+
+func TestNewCodec_Deterministic(t *testing.T) {
+	payload := domain.ExchangePayload{ClientID: "website", User: domain.UserInfo{ProviderID: "123"}}
+
+	c1, err := NewCodec(fixtureKey, nil, nil)
+	if err != nil {
+		t.Fatalf("NewCodec error: %v", err)
+	}
+	c2, _ := NewCodec(fixtureKey, nil, nil)
+
+	a, _ := c1.Encode(payload)
+	b, _ := c2.Encode(payload)
+	if a != b {
+		t.Errorf("expected identical codes, got %q and %q", a, b)
+	}
+
+	got, err := c1.Decode(a)
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if !got.ExpiresAt.After(FixtureTime) {
+		t.Errorf("expected expiry after FixtureTime, got %v", got.ExpiresAt)
+	}
+}
+
+func TestEncodeCode(t *testing.T) {
+	payload := CodePayload{ClientID: "website", User: User{ProviderName: "discord", ProviderID: "123"}}
+	code, err := EncodeCode(fixtureKey, payload)
+	if err != nil {
+		t.Fatalf("EncodeCode error: %v", err)
+	}
+	if again, _ := EncodeCode(fixtureKey, payload); again != code {
+		t.Errorf("expected identical codes, got %q and %q", code, again)
+	}
+	codec, _ := NewCodec(fixtureKey, nil, nil)
+	got, err := codec.Decode(code)
+	if err != nil || got.User.ProviderID != "123" {
+		t.Errorf("Decode = %+v, %v", got, err)
+	}
+	if _, err := EncodeCode([]byte("short"), payload); err == nil {
+		t.Error("expected an error for a short key")
+	}
+}
+
+func TestStateToken(t *testing.T) {
+	token, err := StateToken(fixtureKey, StatePayload{ClientID: "website", Provider: "discord"})
+	if err != nil {
+		t.Fatalf("StateToken error: %v", err)
+	}
+	got, err := NewStateService(fixtureKey, nil, nil).Validate(token)
+	if err != nil || got.Provider != "discord" {
+		t.Errorf("Validate = %+v, %v", got, err)
+	}
+}
+
+func TestSeqReader(t *testing.T) {
+	r := &SeqReader{}
+	p := make([]byte, 3)
+	r.Read(p)
+	r.Read(p)
+	if p[0] != 3 || p[2] != 5 {
+		t.Errorf("unexpected sequence: %v", p)
+	}
+}
@@ -0,0 +1,119 @@
+package testutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// RedisHandler answers a command sent to a server started with StartRedis.
+// The reply is written to the connection verbatim, so it must be RESP, such
+// as "+OK\r\n" or "$-1\r\n".
+type RedisHandler func(args []string) string
+
+// FakeRedis is a Redis server on a local port whose commands are all
+// answered by a RedisHandler, for testing code that speaks the Redis
+// protocol without a real Redis.
+type FakeRedis struct {
+	// Addr is the host:port the server listens on.
+	Addr string
+
+	h        RedisHandler
+	mu       sync.Mutex
+	commands [][]string
+	conns    int
+}
+
+// StartRedis starts a fake Redis server answered by h. It stops when the
+// test ends.
+func StartRedis(t *testing.T, h RedisHandler) *FakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("starting fake Redis: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	f := &FakeRedis{Addr: ln.Addr().String(), h: h}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns++
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+// Commands returns the commands received so far, in order.
+func (f *FakeRedis) Commands() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]string(nil), f.commands...)
+}
+
+// Conns returns how many connections have been opened.
+func (f *FakeRedis) Conns() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.conns
+}
+
+func (f *FakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.commands = append(f.commands, args)
+		f.mu.Unlock()
+		if _, err := io.WriteString(conn, f.h(args)); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads a command sent as a RESP array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	n, err := readHeader(r, '*')
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		size, err := readHeader(r, '$')
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readHeader(r *bufio.Reader, kind byte) (int, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	if len(line) < 3 || line[0] != kind {
+		return 0, fmt.Errorf("testutil: unexpected Redis input %q", line)
+	}
+	return strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+}
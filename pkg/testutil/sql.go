@@ -0,0 +1,109 @@
+package testutil
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// SQLHandler answers the statements sent to a database opened with OpenSQL.
+// It returns the columns and rows of a query's result; statements that
+// return no rows report len(rows) as the number of rows affected.
+type SQLHandler func(query string, args []driver.Value) (columns []string, rows [][]driver.Value, err error)
+
+var (
+	registerSQL sync.Once
+	sqlHandlers sync.Map // DSN -> SQLHandler
+	sqlSeq      atomic.Int64
+)
+
+// OpenSQL returns a database/sql handle whose statements are all answered by
+// h, for testing code that talks SQL without a real database.
+func OpenSQL(t *testing.T, h SQLHandler) *sql.DB {
+	t.Helper()
+	registerSQL.Do(func() { sql.Register("testutil", fakeDriver{}) })
+
+	dsn := fmt.Sprintf("db%d", sqlSeq.Add(1))
+	sqlHandlers.Store(dsn, h)
+	db, err := sql.Open("testutil", dsn)
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		sqlHandlers.Delete(dsn)
+	})
+	return db
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	h, ok := sqlHandlers.Load(dsn)
+	if !ok {
+		return nil, fmt.Errorf("unknown test database %q", dsn)
+	}
+	return fakeConn{h.(SQLHandler)}, nil
+}
+
+type fakeConn struct {
+	h SQLHandler
+}
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("testutil: prepared statements are not supported")
+}
+
+func (c fakeConn) Close() error { return nil }
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("testutil: transactions are not supported")
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	cols, rows, err := c.h(query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{cols: cols, rows: rows}, nil
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	_, rows, err := c.h(query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(rows)), nil
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	v := make([]driver.Value, len(args))
+	for i, a := range args {
+		v[i] = a.Value
+	}
+	return v
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -29,6 +29,22 @@ type UserInfo struct {
 	DisplayName string `json:"display_name"`
 	AvatarURL   string `json:"avatar_url"`
 	Email       string `json:"email,omitempty"`
+
+	// EmailVerified is true when the provider checked the user owns Email.
+	// Only link accounts by email when it is set.
+	EmailVerified bool `json:"email_verified,omitempty"`
+
+	// Locale (e.g. "en-GB") and Country (ISO 3166-1 alpha-2, e.g. "US")
+	// are set when the provider shares them.
+	Locale  string `json:"locale,omitempty"`
+	Country string `json:"country,omitempty"`
+
+	// ProviderData holds typed extras from the provider, if any.
+	ProviderData *ProviderData `json:"provider_data,omitempty"`
+
+	// Raw is the provider's own profile response, for clients with
+	// include_raw. Its shape differs per provider.
+	Raw json.RawMessage `json:"raw,omitempty"`
 }
 
 type exchangeResponse struct {
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestAuthorizeURL(t *testing.T) {
@@ -51,12 +52,13 @@ func TestExchange_Success(t *testing.T) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(exchangeResponse{
 			User: UserInfo{
-				Provider:    "discord",
-				ProviderID:  "12345",
-				Username:    "testuser",
-				DisplayName: "Test User",
-				AvatarURL:   "https://example.com/avatar.png",
-				Email:       "test@example.com",
+				Provider:      "discord",
+				ProviderID:    "12345",
+				Username:      "testuser",
+				DisplayName:   "Test User",
+				AvatarURL:     "https://example.com/avatar.png",
+				Email:         "test@example.com",
+				EmailVerified: true,
 			},
 		})
 	}))
@@ -82,6 +84,9 @@ func TestExchange_Success(t *testing.T) {
 	if user.Email != "test@example.com" {
 		t.Errorf("email = %q, want %q", user.Email, "test@example.com")
 	}
+	if !user.EmailVerified {
+		t.Error("expected email_verified to be true")
+	}
 }
 
 func TestExchange_Expired(t *testing.T) {
@@ -321,6 +326,27 @@ func TestCallbackHandler_MissingCode(t *testing.T) {
 	}
 }
 
+func TestCallbackHandler_SignInError(t *testing.T) {
+	client := New(Config{BaseURL: "http://localhost", ClientID: "app", APIKey: "key"})
+
+	var gotErr error
+	handler := CallbackHandler(client,
+		func(user *UserInfo, w http.ResponseWriter, r *http.Request) {
+			t.Fatal("onSuccess should not be called")
+		},
+		func(err error, w http.ResponseWriter, r *http.Request) {
+			gotErr = err
+		},
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/callback?error=access_denied&error_description=sign-in+was+denied+at+the+provider", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if gotErr == nil || gotErr.Error() != "centralauth: sign-in was denied at the provider" {
+		t.Errorf("err = %v", gotErr)
+	}
+}
+
 func TestCallbackHandler_ExchangeFailure(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -354,3 +380,57 @@ func TestCallbackHandler_ExchangeFailure(t *testing.T) {
 		t.Fatalf("expected UnauthorizedError, got %T: %v", gotErr, gotErr)
 	}
 }
+
+func TestExchange_ProviderData(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"user":{"provider":"steam","provider_id":"765","username":"p","display_name":"p","avatar_url":"",` +
+			`"provider_data":{"kind":"steam","bans":{"vac_banned":true,"vac_bans":1,"game_bans":0,"community_banned":false,` +
+			`"economy_ban":"none","days_since_last_ban":3},"owns_game":true,"playtime":60}}}`))
+	}))
+	defer srv.Close()
+
+	client := New(Config{BaseURL: srv.URL, ClientID: "app", APIKey: "test-key"})
+	user, err := client.Exchange(context.Background(), "test-code")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pd := user.ProviderData
+	if pd == nil || pd.Kind != KindSteam || pd.Steam == nil || pd.Discord != nil {
+		t.Fatalf("unexpected provider data: %+v", pd)
+	}
+	if !pd.Steam.Bans.VACBanned || !pd.Steam.OwnsGame || pd.Steam.Playtime != 60 {
+		t.Errorf("unexpected steam extras: %+v", pd.Steam)
+	}
+}
+
+func TestProviderData_UnknownKind(t *testing.T) {
+	var pd ProviderData
+	if err := json.Unmarshal([]byte(`{"kind":"twitch","follower":true}`), &pd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pd.Kind != "twitch" || pd.Discord != nil || pd.Steam != nil {
+		t.Errorf("unexpected provider data: %+v", pd)
+	}
+}
+
+func TestProviderData_Guest(t *testing.T) {
+	var pd ProviderData
+	if err := json.Unmarshal([]byte(`{"kind":"guest","expires_at":"2026-01-02T03:04:05Z"}`), &pd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pd.Guest == nil || !pd.Guest.ExpiresAt.Equal(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("unexpected provider data: %+v", pd)
+	}
+}
+
+func TestProviderData_DiscordGuilds(t *testing.T) {
+	var pd ProviderData
+	in := `{"kind":"discord","guild_roles":null,"mfa_enabled":false,"guilds":[{"id":"42","name":"BlackMission"}]}`
+	if err := json.Unmarshal([]byte(in), &pd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pd.Discord == nil || !pd.Discord.InGuild("42") || pd.Discord.InGuild("77") {
+		t.Errorf("unexpected provider data: %+v", pd.Discord)
+	}
+}
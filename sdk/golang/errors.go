@@ -32,7 +32,8 @@ func newUnauthorizedError(message string) *UnauthorizedError {
 	return &UnauthorizedError{Message: message, StatusCode: 401}
 }
 
-// ForbiddenError indicates the API key doesn't match the initiating client (HTTP 403).
+// ForbiddenError indicates the API key doesn't match the initiating client, or
+// the client is disabled (HTTP 403).
 type ForbiddenError struct {
 	Message    string
 	StatusCode int
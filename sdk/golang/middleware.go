@@ -4,13 +4,24 @@ import "net/http"
 
 // CallbackHandler returns an http.HandlerFunc that extracts the "code" query
 // parameter from the callback request, exchanges it for user info, and routes
-// to the appropriate callback.
+// to the appropriate callback. A sign-in that failed at CentralAuth, such as
+// one the user denied, arrives with an "error" parameter instead of a code
+// and goes to onError with its description.
 func CallbackHandler(
 	client *Client,
 	onSuccess func(user *UserInfo, w http.ResponseWriter, r *http.Request),
 	onError func(err error, w http.ResponseWriter, r *http.Request),
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if e := r.URL.Query().Get("error"); e != "" {
+			msg := r.URL.Query().Get("error_description")
+			if msg == "" {
+				msg = e
+			}
+			onError(&Error{Message: msg}, w, r)
+			return
+		}
+
 		code := r.URL.Query().Get("code")
 		if code == "" {
 			onError(&Error{Message: "missing code parameter", StatusCode: 400}, w, r)
@@ -0,0 +1,107 @@
+package centralauth
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Provider data kinds.
+const (
+	KindDiscord = "discord"
+	KindSteam   = "steam"
+	KindGuest   = "guest"
+)
+
+// ProviderData holds typed provider-specific extras. Kind says which of the
+// extras fields is set. New kinds can appear in later CentralAuth releases;
+// for a kind this SDK doesn't know, only Kind is set and the data should be
+// ignored. Existing extras types only ever gain fields.
+type ProviderData struct {
+	Kind    string
+	Discord *DiscordExtras
+	Steam   *SteamExtras
+	Guest   *GuestExtras
+}
+
+// DiscordExtras are the Discord-specific extras (kind "discord").
+type DiscordExtras struct {
+	// GuildRoles lists the user's role IDs in the server's configured guild.
+	// It is nil when no guild is configured or the user isn't a member.
+	GuildRoles []string `json:"guild_roles"`
+	Locale     string   `json:"locale,omitempty"`
+	MFAEnabled bool     `json:"mfa_enabled"`
+
+	// Guilds lists the user's guilds, limited to the server's guild filter.
+	// It is nil when the server doesn't request the guilds scope.
+	Guilds []DiscordGuild `json:"guilds"`
+}
+
+// DiscordGuild is a Discord server the user is a member of.
+type DiscordGuild struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// InGuild reports whether the user is a member of the guild with the given
+// ID. It is false when the guild list wasn't requested.
+func (e *DiscordExtras) InGuild(id string) bool {
+	for _, g := range e.Guilds {
+		if g.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// SteamExtras are the Steam-specific extras (kind "steam").
+type SteamExtras struct {
+	Bans     SteamBans `json:"bans"`
+	OwnsGame bool      `json:"owns_game"`
+	Playtime int       `json:"playtime"` // total minutes played
+
+	// InGame reports whether the user was playing the server's configured
+	// app when they signed in; GameServer is the ip:port they were on.
+	InGame     bool   `json:"in_game"`
+	GameServer string `json:"game_server,omitempty"`
+}
+
+// SteamBans summarizes a Steam account's ban record.
+type SteamBans struct {
+	VACBanned        bool   `json:"vac_banned"`
+	VACBans          int    `json:"vac_bans"`
+	GameBans         int    `json:"game_bans"`
+	CommunityBanned  bool   `json:"community_banned"`
+	EconomyBan       string `json:"economy_ban"`
+	DaysSinceLastBan int    `json:"days_since_last_ban"`
+}
+
+// GuestExtras are the guest provider's extras (kind "guest").
+type GuestExtras struct {
+	// ExpiresAt is when the guest identity should stop being honoured.
+	// CentralAuth doesn't enforce it; that is up to the application.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// UnmarshalJSON decodes the flat {"kind": ..., ...} wire format.
+func (d *ProviderData) UnmarshalJSON(data []byte) error {
+	var head struct {
+		Kind string `json:"kind"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return err
+	}
+
+	*d = ProviderData{Kind: head.Kind}
+	switch head.Kind {
+	case KindDiscord:
+		d.Discord = new(DiscordExtras)
+		return json.Unmarshal(data, d.Discord)
+	case KindSteam:
+		d.Steam = new(SteamExtras)
+		return json.Unmarshal(data, d.Steam)
+	case KindGuest:
+		d.Guest = new(GuestExtras)
+		return json.Unmarshal(data, d.Guest)
+	}
+	return nil
+}
@@ -1,5 +1,16 @@
 export { CentralAuthClient } from './client.js';
-export type { CentralAuthConfig, UserInfo, ExchangeResponse, HealthResponse } from './types.js';
+export type {
+  CentralAuthConfig,
+  UserInfo,
+  ExchangeResponse,
+  HealthResponse,
+  ProviderData,
+  DiscordExtras,
+  DiscordGuild,
+  SteamExtras,
+  SteamBans,
+  GuestExtras,
+} from './types.js';
 export {
   CentralAuthError,
   UnauthorizedError,
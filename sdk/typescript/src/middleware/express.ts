@@ -1,5 +1,6 @@
 import type { CentralAuthClient } from '../client.js';
 import type { UserInfo } from '../types.js';
+import { CentralAuthError } from '../errors.js';
 
 export interface CallbackHandlerOptions {
   onSuccess: (user: UserInfo, req: any, res: any) => void | Promise<void>;
@@ -22,6 +23,13 @@ export function createCallbackHandler(
   options: CallbackHandlerOptions
 ): (req: any, res: any) => Promise<void> {
   return async (req, res) => {
+    // A sign-in that failed at CentralAuth, such as one the user denied
+    const signInError = req.query?.error;
+    if (signInError) {
+      await options.onError(new CentralAuthError(String(req.query.error_description || signInError)), req, res);
+      return;
+    }
+
     const code = req.query?.code;
     if (!code) {
       await options.onError(new Error('Missing code parameter'), req, res);
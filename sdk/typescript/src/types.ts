@@ -16,6 +16,67 @@ export interface UserInfo {
   display_name: string;
   avatar_url: string;
   email?: string;
+  /** True when the provider checked the user owns `email`; only link accounts by a verified email */
+  email_verified?: boolean;
+  /** The user's language, e.g. "en-GB", when the provider shares it */
+  locale?: string;
+  /** ISO 3166-1 alpha-2 country code, e.g. "US", when the provider shares it */
+  country?: string;
+  /** Typed provider-specific extras; switch on `kind`. */
+  provider_data?: ProviderData;
+  /** The provider's own profile response, for clients with include_raw; shape differs per provider */
+  raw?: unknown;
+}
+
+/**
+ * Provider-specific extras, discriminated by `kind`.
+ *
+ * New kinds can appear in later CentralAuth releases, so ignore kinds you
+ * don't handle. Existing kinds only ever gain fields.
+ */
+export type ProviderData = DiscordExtras | SteamExtras | GuestExtras;
+
+export interface DiscordExtras {
+  kind: 'discord';
+  /** Role IDs in the configured guild; null when no guild is configured or the user isn't a member */
+  guild_roles: string[] | null;
+  locale?: string;
+  mfa_enabled: boolean;
+  /** The user's guilds, limited to the server's guild filter; null unless the server requests the guilds scope */
+  guilds: DiscordGuild[] | null;
+}
+
+export interface DiscordGuild {
+  id: string;
+  name: string;
+}
+
+export interface SteamExtras {
+  kind: 'steam';
+  bans: SteamBans;
+  /** Whether the user owns the configured app (false when their game details are private) */
+  owns_game: boolean;
+  /** Total minutes played in the configured app */
+  playtime: number;
+  /** Whether the user was playing the configured app when they signed in */
+  in_game: boolean;
+  /** ip:port of the game server they were on, if any */
+  game_server?: string;
+}
+
+export interface GuestExtras {
+  kind: 'guest';
+  /** RFC 3339 time after which the guest identity should no longer be honoured */
+  expires_at: string;
+}
+
+export interface SteamBans {
+  vac_banned: boolean;
+  vac_bans: number;
+  game_bans: number;
+  community_banned: boolean;
+  economy_ban: string;
+  days_since_last_ban: number;
 }
 
 export interface ExchangeResponse {
@@ -84,4 +84,19 @@ describe('Express Middleware', () => {
     expect(onError).toHaveBeenCalled();
     expect(onSuccess).not.toHaveBeenCalled();
   });
+
+  it('calls onError with the description of a failed sign-in', async () => {
+    const onSuccess = vi.fn();
+    const onError = vi.fn();
+
+    const handler = createCallbackHandler(client, { onSuccess, onError });
+
+    const req = { query: { error: 'access_denied', error_description: 'sign-in was denied at the provider' } };
+    const res = {};
+
+    await handler(req, res);
+
+    expect(onError.mock.calls[0][0].message).toBe('sign-in was denied at the provider');
+    expect(onSuccess).not.toHaveBeenCalled();
+  });
 });
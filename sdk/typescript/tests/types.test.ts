@@ -1,5 +1,5 @@
 import { describe, it, expect } from 'vitest';
-import type { UserInfo, CentralAuthConfig, ExchangeResponse } from '../src/types.js';
+import type { UserInfo, CentralAuthConfig, ExchangeResponse, ProviderData } from '../src/types.js';
 
 describe('Type validation', () => {
   it('UserInfo type structure is correct', () => {
@@ -64,4 +64,25 @@ describe('Type validation', () => {
 
     expect(response.user.provider).toBe('steam');
   });
+
+  it('ProviderData narrows on kind', () => {
+    const data: ProviderData = {
+      kind: 'steam',
+      bans: {
+        vac_banned: false,
+        vac_bans: 0,
+        game_bans: 0,
+        community_banned: false,
+        economy_ban: 'none',
+        days_since_last_ban: 0,
+      },
+      owns_game: true,
+      playtime: 120,
+      in_game: false,
+    };
+
+    const summarize = (pd: ProviderData): string =>
+      pd.kind === 'steam' ? `owns=${pd.owns_game}` : `roles=${pd.guild_roles?.length ?? 0}`;
+    expect(summarize(data)).toBe('owns=true');
+  });
 });